@@ -0,0 +1,181 @@
+package common
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"path/filepath"
+	"strings"
+)
+
+// assumedAudioBitrate is used to estimate the duration of the formats which can't be parsed, in bytes per second
+const assumedAudioBitrate = 128 * 1000 / 8
+
+// GetAudioDuration returns the duration in seconds of a WAV, MP3, FLAC, OGG or MP4 audio,
+// the other formats return an error and should be estimated with EstimateAudioDuration
+func GetAudioDuration(data []byte, filename string) (float64, error) {
+	switch {
+	case len(data) >= 12 && string(data[0:4]) == "RIFF" && string(data[8:12]) == "WAVE":
+		return getWavDuration(data)
+	case len(data) >= 4 && string(data[0:4]) == "fLaC":
+		return getFlacDuration(data)
+	case len(data) >= 4 && string(data[0:4]) == "OggS":
+		return getOggDuration(data)
+	case len(data) >= 8 && string(data[4:8]) == "ftyp":
+		return getMP4Duration(data)
+	case len(data) >= 3 && string(data[0:3]) == "ID3", len(data) >= 2 && data[0] == 0xFF && data[1]&0xE0 == 0xE0:
+		return getMP3Duration(data)
+	}
+	return 0, errors.New("unsupported audio format: " + strings.TrimPrefix(filepath.Ext(filename), "."))
+}
+
+// EstimateAudioDuration assumes a bitrate of 128 kbps
+func EstimateAudioDuration(size int) float64 {
+	return float64(size) / assumedAudioBitrate
+}
+
+func getWavDuration(data []byte) (float64, error) {
+	byteRate := uint32(0)
+	offset := 12
+	for offset+8 <= len(data) {
+		chunkId := string(data[offset : offset+4])
+		chunkSize := binary.LittleEndian.Uint32(data[offset+4 : offset+8])
+		body := offset + 8
+		switch chunkId {
+		case "fmt ":
+			if body+12 > len(data) {
+				return 0, errors.New("invalid wav fmt chunk")
+			}
+			byteRate = binary.LittleEndian.Uint32(data[body+8 : body+12])
+		case "data":
+			if byteRate == 0 {
+				return 0, errors.New("wav data chunk before fmt chunk")
+			}
+			// the size is left 0 or 0xFFFFFFFF by the recorders streaming to a file
+			if chunkSize == 0 || chunkSize == 0xFFFFFFFF || int(chunkSize) > len(data)-body {
+				chunkSize = uint32(len(data) - body)
+			}
+			return float64(chunkSize) / float64(byteRate), nil
+		}
+		offset = body + int(chunkSize) + int(chunkSize%2)
+	}
+	return 0, errors.New("wav data chunk not found")
+}
+
+func getFlacDuration(data []byte) (float64, error) {
+	// STREAMINFO is always the first metadata block
+	if len(data) < 8+18 {
+		return 0, errors.New("invalid flac header")
+	}
+	info := data[8:]
+	sampleRate := uint32(info[10])<<12 | uint32(info[11])<<4 | uint32(info[12])>>4
+	totalSamples := uint64(info[13]&0x0F)<<32 | uint64(binary.BigEndian.Uint32(info[14:18]))
+	if sampleRate == 0 || totalSamples == 0 {
+		return 0, errors.New("flac duration unknown")
+	}
+	return float64(totalSamples) / float64(sampleRate), nil
+}
+
+func getOggDuration(data []byte) (float64, error) {
+	sampleRate := uint32(0)
+	if index := bytes.Index(data, []byte("OpusHead")); index >= 0 {
+		// the granule position of Opus always counts 48 kHz samples
+		sampleRate = 48000
+	} else if index := bytes.Index(data, []byte("\x01vorbis")); index >= 0 && index+16 <= len(data) {
+		sampleRate = binary.LittleEndian.Uint32(data[index+12 : index+16])
+	}
+	if sampleRate == 0 {
+		return 0, errors.New("unsupported ogg codec")
+	}
+	last := bytes.LastIndex(data, []byte("OggS"))
+	if last < 0 || last+14 > len(data) {
+		return 0, errors.New("invalid ogg page")
+	}
+	granule := binary.LittleEndian.Uint64(data[last+6 : last+14])
+	return float64(granule) / float64(sampleRate), nil
+}
+
+func getMP4Duration(data []byte) (float64, error) {
+	index := bytes.Index(data, []byte("mvhd"))
+	if index < 4 || index+4+28 > len(data) {
+		return 0, errors.New("mp4 mvhd box not found")
+	}
+	box := data[index+4:]
+	var timescale uint32
+	var duration uint64
+	if box[0] == 1 {
+		if len(box) < 32 {
+			return 0, errors.New("invalid mp4 mvhd box")
+		}
+		timescale = binary.BigEndian.Uint32(box[20:24])
+		duration = binary.BigEndian.Uint64(box[24:32])
+	} else {
+		timescale = binary.BigEndian.Uint32(box[12:16])
+		duration = uint64(binary.BigEndian.Uint32(box[16:20]))
+	}
+	if timescale == 0 {
+		return 0, errors.New("invalid mp4 timescale")
+	}
+	return float64(duration) / float64(timescale), nil
+}
+
+var mp3Bitrates = [2][16]int{
+	{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}, // MPEG-1 Layer III
+	{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0},     // MPEG-2 and 2.5 Layer III
+}
+
+var mp3SampleRates = [4][3]int{
+	{11025, 12000, 8000},  // MPEG-2.5
+	{0, 0, 0},             // reserved
+	{22050, 24000, 16000}, // MPEG-2
+	{44100, 48000, 32000}, // MPEG-1
+}
+
+func getMP3Duration(data []byte) (float64, error) {
+	offset := 0
+	if len(data) >= 10 && string(data[0:3]) == "ID3" {
+		tagSize := int(data[6]&0x7F)<<21 | int(data[7]&0x7F)<<14 | int(data[8]&0x7F)<<7 | int(data[9]&0x7F)
+		offset = 10 + tagSize
+	}
+	for offset+4 <= len(data) && !(data[offset] == 0xFF && data[offset+1]&0xE0 == 0xE0) {
+		offset++
+	}
+	if offset+4 > len(data) {
+		return 0, errors.New("mp3 frame not found")
+	}
+	header := data[offset : offset+4]
+	version := int(header[1]>>3) & 0x03
+	bitrateIndex := int(header[2] >> 4)
+	sampleRateIndex := int(header[2]>>2) & 0x03
+	if version == 1 || sampleRateIndex == 3 {
+		return 0, errors.New("invalid mp3 frame header")
+	}
+	sampleRate := mp3SampleRates[version][sampleRateIndex]
+	samplesPerFrame := 1152
+	bitrates := mp3Bitrates[0]
+	if version != 3 {
+		samplesPerFrame = 576
+		bitrates = mp3Bitrates[1]
+	}
+	// the Xing, Info or VBRI header in the first frame of the VBR files tells the number of frames
+	frameEnd := offset + 64
+	if frameEnd > len(data) {
+		frameEnd = len(data)
+	}
+	frame := data[offset:frameEnd]
+	for _, tag := range []string{"Xing", "Info"} {
+		if index := bytes.Index(frame, []byte(tag)); index >= 0 && index+12 <= len(frame) && frame[index+7]&0x01 != 0 {
+			frames := binary.BigEndian.Uint32(frame[index+8 : index+12])
+			return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+		}
+	}
+	if index := bytes.Index(frame, []byte("VBRI")); index >= 0 && index+18 <= len(frame) {
+		frames := binary.BigEndian.Uint32(frame[index+14 : index+18])
+		return float64(frames) * float64(samplesPerFrame) / float64(sampleRate), nil
+	}
+	bitrate := bitrates[bitrateIndex]
+	if bitrate == 0 {
+		return 0, errors.New("free format mp3 is not supported")
+	}
+	return float64(len(data)-offset) * 8 / float64(bitrate*1000), nil
+}
@@ -0,0 +1,65 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// TranscriptionPrice is the price in USD of each minute of audio transcribed or translated
+var TranscriptionPrice = map[string]float64{
+	"whisper-1":              0.006,
+	"gpt-4o-transcribe":      0.006,
+	"gpt-4o-mini-transcribe": 0.003,
+}
+
+// SpeechPrice is the price in USD of each 1K characters of speech generated
+var SpeechPrice = map[string]float64{
+	"tts-1":           0.015,
+	"tts-1-hd":        0.03,
+	"gpt-4o-mini-tts": 0.015,
+}
+
+func TranscriptionPrice2JSONString() string {
+	jsonBytes, err := json.Marshal(TranscriptionPrice)
+	if err != nil {
+		SysError("error marshalling transcription price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateTranscriptionPriceByJSONString(jsonStr string) error {
+	TranscriptionPrice = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &TranscriptionPrice)
+}
+
+func SpeechPrice2JSONString() string {
+	jsonBytes, err := json.Marshal(SpeechPrice)
+	if err != nil {
+		SysError("error marshalling speech price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateSpeechPriceByJSONString(jsonStr string) error {
+	SpeechPrice = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &SpeechPrice)
+}
+
+// GetTranscriptionPrice falls back to the price of whisper-1 for the unknown models
+func GetTranscriptionPrice(name string) float64 {
+	price, ok := TranscriptionPrice[name]
+	if !ok {
+		SysError("transcription price not found: " + name)
+		return 0.006
+	}
+	return price
+}
+
+// GetSpeechPrice falls back to the price of tts-1 for the unknown models
+func GetSpeechPrice(name string) float64 {
+	price, ok := SpeechPrice[name]
+	if !ok {
+		SysError("speech price not found: " + name)
+		return 0.015
+	}
+	return price
+}
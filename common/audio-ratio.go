@@ -0,0 +1,61 @@
+package common
+
+import "encoding/json"
+
+// AudioRatio is the price of the audio prompt tokens relative to the text prompt tokens, and AudioCompletionRatio the
+// one of the audio completion tokens relative to the text completion tokens, the keys are model name prefixes and the
+// longest matching one wins
+var AudioRatio = map[string]float64{
+	"gpt-4o-realtime":      8,     // $40 / 1M tokens
+	"gpt-4o-mini-realtime": 16.67, // $10 / 1M tokens
+	"gpt-realtime":         8,     // $32 / 1M tokens
+}
+
+var AudioCompletionRatio = map[string]float64{
+	"gpt-4o-realtime":      4,    // $80 / 1M tokens
+	"gpt-4o-mini-realtime": 8.33, // $20 / 1M tokens
+	"gpt-realtime":         4,    // $64 / 1M tokens
+}
+
+func AudioRatio2JSONString() string {
+	jsonBytes, err := json.Marshal(AudioRatio)
+	if err != nil {
+		SysError("error marshalling audio ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateAudioRatioByJSONString(jsonStr string) error {
+	AudioRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &AudioRatio)
+}
+
+func AudioCompletionRatio2JSONString() string {
+	jsonBytes, err := json.Marshal(AudioCompletionRatio)
+	if err != nil {
+		SysError("error marshalling audio completion ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateAudioCompletionRatioByJSONString(jsonStr string) error {
+	AudioCompletionRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &AudioCompletionRatio)
+}
+
+// GetAudioRatio returns 1 for the unknown models, the audio tokens are billed as the text ones then
+func GetAudioRatio(name string) float64 {
+	ratio, ok := getPrefixRatio(AudioRatio, name)
+	if !ok {
+		return 1
+	}
+	return ratio
+}
+
+func GetAudioCompletionRatio(name string) float64 {
+	ratio, ok := getPrefixRatio(AudioCompletionRatio, name)
+	if !ok {
+		return 1
+	}
+	return ratio
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// CacheRatio is the price of the cached prompt tokens relative to the normal prompt tokens,
+// the keys are model name prefixes and the longest matching one wins
+var CacheRatio = map[string]float64{
+	"gpt-4o":      0.5,
+	"gpt-4o-mini": 0.5,
+	"gpt-4.1":     0.25,
+	"gpt-5":       0.1,
+	"o1":          0.5,
+	"o3":          0.25,
+	"o4-mini":     0.25,
+	"claude":      0.1,
+	"gemini-2.0":  0.25,
+	"gemini-2.5":  0.25,
+	"deepseek":    0.1,
+	"qwen":        0.4,
+	"moonshot":    0.25,
+	"kimi":        0.25,
+	"glm-4.5":     0.2,
+	"doubao":      0.2,
+	"grok":        0.25,
+	"mistral":     0.1,
+}
+
+// CacheCreationRatio is the price of the prompt tokens written into the cache, only Anthropic charges for it
+var CacheCreationRatio = map[string]float64{
+	"claude": 1.25,
+}
+
+func CacheRatio2JSONString() string {
+	jsonBytes, err := json.Marshal(CacheRatio)
+	if err != nil {
+		SysError("error marshalling cache ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheRatioByJSONString(jsonStr string) error {
+	CacheRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &CacheRatio)
+}
+
+func CacheCreationRatio2JSONString() string {
+	jsonBytes, err := json.Marshal(CacheCreationRatio)
+	if err != nil {
+		SysError("error marshalling cache creation ratio: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateCacheCreationRatioByJSONString(jsonStr string) error {
+	CacheCreationRatio = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &CacheCreationRatio)
+}
+
+func getPrefixRatio(ratios map[string]float64, name string) (float64, bool) {
+	matched := ""
+	for prefix := range ratios {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		return 0, false
+	}
+	return ratios[matched], true
+}
+
+// GetCacheRatio returns 1 for the unknown models, the cached tokens are billed at full price then
+func GetCacheRatio(name string) float64 {
+	ratio, ok := getPrefixRatio(CacheRatio, name)
+	if !ok {
+		return 1
+	}
+	return ratio
+}
+
+func GetCacheCreationRatio(name string) float64 {
+	ratio, ok := getPrefixRatio(CacheCreationRatio, name)
+	if !ok {
+		return 1
+	}
+	return ratio
+}
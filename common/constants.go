@@ -17,6 +17,28 @@ var Footer = ""
 var Logo = ""
 var TopUpLink = ""
 var ChatLink = ""
+var EpayAddress = ""
+var EpayId = ""
+var EpaySecret = ""
+var TopUpPrice = 7.3 // unit is CNY, the price of QuotaPerUnit quota
+var MinTopUp = 1
+var StatementCurrency = "USD"
+var StatementExchangeRate = 1.0 // how much StatementCurrency one USD is worth
+var WebhookURL = ""
+var WebhookSecret = ""
+var QuotaTransferEnabled = false
+var QuotaTransferMin = 0        // 0 means no limit
+var QuotaTransferMax = 0        // 0 means no limit
+var QuotaTransferDailyLimit = 0 // total quota a user can send per day, 0 means no limit
+var QuotaTransferFeeRate = 0.0  // charged to the sender on top of the transferred quota, 0.01 means 1%
+var DailyGrantQuota = 0         // free quota granted to every active user each day, 0 means disabled
+var DailyGrantGroup = ""        // only grant to this group, empty means all groups
+var DailyGrantActiveDays = 0    // only grant to users whose tokens were used in the last N days, 0 means all enabled users
+var DailyGrantAccumulationEnabled = false
+var ModelDowngradeSuggestionEnabled = false
+var PromoCreditExpireDays = 0 // the quota given on registration and invitation expires after N days, 0 means never
+var RelayRateLimitNum = 0     // relay requests per minute of each token, 0 means no limit
+var ErrorPassthroughEnabled = true
 var QuotaPerUnit = 500 * 1000.0 // $0.002 / 1K tokens
 var DisplayInCurrencyEnabled = true
 var DisplayTokenStatEnabled = true
@@ -56,6 +78,9 @@ var EmailDomainWhitelist = []string{
 }
 
 var DebugEnabled = os.Getenv("DEBUG") == "true"
+var SQLAuditEnabled = os.Getenv("SQL_AUDIT") == "true"
+var SQLSlowThreshold = GetOrDefault("SQL_SLOW_THRESHOLD", 200)                // unit is millisecond
+var SQLAuditReportFrequency = GetOrDefault("SQL_AUDIT_REPORT_FREQUENCY", 600) // unit is second
 
 var LogConsumeEnabled = true
 
@@ -80,10 +105,36 @@ var QuotaForInviter = 0
 var QuotaForInvitee = 0
 var ChannelDisableThreshold = 5.0
 var AutomaticDisableChannelEnabled = false
+var AutomaticEnableChannelEnabled = false
+var ChannelProbeFailureThreshold = 3  // consecutive failed health checks which disable a channel
+var ChannelProbeRecoveryThreshold = 2 // consecutive successful health checks which enable an automatically disabled channel again
 var QuotaRemindThreshold = 1000
 var PreConsumedQuota = 500
 var ApproximateTokenEnabled = false
-var RetryTimes = 0
+var RetryTimes = 0                                                  // how many other channels a failed request is retried with
+var CircuitBreakerFailureThreshold = 5                              // failures of a channel in a row which open its circuit breaker, 0 disables the breakers
+var CircuitBreakerOpenTime = 30                                     // seconds an open circuit breaker refuses the requests before a probe is let through
+var ChannelKeyCooldownTime = 60                                     // seconds a key of a channel is skipped after the upstream rate limited it
+var ChannelRateLimitWaitTime = 5                                    // seconds a request waits for a channel while all of them are at their rate limits
+var ChannelQueueDepth = 1000                                        // requests a node queues at most while the channels of their models are at their rate limits
+var StickyRoutingEnabled = false                                    // the requests of a conversation go to the same channel when possible
+var FreeModerationEnabled = false                                   // the moderation requests are not billed
+var FileSizeLimit = 512                                             // MB a file uploaded to /v1/files may have at most, 0 for no limit
+var FileStorageQuota = 10240                                        // MB of the files a user may store, 0 for no limit
+var BatchRatio = 0.5                                                // the requests of the batches are billed at this ratio of the price
+var BatchUpstreamEnabled = false                                    // the batches are sent to the batch APIs of the OpenAI channels
+var EmbeddingChunkSize = 0                                          // the most inputs of each upstream request an embeddings request is split into, 0 for the limits of the upstreams
+var StructuredOutputValidationEnabled = false                       // the JSON outputs of the chat requests are checked against their response formats
+var StructuredOutputRetryTimes = 1                                  // how many more times a chat request is sent when its JSON output fails the check
+var StreamUsageRequestEnabled = true                                // the OpenAI channels are asked for the usage of the streams
+var StreamUsageVerificationRate = 1                                 // percentage of streams with upstream usage which are also counted locally
+var LogSampleRate = 100                                             // percentage of successful requests with a consume log
+var ResponseCacheTTL = 3600                                         // seconds the cached responses are served for the identical requests, 0 disables the cache
+var ResponseCacheRatio = 0.1                                        // the cache hits are billed at this ratio of the price
+var SemanticCacheModel = ""                                         // the embedding model of the semantic cache, empty disables it
+var SemanticCacheThreshold = 0.95                                   // the cosine similarity of the prompts a semantic cache hit needs at least
+var RaceModels []string                                             // the requests of these models are sent to two channels at once and the first to respond wins
+var ReservationTimeout = GetOrDefault("RESERVATION_TIMEOUT", 30*60) // unit is second
 
 var RootUserEmail = ""
 
@@ -94,6 +145,27 @@ var RequestInterval = time.Duration(requestInterval) * time.Second
 
 var SyncFrequency = 10 * 60 // unit is second, will be overwritten by SYNC_FREQUENCY
 
+// KubernetesConfigDirs are the mount points of ConfigMaps / Secrets, separated by comma
+var KubernetesConfigDirs = os.Getenv("KUBERNETES_CONFIG_DIRS")
+var KubernetesConfigSyncFrequency = GetOrDefault("KUBERNETES_CONFIG_SYNC_FREQUENCY", 10) // unit is second
+
+// WarmupTokenCount is how many recently used tokens are loaded into Redis on startup, 0 disables it
+var WarmupTokenCount = GetOrDefault("WARMUP_TOKEN_COUNT", 100)
+var WarmupProbeChannels = os.Getenv("WARMUP_PROBE_CHANNELS") == "true"
+var WarmupTimeout = GetOrDefault("WARMUP_TIMEOUT", 30) // unit is second, the instance reports ready after it even if the warmup isn't done
+
+// the validated tokens are kept in memory for a short time, so that the bursts from edge functions don't reach Redis or the database,
+// the invalid keys are remembered as well, 0 disables the cache
+var TokenLocalCacheTTL = GetOrDefault("TOKEN_LOCAL_CACHE_TTL", 500)                 // unit is millisecond
+var InvalidTokenLocalCacheTTL = GetOrDefault("INVALID_TOKEN_LOCAL_CACHE_TTL", 1000) // unit is millisecond
+var TokenLocalCacheSize = GetOrDefault("TOKEN_LOCAL_CACHE_SIZE", 10000)
+
+// ResponseCacheSize is the responses cached in memory when Redis is not enabled
+var ResponseCacheSize = GetOrDefault("RESPONSE_CACHE_SIZE", 1000)
+
+// TokenizerOfflineEnabled never downloads the encoding files, the ones embedded in the binary are used for air-gapped deployments
+var TokenizerOfflineEnabled = os.Getenv("TIKTOKEN_OFFLINE") == "true"
+
 const (
 	RoleGuestUser  = 0
 	RoleCommonUser = 1
@@ -134,6 +206,17 @@ const (
 	UserStatusDisabled = 2 // also don't use 0
 )
 
+const (
+	UserBillingModePrepaid  = 1 // don't use 0, 0 is the default value!
+	UserBillingModePostpaid = 2 // quota can go negative down to -CreditLimit, the balance is invoiced later
+)
+
+const (
+	PriorityLow    = 1 // don't use 0, 0 is the default value! shed first when the channels are congested
+	PriorityNormal = 2
+	PriorityHigh   = 3 // released first from the queue of the congested channels
+)
+
 const (
 	TokenStatusEnabled   = 1 // don't use 0, 0 is the default value!
 	TokenStatusDisabled  = 2 // also don't use 0
@@ -148,9 +231,60 @@ const (
 )
 
 const (
-	ChannelStatusUnknown  = 0
-	ChannelStatusEnabled  = 1 // don't use 0, 0 is the default value!
-	ChannelStatusDisabled = 2 // also don't use 0
+	SpendingAlertStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	SpendingAlertStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	CouponStatusEnabled   = 1 // don't use 0, 0 is the default value!
+	CouponStatusDisabled  = 2 // also don't use 0
+	CouponStatusExhausted = 3 // the coupon of a user has reached its usage caps
+)
+
+const (
+	ReservationStatusReserved = 1 // don't use 0, 0 is the default value!
+	ReservationStatusSettled  = 2
+	ReservationStatusReleased = 3
+)
+
+const (
+	TopUpOrderStatusPending = 1 // don't use 0, 0 is the default value!
+	TopUpOrderStatusSuccess = 2
+)
+
+const (
+	WebhookDeliveryStatusPending = 1 // don't use 0, 0 is the default value!
+	WebhookDeliveryStatusSuccess = 2
+	WebhookDeliveryStatusFailed  = 3
+)
+
+const (
+	ExperimentStatusRunning = 1 // don't use 0, 0 is the default value!
+	ExperimentStatusStopped = 2
+)
+
+const (
+	FeatureFlagStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	FeatureFlagStatusDisabled = 2 // the feature is off for everyone
+)
+
+const (
+	PolicyStatusEnabled  = 1 // don't use 0, 0 is the default value!
+	PolicyStatusDisabled = 2 // also don't use 0
+)
+
+const (
+	CreditBucketStatusActive  = 1 // don't use 0, 0 is the default value!
+	CreditBucketStatusExpired = 2
+)
+
+const (
+	ChannelStatusUnknown      = 0
+	ChannelStatusEnabled      = 1 // don't use 0, 0 is the default value!
+	ChannelStatusDisabled     = 2 // also don't use 0
+	ChannelStatusAutoDisabled = 3 // disabled by One API itself, the health checks may enable it again
+	ChannelStatusOverBudget   = 4 // paused until the budget of the channel renews
+	ChannelStatusMaintenance  = 5 // paused during a maintenance window of the channel
 )
 
 const (
@@ -174,6 +308,9 @@ const (
 	ChannelTypeAli       = 17
 	ChannelTypeXunfei    = 18
 	ChannelTypeMiniMax   = 19
+	ChannelTypeGemini    = 20
+	ChannelTypeBedrock   = 21
+	ChannelTypeLocal     = 22
 )
 
 var ChannelBaseURLs = []string{
@@ -197,4 +334,7 @@ var ChannelBaseURLs = []string{
 	"https://dashscope.aliyuncs.com", // 17
 	"",                               // 18
 	"",                               // 19
+	"https://generativelanguage.googleapis.com", // 20
+	"",                       // 21
+	"http://localhost:11434", // 22
 }
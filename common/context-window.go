@@ -0,0 +1,59 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ModelContextWindow is the tokens of the prompt and the completion a model takes at most, the keys are model name
+// prefixes and the longest matching one wins
+var ModelContextWindow = map[string]int{
+	"gpt-3.5-turbo":      16385,
+	"gpt-3.5-turbo-0613": 4096,
+	"gpt-4":              8192,
+	"gpt-4-32k":          32768,
+	"gpt-4-turbo":        128000,
+	"gpt-4-1106":         128000,
+	"gpt-4-0125":         128000,
+	"gpt-4o":             128000,
+	"gpt-4.1":            1047576,
+	"gpt-5":              400000,
+	"o1":                 200000,
+	"o3":                 200000,
+	"o4-mini":            200000,
+	"claude-":            200000,
+	"gemini-1.5":         1048576,
+	"gemini-2":           1048576,
+	"ERNIE-Bot":          4800,
+	"chatglm":            8192,
+	"qwen":               8192,
+	"abab":               8192,
+	"SparkDesk":          8192,
+}
+
+func ModelContextWindow2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelContextWindow)
+	if err != nil {
+		SysError("error marshalling model context window: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelContextWindowByJSONString(jsonStr string) error {
+	ModelContextWindow = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &ModelContextWindow)
+}
+
+// GetModelContextWindow returns 0 for the unknown models, their requests are not truncated
+func GetModelContextWindow(name string) int {
+	matched := ""
+	for prefix := range ModelContextWindow {
+		if strings.HasPrefix(name, prefix) && len(prefix) > len(matched) {
+			matched = prefix
+		}
+	}
+	if matched == "" {
+		return 0
+	}
+	return ModelContextWindow[matched]
+}
@@ -0,0 +1,39 @@
+package common
+
+const (
+	CurrencyUSD    = "USD"
+	CurrencyCNY    = "CNY"
+	CurrencyTokens = "TOKENS" // the raw quota
+)
+
+// how much CNY one USD is worth unless the statements are in CNY
+const defaultCNYExchangeRate = 7.3
+
+// GetQuotaDisplayType is StatementCurrency, or CurrencyTokens if the quota isn't displayed in currency
+func GetQuotaDisplayType() string {
+	if !DisplayInCurrencyEnabled {
+		return CurrencyTokens
+	}
+	return StatementCurrency
+}
+
+// QuotaToCurrencyAmount converts the quota to StatementCurrency
+func QuotaToCurrencyAmount(quota float64) float64 {
+	return quota / QuotaPerUnit * StatementExchangeRate
+}
+
+// QuotaToDisplayAmount converts the quota to the amount shown in the dashboard, the logs and the emails
+func QuotaToDisplayAmount(quota float64) float64 {
+	if !DisplayInCurrencyEnabled {
+		return quota
+	}
+	return QuotaToCurrencyAmount(quota)
+}
+
+// GetCNYExchangeRate is how much CNY one USD is worth, for the balances of the upstreams which bill in CNY
+func GetCNYExchangeRate() float64 {
+	if StatementCurrency == CurrencyCNY && StatementExchangeRate > 0 {
+		return StatementExchangeRate
+	}
+	return defaultCNYExchangeRate
+}
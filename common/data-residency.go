@@ -0,0 +1,52 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// GroupDataResidency restricts the requests of a group to the channels labelled with one of the regions,
+// such as {"eu-customers":"eu"}, several regions are separated by commas
+var GroupDataResidency = map[string]string{}
+
+func GroupDataResidency2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupDataResidency)
+	if err != nil {
+		SysError("error marshalling group data residency: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupDataResidencyByJSONString(jsonStr string) error {
+	GroupDataResidency = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &GroupDataResidency)
+}
+
+func GetGroupDataResidency(group string) string {
+	return GroupDataResidency[group]
+}
+
+// IsRegionAllowed tells whether a channel of the region satisfies all the constraints, each constraint is a comma separated
+// list of regions and an empty one puts no restriction, the channels without a region never satisfy a constraint
+func IsRegionAllowed(region string, constraints ...string) bool {
+	region = strings.TrimSpace(region)
+	for _, constraint := range constraints {
+		if constraint == "" {
+			continue
+		}
+		if region == "" {
+			return false
+		}
+		allowed := false
+		for _, candidate := range strings.Split(constraint, ",") {
+			if strings.EqualFold(strings.TrimSpace(candidate), region) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
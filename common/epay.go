@@ -0,0 +1,43 @@
+package common
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// EpaySign implements the MD5 signature used by epay compatible aggregation gateways:
+// sort the non-empty params by key, join them as k=v&k=v, then append the merchant secret.
+func EpaySign(params map[string]string, secret string) string {
+	keys := make([]string, 0, len(params))
+	for k, v := range params {
+		if k == "sign" || k == "sign_type" || v == "" {
+			continue
+		}
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		pairs = append(pairs, k+"="+params[k])
+	}
+	sum := md5.Sum([]byte(strings.Join(pairs, "&") + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func EpayVerify(params map[string]string, secret string) bool {
+	return params["sign"] != "" && strings.EqualFold(params["sign"], EpaySign(params, secret))
+}
+
+// EpayPayURL returns the address the user should be redirected to for paying.
+func EpayPayURL(params map[string]string) string {
+	params["sign"] = EpaySign(params, EpaySecret)
+	params["sign_type"] = "MD5"
+	values := url.Values{}
+	for k, v := range params {
+		values.Set(k, v)
+	}
+	return strings.TrimSuffix(EpayAddress, "/") + "/submit.php?" + values.Encode()
+}
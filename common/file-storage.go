@@ -0,0 +1,54 @@
+package common
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	FileStorageLocal = "local"
+	FileStorageS3    = "s3"
+)
+
+// the uploaded files are kept in the S3 compatible storage when it is set, else in this directory
+var FileStorageDir = os.Getenv("FILE_STORAGE_DIR")
+
+func init() {
+	if FileStorageDir == "" {
+		FileStorageDir = "files"
+	}
+}
+
+// SaveFile stores the file and returns the storage which keeps it, the later calls use it so that the files stay
+// reachable after the storage is changed
+func SaveFile(key string, data []byte, contentType string) (string, error) {
+	if S3Enabled() {
+		return FileStorageS3, S3PutObject(key, data, contentType)
+	}
+	path := filepath.Join(FileStorageDir, key)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err != nil {
+		return "", err
+	}
+	return FileStorageLocal, os.WriteFile(path, data, 0644)
+}
+
+// LoadFile returns the file body, the caller should close it
+func LoadFile(storage string, key string) (io.ReadCloser, error) {
+	if storage == FileStorageS3 {
+		return S3GetObject(key)
+	}
+	return os.Open(filepath.Join(FileStorageDir, key))
+}
+
+func RemoveFile(storage string, key string) error {
+	if storage == FileStorageS3 {
+		return S3DeleteObject(key)
+	}
+	err := os.Remove(filepath.Join(FileStorageDir, key))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
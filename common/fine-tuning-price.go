@@ -0,0 +1,48 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// FineTuningPrice is the price in USD of each 1M tokens trained by the fine-tuning jobs of the base models
+var FineTuningPrice = map[string]float64{
+	"gpt-4o":                  25,
+	"gpt-4o-mini":             3,
+	"gpt-4.1":                 25,
+	"gpt-4.1-mini":            5,
+	"gpt-4.1-nano":            1.5,
+	"gpt-3.5-turbo":           8,
+	"gpt-4o-2024-08-06":       25,
+	"gpt-4o-mini-2024-07-18":  3,
+	"gpt-4.1-2025-04-14":      25,
+	"gpt-4.1-mini-2025-04-14": 5,
+	"gpt-4.1-nano-2025-04-14": 1.5,
+	"gpt-3.5-turbo-0125":      8,
+	"gpt-3.5-turbo-1106":      8,
+	"gpt-3.5-turbo-0613":      8,
+	"davinci-002":             6,
+	"babbage-002":             0.4,
+}
+
+func FineTuningPrice2JSONString() string {
+	jsonBytes, err := json.Marshal(FineTuningPrice)
+	if err != nil {
+		SysError("error marshalling fine-tuning price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateFineTuningPriceByJSONString(jsonStr string) error {
+	FineTuningPrice = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &FineTuningPrice)
+}
+
+// GetFineTuningPrice falls back to the price of gpt-4o-2024-08-06 for the unknown models, the highest of the chat models
+func GetFineTuningPrice(name string) float64 {
+	price, ok := FineTuningPrice[name]
+	if !ok {
+		SysError("fine-tuning price not found: " + name)
+		return 25
+	}
+	return price
+}
@@ -5,8 +5,11 @@ import (
 	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"io"
+	"mime/multipart"
 )
 
+const maxMultipartMemory = 32 << 20
+
 func UnmarshalBodyReusable(c *gin.Context, v any) error {
 	requestBody, err := io.ReadAll(c.Request.Body)
 	if err != nil {
@@ -24,3 +27,27 @@ func UnmarshalBodyReusable(c *gin.Context, v any) error {
 	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
 	return nil
 }
+
+// ParseMultipartFormReusable parses the multipart form and keeps the body to be sent to the upstream,
+// calling it again returns the form parsed already
+func ParseMultipartFormReusable(c *gin.Context) (*multipart.Form, error) {
+	if c.Request.MultipartForm != nil {
+		return c.Request.MultipartForm, nil
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil, err
+	}
+	err = c.Request.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	err = c.Request.ParseMultipartForm(maxMultipartMemory)
+	// Reset request body
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	return c.Request.MultipartForm, nil
+}
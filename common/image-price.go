@@ -0,0 +1,96 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ImagePrice is the price in USD of each generated image, keyed by model and then by size,
+// the "size|quality" keys take precedence over the plain size ones
+var ImagePrice = map[string]map[string]float64{
+	"dall-e": {
+		"256x256":   0.016,
+		"512x512":   0.018,
+		"1024x1024": 0.02,
+	},
+	"dall-e-2": {
+		"256x256":   0.016,
+		"512x512":   0.018,
+		"1024x1024": 0.02,
+	},
+	"dall-e-3": {
+		"1024x1024":    0.04,
+		"1024x1792":    0.08,
+		"1792x1024":    0.08,
+		"1024x1024|hd": 0.08,
+		"1024x1792|hd": 0.12,
+		"1792x1024|hd": 0.12,
+	},
+	"gpt-image-1": {
+		"1024x1024":        0.042,
+		"1024x1536":        0.063,
+		"1536x1024":        0.063,
+		"1024x1024|low":    0.011,
+		"1024x1536|low":    0.016,
+		"1536x1024|low":    0.016,
+		"1024x1024|medium": 0.042,
+		"1024x1536|medium": 0.063,
+		"1536x1024|medium": 0.063,
+		"1024x1024|high":   0.167,
+		"1024x1536|high":   0.25,
+		"1536x1024|high":   0.25,
+		// the size chosen by the model, priced as the largest one
+		"auto":        0.063,
+		"auto|low":    0.016,
+		"auto|medium": 0.063,
+		"auto|high":   0.25,
+	},
+}
+
+func ImagePrice2JSONString() string {
+	jsonBytes, err := json.Marshal(ImagePrice)
+	if err != nil {
+		SysError("error marshalling image price: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateImagePriceByJSONString(jsonStr string) error {
+	ImagePrice = make(map[string]map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &ImagePrice)
+}
+
+// IsImageModelPriced tells whether the model has its own prices, the sizes of such a model are limited to the priced ones
+func IsImageModelPriced(name string) bool {
+	_, ok := ImagePrice[name]
+	return ok
+}
+
+// IsImageQualitySupported tells whether the priced model takes the quality, which are the ones of its "size|quality"
+// prices along with standard and auto, the default ones
+func IsImageQualitySupported(name string, quality string) bool {
+	if quality == "" || quality == "standard" || quality == "auto" {
+		return true
+	}
+	for key := range ImagePrice[name] {
+		if strings.HasSuffix(key, "|"+quality) {
+			return true
+		}
+	}
+	return false
+}
+
+// GetImagePrice returns the price of one image, false is returned if the size is not priced for the model
+func GetImagePrice(name string, size string, quality string) (float64, bool) {
+	prices, ok := ImagePrice[name]
+	if !ok {
+		return 0, false
+	}
+	if quality != "" {
+		if price, ok := prices[size+"|"+quality]; ok {
+			return price, true
+		}
+	}
+	price, ok := prices[size]
+	return price, ok
+}
@@ -6,6 +6,7 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 var (
@@ -23,7 +24,10 @@ func printHelp() {
 }
 
 func init() {
-	flag.Parse()
+	// the test binaries parse their own flags
+	if !strings.HasSuffix(strings.TrimSuffix(filepath.Base(os.Args[0]), ".exe"), ".test") {
+		flag.Parse()
+	}
 
 	if *PrintVersion {
 		fmt.Println(Version)
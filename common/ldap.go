@@ -0,0 +1,79 @@
+package common
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"net"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-ldap/ldap/v3"
+)
+
+var LDAPAuthEnabled = false
+var LDAPServerURL = ""                    // ldap://host:389 or ldaps://host:636
+var LDAPBindDN = ""                       // the service account which looks the users up, empty for an anonymous search
+var LDAPBindPassword = ""                 // the option name ends with Password, it is never returned by GetOptions
+var LDAPBaseDN = ""                       // the subtree the users are searched in
+var LDAPUsernameAttribute = "uid"         // sAMAccountName for Active Directory
+var LDAPGroupAttribute = "memberOf"       // the attribute of the user listing the DNs of its groups
+var LDAPAutoProvisionEnabled = false      // the directory users without an account get one on their first login
+var LDAPStartTLSEnabled = false           // the ldap:// connections are upgraded to TLS with StartTLS before binding
+var LDAPInsecureTLSEnabled = false        // the certificate of the server is not verified, for the self-signed ones
+var LDAPGroupRoles = make(map[string]int) // the role of the members of a group, keyed by the DN of the group, the highest role wins
+
+const ldapTimeout = 10 * time.Second
+
+func LDAPGroupRoles2JSONString() string {
+	jsonBytes, err := json.Marshal(LDAPGroupRoles)
+	if err != nil {
+		SysError("error marshalling LDAP group roles: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateLDAPGroupRolesByJSONString(jsonStr string) error {
+	LDAPGroupRoles = make(map[string]int)
+	return json.Unmarshal([]byte(jsonStr), &LDAPGroupRoles)
+}
+
+// GetLDAPGroupsRole returns the highest role the groups are mapped to, 0 if none of them is mapped, the DNs are
+// compared case-insensitively
+func GetLDAPGroupsRole(groups []string) int {
+	role := 0
+	for groupDN, groupRole := range LDAPGroupRoles {
+		for _, group := range groups {
+			if strings.EqualFold(strings.TrimSpace(group), strings.TrimSpace(groupDN)) && groupRole > role {
+				role = groupRole
+			}
+		}
+	}
+	return role
+}
+
+// DialLDAP connects to the ldap:// or ldaps:// URL of LDAPServerURL, the ldap:// connections are upgraded with
+// StartTLS if LDAPStartTLSEnabled
+func DialLDAP() (*ldap.Conn, error) {
+	parsedURL, err := url.Parse(LDAPServerURL)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		ServerName:         parsedURL.Hostname(),
+		InsecureSkipVerify: LDAPInsecureTLSEnabled,
+	}
+	conn, err := ldap.DialURL(LDAPServerURL, ldap.DialWithDialer(&net.Dialer{Timeout: ldapTimeout}), ldap.DialWithTLSConfig(tlsConfig))
+	if err != nil {
+		return nil, err
+	}
+	conn.SetTimeout(ldapTimeout)
+	if LDAPStartTLSEnabled && parsedURL.Scheme == "ldap" {
+		err = conn.StartTLS(tlsConfig)
+		if err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
@@ -44,9 +44,14 @@ func FatalLog(v ...any) {
 }
 
 func LogQuota(quota int) string {
-	if DisplayInCurrencyEnabled {
-		return fmt.Sprintf("＄%.6f 额度", float64(quota)/QuotaPerUnit)
-	} else {
+	switch currency := GetQuotaDisplayType(); currency {
+	case CurrencyTokens:
 		return fmt.Sprintf("%d 点额度", quota)
+	case CurrencyUSD:
+		return fmt.Sprintf("＄%.6f 额度", QuotaToDisplayAmount(float64(quota)))
+	case CurrencyCNY:
+		return fmt.Sprintf("￥%.6f 额度", QuotaToDisplayAmount(float64(quota)))
+	default:
+		return fmt.Sprintf("%.6f %s 额度", QuotaToDisplayAmount(float64(quota)), currency)
 	}
 }
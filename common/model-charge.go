@@ -0,0 +1,52 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// ModelMinCharge is the minimum price in USD of each request of a model, the key "*" applies to the models not listed
+var ModelMinCharge = map[string]float64{}
+
+// ModelSurcharge is the fixed price in USD added to each request of a model, the key "*" applies to the models not listed
+var ModelSurcharge = map[string]float64{}
+
+func ModelMinCharge2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelMinCharge)
+	if err != nil {
+		SysError("error marshalling model min charge: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelMinChargeByJSONString(jsonStr string) error {
+	ModelMinCharge = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &ModelMinCharge)
+}
+
+func ModelSurcharge2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelSurcharge)
+	if err != nil {
+		SysError("error marshalling model surcharge: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelSurchargeByJSONString(jsonStr string) error {
+	ModelSurcharge = make(map[string]float64)
+	return json.Unmarshal([]byte(jsonStr), &ModelSurcharge)
+}
+
+func getModelCharge(charges map[string]float64, name string) float64 {
+	if charge, ok := charges[name]; ok {
+		return charge
+	}
+	return charges["*"]
+}
+
+func GetModelMinCharge(name string) float64 {
+	return getModelCharge(ModelMinCharge, name)
+}
+
+func GetModelSurcharge(name string) float64 {
+	return getModelCharge(ModelSurcharge, name)
+}
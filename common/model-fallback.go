@@ -0,0 +1,20 @@
+package common
+
+import "encoding/json"
+
+// ModelFallbacks are the models tried in order when none of the channels of a model can serve it, such as
+// {"gpt-4o": ["claude-3-5-sonnet", "gpt-4o-mini"]}
+var ModelFallbacks = map[string][]string{}
+
+func ModelFallbacks2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelFallbacks)
+	if err != nil {
+		SysError("error marshalling model fallbacks: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelFallbacksByJSONString(jsonStr string) error {
+	ModelFallbacks = make(map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &ModelFallbacks)
+}
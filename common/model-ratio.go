@@ -53,6 +53,35 @@ var ModelRatio = map[string]float64{
 	"abab5.5-chat":            1.0715, // ￥0.014 / 1k tokens
 	"abab5-chat":              1.0715, // ￥0.014 / 1k tokens
 	"embo-01":                 0.75,   // TBD: https://api.minimax.chat/document/price?id=6433f32294878d408fc8293e
+
+	// the Messages API models of Anthropic
+	"claude-3-haiku-20240307":    0.125, // $0.25 / 1M tokens
+	"claude-3-5-haiku-20241022":  0.4,   // $0.8 / 1M tokens
+	"claude-3-sonnet-20240229":   1.5,   // $3 / 1M tokens
+	"claude-3-5-sonnet-20241022": 1.5,
+	"claude-3-7-sonnet-20250219": 1.5,
+	"claude-sonnet-4-20250514":   1.5,
+	"claude-3-opus-20240229":     7.5, // $15 / 1M tokens
+	"claude-opus-4-20250514":     7.5,
+
+	// the Gemini models of Google
+	"gemini-1.5-flash": 0.0375, // $0.075 / 1M tokens
+	"gemini-1.5-pro":   0.625,  // $1.25 / 1M tokens
+	"gemini-2.0-flash": 0.05,   // $0.1 / 1M tokens
+	"gemini-2.5-flash": 0.15,   // $0.3 / 1M tokens
+	"gemini-2.5-pro":   0.625,  // $1.25 / 1M tokens
+
+	// the realtime models of OpenAI, the audio tokens are weighted by AudioRatio and AudioCompletionRatio
+	"gpt-4o-realtime-preview":      2.5, // $5 / 1M tokens
+	"gpt-4o-mini-realtime-preview": 0.3, // $0.6 / 1M tokens
+	"gpt-realtime":                 2,   // $4 / 1M tokens
+
+	// the Llama models of Meta on Bedrock
+	"llama3-8b-instruct":     0.15,  // $0.3 / 1M tokens
+	"llama3-70b-instruct":    1.325, // $2.65 / 1M tokens
+	"llama3.1-8b-instruct":   0.11,  // $0.22 / 1M tokens
+	"llama3.1-70b-instruct":  0.36,  // $0.72 / 1M tokens
+	"llama3.1-405b-instruct": 1.2,   // $2.4 / 1M tokens
 }
 
 func ModelRatio2JSONString() string {
@@ -0,0 +1,57 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// ModelSyncTemplate prices a model found on the model list of an upstream which has no model ratio yet
+type ModelSyncTemplate struct {
+	ModelRatio float64 `json:"model_ratio"`
+	OwnedBy    string  `json:"owned_by"` // shown by /v1/models, the first part of the model name if empty
+}
+
+// ModelSyncTemplates are keyed by model name prefixes and the longest matching one wins, the models
+// matching none are only reported to the root user
+var ModelSyncTemplates = map[string]*ModelSyncTemplate{}
+
+// SyncedModels are the models added by the model synchronization with their owners, they are listed by /v1/models
+var SyncedModels = map[string]string{}
+
+func ModelSyncTemplates2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelSyncTemplates)
+	if err != nil {
+		SysError("error marshalling model sync templates: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelSyncTemplatesByJSONString(jsonStr string) error {
+	ModelSyncTemplates = make(map[string]*ModelSyncTemplate)
+	return json.Unmarshal([]byte(jsonStr), &ModelSyncTemplates)
+}
+
+func SyncedModels2JSONString() string {
+	jsonBytes, err := json.Marshal(SyncedModels)
+	if err != nil {
+		SysError("error marshalling synced models: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateSyncedModelsByJSONString(jsonStr string) error {
+	SyncedModels = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &SyncedModels)
+}
+
+func GetModelSyncTemplate(name string) *ModelSyncTemplate {
+	matched := ""
+	var template *ModelSyncTemplate
+	for prefix, t := range ModelSyncTemplates {
+		if strings.HasPrefix(name, prefix) && (template == nil || len(prefix) > len(matched)) {
+			matched = prefix
+			template = t
+		}
+	}
+	return template
+}
@@ -0,0 +1,69 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+const (
+	ModerationLevelOff   = "off"
+	ModerationLevelFlag  = "flag"  // the request is let through and logged
+	ModerationLevelBlock = "block" // the request is refused and logged
+)
+
+// SensitiveWords are matched in the texts of the requests regardless of the case
+var SensitiveWords []string
+
+// ContentModerationModel is the moderation model the requests are also checked with, empty for the sensitive words only
+var ContentModerationModel = ""
+
+// GroupModerationLevel is the moderation level of each user group, the key "*" applies to the groups not listed
+var GroupModerationLevel = map[string]string{}
+
+func IsValidModerationLevel(level string) bool {
+	return level == ModerationLevelOff || level == ModerationLevelFlag || level == ModerationLevelBlock
+}
+
+func UpdateSensitiveWordsByString(value string) {
+	SensitiveWords = nil
+	for _, word := range strings.Split(value, "\n") {
+		word = strings.TrimSpace(word)
+		if word != "" {
+			SensitiveWords = append(SensitiveWords, strings.ToLower(word))
+		}
+	}
+}
+
+// MatchSensitiveWord returns the first sensitive word in the text, empty if none
+func MatchSensitiveWord(text string) string {
+	text = strings.ToLower(text)
+	for _, word := range SensitiveWords {
+		if strings.Contains(text, word) {
+			return word
+		}
+	}
+	return ""
+}
+
+func GroupModerationLevel2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupModerationLevel)
+	if err != nil {
+		SysError("error marshalling group moderation level: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupModerationLevelByJSONString(jsonStr string) error {
+	GroupModerationLevel = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &GroupModerationLevel)
+}
+
+func GetGroupModerationLevel(group string) string {
+	if level, ok := GroupModerationLevel[group]; ok {
+		return level
+	}
+	if level, ok := GroupModerationLevel["*"]; ok {
+		return level
+	}
+	return ModerationLevelOff
+}
@@ -0,0 +1,90 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+)
+
+// SimplePDF renders lines of monospaced text into a PDF document, it is enough for statements and
+// reports without pulling in a PDF library. Only ASCII is supported by the built-in Courier font.
+type SimplePDF struct {
+	lines []string
+}
+
+const (
+	pdfLinesPerPage = 60
+	pdfFontSize     = 9
+	pdfLineHeight   = 12
+	pdfPageWidth    = 595 // A4
+	pdfPageHeight   = 842
+	pdfMargin       = 40
+)
+
+func (pdf *SimplePDF) AddLine(line string) {
+	pdf.lines = append(pdf.lines, line)
+}
+
+func pdfEscape(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '\\' || r == '(' || r == ')':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func (pdf *SimplePDF) Bytes() []byte {
+	var pages [][]string
+	for i := 0; i < len(pdf.lines); i += pdfLinesPerPage {
+		end := i + pdfLinesPerPage
+		if end > len(pdf.lines) {
+			end = len(pdf.lines)
+		}
+		pages = append(pages, pdf.lines[i:end])
+	}
+	if len(pages) == 0 {
+		pages = append(pages, []string{})
+	}
+	// object 1: catalog, 2: pages, 3: font, then a page and a content stream for each page
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 4+i*2)
+	}
+	objects = append(objects, "<< /Type /Catalog /Pages 2 0 R >>")
+	objects = append(objects, fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)))
+	objects = append(objects, "<< /Type /Font /Subtype /Type1 /BaseFont /Courier >>")
+	for i, lines := range pages {
+		var content strings.Builder
+		content.WriteString(fmt.Sprintf("BT /F1 %d Tf %d TL %d %d Td\n", pdfFontSize, pdfLineHeight, pdfMargin, pdfPageHeight-pdfMargin))
+		for _, line := range lines {
+			content.WriteString(fmt.Sprintf("(%s) Tj T*\n", pdfEscape(line)))
+		}
+		content.WriteString("ET")
+		objects = append(objects, fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %d %d] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+			pdfPageWidth, pdfPageHeight, 5+i*2))
+		objects = append(objects, fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", content.Len(), content.String()))
+	}
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = buf.Len()
+		buf.WriteString(fmt.Sprintf("%d 0 obj\n%s\nendobj\n", i+1, object))
+	}
+	xref := buf.Len()
+	buf.WriteString(fmt.Sprintf("xref\n0 %d\n0000000000 65535 f \n", len(objects)+1))
+	for _, offset := range offsets {
+		buf.WriteString(fmt.Sprintf("%010d 00000 n \n", offset))
+	}
+	buf.WriteString(fmt.Sprintf("trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref))
+	return buf.Bytes()
+}
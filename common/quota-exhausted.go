@@ -0,0 +1,58 @@
+package common
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// QuotaExhaustedResponse replaces the error returned when a token or a user runs out of quota
+type QuotaExhaustedResponse struct {
+	StatusCode int               `json:"status_code"` // 0 keeps the default status
+	Message    string            `json:"message"`
+	Messages   map[string]string `json:"messages"` // the localized messages by language, such as "en" or "zh-TW"
+	UpgradeURL string            `json:"upgrade_url"`
+	// Body is sent as is instead of the OpenAI error, the strings "{message}", "{upgrade_url}" and "{group}" in it are replaced
+	Body json.RawMessage `json:"body"`
+}
+
+// GroupQuotaExhaustedResponse is the response of each group, the key "*" applies to the groups not listed
+var GroupQuotaExhaustedResponse = map[string]*QuotaExhaustedResponse{}
+
+func GroupQuotaExhaustedResponse2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupQuotaExhaustedResponse)
+	if err != nil {
+		SysError("error marshalling group quota exhausted response: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupQuotaExhaustedResponseByJSONString(jsonStr string) error {
+	GroupQuotaExhaustedResponse = make(map[string]*QuotaExhaustedResponse)
+	return json.Unmarshal([]byte(jsonStr), &GroupQuotaExhaustedResponse)
+}
+
+func GetGroupQuotaExhaustedResponse(group string) *QuotaExhaustedResponse {
+	if response, ok := GroupQuotaExhaustedResponse[group]; ok {
+		return response
+	}
+	return GroupQuotaExhaustedResponse["*"]
+}
+
+// GetMessage picks the message of the first language in Accept-Language which has one, such as zh-CN and then zh
+func (r *QuotaExhaustedResponse) GetMessage(acceptLanguage string) string {
+	for _, language := range strings.Split(acceptLanguage, ",") {
+		language, _, _ = strings.Cut(strings.TrimSpace(language), ";")
+		if language == "" {
+			continue
+		}
+		if message, ok := r.Messages[language]; ok {
+			return message
+		}
+		if base, _, found := strings.Cut(language, "-"); found {
+			if message, ok := r.Messages[base]; ok {
+				return message
+			}
+		}
+	}
+	return r.Message
+}
@@ -61,3 +61,39 @@ func RedisDel(key string) error {
 	ctx := context.Background()
 	return RDB.Del(ctx, key).Err()
 }
+
+// RedisListPush appends the value to the list and keeps the latest size values of it
+func RedisListPush(key string, value string, size int, expiration time.Duration) error {
+	ctx := context.Background()
+	pipe := RDB.TxPipeline()
+	pipe.RPush(ctx, key, value)
+	pipe.LTrim(ctx, key, int64(-size), -1)
+	pipe.Expire(ctx, key, expiration)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// RedisListRanges reads the lists in one round trip, the missing ones are empty
+func RedisListRanges(keys []string) ([][]string, error) {
+	ctx := context.Background()
+	pipe := RDB.Pipeline()
+	commands := make([]*redis.StringSliceCmd, len(keys))
+	for i, key := range keys {
+		commands[i] = pipe.LRange(ctx, key, 0, -1)
+	}
+	_, err := pipe.Exec(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lists := make([][]string, len(keys))
+	for i, command := range commands {
+		lists[i] = command.Val()
+	}
+	return lists, nil
+}
+
+func RedisPing() error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+	return RDB.Ping(ctx).Err()
+}
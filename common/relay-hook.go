@@ -0,0 +1,43 @@
+package common
+
+import "encoding/json"
+
+type RelayHookSetting struct {
+	Name   string         `json:"name"`             // the name the hook is registered with
+	Groups []string       `json:"groups,omitempty"` // the user groups the hook applies to, all of them when empty
+	Config map[string]any `json:"config,omitempty"` // passed to the hook as is
+}
+
+// RelayHooks are the hooks run on the relayed requests and responses, in this order
+var RelayHooks []RelayHookSetting
+
+func RelayHooks2JSONString() string {
+	jsonBytes, err := json.Marshal(RelayHooks)
+	if err != nil {
+		SysError("error marshalling relay hooks: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateRelayHooksByJSONString(jsonStr string) error {
+	RelayHooks = nil
+	return json.Unmarshal([]byte(jsonStr), &RelayHooks)
+}
+
+// GetRelayHookSettings returns the hooks which apply to the user group
+func GetRelayHookSettings(group string) []RelayHookSetting {
+	var settings []RelayHookSetting
+	for _, setting := range RelayHooks {
+		if len(setting.Groups) == 0 {
+			settings = append(settings, setting)
+			continue
+		}
+		for _, g := range setting.Groups {
+			if g == group {
+				settings = append(settings, setting)
+				break
+			}
+		}
+	}
+	return settings
+}
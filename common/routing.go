@@ -0,0 +1,78 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+const (
+	RoutingModeWeighted = "weighted" // a probability proportional to the weights of the channels
+	RoutingModeLatency  = "latency"  // the fastest healthy channel, see LatencyRoutingMaxErrorRate
+)
+
+// ModelRoutingMode is how a channel is picked for each model among the ones of the highest priority,
+// the key "*" applies to the models not listed
+var ModelRoutingMode = map[string]string{}
+
+// ModelChannelGroups and GroupChannelGroups route the requests of a model or of a user group to a channel group, the
+// channel group of the token comes first, then the one of the model, then the one of the user group
+var ModelChannelGroups = map[string]string{}
+var GroupChannelGroups = map[string]string{}
+
+// ModerationChannelGroup is the channel group serving all the moderation requests, before the one of the token, empty if none
+var ModerationChannelGroup = ""
+
+var LatencyRoutingMaxErrorRate = 0.2 // the channels failing more often are not preferred by the latency routing
+var LatencyRoutingExploreRate = 10   // percentage of the requests routed by weight so that all the channels keep being measured
+
+func IsValidRoutingMode(mode string) bool {
+	return mode == RoutingModeWeighted || mode == RoutingModeLatency
+}
+
+func ModelRoutingMode2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelRoutingMode)
+	if err != nil {
+		SysError("error marshalling model routing mode: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelRoutingModeByJSONString(jsonStr string) error {
+	ModelRoutingMode = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &ModelRoutingMode)
+}
+
+func GetModelRoutingMode(model string) string {
+	if mode, ok := ModelRoutingMode[model]; ok {
+		return mode
+	}
+	if mode, ok := ModelRoutingMode["*"]; ok {
+		return mode
+	}
+	return RoutingModeWeighted
+}
+
+func ModelChannelGroups2JSONString() string {
+	jsonBytes, err := json.Marshal(ModelChannelGroups)
+	if err != nil {
+		SysError("error marshalling model channel groups: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateModelChannelGroupsByJSONString(jsonStr string) error {
+	ModelChannelGroups = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &ModelChannelGroups)
+}
+
+func GroupChannelGroups2JSONString() string {
+	jsonBytes, err := json.Marshal(GroupChannelGroups)
+	if err != nil {
+		SysError("error marshalling group channel groups: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateGroupChannelGroupsByJSONString(jsonStr string) error {
+	GroupChannelGroups = make(map[string]string)
+	return json.Unmarshal([]byte(jsonStr), &GroupChannelGroups)
+}
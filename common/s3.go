@@ -0,0 +1,86 @@
+package common
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// S3 compatible object storage (AWS S3, MinIO, Cloudflare R2, ...), requests use path style addressing
+var S3Endpoint = os.Getenv("S3_ENDPOINT")
+var S3Region = os.Getenv("S3_REGION")
+var S3Bucket = os.Getenv("S3_BUCKET")
+var S3AccessKey = os.Getenv("S3_ACCESS_KEY")
+var S3SecretKey = os.Getenv("S3_SECRET_KEY")
+
+var s3Client = &http.Client{Timeout: 5 * time.Minute}
+
+func S3Enabled() bool {
+	return S3Bucket != "" && S3AccessKey != "" && S3SecretKey != ""
+}
+
+func s3ObjectURL(key string) string {
+	region := S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	endpoint := S3Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://s3.%s.amazonaws.com", region)
+	}
+	return fmt.Sprintf("%s/%s/%s", strings.TrimSuffix(endpoint, "/"), S3Bucket, strings.TrimPrefix(key, "/"))
+}
+
+func s3Do(method string, key string, body []byte, contentType string) (*http.Response, error) {
+	req, err := http.NewRequest(method, s3ObjectURL(key), bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	region := S3Region
+	if region == "" {
+		region = "us-east-1"
+	}
+	SignAWSRequestV4(req, body, region, "s3", S3AccessKey, S3SecretKey)
+	resp, err := s3Client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 300 {
+		message, _ := io.ReadAll(resp.Body)
+		_ = resp.Body.Close()
+		return nil, fmt.Errorf("s3 %s %s failed: status code %d, %s", method, key, resp.StatusCode, string(message))
+	}
+	return resp, nil
+}
+
+func S3PutObject(key string, body []byte, contentType string) error {
+	resp, err := s3Do(http.MethodPut, key, body, contentType)
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
+
+// S3GetObject returns the object body, the caller should close it
+func S3GetObject(key string) (io.ReadCloser, error) {
+	resp, err := s3Do(http.MethodGet, key, nil, "")
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+func S3DeleteObject(key string) error {
+	resp, err := s3Do(http.MethodDelete, key, nil, "")
+	if err != nil {
+		return err
+	}
+	return resp.Body.Close()
+}
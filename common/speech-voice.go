@@ -0,0 +1,42 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+var openAISpeechVoices = []string{"alloy", "ash", "coral", "echo", "fable", "onyx", "nova", "sage", "shimmer"}
+
+// SpeechVoices are the built-in voices of each speech model, the requests for the other voices are refused before
+// relaying, the models not listed take any voice
+var SpeechVoices = map[string][]string{
+	"tts-1":           openAISpeechVoices,
+	"tts-1-hd":        openAISpeechVoices,
+	"gpt-4o-mini-tts": append([]string{"ballad", "verse", "marin", "cedar"}, openAISpeechVoices...),
+}
+
+func SpeechVoices2JSONString() string {
+	jsonBytes, err := json.Marshal(SpeechVoices)
+	if err != nil {
+		SysError("error marshalling speech voices: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateSpeechVoicesByJSONString(jsonStr string) error {
+	SpeechVoices = make(map[string][]string)
+	return json.Unmarshal([]byte(jsonStr), &SpeechVoices)
+}
+
+// IsSpeechVoiceSupported tells whether the model has the voice, the voices of the models not listed are not checked
+func IsSpeechVoiceSupported(name string, voice string) bool {
+	voices, ok := SpeechVoices[name]
+	if !ok {
+		return true
+	}
+	for _, supportedVoice := range voices {
+		if supportedVoice == voice {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,47 @@
+package common
+
+import "encoding/json"
+
+const (
+	StreamClientBrowser = "browser"
+	StreamClientSDK     = "sdk"
+	StreamClientCurl    = "curl"
+	StreamClientOther   = "other"
+)
+
+type StreamSetting struct {
+	Heartbeat   int `json:"heartbeat"`    // seconds between SSE comments sent while the upstream is silent, 0 means disabled
+	IdleTimeout int `json:"idle_timeout"` // seconds without upstream data before the stream is closed, 0 means no limit
+	Retry       int `json:"retry"`        // reconnection delay in milliseconds announced to EventSource clients, 0 means not sent
+	Padding     int `json:"padding"`      // bytes of SSE comment sent first to push the response through buffering proxies
+}
+
+// StreamSettings is keyed by user group and then by client type, the "default" group applies to the groups without their own setting
+var StreamSettings = map[string]map[string]StreamSetting{
+	"default": {
+		StreamClientBrowser: {Heartbeat: 15, IdleTimeout: 120},
+		StreamClientSDK:     {Heartbeat: 15, IdleTimeout: 300},
+		StreamClientCurl:    {IdleTimeout: 300},
+		StreamClientOther:   {},
+	},
+}
+
+func StreamSettings2JSONString() string {
+	jsonBytes, err := json.Marshal(StreamSettings)
+	if err != nil {
+		SysError("error marshalling stream settings: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateStreamSettingsByJSONString(jsonStr string) error {
+	StreamSettings = make(map[string]map[string]StreamSetting)
+	return json.Unmarshal([]byte(jsonStr), &StreamSettings)
+}
+
+func GetStreamSetting(group string, clientType string) StreamSetting {
+	if setting, ok := StreamSettings[group][clientType]; ok {
+		return setting
+	}
+	return StreamSettings["default"][clientType]
+}
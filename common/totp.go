@@ -0,0 +1,104 @@
+package common
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"net/url"
+	"strings"
+	"time"
+)
+
+var TOTPRequiredForAdminEnabled = false // the admin routes refuse the admins who have not enabled the two-factor authentication
+
+const (
+	TOTPPeriod            = 30 // seconds, the default of the authenticator apps
+	TOTPDigits            = 6
+	TOTPRecoveryCodeCount = 10
+)
+
+// the codes of the previous and the next periods are accepted for the clock drift of the phones
+const totpSkew = 1
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return b
+}
+
+// GenerateTOTPSecret returns a 160-bit secret encoded in base32 without padding, as RFC 4226 recommends
+func GenerateTOTPSecret() string {
+	return base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(randomBytes(20))
+}
+
+// GetTOTPURL returns the otpauth:// URL the authenticator apps scan from a QR code
+func GetTOTPURL(account string, secret string) string {
+	issuer := SystemName
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	values.Set("period", fmt.Sprintf("%d", TOTPPeriod))
+	values.Set("digits", fmt.Sprintf("%d", TOTPDigits))
+	return "otpauth://totp/" + url.PathEscape(issuer+":"+account) + "?" + values.Encode()
+}
+
+func totpCode(key []byte, counter int64) string {
+	message := make([]byte, 8)
+	binary.BigEndian.PutUint64(message, uint64(counter))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(message)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+	return fmt.Sprintf("%0*d", TOTPDigits, value%uint32(math.Pow10(TOTPDigits)))
+}
+
+// ValidateTOTP checks the code against the secret, it returns the counter of the period the code belongs to so that
+// the callers can refuse a code used before, 0 if the code is invalid
+func ValidateTOTP(secret string, code string) int64 {
+	code = strings.TrimSpace(code)
+	if len(code) != TOTPDigits {
+		return 0
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(secret))
+	if err != nil {
+		return 0
+	}
+	counter := time.Now().Unix() / TOTPPeriod
+	for i := -totpSkew; i <= totpSkew; i++ {
+		if subtle.ConstantTimeCompare([]byte(totpCode(key, counter+int64(i))), []byte(code)) == 1 {
+			return counter + int64(i)
+		}
+	}
+	return 0
+}
+
+// GenerateRecoveryCodes returns the one-time codes which replace a TOTP code when the phone is lost, like xxxxx-xxxxx
+func GenerateRecoveryCodes() []string {
+	const chars = "abcdefghjkmnpqrstuvwxyz23456789"
+	codes := make([]string, TOTPRecoveryCodeCount)
+	for i := range codes {
+		code := make([]byte, 10)
+		for j, b := range randomBytes(10) {
+			code[j] = chars[int(b)%len(chars)]
+		}
+		codes[i] = string(code[:5]) + "-" + string(code[5:])
+	}
+	return codes
+}
+
+// NormalizeRecoveryCode lets the users type the recovery codes without the dash and in upper case
+func NormalizeRecoveryCode(code string) string {
+	code = strings.ToLower(strings.ReplaceAll(strings.TrimSpace(code), "-", ""))
+	if len(code) != 10 {
+		return code
+	}
+	return code[:5] + "-" + code[5:]
+}
@@ -0,0 +1,46 @@
+package common
+
+import (
+	"encoding/json"
+)
+
+// UpstreamPrice is the list price of a model at its provider in USD per 1K tokens, it estimates what the
+// requests cost upstream independently of the ratios the users are billed with
+type UpstreamPrice struct {
+	Input  float64 `json:"input"`
+	Output float64 `json:"output"`
+}
+
+var UpstreamPrices = map[string]*UpstreamPrice{
+	"gpt-3.5-turbo":          {Input: 0.0005, Output: 0.0015},
+	"gpt-4":                  {Input: 0.03, Output: 0.06},
+	"gpt-4-32k":              {Input: 0.06, Output: 0.12},
+	"gpt-4-turbo":            {Input: 0.01, Output: 0.03},
+	"gpt-4o":                 {Input: 0.0025, Output: 0.01},
+	"gpt-4o-mini":            {Input: 0.00015, Output: 0.0006},
+	"text-embedding-ada-002": {Input: 0.0001},
+	"text-embedding-3-small": {Input: 0.00002},
+	"text-embedding-3-large": {Input: 0.00013},
+}
+
+func UpstreamPrices2JSONString() string {
+	jsonBytes, err := json.Marshal(UpstreamPrices)
+	if err != nil {
+		SysError("error marshalling upstream prices: " + err.Error())
+	}
+	return string(jsonBytes)
+}
+
+func UpdateUpstreamPricesByJSONString(jsonStr string) error {
+	UpstreamPrices = make(map[string]*UpstreamPrice)
+	return json.Unmarshal([]byte(jsonStr), &UpstreamPrices)
+}
+
+// GetUpstreamCost is the estimated cost in USD of a request of the model, false is returned if the model has no price
+func GetUpstreamCost(name string, promptTokens int, completionTokens int) (float64, bool) {
+	price, ok := UpstreamPrices[name]
+	if !ok || price == nil {
+		return 0, false
+	}
+	return (float64(promptTokens)*price.Input + float64(completionTokens)*price.Output) / 1000, true
+}
@@ -0,0 +1,720 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the endpoints the requests of a batch may use
+var batchEndpoints = map[string]bool{
+	"/v1/chat/completions": true,
+	"/v1/completions":      true,
+	"/v1/embeddings":       true,
+}
+
+const maxBatchRequests = 50000
+
+// batchContextKey marks the requests of the batches run by the gateway, the clients cannot set it
+type batchContextKey struct{}
+
+// BatchRequest is a line of the input file of a batch
+type BatchRequest struct {
+	CustomId string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	Url      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+// BatchOutputLine is a line of the output or the error file of a batch
+type BatchOutputLine struct {
+	Id       string `json:"id"`
+	CustomId string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		RequestId  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error any `json:"error"`
+}
+
+type BatchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Param   string `json:"param,omitempty"`
+	Line    int    `json:"line,omitempty"`
+}
+
+// BatchObject is the batch in the format of the Batch API
+type BatchObject struct {
+	*model.Batch
+	Object string `json:"object"`
+	Errors *struct {
+		Object string       `json:"object"`
+		Data   []BatchError `json:"data"`
+	} `json:"errors"`
+	Metadata      any `json:"metadata"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+func getBatchObject(batch *model.Batch) *BatchObject {
+	object := &BatchObject{Batch: batch, Object: "batch"}
+	var batchErrors []BatchError
+	if batch.Errors != "" && json.Unmarshal([]byte(batch.Errors), &batchErrors) == nil {
+		object.Errors = &struct {
+			Object string       `json:"object"`
+			Data   []BatchError `json:"data"`
+		}{Object: "list", Data: batchErrors}
+	}
+	if batch.Metadata != "" {
+		_ = json.Unmarshal([]byte(batch.Metadata), &object.Metadata)
+	}
+	object.RequestCounts.Total = batch.Total
+	object.RequestCounts.Completed = batch.Completed
+	object.RequestCounts.Failed = batch.Failed
+	return object
+}
+
+// getBatchRatio is the ratio of the price the request is billed at, lower for the requests of the batches
+func getBatchRatio(c *gin.Context) float64 {
+	if c.Request.Context().Value(batchContextKey{}) == nil {
+		return 1
+	}
+	return common.BatchRatio
+}
+
+// readBatchRequests parses the input file of a batch, the errors tell the invalid lines
+func readBatchRequests(file *model.File, endpoint string) ([]*BatchRequest, []BatchError, error) {
+	content, err := common.LoadFile(file.Storage, file.GetStorageKey())
+	if err != nil {
+		return nil, nil, err
+	}
+	defer content.Close()
+	var requests []*BatchRequest
+	var batchErrors []BatchError
+	customIds := make(map[string]bool)
+	scanner := bufio.NewScanner(content)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for line := 1; scanner.Scan(); line++ {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		request := &BatchRequest{}
+		var body struct {
+			Model string `json:"model"`
+		}
+		if json.Unmarshal(scanner.Bytes(), request) != nil || json.Unmarshal(request.Body, &body) != nil {
+			batchErrors = append(batchErrors, BatchError{Code: "invalid_json_line", Message: "This line is not parseable as valid JSON.", Line: line})
+		} else if request.CustomId == "" || customIds[request.CustomId] {
+			batchErrors = append(batchErrors, BatchError{Code: "duplicate_custom_id", Message: "The custom ID is empty or not unique in the file.", Param: "custom_id", Line: line})
+		} else if request.Method != http.MethodPost {
+			batchErrors = append(batchErrors, BatchError{Code: "invalid_method", Message: "The method must be POST.", Param: "method", Line: line})
+		} else if request.Url != endpoint {
+			batchErrors = append(batchErrors, BatchError{Code: "mismatched_endpoint", Message: fmt.Sprintf("The URL must be %s, the endpoint of the batch.", endpoint), Param: "url", Line: line})
+		} else if body.Model == "" {
+			batchErrors = append(batchErrors, BatchError{Code: "missing_required_parameter", Message: "The body must have a model.", Param: "body.model", Line: line})
+		}
+		customIds[request.CustomId] = true
+		requests = append(requests, request)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(requests) == 0 {
+		batchErrors = append(batchErrors, BatchError{Code: "empty_file", Message: "The input file has no request."})
+	}
+	if len(requests) > maxBatchRequests {
+		batchErrors = append(batchErrors, BatchError{Code: "too_many_requests", Message: fmt.Sprintf("The input file has more than %d requests.", maxBatchRequests)})
+	}
+	return requests, batchErrors, nil
+}
+
+func getRequestModel(request *BatchRequest) string {
+	var body struct {
+		Model string `json:"model"`
+	}
+	_ = json.Unmarshal(request.Body, &body)
+	return body.Model
+}
+
+func CreateBatch(c *gin.Context) {
+	userId := c.GetInt("id")
+	var request struct {
+		InputFileId      string `json:"input_file_id"`
+		Endpoint         string `json:"endpoint"`
+		CompletionWindow string `json:"completion_window"`
+		Metadata         any    `json:"metadata"`
+	}
+	err := json.NewDecoder(c.Request.Body).Decode(&request)
+	if err != nil {
+		writeFileError(c, http.StatusBadRequest, "无效的请求")
+		return
+	}
+	if !batchEndpoints[request.Endpoint] {
+		writeFileError(c, http.StatusBadRequest, fmt.Sprintf("不支持的 endpoint %s", request.Endpoint))
+		return
+	}
+	if request.CompletionWindow != "24h" {
+		writeFileError(c, http.StatusBadRequest, "completion_window 只能为 24h")
+		return
+	}
+	file, err := model.GetUserFile(request.InputFileId, userId)
+	if err != nil {
+		writeFileError(c, http.StatusNotFound, fmt.Sprintf("文件 %s 不存在", request.InputFileId))
+		return
+	}
+	if file.Purpose != "batch" {
+		writeFileError(c, http.StatusBadRequest, "输入文件的 purpose 必须为 batch")
+		return
+	}
+	requests, batchErrors, err := readBatchRequests(file, request.Endpoint)
+	if err != nil {
+		common.SysError("failed to load file: " + err.Error())
+		writeFileError(c, http.StatusInternalServerError, "文件读取失败")
+		return
+	}
+	now := common.GetTimestamp()
+	batch := &model.Batch{
+		Id:               "batch_" + common.GetUUID(),
+		UserId:           userId,
+		TokenId:          c.GetInt("token_id"),
+		Endpoint:         request.Endpoint,
+		InputFileId:      request.InputFileId,
+		CompletionWindow: request.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Total:            len(requests),
+		ExpiresAt:        now + 24*60*60,
+	}
+	if request.Metadata != nil {
+		metadata, _ := json.Marshal(request.Metadata)
+		batch.Metadata = string(metadata)
+	}
+	if len(batchErrors) > 0 {
+		// the batch is kept with its errors, as the upstreams do
+		errorsJSON, _ := json.Marshal(batchErrors)
+		batch.Errors = string(errorsJSON)
+		batch.Status = model.BatchStatusFailed
+		batch.FailedAt = now
+		batch.Total = 0
+	} else if common.BatchUpstreamEnabled {
+		err = createUpstreamBatch(c, batch, file, getRequestModel(requests[0]))
+		if err != nil {
+			// the gateway runs the batch itself in this case
+			common.SysError("failed to create upstream batch: " + err.Error())
+		}
+	}
+	err = batch.Insert()
+	if err != nil {
+		writeFileError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	c.JSON(http.StatusOK, getBatchObject(batch))
+}
+
+func ListBatches(c *gin.Context) {
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+	batches, err := model.GetUserBatches(c.GetInt("id"), c.Query("after"), limit)
+	if err != nil {
+		writeFileError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	data := make([]*BatchObject, 0, len(batches))
+	for _, batch := range batches {
+		data = append(data, getBatchObject(batch))
+	}
+	var firstId, lastId *string
+	if len(batches) > 0 {
+		firstId, lastId = &batches[0].Id, &batches[len(batches)-1].Id
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     data,
+		"first_id": firstId,
+		"last_id":  lastId,
+		"has_more": len(batches) == limit,
+	})
+}
+
+func getRequestBatch(c *gin.Context) *model.Batch {
+	batch, err := model.GetUserBatch(c.Param("batch_id"), c.GetInt("id"))
+	if err != nil {
+		writeFileError(c, http.StatusNotFound, fmt.Sprintf("批处理 %s 不存在", c.Param("batch_id")))
+		return nil
+	}
+	return batch
+}
+
+func RetrieveBatch(c *gin.Context) {
+	batch := getRequestBatch(c)
+	if batch == nil {
+		return
+	}
+	c.JSON(http.StatusOK, getBatchObject(batch))
+}
+
+func CancelBatch(c *gin.Context) {
+	batch := getRequestBatch(c)
+	if batch == nil {
+		return
+	}
+	if !model.SetBatchStatus(batch.Id, []string{model.BatchStatusValidating, model.BatchStatusInProgress}, map[string]any{"status": model.BatchStatusCancelling}) {
+		writeFileError(c, http.StatusBadRequest, fmt.Sprintf("批处理 %s 的状态为 %s，无法取消", batch.Id, batch.Status))
+		return
+	}
+	batch.Status = model.BatchStatusCancelling
+	if batch.UpstreamBatchId != "" {
+		_, err := doUpstreamBatchRequest(batch, http.MethodPost, "/v1/batches/"+batch.UpstreamBatchId+"/cancel", nil)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to cancel upstream batch %s: %s", batch.UpstreamBatchId, err.Error()))
+		}
+	}
+	c.JSON(http.StatusOK, getBatchObject(batch))
+}
+
+// createUpstreamBatch sends the batch to the batch API of the channel of its model, the batches of the other channels,
+// or of the channels which map the models, are run by the gateway
+func createUpstreamBatch(c *gin.Context, batch *model.Batch, file *model.File, modelName string) error {
+	group, err := model.CacheGetUserGroup(batch.UserId)
+	if err != nil {
+		return err
+	}
+	c.Set("group", group)
+	c.Set("request_model", modelName)
+	channel, err := middleware.SelectChannel(c, group, modelName)
+	if err != nil {
+		return err
+	}
+	if channel.Type != common.ChannelTypeOpenAI || (channel.ModelMapping != "" && channel.ModelMapping != "{}") {
+		return nil
+	}
+	middleware.SetupContextForSelectedChannel(c, channel)
+	upstreamFileId, errWithStatusCode := getUpstreamFileId(c, file)
+	if errWithStatusCode != nil {
+		return errors.New(errWithStatusCode.Message)
+	}
+	batch.ChannelId = channel.Id
+	batch.KeyHash = c.GetString("channel_key_hash")
+	requestBody, _ := json.Marshal(gin.H{
+		"input_file_id":     upstreamFileId,
+		"endpoint":          batch.Endpoint,
+		"completion_window": batch.CompletionWindow,
+	})
+	upstreamBatch, err := doUpstreamBatchRequest(batch, http.MethodPost, "/v1/batches", requestBody)
+	if err != nil {
+		batch.ChannelId, batch.KeyHash = 0, ""
+		return err
+	}
+	batch.UpstreamBatchId = upstreamBatch.Id
+	return nil
+}
+
+// UpstreamBatch is the part of a batch of an upstream used to track it
+type UpstreamBatch struct {
+	Id           string `json:"id"`
+	Status       string `json:"status"`
+	OutputFileId string `json:"output_file_id"`
+	ErrorFileId  string `json:"error_file_id"`
+	Errors       *struct {
+		Data []BatchError `json:"data"`
+	} `json:"errors"`
+	InProgressAt  int64 `json:"in_progress_at"`
+	RequestCounts struct {
+		Total     int `json:"total"`
+		Completed int `json:"completed"`
+		Failed    int `json:"failed"`
+	} `json:"request_counts"`
+}
+
+// doUpstreamBatch sends a request about the batch to the channel and the key which created it
+func doUpstreamBatch(batch *model.Batch, method string, path string, requestBody []byte) (*http.Response, error) {
+	channel, err := model.GetChannelById(batch.ChannelId, true)
+	if err != nil {
+		return nil, err
+	}
+	key := channel.Key
+	if batch.KeyHash != "" {
+		var ok bool
+		if key, ok = model.GetChannelKeyByHash(channel, batch.KeyHash); !ok {
+			return nil, fmt.Errorf("channel #%d no longer has the key of batch %s", channel.Id, batch.Id)
+		}
+	}
+	baseURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		baseURL = channel.BaseURL
+	}
+	req, err := http.NewRequest(method, baseURL+path, bytes.NewReader(requestBody))
+	if err != nil {
+		return nil, err
+	}
+	setBearerAuth(req, key)
+	req.Header.Set("Content-Type", "application/json")
+	setChannelHeaders(req, channel.Headers)
+	resp, err := getHTTPClient(channel.Proxy).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		errWithStatusCode := getOpenAIResponseError(resp)
+		return nil, fmt.Errorf("status code %d, %s", resp.StatusCode, errWithStatusCode.Message)
+	}
+	return resp, nil
+}
+
+func doUpstreamBatchRequest(batch *model.Batch, method string, path string, requestBody []byte) (*UpstreamBatch, error) {
+	resp, err := doUpstreamBatch(batch, method, path, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	upstreamBatch := &UpstreamBatch{}
+	err = json.NewDecoder(resp.Body).Decode(upstreamBatch)
+	return upstreamBatch, err
+}
+
+// AutomaticallyProcessBatches runs the batches of the gateway and tracks the ones of the upstreams, the requests of
+// the batches are served by the handler like the other requests of their tokens, one after another
+func AutomaticallyProcessBatches(handler http.Handler, frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		batches, err := model.GetUnfinishedBatches()
+		if err != nil {
+			common.SysError("failed to get unfinished batches: " + err.Error())
+			continue
+		}
+		for _, batch := range batches {
+			if batch.UpstreamBatchId != "" {
+				trackUpstreamBatch(batch)
+			} else {
+				runBatch(handler, batch)
+			}
+		}
+	}
+}
+
+func failBatch(batch *model.Batch, code string, message string) {
+	errorsJSON, _ := json.Marshal([]BatchError{{Code: code, Message: message}})
+	batch.Errors = string(errorsJSON)
+	batch.Status = model.BatchStatusFailed
+	batch.FailedAt = common.GetTimestamp()
+	err := batch.Update()
+	if err != nil {
+		common.SysError("failed to update batch: " + err.Error())
+	}
+	model.DeleteBatchOutputs(batch.Id)
+}
+
+func runBatch(handler http.Handler, batch *model.Batch) {
+	file, err := model.GetUserFile(batch.InputFileId, batch.UserId)
+	if err != nil {
+		failBatch(batch, "file_not_found", "The input file was deleted.")
+		return
+	}
+	requests, _, err := readBatchRequests(file, batch.Endpoint)
+	if err != nil {
+		common.SysError("failed to load file: " + err.Error())
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		failBatch(batch, "token_not_found", "The token which created the batch was deleted.")
+		return
+	}
+	if batch.Status == model.BatchStatusValidating {
+		batch.Status = model.BatchStatusInProgress
+		batch.InProgressAt = common.GetTimestamp()
+		model.SetBatchStatus(batch.Id, []string{model.BatchStatusValidating}, map[string]any{"status": batch.Status, "in_progress_at": batch.InProgressAt})
+	}
+	outputs, err := model.GetBatchOutputs(batch.Id)
+	if err != nil {
+		common.SysError("failed to get batch outputs: " + err.Error())
+		return
+	}
+	for line := len(outputs); line < len(requests); line++ {
+		current, err := model.GetBatchById(batch.Id)
+		if err != nil {
+			return
+		}
+		if current.Status == model.BatchStatusCancelling {
+			finishBatch(batch, model.BatchStatusCancelled)
+			return
+		}
+		if common.GetTimestamp() > batch.ExpiresAt {
+			finishBatch(batch, model.BatchStatusExpired)
+			return
+		}
+		output, statusCode := runBatchRequest(handler, batch, token.Key, requests[line])
+		if statusCode == http.StatusTooManyRequests {
+			// the token or the channels are at their limits, the batch goes on in the next round
+			return
+		}
+		err = model.RecordBatchOutput(&model.BatchOutput{BatchId: batch.Id, Line: line, Output: output, IsError: statusCode != http.StatusOK})
+		if err != nil {
+			common.SysError("failed to record batch output: " + err.Error())
+			return
+		}
+		if statusCode == http.StatusOK {
+			batch.Completed++
+		} else {
+			batch.Failed++
+		}
+		model.UpdateBatchCounts(batch.Id, batch.Completed, batch.Failed)
+	}
+	finishBatch(batch, model.BatchStatusCompleted)
+}
+
+// runBatchRequest serves the request with the token of the batch, the context marks it to be billed at the batch ratio
+func runBatchRequest(handler http.Handler, batch *model.Batch, key string, request *BatchRequest) (string, int) {
+	ctx := context.WithValue(context.Background(), batchContextKey{}, batch.Id)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, request.Url, bytes.NewReader(request.Body))
+	if err != nil {
+		return "", http.StatusInternalServerError
+	}
+	req.Header.Set("Authorization", "Bearer sk-"+key)
+	req.Header.Set("Content-Type", "application/json")
+	req.RemoteAddr = "127.0.0.1:0"
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, req)
+	body := recorder.Body.Bytes()
+	if !json.Valid(body) {
+		body, _ = json.Marshal(string(body))
+	}
+	id := "batch_req_" + common.GetUUID()
+	output := BatchOutputLine{Id: id, CustomId: request.CustomId}
+	output.Response = &struct {
+		StatusCode int             `json:"status_code"`
+		RequestId  string          `json:"request_id"`
+		Body       json.RawMessage `json:"body"`
+	}{StatusCode: recorder.Code, RequestId: id, Body: body}
+	outputJSON, _ := json.Marshal(output)
+	return string(outputJSON), recorder.Code
+}
+
+// saveBatchFile keeps the output or the error file of the batch as a file of its user
+func saveBatchFile(batch *model.Batch, name string, data []byte) (string, error) {
+	file := &model.File{
+		Id:       "file-" + common.GetUUID(),
+		UserId:   batch.UserId,
+		Bytes:    int64(len(data)),
+		Filename: fmt.Sprintf("%s_%s.jsonl", batch.Id, name),
+		Purpose:  "batch_output",
+	}
+	var err error
+	file.Storage, err = common.SaveFile(file.GetStorageKey(), data, "application/jsonl")
+	if err != nil {
+		return "", err
+	}
+	return file.Id, file.Insert()
+}
+
+func finishBatch(batch *model.Batch, status string) {
+	outputs, err := model.GetBatchOutputs(batch.Id)
+	if err != nil {
+		common.SysError("failed to get batch outputs: " + err.Error())
+		return
+	}
+	var outputData, errorData bytes.Buffer
+	for _, output := range outputs {
+		if output.IsError {
+			errorData.WriteString(output.Output + "\n")
+		} else {
+			outputData.WriteString(output.Output + "\n")
+		}
+	}
+	if outputData.Len() > 0 {
+		batch.OutputFileId, err = saveBatchFile(batch, "output", outputData.Bytes())
+	}
+	if err == nil && errorData.Len() > 0 {
+		batch.ErrorFileId, err = saveBatchFile(batch, "error", errorData.Bytes())
+	}
+	if err != nil {
+		common.SysError("failed to save batch file: " + err.Error())
+		return
+	}
+	finishBatchStatus(batch, status)
+	model.DeleteBatchOutputs(batch.Id)
+}
+
+func finishBatchStatus(batch *model.Batch, status string) {
+	batch.Status = status
+	now := common.GetTimestamp()
+	switch status {
+	case model.BatchStatusCompleted, model.BatchStatusExpired:
+		batch.CompletedAt = now
+	case model.BatchStatusCancelled:
+		batch.CancelledAt = now
+	case model.BatchStatusFailed:
+		batch.FailedAt = now
+	}
+	err := batch.Update()
+	if err != nil {
+		common.SysError("failed to update batch: " + err.Error())
+	}
+}
+
+// trackUpstreamBatch updates the batch from the upstream, the output is copied and billed once the batch is done
+func trackUpstreamBatch(batch *model.Batch) {
+	upstreamBatch, err := doUpstreamBatchRequest(batch, http.MethodGet, "/v1/batches/"+batch.UpstreamBatchId, nil)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get upstream batch %s: %s", batch.UpstreamBatchId, err.Error()))
+		return
+	}
+	batch.Total = upstreamBatch.RequestCounts.Total
+	batch.Completed = upstreamBatch.RequestCounts.Completed
+	batch.Failed = upstreamBatch.RequestCounts.Failed
+	if batch.InProgressAt == 0 {
+		batch.InProgressAt = upstreamBatch.InProgressAt
+	}
+	if upstreamBatch.Errors != nil && len(upstreamBatch.Errors.Data) > 0 {
+		errorsJSON, _ := json.Marshal(upstreamBatch.Errors.Data)
+		batch.Errors = string(errorsJSON)
+	}
+	switch upstreamBatch.Status {
+	case model.BatchStatusCompleted, model.BatchStatusFailed, model.BatchStatusExpired, model.BatchStatusCancelled:
+	default:
+		if batch.Status != model.BatchStatusCancelling || upstreamBatch.Status == model.BatchStatusCancelling {
+			batch.Status = upstreamBatch.Status
+		}
+		err = batch.Update()
+		if err != nil {
+			common.SysError("failed to update batch: " + err.Error())
+		}
+		return
+	}
+	if upstreamBatch.OutputFileId != "" {
+		data, err := getUpstreamBatchFile(batch, upstreamBatch.OutputFileId)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get output of upstream batch %s: %s", batch.UpstreamBatchId, err.Error()))
+			return
+		}
+		batch.OutputFileId, err = saveBatchFile(batch, "output", data)
+		if err != nil {
+			common.SysError("failed to save batch file: " + err.Error())
+			return
+		}
+		billUpstreamBatch(batch, data)
+	}
+	if upstreamBatch.ErrorFileId != "" {
+		data, err := getUpstreamBatchFile(batch, upstreamBatch.ErrorFileId)
+		if err == nil {
+			batch.ErrorFileId, err = saveBatchFile(batch, "error", data)
+		}
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to copy errors of upstream batch %s: %s", batch.UpstreamBatchId, err.Error()))
+		}
+	}
+	finishBatchStatus(batch, upstreamBatch.Status)
+}
+
+func getUpstreamBatchFile(batch *model.Batch, fileId string) ([]byte, error) {
+	resp, err := doUpstreamBatch(batch, http.MethodGet, "/v1/files/"+fileId+"/content", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	return io.ReadAll(resp.Body)
+}
+
+// billUpstreamBatch bills the successful requests of the output of an upstream batch, grouped by their models
+func billUpstreamBatch(batch *model.Batch, data []byte) {
+	usages := make(map[string]*Usage)
+	requests := make(map[string]int)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	for scanner.Scan() {
+		var line struct {
+			Response *struct {
+				StatusCode int `json:"status_code"`
+				Body       struct {
+					Model string `json:"model"`
+					Usage *Usage `json:"usage"`
+				} `json:"body"`
+			} `json:"response"`
+		}
+		if json.Unmarshal(scanner.Bytes(), &line) != nil || line.Response == nil || line.Response.StatusCode != http.StatusOK || line.Response.Body.Usage == nil {
+			continue
+		}
+		modelName := line.Response.Body.Model
+		usage, ok := usages[modelName]
+		if !ok {
+			usage = &Usage{PromptTokensDetails: &PromptTokensDetails{}}
+			usages[modelName] = usage
+		}
+		usage.PromptTokens += line.Response.Body.Usage.PromptTokens
+		usage.CompletionTokens += line.Response.Body.Usage.CompletionTokens
+		if line.Response.Body.Usage.PromptTokensDetails != nil {
+			usage.PromptTokensDetails.CachedTokens += line.Response.Body.Usage.PromptTokensDetails.CachedTokens
+		}
+		requests[modelName]++
+	}
+	for modelName, usage := range usages {
+		billBatchUsage(batch, modelName, *usage, requests[modelName])
+	}
+}
+
+func billBatchUsage(batch *model.Batch, modelName string, usage Usage, requests int) {
+	if usage.PromptTokens+usage.CompletionTokens == 0 {
+		return
+	}
+	token, err := model.GetTokenById(batch.TokenId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to bill batch %s: %s", batch.Id, err.Error()))
+		return
+	}
+	group, _ := model.CacheGetUserGroup(batch.UserId)
+	model.RecordChannelTokens(batch.ChannelId, modelName, usage.PromptTokens+usage.CompletionTokens)
+	modelRatio := common.GetModelRatio(modelName)
+	groupRatio := common.GetGroupRatio(group)
+	weightedTokens := getPromptQuota(usage, modelName) + float64(usage.CompletionTokens)*getCompletionRatio(modelName)
+	// the upstreams bill the batches at their batch prices as well
+	model.RecordChannelSpend(batch.ChannelId, int(weightedTokens*modelRatio*common.BatchRatio))
+	ratio := modelRatio * groupRatio * common.BatchRatio
+	quota := int(weightedTokens * ratio)
+	if ratio != 0 && quota <= 0 {
+		quota = 1
+	}
+	chargeLog := ""
+	if quota != 0 {
+		quota, chargeLog = applyModelCharges(modelName, quota, groupRatio)
+		var couponLog string
+		quota, couponLog = applyUserCoupon(batch.UserId, modelName, quota)
+		chargeLog += couponLog
+	}
+	logContent := fmt.Sprintf("批处理 %s，%d 个请求，模型倍率 %.2f，分组倍率 %.2f，批处理倍率 %.2f", batch.Id, requests, modelRatio, groupRatio, common.BatchRatio)
+	logContent += getPromptCacheLog(usage, modelName)
+	logContent += chargeLog
+	settlement := quotaSettlement{
+		userId:            batch.UserId,
+		tokenId:           batch.TokenId,
+		tokenName:         token.Name,
+		organizationId:    token.OrganizationId,
+		group:             group,
+		channelId:         batch.ChannelId,
+		channelKeyHash:    batch.KeyHash,
+		consumeQuota:      true,
+		logConsumeEnabled: model.ResolveBoolOption("LogConsumeEnabled", group, batch.UserId, batch.TokenId, common.LogConsumeEnabled),
+	}
+	settlement.settle(modelName, usage.PromptTokens, usage.CompletionTokens, quota, logContent)
+	cost, priced := common.GetUpstreamCost(modelName, usage.PromptTokens, usage.CompletionTokens)
+	model.RecordChannelUsage(batch.ChannelId, modelName, usage.PromptTokens, usage.CompletionTokens, quota, cost*common.BatchRatio, priced)
+}
@@ -22,6 +22,8 @@ func GetSubscription(c *gin.Context) {
 		userId := c.GetInt("id")
 		remainQuota, err = model.GetUserQuota(userId)
 		usedQuota, err = model.GetUserUsedQuota(userId)
+		creditLimit, _ := model.GetUserCreditLimit(userId)
+		remainQuota += creditLimit
 	}
 	if expiredTime <= 0 {
 		expiredTime = 0
@@ -37,6 +39,7 @@ func GetSubscription(c *gin.Context) {
 		return
 	}
 	quota := remainQuota + usedQuota
+	// the OpenAI clients read these amounts as USD
 	amount := float64(quota)
 	if common.DisplayInCurrencyEnabled {
 		amount /= common.QuotaPerUnit
@@ -78,6 +81,7 @@ func GetUsage(c *gin.Context) {
 		})
 		return
 	}
+	// the OpenAI clients read these amounts as USD
 	amount := float64(quota)
 	if common.DisplayInCurrencyEnabled {
 		amount /= common.QuotaPerUnit
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the API version of the Azure channels which set none, it serves the chat, the embeddings and DALL·E 3
+const defaultAzureAPIVersion = "2024-02-01"
+
+// AzureDeployment is where a model is deployed on an Azure channel, an empty deployment is derived from the model
+// name and an empty API version falls back to the default of the channel
+type AzureDeployment struct {
+	Deployment string `json:"deployment,omitempty"`
+	APIVersion string `json:"api_version,omitempty"`
+}
+
+func parseAzureDeployments(deploymentsJSON string) (map[string]AzureDeployment, error) {
+	deployments := make(map[string]AzureDeployment)
+	if deploymentsJSON == "" {
+		return deployments, nil
+	}
+	err := json.Unmarshal([]byte(deploymentsJSON), &deployments)
+	return deployments, err
+}
+
+func isValidAzureDeployments(deploymentsJSON string) bool {
+	deployments, err := parseAzureDeployments(deploymentsJSON)
+	if err != nil {
+		return false
+	}
+	for _, deployment := range deployments {
+		if strings.ContainsAny(deployment.Deployment, "/?#") || strings.ContainsAny(deployment.APIVersion, "/?#&") {
+			return false
+		}
+	}
+	return true
+}
+
+// getAzureDeploymentName derives the deployment name from the model name, without the dots and the date suffixes,
+// see https://github.com/songquanpeng/one-api/issues/67
+func getAzureDeploymentName(modelName string) string {
+	deployment := strings.Replace(modelName, ".", "", -1)
+	deployment = strings.TrimSuffix(deployment, "-0301")
+	deployment = strings.TrimSuffix(deployment, "-0314")
+	deployment = strings.TrimSuffix(deployment, "-0613")
+	return deployment
+}
+
+// resolveAzureDeployment is the deployment and the API version of the model on the channel
+func resolveAzureDeployment(deploymentsJSON string, defaultAPIVersion string, modelName string) (string, string) {
+	deployment, apiVersion := getAzureDeploymentName(modelName), defaultAPIVersion
+	deployments, _ := parseAzureDeployments(deploymentsJSON)
+	if mapped, ok := deployments[modelName]; ok {
+		if mapped.Deployment != "" {
+			deployment = mapped.Deployment
+		}
+		if mapped.APIVersion != "" {
+			apiVersion = mapped.APIVersion
+		}
+	}
+	if apiVersion == "" {
+		apiVersion = defaultAzureAPIVersion
+	}
+	return deployment, apiVersion
+}
+
+// getAzureRequestURL is the URL of the task, such as chat/completions or images/generations, on the deployment of
+// the model, the api-version of the request takes precedence over the one of the channel,
+// see https://learn.microsoft.com/en-us/azure/ai-services/openai/reference
+func getAzureRequestURL(c *gin.Context, modelName string, task string) string {
+	deployment, apiVersion := resolveAzureDeployment(c.GetString("azure_deployments"), c.GetString("api_version"), modelName)
+	if requestAPIVersion := c.Query("api-version"); requestAPIVersion != "" {
+		apiVersion = requestAPIVersion
+	}
+	return fmt.Sprintf("%s/openai/deployments/%s/%s?api-version=%s", c.GetString("base_url"), url.PathEscape(deployment), task, url.QueryEscape(apiVersion))
+}
@@ -6,9 +6,11 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"net/url"
 	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -77,9 +79,64 @@ type APGC2DGPTUsageResponse struct {
 	TotalUsed      float64 `json:"total_used"`
 }
 
+type DeepSeekBalanceResponse struct {
+	IsAvailable  bool `json:"is_available"`
+	BalanceInfos []struct {
+		Currency     string `json:"currency"`
+		TotalBalance string `json:"total_balance"`
+	} `json:"balance_infos"`
+}
+
+type MoonshotBalanceResponse struct {
+	Status bool   `json:"status"`
+	Error  string `json:"error_msg"`
+	Data   struct {
+		AvailableBalance float64 `json:"available_balance"`
+	} `json:"data"`
+}
+
+type OpenRouterCreditsResponse struct {
+	Data struct {
+		TotalCredits float64 `json:"total_credits"`
+		TotalUsage   float64 `json:"total_usage"`
+	} `json:"data"`
+}
+
+type SiliconFlowUserInfoResponse struct {
+	Status  bool   `json:"status"`
+	Message string `json:"message"`
+	Data    struct {
+		TotalBalance string `json:"totalBalance"`
+	} `json:"data"`
+}
+
+type ChannelBalanceResult struct {
+	Id      int    `json:"id"`
+	Name    string `json:"name"`
+	Message string `json:"message"`
+}
+
+var errBalanceNotImplemented = errors.New("尚未实现")
+var errBalanceNotProvided = errors.New("该上游未提供余额查询接口")
+var errBalanceMultipleKeys = errors.New("多密钥渠道暂不支持查询余额")
+
+// the OpenAI compatible upstreams with a balance API of their own, told apart by the host of the base URL of the
+// channel, the balances in CNY are converted to USD
+var balanceUpdatersByHost = map[string]func(channel *model.Channel, origin string) (float64, error){
+	"api.deepseek.com":   updateChannelDeepSeekBalance,
+	"api.moonshot.cn":    updateChannelMoonshotBalance,
+	"api.moonshot.ai":    updateChannelMoonshotBalance,
+	"openrouter.ai":      updateChannelOpenRouterBalance,
+	"api.siliconflow.cn": updateChannelSiliconFlowBalance,
+}
+
 // GetAuthHeader get auth header
 func GetAuthHeader(token string) http.Header {
 	h := http.Header{}
+	if token == "" {
+		// the channels without a key, see setBearerAuth
+		return h
+	}
 	h.Add("Authorization", fmt.Sprintf("Bearer %s", token))
 	return h
 }
@@ -89,10 +146,11 @@ func GetResponseBody(method, url string, channel *model.Channel, headers http.He
 	if err != nil {
 		return nil, err
 	}
+	setChannelHeaders(req, channel.Headers)
 	for k := range headers {
 		req.Header.Add(k, headers.Get(k))
 	}
-	res, err := httpClient.Do(req)
+	res, err := getHTTPClient(channel.Proxy).Do(req)
 	if err != nil {
 		return nil, err
 	}
@@ -199,7 +257,97 @@ func updateChannelAIGC2DBalance(channel *model.Channel) (float64, error) {
 	return response.TotalAvailable, nil
 }
 
+func updateChannelDeepSeekBalance(channel *model.Channel, origin string) (float64, error) {
+	body, err := GetResponseBody("GET", origin+"/user/balance", channel, GetAuthHeader(channel.Key))
+	if err != nil {
+		return 0, err
+	}
+	response := DeepSeekBalanceResponse{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return 0, err
+	}
+	balance := 0.0
+	for _, info := range response.BalanceInfos {
+		total, err := strconv.ParseFloat(info.TotalBalance, 64)
+		if err != nil {
+			return 0, err
+		}
+		if info.Currency == "CNY" {
+			total /= common.GetCNYExchangeRate()
+		}
+		balance += total
+	}
+	if !response.IsAvailable {
+		balance = 0
+	}
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+func updateChannelMoonshotBalance(channel *model.Channel, origin string) (float64, error) {
+	body, err := GetResponseBody("GET", origin+"/v1/users/me/balance", channel, GetAuthHeader(channel.Key))
+	if err != nil {
+		return 0, err
+	}
+	response := MoonshotBalanceResponse{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Status {
+		return 0, errors.New(response.Error)
+	}
+	balance := response.Data.AvailableBalance
+	// the platform in China bills in CNY
+	if strings.HasSuffix(origin, ".cn") {
+		balance /= common.GetCNYExchangeRate()
+	}
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+func updateChannelOpenRouterBalance(channel *model.Channel, origin string) (float64, error) {
+	body, err := GetResponseBody("GET", origin+"/api/v1/credits", channel, GetAuthHeader(channel.Key))
+	if err != nil {
+		return 0, err
+	}
+	response := OpenRouterCreditsResponse{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return 0, err
+	}
+	balance := response.Data.TotalCredits - response.Data.TotalUsage
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
+func updateChannelSiliconFlowBalance(channel *model.Channel, origin string) (float64, error) {
+	body, err := GetResponseBody("GET", origin+"/v1/user/info", channel, GetAuthHeader(channel.Key))
+	if err != nil {
+		return 0, err
+	}
+	response := SiliconFlowUserInfoResponse{}
+	err = json.Unmarshal(body, &response)
+	if err != nil {
+		return 0, err
+	}
+	if !response.Status {
+		return 0, errors.New(response.Message)
+	}
+	balance, err := strconv.ParseFloat(response.Data.TotalBalance, 64)
+	if err != nil {
+		return 0, err
+	}
+	balance /= common.GetCNYExchangeRate()
+	channel.UpdateBalance(balance)
+	return balance, nil
+}
+
 func updateChannelBalance(channel *model.Channel) (float64, error) {
+	if len(channel.GetKeys()) > 1 {
+		return 0, errBalanceMultipleKeys
+	}
 	baseURL := common.ChannelBaseURLs[channel.Type]
 	if channel.BaseURL == "" {
 		channel.BaseURL = baseURL
@@ -210,7 +358,9 @@ func updateChannelBalance(channel *model.Channel) (float64, error) {
 			baseURL = channel.BaseURL
 		}
 	case common.ChannelTypeAzure:
-		return 0, errors.New("尚未实现")
+		return 0, errBalanceNotImplemented
+	case common.ChannelTypeAnthropic, common.ChannelTypeZhipu, common.ChannelTypeGemini, common.ChannelTypeBedrock, common.ChannelTypeLocal:
+		return 0, errBalanceNotProvided
 	case common.ChannelTypeCustom:
 		baseURL = channel.BaseURL
 	case common.ChannelTypeCloseAI:
@@ -224,7 +374,12 @@ func updateChannelBalance(channel *model.Channel) (float64, error) {
 	case common.ChannelTypeAIGC2D:
 		return updateChannelAIGC2DBalance(channel)
 	default:
-		return 0, errors.New("尚未实现")
+		return 0, errBalanceNotImplemented
+	}
+	if parsedURL, err := url.Parse(baseURL); err == nil {
+		if updateBalance, ok := balanceUpdatersByHost[parsedURL.Host]; ok {
+			return updateBalance(channel, parsedURL.Scheme+"://"+parsedURL.Host)
+		}
 	}
 	url := fmt.Sprintf("%s/v1/dashboard/billing/subscription", baseURL)
 
@@ -291,23 +446,27 @@ func UpdateChannelBalance(c *gin.Context) {
 	return
 }
 
-func updateAllChannelsBalance() error {
+// updateAllChannelsBalance queries the balances of all the enabled channels, the channels of which the balance can't be
+// queried are skipped
+func updateAllChannelsBalance() (updated int, skipped int, failed []ChannelBalanceResult, err error) {
 	channels, err := model.GetAllChannels(0, 0, true)
 	if err != nil {
-		return err
+		return 0, 0, nil, err
 	}
+	failed = make([]ChannelBalanceResult, 0)
 	for _, channel := range channels {
 		if channel.Status != common.ChannelStatusEnabled {
 			continue
 		}
-		// TODO: support Azure
-		if channel.Type != common.ChannelTypeOpenAI && channel.Type != common.ChannelTypeCustom {
-			continue
-		}
 		balance, err := updateChannelBalance(channel)
 		if err != nil {
-			continue
+			if errors.Is(err, errBalanceNotImplemented) || errors.Is(err, errBalanceNotProvided) || errors.Is(err, errBalanceMultipleKeys) {
+				skipped++
+				continue
+			}
+			failed = append(failed, ChannelBalanceResult{Id: channel.Id, Name: channel.Name, Message: err.Error()})
 		} else {
+			updated++
 			// err is nil & balance <= 0 means quota is used up
 			if balance <= 0 {
 				disableChannel(channel.Id, channel.Name, "余额不足")
@@ -315,12 +474,12 @@ func updateAllChannelsBalance() error {
 		}
 		time.Sleep(common.RequestInterval)
 	}
-	return nil
+	return updated, skipped, failed, nil
 }
 
 func UpdateAllChannelsBalance(c *gin.Context) {
 	// TODO: make it async
-	err := updateAllChannelsBalance()
+	updated, skipped, failed, err := updateAllChannelsBalance()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -331,6 +490,11 @@ func UpdateAllChannelsBalance(c *gin.Context) {
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
+		"data": gin.H{
+			"updated": updated,
+			"skipped": skipped,
+			"failed":  failed,
+		},
 	})
 	return
 }
@@ -339,7 +503,11 @@ func AutomaticallyUpdateChannels(frequency int) {
 	for {
 		time.Sleep(time.Duration(frequency) * time.Minute)
 		common.SysLog("updating all channels")
-		_ = updateAllChannelsBalance()
-		common.SysLog("channels update done")
+		updated, _, failed, err := updateAllChannelsBalance()
+		if err != nil {
+			common.SysError("failed to update channel balances: " + err.Error())
+			continue
+		}
+		common.SysLog(fmt.Sprintf("channels update done, %d updated, %d failed", updated, len(failed)))
 	}
 }
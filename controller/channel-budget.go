@@ -0,0 +1,72 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+// getChannelBudgetExcess tells which budget of the channel is used up, empty if none
+func getChannelBudgetExcess(channel *model.Channel) (string, error) {
+	if channel.GetDailyBudget() <= 0 && channel.GetMonthlyBudget() <= 0 {
+		return "", nil
+	}
+	daily, monthly, err := model.GetChannelSpend(channel.Id)
+	if err != nil {
+		return "", err
+	}
+	if channel.GetDailyBudget() > 0 && daily >= int64(channel.GetDailyBudget()) {
+		return fmt.Sprintf("今日已使用 %s，达到每日预算 %s", common.LogQuota(int(daily)), common.LogQuota(channel.GetDailyBudget())), nil
+	}
+	if channel.GetMonthlyBudget() > 0 && monthly >= int64(channel.GetMonthlyBudget()) {
+		return fmt.Sprintf("本月已使用 %s，达到每月预算 %s", common.LogQuota(int(monthly)), common.LogQuota(channel.GetMonthlyBudget())), nil
+	}
+	return "", nil
+}
+
+func notifyChannelBudget(subject string, content string) {
+	if common.RootUserEmail == "" {
+		common.RootUserEmail = model.GetRootUserEmail()
+	}
+	err := common.SendEmail(subject, common.RootUserEmail, content)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+	}
+}
+
+// checkChannelBudgets pauses the enabled channels which used up a budget and resumes the paused ones once their
+// budgets renew or are raised
+func checkChannelBudgets() {
+	channels, err := model.GetChannelsWithBudget()
+	if err != nil {
+		common.SysError("failed to get channels with budget: " + err.Error())
+		return
+	}
+	for _, channel := range channels {
+		excess, err := getChannelBudgetExcess(channel)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to get spend of channel #%d: %s", channel.Id, err.Error()))
+			continue
+		}
+		if channel.Status == common.ChannelStatusEnabled && excess != "" {
+			common.SysLog(fmt.Sprintf("channel #%d is over budget, pausing it", channel.Id))
+			model.UpdateChannelStatusById(channel.Id, common.ChannelStatusOverBudget)
+			notifyChannelBudget(fmt.Sprintf("通道「%s」（#%d）已超出预算", channel.Name, channel.Id),
+				fmt.Sprintf("通道「%s」（#%d）%s，已暂停使用，预算恢复后将自动启用", channel.Name, channel.Id, excess))
+		} else if channel.Status == common.ChannelStatusOverBudget && excess == "" {
+			common.SysLog(fmt.Sprintf("the budget of channel #%d renewed, resuming it", channel.Id))
+			model.UpdateChannelStatusById(channel.Id, common.ChannelStatusEnabled)
+			notifyChannelBudget(fmt.Sprintf("通道「%s」（#%d）已恢复使用", channel.Name, channel.Id),
+				fmt.Sprintf("通道「%s」（#%d）的预算已恢复，已被自动启用", channel.Name, channel.Id))
+		}
+	}
+}
+
+func AutomaticallyCheckChannelBudgets(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		model.FlushChannelSpends()
+		checkChannelBudgets()
+	}
+}
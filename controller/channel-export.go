@@ -0,0 +1,268 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"gopkg.in/yaml.v3"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ChannelConfig is the configuration of a channel which is replicated across instances, without the state of the
+// channel such as its balance or used quota
+type ChannelConfig struct {
+	Name               string `json:"name" yaml:"name"`
+	Type               int    `json:"type" yaml:"type"`
+	Key                string `json:"key,omitempty" yaml:"key,omitempty"` // empty when redacted
+	Status             int    `json:"status" yaml:"status"`
+	Weight             int    `json:"weight" yaml:"weight"`
+	Priority           *int64 `json:"priority" yaml:"priority"`
+	BaseURL            string `json:"base_url,omitempty" yaml:"base_url,omitempty"`
+	Other              string `json:"other,omitempty" yaml:"other,omitempty"`
+	Models             string `json:"models" yaml:"models"`
+	Group              string `json:"group" yaml:"group"`
+	ModelMapping       string `json:"model_mapping,omitempty" yaml:"model_mapping,omitempty"`
+	Region             string `json:"region,omitempty" yaml:"region,omitempty"`
+	KeyStrategy        string `json:"key_strategy,omitempty" yaml:"key_strategy,omitempty"`
+	RPM                *int   `json:"rpm" yaml:"rpm"`
+	TPM                *int   `json:"tpm" yaml:"tpm"`
+	ModelRateLimits    string `json:"model_rate_limits,omitempty" yaml:"model_rate_limits,omitempty"`
+	DailyBudget        *int   `json:"daily_budget" yaml:"daily_budget"`
+	MonthlyBudget      *int   `json:"monthly_budget" yaml:"monthly_budget"`
+	Proxy              string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	AutoSyncModels     *bool  `json:"auto_sync_models" yaml:"auto_sync_models"`
+	MaintenanceWindows string `json:"maintenance_windows,omitempty" yaml:"maintenance_windows,omitempty"`
+	Headers            string `json:"headers,omitempty" yaml:"headers,omitempty"`
+	StripHeaders       string `json:"strip_headers,omitempty" yaml:"strip_headers,omitempty"`
+	TestPayload        string `json:"test_payload,omitempty" yaml:"test_payload,omitempty"`
+	TimeoutPolicy      string `json:"timeout_policy,omitempty" yaml:"timeout_policy,omitempty"`
+	AzureDeployments   string `json:"azure_deployments,omitempty" yaml:"azure_deployments,omitempty"`
+	SystemPrompt       string `json:"system_prompt,omitempty" yaml:"system_prompt,omitempty"`
+	SystemPromptMode   string `json:"system_prompt_mode,omitempty" yaml:"system_prompt_mode,omitempty"`
+}
+
+func newChannelConfig(channel *model.Channel, redactKeys bool) ChannelConfig {
+	config := ChannelConfig{
+		Name:               channel.Name,
+		Type:               channel.Type,
+		Key:                channel.Key,
+		Status:             channel.Status,
+		Weight:             channel.Weight,
+		Priority:           channel.Priority,
+		BaseURL:            channel.BaseURL,
+		Other:              channel.Other,
+		Models:             channel.Models,
+		Group:              channel.Group,
+		ModelMapping:       channel.ModelMapping,
+		Region:             channel.Region,
+		KeyStrategy:        channel.KeyStrategy,
+		RPM:                channel.RPM,
+		TPM:                channel.TPM,
+		ModelRateLimits:    channel.ModelRateLimits,
+		DailyBudget:        channel.DailyBudget,
+		MonthlyBudget:      channel.MonthlyBudget,
+		Proxy:              channel.Proxy,
+		AutoSyncModels:     channel.AutoSyncModels,
+		MaintenanceWindows: channel.MaintenanceWindows,
+		Headers:            channel.Headers,
+		StripHeaders:       channel.StripHeaders,
+		TestPayload:        channel.TestPayload,
+		TimeoutPolicy:      channel.TimeoutPolicy,
+		AzureDeployments:   channel.AzureDeployments,
+		SystemPrompt:       channel.SystemPrompt,
+		SystemPromptMode:   channel.SystemPromptMode,
+	}
+	if redactKeys {
+		config.Key = ""
+	}
+	return config
+}
+
+func (config *ChannelConfig) toChannel() model.Channel {
+	channel := model.Channel{
+		Name:               config.Name,
+		Type:               config.Type,
+		Key:                config.Key,
+		Status:             config.Status,
+		Weight:             config.Weight,
+		Priority:           config.Priority,
+		BaseURL:            config.BaseURL,
+		Other:              config.Other,
+		Models:             config.Models,
+		Group:              config.Group,
+		ModelMapping:       config.ModelMapping,
+		Region:             config.Region,
+		KeyStrategy:        config.KeyStrategy,
+		RPM:                config.RPM,
+		TPM:                config.TPM,
+		ModelRateLimits:    config.ModelRateLimits,
+		DailyBudget:        config.DailyBudget,
+		MonthlyBudget:      config.MonthlyBudget,
+		Proxy:              config.Proxy,
+		AutoSyncModels:     config.AutoSyncModels,
+		MaintenanceWindows: config.MaintenanceWindows,
+		Headers:            config.Headers,
+		StripHeaders:       config.StripHeaders,
+		TestPayload:        config.TestPayload,
+		TimeoutPolicy:      config.TimeoutPolicy,
+		AzureDeployments:   config.AzureDeployments,
+		SystemPrompt:       config.SystemPrompt,
+		SystemPromptMode:   config.SystemPromptMode,
+	}
+	if channel.Status == 0 {
+		channel.Status = common.ChannelStatusEnabled
+	}
+	if channel.Group == "" {
+		channel.Group = "default"
+	}
+	return channel
+}
+
+// ExportChannels downloads the configurations of all the channels in JSON or YAML, with the keys unless redact_keys
+// is set
+func ExportChannels(c *gin.Context) {
+	channels, err := model.GetAllChannels(0, 0, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Id < channels[j].Id })
+	redactKeys := c.Query("redact_keys") == "true"
+	configs := make([]ChannelConfig, 0, len(channels))
+	for _, channel := range channels {
+		configs = append(configs, newChannelConfig(channel, redactKeys))
+	}
+	filename := fmt.Sprintf("channels-%s", time.Now().Format("20060102150405"))
+	var data []byte
+	var contentType string
+	switch c.Query("format") {
+	case "yaml":
+		data, err = yaml.Marshal(configs)
+		contentType = "application/yaml; charset=utf-8"
+		filename += ".yaml"
+	default:
+		data, err = json.MarshalIndent(configs, "", "  ")
+		contentType = "application/json; charset=utf-8"
+		filename += ".json"
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s", filename))
+	c.Data(http.StatusOK, contentType, data)
+}
+
+// ImportChannels adds the channels of an export in JSON or YAML, with overwrite set the channels of the same name
+// are updated instead, keeping their keys if the key was redacted
+func ImportChannels(c *gin.Context) {
+	body, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	var configs []ChannelConfig
+	if c.Query("format") == "yaml" || strings.Contains(c.ContentType(), "yaml") {
+		err = yaml.Unmarshal(body, &configs)
+	} else {
+		err = json.Unmarshal(body, &configs)
+	}
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无法解析导入的渠道：" + err.Error(),
+		})
+		return
+	}
+	existingChannels := make(map[string][]*model.Channel)
+	overwrite := c.Query("overwrite") == "true"
+	if overwrite {
+		channels, err := model.GetAllChannels(0, 0, true)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		for _, channel := range channels {
+			existingChannels[channel.Name] = append(existingChannels[channel.Name], channel)
+		}
+	}
+	// everything is checked before any change so that a bad entry does not leave a partial import
+	newChannels := make([]model.Channel, 0)
+	updatedChannels := make([]model.Channel, 0)
+	overwritten := make(map[string]bool)
+	for i, config := range configs {
+		channel := config.toChannel()
+		message := validateChannel(&channel)
+		if message == "" && overwrite && len(existingChannels[channel.Name]) > 1 {
+			message = "存在多个同名的渠道，无法覆盖"
+		}
+		if message == "" && overwrite && len(existingChannels[channel.Name]) == 1 {
+			if overwritten[channel.Name] {
+				message = "导入的渠道中存在重复的名称，无法覆盖"
+			}
+			overwritten[channel.Name] = true
+			channel.Id = existingChannels[channel.Name][0].Id
+		} else if message == "" && strings.TrimSpace(channel.Key) == "" {
+			message = "缺少密钥"
+		}
+		if message != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": fmt.Sprintf("第 %d 个渠道「%s」：%s", i+1, channel.Name, message),
+			})
+			return
+		}
+		if channel.Id != 0 {
+			updatedChannels = append(updatedChannels, channel)
+		} else {
+			channel.CreatedTime = common.GetTimestamp()
+			newChannels = append(newChannels, channel)
+		}
+	}
+	if len(newChannels) > 0 {
+		err = model.BatchInsertChannels(newChannels)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	for _, channel := range updatedChannels {
+		err = channel.Update()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"created": len(newChannels),
+			"updated": len(updatedChannels),
+		},
+	})
+}
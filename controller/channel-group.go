@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllChannelGroups(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	groups, err := model.GetAllChannelGroups(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    groups,
+	})
+	return
+}
+
+func GetChannelGroup(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	group, err := model.GetChannelGroupById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    group,
+	})
+	return
+}
+
+func validateChannelGroup(group *model.ChannelGroup) error {
+	if group.Name == "" || len(group.Name) > 32 {
+		return errors.New("渠道组名称不能为空且不能超过 32 个字符")
+	}
+	if group.Strategy != "" && !common.IsValidRoutingMode(group.Strategy) {
+		return errors.New("负载均衡策略只能为 weighted 或 latency")
+	}
+	channelIds, err := model.ParseChannelGroupChannelIds(group.ChannelIds)
+	if err != nil {
+		return err
+	}
+	for id := range channelIds {
+		if _, err := model.GetChannelById(id, false); err != nil {
+			return fmt.Errorf("渠道 %d 不存在", id)
+		}
+	}
+	return nil
+}
+
+func AddChannelGroup(c *gin.Context) {
+	group := model.ChannelGroup{}
+	err := c.ShouldBindJSON(&group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateChannelGroup(&group); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanGroup := model.ChannelGroup{
+		Name:        group.Name,
+		Strategy:    group.Strategy,
+		ChannelIds:  group.ChannelIds,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = cleanGroup.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanGroup,
+	})
+	return
+}
+
+func UpdateChannelGroup(c *gin.Context) {
+	group := model.ChannelGroup{}
+	err := c.ShouldBindJSON(&group)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateChannelGroup(&group); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanGroup, err := model.GetChannelGroupById(group.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanGroup.Name = group.Name
+	cleanGroup.Strategy = group.Strategy
+	cleanGroup.ChannelIds = group.ChannelIds
+	err = cleanGroup.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanGroup,
+	})
+	return
+}
+
+func DeleteChannelGroup(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	group := model.ChannelGroup{Id: id}
+	err := group.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
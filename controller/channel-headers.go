@@ -0,0 +1,66 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the headers One API manages itself, a channel can't override them
+var reservedChannelHeaders = map[string]bool{
+	"Authorization":  true,
+	"Content-Length": true,
+	"Host":           true,
+}
+
+func parseChannelHeaders(headers string) (map[string]string, error) {
+	channelHeaders := make(map[string]string)
+	if headers == "" {
+		return channelHeaders, nil
+	}
+	err := json.Unmarshal([]byte(headers), &channelHeaders)
+	return channelHeaders, err
+}
+
+func isValidChannelHeaders(headers string) bool {
+	channelHeaders, err := parseChannelHeaders(headers)
+	if err != nil {
+		return false
+	}
+	for key := range channelHeaders {
+		if key == "" || reservedChannelHeaders[http.CanonicalHeaderKey(key)] {
+			return false
+		}
+	}
+	return true
+}
+
+// setChannelHeaders adds the extra upstream headers of the channel, such as OpenAI-Organization
+func setChannelHeaders(req *http.Request, headers string) {
+	channelHeaders, err := parseChannelHeaders(headers)
+	if err != nil {
+		return
+	}
+	for key, value := range channelHeaders {
+		if !reservedChannelHeaders[http.CanonicalHeaderKey(key)] {
+			req.Header.Set(key, value)
+		}
+	}
+}
+
+// copyResponseHeaders passes the headers of the upstream response to the client, except the ones the channel strips
+func copyResponseHeaders(c *gin.Context, resp *http.Response) {
+	stripped := make(map[string]bool)
+	for _, key := range strings.Split(c.GetString("strip_headers"), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			stripped[http.CanonicalHeaderKey(key)] = true
+		}
+	}
+	for k, v := range resp.Header {
+		if !stripped[http.CanonicalHeaderKey(k)] {
+			c.Writer.Header().Set(k, v[0])
+		}
+	}
+}
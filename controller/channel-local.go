@@ -0,0 +1,47 @@
+package controller
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"one-api/model"
+)
+
+// the self-hosted backends, such as Ollama, vLLM and LM Studio, serve the OpenAI API on a base URL of their own and
+// may run without authentication, so their channels can go without a key
+
+type ollamaTagList struct {
+	Models []struct {
+		Name string `json:"name"`
+	} `json:"models"`
+}
+
+// setBearerAuth leaves out the Authorization header for the channels without a key
+func setBearerAuth(req *http.Request, key string) {
+	if key == "" {
+		req.Header.Del("Authorization")
+		return
+	}
+	req.Header.Set("Authorization", "Bearer "+key)
+}
+
+// fetchOllamaModels lists the models of an Ollama server too old to have the OpenAI compatible model list
+func fetchOllamaModels(channel *model.Channel, baseURL string) (map[string]string, error) {
+	key, _ := model.PickChannelKey(channel)
+	body, err := GetResponseBody("GET", fmt.Sprintf("%s/api/tags", baseURL), channel, GetAuthHeader(key))
+	if err != nil {
+		return nil, err
+	}
+	var list ollamaTagList
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	models := make(map[string]string, len(list.Models))
+	for _, item := range list.Models {
+		if item.Name != "" {
+			models[item.Name] = "ollama"
+		}
+	}
+	return models, nil
+}
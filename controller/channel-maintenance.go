@@ -0,0 +1,36 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"time"
+)
+
+// checkChannelMaintenance pauses the enabled channels entering one of their maintenance windows and resumes the
+// paused ones once out of all their windows, the channels disabled otherwise are left alone
+func checkChannelMaintenance() {
+	channels, err := model.GetChannelsWithMaintenanceWindows()
+	if err != nil {
+		common.SysError("failed to get channels with maintenance windows: " + err.Error())
+		return
+	}
+	now := time.Now()
+	for _, channel := range channels {
+		inMaintenance := channel.IsInMaintenance(now)
+		if channel.Status == common.ChannelStatusEnabled && inMaintenance {
+			common.SysLog(fmt.Sprintf("channel #%d entered a maintenance window, pausing it", channel.Id))
+			model.UpdateChannelStatusById(channel.Id, common.ChannelStatusMaintenance)
+		} else if channel.Status == common.ChannelStatusMaintenance && !inMaintenance {
+			common.SysLog(fmt.Sprintf("the maintenance window of channel #%d ended, resuming it", channel.Id))
+			model.UpdateChannelStatusById(channel.Id, common.ChannelStatusEnabled)
+		}
+	}
+}
+
+func AutomaticallyCheckChannelMaintenance(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		checkChannelMaintenance()
+	}
+}
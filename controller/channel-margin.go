@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ChannelMargin compares what the users were billed on a channel for a model with the estimated upstream cost
+type ChannelMargin struct {
+	ChannelId        int     `json:"channel_id"`
+	ChannelName      string  `json:"channel_name"`
+	ModelName        string  `json:"model_name"`
+	Requests         int     `json:"requests"`
+	PromptTokens     int64   `json:"prompt_tokens"`
+	CompletionTokens int64   `json:"completion_tokens"`
+	Quota            int64   `json:"quota"`
+	Revenue          float64 `json:"revenue"` // in USD, the quota billed
+	Cost             float64 `json:"cost"`    // in USD, only of the priced requests
+	Margin           float64 `json:"margin"`
+	MarginRate       float64 `json:"margin_rate"`       // of the revenue, 0 without revenue
+	UnpricedRequests int     `json:"unpriced_requests"` // the model has no upstream price, so the margin is overestimated
+}
+
+// GetChannelMargins reports the margins of the current month by default, start_day and end_day are like 2006-01-02
+func GetChannelMargins(c *gin.Context) {
+	now := time.Now()
+	startDay := c.DefaultQuery("start_day", now.Format("2006-01")+"-01")
+	endDay := c.DefaultQuery("end_day", now.Format("2006-01-02"))
+	if _, err := time.Parse("2006-01-02", startDay); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的开始日期",
+		})
+		return
+	}
+	if _, err := time.Parse("2006-01-02", endDay); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的结束日期",
+		})
+		return
+	}
+	model.FlushChannelUsages()
+	usages, err := model.GetChannelUsages(startDay, endDay)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channels, err := model.GetAllChannels(0, 0, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channelNames := make(map[int]string, len(channels))
+	for _, channel := range channels {
+		channelNames[channel.Id] = channel.Name
+	}
+	margins := make([]*ChannelMargin, 0, len(usages))
+	for _, usage := range usages {
+		margin := &ChannelMargin{
+			ChannelId:        usage.ChannelId,
+			ChannelName:      channelNames[usage.ChannelId],
+			ModelName:        usage.ModelName,
+			Requests:         usage.Requests,
+			PromptTokens:     usage.PromptTokens,
+			CompletionTokens: usage.CompletionTokens,
+			Quota:            usage.Quota,
+			Revenue:          float64(usage.Quota) / common.QuotaPerUnit,
+			Cost:             usage.Cost,
+			UnpricedRequests: usage.UnpricedRequests,
+		}
+		margin.Margin = margin.Revenue - margin.Cost
+		if margin.Revenue > 0 {
+			margin.MarginRate = margin.Margin / margin.Revenue
+		}
+		margins = append(margins, margin)
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    margins,
+	})
+	return
+}
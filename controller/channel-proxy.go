@@ -0,0 +1,105 @@
+package controller
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+type transportKey struct {
+	proxy                 string
+	connectTimeout        time.Duration
+	responseHeaderTimeout time.Duration
+}
+
+var proxyTransportLock sync.Mutex
+var proxyTransports = make(map[transportKey]*http.Transport)
+
+func parseChannelProxy(proxy string) (*url.URL, error) {
+	proxyURL, err := url.Parse(proxy)
+	if err != nil {
+		return nil, err
+	}
+	switch proxyURL.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, errors.New("unsupported proxy scheme: " + proxyURL.Scheme)
+	}
+	if proxyURL.Host == "" {
+		return nil, errors.New("proxy host is empty")
+	}
+	return proxyURL, nil
+}
+
+func isValidChannelProxy(proxy string) bool {
+	if proxy == "" {
+		return true
+	}
+	_, err := parseChannelProxy(proxy)
+	return err == nil
+}
+
+// getTransport shares the connections of the channels with the same proxy and timeouts, the requests fail rather than
+// leaving without the proxy if it is invalid
+func getTransport(proxy string, connectTimeout time.Duration, responseHeaderTimeout time.Duration) *http.Transport {
+	key := transportKey{proxy: proxy, connectTimeout: connectTimeout, responseHeaderTimeout: responseHeaderTimeout}
+	proxyTransportLock.Lock()
+	defer proxyTransportLock.Unlock()
+	if transport, ok := proxyTransports[key]; ok {
+		return transport
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if proxy != "" {
+		proxyURL, err := parseChannelProxy(proxy)
+		if err != nil {
+			common.SysError("invalid channel proxy: " + err.Error())
+			transport.Proxy = func(*http.Request) (*url.URL, error) {
+				return nil, err
+			}
+		} else {
+			transport.Proxy = http.ProxyURL(proxyURL)
+		}
+	}
+	if connectTimeout != 0 {
+		transport.DialContext = (&net.Dialer{Timeout: connectTimeout, KeepAlive: 30 * time.Second}).DialContext
+		transport.TLSHandshakeTimeout = connectTimeout
+	}
+	transport.ResponseHeaderTimeout = responseHeaderTimeout
+	proxyTransports[key] = transport
+	return transport
+}
+
+func getProxyTransport(proxy string) *http.Transport {
+	return getTransport(proxy, 0, 0)
+}
+
+// getHTTPClient is the client for the upstream calls of a channel, through the proxy of the channel if it has one
+func getHTTPClient(proxy string) *http.Client {
+	if proxy == "" {
+		return httpClient
+	}
+	return &http.Client{Transport: getProxyTransport(proxy)}
+}
+
+func getImpatientHTTPClient(proxy string) *http.Client {
+	if proxy == "" {
+		return impatientHTTPClient
+	}
+	return &http.Client{Transport: getProxyTransport(proxy), Timeout: impatientHTTPClient.Timeout}
+}
+
+func getWebsocketDialer(proxy string) *websocket.Dialer {
+	dialer := &websocket.Dialer{
+		HandshakeTimeout: 5 * time.Second,
+	}
+	if proxy != "" {
+		dialer.Proxy = getProxyTransport(proxy).Proxy
+	}
+	return dialer
+}
@@ -1,20 +1,54 @@
 package controller
 
 import (
+	"bufio"
 	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
+	"net/url"
 	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 )
 
-func testChannel(channel *model.Channel, request ChatRequest) (error, *OpenAIError) {
+var errChannelTestNotSupported = errors.New("该渠道类型当前版本不支持测试，请手动测试")
+
+// buildTestRequest is the test payload of the channel, a chat completions request in JSON which defaults to asking
+// for a single token, the model is filled in when the payload leaves it out
+func buildTestRequest(channel *model.Channel) (map[string]any, error) {
+	request := map[string]any{
+		"messages":   []Message{{Role: "user", Content: "hi"}},
+		"max_tokens": 1,
+	}
+	if channel.TestPayload != "" {
+		request = make(map[string]any)
+		err := json.Unmarshal([]byte(channel.TestPayload), &request)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if model, ok := request["model"].(string); !ok || model == "" {
+		if channel.Type == common.ChannelTypeAzure {
+			request["model"] = "gpt-35-turbo"
+		} else if channel.Type == common.ChannelTypeLocal && channel.Models != "" {
+			// the local backends only serve the models they have pulled
+			request["model"] = strings.Split(channel.Models, ",")[0]
+		} else {
+			request["model"] = "gpt-3.5-turbo"
+		}
+	}
+	return request, nil
+}
+
+// testChannel sends the test payload of the channel and fills in the report with the status code, the model the
+// upstream answered with and whether the payload was streamed
+func testChannel(channel *model.Channel, report *model.ChannelProbe) (error, *OpenAIError) {
 	switch channel.Type {
 	case common.ChannelTypePaLM:
 		fallthrough
@@ -25,15 +59,22 @@ func testChannel(channel *model.Channel, request ChatRequest) (error, *OpenAIErr
 	case common.ChannelTypeZhipu:
 		fallthrough
 	case common.ChannelTypeXunfei:
-		return errors.New("该渠道类型当前版本不支持测试，请手动测试"), nil
-	case common.ChannelTypeAzure:
-		request.Model = "gpt-35-turbo"
-	default:
-		request.Model = "gpt-3.5-turbo"
+		fallthrough
+	case common.ChannelTypeGemini:
+		fallthrough
+	case common.ChannelTypeBedrock:
+		return errChannelTestNotSupported, nil
 	}
+	request, err := buildTestRequest(channel)
+	if err != nil {
+		return err, nil
+	}
+	report.Stream, _ = request["stream"].(bool)
 	requestURL := common.ChannelBaseURLs[channel.Type]
 	if channel.Type == common.ChannelTypeAzure {
-		requestURL = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=2023-03-15-preview", channel.BaseURL, request.Model)
+		modelName, _ := request["model"].(string)
+		deployment, apiVersion := resolveAzureDeployment(channel.AzureDeployments, channel.Other, modelName)
+		requestURL = fmt.Sprintf("%s/openai/deployments/%s/chat/completions?api-version=%s", channel.BaseURL, url.PathEscape(deployment), url.QueryEscape(apiVersion))
 	} else {
 		if channel.BaseURL != "" {
 			requestURL = channel.BaseURL
@@ -49,39 +90,69 @@ func testChannel(channel *model.Channel, request ChatRequest) (error, *OpenAIErr
 	if err != nil {
 		return err, nil
 	}
+	key, _ := model.PickChannelKey(channel)
 	if channel.Type == common.ChannelTypeAzure {
-		req.Header.Set("api-key", channel.Key)
+		req.Header.Set("api-key", key)
 	} else {
-		req.Header.Set("Authorization", "Bearer "+channel.Key)
+		setBearerAuth(req, key)
 	}
 	req.Header.Set("Content-Type", "application/json")
-	resp, err := httpClient.Do(req)
+	setChannelHeaders(req, channel.Headers)
+	resp, err := getHTTPClient(channel.Proxy).Do(req)
 	if err != nil {
 		return err, nil
 	}
 	defer resp.Body.Close()
-	var response TextResponse
+	report.StatusCode = resp.StatusCode
+	if report.Stream && strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream") {
+		return readTestStream(resp, report), nil
+	}
+	var response struct {
+		TextResponse
+		Model string `json:"model"`
+	}
 	err = json.NewDecoder(resp.Body).Decode(&response)
 	if err != nil {
 		return err, nil
 	}
+	report.Model = response.Model
 	if response.Usage.CompletionTokens == 0 {
 		return errors.New(fmt.Sprintf("type %s, code %v, message %s", response.Error.Type, response.Error.Code, response.Error.Message)), &response.Error
 	}
+	if report.Stream {
+		return errors.New("上游未返回流式响应"), nil
+	}
 	return nil, nil
 }
 
-func buildTestRequest() *ChatRequest {
-	testRequest := &ChatRequest{
-		Model:     "", // this will be set later
-		MaxTokens: 1,
+// readTestStream reads the stream of a test request, it succeeds once a chunk has choices
+func readTestStream(resp *http.Response, report *model.ChannelProbe) error {
+	chunks := 0
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		data := scanner.Text()
+		if !strings.HasPrefix(data, "data: ") || strings.HasPrefix(data[6:], "[DONE]") {
+			continue
+		}
+		var streamResponse ChatCompletionsStreamResponse
+		err := json.Unmarshal([]byte(data[6:]), &streamResponse)
+		if err != nil {
+			return err
+		}
+		if streamResponse.Model != "" {
+			report.Model = streamResponse.Model
+		}
+		if len(streamResponse.Choices) > 0 {
+			chunks++
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
 	}
-	testMessage := Message{
-		Role:    "user",
-		Content: "hi",
+	if chunks == 0 {
+		return errors.New("流式响应中没有内容")
 	}
-	testRequest.Messages = append(testRequest.Messages, testMessage)
-	return testRequest
+	return nil
 }
 
 func TestChannel(c *gin.Context) {
@@ -101,18 +172,27 @@ func TestChannel(c *gin.Context) {
 		})
 		return
 	}
-	testRequest := buildTestRequest()
+	probe := &model.ChannelProbe{ChannelId: channel.Id, Status: channel.Status}
 	tik := time.Now()
-	err, _ = testChannel(channel, *testRequest)
+	err, _ = testChannel(channel, probe)
 	tok := time.Now()
 	milliseconds := tok.Sub(tik).Milliseconds()
 	go channel.UpdateResponseTime(milliseconds)
+	probe.Success = err == nil
+	probe.ResponseTime = int(milliseconds)
+	if err != nil {
+		probe.Message = err.Error()
+	}
+	if err != errChannelTestNotSupported {
+		model.RecordChannelProbe(probe)
+	}
 	consumedTime := float64(milliseconds) / 1000.0
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
 			"message": err.Error(),
 			"time":    consumedTime,
+			"data":    probe,
 		})
 		return
 	}
@@ -120,6 +200,32 @@ func TestChannel(c *gin.Context) {
 		"success": true,
 		"message": "",
 		"time":    consumedTime,
+		"data":    probe,
+	})
+	return
+}
+
+func GetChannelProbes(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	probes, err := model.GetChannelProbes(id, model.MaxChannelProbesPerChannel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    probes,
 	})
 	return
 }
@@ -132,7 +238,7 @@ func disableChannel(channelId int, channelName string, reason string) {
 	if common.RootUserEmail == "" {
 		common.RootUserEmail = model.GetRootUserEmail()
 	}
-	model.UpdateChannelStatusById(channelId, common.ChannelStatusDisabled)
+	model.UpdateChannelStatusById(channelId, common.ChannelStatusAutoDisabled)
 	subject := fmt.Sprintf("通道「%s」（#%d）已被禁用", channelName, channelId)
 	content := fmt.Sprintf("通道「%s」（#%d）已被禁用，原因：%s", channelName, channelId, reason)
 	err := common.SendEmail(subject, common.RootUserEmail, content)
@@ -141,6 +247,70 @@ func disableChannel(channelId int, channelName string, reason string) {
 	}
 }
 
+// enable & notify
+func enableChannel(channelId int, channelName string) {
+	if common.RootUserEmail == "" {
+		common.RootUserEmail = model.GetRootUserEmail()
+	}
+	model.UpdateChannelStatusById(channelId, common.ChannelStatusEnabled)
+	model.EnableChannelKeys(channelId)
+	subject := fmt.Sprintf("通道「%s」（#%d）已被启用", channelName, channelId)
+	content := fmt.Sprintf("通道「%s」（#%d）已连续 %d 次测试成功，已被自动启用", channelName, channelId, common.ChannelProbeRecoveryThreshold)
+	err := common.SendEmail(subject, common.RootUserEmail, content)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+	}
+}
+
+// probeChannel tests the channel and records the result in its history, a channel is disabled after
+// ChannelProbeFailureThreshold failures in a row and enabled again after ChannelProbeRecoveryThreshold
+// successes in a row if it was disabled automatically
+func probeChannel(channel *model.Channel, disableThreshold int64) {
+	probe := &model.ChannelProbe{ChannelId: channel.Id, Status: channel.Status}
+	tik := time.Now()
+	err, openaiErr := testChannel(channel, probe)
+	tok := time.Now()
+	milliseconds := tok.Sub(tik).Milliseconds()
+	if err == errChannelTestNotSupported {
+		return
+	}
+	disable := false
+	if milliseconds > disableThreshold {
+		err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
+		disable = true
+	}
+	if shouldDisableChannel(openaiErr) {
+		disable = true
+	}
+	probe.Success = err == nil
+	probe.ResponseTime = int(milliseconds)
+	if err != nil {
+		probe.Message = err.Error()
+	}
+	// the previous results in a row, this one included
+	count, countErr := model.CountConsecutiveChannelProbes(channel.Id, probe.Success, common.ChannelProbeFailureThreshold+common.ChannelProbeRecoveryThreshold)
+	if countErr != nil {
+		common.SysError(fmt.Sprintf("failed to get probes of channel #%d: %s", channel.Id, countErr.Error()))
+	}
+	count++
+	if err != nil && channel.Status == common.ChannelStatusEnabled {
+		if !disable && common.AutomaticDisableChannelEnabled && common.ChannelProbeFailureThreshold > 0 && count >= common.ChannelProbeFailureThreshold {
+			err = errors.New(fmt.Sprintf("连续 %d 次测试失败，最近一次：%s", count, err.Error()))
+			disable = true
+		}
+		if disable {
+			disableChannel(channel.Id, channel.Name, err.Error())
+			probe.Status = common.ChannelStatusAutoDisabled
+		}
+	}
+	if err == nil && channel.Status == common.ChannelStatusAutoDisabled && common.AutomaticEnableChannelEnabled && count >= common.ChannelProbeRecoveryThreshold {
+		enableChannel(channel.Id, channel.Name)
+		probe.Status = common.ChannelStatusEnabled
+	}
+	model.RecordChannelProbe(probe)
+	channel.UpdateResponseTime(milliseconds)
+}
+
 func testAllChannels(notify bool) error {
 	if common.RootUserEmail == "" {
 		common.RootUserEmail = model.GetRootUserEmail()
@@ -156,28 +326,17 @@ func testAllChannels(notify bool) error {
 	if err != nil {
 		return err
 	}
-	testRequest := buildTestRequest()
 	var disableThreshold = int64(common.ChannelDisableThreshold * 1000)
 	if disableThreshold == 0 {
 		disableThreshold = 10000000 // a impossible value
 	}
 	go func() {
 		for _, channel := range channels {
-			if channel.Status != common.ChannelStatusEnabled {
+			// the channels disabled by hand are left alone
+			if channel.Status != common.ChannelStatusEnabled && !(channel.Status == common.ChannelStatusAutoDisabled && common.AutomaticEnableChannelEnabled) {
 				continue
 			}
-			tik := time.Now()
-			err, openaiErr := testChannel(channel, *testRequest)
-			tok := time.Now()
-			milliseconds := tok.Sub(tik).Milliseconds()
-			if milliseconds > disableThreshold {
-				err = errors.New(fmt.Sprintf("响应时间 %.2fs 超过阈值 %.2fs", float64(milliseconds)/1000.0, float64(disableThreshold)/1000.0))
-				disableChannel(channel.Id, channel.Name, err.Error())
-			}
-			if shouldDisableChannel(openaiErr) {
-				disableChannel(channel.Id, channel.Name, err.Error())
-			}
-			channel.UpdateResponseTime(milliseconds)
+			probeChannel(channel, disableThreshold)
 			time.Sleep(common.RequestInterval)
 		}
 		testAllChannelsLock.Lock()
@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the most retries of a request with the same channel, beyond it the upstream is better failed over
+const maxChannelRetryTimes = 5
+
+// ChannelTimeoutPolicy is how long the upstream calls of a channel may take, in seconds, and how many times a failed
+// request is retried with the channel before failing over, the zero values keep the defaults, that is no timeout and
+// no retry, and the policies of the models override the fields they set
+type ChannelTimeoutPolicy struct {
+	ConnectTimeout        int                              `json:"connect_timeout,omitempty"`
+	ResponseHeaderTimeout int                              `json:"response_header_timeout,omitempty"`
+	Timeout               int                              `json:"timeout,omitempty"` // the overall deadline, reading the stream included
+	RetryTimes            int                              `json:"retry_times,omitempty"`
+	Models                map[string]*ChannelTimeoutPolicy `json:"models,omitempty"`
+}
+
+func parseChannelTimeoutPolicy(policyJSON string) (*ChannelTimeoutPolicy, error) {
+	policy := &ChannelTimeoutPolicy{}
+	if policyJSON == "" {
+		return policy, nil
+	}
+	err := json.Unmarshal([]byte(policyJSON), policy)
+	return policy, err
+}
+
+func (policy *ChannelTimeoutPolicy) isValid() bool {
+	return policy.ConnectTimeout >= 0 && policy.ResponseHeaderTimeout >= 0 && policy.Timeout >= 0 &&
+		policy.RetryTimes >= 0 && policy.RetryTimes <= maxChannelRetryTimes
+}
+
+func isValidChannelTimeoutPolicy(policyJSON string) bool {
+	policy, err := parseChannelTimeoutPolicy(policyJSON)
+	if err != nil || !policy.isValid() {
+		return false
+	}
+	for _, modelPolicy := range policy.Models {
+		if modelPolicy == nil || !modelPolicy.isValid() || len(modelPolicy.Models) > 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// forModel is the policy of the channel with the fields the policy of the model sets overridden
+func (policy *ChannelTimeoutPolicy) forModel(modelName string) ChannelTimeoutPolicy {
+	resolved := *policy
+	resolved.Models = nil
+	modelPolicy, ok := policy.Models[modelName]
+	if !ok || modelPolicy == nil {
+		return resolved
+	}
+	if modelPolicy.ConnectTimeout != 0 {
+		resolved.ConnectTimeout = modelPolicy.ConnectTimeout
+	}
+	if modelPolicy.ResponseHeaderTimeout != 0 {
+		resolved.ResponseHeaderTimeout = modelPolicy.ResponseHeaderTimeout
+	}
+	if modelPolicy.Timeout != 0 {
+		resolved.Timeout = modelPolicy.Timeout
+	}
+	if modelPolicy.RetryTimes != 0 {
+		resolved.RetryTimes = modelPolicy.RetryTimes
+	}
+	return resolved
+}
+
+// getChannelTimeoutPolicy is the policy of the selected channel for the model of the request
+func getChannelTimeoutPolicy(c *gin.Context) ChannelTimeoutPolicy {
+	policy, err := parseChannelTimeoutPolicy(c.GetString("timeout_policy"))
+	if err != nil {
+		return ChannelTimeoutPolicy{}
+	}
+	return policy.forModel(c.GetString("request_model"))
+}
+
+// getRelayHTTPClient is the client for relaying the request to the selected channel, through its proxy and with its
+// timeouts for the model of the request
+func getRelayHTTPClient(c *gin.Context) *http.Client {
+	proxy := c.GetString("proxy")
+	policy := getChannelTimeoutPolicy(c)
+	if policy.ConnectTimeout == 0 && policy.ResponseHeaderTimeout == 0 && policy.Timeout == 0 {
+		return getHTTPClient(proxy)
+	}
+	transport := getTransport(proxy, time.Duration(policy.ConnectTimeout)*time.Second, time.Duration(policy.ResponseHeaderTimeout)*time.Second)
+	return &http.Client{Transport: transport, Timeout: time.Duration(policy.Timeout) * time.Second}
+}
@@ -9,6 +9,53 @@ import (
 	"strings"
 )
 
+// validateChannel checks the settings of a channel which is added or updated, the error message is empty if valid
+func validateChannel(channel *model.Channel) string {
+	if !model.IsValidChannelKeyStrategy(channel.KeyStrategy) {
+		return "无效的密钥轮换策略"
+	}
+	if !model.IsValidModelRateLimits(channel.ModelRateLimits) || channel.GetRPM() < 0 || channel.GetTPM() < 0 {
+		return "无效的速率限制"
+	}
+	if !isValidChannelProxy(channel.Proxy) {
+		return "无效的代理地址"
+	}
+	if channel.GetDailyBudget() < 0 || channel.GetMonthlyBudget() < 0 {
+		return "无效的预算"
+	}
+	if !model.IsValidMaintenanceWindows(channel.MaintenanceWindows) {
+		return "无效的维护时段"
+	}
+	if !isValidChannelHeaders(channel.Headers) {
+		return "无效的请求头"
+	}
+	if !isValidChannelTimeoutPolicy(channel.TimeoutPolicy) {
+		return "无效的超时策略"
+	}
+	if !isValidAzureDeployments(channel.AzureDeployments) {
+		return "无效的 Azure 部署映射"
+	}
+	if !model.IsValidSystemPromptMode(channel.SystemPromptMode) {
+		return "无效的系统提示模式"
+	}
+	if _, err := buildTestRequest(channel); err != nil {
+		return "无效的测试请求体"
+	}
+	if channel.GetShadowRate() < 0 || channel.GetShadowRate() > 100 {
+		return "无效的镜像比例"
+	}
+	if shadowChannelId := channel.GetShadowChannelId(); shadowChannelId != 0 {
+		shadowChannel, err := model.GetChannelById(shadowChannelId, false)
+		if err != nil || shadowChannelId == channel.Id {
+			return "无效的镜像渠道"
+		}
+		if getAPIType(shadowChannel.Type) != APITypeOpenAI || shadowChannel.Type == common.ChannelTypeAzure {
+			return "镜像渠道仅支持 OpenAI 兼容的渠道"
+		}
+	}
+	return ""
+}
+
 func GetAllChannels(c *gin.Context) {
 	p, _ := strconv.Atoi(c.Query("p"))
 	if p < 0 {
@@ -57,7 +104,23 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
-	channel, err := model.GetChannelById(id, false)
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel.KeyUsages, err = model.GetChannelKeyUsages(channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel.DailySpend, channel.MonthlySpend, err = model.GetChannelSpend(channel.Id)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -65,6 +128,7 @@ func GetChannel(c *gin.Context) {
 		})
 		return
 	}
+	channel.Key = ""
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -83,8 +147,19 @@ func AddChannel(c *gin.Context) {
 		})
 		return
 	}
+	if message := validateChannel(&channel); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
 	channel.CreatedTime = common.GetTimestamp()
 	keys := strings.Split(channel.Key, "\n")
+	if channel.KeyStrategy != "" {
+		// the keys share one channel and take turns with the strategy
+		keys = []string{strings.Join(channel.GetKeys(), "\n")}
+	}
 	channels := make([]model.Channel, 0)
 	for _, key := range keys {
 		if key == "" {
@@ -94,6 +169,10 @@ func AddChannel(c *gin.Context) {
 		localChannel.Key = key
 		channels = append(channels, localChannel)
 	}
+	if len(channels) == 0 && channel.Type == common.ChannelTypeLocal {
+		// the self-hosted backends may run without authentication
+		channels = append(channels, channel)
+	}
 	err = model.BatchInsertChannels(channels)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -137,6 +216,13 @@ func UpdateChannel(c *gin.Context) {
 		})
 		return
 	}
+	if message := validateChannel(&channel); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
 	err = channel.Update()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -152,3 +238,21 @@ func UpdateChannel(c *gin.Context) {
 	})
 	return
 }
+
+func GetChannelLatencies(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetAllChannelLatencyStats(),
+	})
+	return
+}
+
+func GetChannelCircuits(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetChannelCircuits(),
+	})
+	return
+}
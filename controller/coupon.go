@@ -0,0 +1,331 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllCoupons(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	coupons, err := model.GetAllCoupons(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupons,
+	})
+}
+
+func SearchCoupons(c *gin.Context) {
+	coupons, err := model.SearchCoupons(c.Query("keyword"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupons,
+	})
+}
+
+func GetCoupon(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	coupon, err := model.GetCouponById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    coupon,
+	})
+}
+
+func validateCoupon(coupon *model.Coupon) string {
+	if len(coupon.Name) == 0 || len(coupon.Name) > 20 {
+		return "优惠券名称长度必须在1-20之间"
+	}
+	if coupon.Discount < 1 || coupon.Discount > 100 {
+		return "优惠券折扣必须在 1-100 之间"
+	}
+	if coupon.EndTime != -1 && coupon.EndTime < common.GetTimestamp() {
+		return "优惠券结束时间不能早于当前时间"
+	}
+	if coupon.ValidDays < 0 || coupon.MaxUses < 0 || coupon.MaxDiscount < 0 {
+		return "有效天数、使用次数与减免额度上限不能为负数"
+	}
+	return ""
+}
+
+func AddCoupon(c *gin.Context) {
+	coupon := model.Coupon{}
+	err := c.ShouldBindJSON(&coupon)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if coupon.EndTime == 0 {
+		coupon.EndTime = -1
+	}
+	if coupon.MaxClaims <= 0 {
+		coupon.MaxClaims = 1
+	}
+	if message := validateCoupon(&coupon); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	if len(coupon.Code) > 32 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "优惠券码长度不能超过 32",
+		})
+		return
+	}
+	if coupon.Code == "" {
+		coupon.Code = common.GetUUID()
+	}
+	cleanCoupon := model.Coupon{
+		UserId:      c.GetInt("id"),
+		Code:        coupon.Code,
+		Name:        coupon.Name,
+		Status:      common.CouponStatusEnabled,
+		Discount:    coupon.Discount,
+		Models:      coupon.Models,
+		StartTime:   coupon.StartTime,
+		EndTime:     coupon.EndTime,
+		ValidDays:   coupon.ValidDays,
+		MaxClaims:   coupon.MaxClaims,
+		MaxUses:     coupon.MaxUses,
+		MaxDiscount: coupon.MaxDiscount,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = cleanCoupon.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanCoupon,
+	})
+}
+
+// UpdateCoupon changes the terms for the users claiming it afterwards, the coupons attached already keep theirs
+func UpdateCoupon(c *gin.Context) {
+	statusOnly := c.Query("status_only")
+	coupon := model.Coupon{}
+	err := c.ShouldBindJSON(&coupon)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanCoupon, err := model.GetCouponById(coupon.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly != "" {
+		cleanCoupon.Status = coupon.Status
+	} else {
+		// If you add more fields, please also update coupon.Update()
+		cleanCoupon.Name = coupon.Name
+		cleanCoupon.Discount = coupon.Discount
+		cleanCoupon.Models = coupon.Models
+		cleanCoupon.StartTime = coupon.StartTime
+		if coupon.EndTime != 0 {
+			cleanCoupon.EndTime = coupon.EndTime
+		}
+		cleanCoupon.ValidDays = coupon.ValidDays
+		if coupon.MaxClaims > 0 {
+			cleanCoupon.MaxClaims = coupon.MaxClaims
+		}
+		cleanCoupon.MaxUses = coupon.MaxUses
+		cleanCoupon.MaxDiscount = coupon.MaxDiscount
+		if message := validateCoupon(cleanCoupon); message != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": message,
+			})
+			return
+		}
+	}
+	err = cleanCoupon.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanCoupon,
+	})
+}
+
+func DeleteCoupon(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteCouponById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+type assignCouponRequest struct {
+	UserId int `json:"user_id"`
+}
+
+func AssignCoupon(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	request := assignCouponRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	userCoupon, err := model.AssignCoupon(id, request.UserId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userCoupon,
+	})
+}
+
+type claimCouponRequest struct {
+	Code string `json:"code"`
+}
+
+func ClaimCoupon(c *gin.Context) {
+	request := claimCouponRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	userCoupon, err := model.ClaimCoupon(request.Code, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userCoupon,
+	})
+}
+
+func GetSelfCoupons(c *gin.Context) {
+	userCoupons, err := model.GetUserCoupons(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    userCoupons,
+	})
+}
+
+func getCouponLedgers(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	userCouponId, _ := strconv.Atoi(c.Query("user_coupon_id"))
+	ledgers, err := model.GetCouponLedgers(userId, userCouponId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    ledgers,
+	})
+}
+
+func GetSelfCouponLedgers(c *gin.Context) {
+	getCouponLedgers(c, c.GetInt("id"))
+}
+
+// GetCouponLedgers lists the ledger of all the users unless user_id is given
+func GetCouponLedgers(c *gin.Context) {
+	userId, _ := strconv.Atoi(c.Query("user_id"))
+	getCouponLedgers(c, userId)
+}
@@ -0,0 +1,81 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type grantCreditRequest struct {
+	UserId int `json:"user_id"`
+	Quota  int `json:"quota"`
+	Days   int `json:"days"`
+}
+
+func GrantCredit(c *gin.Context) {
+	request := grantCreditRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.UserId == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if request.Days == 0 {
+		request.Days = 30
+	}
+	err = model.GrantExpiringCredit(request.UserId, request.Quota, request.Days, model.CreditSourceAdmin)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func getCreditBuckets(c *gin.Context, userId int) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	buckets, err := model.GetUserCreditBuckets(userId, p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    buckets,
+	})
+	return
+}
+
+func GetSelfCreditBuckets(c *gin.Context) {
+	getCreditBuckets(c, c.GetInt("id"))
+}
+
+func GetUserCreditBuckets(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	getCreditBuckets(c, id)
+}
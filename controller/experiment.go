@@ -0,0 +1,358 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"regexp"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllExperiments(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	experiments, err := model.GetAllExperiments(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    experiments,
+	})
+	return
+}
+
+func GetExperiment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	experiment, err := model.GetExperimentById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    experiment,
+	})
+	return
+}
+
+func validateExperiment(experiment *model.Experiment) error {
+	if experiment.Model == "" {
+		return errors.New("实验模型不能为空")
+	}
+	if experiment.ControlChannelId == 0 || experiment.TreatmentChannelId == 0 {
+		return errors.New("请指定对照组渠道与实验组渠道")
+	}
+	if experiment.ControlChannelId == experiment.TreatmentChannelId {
+		return errors.New("对照组渠道与实验组渠道不能相同")
+	}
+	if experiment.Percentage < 0 || experiment.Percentage > 100 {
+		return errors.New("实验组流量比例必须在 0-100 之间")
+	}
+	return nil
+}
+
+func AddExperiment(c *gin.Context) {
+	experiment := model.Experiment{}
+	err := c.ShouldBindJSON(&experiment)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateExperiment(&experiment); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanExperiment := model.Experiment{
+		Name:               experiment.Name,
+		Model:              experiment.Model,
+		ControlChannelId:   experiment.ControlChannelId,
+		TreatmentChannelId: experiment.TreatmentChannelId,
+		Percentage:         experiment.Percentage,
+		JudgeModel:         experiment.JudgeModel,
+		Status:             common.ExperimentStatusRunning,
+		CreatedTime:        common.GetTimestamp(),
+	}
+	err = cleanExperiment.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanExperiment,
+	})
+	return
+}
+
+func UpdateExperiment(c *gin.Context) {
+	experiment := model.Experiment{}
+	err := c.ShouldBindJSON(&experiment)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateExperiment(&experiment); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanExperiment, err := model.GetExperimentById(experiment.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanExperiment.Name = experiment.Name
+	cleanExperiment.Model = experiment.Model
+	cleanExperiment.ControlChannelId = experiment.ControlChannelId
+	cleanExperiment.TreatmentChannelId = experiment.TreatmentChannelId
+	cleanExperiment.Percentage = experiment.Percentage
+	cleanExperiment.JudgeModel = experiment.JudgeModel
+	if experiment.Status != 0 {
+		cleanExperiment.Status = experiment.Status
+	}
+	err = cleanExperiment.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanExperiment,
+	})
+	return
+}
+
+func DeleteExperiment(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	experiment := model.Experiment{Id: id}
+	err := experiment.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+func GetExperimentReport(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	experiment, err := model.GetExperimentById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	stats, err := model.GetExperimentReport(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"experiment": experiment,
+			"arms":       stats,
+		},
+	})
+	return
+}
+
+var judgeScorePattern = regexp.MustCompile(`\d+(\.\d+)?`)
+
+func buildJudgePrompt(prompt string, completion string) string {
+	return fmt.Sprintf("请作为公正的评审，对下面 AI 助手的回答质量进行打分，分数范围为 0 到 10，只输出分数本身。\n\n[问题]\n%s\n\n[回答]\n%s", prompt, completion)
+}
+
+// judgeCompletion asks the judge model to score a completion, only OpenAI compatible channels are supported, the
+// channel is selected for the group of the admin who asks for the judgement
+func judgeCompletion(c *gin.Context, group string, judgeModel string, prompt string, completion string) (float64, error) {
+	channel, err := middleware.SelectChannel(c, group, judgeModel)
+	if err != nil {
+		return 0, fmt.Errorf("评审模型 %s 无可用渠道", judgeModel)
+	}
+	request := ChatRequest{
+		Model:     judgeModel,
+		MaxTokens: 8,
+		Messages: []Message{
+			{
+				Role:    "user",
+				Content: buildJudgePrompt(prompt, completion),
+			},
+		},
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return 0, err
+	}
+	requestURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		requestURL = channel.BaseURL
+	}
+	req, err := http.NewRequest("POST", requestURL+"/v1/chat/completions", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Authorization", "Bearer "+channel.Key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := getHTTPClient(channel.Proxy).Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	var response TextResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return 0, err
+	}
+	if response.Error.Type != "" {
+		return 0, errors.New(response.Error.Message)
+	}
+	if len(response.Choices) == 0 {
+		return 0, errors.New("评审模型未返回结果")
+	}
+	scoreStr := judgeScorePattern.FindString(response.Choices[0].Message.StringContent())
+	if scoreStr == "" {
+		return 0, errors.New("无法解析评审分数：" + response.Choices[0].Message.StringContent())
+	}
+	score, err := strconv.ParseFloat(scoreStr, 64)
+	if err != nil {
+		return 0, err
+	}
+	if score > 10 {
+		score = 10
+	}
+	return score, nil
+}
+
+func JudgeExperiment(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	experiment, err := model.GetExperimentById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if experiment.JudgeModel == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该实验未配置评审模型",
+		})
+		return
+	}
+	num, _ := strconv.Atoi(c.Query("num"))
+	if num <= 0 || num > 100 {
+		num = 10
+	}
+	records, err := model.GetUnjudgedExperimentRecords(id, num)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	group, err := model.CacheGetUserGroup(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	judged := 0
+	for _, record := range records {
+		score, err := judgeCompletion(c, group, experiment.JudgeModel, record.Prompt, record.Completion)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to judge experiment record #%d: %s", record.Id, err.Error()))
+			continue
+		}
+		err = model.UpdateExperimentRecordScore(record.Id, score)
+		if err != nil {
+			common.SysError("failed to update experiment record score: " + err.Error())
+			continue
+		}
+		judged++
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    judged,
+	})
+	return
+}
@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isFeatureEnabled evaluates the flag for the user of the request, the result is remembered for the metrics of the flag
+func isFeatureEnabled(c *gin.Context, key string) bool {
+	flags, _ := c.Get("feature_flags")
+	evaluated, ok := flags.(map[string]bool)
+	if !ok {
+		evaluated = make(map[string]bool)
+		c.Set("feature_flags", evaluated)
+	}
+	if enabled, ok := evaluated[key]; ok {
+		return enabled
+	}
+	enabled := model.IsFeatureEnabled(key, c.GetString("group"), c.GetInt("id"), model.KnownFeatureFlags[key])
+	evaluated[key] = enabled
+	return enabled
+}
+
+func recordFeatureFlagMetrics(c *gin.Context, latency int64, err *OpenAIErrorWithStatusCode) {
+	flags, _ := c.Get("feature_flags")
+	evaluated, _ := flags.(map[string]bool)
+	for key, enabled := range evaluated {
+		model.RecordFeatureFlagMetric(key, enabled, latency, err == nil)
+	}
+}
+
+func GetAllFeatureFlags(c *gin.Context) {
+	flags, err := model.GetAllFeatureFlags()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    flags,
+	})
+	return
+}
+
+func AddFeatureFlag(c *gin.Context) {
+	flag := model.FeatureFlag{}
+	err := c.ShouldBindJSON(&flag)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = flag.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanFlag := model.FeatureFlag{
+		Key:              flag.Key,
+		Description:      flag.Description,
+		Status:           common.FeatureFlagStatusEnabled,
+		Percentage:       flag.Percentage,
+		GroupPercentages: flag.GroupPercentages,
+		UserIds:          flag.UserIds,
+		CreatedTime:      common.GetTimestamp(),
+		UpdatedTime:      common.GetTimestamp(),
+	}
+	err = cleanFlag.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanFlag,
+	})
+	return
+}
+
+func UpdateFeatureFlag(c *gin.Context) {
+	flag := model.FeatureFlag{}
+	err := c.ShouldBindJSON(&flag)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanFlag, err := model.GetFeatureFlagById(flag.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	flag.Key = cleanFlag.Key
+	if err = flag.Validate(); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanFlag.Description = flag.Description
+	cleanFlag.Percentage = flag.Percentage
+	cleanFlag.GroupPercentages = flag.GroupPercentages
+	cleanFlag.UserIds = flag.UserIds
+	if flag.Status != 0 {
+		cleanFlag.Status = flag.Status
+	}
+	cleanFlag.UpdatedTime = common.GetTimestamp()
+	err = cleanFlag.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanFlag,
+	})
+	return
+}
+
+func DeleteFeatureFlag(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	flag := model.FeatureFlag{Id: id}
+	err := flag.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
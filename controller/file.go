@@ -0,0 +1,382 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the keys of the requests which hold the ids of the files, such as the training file of a fine-tuning job or the
+// attachments of the messages of a thread
+var fileIdKeys = map[string]bool{
+	"file_id":         true,
+	"file_ids":        true,
+	"training_file":   true,
+	"validation_file": true,
+	"input_file_id":   true,
+}
+
+func writeFileError(c *gin.Context, statusCode int, message string) {
+	c.JSON(statusCode, gin.H{
+		"error": OpenAIError{
+			Message: message,
+			Type:    "one_api_error",
+			Param:   "",
+			Code:    nil,
+		},
+	})
+}
+
+func UploadFile(c *gin.Context) {
+	userId := c.GetInt("id")
+	fileSizeLimit := int64(common.FileSizeLimit) * 1024 * 1024
+	if fileSizeLimit > 0 {
+		// 1 MB is left for the boundaries and the other fields of the form
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, fileSizeLimit+1024*1024)
+	}
+	err := c.Request.ParseMultipartForm(32 << 20)
+	if err != nil {
+		if strings.Contains(err.Error(), "request body too large") {
+			writeFileError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("文件过大，上限为 %d MB", common.FileSizeLimit))
+			return
+		}
+		writeFileError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		writeFileError(c, http.StatusBadRequest, "purpose 不能为空")
+		return
+	}
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		writeFileError(c, http.StatusBadRequest, "file 不能为空")
+		return
+	}
+	if fileSizeLimit > 0 && fileHeader.Size > fileSizeLimit {
+		writeFileError(c, http.StatusRequestEntityTooLarge, fmt.Sprintf("文件过大，上限为 %d MB", common.FileSizeLimit))
+		return
+	}
+	if storageQuota := resolveIntOption(c, "FileStorageQuota", common.FileStorageQuota); storageQuota > 0 {
+		usedBytes, err := model.GetUserFileBytes(userId)
+		if err != nil {
+			writeFileError(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+		if usedBytes+fileHeader.Size > int64(storageQuota)*1024*1024 {
+			writeFileError(c, http.StatusForbidden, fmt.Sprintf("文件存储空间不足，上限为 %d MB，已使用 %.2f MB", storageQuota, float64(usedBytes)/1024/1024))
+			return
+		}
+	}
+	content, err := fileHeader.Open()
+	if err != nil {
+		writeFileError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	data, err := io.ReadAll(content)
+	_ = content.Close()
+	if err != nil {
+		writeFileError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	file := &model.File{
+		Id:       "file-" + common.GetUUID(),
+		UserId:   userId,
+		Bytes:    int64(len(data)),
+		Filename: fileHeader.Filename,
+		Purpose:  purpose,
+	}
+	file.Storage, err = common.SaveFile(file.GetStorageKey(), data, fileHeader.Header.Get("Content-Type"))
+	if err != nil {
+		common.SysError("failed to save file: " + err.Error())
+		writeFileError(c, http.StatusInternalServerError, "文件保存失败")
+		return
+	}
+	err = file.Insert()
+	if err != nil {
+		_ = common.RemoveFile(file.Storage, file.GetStorageKey())
+		writeFileError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	file.Object = "file"
+	c.JSON(http.StatusOK, file)
+}
+
+func ListFiles(c *gin.Context) {
+	files, err := model.GetUserFiles(c.GetInt("id"), c.Query("purpose"))
+	if err != nil {
+		writeFileError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	for _, file := range files {
+		file.Object = "file"
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"object":   "list",
+		"data":     files,
+		"has_more": false,
+	})
+}
+
+func getRequestFile(c *gin.Context) *model.File {
+	file, err := model.GetUserFile(c.Param("id"), c.GetInt("id"))
+	if err != nil {
+		writeFileError(c, http.StatusNotFound, fmt.Sprintf("文件 %s 不存在", c.Param("id")))
+		return nil
+	}
+	file.Object = "file"
+	return file
+}
+
+func RetrieveFile(c *gin.Context) {
+	file := getRequestFile(c)
+	if file == nil {
+		return
+	}
+	c.JSON(http.StatusOK, file)
+}
+
+func RetrieveFileContent(c *gin.Context) {
+	file := getRequestFile(c)
+	if file == nil {
+		return
+	}
+	content, err := common.LoadFile(file.Storage, file.GetStorageKey())
+	if err != nil {
+		common.SysError("failed to load file: " + err.Error())
+		writeFileError(c, http.StatusInternalServerError, "文件读取失败")
+		return
+	}
+	defer content.Close()
+	c.DataFromReader(http.StatusOK, file.Bytes, "application/octet-stream", content, map[string]string{
+		"Content-Disposition": fmt.Sprintf("attachment; filename=%q", file.Filename),
+	})
+}
+
+func DeleteFile(c *gin.Context) {
+	file := getRequestFile(c)
+	if file == nil {
+		return
+	}
+	upstreamFiles, err := file.Delete()
+	if err != nil {
+		writeFileError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	err = common.RemoveFile(file.Storage, file.GetStorageKey())
+	if err != nil {
+		common.SysError("failed to remove file: " + err.Error())
+	}
+	go deleteUpstreamFiles(upstreamFiles)
+	c.JSON(http.StatusOK, gin.H{
+		"id":      file.Id,
+		"object":  "file",
+		"deleted": true,
+	})
+}
+
+func getChannelFilesURL(channel *model.Channel) string {
+	baseURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		baseURL = channel.BaseURL
+	}
+	if channel.Type == common.ChannelTypeAzure {
+		return fmt.Sprintf("%s/openai/files", baseURL)
+	}
+	return fmt.Sprintf("%s/v1/files", baseURL)
+}
+
+func setChannelFileAuth(req *http.Request, channelType int, key string) {
+	if channelType == common.ChannelTypeAzure {
+		req.Header.Set("api-key", key)
+	} else {
+		setBearerAuth(req, key)
+	}
+}
+
+// deleteUpstreamFiles deletes the copies of a deleted file on the upstreams, the ones which fail are left there
+func deleteUpstreamFiles(upstreamFiles []*model.UpstreamFile) {
+	for _, upstreamFile := range upstreamFiles {
+		channel, err := model.GetChannelById(upstreamFile.ChannelId, true)
+		if err != nil {
+			continue
+		}
+		key := channel.Key
+		if upstreamFile.KeyHash != "" {
+			var ok bool
+			if key, ok = model.GetChannelKeyByHash(channel, upstreamFile.KeyHash); !ok {
+				continue
+			}
+		}
+		fullRequestURL := getChannelFilesURL(channel) + "/" + upstreamFile.UpstreamFileId
+		if channel.Type == common.ChannelTypeAzure {
+			fullRequestURL += "?api-version=" + defaultAzureAssistantsAPIVersion
+		}
+		req, err := http.NewRequest(http.MethodDelete, fullRequestURL, nil)
+		if err != nil {
+			continue
+		}
+		setChannelFileAuth(req, channel.Type, key)
+		setChannelHeaders(req, channel.Headers)
+		resp, err := getHTTPClient(channel.Proxy).Do(req)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to delete upstream file %s of channel #%d: %s", upstreamFile.UpstreamFileId, channel.Id, err.Error()))
+			continue
+		}
+		_ = resp.Body.Close()
+	}
+}
+
+// getUpstreamFileId uploads the file with the key of the channel of the request the first time it is used there, the
+// later requests reuse the copy
+func getUpstreamFileId(c *gin.Context, file *model.File) (string, *OpenAIErrorWithStatusCode) {
+	channelId := c.GetInt("channel_id")
+	keyHash := c.GetString("channel_key_hash")
+	if upstreamFileId, ok := model.GetUpstreamFileId(file.Id, channelId, keyHash); ok {
+		return upstreamFileId, nil
+	}
+	content, err := common.LoadFile(file.Storage, file.GetStorageKey())
+	if err != nil {
+		return "", errorWrapper(err, "load_file_failed", http.StatusInternalServerError)
+	}
+	defer content.Close()
+	var requestBody bytes.Buffer
+	writer := multipart.NewWriter(&requestBody)
+	_ = writer.WriteField("purpose", file.Purpose)
+	part, err := writer.CreateFormFile("file", file.Filename)
+	if err != nil {
+		return "", errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	_, err = io.Copy(part, content)
+	if err != nil {
+		return "", errorWrapper(err, "load_file_failed", http.StatusInternalServerError)
+	}
+	err = writer.Close()
+	if err != nil {
+		return "", errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+
+	channelType := c.GetInt("channel")
+	baseURL := common.ChannelBaseURLs[channelType]
+	if c.GetString("base_url") != "" {
+		baseURL = c.GetString("base_url")
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/files", baseURL)
+	if channelType == common.ChannelTypeAzure {
+		fullRequestURL = fmt.Sprintf("%s/openai/files?api-version=%s", baseURL, defaultAzureAssistantsAPIVersion)
+	}
+	req, err := http.NewRequest(http.MethodPost, fullRequestURL, &requestBody)
+	if err != nil {
+		return "", errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	setChannelFileAuth(req, channelType, strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	setChannelHeaders(req, c.GetString("channel_headers"))
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		return "", errorWrapper(err, "upload_file_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", getOpenAIResponseError(resp)
+	}
+	var upstreamFile struct {
+		Id string `json:"id"`
+	}
+	err = json.NewDecoder(resp.Body).Decode(&upstreamFile)
+	_ = resp.Body.Close()
+	if err != nil || upstreamFile.Id == "" {
+		return "", errorWrapper(fmt.Errorf("upstream returned no id for file %s", file.Id), "upload_file_failed", http.StatusInternalServerError)
+	}
+	model.RecordUpstreamFile(&model.UpstreamFile{
+		FileId:         file.Id,
+		ChannelId:      channelId,
+		KeyHash:        keyHash,
+		UpstreamFileId: upstreamFile.Id,
+	})
+	return upstreamFile.Id, nil
+}
+
+// replaceFileIds replaces the ids of the files of the user in the request with the ones of their copies on the
+// upstream, the other ids, such as the ones of the files uploaded to the upstream directly, are kept
+func replaceFileIds(c *gin.Context, value any, replaced *bool) (any, *OpenAIErrorWithStatusCode) {
+	switch value := value.(type) {
+	case map[string]any:
+		for key, item := range value {
+			var err *OpenAIErrorWithStatusCode
+			if fileIdKeys[key] {
+				value[key], err = replaceFileIdValues(c, item, replaced)
+			} else {
+				value[key], err = replaceFileIds(c, item, replaced)
+			}
+			if err != nil {
+				return nil, err
+			}
+		}
+	case []any:
+		for i, item := range value {
+			var err *OpenAIErrorWithStatusCode
+			value[i], err = replaceFileIds(c, item, replaced)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+func replaceFileIdValues(c *gin.Context, value any, replaced *bool) (any, *OpenAIErrorWithStatusCode) {
+	switch value := value.(type) {
+	case string:
+		if !strings.HasPrefix(value, "file-") {
+			return value, nil
+		}
+		file, err := model.GetUserFile(value, c.GetInt("id"))
+		if err != nil {
+			return value, nil
+		}
+		*replaced = true
+		return getUpstreamFileId(c, file)
+	case []any:
+		for i, item := range value {
+			var err *OpenAIErrorWithStatusCode
+			value[i], err = replaceFileIdValues(c, item, replaced)
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+	return value, nil
+}
+
+// resolveUpstreamFiles makes the files of the gateway used by the JSON request available on the upstream of the request
+func resolveUpstreamFiles(c *gin.Context) *OpenAIErrorWithStatusCode {
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	var request any
+	if json.Unmarshal(requestBody, &request) != nil {
+		return nil
+	}
+	replaced := false
+	request, errWithStatusCode := replaceFileIds(c, request, &replaced)
+	if errWithStatusCode != nil || !replaced {
+		return errWithStatusCode
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return nil
+}
@@ -0,0 +1,114 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultForecastDays = 7
+	maxForecastDays     = 30
+)
+
+type ForecastDay struct {
+	Day   string `json:"day"`
+	Quota int64  `json:"quota"`
+}
+
+// Forecast projects the spending of the recent days, the quotas are in the unit of the user quota
+type Forecast struct {
+	Days                int           `json:"days"`
+	AvailableQuota      int           `json:"available_quota"`
+	Daily               []ForecastDay `json:"daily"`
+	DailyAverage        int64         `json:"daily_average"`
+	DaysUntilExhaustion float64       `json:"days_until_exhaustion"` // -1 means the quota is not being used
+	ExhaustionTime      int64         `json:"exhaustion_time"`       // 0 if it is not expected
+	MonthlySpend        int64         `json:"monthly_spend"`         // 30 days at the daily average
+	MonthToDate         int64         `json:"month_to_date"`
+	ProjectedMonthSpend int64         `json:"projected_month_spend"` // the spending of this month when it ends
+}
+
+func buildForecast(userId int, days int) (*Forecast, error) {
+	availableQuota, err := model.CacheGetUserAvailableQuota(userId)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	forecast := &Forecast{
+		Days:                days,
+		AvailableQuota:      availableQuota,
+		Daily:               make([]ForecastDay, 0, days),
+		DaysUntilExhaustion: -1,
+	}
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.Local)
+	for i := days - 1; i >= 0; i-- {
+		start := today.AddDate(0, 0, -i)
+		quota, _, err := model.SumUserConsumedQuota(userId, start.Unix(), start.AddDate(0, 0, 1).Unix())
+		if err != nil {
+			return nil, err
+		}
+		forecast.Daily = append(forecast.Daily, ForecastDay{Day: start.Format("2006-01-02"), Quota: quota})
+	}
+
+	// the average is taken over the rolling window, or since the first usage for a new user
+	windowStart := now.Add(-time.Duration(days) * 24 * time.Hour)
+	total, firstTime, err := model.SumUserConsumedQuota(userId, windowStart.Unix(), now.Unix()+1)
+	if err != nil {
+		return nil, err
+	}
+	elapsedDays := float64(days)
+	if firstTime > windowStart.Unix() {
+		elapsedDays = float64(now.Unix()-firstTime) / (24 * 60 * 60)
+		if elapsedDays < 1 {
+			elapsedDays = 1
+		}
+	}
+	dailyAverage := float64(total) / elapsedDays
+	forecast.DailyAverage = int64(dailyAverage)
+	forecast.MonthlySpend = int64(dailyAverage * 30)
+	if dailyAverage > 0 {
+		forecast.DaysUntilExhaustion = 0
+		if availableQuota > 0 {
+			forecast.DaysUntilExhaustion = float64(availableQuota) / dailyAverage
+		}
+		forecast.ExhaustionTime = now.Unix() + int64(forecast.DaysUntilExhaustion*24*60*60)
+	}
+
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.Local)
+	forecast.MonthToDate, _, err = model.SumUserConsumedQuota(userId, monthStart.Unix(), now.Unix()+1)
+	if err != nil {
+		return nil, err
+	}
+	remainingDays := monthStart.AddDate(0, 1, 0).Sub(now).Hours() / 24
+	forecast.ProjectedMonthSpend = forecast.MonthToDate + int64(dailyAverage*remainingDays)
+	return forecast, nil
+}
+
+// GetSelfForecast projects when the quota runs out from the consume logs, so it is empty if they are disabled
+func GetSelfForecast(c *gin.Context) {
+	days, _ := strconv.Atoi(c.Query("days"))
+	if days <= 0 {
+		days = defaultForecastDays
+	}
+	if days > maxForecastDays {
+		days = maxForecastDays
+	}
+	forecast, err := buildForecast(c.GetInt("id"), days)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    forecast,
+	})
+	return
+}
@@ -1,9 +1,12 @@
 package controller
 
 import (
+	"encoding/json"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
+	"one-api/model"
+	"sort"
 )
 
 func GetGroups(c *gin.Context) {
@@ -17,3 +20,141 @@ func GetGroups(c *gin.Context) {
 		"data":    groupNames,
 	})
 }
+
+type GroupRatioItem struct {
+	Group string  `json:"group"`
+	Ratio float64 `json:"ratio"`
+	Users int     `json:"users"`
+}
+
+func GetGroupRatios(c *gin.Context) {
+	counts, err := model.GetGroupUserCounts()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	group2users := make(map[string]int)
+	for _, count := range counts {
+		group2users[count.Group] = count.Count
+	}
+	items := make([]*GroupRatioItem, 0, len(common.GroupRatio))
+	for group, ratio := range common.GroupRatio {
+		items = append(items, &GroupRatioItem{Group: group, Ratio: ratio, Users: group2users[group]})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Group < items[j].Group
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    items,
+	})
+}
+
+// saveGroupRatio persists the ratios through the GroupRatio option so that every node picks them up
+func saveGroupRatio(groupRatio map[string]float64) error {
+	jsonBytes, err := json.Marshal(groupRatio)
+	if err != nil {
+		return err
+	}
+	return model.UpdateOption("GroupRatio", string(jsonBytes))
+}
+
+func UpdateGroupRatio(c *gin.Context) {
+	item := GroupRatioItem{}
+	err := c.ShouldBindJSON(&item)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	if item.Group == "" || len(item.Group) > 32 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "分组名称不能为空且不能超过 32 个字符",
+		})
+		return
+	}
+	if item.Ratio < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "分组倍率不能为负数",
+		})
+		return
+	}
+	groupRatio := make(map[string]float64, len(common.GroupRatio)+1)
+	for group, ratio := range common.GroupRatio {
+		groupRatio[group] = ratio
+	}
+	groupRatio[item.Group] = item.Ratio
+	err = saveGroupRatio(groupRatio)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+func DeleteGroupRatio(c *gin.Context) {
+	name := c.Param("group")
+	if name == "default" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不能删除默认分组",
+		})
+		return
+	}
+	if _, ok := common.GroupRatio[name]; !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "分组不存在",
+		})
+		return
+	}
+	counts, err := model.GetGroupUserCounts()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	for _, count := range counts {
+		if count.Group == name && count.Count > 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "该分组下仍有用户，无法删除",
+			})
+			return
+		}
+	}
+	groupRatio := make(map[string]float64, len(common.GroupRatio))
+	for group, ratio := range common.GroupRatio {
+		if group != name {
+			groupRatio[group] = ratio
+		}
+	}
+	err = saveGroupRatio(groupRatio)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
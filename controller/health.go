@@ -0,0 +1,83 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetHealth is meant for the liveness probe, it only tells the process is serving
+func GetHealth(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
+
+// GetReadiness is meant for the readiness probe, the pod is removed from the service endpoints
+// until the database, Redis and the mounted Kubernetes config are all ready and the warmup is done
+func GetReadiness(c *gin.Context) {
+	checks := gin.H{}
+	ready := true
+	// the probe is not authenticated, the errors are only logged
+	if err := model.PingDB(); err != nil {
+		common.SysError("readiness check of the database failed: " + err.Error())
+		checks["database"] = "unavailable"
+		ready = false
+	} else {
+		checks["database"] = "ok"
+	}
+	if common.RedisEnabled {
+		if err := common.RedisPing(); err != nil {
+			common.SysError("readiness check of Redis failed: " + err.Error())
+			checks["redis"] = "unavailable"
+			ready = false
+		} else {
+			checks["redis"] = "ok"
+		}
+	}
+	if model.KubernetesConfigEnabled() {
+		if !model.IsKubernetesConfigLoaded() {
+			checks["kubernetes_config"] = "not loaded"
+			ready = false
+		} else {
+			checks["kubernetes_config"] = "ok"
+		}
+	}
+	if !IsWarmedUp() {
+		checks["warmup"] = "running"
+		ready = false
+	} else {
+		checks["warmup"] = "ok"
+	}
+	status := http.StatusOK
+	if !ready {
+		status = http.StatusServiceUnavailable
+	}
+	c.JSON(status, gin.H{
+		"success": ready,
+		"message": "",
+		"data":    checks,
+	})
+	return
+}
+
+// GetSlowQueries lists the slow queries recorded by the SQL audit mode since the last report
+func GetSlowQueries(c *gin.Context) {
+	if !common.SQLAuditEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "未开启 SQL 审计模式，请设置环境变量 SQL_AUDIT=true",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    model.GetSlowQueries(),
+	})
+	return
+}
@@ -0,0 +1,159 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/go-ldap/ldap/v3"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+)
+
+// authenticateLDAPUser checks the password of the user against the directory and returns its entry
+func authenticateLDAPUser(username string, password string) (*ldap.Entry, error) {
+	conn, err := common.DialLDAP()
+	if err != nil {
+		common.SysError("failed to connect to the LDAP server: " + err.Error())
+		return nil, errors.New("无法连接 LDAP 服务器")
+	}
+	defer conn.Close()
+	if common.LDAPBindDN != "" {
+		err = conn.Bind(common.LDAPBindDN, common.LDAPBindPassword)
+		if err != nil {
+			common.SysError("failed to bind the LDAP service account: " + err.Error())
+			return nil, errors.New("LDAP 服务账号认证失败")
+		}
+	}
+	searchRequest := ldap.NewSearchRequest(common.LDAPBaseDN, ldap.ScopeWholeSubtree, ldap.NeverDerefAliases, 2, 0, false,
+		fmt.Sprintf("(%s=%s)", ldap.EscapeFilter(common.LDAPUsernameAttribute), ldap.EscapeFilter(username)),
+		[]string{common.LDAPUsernameAttribute, "mail", "displayName", "cn", common.LDAPGroupAttribute}, nil)
+	result, err := conn.Search(searchRequest)
+	if err != nil && !ldap.IsErrorWithCode(err, ldap.LDAPResultSizeLimitExceeded) {
+		common.SysError("failed to search the LDAP directory: " + err.Error())
+		return nil, errors.New("LDAP 查询失败")
+	}
+	if result == nil || len(result.Entries) != 1 {
+		return nil, errors.New("用户名或密码错误")
+	}
+	entry := result.Entries[0]
+	err = conn.Bind(entry.DN, password)
+	if err != nil {
+		if ldap.IsErrorWithCode(err, ldap.LDAPResultInvalidCredentials) {
+			return nil, errors.New("用户名或密码错误")
+		}
+		common.SysError("failed to bind the LDAP user: " + err.Error())
+		return nil, errors.New("LDAP 认证失败")
+	}
+	return entry, nil
+}
+
+func LDAPLogin(c *gin.Context) {
+	if !common.LDAPAuthEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "管理员未开启通过 LDAP 登录",
+			"success": false,
+		})
+		return
+	}
+	var loginRequest LoginRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&loginRequest)
+	if err != nil || loginRequest.Username == "" || loginRequest.Password == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无效的参数",
+			"success": false,
+		})
+		return
+	}
+	entry, err := authenticateLDAPUser(loginRequest.Username, loginRequest.Password)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	// the user is identified by the username as the directory stores it, not as typed, or else by its DN
+	ldapId := entry.GetEqualFoldAttributeValue(common.LDAPUsernameAttribute)
+	if ldapId == "" {
+		ldapId = entry.DN
+	}
+	// the role follows the groups on every login once the groups are mapped, the root user is never changed
+	role := 0
+	if len(common.LDAPGroupRoles) > 0 {
+		role = common.GetLDAPGroupsRole(entry.GetEqualFoldAttributeValues(common.LDAPGroupAttribute))
+		if role == 0 {
+			role = common.RoleCommonUser
+		}
+	}
+	user := model.User{
+		LDAPId: ldapId,
+	}
+	if model.IsLDAPIdAlreadyTaken(user.LDAPId) {
+		err := user.FillUserByLDAPId()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if role != 0 && user.Role != role && user.Role != common.RoleRootUser {
+			err = model.UpdateUserRole(user.Id, role)
+			if err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": err.Error(),
+				})
+				return
+			}
+			user.Role = role
+		}
+	} else {
+		if common.LDAPAutoProvisionEnabled {
+			if len(ldapId) <= 12 && !model.IsUsernameAlreadyTaken(ldapId) {
+				user.Username = ldapId
+			} else {
+				user.Username = "ldap_" + strconv.Itoa(model.GetMaxUserId()+1)
+			}
+			user.DisplayName = entry.GetEqualFoldAttributeValue("displayName")
+			if user.DisplayName == "" {
+				user.DisplayName = entry.GetEqualFoldAttributeValue("cn")
+			}
+			if user.DisplayName == "" {
+				user.DisplayName = "LDAP User"
+			}
+			user.Email = entry.GetEqualFoldAttributeValue("mail")
+			user.Role = common.RoleCommonUser
+			if role != 0 {
+				user.Role = role
+			}
+			user.Status = common.UserStatusEnabled
+
+			if err := user.Insert(0); err != nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": err.Error(),
+				})
+				return
+			}
+		} else {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "该 LDAP 用户尚未开通账户",
+			})
+			return
+		}
+	}
+
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	setupLogin(&user, c)
+}
@@ -16,23 +16,30 @@ func GetStatus(c *gin.Context) {
 		"success": true,
 		"message": "",
 		"data": gin.H{
-			"version":             common.Version,
-			"start_time":          common.StartTime,
-			"email_verification":  common.EmailVerificationEnabled,
-			"github_oauth":        common.GitHubOAuthEnabled,
-			"github_client_id":    common.GitHubClientId,
-			"system_name":         common.SystemName,
-			"logo":                common.Logo,
-			"footer_html":         common.Footer,
-			"wechat_qrcode":       common.WeChatAccountQRCodeImageURL,
-			"wechat_login":        common.WeChatAuthEnabled,
-			"server_address":      common.ServerAddress,
-			"turnstile_check":     common.TurnstileCheckEnabled,
-			"turnstile_site_key":  common.TurnstileSiteKey,
-			"top_up_link":         common.TopUpLink,
-			"chat_link":           common.ChatLink,
-			"quota_per_unit":      common.QuotaPerUnit,
-			"display_in_currency": common.DisplayInCurrencyEnabled,
+			"version":                common.Version,
+			"start_time":             common.StartTime,
+			"email_verification":     common.EmailVerificationEnabled,
+			"github_oauth":           common.GitHubOAuthEnabled,
+			"github_client_id":       common.GitHubClientId,
+			"ldap_auth":              common.LDAPAuthEnabled,
+			"system_name":            common.SystemName,
+			"logo":                   common.Logo,
+			"footer_html":            common.Footer,
+			"wechat_qrcode":          common.WeChatAccountQRCodeImageURL,
+			"wechat_login":           common.WeChatAuthEnabled,
+			"server_address":         common.ServerAddress,
+			"turnstile_check":        common.TurnstileCheckEnabled,
+			"turnstile_site_key":     common.TurnstileSiteKey,
+			"top_up_link":            common.TopUpLink,
+			"epay_enabled":           common.EpayAddress != "" && common.EpayId != "" && common.EpaySecret != "",
+			"top_up_price":           common.TopUpPrice,
+			"min_top_up":             common.MinTopUp,
+			"quota_transfer_enabled": common.QuotaTransferEnabled,
+			"chat_link":              common.ChatLink,
+			"quota_per_unit":         common.QuotaPerUnit,
+			"display_in_currency":    common.DisplayInCurrencyEnabled,
+			"display_currency":       common.GetQuotaDisplayType(),
+			"exchange_rate":          common.StatementExchangeRate,
 		},
 	})
 	return
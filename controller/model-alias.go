@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllModelAliases(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	aliases, err := model.GetAllModelAliases(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    aliases,
+	})
+	return
+}
+
+func GetModelAlias(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	alias, err := model.GetModelAliasById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    alias,
+	})
+	return
+}
+
+func validateModelAlias(alias *model.ModelAlias) error {
+	if alias.Alias == "" || len(alias.Alias) > 64 || alias.Model == "" || len(alias.Model) > 64 {
+		return errors.New("别名与模型名称不能为空且不能超过 64 个字符")
+	}
+	if alias.Alias == alias.Model {
+		return errors.New("别名不能与模型名称相同")
+	}
+	// the alias is applied once, so the target must not be an alias itself
+	if target := model.GetModelAlias(alias.Model); target != nil && target.Id != alias.Id {
+		return errors.New("目标模型不能是另一个别名")
+	}
+	return nil
+}
+
+func AddModelAlias(c *gin.Context) {
+	alias := model.ModelAlias{}
+	err := c.ShouldBindJSON(&alias)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateModelAlias(&alias); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanAlias := model.ModelAlias{
+		Alias:       alias.Alias,
+		Model:       alias.Model,
+		Deprecated:  alias.Deprecated,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = cleanAlias.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanAlias,
+	})
+	return
+}
+
+func UpdateModelAlias(c *gin.Context) {
+	alias := model.ModelAlias{}
+	err := c.ShouldBindJSON(&alias)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validateModelAlias(&alias); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanAlias, err := model.GetModelAliasById(alias.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanAlias.Alias = alias.Alias
+	cleanAlias.Model = alias.Model
+	cleanAlias.Deprecated = alias.Deprecated
+	err = cleanAlias.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanAlias,
+	})
+	return
+}
+
+func DeleteModelAlias(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	alias := model.ModelAlias{Id: id}
+	err := alias.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
@@ -0,0 +1,313 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type upstreamModelList struct {
+	Data []struct {
+		Id      string `json:"id"`
+		OwnedBy string `json:"owned_by"`
+	} `json:"data"`
+}
+
+type ModelSyncResult struct {
+	Added    []string `json:"added"`    // priced with a template
+	Unpriced []string `json:"unpriced"` // no template matches, the requests are billed with the default ratio until one is set
+}
+
+type ChannelModelSyncResult struct {
+	Added   []string `json:"added"`
+	Removed []string `json:"removed"`
+	Models  []string `json:"models"`
+}
+
+var modelSyncLock sync.Mutex
+
+// the unpriced models are reported once per process so that the root user is not mailed on every run
+var reportedUnpricedModels = make(map[string]bool)
+
+// fetchUpstreamModels lists the models of an OpenAI compatible channel with their owners
+func fetchUpstreamModels(channel *model.Channel) (map[string]string, error) {
+	if getAPIType(channel.Type) != APITypeOpenAI || channel.Type == common.ChannelTypeAzure {
+		return nil, fmt.Errorf("channel type %d has no model list", channel.Type)
+	}
+	baseURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		baseURL = channel.BaseURL
+	}
+	key, _ := model.PickChannelKey(channel)
+	body, err := GetResponseBody("GET", fmt.Sprintf("%s/v1/models", baseURL), channel, GetAuthHeader(key))
+	if err != nil {
+		if channel.Type == common.ChannelTypeLocal {
+			return fetchOllamaModels(channel, baseURL)
+		}
+		return nil, err
+	}
+	var list upstreamModelList
+	err = json.Unmarshal(body, &list)
+	if err != nil {
+		return nil, err
+	}
+	models := make(map[string]string, len(list.Data))
+	for _, item := range list.Data {
+		if item.Id != "" {
+			models[item.Id] = item.OwnedBy
+		}
+	}
+	return models, nil
+}
+
+// updateChannelModels replaces the models of the channel with the ones of its upstream, the models which the mapping
+// of the channel renames are kept as the upstream does not list them
+func updateChannelModels(channel *model.Channel, upstreamModels map[string]string) (*ChannelModelSyncResult, error) {
+	if len(upstreamModels) == 0 {
+		return nil, errors.New("上游未返回任何模型")
+	}
+	modelMapping := make(map[string]string)
+	if channel.ModelMapping != "" {
+		err := json.Unmarshal([]byte(channel.ModelMapping), &modelMapping)
+		if err != nil {
+			return nil, err
+		}
+	}
+	oldModels := make(map[string]bool)
+	for _, name := range strings.Split(channel.Models, ",") {
+		if name != "" {
+			oldModels[name] = true
+		}
+	}
+	newModels := make(map[string]bool, len(upstreamModels))
+	for id := range upstreamModels {
+		newModels[id] = true
+	}
+	for name := range modelMapping {
+		if oldModels[name] {
+			newModels[name] = true
+		}
+	}
+	result := &ChannelModelSyncResult{Added: []string{}, Removed: []string{}, Models: make([]string, 0, len(newModels))}
+	for name := range newModels {
+		result.Models = append(result.Models, name)
+		if !oldModels[name] {
+			result.Added = append(result.Added, name)
+		}
+	}
+	for name := range oldModels {
+		if !newModels[name] {
+			result.Removed = append(result.Removed, name)
+		}
+	}
+	sort.Strings(result.Models)
+	sort.Strings(result.Added)
+	sort.Strings(result.Removed)
+	if len(result.Added) == 0 && len(result.Removed) == 0 {
+		return result, nil
+	}
+	err := channel.UpdateModels(strings.Join(result.Models, ","))
+	if err != nil {
+		return nil, err
+	}
+	common.SysLog(fmt.Sprintf("models of channel #%d synced: %d added, %d removed", channel.Id, len(result.Added), len(result.Removed)))
+	return result, nil
+}
+
+// syncModels adds the models of the upstreams without a model ratio to the pricing and to /v1/models with the
+// templates, and notifies the root user of the additions and of the models no template matches
+func syncModels() (*ModelSyncResult, error) {
+	modelSyncLock.Lock()
+	defer modelSyncLock.Unlock()
+	channels, err := model.GetAllChannels(0, 0, true)
+	if err != nil {
+		return nil, err
+	}
+	upstreamModels := make(map[string]string)
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled || getAPIType(channel.Type) != APITypeOpenAI || channel.Type == common.ChannelTypeAzure {
+			continue
+		}
+		models, err := fetchUpstreamModels(channel)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to fetch models of channel #%d: %s", channel.Id, err.Error()))
+			continue
+		}
+		for id, ownedBy := range models {
+			upstreamModels[id] = ownedBy
+		}
+		if channel.IsAutoSyncModels() {
+			_, err = updateChannelModels(channel, models)
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to update models of channel #%d: %s", channel.Id, err.Error()))
+			}
+		}
+		time.Sleep(common.RequestInterval)
+	}
+	result := &ModelSyncResult{Added: []string{}, Unpriced: []string{}}
+	modelRatio := make(map[string]float64, len(common.ModelRatio))
+	for name, ratio := range common.ModelRatio {
+		modelRatio[name] = ratio
+	}
+	syncedModels := make(map[string]string, len(common.SyncedModels))
+	for name, ownedBy := range common.SyncedModels {
+		syncedModels[name] = ownedBy
+	}
+	for id, ownedBy := range upstreamModels {
+		if _, ok := modelRatio[id]; ok {
+			continue
+		}
+		template := common.GetModelSyncTemplate(id)
+		if template == nil {
+			result.Unpriced = append(result.Unpriced, id)
+			continue
+		}
+		modelRatio[id] = template.ModelRatio
+		if _, ok := openAIModelsMap[id]; !ok {
+			if template.OwnedBy != "" {
+				ownedBy = template.OwnedBy
+			}
+			if ownedBy == "" {
+				ownedBy = strings.Split(id, "-")[0]
+			}
+			syncedModels[id] = ownedBy
+		}
+		result.Added = append(result.Added, id)
+	}
+	sort.Strings(result.Added)
+	sort.Strings(result.Unpriced)
+	if len(result.Added) != 0 {
+		jsonBytes, err := json.Marshal(modelRatio)
+		if err != nil {
+			return nil, err
+		}
+		err = model.UpdateOption("ModelRatio", string(jsonBytes))
+		if err != nil {
+			return nil, err
+		}
+		jsonBytes, err = json.Marshal(syncedModels)
+		if err != nil {
+			return nil, err
+		}
+		err = model.UpdateOption("SyncedModels", string(jsonBytes))
+		if err != nil {
+			return nil, err
+		}
+	}
+	notifyModelSync(result)
+	return result, nil
+}
+
+func notifyModelSync(result *ModelSyncResult) {
+	var unreported []string
+	for _, id := range result.Unpriced {
+		if !reportedUnpricedModels[id] {
+			reportedUnpricedModels[id] = true
+			unreported = append(unreported, id)
+		}
+	}
+	if len(result.Added) == 0 && len(unreported) == 0 {
+		return
+	}
+	common.SysLog(fmt.Sprintf("model sync: %d models added, %d models without a template", len(result.Added), len(unreported)))
+	if common.RootUserEmail == "" {
+		common.RootUserEmail = model.GetRootUserEmail()
+	}
+	content := ""
+	if len(result.Added) != 0 {
+		content += fmt.Sprintf("以下模型已按模板自动添加倍率：%s。", strings.Join(result.Added, "、"))
+	}
+	if len(unreported) != 0 {
+		content += fmt.Sprintf("以下模型没有匹配的模板，请手动设置模型倍率：%s。", strings.Join(unreported, "、"))
+	}
+	err := common.SendEmail("上游出现新模型", common.RootUserEmail, content)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to send email: %s", err.Error()))
+	}
+}
+
+func SyncModels(c *gin.Context) {
+	result, err := syncModels()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
+
+// SyncChannelModels replaces the models of a channel with the ones of its upstream
+func SyncChannelModels(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	channel, err := model.GetChannelById(id, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if getAPIType(channel.Type) != APITypeOpenAI || channel.Type == common.ChannelTypeAzure {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "该类型的渠道不支持同步模型列表",
+		})
+		return
+	}
+	models, err := fetchUpstreamModels(channel)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	result, err := updateChannelModels(channel, models)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    result,
+	})
+}
+
+func AutomaticallySyncModels(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Minute)
+		common.SysLog("syncing models of all channels")
+		_, err := syncModels()
+		if err != nil {
+			common.SysError("failed to sync models: " + err.Error())
+		}
+		common.SysLog("model sync done")
+	}
+}
@@ -2,6 +2,8 @@ package controller
 
 import (
 	"fmt"
+	"one-api/common"
+	"sort"
 
 	"github.com/gin-gonic/gin"
 )
@@ -270,6 +272,168 @@ func init() {
 			Root:       "claude-2",
 			Parent:     nil,
 		},
+		{
+			Id:         "claude-3-haiku-20240307",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-haiku-20240307",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-3-5-haiku-20241022",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-5-haiku-20241022",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-3-sonnet-20240229",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-sonnet-20240229",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-3-5-sonnet-20241022",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-5-sonnet-20241022",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-3-7-sonnet-20250219",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-7-sonnet-20250219",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-sonnet-4-20250514",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-sonnet-4-20250514",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-3-opus-20240229",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-3-opus-20240229",
+			Parent:     nil,
+		},
+		{
+			Id:         "claude-opus-4-20250514",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "anthropic",
+			Permission: permission,
+			Root:       "claude-opus-4-20250514",
+			Parent:     nil,
+		},
+		{
+			Id:         "gemini-1.5-flash",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "google",
+			Permission: permission,
+			Root:       "gemini-1.5-flash",
+			Parent:     nil,
+		},
+		{
+			Id:         "gemini-1.5-pro",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "google",
+			Permission: permission,
+			Root:       "gemini-1.5-pro",
+			Parent:     nil,
+		},
+		{
+			Id:         "gemini-2.0-flash",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "google",
+			Permission: permission,
+			Root:       "gemini-2.0-flash",
+			Parent:     nil,
+		},
+		{
+			Id:         "gemini-2.5-flash",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "google",
+			Permission: permission,
+			Root:       "gemini-2.5-flash",
+			Parent:     nil,
+		},
+		{
+			Id:         "gemini-2.5-pro",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "google",
+			Permission: permission,
+			Root:       "gemini-2.5-pro",
+			Parent:     nil,
+		},
+		{
+			Id:         "llama3-8b-instruct",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "meta",
+			Permission: permission,
+			Root:       "llama3-8b-instruct",
+			Parent:     nil,
+		},
+		{
+			Id:         "llama3-70b-instruct",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "meta",
+			Permission: permission,
+			Root:       "llama3-70b-instruct",
+			Parent:     nil,
+		},
+		{
+			Id:         "llama3.1-8b-instruct",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "meta",
+			Permission: permission,
+			Root:       "llama3.1-8b-instruct",
+			Parent:     nil,
+		},
+		{
+			Id:         "llama3.1-70b-instruct",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "meta",
+			Permission: permission,
+			Root:       "llama3.1-70b-instruct",
+			Parent:     nil,
+		},
+		{
+			Id:         "llama3.1-405b-instruct",
+			Object:     "model",
+			Created:    1677649963,
+			OwnedBy:    "meta",
+			Permission: permission,
+			Root:       "llama3.1-405b-instruct",
+			Parent:     nil,
+		},
 		{
 			Id:         "ERNIE-Bot",
 			Object:     "model",
@@ -394,10 +558,38 @@ func init() {
 	}
 }
 
+// getSyncedModel describes a model added by the model synchronization like the built-in ones
+func getSyncedModel(id string, ownedBy string) OpenAIModels {
+	return OpenAIModels{
+		Id:         id,
+		Object:     "model",
+		Created:    1677649963,
+		OwnedBy:    ownedBy,
+		Permission: openAIModels[0].Permission,
+		Root:       id,
+		Parent:     nil,
+	}
+}
+
 func ListModels(c *gin.Context) {
+	models := openAIModels
+	if len(common.SyncedModels) != 0 {
+		models = make([]OpenAIModels, 0, len(openAIModels)+len(common.SyncedModels))
+		models = append(models, openAIModels...)
+		var ids []string
+		for id := range common.SyncedModels {
+			if _, ok := openAIModelsMap[id]; !ok {
+				ids = append(ids, id)
+			}
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			models = append(models, getSyncedModel(id, common.SyncedModels[id]))
+		}
+	}
 	c.JSON(200, gin.H{
 		"object": "list",
-		"data":   openAIModels,
+		"data":   models,
 	})
 }
 
@@ -405,6 +597,8 @@ func RetrieveModel(c *gin.Context) {
 	modelId := c.Param("model")
 	if model, ok := openAIModelsMap[modelId]; ok {
 		c.JSON(200, model)
+	} else if ownedBy, ok := common.SyncedModels[modelId]; ok {
+		c.JSON(200, getSyncedModel(modelId, ownedBy))
 	} else {
 		openAIError := OpenAIError{
 			Message: fmt.Sprintf("The model '%s' does not exist", modelId),
@@ -2,9 +2,11 @@ package controller
 
 import (
 	"encoding/json"
+	"fmt"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"strconv"
 	"strings"
 
 	"github.com/gin-gonic/gin"
@@ -14,7 +16,7 @@ func GetOptions(c *gin.Context) {
 	var options []*model.Option
 	common.OptionMapRWMutex.Lock()
 	for k, v := range common.OptionMap {
-		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") {
+		if strings.HasSuffix(k, "Token") || strings.HasSuffix(k, "Secret") || strings.HasSuffix(k, "Password") {
 			continue
 		}
 		options = append(options, &model.Option{
@@ -50,6 +52,43 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "LDAPAuthEnabled":
+		if option.Value == "true" && (common.LDAPServerURL == "" || common.LDAPBaseDN == "") {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无法启用 LDAP 登录，请先填入 LDAP 服务器地址以及用户搜索的 Base DN！",
+			})
+			return
+		}
+	case "TOTPRequiredForAdminEnabled":
+		if option.Value == "true" {
+			user, err := model.GetUserById(c.GetInt("id"), false)
+			if err != nil || !user.TOTPEnabled {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "无法强制管理员开启两步验证，请先为当前账户开启两步验证！",
+				})
+				return
+			}
+		}
+	case "LDAPGroupRoles":
+		var groupRoles map[string]int
+		err = json.Unmarshal([]byte(option.Value), &groupRoles)
+		if err == nil {
+			for _, role := range groupRoles {
+				if role != common.RoleCommonUser && role != common.RoleAdminUser {
+					err = fmt.Errorf("LDAP 分组只能映射到普通用户（%d）或管理员（%d）", common.RoleCommonUser, common.RoleAdminUser)
+					break
+				}
+			}
+		}
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "LDAP 分组角色映射不合法：" + err.Error(),
+			})
+			return
+		}
 	case "EmailDomainRestrictionEnabled":
 		if option.Value == "true" && len(common.EmailDomainWhitelist) == 0 {
 			c.JSON(http.StatusOK, gin.H{
@@ -74,6 +113,76 @@ func UpdateOption(c *gin.Context) {
 			})
 			return
 		}
+	case "StatementCurrency":
+		if strings.TrimSpace(option.Value) == "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "货币不能为空",
+			})
+			return
+		}
+	case "StatementExchangeRate":
+		if rate, err := strconv.ParseFloat(option.Value, 64); err != nil || rate <= 0 {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "汇率必须为正数",
+			})
+			return
+		}
+	case "ModelChannelGroups", "GroupChannelGroups":
+		var channelGroups map[string]string
+		if err := json.Unmarshal([]byte(option.Value), &channelGroups); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "渠道组配置不是合法的 JSON 字符串",
+			})
+			return
+		}
+		for _, channelGroup := range channelGroups {
+			if model.GetChannelGroupByName(channelGroup) == nil {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": fmt.Sprintf("渠道组 %s 不存在", channelGroup),
+				})
+				return
+			}
+		}
+	case "GroupModerationLevel":
+		var levels map[string]string
+		if err := json.Unmarshal([]byte(option.Value), &levels); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "审核级别不是合法的 JSON 字符串",
+			})
+			return
+		}
+		for _, level := range levels {
+			if !common.IsValidModerationLevel(level) {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "审核级别只能为 off、flag 或 block",
+				})
+				return
+			}
+		}
+	case "ModelRoutingMode":
+		var modes map[string]string
+		if err := json.Unmarshal([]byte(option.Value), &modes); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "路由模式不是合法的 JSON 字符串",
+			})
+			return
+		}
+		for _, mode := range modes {
+			if !common.IsValidRoutingMode(mode) {
+				c.JSON(http.StatusOK, gin.H{
+					"success": false,
+					"message": "路由模式只能为 weighted 或 latency",
+				})
+				return
+			}
+		}
 	}
 	err = model.UpdateOption(option.Key, option.Value)
 	if err != nil {
@@ -0,0 +1,401 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type OrganizationRequest struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Quota int    `json:"quota"`
+	Owner string `json:"owner"` // the username of the first organization admin, only on creation
+}
+
+type OrganizationMemberRequest struct {
+	UserId   int    `json:"user_id"`
+	Username string `json:"username"`
+	Role     int    `json:"role"`
+	Budget   int    `json:"budget"`
+}
+
+type FundOrganizationRequest struct {
+	Quota int `json:"quota"`
+}
+
+// checkOrganizationAccess writes the response if the user may not access the organization, the admins of the site
+// may access all of them, the members only theirs and only the organization admins may manage them
+func checkOrganizationAccess(c *gin.Context, organizationId int, manage bool) bool {
+	if c.GetInt("role") >= common.RoleAdminUser {
+		return true
+	}
+	member, err := model.GetOrganizationMember(organizationId, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return false
+	}
+	if manage && member.Role != model.OrganizationRoleAdmin {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无权进行此操作，需要组织管理员权限",
+		})
+		return false
+	}
+	return true
+}
+
+func GetAllOrganizations(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	organizations, err := model.GetAllOrganizations(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+func GetSelfOrganizations(c *gin.Context) {
+	organizations, err := model.GetUserOrganizations(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organizations,
+	})
+}
+
+func AddOrganization(c *gin.Context) {
+	req := OrganizationRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(req.Name) == 0 || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称长度必须在1-64之间",
+		})
+		return
+	}
+	if req.Quota < 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "额度不能为负数",
+		})
+		return
+	}
+	organization := model.Organization{
+		Name:        req.Name,
+		Quota:       req.Quota,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = organization.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if req.Owner != "" {
+		_, err = model.AddOrganizationMember(organization.Id, req.Owner, model.OrganizationRoleAdmin, 0)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "组织已创建，但添加组织管理员失败：" + err.Error(),
+			})
+			return
+		}
+	}
+	model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("创建组织 %s，额度 %s", organization.Name, common.LogQuota(organization.Quota)))
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+}
+
+func UpdateOrganization(c *gin.Context) {
+	req := OrganizationRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(req.Name) == 0 || len(req.Name) > 64 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "组织名称长度必须在1-64之间",
+		})
+		return
+	}
+	organization, err := model.GetOrganizationById(req.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	previousQuota := organization.Quota
+	organization.Name = req.Name
+	organization.Quota = req.Quota
+	err = organization.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if previousQuota != organization.Quota {
+		model.RecordLog(c.GetInt("id"), model.LogTypeManage, fmt.Sprintf("将组织 %s 的额度从 %s 修改为 %s", organization.Name,
+			common.LogQuota(previousQuota), common.LogQuota(organization.Quota)))
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    organization,
+	})
+}
+
+func DeleteOrganization(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteOrganizationById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// GetOrganizationUsage is the consolidated usage of the organization, the pool and what each member has drawn from it
+func GetOrganizationUsage(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if !checkOrganizationAccess(c, id, true) {
+		return
+	}
+	organization, err := model.GetOrganizationById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	members, err := model.GetOrganizationMembers(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"organization": organization,
+			"members":      members,
+		},
+	})
+}
+
+func validateOrganizationMember(req *OrganizationMemberRequest) string {
+	if !model.IsValidOrganizationRole(req.Role) {
+		return "无效的组织角色"
+	}
+	if req.Budget < 0 {
+		return "预算不能为负数"
+	}
+	return ""
+}
+
+func AddOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if !checkOrganizationAccess(c, id, true) {
+		return
+	}
+	req := OrganizationMemberRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if message := validateOrganizationMember(&req); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	member, err := model.AddOrganizationMember(id, req.Username, req.Role, req.Budget)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    member,
+	})
+}
+
+// ensureOrganizationAdminLeft refuses the changes which would leave the organization without an admin, unless an
+// admin of the site makes them
+func ensureOrganizationAdminLeft(c *gin.Context, organizationId int, userId int) bool {
+	if c.GetInt("role") >= common.RoleAdminUser {
+		return true
+	}
+	member, err := model.GetOrganizationMember(organizationId, userId)
+	if err != nil || member.Role != model.OrganizationRoleAdmin {
+		return true
+	}
+	count, err := model.CountOrganizationAdmins(organizationId)
+	if err == nil && count > 1 {
+		return true
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": false,
+		"message": "组织至少需要保留一名组织管理员",
+	})
+	return false
+}
+
+func UpdateOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if !checkOrganizationAccess(c, id, true) {
+		return
+	}
+	req := OrganizationMemberRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if message := validateOrganizationMember(&req); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	if req.Role != model.OrganizationRoleAdmin && !ensureOrganizationAdminLeft(c, id, req.UserId) {
+		return
+	}
+	err = model.UpdateOrganizationMember(id, req.UserId, req.Role, req.Budget)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// DeleteOrganizationMember removes a member, the members may also leave by themselves
+func DeleteOrganizationMember(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId, _ := strconv.Atoi(c.Param("user_id"))
+	if !checkOrganizationAccess(c, id, userId != c.GetInt("id")) {
+		return
+	}
+	if !ensureOrganizationAdminLeft(c, id, userId) {
+		return
+	}
+	err := model.DeleteOrganizationMember(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// FundOrganization moves quota of the member into the pool, so that the members can top up their organization
+func FundOrganization(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	if _, err := model.GetOrganizationMember(id, c.GetInt("id")); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	req := FundOrganizationRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = model.FundOrganization(id, c.GetInt("id"), req.Quota)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
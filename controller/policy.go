@@ -0,0 +1,182 @@
+package controller
+
+import (
+	"errors"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetAllPolicies(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	policies, err := model.GetAllPolicies(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policies,
+	})
+	return
+}
+
+func GetPolicy(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	policy, err := model.GetPolicyById(id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    policy,
+	})
+	return
+}
+
+func validatePolicy(policy *model.Policy) error {
+	if policy.Scope != model.PolicyScopeGroup && policy.Scope != model.PolicyScopeChannel {
+		return errors.New("策略作用范围必须是 group 或 channel")
+	}
+	if policy.Target == "" {
+		return errors.New("请指定策略作用的分组或渠道")
+	}
+	if policy.Scope == model.PolicyScopeChannel {
+		if _, err := strconv.Atoi(policy.Target); err != nil {
+			return errors.New("渠道策略的作用对象必须是渠道 ID")
+		}
+	}
+	_, err := model.ParsePolicyRules(policy.Rules)
+	return err
+}
+
+func AddPolicy(c *gin.Context) {
+	policy := model.Policy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validatePolicy(&policy); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPolicy := model.Policy{
+		Name:        policy.Name,
+		Scope:       policy.Scope,
+		Target:      policy.Target,
+		Priority:    policy.Priority,
+		Rules:       policy.Rules,
+		Status:      common.PolicyStatusEnabled,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = cleanPolicy.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPolicy,
+	})
+	return
+}
+
+func UpdatePolicy(c *gin.Context) {
+	policy := model.Policy{}
+	err := c.ShouldBindJSON(&policy)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if err = validatePolicy(&policy); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPolicy, err := model.GetPolicyById(policy.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanPolicy.Name = policy.Name
+	cleanPolicy.Scope = policy.Scope
+	cleanPolicy.Target = policy.Target
+	cleanPolicy.Priority = policy.Priority
+	cleanPolicy.Rules = policy.Rules
+	if policy.Status != 0 {
+		cleanPolicy.Status = policy.Status
+	}
+	err = cleanPolicy.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanPolicy,
+	})
+	return
+}
+
+func DeletePolicy(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	policy := model.Policy{Id: id}
+	err := policy.Delete()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+	return
+}
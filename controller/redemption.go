@@ -1,11 +1,15 @@
 package controller
 
 import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
 	"strconv"
+	"time"
 )
 
 func GetAllRedemptions(c *gin.Context) {
@@ -96,38 +100,137 @@ func AddRedemption(c *gin.Context) {
 		})
 		return
 	}
-	if redemption.Count > 100 {
+	if redemption.Count > 10000 {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
-			"message": "一次兑换码批量生成的个数不能大于 100",
+			"message": "一次兑换码批量生成的个数不能大于 10000",
 		})
 		return
 	}
-	var keys []string
+	if len(redemption.Prefix) > 10 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "兑换码前缀长度不能超过 10",
+		})
+		return
+	}
+	if redemption.ExpiredTime == 0 {
+		redemption.ExpiredTime = -1
+	}
+	if redemption.ExpiredTime != -1 && redemption.ExpiredTime < common.GetTimestamp() {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "兑换码过期时间不能早于当前时间",
+		})
+		return
+	}
+	if redemption.MaxRedemptions <= 0 {
+		redemption.MaxRedemptions = 1
+	}
+	batch := fmt.Sprintf("%s-%s", time.Now().Format("20060102150405"), common.GetRandomString(6))
+	keys := make([]string, 0, redemption.Count)
+	redemptions := make([]*model.Redemption, 0, redemption.Count)
 	for i := 0; i < redemption.Count; i++ {
-		key := common.GetUUID()
-		cleanRedemption := model.Redemption{
-			UserId:      c.GetInt("id"),
-			Name:        redemption.Name,
-			Key:         key,
-			CreatedTime: common.GetTimestamp(),
-			Quota:       redemption.Quota,
-		}
-		err = cleanRedemption.Insert()
-		if err != nil {
-			c.JSON(http.StatusOK, gin.H{
-				"success": false,
-				"message": err.Error(),
-				"data":    keys,
-			})
-			return
-		}
+		// the key column is 32 characters, the prefix takes part of the random UUID
+		key := redemption.Prefix + common.GetUUID()[:32-len(redemption.Prefix)]
+		redemptions = append(redemptions, &model.Redemption{
+			UserId:         c.GetInt("id"),
+			Name:           redemption.Name,
+			Key:            key,
+			CreatedTime:    common.GetTimestamp(),
+			Quota:          redemption.Quota,
+			Batch:          batch,
+			ExpiredTime:    redemption.ExpiredTime,
+			MaxRedemptions: redemption.MaxRedemptions,
+			CreditDays:     redemption.CreditDays,
+		})
 		keys = append(keys, key)
 	}
+	err = model.InsertRedemptions(redemptions)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
 		"data":    keys,
+		"batch":   batch,
+	})
+	return
+}
+
+func ExportRedemptions(c *gin.Context) {
+	batch := c.Param("batch")
+	redemptions, err := model.GetRedemptionsByBatch(batch)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if len(redemptions) == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "批次不存在",
+		})
+		return
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"id", "name", "key", "quota", "expired_time", "max_redemptions", "redeemed_count", "status"})
+	for _, redemption := range redemptions {
+		expiredTime := ""
+		if redemption.ExpiredTime != -1 {
+			expiredTime = time.Unix(redemption.ExpiredTime, 0).Format("2006-01-02 15:04:05")
+		}
+		_ = writer.Write([]string{
+			strconv.Itoa(redemption.Id),
+			redemption.Name,
+			redemption.Key,
+			strconv.Itoa(redemption.Quota),
+			expiredTime,
+			strconv.Itoa(redemption.MaxRedemptions),
+			strconv.Itoa(redemption.RedeemedCount),
+			strconv.Itoa(redemption.Status),
+		})
+	}
+	writer.Flush()
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=redemptions-%s.csv", batch))
+	c.Data(http.StatusOK, "text/csv; charset=utf-8", buf.Bytes())
+}
+
+type revokeRedemptionsRequest struct {
+	Batch string `json:"batch"`
+	Ids   []int  `json:"ids"`
+}
+
+func RevokeRedemptions(c *gin.Context) {
+	request := revokeRedemptionsRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	count, err := model.RevokeRedemptions(request.Batch, request.Ids)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    count,
 	})
 	return
 }
@@ -174,6 +277,13 @@ func UpdateRedemption(c *gin.Context) {
 		// If you add more fields, please also update redemption.Update()
 		cleanRedemption.Name = redemption.Name
 		cleanRedemption.Quota = redemption.Quota
+		if redemption.ExpiredTime != 0 {
+			cleanRedemption.ExpiredTime = redemption.ExpiredTime
+		}
+		if redemption.MaxRedemptions > 0 {
+			cleanRedemption.MaxRedemptions = redemption.MaxRedemptions
+		}
+		cleanRedemption.CreditDays = redemption.CreditDays
 	}
 	err = cleanRedemption.Update()
 	if err != nil {
@@ -64,18 +64,18 @@ func requestOpenAI2Ali(request GeneralOpenAIRequest) *AliChatRequest {
 		message := request.Messages[i]
 		if message.Role == "system" {
 			messages = append(messages, AliMessage{
-				User: message.Content,
+				User: message.StringContent(),
 				Bot:  "Okay",
 			})
 			continue
 		} else {
 			if i == len(request.Messages)-1 {
-				prompt = message.Content
+				prompt = message.StringContent()
 				break
 			}
 			messages = append(messages, AliMessage{
-				User: message.Content,
-				Bot:  request.Messages[i+1].Content,
+				User: message.StringContent(),
+				Bot:  request.Messages[i+1].StringContent(),
 			})
 			i++
 		}
@@ -150,9 +150,12 @@ func aliStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStat
 		}
 		return 0, nil, nil
 	})
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
 	go func() {
+		defer close(stopChan)
 		for scanner.Scan() {
 			data := scanner.Text()
 			if len(data) < 5 { // ignore blank line or wrong format
@@ -162,15 +165,18 @@ func aliStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStat
 				continue
 			}
 			data = data[5:]
-			dataChan <- data
+			select {
+			case dataChan <- data:
+			case <-keeper.Done():
+				return
+			}
 		}
-		stopChan <- true
 	}()
-	setEventStreamHeaders(c)
 	lastResponseText := ""
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
+			keeper.Touch()
 			var aliResponse AliChatResponse
 			err := json.Unmarshal([]byte(data), &aliResponse)
 			if err != nil {
@@ -190,12 +196,14 @@ func aliStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStat
 			}
 			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonResponse)})
 			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
 		case <-stopChan:
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
 	})
-	err := resp.Body.Close()
+	err := keeper.Finish(resp.Body, stopChan)
 	if err != nil {
 		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
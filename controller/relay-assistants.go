@@ -0,0 +1,417 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the Assistants API of Azure is only in the preview API versions
+const defaultAzureAssistantsAPIVersion = "2024-05-01-preview"
+
+// the Assistants API is only served with the beta header
+const defaultAssistantsBeta = "assistants=v2"
+
+// AssistantsRequest is the part of a request of the Assistants API used for its routing and its billing, the request
+// is relayed as it is, see https://platform.openai.com/docs/api-reference/assistants
+type AssistantsRequest struct {
+	Model       string `json:"model"`
+	AssistantId string `json:"assistant_id"`
+	Stream      bool   `json:"stream"`
+}
+
+// AssistantsObject is the part of an assistant, a thread or a run used for their ownership and the billing of the runs
+type AssistantsObject struct {
+	Id       string `json:"id"`
+	Object   string `json:"object"`
+	Model    string `json:"model"`
+	ThreadId string `json:"thread_id"`
+	Status   string `json:"status"`
+	Usage    *Usage `json:"usage"`
+}
+
+type AssistantsList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	FirstId *string           `json:"first_id"`
+	LastId  *string           `json:"last_id"`
+	HasMore bool              `json:"has_more"`
+}
+
+// the runs in these states are done, their usage is final
+var assistantsRunDoneStatuses = map[string]bool{
+	"completed":  true,
+	"failed":     true,
+	"cancelled":  true,
+	"expired":    true,
+	"incomplete": true,
+}
+
+func relayAssistantsHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel")
+	if getAPIType(channelType) != APITypeOpenAI {
+		// the upstreams of their own formats have no Assistants API, another channel of the model may have
+		return errorWrapper(fmt.Errorf("channel type %d does not support the Assistants API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
+	userId := c.GetInt("id")
+	tokenId := c.GetInt("token_id")
+	path := c.Request.URL.Path
+	threadId := c.Param("thread_id")
+	createsRun := c.Request.Method == http.MethodPost && (path == "/v1/threads/runs" || path == "/v1/threads/"+threadId+"/runs")
+
+	var assistantsRequest AssistantsRequest
+	if c.Request.Method == http.MethodPost {
+		err := common.UnmarshalBodyReusable(c, &assistantsRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		// the attachments and the tool resources may use the files of the gateway
+		errWithStatusCode := resolveUpstreamFiles(c)
+		if errWithStatusCode != nil {
+			return errWithStatusCode
+		}
+	}
+	if createsRun {
+		if threadId != "" {
+			// the thread is run by an assistant of the token, created with the same key as the thread
+			assistant, err := model.GetUserUpstreamObject(assistantsRequest.AssistantId, userId)
+			if err != nil || assistant.TokenId != tokenId || assistant.ChannelId != c.GetInt("channel_id") ||
+				assistant.KeyHash != c.GetString("channel_key_hash") {
+				return errorWrapper(fmt.Errorf("assistant %s not found", assistantsRequest.AssistantId), "invalid_field_value", http.StatusNotFound)
+			}
+		}
+		if c.GetBool("consume_quota") {
+			// the runs are billed once they are done, their cost is unknown before
+			userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
+			if err != nil {
+				return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+			}
+			if userQuota <= 0 {
+				return quotaExhaustedErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+			}
+		}
+	}
+
+	if assistantsRequest.Model != "" {
+		errWithStatusCode := mapRequestModel(c, assistantsRequest.Model)
+		if errWithStatusCode != nil {
+			return errWithStatusCode
+		}
+	}
+
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	fullRequestURL := getUpstreamRequestURL(c, c.GetString("request_model"), defaultAzureAssistantsAPIVersion)
+	req, err := newUpstreamRequest(c, fullRequestURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if req.Header.Get("OpenAI-Beta") == "" {
+		req.Header.Set("OpenAI-Beta", defaultAssistantsBeta)
+	}
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return getOpenAIResponseError(resp)
+	}
+
+	var objects []AssistantsObject
+	if assistantsRequest.Stream {
+		err = relayEventStream(c, resp, func(data string) {
+			var object AssistantsObject
+			if json.Unmarshal([]byte(data), &object) == nil && object.Id != "" {
+				objects = append(objects, object)
+			}
+		})
+		if err != nil {
+			return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+		}
+	} else {
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		}
+		err = resp.Body.Close()
+		if err != nil {
+			return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+		}
+		objects, responseBody, err = getAssistantsObjects(c, responseBody)
+		if err != nil {
+			return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+		}
+		copyResponseHeaders(c, resp)
+		c.Writer.Header().Del("Content-Length")
+		c.Writer.WriteHeader(resp.StatusCode)
+		_, err = c.Writer.Write(responseBody)
+		if err != nil {
+			return errorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+		}
+	}
+
+	// the messages of the threads are deleted on the longer paths, they are not recorded
+	if c.Request.Method == http.MethodDelete && strings.Count(path, "/") == 3 {
+		model.DeleteUpstreamObject(path[strings.LastIndex(path, "/")+1:])
+	}
+	recordAssistantsObjects(c, objects, createsRun)
+	return nil
+}
+
+// mapRequestModel replaces the model of a request relayed as it is by the one of the channel
+func mapRequestModel(c *gin.Context, requestModel string) *OpenAIErrorWithStatusCode {
+	modelName := requestModel
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping != "" {
+		modelMap := make(map[string]string)
+		err := json.Unmarshal([]byte(modelMapping), &modelMap)
+		if err != nil {
+			return errorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+		}
+		if modelMap[modelName] != "" {
+			modelName = modelMap[modelName]
+		}
+	}
+	if c.GetInt("channel") == common.ChannelTypeAzure {
+		// the model of a request to Azure is the deployment
+		modelName, _ = resolveAzureDeployment(c.GetString("azure_deployments"), c.GetString("api_version"), modelName)
+	}
+	if modelName != requestModel {
+		err := replaceRequestModel(c, modelName)
+		if err != nil {
+			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+	}
+	return nil
+}
+
+// getAssistantsObjects parses the object or the list of the response, the list of the assistants keeps only the ones
+// of the token, the keys of the channels are shared by the tokens
+func getAssistantsObjects(c *gin.Context, responseBody []byte) ([]AssistantsObject, []byte, error) {
+	var list AssistantsList
+	if json.Unmarshal(responseBody, &list) != nil || list.Object != "list" {
+		var object AssistantsObject
+		err := json.Unmarshal(responseBody, &object)
+		return []AssistantsObject{object}, responseBody, err
+	}
+	var owned map[string]bool
+	if c.Request.URL.Path == "/v1/assistants" {
+		ids, err := model.GetTokenUpstreamObjectIds(c.GetInt("token_id"), model.UpstreamObjectTypeAssistant, c.GetInt("channel_id"))
+		if err != nil {
+			return nil, nil, err
+		}
+		owned = make(map[string]bool)
+		for _, id := range ids {
+			owned[id] = true
+		}
+	}
+	var objects []AssistantsObject
+	data := make([]json.RawMessage, 0, len(list.Data))
+	for _, item := range list.Data {
+		var object AssistantsObject
+		err := json.Unmarshal(item, &object)
+		if err != nil {
+			return nil, nil, err
+		}
+		if owned != nil && !owned[object.Id] {
+			continue
+		}
+		objects = append(objects, object)
+		data = append(data, item)
+	}
+	if owned == nil {
+		return objects, responseBody, nil
+	}
+	list.Data = data
+	list.FirstId, list.LastId = nil, nil
+	if len(objects) > 0 {
+		list.FirstId, list.LastId = &objects[0].Id, &objects[len(objects)-1].Id
+	}
+	responseBody, err := json.Marshal(list)
+	return objects, responseBody, err
+}
+
+// recordAssistantsObjects records the created assistants, threads and runs for the token, and bills the runs which are
+// done, each of them once, whichever request sees them done first
+func recordAssistantsObjects(c *gin.Context, objects []AssistantsObject, createsRun bool) {
+	created := make(map[string]bool)
+	for _, object := range objects {
+		objectType := ""
+		switch {
+		case object.Object == "assistant" && c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/assistants":
+			objectType = model.UpstreamObjectTypeAssistant
+		case object.Object == "thread" && c.Request.Method == http.MethodPost && strings.HasPrefix(c.Request.URL.Path, "/v1/threads") && c.Param("thread_id") == "":
+			objectType = model.UpstreamObjectTypeThread
+		case object.Object == "thread.run" && createsRun:
+			objectType = model.UpstreamObjectTypeRun
+		}
+		if objectType != "" && !created[object.Id] {
+			created[object.Id] = true
+			model.RecordUpstreamObject(&model.UpstreamObject{
+				Id:        object.Id,
+				Type:      objectType,
+				UserId:    c.GetInt("id"),
+				TokenId:   c.GetInt("token_id"),
+				ChannelId: c.GetInt("channel_id"),
+				KeyHash:   c.GetString("channel_key_hash"),
+				Model:     c.GetString("request_model"),
+				ThreadId:  object.ThreadId,
+			})
+		}
+		// the thread of a run created with it is not in the response
+		if object.Object == "thread.run" && c.Request.URL.Path == "/v1/threads/runs" && !created[object.ThreadId] {
+			created[object.ThreadId] = true
+			model.RecordUpstreamObject(&model.UpstreamObject{
+				Id:        object.ThreadId,
+				Type:      model.UpstreamObjectTypeThread,
+				UserId:    c.GetInt("id"),
+				TokenId:   c.GetInt("token_id"),
+				ChannelId: c.GetInt("channel_id"),
+				KeyHash:   c.GetString("channel_key_hash"),
+			})
+		}
+		if object.Object == "thread.run" && assistantsRunDoneStatuses[object.Status] && object.Usage != nil &&
+			model.MarkUpstreamObjectBilled(object.Id) {
+			billAssistantsRun(c, object)
+		}
+	}
+}
+
+func billAssistantsRun(c *gin.Context, run AssistantsObject) {
+	go settleAssistantsRun(getQuotaSettlement(c), run)
+}
+
+func settleAssistantsRun(settlement quotaSettlement, run AssistantsObject) {
+	usage := *run.Usage
+	if usage.PromptTokens+usage.CompletionTokens == 0 {
+		return
+	}
+	model.RecordChannelTokens(settlement.channelId, run.Model, usage.PromptTokens+usage.CompletionTokens)
+	modelRatio := common.GetModelRatio(run.Model)
+	groupRatio := common.GetGroupRatio(settlement.group)
+	weightedTokens := getPromptQuota(usage, run.Model) + float64(usage.CompletionTokens)*getCompletionRatio(run.Model)
+	// the budgets of the channel count the list price, without the group ratio and the discounts
+	model.RecordChannelSpend(settlement.channelId, int(weightedTokens*modelRatio))
+	quota := 0
+	if settlement.consumeQuota {
+		ratio := modelRatio * groupRatio
+		quota = int(weightedTokens * ratio)
+		if ratio != 0 && quota <= 0 {
+			quota = 1
+		}
+		chargeLog := ""
+		if quota != 0 {
+			quota, chargeLog = applyModelCharges(run.Model, quota, groupRatio)
+			var couponLog string
+			quota, couponLog = applyUserCoupon(settlement.userId, run.Model, quota)
+			chargeLog += couponLog
+		}
+		logContent := fmt.Sprintf("助手运行 %s，模型倍率 %.2f，分组倍率 %.2f", run.Id, modelRatio, groupRatio)
+		logContent += chargeLog
+		settlement.settle(run.Model, usage.PromptTokens, usage.CompletionTokens, quota, logContent)
+	}
+	cost, priced := common.GetUpstreamCost(run.Model, usage.PromptTokens, usage.CompletionTokens)
+	model.RecordChannelUsage(settlement.channelId, run.Model, usage.PromptTokens, usage.CompletionTokens, quota, cost, priced)
+}
+
+// AutomaticallyBillAssistantsRuns asks the upstreams about the runs which no response through the gateway showed done,
+// such as the ones of the clients which only read the messages of their threads, and bills the done ones
+func AutomaticallyBillAssistantsRuns(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		runs, err := model.GetUnbilledUpstreamRuns(common.GetTimestamp()-int64(frequency), 100)
+		if err != nil {
+			common.SysError("failed to get unbilled runs: " + err.Error())
+			continue
+		}
+		for _, run := range runs {
+			pollAssistantsRun(run)
+		}
+	}
+}
+
+func pollAssistantsRun(object *model.UpstreamObject) {
+	channel, err := model.GetChannelById(object.ChannelId, true)
+	if err != nil {
+		// the run of a deleted channel can no longer be asked about
+		model.MarkUpstreamObjectBilled(object.Id)
+		return
+	}
+	key := channel.Key
+	if object.KeyHash != "" {
+		var ok bool
+		if key, ok = model.GetChannelKeyByHash(channel, object.KeyHash); !ok {
+			model.MarkUpstreamObjectBilled(object.Id)
+			return
+		}
+	}
+	baseURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		baseURL = channel.BaseURL
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/threads/%s/runs/%s", baseURL, object.ThreadId, object.Id)
+	if channel.Type == common.ChannelTypeAzure {
+		fullRequestURL = fmt.Sprintf("%s/openai/threads/%s/runs/%s?api-version=%s", baseURL, object.ThreadId, object.Id, defaultAzureAssistantsAPIVersion)
+	}
+	req, err := http.NewRequest(http.MethodGet, fullRequestURL, nil)
+	if err != nil {
+		return
+	}
+	setChannelFileAuth(req, channel.Type, key)
+	req.Header.Set("OpenAI-Beta", defaultAssistantsBeta)
+	setChannelHeaders(req, channel.Headers)
+	resp, err := getHTTPClient(channel.Proxy).Do(req)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to get upstream run %s: %s", object.Id, err.Error()))
+		return
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		// the thread of the run was deleted, its usage is lost
+		model.MarkUpstreamObjectBilled(object.Id)
+		return
+	}
+	if resp.StatusCode != http.StatusOK {
+		return
+	}
+	var run AssistantsObject
+	err = json.NewDecoder(resp.Body).Decode(&run)
+	if err != nil || !assistantsRunDoneStatuses[run.Status] || run.Usage == nil {
+		return
+	}
+	if !model.MarkUpstreamObjectBilled(object.Id) {
+		return
+	}
+	token, err := model.GetTokenById(object.TokenId)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to bill run %s: %s", object.Id, err.Error()))
+		return
+	}
+	group, _ := model.CacheGetUserGroup(object.UserId)
+	// the model of the run is the one of its assistant unless the run overrides it
+	if run.Model == "" {
+		run.Model = object.Model
+	}
+	settleAssistantsRun(quotaSettlement{
+		userId:            object.UserId,
+		tokenId:           object.TokenId,
+		tokenName:         token.Name,
+		organizationId:    token.OrganizationId,
+		group:             group,
+		channelId:         object.ChannelId,
+		channelKeyHash:    object.KeyHash,
+		consumeQuota:      true,
+		logConsumeEnabled: model.ResolveBoolOption("LogConsumeEnabled", group, object.UserId, object.TokenId, common.LogConsumeEnabled),
+	}, run)
+}
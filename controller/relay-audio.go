@@ -0,0 +1,359 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"mime/multipart"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const maxSpeechInputLength = 4096
+
+type AudioSpeechRequest struct {
+	Model string `json:"model"`
+	Input string `json:"input"`
+	Voice any    `json:"voice"` // the name of a built-in voice, or an object with the id of a custom voice
+}
+
+type AudioResponse struct {
+	Text     string  `json:"text"`
+	Duration float64 `json:"duration"` // only in the verbose_json format
+	Usage    *struct {
+		Type    string  `json:"type"` // duration for whisper-1
+		Seconds float64 `json:"seconds"`
+	} `json:"usage"`
+}
+
+// getDuration is the duration the upstream reports, 0 if it reports none
+func (response AudioResponse) getDuration() float64 {
+	if response.Duration > 0 {
+		return response.Duration
+	}
+	if response.Usage != nil && response.Usage.Type == "duration" {
+		return response.Usage.Seconds
+	}
+	return 0
+}
+
+func getSpeechQuota(modelName string, characters int, groupRatio float64) int {
+	return int(math.Ceil(common.GetSpeechPrice(modelName) * float64(characters) / 1000 * common.QuotaPerUnit * groupRatio))
+}
+
+// getTranscriptionQuota bills the duration rounded up to the second
+func getTranscriptionQuota(modelName string, duration float64, groupRatio float64) int {
+	return int(math.Ceil(common.GetTranscriptionPrice(modelName) * math.Ceil(duration) / 60 * common.QuotaPerUnit * groupRatio))
+}
+
+// buildMultipartForm encodes the form again with the model replaced, the files are copied with their headers
+func buildMultipartForm(form *multipart.Form, modelName string) (*bytes.Buffer, string, error) {
+	body := &bytes.Buffer{}
+	writer := multipart.NewWriter(body)
+	for key, values := range form.Value {
+		if key == "model" {
+			continue
+		}
+		for _, value := range values {
+			err := writer.WriteField(key, value)
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	err := writer.WriteField("model", modelName)
+	if err != nil {
+		return nil, "", err
+	}
+	for _, fileHeaders := range form.File {
+		for _, fileHeader := range fileHeaders {
+			part, err := writer.CreatePart(fileHeader.Header)
+			if err != nil {
+				return nil, "", err
+			}
+			file, err := fileHeader.Open()
+			if err != nil {
+				return nil, "", err
+			}
+			_, err = io.Copy(part, file)
+			_ = file.Close()
+			if err != nil {
+				return nil, "", err
+			}
+		}
+	}
+	err = writer.Close()
+	if err != nil {
+		return nil, "", err
+	}
+	return body, writer.FormDataContentType(), nil
+}
+
+func relayAudioHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel")
+	userId := c.GetInt("id")
+	consumeQuota := c.GetBool("consume_quota")
+	group := c.GetString("group")
+	groupRatio := common.GetGroupRatio(group)
+	if getAPIType(channelType) != APITypeOpenAI {
+		// the upstreams of their own formats have no audio API, another channel of the model may have
+		return errorWrapper(fmt.Errorf("channel type %d does not support the audio API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
+
+	var form *multipart.Form
+	audioModel := ""
+	characters := 0
+	duration := 0.0
+	durationEstimated := false
+	if relayMode == RelayModeAudioSpeech {
+		var speechRequest AudioSpeechRequest
+		err := common.UnmarshalBodyReusable(c, &speechRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		if speechRequest.Model == "" {
+			return errorWrapper(errors.New("model is required"), "required_field_missing", http.StatusBadRequest)
+		}
+		if speechRequest.Input == "" {
+			return errorWrapper(errors.New("input is required"), "required_field_missing", http.StatusBadRequest)
+		}
+		switch voice := speechRequest.Voice.(type) {
+		case string:
+			if voice == "" {
+				return errorWrapper(errors.New("voice is required"), "required_field_missing", http.StatusBadRequest)
+			}
+			if !common.IsSpeechVoiceSupported(speechRequest.Model, voice) {
+				return errorWrapper(fmt.Errorf("voice %s is not supported by %s", voice, speechRequest.Model), "invalid_field_value", http.StatusBadRequest)
+			}
+		case map[string]any:
+		default:
+			return errorWrapper(errors.New("voice is required"), "required_field_missing", http.StatusBadRequest)
+		}
+		characters = utf8.RuneCountInString(speechRequest.Input)
+		if characters > maxSpeechInputLength {
+			return errorWrapper(fmt.Errorf("input must be at most %d characters", maxSpeechInputLength), "invalid_field_value", http.StatusBadRequest)
+		}
+		audioModel = speechRequest.Model
+	} else {
+		var err error
+		form, err = common.ParseMultipartFormReusable(c)
+		if err != nil {
+			return errorWrapper(err, "parse_multipart_form_failed", http.StatusBadRequest)
+		}
+		audioModel = "whisper-1"
+		if len(form.Value["model"]) > 0 && form.Value["model"][0] != "" {
+			audioModel = form.Value["model"][0]
+		}
+		if len(form.File["file"]) == 0 {
+			return errorWrapper(errors.New("file is required"), "required_field_missing", http.StatusBadRequest)
+		}
+		fileHeader := form.File["file"][0]
+		file, err := fileHeader.Open()
+		if err != nil {
+			return errorWrapper(err, "open_audio_file_failed", http.StatusBadRequest)
+		}
+		audio, err := io.ReadAll(file)
+		_ = file.Close()
+		if err != nil {
+			return errorWrapper(err, "read_audio_file_failed", http.StatusBadRequest)
+		}
+		duration, err = common.GetAudioDuration(audio, fileHeader.Filename)
+		if err != nil {
+			duration = common.EstimateAudioDuration(len(audio))
+			durationEstimated = true
+		}
+	}
+
+	// map model name, the form is encoded again rather than changing the request since the failover relays it again
+	var requestBody io.Reader = c.Request.Body
+	contentType := c.Request.Header.Get("Content-Type")
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping != "" {
+		modelMap := make(map[string]string)
+		err := json.Unmarshal([]byte(modelMapping), &modelMap)
+		if err != nil {
+			return errorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+		}
+		if modelMap[audioModel] != "" {
+			audioModel = modelMap[audioModel]
+			if relayMode == RelayModeAudioSpeech {
+				err = replaceRequestModel(c, audioModel)
+				requestBody = c.Request.Body
+			} else {
+				requestBody, contentType, err = buildMultipartForm(form, audioModel)
+			}
+			if err != nil {
+				return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+			}
+		}
+	}
+
+	quota := 0
+	if relayMode == RelayModeAudioSpeech {
+		quota = getSpeechQuota(audioModel, characters, groupRatio)
+	} else {
+		quota = getTranscriptionQuota(audioModel, duration, groupRatio)
+	}
+	chargeLog := ""
+	if quota != 0 {
+		quota, chargeLog = applyModelCharges(audioModel, quota, groupRatio)
+	}
+	if consumeQuota {
+		userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
+		if err != nil {
+			return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+		}
+		if userQuota-quota < 0 {
+			return quotaExhaustedErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+		}
+	}
+
+	baseURL := common.ChannelBaseURLs[channelType]
+	requestURL := c.Request.URL.String()
+	if c.GetString("base_url") != "" {
+		baseURL = c.GetString("base_url")
+	}
+	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
+	if channelType == common.ChannelTypeAzure {
+		fullRequestURL = getAzureRequestURL(c, audioModel, strings.TrimPrefix(c.Request.URL.Path, "/v1/"))
+	}
+
+	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if channelType == common.ChannelTypeAzure {
+		req.Header.Set("api-key", strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	} else {
+		setBearerAuth(req, strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+	setChannelHeaders(req, c.GetString("channel_headers"))
+	setPolicyHeaders(c, req)
+
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	err = req.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
+	}
+	err = c.Request.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
+	}
+	// the failed requests are returned as errors, so that they fail over to the other channels
+	if resp.StatusCode != http.StatusOK {
+		return getOpenAIResponseError(resp)
+	}
+
+	// the duration reported by the upstream is more accurate than the one of the file
+	if relayMode != RelayModeAudioSpeech && consumeQuota &&
+		strings.HasPrefix(resp.Header.Get("Content-Type"), "application/json") {
+		responseBody, err := io.ReadAll(resp.Body)
+		if err != nil {
+			return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+		}
+		err = resp.Body.Close()
+		if err != nil {
+			return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+		}
+		var audioResponse AudioResponse
+		if json.Unmarshal(responseBody, &audioResponse) == nil && audioResponse.getDuration() > 0 {
+			duration = audioResponse.getDuration()
+			durationEstimated = false
+			quota = getTranscriptionQuota(audioModel, duration, groupRatio)
+			if quota != 0 {
+				quota, chargeLog = applyModelCharges(audioModel, quota, groupRatio)
+			}
+		}
+		resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
+	}
+
+	defer func() {
+		// the budgets of the channel count the list price, without the group ratio and the discounts
+		spend := getTranscriptionQuota(audioModel, duration, 1)
+		if relayMode == RelayModeAudioSpeech {
+			spend = getSpeechQuota(audioModel, characters, 1)
+		}
+		if resp.StatusCode == http.StatusOK {
+			model.RecordChannelSpend(c.GetInt("channel_id"), spend)
+		}
+		// the failed requests are not charged
+		if consumeQuota && resp.StatusCode == http.StatusOK {
+			if quota != 0 {
+				var couponLog string
+				quota, couponLog = applyUserCoupon(userId, audioModel, quota)
+				chargeLog += couponLog
+			}
+			var logContent string
+			if relayMode == RelayModeAudioSpeech {
+				logContent = fmt.Sprintf("语音合成 %d 字符，单价 $%.3f / 1K 字符，分组倍率 %.2f", characters, common.GetSpeechPrice(audioModel), groupRatio)
+			} else {
+				logContent = fmt.Sprintf("音频时长 %.1f 秒", duration)
+				if durationEstimated {
+					logContent += "（按文件大小估算）"
+				}
+				logContent += fmt.Sprintf("，单价 $%.3f / 分钟，分组倍率 %.2f", common.GetTranscriptionPrice(audioModel), groupRatio)
+			}
+			logContent += chargeLog
+			getQuotaSettlement(c).settle(audioModel, 0, 0, quota, logContent)
+		}
+		if resp.StatusCode == http.StatusOK {
+			billedQuota := 0
+			if consumeQuota {
+				billedQuota = quota
+			}
+			model.RecordChannelUsage(c.GetInt("channel_id"), audioModel, 0, 0, billedQuota, float64(spend)/common.QuotaPerUnit, true)
+		}
+	}()
+
+	copyResponseHeaders(c, resp)
+	if relayMode == RelayModeAudioSpeech {
+		// the speech is flushed as it comes, so that the clients play it while the rest is being generated
+		c.Writer.Header().Set("X-Accel-Buffering", "no")
+		c.Writer.WriteHeader(resp.StatusCode)
+		err = copyFlushing(c.Writer, resp.Body)
+	} else {
+		c.Writer.WriteHeader(resp.StatusCode)
+		_, err = io.Copy(c.Writer, resp.Body)
+	}
+	if err != nil {
+		return errorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
+
+func copyFlushing(w gin.ResponseWriter, body io.Reader) error {
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := body.Read(buffer)
+		if n > 0 {
+			_, writeErr := w.Write(buffer[:n])
+			if writeErr != nil {
+				return writeErr
+			}
+			w.Flush()
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,628 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"strings"
+	"time"
+)
+
+// the OpenAI chat requests are translated to the Converse API of Bedrock by way of the Anthropic Messages API, which
+// has the same content blocks, see https://docs.aws.amazon.com/bedrock/latest/APIReference/API_runtime_Converse.html
+
+// bedrockModelIds maps the model names to the model IDs of Bedrock, the other names are taken as model IDs, so that
+// the model mapping of the channel can name a model ID or an inference profile such as us.anthropic.claude-sonnet-4
+var bedrockModelIds = map[string]string{
+	"claude-instant-1":           "anthropic.claude-instant-v1",
+	"claude-2":                   "anthropic.claude-v2:1",
+	"claude-3-haiku-20240307":    "anthropic.claude-3-haiku-20240307-v1:0",
+	"claude-3-5-haiku-20241022":  "anthropic.claude-3-5-haiku-20241022-v1:0",
+	"claude-3-sonnet-20240229":   "anthropic.claude-3-sonnet-20240229-v1:0",
+	"claude-3-5-sonnet-20241022": "anthropic.claude-3-5-sonnet-20241022-v2:0",
+	"claude-3-7-sonnet-20250219": "anthropic.claude-3-7-sonnet-20250219-v1:0",
+	"claude-sonnet-4-20250514":   "anthropic.claude-sonnet-4-20250514-v1:0",
+	"claude-3-opus-20240229":     "anthropic.claude-3-opus-20240229-v1:0",
+	"claude-opus-4-20250514":     "anthropic.claude-opus-4-20250514-v1:0",
+	"llama3-8b-instruct":         "meta.llama3-8b-instruct-v1:0",
+	"llama3-70b-instruct":        "meta.llama3-70b-instruct-v1:0",
+	"llama3.1-8b-instruct":       "meta.llama3-1-8b-instruct-v1:0",
+	"llama3.1-70b-instruct":      "meta.llama3-1-70b-instruct-v1:0",
+	"llama3.1-405b-instruct":     "meta.llama3-1-405b-instruct-v1:0",
+}
+
+// the longest event of a stream, the events are small but the tool inputs may come in one piece
+const maxBedrockEventSize = 16 * 1024 * 1024
+
+type BedrockImageBlock struct {
+	Format string `json:"format"` // png, jpeg, gif or webp
+	Source struct {
+		Bytes string `json:"bytes"`
+	} `json:"source"`
+}
+
+type BedrockToolUseBlock struct {
+	ToolUseId string `json:"toolUseId"`
+	Name      string `json:"name"`
+	Input     any    `json:"input,omitempty"`
+}
+
+type BedrockToolResultBlock struct {
+	ToolUseId string                `json:"toolUseId"`
+	Content   []BedrockContentBlock `json:"content"`
+}
+
+type BedrockContentBlock struct {
+	Text       string                  `json:"text,omitempty"`
+	Image      *BedrockImageBlock      `json:"image,omitempty"`
+	ToolUse    *BedrockToolUseBlock    `json:"toolUse,omitempty"`
+	ToolResult *BedrockToolResultBlock `json:"toolResult,omitempty"`
+}
+
+type BedrockMessage struct {
+	Role    string                `json:"role"`
+	Content []BedrockContentBlock `json:"content"`
+}
+
+type BedrockInferenceConfig struct {
+	MaxTokens     int      `json:"maxTokens,omitempty"`
+	Temperature   *float64 `json:"temperature,omitempty"`
+	TopP          *float64 `json:"topP,omitempty"`
+	StopSequences []string `json:"stopSequences,omitempty"`
+}
+
+type BedrockToolSpec struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema struct {
+		Json any `json:"json"`
+	} `json:"inputSchema"`
+}
+
+type BedrockTool struct {
+	ToolSpec BedrockToolSpec `json:"toolSpec"`
+}
+
+type BedrockToolConfig struct {
+	Tools      []BedrockTool  `json:"tools"`
+	ToolChoice map[string]any `json:"toolChoice,omitempty"`
+}
+
+type BedrockConverseRequest struct {
+	Messages        []BedrockMessage        `json:"messages"`
+	System          []BedrockContentBlock   `json:"system,omitempty"`
+	InferenceConfig *BedrockInferenceConfig `json:"inferenceConfig,omitempty"`
+	ToolConfig      *BedrockToolConfig      `json:"toolConfig,omitempty"`
+}
+
+type BedrockUsage struct {
+	InputTokens           int `json:"inputTokens"`
+	OutputTokens          int `json:"outputTokens"`
+	TotalTokens           int `json:"totalTokens"`
+	CacheReadInputTokens  int `json:"cacheReadInputTokens"`
+	CacheWriteInputTokens int `json:"cacheWriteInputTokens"`
+}
+
+type BedrockConverseResponse struct {
+	Output struct {
+		Message BedrockMessage `json:"message"`
+	} `json:"output"`
+	StopReason string       `json:"stopReason"`
+	Usage      BedrockUsage `json:"usage"`
+}
+
+// BedrockStreamEvent is the payload of any of the events of a stream, they are told apart by the event type header
+type BedrockStreamEvent struct {
+	ContentBlockIndex int `json:"contentBlockIndex"`
+	Start             *struct {
+		ToolUse *BedrockToolUseBlock `json:"toolUse"`
+	} `json:"start"`
+	Delta *struct {
+		Text    string `json:"text"`
+		ToolUse *struct {
+			Input string `json:"input"`
+		} `json:"toolUse"`
+	} `json:"delta"`
+	StopReason string        `json:"stopReason"`
+	Usage      *BedrockUsage `json:"usage"`
+	Message    string        `json:"message"` // of the exceptions
+}
+
+type bedrockCredentials struct {
+	accessKeyId     string
+	secretAccessKey string
+	region          string
+}
+
+// parseBedrockKey parses the key of a Bedrock channel, which is given as AccessKeyId|SecretAccessKey|Region
+func parseBedrockKey(key string) (*bedrockCredentials, error) {
+	parts := strings.Split(key, "|")
+	if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+		return nil, errors.New("invalid bedrock key, it should be AccessKeyId|SecretAccessKey|Region")
+	}
+	return &bedrockCredentials{accessKeyId: parts[0], secretAccessKey: parts[1], region: parts[2]}, nil
+}
+
+func getBedrockModelId(modelName string) string {
+	if modelId, ok := bedrockModelIds[modelName]; ok {
+		return modelId
+	}
+	return modelName
+}
+
+// getBedrockRequestURL escapes the colons of the model ID as the AWS SDKs do
+func getBedrockRequestURL(baseURL string, region string, modelName string, stream bool) string {
+	if baseURL == "" {
+		baseURL = fmt.Sprintf("https://bedrock-runtime.%s.amazonaws.com", region)
+	}
+	method := "converse"
+	if stream {
+		method = "converse-stream"
+	}
+	modelId := strings.ReplaceAll(url.PathEscape(getBedrockModelId(modelName)), ":", "%3A")
+	return fmt.Sprintf("%s/model/%s/%s", baseURL, modelId, method)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func sha256Hex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+// escapeSigV4 escapes all the bytes but the unreserved ones and the slashes, the path is escaped once more since
+// Bedrock is not S3
+func escapeSigV4(s string) string {
+	var builder strings.Builder
+	for i := 0; i < len(s); i++ {
+		b := s[i]
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || b == '-' || b == '_' || b == '.' || b == '~' || b == '/' {
+			builder.WriteByte(b)
+		} else {
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+	return builder.String()
+}
+
+// signBedrockRequest signs the request with Signature Version 4, only the host and the x-amz headers are signed so
+// that the headers set afterwards do not break the signature,
+// see https://docs.aws.amazon.com/IAM/latest/UserGuide/reference_sigv-create-signed-request.html
+func signBedrockRequest(req *http.Request, credentials *bedrockCredentials) error {
+	var body []byte
+	if req.GetBody != nil {
+		reader, err := req.GetBody()
+		if err != nil {
+			return err
+		}
+		body, err = io.ReadAll(reader)
+		if err != nil {
+			return err
+		}
+	}
+	now := time.Now().UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	payloadHash := sha256Hex(body)
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	canonicalHeaders := fmt.Sprintf("host:%s\nx-amz-content-sha256:%s\nx-amz-date:%s\n", req.URL.Host, payloadHash, amzDate)
+	canonicalQuery := strings.ReplaceAll(req.URL.Query().Encode(), "+", "%20")
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		escapeSigV4(req.URL.EscapedPath()),
+		canonicalQuery,
+		canonicalHeaders,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+	scope := fmt.Sprintf("%s/%s/bedrock/aws4_request", date, credentials.region)
+	stringToSign := fmt.Sprintf("AWS4-HMAC-SHA256\n%s\n%s\n%s", amzDate, scope, sha256Hex([]byte(canonicalRequest)))
+	signingKey := hmacSHA256([]byte("AWS4"+credentials.secretAccessKey), date)
+	signingKey = hmacSHA256(signingKey, credentials.region)
+	signingKey = hmacSHA256(signingKey, "bedrock")
+	signingKey = hmacSHA256(signingKey, "aws4_request")
+	signature := hex.EncodeToString(hmacSHA256(signingKey, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.accessKeyId, scope, signedHeaders, signature))
+	return nil
+}
+
+func getBedrockImageBlock(source *ClaudeImageSource, proxy string) (*BedrockImageBlock, error) {
+	mimeType, data := source.MediaType, source.Data
+	if source.Type == "url" {
+		var err error
+		mimeType, data, err = getImageData(source.Url, proxy)
+		if err != nil {
+			return nil, err
+		}
+	}
+	mimeType, err := checkImage(mimeType, data, bedrockImageLimit)
+	if err != nil {
+		return nil, err
+	}
+	var image BedrockImageBlock
+	image.Format = strings.TrimPrefix(mimeType, "image/")
+	image.Source.Bytes = data
+	return &image, nil
+}
+
+func getBedrockToolChoice(toolChoice *ClaudeToolChoice) map[string]any {
+	if toolChoice == nil {
+		return nil
+	}
+	switch toolChoice.Type {
+	case "any":
+		return map[string]any{"any": gin.H{}}
+	case "tool":
+		return map[string]any{"tool": gin.H{"name": toolChoice.Name}}
+	}
+	// there is no none, the tools are still given since the messages may have used them
+	return map[string]any{"auto": gin.H{}}
+}
+
+// requestOpenAI2Bedrock translates the request to the content blocks of Anthropic first and then to those of Bedrock,
+// the images given by URL are downloaded since Bedrock takes them inline
+func requestOpenAI2Bedrock(request ChatCompletionRequest, proxy string) (*BedrockConverseRequest, error) {
+	claudeRequest, err := requestOpenAI2Claude(request, "")
+	if err != nil {
+		return nil, err
+	}
+	bedrockRequest := BedrockConverseRequest{
+		InferenceConfig: &BedrockInferenceConfig{
+			// not defaulted as Anthropic requires, the models of Bedrock have limits of their own
+			MaxTokens:     request.MaxTokens,
+			Temperature:   claudeRequest.Temperature,
+			TopP:          claudeRequest.TopP,
+			StopSequences: claudeRequest.StopSequences,
+		},
+	}
+	if claudeRequest.System != "" {
+		bedrockRequest.System = []BedrockContentBlock{{Text: claudeRequest.System}}
+	}
+	if len(claudeRequest.Tools) > 0 {
+		bedrockRequest.ToolConfig = &BedrockToolConfig{ToolChoice: getBedrockToolChoice(claudeRequest.ToolChoice)}
+		for _, tool := range claudeRequest.Tools {
+			var bedrockTool BedrockTool
+			bedrockTool.ToolSpec.Name = tool.Name
+			bedrockTool.ToolSpec.Description = tool.Description
+			bedrockTool.ToolSpec.InputSchema.Json = tool.InputSchema
+			bedrockRequest.ToolConfig.Tools = append(bedrockRequest.ToolConfig.Tools, bedrockTool)
+		}
+	}
+	for _, message := range claudeRequest.Messages {
+		bedrockMessage := BedrockMessage{Role: message.Role}
+		for _, block := range message.Content {
+			var bedrockBlock BedrockContentBlock
+			switch block.Type {
+			case "text":
+				bedrockBlock.Text = block.Text
+			case "image":
+				bedrockBlock.Image, err = getBedrockImageBlock(block.Source, proxy)
+				if err != nil {
+					return nil, err
+				}
+			case "tool_use":
+				bedrockBlock.ToolUse = &BedrockToolUseBlock{ToolUseId: block.Id, Name: block.Name, Input: block.Input}
+			case "tool_result":
+				bedrockBlock.ToolResult = &BedrockToolResultBlock{
+					ToolUseId: block.ToolUseId,
+					Content:   []BedrockContentBlock{{Text: block.Content}},
+				}
+			}
+			bedrockMessage.Content = append(bedrockMessage.Content, bedrockBlock)
+		}
+		bedrockRequest.Messages = append(bedrockRequest.Messages, bedrockMessage)
+	}
+	return &bedrockRequest, nil
+}
+
+func usageBedrock2Claude(usage BedrockUsage) ClaudeUsage {
+	return ClaudeUsage{
+		InputTokens:              usage.InputTokens,
+		OutputTokens:             usage.OutputTokens,
+		CacheReadInputTokens:     usage.CacheReadInputTokens,
+		CacheCreationInputTokens: usage.CacheWriteInputTokens,
+	}
+}
+
+func responseBedrock2Claude(response *BedrockConverseResponse, modelName string) *ClaudeResponse {
+	claudeResponse := ClaudeResponse{
+		Model:      modelName,
+		StopReason: response.StopReason,
+		Usage:      usageBedrock2Claude(response.Usage),
+	}
+	for _, block := range response.Output.Message.Content {
+		switch {
+		case block.ToolUse != nil:
+			claudeResponse.Content = append(claudeResponse.Content, ClaudeContentBlock{
+				Type:  "tool_use",
+				Id:    block.ToolUse.ToolUseId,
+				Name:  block.ToolUse.Name,
+				Input: block.ToolUse.Input,
+			})
+		case block.Text != "":
+			claudeResponse.Content = append(claudeResponse.Content, ClaudeContentBlock{Type: "text", Text: block.Text})
+		}
+	}
+	return &claudeResponse
+}
+
+// getBedrockError reads the error type from the x-amzn-ErrorType header, such as ValidationException:http://...
+func getBedrockError(errorType string, message string, statusCode int) *OpenAIErrorWithStatusCode {
+	errorType = strings.Split(errorType, ":")[0]
+	if errorType == "" {
+		errorType = "bedrock_error"
+	}
+	return claudeErrorWrapper(ClaudeError{Type: errorType, Message: message}, statusCode)
+}
+
+func getBedrockResponseError(resp *http.Response) *OpenAIErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+	var errorResponse struct {
+		Message      string `json:"message"`
+		MessageUpper string `json:"Message"`
+	}
+	err = json.Unmarshal(responseBody, &errorResponse)
+	message := errorResponse.Message
+	if message == "" {
+		message = errorResponse.MessageUpper
+	}
+	if err != nil || message == "" {
+		return errorWrapper(fmt.Errorf("status code %d", resp.StatusCode), "bad_response_status_code", resp.StatusCode)
+	}
+	return getBedrockError(resp.Header.Get("X-Amzn-Errortype"), message, resp.StatusCode)
+}
+
+// readBedrockEvent reads a message of the event stream encoding, a prelude of the lengths, the headers, the payload
+// and the checksums, see https://docs.aws.amazon.com/transcribe/latest/dg/streaming-setting-up.html
+func readBedrockEvent(reader io.Reader) (map[string]string, []byte, error) {
+	prelude := make([]byte, 12)
+	_, err := io.ReadFull(reader, prelude)
+	if err != nil {
+		return nil, nil, err
+	}
+	totalLength := binary.BigEndian.Uint32(prelude[0:4])
+	headersLength := binary.BigEndian.Uint32(prelude[4:8])
+	if crc32.ChecksumIEEE(prelude[:8]) != binary.BigEndian.Uint32(prelude[8:12]) {
+		return nil, nil, errors.New("invalid prelude checksum")
+	}
+	if totalLength < 16+headersLength || totalLength > maxBedrockEventSize {
+		return nil, nil, errors.New("invalid event length")
+	}
+	message := make([]byte, totalLength-12)
+	_, err = io.ReadFull(reader, message)
+	if err != nil {
+		return nil, nil, err
+	}
+	checksum := crc32.Update(crc32.ChecksumIEEE(prelude), crc32.IEEETable, message[:len(message)-4])
+	if checksum != binary.BigEndian.Uint32(message[len(message)-4:]) {
+		return nil, nil, errors.New("invalid message checksum")
+	}
+	headers, err := parseBedrockEventHeaders(message[:headersLength])
+	if err != nil {
+		return nil, nil, err
+	}
+	return headers, message[headersLength : len(message)-4], nil
+}
+
+// parseBedrockEventHeaders keeps the string headers, which are the only ones Bedrock sends
+func parseBedrockEventHeaders(data []byte) (map[string]string, error) {
+	// the sizes of the values of the fixed size types, by the type
+	valueSizes := map[byte]int{0: 0, 1: 0, 2: 1, 3: 2, 4: 4, 5: 8, 8: 8, 9: 16}
+	headers := make(map[string]string)
+	for len(data) > 0 {
+		nameLength := int(data[0])
+		if len(data) < 1+nameLength+1 {
+			return nil, errors.New("invalid event header")
+		}
+		name := string(data[1 : 1+nameLength])
+		valueType := data[1+nameLength]
+		data = data[1+nameLength+1:]
+		if size, ok := valueSizes[valueType]; ok {
+			if len(data) < size {
+				return nil, errors.New("invalid event header")
+			}
+			data = data[size:]
+			continue
+		}
+		// the byte arrays and the strings have their length first
+		if (valueType != 6 && valueType != 7) || len(data) < 2 {
+			return nil, errors.New("invalid event header")
+		}
+		valueLength := int(binary.BigEndian.Uint16(data[:2]))
+		if len(data) < 2+valueLength {
+			return nil, errors.New("invalid event header")
+		}
+		if valueType == 7 {
+			headers[name] = string(data[2 : 2+valueLength])
+		}
+		data = data[2+valueLength:]
+	}
+	return headers, nil
+}
+
+// streamEventBedrock2Claude translates an event of the stream to the event of Anthropic with the same meaning, nil
+// for the events without one
+func streamEventBedrock2Claude(eventType string, event *BedrockStreamEvent) *ClaudeStreamEvent {
+	claudeEvent := ClaudeStreamEvent{Index: event.ContentBlockIndex}
+	switch eventType {
+	case "messageStart":
+		claudeEvent.Type = "message_start"
+	case "contentBlockStart":
+		if event.Start == nil || event.Start.ToolUse == nil {
+			return nil
+		}
+		claudeEvent.Type = "content_block_start"
+		claudeEvent.ContentBlock = &ClaudeContentBlock{Type: "tool_use", Id: event.Start.ToolUse.ToolUseId, Name: event.Start.ToolUse.Name}
+	case "contentBlockDelta":
+		if event.Delta == nil {
+			return nil
+		}
+		claudeEvent.Type = "content_block_delta"
+		if event.Delta.ToolUse != nil {
+			claudeEvent.Delta.Type = "input_json_delta"
+			claudeEvent.Delta.PartialJson = event.Delta.ToolUse.Input
+		} else {
+			claudeEvent.Delta.Type = "text_delta"
+			claudeEvent.Delta.Text = event.Delta.Text
+		}
+	case "messageStop":
+		claudeEvent.Type = "message_delta"
+		claudeEvent.Delta.StopReason = event.StopReason
+	default:
+		return nil
+	}
+	return &claudeEvent
+}
+
+func bedrockStreamHandler(c *gin.Context, resp *http.Response, modelName string) (*OpenAIErrorWithStatusCode, string, *Usage) {
+	if resp.StatusCode != http.StatusOK {
+		return getBedrockResponseError(resp), "", nil
+	}
+	responseText := ""
+	responseId := fmt.Sprintf("chatcmpl-%s", common.GetUUID())
+	createdTime := common.GetTimestamp()
+	var usage *Usage
+	var streamError *OpenAIErrorWithStatusCode
+	toolCallIndexes := make(map[int]int)
+	type bedrockEvent struct {
+		headers map[string]string
+		payload []byte
+	}
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
+	eventChan := make(chan bedrockEvent)
+	stopChan := make(chan bool)
+	go func() {
+		defer close(stopChan)
+		for {
+			headers, payload, err := readBedrockEvent(resp.Body)
+			if err != nil {
+				if err != io.EOF {
+					common.SysError("error reading stream response: " + err.Error())
+				}
+				break
+			}
+			select {
+			case eventChan <- bedrockEvent{headers: headers, payload: payload}:
+			case <-keeper.Done():
+				return
+			}
+		}
+	}()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case data := <-eventChan:
+			keeper.Touch()
+			var event BedrockStreamEvent
+			if len(data.payload) > 0 {
+				err := json.Unmarshal(data.payload, &event)
+				if err != nil {
+					common.SysError("error unmarshalling stream response: " + err.Error())
+					return true
+				}
+			}
+			switch data.headers[":message-type"] {
+			case "exception":
+				streamError = getBedrockError(data.headers[":exception-type"], event.Message, http.StatusInternalServerError)
+				return true
+			case "error":
+				streamError = getBedrockError(data.headers[":error-code"], data.headers[":error-message"], http.StatusInternalServerError)
+				return true
+			}
+			eventType := data.headers[":event-type"]
+			if eventType == "metadata" && event.Usage != nil {
+				openaiUsage := usageClaude2OpenAI(usageBedrock2Claude(*event.Usage))
+				usage = &openaiUsage
+			}
+			claudeEvent := streamEventBedrock2Claude(eventType, &event)
+			if claudeEvent == nil {
+				return true
+			}
+			if claudeEvent.Type == "content_block_delta" {
+				responseText += claudeEvent.Delta.Text + claudeEvent.Delta.PartialJson
+			}
+			response := streamEventClaude2OpenAI(claudeEvent, toolCallIndexes)
+			if response == nil {
+				return true
+			}
+			response.Id = responseId
+			response.Created = createdTime
+			response.Model = modelName
+			jsonStr, err := json.Marshal(response)
+			if err != nil {
+				common.SysError("error marshalling stream response: " + err.Error())
+				return true
+			}
+			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
+		case <-stopChan:
+			if streamError != nil {
+				jsonStr, _ := json.Marshal(gin.H{"error": streamError.OpenAIError})
+				c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+			}
+			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
+			return false
+		}
+	})
+	err := keeper.Finish(resp.Body, stopChan)
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
+	}
+	return nil, responseText, usage
+}
+
+func bedrockHandler(c *gin.Context, resp *http.Response, modelName string) (*OpenAIErrorWithStatusCode, *Usage, string) {
+	if resp.StatusCode != http.StatusOK {
+		return getBedrockResponseError(resp), nil, ""
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	var bedrockResponse BedrockConverseResponse
+	err = json.Unmarshal(responseBody, &bedrockResponse)
+	if err != nil {
+		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	claudeResponse := responseBedrock2Claude(&bedrockResponse, modelName)
+	fullTextResponse := responseClaude2OpenAI(claudeResponse)
+	completionText := ""
+	for _, block := range claudeResponse.Content {
+		completionText += block.Text
+	}
+	jsonResponse, err := json.Marshal(fullTextResponse)
+	if err != nil {
+		return errorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(jsonResponse)
+	return nil, &fullTextResponse.Usage, completionText
+}
@@ -0,0 +1,414 @@
+package controller
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// cachedResponse is a response of the upstream kept for the identical requests
+type cachedResponse struct {
+	Body      []byte `json:"body"`
+	Usage     Usage  `json:"usage"`
+	ExpiredAt int64  `json:"expired_at"`
+}
+
+// the responses are kept in Redis if enabled, so that all the nodes share them, the expiry is checked on each entry
+// since the TTL option may change
+var responseLocalCache = common.NewLRUCache(common.ResponseCacheSize, 7*24*time.Hour)
+
+// getResponseCacheKey returns the key of the request, empty if its response is not cached, only the requests of the
+// tokens which opted in with a temperature of 0 are, the key covers the user, the model the client asked for and the
+// body with its fields sorted
+func getResponseCacheKey(c *gin.Context, relayMode int) (string, error) {
+	if !c.GetBool("response_cache_enabled") || common.ResponseCacheTTL <= 0 || c.GetBool("choice_request") ||
+		(relayMode != RelayModeChatCompletions && relayMode != RelayModeCompletions) {
+		return "", nil
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return "", err
+	}
+	if temperature, ok := request["temperature"].(float64); !ok || temperature != 0 {
+		return "", nil
+	}
+	if stream, _ := request["stream"].(bool); stream {
+		return "", nil
+	}
+	normalizedBody, err := json.Marshal(request)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s", c.GetInt("id"), c.GetString("request_model"), normalizedBody)))
+	return "response_cache:" + hex.EncodeToString(hash[:]), nil
+}
+
+func getCachedResponse(key string) *cachedResponse {
+	var response *cachedResponse
+	if common.RedisEnabled {
+		value, err := common.RedisGet(key)
+		if err != nil {
+			return nil
+		}
+		response = &cachedResponse{}
+		if json.Unmarshal([]byte(value), response) != nil {
+			return nil
+		}
+	} else {
+		value, ok := responseLocalCache.Get(key)
+		if !ok {
+			return nil
+		}
+		response = value.(*cachedResponse)
+	}
+	if response.ExpiredAt < common.GetTimestamp() {
+		return nil
+	}
+	return response
+}
+
+func setCachedResponse(key string, body []byte, usage Usage) {
+	ttl := time.Duration(common.ResponseCacheTTL) * time.Second
+	response := &cachedResponse{Body: body, Usage: usage, ExpiredAt: common.GetTimestamp() + int64(common.ResponseCacheTTL)}
+	if !common.RedisEnabled {
+		responseLocalCache.Set(key, response)
+		return
+	}
+	value, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("failed to marshal cached response: " + err.Error())
+		return
+	}
+	err = common.RedisSet(key, string(value), ttl)
+	if err != nil {
+		common.SysError("Redis set response cache error: " + err.Error())
+	}
+}
+
+// the prompts of a scope are sharded by the signs of their embeddings on fixed random hyperplanes, the similar prompts
+// mostly share them, a lookup compares the prompt with the entries of its shard and of the shards one sign apart only,
+// at most semanticCacheShardSize entries each
+const (
+	semanticCacheShardBits = 6
+	semanticCacheShardSize = 8
+)
+
+// semanticCacheEntry is a cached response with the embedding of its prompt
+type semanticCacheEntry struct {
+	Embedding []float64      `json:"embedding"`
+	Response  cachedResponse `json:"response"`
+}
+
+// semanticCacheScope is the entries in memory of a shard of a scope when Redis is not enabled
+type semanticCacheScope struct {
+	lock    sync.Mutex
+	entries []*semanticCacheEntry
+}
+
+var semanticLocalCache = common.NewLRUCache(common.ResponseCacheSize, 7*24*time.Hour)
+
+// semanticCacheQuery is the prompt of a request embedded for the lookup of the semantic cache
+type semanticCacheQuery struct {
+	scope     string
+	shard     int
+	embedding []float64
+}
+
+// getSemanticCacheShard is the signs of the embedding on the hyperplanes, the same on all the nodes
+func getSemanticCacheShard(embedding []float64) int {
+	shard := 0
+	for bit := 0; bit < semanticCacheShardBits; bit++ {
+		var projection float64
+		for i, value := range embedding {
+			if isHyperplanePositive(bit, i) {
+				projection += value
+			} else {
+				projection -= value
+			}
+		}
+		if projection >= 0 {
+			shard |= 1 << bit
+		}
+	}
+	return shard
+}
+
+// isHyperplanePositive is the sign of a component of a hyperplane, mixed from its position like splitmix64
+func isHyperplanePositive(bit int, i int) bool {
+	x := uint64(bit)<<32 | uint64(i)
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	x ^= x >> 31
+	return x&1 == 1
+}
+
+func (query *semanticCacheQuery) getShardKey(shard int) string {
+	return fmt.Sprintf("%s:%d", query.scope, shard)
+}
+
+// embedText asks the embedding model for the embedding of the text, only OpenAI compatible channels are supported, the
+// channel is selected like the ones of the request and the embedding is billed to its token
+func embedText(c *gin.Context, embeddingModel string, text string) ([]float64, error) {
+	channel, err := middleware.SelectChannel(c, c.GetString("group"), embeddingModel)
+	if err != nil {
+		return nil, fmt.Errorf("嵌入模型 %s 无可用渠道", embeddingModel)
+	}
+	if getAPIType(channel.Type) != APITypeOpenAI || channel.Type == common.ChannelTypeAzure {
+		return nil, fmt.Errorf("嵌入模型 %s 的渠道不是 OpenAI 兼容的渠道", embeddingModel)
+	}
+	jsonData, err := json.Marshal(map[string]any{
+		"model": embeddingModel,
+		"input": text,
+	})
+	if err != nil {
+		return nil, err
+	}
+	requestURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		requestURL = channel.BaseURL
+	}
+	req, err := http.NewRequest("POST", requestURL+"/v1/embeddings", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	key, keyHash := model.PickChannelKey(channel)
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := getImpatientHTTPClient(channel.Proxy).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("bad status code: %d", resp.StatusCode)
+	}
+	var response OpenAIEmbeddingResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	billEmbedding(c, channel.Id, keyHash, embeddingModel, response.Usage)
+	if len(response.Data) == 0 || len(response.Data[0].Embedding) == 0 {
+		return nil, errors.New("the embedding is empty")
+	}
+	return response.Data[0].Embedding, nil
+}
+
+// billEmbedding bills the embedding of a prompt for the semantic cache at the price of the embedding model, whether
+// the lookup hits or not
+func billEmbedding(c *gin.Context, channelId int, channelKeyHash string, embeddingModel string, usage Usage) {
+	settlement := getQuotaSettlement(c)
+	// the embedding may be sent to another channel than the request
+	settlement.channelId = channelId
+	settlement.channelKeyHash = channelKeyHash
+	go func() {
+		if usage.PromptTokens == 0 {
+			return
+		}
+		model.RecordChannelTokens(channelId, embeddingModel, usage.PromptTokens)
+		modelRatio := common.GetModelRatio(embeddingModel)
+		groupRatio := common.GetGroupRatio(settlement.group)
+		model.RecordChannelSpend(channelId, int(float64(usage.PromptTokens)*modelRatio))
+		ratio := modelRatio * groupRatio
+		quota := int(float64(usage.PromptTokens) * ratio)
+		if ratio != 0 && quota <= 0 {
+			quota = 1
+		}
+		logContent := fmt.Sprintf("语义缓存嵌入，模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
+		settlement.settle(embeddingModel, usage.PromptTokens, 0, quota, logContent)
+		cost, priced := common.GetUpstreamCost(embeddingModel, usage.PromptTokens, 0)
+		model.RecordChannelUsage(channelId, embeddingModel, usage.PromptTokens, 0, quota, cost, priced)
+	}()
+}
+
+func cosineSimilarity(a []float64, b []float64) float64 {
+	if len(a) != len(b) {
+		return 0
+	}
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+	return dot / math.Sqrt(normA*normB)
+}
+
+// getSemanticCacheQuery embeds the prompt of the request, nil if the request is not looked up in the semantic cache,
+// only the requests of the tokens which opted in are, the prompts are only compared within the requests of the same
+// user and model with the same fields but the prompt, a failed embedding skips the cache
+func getSemanticCacheQuery(c *gin.Context, relayMode int) *semanticCacheQuery {
+	if !c.GetBool("semantic_cache_enabled") || common.SemanticCacheModel == "" || common.ResponseCacheTTL <= 0 ||
+		c.GetBool("choice_request") || (relayMode != RelayModeChatCompletions && relayMode != RelayModeCompletions) {
+		return nil
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return nil
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+	var request map[string]any
+	var textRequest GeneralOpenAIRequest
+	if json.Unmarshal(requestBody, &request) != nil || json.Unmarshal(requestBody, &textRequest) != nil || textRequest.Stream {
+		return nil
+	}
+	var text string
+	if relayMode == RelayModeChatCompletions {
+		lines := make([]string, 0, len(textRequest.Messages))
+		for _, message := range textRequest.Messages {
+			lines = append(lines, fmt.Sprintf("%s: %s", message.Role, message.StringContent()))
+		}
+		text = strings.Join(lines, "\n")
+		delete(request, "messages")
+	} else {
+		prompt, ok := textRequest.Prompt.(string)
+		if !ok {
+			return nil
+		}
+		text = prompt
+		delete(request, "prompt")
+	}
+	if strings.TrimSpace(text) == "" {
+		return nil
+	}
+	params, err := json.Marshal(request)
+	if err != nil {
+		return nil
+	}
+	embedding, err := embedText(c, common.SemanticCacheModel, text)
+	if err != nil {
+		common.SysError("failed to embed the prompt for the semantic cache: " + err.Error())
+		return nil
+	}
+	hash := sha256.Sum256([]byte(fmt.Sprintf("%d\n%s\n%s", c.GetInt("id"), c.GetString("request_model"), params)))
+	return &semanticCacheQuery{scope: "semantic_cache:" + hex.EncodeToString(hash[:]), shard: getSemanticCacheShard(embedding), embedding: embedding}
+}
+
+func (query *semanticCacheQuery) getEntries() []*semanticCacheEntry {
+	keys := []string{query.getShardKey(query.shard)}
+	for bit := 0; bit < semanticCacheShardBits; bit++ {
+		keys = append(keys, query.getShardKey(query.shard^(1<<bit)))
+	}
+	var entries []*semanticCacheEntry
+	if !common.RedisEnabled {
+		for _, key := range keys {
+			value, ok := semanticLocalCache.Get(key)
+			if !ok {
+				continue
+			}
+			scope := value.(*semanticCacheScope)
+			scope.lock.Lock()
+			entries = append(entries, scope.entries...)
+			scope.lock.Unlock()
+		}
+		return entries
+	}
+	lists, err := common.RedisListRanges(keys)
+	if err != nil {
+		return nil
+	}
+	for _, values := range lists {
+		for _, value := range values {
+			entry := &semanticCacheEntry{}
+			if json.Unmarshal([]byte(value), entry) == nil {
+				entries = append(entries, entry)
+			}
+		}
+	}
+	return entries
+}
+
+// find returns the cached response of the most similar prompt if it is at least as similar as SemanticCacheThreshold
+func (query *semanticCacheQuery) find() *cachedResponse {
+	var found *cachedResponse
+	bestSimilarity := common.SemanticCacheThreshold
+	now := common.GetTimestamp()
+	for _, entry := range query.getEntries() {
+		if entry.Response.ExpiredAt < now {
+			continue
+		}
+		if similarity := cosineSimilarity(query.embedding, entry.Embedding); similarity >= bestSimilarity {
+			bestSimilarity = similarity
+			found = &entry.Response
+		}
+	}
+	return found
+}
+
+func (query *semanticCacheQuery) store(body []byte, usage Usage) {
+	entry := &semanticCacheEntry{
+		Embedding: query.embedding,
+		Response:  cachedResponse{Body: body, Usage: usage, ExpiredAt: common.GetTimestamp() + int64(common.ResponseCacheTTL)},
+	}
+	key := query.getShardKey(query.shard)
+	if !common.RedisEnabled {
+		value, ok := semanticLocalCache.Get(key)
+		if !ok {
+			value = &semanticCacheScope{}
+			semanticLocalCache.Set(key, value)
+		}
+		scope := value.(*semanticCacheScope)
+		scope.lock.Lock()
+		defer scope.lock.Unlock()
+		scope.entries = append(scope.entries, entry)
+		if len(scope.entries) > semanticCacheShardSize {
+			scope.entries = scope.entries[len(scope.entries)-semanticCacheShardSize:]
+		}
+		return
+	}
+	value, err := json.Marshal(entry)
+	if err != nil {
+		common.SysError("failed to marshal semantic cache entry: " + err.Error())
+		return
+	}
+	err = common.RedisListPush(key, string(value), semanticCacheShardSize, time.Duration(common.ResponseCacheTTL)*time.Second)
+	if err != nil {
+		common.SysError("Redis push semantic cache error: " + err.Error())
+	}
+}
+
+// responseCacheWriter keeps a copy of the response relayed to the client so that it can be cached
+type responseCacheWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *responseCacheWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *responseCacheWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+// writeCachedResponse relays the cached response, the X-Response-Cache header tells the client it is a hit
+func writeCachedResponse(c *gin.Context, response *cachedResponse) {
+	c.Header("X-Response-Cache", "hit")
+	c.Data(http.StatusOK, "application/json", response.Body)
+}
@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxChoiceCount    = 128
+	maxBestOf         = 20
+	maxChoiceRequests = 20 // upper limit of the parallel upstream requests of an emulated request
+	maxPaLMCandidates = 8
+)
+
+// choicePlan tells how the choices are generated when the upstream can't do it in one request
+type choicePlan struct {
+	requests int // parallel upstream requests
+	n        int // choices asked from each of them
+}
+
+// getChoiceCount returns the n and best_of of the request, both default to 1
+func getChoiceCount(request GeneralOpenAIRequest) (int, int, error) {
+	n := request.N
+	if n == 0 {
+		n = 1
+	}
+	bestOf := request.BestOf
+	if bestOf == 0 {
+		bestOf = n
+	}
+	if n < 1 || n > maxChoiceCount {
+		return 0, 0, fmt.Errorf("n must be between 1 and %d", maxChoiceCount)
+	}
+	if bestOf < n {
+		return 0, 0, errors.New("best_of must be greater than or equal to n")
+	}
+	if request.BestOf > maxBestOf {
+		return 0, 0, fmt.Errorf("best_of must be less than or equal to %d", maxBestOf)
+	}
+	if request.Stream && bestOf > n {
+		return 0, 0, errors.New("best_of cannot be used with stream")
+	}
+	return n, bestOf, nil
+}
+
+// getChoicePlan returns nil when the upstream generates all the choices on its own
+func getChoicePlan(apiType int, relayMode int, n int, bestOf int, isStream bool) *choicePlan {
+	if bestOf == 1 {
+		return nil
+	}
+	switch apiType {
+	case APITypeOpenAI:
+		// the completions api supports best_of, the chat api only has n
+		if relayMode == RelayModeCompletions || bestOf == n {
+			return nil
+		}
+		return &choicePlan{requests: 1, n: bestOf}
+	case APITypePaLM:
+		// the candidates are not streamed
+		if bestOf <= maxPaLMCandidates {
+			if bestOf == n && !isStream {
+				return nil
+			}
+			return &choicePlan{requests: 1, n: bestOf}
+		}
+	}
+	return &choicePlan{requests: bestOf, n: 1}
+}
+
+func newChoiceContext(c *gin.Context, requestBody []byte) (*gin.Context, *httptest.ResponseRecorder) {
+	recorder := httptest.NewRecorder()
+	choiceContext, _ := gin.CreateTestContext(recorder)
+	choiceContext.Request = c.Request.Clone(c.Request.Context())
+	choiceContext.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+	choiceContext.Request.ContentLength = int64(len(requestBody))
+	choiceContext.Params = c.Params
+	for key, value := range c.Keys {
+		choiceContext.Set(key, value)
+	}
+	choiceContext.Set("choice_request", true)
+	return choiceContext, recorder
+}
+
+func doChoiceRequest(choiceContext *gin.Context, recorder *httptest.ResponseRecorder, relayMode int) (*OpenAITextResponse, *Usage, *OpenAIErrorWithStatusCode) {
+	err := relayTextHelper(choiceContext, relayMode)
+	var usage *Usage
+	if value, ok := choiceContext.Get("relay_usage"); ok {
+		choiceUsage := value.(Usage)
+		usage = &choiceUsage
+	}
+	if err != nil {
+		return nil, usage, err
+	}
+	var response OpenAITextResponse
+	jsonErr := json.Unmarshal(recorder.Body.Bytes(), &response)
+	if jsonErr != nil {
+		return nil, usage, errorWrapper(jsonErr, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if recorder.Code != http.StatusOK {
+		return nil, usage, errorWrapper(fmt.Errorf("bad status code: %d", recorder.Code), "bad_status_code", recorder.Code)
+	}
+	return &response, usage, nil
+}
+
+// selectChoices keeps n of the choices, there are no log probabilities to rank them,
+// so the ones which finished on their own are preferred to the truncated ones
+func selectChoices(choices []OpenAITextResponseChoice, n int) []OpenAITextResponseChoice {
+	sort.SliceStable(choices, func(i, j int) bool {
+		return choices[i].FinishReason == "stop" && choices[j].FinishReason != "stop"
+	})
+	choices = choices[:n]
+	for i := range choices {
+		choices[i].Index = i
+	}
+	return choices
+}
+
+func writeChoicesStream(c *gin.Context, modelName string, choices []OpenAITextResponseChoice) {
+	responseId := fmt.Sprintf("chatcmpl-%s", common.GetUUID())
+	createdTime := common.GetTimestamp()
+	setEventStreamHeaders(c)
+	for _, choice := range choices {
+		finishReason := choice.FinishReason
+		var streamChoice ChatCompletionsStreamResponseChoice
+		streamChoice.Index = choice.Index
+		streamChoice.Delta.Content = choice.Message.StringContent()
+		for i, toolCall := range choice.Message.ToolCalls {
+			index := i
+			toolCall.Index = &index
+			streamChoice.Delta.ToolCalls = append(streamChoice.Delta.ToolCalls, toolCall)
+		}
+		streamChoice.FinishReason = &finishReason
+		response := ChatCompletionsStreamResponse{
+			Id:      responseId,
+			Object:  "chat.completion.chunk",
+			Created: createdTime,
+			Model:   modelName,
+			Choices: []ChatCompletionsStreamResponseChoice{streamChoice},
+		}
+		jsonStr, err := json.Marshal(response)
+		if err != nil {
+			common.SysError("error marshalling stream response: " + err.Error())
+			continue
+		}
+		c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+	}
+	c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
+	c.Writer.Flush()
+}
+
+// relayChoices emulates n and best_of with parallel upstream requests, the choices are merged into one response,
+// the returned usage covers all the generated choices including the ones dropped by best_of
+func relayChoices(c *gin.Context, relayMode int, plan *choicePlan, n int, isStream bool, modelName string) (Usage, string, *OpenAIErrorWithStatusCode) {
+	var usage Usage
+	if plan.requests > maxChoiceRequests {
+		return usage, "", errorWrapper(fmt.Errorf("this channel supports at most %d choices", maxChoiceRequests), "invalid_n", http.StatusBadRequest)
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return usage, "", errorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return usage, "", errorWrapper(err, "unmarshal_request_body_failed", http.StatusBadRequest)
+	}
+	delete(request, "best_of")
+	delete(request, "stream")
+	delete(request, "stream_options")
+	delete(request, "n")
+	if plan.n > 1 {
+		request["n"] = plan.n
+	}
+	choiceRequestBody, err := json.Marshal(request)
+	if err != nil {
+		return usage, "", errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+	}
+
+	responses := make([]*OpenAITextResponse, plan.requests)
+	usages := make([]*Usage, plan.requests)
+	errs := make([]*OpenAIErrorWithStatusCode, plan.requests)
+	var wg sync.WaitGroup
+	for i := 0; i < plan.requests; i++ {
+		choiceContext, recorder := newChoiceContext(c, choiceRequestBody)
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			responses[i], usages[i], errs[i] = doChoiceRequest(choiceContext, recorder, relayMode)
+		}(i)
+	}
+	wg.Wait()
+
+	var choices []OpenAITextResponseChoice
+	var firstErr *OpenAIErrorWithStatusCode
+	for i := 0; i < plan.requests; i++ {
+		if usages[i] != nil {
+			usage.PromptTokens += usages[i].PromptTokens
+			usage.CompletionTokens += usages[i].CompletionTokens
+			usage.TotalTokens += usages[i].TotalTokens
+			if usages[i].PromptTokensDetails != nil {
+				if usage.PromptTokensDetails == nil {
+					usage.PromptTokensDetails = &PromptTokensDetails{}
+				}
+				usage.PromptTokensDetails.CachedTokens += usages[i].PromptTokensDetails.CachedTokens
+				usage.PromptTokensDetails.CacheCreationTokens += usages[i].PromptTokensDetails.CacheCreationTokens
+			}
+		}
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			continue
+		}
+		choices = append(choices, responses[i].Choices...)
+	}
+	if len(choices) < n {
+		if firstErr == nil {
+			firstErr = errorWrapper(errors.New("upstream returned fewer choices than requested"), "insufficient_choices", http.StatusInternalServerError)
+		}
+		return usage, "", firstErr
+	}
+	choices = selectChoices(choices, n)
+	var completionText strings.Builder
+	for _, choice := range choices {
+		completionText.WriteString(choice.Message.StringContent())
+	}
+	if isStream {
+		writeChoicesStream(c, modelName, choices)
+	} else {
+		c.JSON(http.StatusOK, OpenAITextResponse{
+			Id:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+			Object:  "chat.completion",
+			Created: common.GetTimestamp(),
+			Choices: choices,
+			Usage:   usage,
+		})
+	}
+	return usage, completionText.String(), nil
+}
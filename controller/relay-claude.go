@@ -3,6 +3,7 @@ package controller
 import (
 	"bufio"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
@@ -11,20 +12,61 @@ import (
 	"strings"
 )
 
+// the OpenAI chat requests are translated to the Anthropic Messages API, see https://docs.anthropic.com/en/api/messages
+
+// the default of max_tokens, which the Messages API requires
+const claudeDefaultMaxTokens = 4096
+
 type ClaudeMetadata struct {
 	UserId string `json:"user_id"`
 }
 
+type ClaudeImageSource struct {
+	Type      string `json:"type"` // base64 or url
+	MediaType string `json:"media_type,omitempty"`
+	Data      string `json:"data,omitempty"`
+	Url       string `json:"url,omitempty"`
+}
+
+type ClaudeContentBlock struct {
+	Type      string             `json:"type"`
+	Text      string             `json:"text,omitempty"`
+	Source    *ClaudeImageSource `json:"source,omitempty"`
+	Id        string             `json:"id,omitempty"`
+	Name      string             `json:"name,omitempty"`
+	Input     any                `json:"input,omitempty"`
+	ToolUseId string             `json:"tool_use_id,omitempty"`
+	Content   string             `json:"content,omitempty"`
+}
+
+type ClaudeMessage struct {
+	Role    string               `json:"role"`
+	Content []ClaudeContentBlock `json:"content"`
+}
+
+type ClaudeTool struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type ClaudeToolChoice struct {
+	Type string `json:"type"` // auto, any, tool or none
+	Name string `json:"name,omitempty"`
+}
+
 type ClaudeRequest struct {
-	Model             string   `json:"model"`
-	Prompt            string   `json:"prompt"`
-	MaxTokensToSample int      `json:"max_tokens_to_sample"`
-	StopSequences     []string `json:"stop_sequences,omitempty"`
-	Temperature       float64  `json:"temperature,omitempty"`
-	TopP              float64  `json:"top_p,omitempty"`
-	TopK              int      `json:"top_k,omitempty"`
-	//ClaudeMetadata    `json:"metadata,omitempty"`
-	Stream bool `json:"stream,omitempty"`
+	Model         string            `json:"model"`
+	Messages      []ClaudeMessage   `json:"messages"`
+	System        string            `json:"system,omitempty"`
+	MaxTokens     int               `json:"max_tokens"`
+	StopSequences []string          `json:"stop_sequences,omitempty"`
+	Temperature   *float64          `json:"temperature,omitempty"`
+	TopP          *float64          `json:"top_p,omitempty"`
+	Stream        bool              `json:"stream,omitempty"`
+	Tools         []ClaudeTool      `json:"tools,omitempty"`
+	ToolChoice    *ClaudeToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *ClaudeMetadata   `json:"metadata,omitempty"`
 }
 
 type ClaudeError struct {
@@ -32,131 +74,455 @@ type ClaudeError struct {
 	Message string `json:"message"`
 }
 
+type ClaudeUsage struct {
+	InputTokens              int `json:"input_tokens"`
+	OutputTokens             int `json:"output_tokens"`
+	CacheReadInputTokens     int `json:"cache_read_input_tokens"`
+	CacheCreationInputTokens int `json:"cache_creation_input_tokens"`
+}
+
 type ClaudeResponse struct {
-	Completion string      `json:"completion"`
-	StopReason string      `json:"stop_reason"`
-	Model      string      `json:"model"`
-	Error      ClaudeError `json:"error"`
+	Id         string               `json:"id"`
+	Model      string               `json:"model"`
+	Content    []ClaudeContentBlock `json:"content"`
+	StopReason string               `json:"stop_reason"`
+	Usage      ClaudeUsage          `json:"usage"`
+	Error      ClaudeError          `json:"error"`
+}
+
+// ClaudeStreamEvent is any of the events of a stream, they are told apart by the type
+type ClaudeStreamEvent struct {
+	Type         string              `json:"type"`
+	Message      *ClaudeResponse     `json:"message,omitempty"`
+	Index        int                 `json:"index"`
+	ContentBlock *ClaudeContentBlock `json:"content_block,omitempty"`
+	Delta        struct {
+		Type        string `json:"type"`
+		Text        string `json:"text"`
+		PartialJson string `json:"partial_json"`
+		StopReason  string `json:"stop_reason"`
+	} `json:"delta"`
+	Usage *ClaudeUsage `json:"usage,omitempty"`
+	Error *ClaudeError `json:"error,omitempty"`
+}
+
+// ClaudeOpenAIResponseMessage is the assistant message of an OpenAI response, which may call tools
+type ClaudeOpenAIResponseMessage struct {
+	Role      string           `json:"role,omitempty"`
+	Content   *string          `json:"content,omitempty"`
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+type ClaudeOpenAIResponseChoice struct {
+	Index        int                         `json:"index"`
+	Message      ClaudeOpenAIResponseMessage `json:"message"`
+	FinishReason string                      `json:"finish_reason"`
+}
+
+type ClaudeOpenAIResponse struct {
+	Id      string                       `json:"id"`
+	Object  string                       `json:"object"`
+	Created int64                        `json:"created"`
+	Model   string                       `json:"model"`
+	Choices []ClaudeOpenAIResponseChoice `json:"choices"`
+	Usage   Usage                        `json:"usage"`
+}
+
+type ClaudeOpenAIStreamChoice struct {
+	Index        int                         `json:"index"`
+	Delta        ClaudeOpenAIResponseMessage `json:"delta"`
+	FinishReason *string                     `json:"finish_reason"`
+}
+
+type ClaudeOpenAIStreamResponse struct {
+	Id      string                     `json:"id"`
+	Object  string                     `json:"object"`
+	Created int64                      `json:"created"`
+	Model   string                     `json:"model"`
+	Choices []ClaudeOpenAIStreamChoice `json:"choices"`
 }
 
 func stopReasonClaude2OpenAI(reason string) string {
 	switch reason {
-	case "stop_sequence":
+	case "end_turn", "stop_sequence":
 		return "stop"
 	case "max_tokens":
 		return "length"
+	case "tool_use":
+		return "tool_calls"
+	case "refusal", "guardrail_intervened", "content_filtered": // the last two are of Bedrock
+		return "content_filter"
 	default:
 		return reason
 	}
 }
 
-func requestOpenAI2Claude(textRequest GeneralOpenAIRequest) *ClaudeRequest {
+func usageClaude2OpenAI(usage ClaudeUsage) Usage {
+	// the input tokens of Anthropic leave out the cached ones, which OpenAI counts in the prompt tokens
+	promptTokens := usage.InputTokens + usage.CacheReadInputTokens + usage.CacheCreationInputTokens
+	openaiUsage := Usage{
+		PromptTokens:     promptTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      promptTokens + usage.OutputTokens,
+	}
+	if usage.CacheReadInputTokens > 0 || usage.CacheCreationInputTokens > 0 {
+		openaiUsage.PromptTokensDetails = &PromptTokensDetails{
+			CachedTokens:        usage.CacheReadInputTokens,
+			CacheCreationTokens: usage.CacheCreationInputTokens,
+		}
+	}
+	return openaiUsage
+}
+
+// getClaudeContent translates the content of an OpenAI message, a string or a list of text and image parts
+func getClaudeContent(content any) ([]ClaudeContentBlock, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if content == "" {
+			return nil, nil
+		}
+		return []ClaudeContentBlock{{Type: "text", Text: content}}, nil
+	case []any:
+		var blocks []ClaudeContentBlock
+		for _, item := range content {
+			part, ok := item.(map[string]any)
+			if !ok {
+				return nil, errors.New("invalid content part")
+			}
+			partType, _ := part["type"].(string)
+			switch partType {
+			case "text":
+				text, _ := part["text"].(string)
+				blocks = append(blocks, ClaudeContentBlock{Type: "text", Text: text})
+			case "image_url":
+				imageURL, _ := part["image_url"].(map[string]any)
+				url, _ := imageURL["url"].(string)
+				source, err := getClaudeImageSource(url)
+				if err != nil {
+					return nil, err
+				}
+				blocks = append(blocks, ClaudeContentBlock{Type: "image", Source: source})
+			default:
+				return nil, fmt.Errorf("content part of type %s is not supported", partType)
+			}
+		}
+		return blocks, nil
+	}
+	return nil, errors.New("content must be a string or a list of content parts")
+}
+
+// getClaudeImageSource turns a data URL into a base64 source, the other URLs are fetched by Anthropic
+func getClaudeImageSource(url string) (*ClaudeImageSource, error) {
+	if strings.HasPrefix(url, "data:") {
+		if i := strings.Index(url, ";base64,"); i >= 0 {
+			data := url[i+len(";base64,"):]
+			mimeType, err := checkImage(url[len("data:"):i], data, claudeImageLimit)
+			if err != nil {
+				return nil, err
+			}
+			return &ClaudeImageSource{Type: "base64", MediaType: mimeType, Data: data}, nil
+		}
+	}
+	return &ClaudeImageSource{Type: "url", Url: url}, nil
+}
+
+func getClaudeToolChoice(toolChoice any) *ClaudeToolChoice {
+	switch toolChoice := toolChoice.(type) {
+	case string:
+		switch toolChoice {
+		case "auto":
+			return &ClaudeToolChoice{Type: "auto"}
+		case "required":
+			return &ClaudeToolChoice{Type: "any"}
+		case "none":
+			return &ClaudeToolChoice{Type: "none"}
+		}
+	case map[string]any:
+		function, _ := toolChoice["function"].(map[string]any)
+		if name, _ := function["name"].(string); name != "" {
+			return &ClaudeToolChoice{Type: "tool", Name: name}
+		}
+	}
+	return nil
+}
+
+// requestOpenAI2Claude moves the system messages to the system prompt, the tool calls to tool_use blocks and the tool
+// messages to tool_result blocks, the consecutive messages of the same role are merged since the roles must alternate
+func requestOpenAI2Claude(request ChatCompletionRequest, modelName string) (*ClaudeRequest, error) {
 	claudeRequest := ClaudeRequest{
-		Model:             textRequest.Model,
-		Prompt:            "",
-		MaxTokensToSample: textRequest.MaxTokens,
-		StopSequences:     nil,
-		Temperature:       textRequest.Temperature,
-		TopP:              textRequest.TopP,
-		Stream:            textRequest.Stream,
-	}
-	if claudeRequest.MaxTokensToSample == 0 {
-		claudeRequest.MaxTokensToSample = 1000000
-	}
-	prompt := ""
-	for _, message := range textRequest.Messages {
-		if message.Role == "user" {
-			prompt += fmt.Sprintf("\n\nHuman: %s", message.Content)
-		} else if message.Role == "assistant" {
-			prompt += fmt.Sprintf("\n\nAssistant: %s", message.Content)
-		} else if message.Role == "system" {
-			prompt += fmt.Sprintf("\n\nSystem: %s", message.Content)
-		}
-	}
-	prompt += "\n\nAssistant:"
-	claudeRequest.Prompt = prompt
-	return &claudeRequest
-}
-
-func streamResponseClaude2OpenAI(claudeResponse *ClaudeResponse) *ChatCompletionsStreamResponse {
-	var choice ChatCompletionsStreamResponseChoice
-	choice.Delta.Content = claudeResponse.Completion
-	finishReason := stopReasonClaude2OpenAI(claudeResponse.StopReason)
-	if finishReason != "null" {
-		choice.FinishReason = &finishReason
+		Model:       modelName,
+		MaxTokens:   request.MaxTokens,
+		Temperature: request.Temperature,
+		TopP:        request.TopP,
+		Stream:      request.Stream,
+		ToolChoice:  getClaudeToolChoice(request.ToolChoice),
+	}
+	if claudeRequest.MaxTokens == 0 {
+		claudeRequest.MaxTokens = claudeDefaultMaxTokens
+	}
+	switch stop := request.Stop.(type) {
+	case string:
+		claudeRequest.StopSequences = []string{stop}
+	case []any:
+		for _, item := range stop {
+			if sequence, ok := item.(string); ok {
+				claudeRequest.StopSequences = append(claudeRequest.StopSequences, sequence)
+			}
+		}
+	}
+	if request.User != "" {
+		claudeRequest.Metadata = &ClaudeMetadata{UserId: request.User}
 	}
-	var response ChatCompletionsStreamResponse
-	response.Object = "chat.completion.chunk"
-	response.Model = claudeResponse.Model
-	response.Choices = []ChatCompletionsStreamResponseChoice{choice}
-	return &response
+	for _, tool := range request.Tools {
+		inputSchema := tool.Function.Parameters
+		if inputSchema == nil {
+			inputSchema = map[string]any{"type": "object"}
+		}
+		claudeRequest.Tools = append(claudeRequest.Tools, ClaudeTool{
+			Name:        tool.Function.Name,
+			Description: tool.Function.Description,
+			InputSchema: inputSchema,
+		})
+	}
+	var systems []string
+	for _, message := range request.Messages {
+		role := message.Role
+		var blocks []ClaudeContentBlock
+		switch message.Role {
+		case "system", "developer":
+			system, err := getClaudeContent(message.Content)
+			if err != nil {
+				return nil, err
+			}
+			for _, block := range system {
+				systems = append(systems, block.Text)
+			}
+			continue
+		case "user", "assistant":
+			var err error
+			blocks, err = getClaudeContent(message.Content)
+			if err != nil {
+				return nil, err
+			}
+			for _, toolCall := range message.ToolCalls {
+				var input any = map[string]any{}
+				if toolCall.Function.Arguments != "" {
+					err = json.Unmarshal([]byte(toolCall.Function.Arguments), &input)
+					if err != nil {
+						return nil, fmt.Errorf("invalid arguments of tool call %s", toolCall.Id)
+					}
+				}
+				blocks = append(blocks, ClaudeContentBlock{Type: "tool_use", Id: toolCall.Id, Name: toolCall.Function.Name, Input: input})
+			}
+		case "tool":
+			role = "user"
+			content, err := getClaudeContent(message.Content)
+			if err != nil {
+				return nil, err
+			}
+			var texts []string
+			for _, block := range content {
+				texts = append(texts, block.Text)
+			}
+			blocks = []ClaudeContentBlock{{Type: "tool_result", ToolUseId: message.ToolCallId, Content: strings.Join(texts, "\n")}}
+		default:
+			return nil, fmt.Errorf("invalid message role %s", message.Role)
+		}
+		if len(blocks) == 0 {
+			continue
+		}
+		if last := len(claudeRequest.Messages) - 1; last >= 0 && claudeRequest.Messages[last].Role == role {
+			claudeRequest.Messages[last].Content = append(claudeRequest.Messages[last].Content, blocks...)
+			continue
+		}
+		claudeRequest.Messages = append(claudeRequest.Messages, ClaudeMessage{Role: role, Content: blocks})
+	}
+	// the Messages API has no response format, the model is told to answer in JSON instead
+	if instruction := getResponseFormatInstruction(request.ResponseFormat); instruction != "" {
+		systems = append(systems, instruction)
+	}
+	claudeRequest.System = strings.Join(systems, "\n")
+	return &claudeRequest, nil
 }
 
-func responseClaude2OpenAI(claudeResponse *ClaudeResponse) *OpenAITextResponse {
-	choice := OpenAITextResponseChoice{
-		Index: 0,
-		Message: Message{
-			Role:    "assistant",
-			Content: strings.TrimPrefix(claudeResponse.Completion, " "),
-			Name:    nil,
-		},
-		FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
+func responseClaude2OpenAI(claudeResponse *ClaudeResponse) *ClaudeOpenAIResponse {
+	message := ClaudeOpenAIResponseMessage{Role: "assistant"}
+	text := ""
+	for _, block := range claudeResponse.Content {
+		switch block.Type {
+		case "text":
+			text += block.Text
+		case "tool_use":
+			arguments, _ := json.Marshal(block.Input)
+			message.ToolCalls = append(message.ToolCalls, OpenAIToolCall{
+				Id:       block.Id,
+				Type:     "function",
+				Function: OpenAIFunctionCall{Name: block.Name, Arguments: string(arguments)},
+			})
+		}
 	}
-	fullTextResponse := OpenAITextResponse{
+	if text != "" || len(message.ToolCalls) == 0 {
+		message.Content = &text
+	}
+	fullTextResponse := ClaudeOpenAIResponse{
 		Id:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
 		Object:  "chat.completion",
 		Created: common.GetTimestamp(),
-		Choices: []OpenAITextResponseChoice{choice},
+		Model:   claudeResponse.Model,
+		Choices: []ClaudeOpenAIResponseChoice{{
+			Index:        0,
+			Message:      message,
+			FinishReason: stopReasonClaude2OpenAI(claudeResponse.StopReason),
+		}},
+		Usage: usageClaude2OpenAI(claudeResponse.Usage),
 	}
 	return &fullTextResponse
 }
 
-func claudeStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, string) {
+func claudeErrorWrapper(claudeError ClaudeError, statusCode int) *OpenAIErrorWithStatusCode {
+	return &OpenAIErrorWithStatusCode{
+		OpenAIError: OpenAIError{
+			Message: claudeError.Message,
+			Type:    claudeError.Type,
+			Param:   "",
+			Code:    claudeError.Type,
+		},
+		StatusCode: statusCode,
+	}
+}
+
+// getClaudeResponseError is the error of a failed response, which is not a stream even if one was requested
+func getClaudeResponseError(resp *http.Response) *OpenAIErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+	var claudeResponse ClaudeResponse
+	err = json.Unmarshal(responseBody, &claudeResponse)
+	if err != nil || claudeResponse.Error.Type == "" {
+		return errorWrapper(fmt.Errorf("status code %d", resp.StatusCode), "bad_response_status_code", resp.StatusCode)
+	}
+	return claudeErrorWrapper(claudeResponse.Error, resp.StatusCode)
+}
+
+// streamEventClaude2OpenAI translates an event of the stream to a chunk, nil for the events without a counterpart,
+// the tool calls are numbered in the order they come since the content blocks also count the text
+func streamEventClaude2OpenAI(event *ClaudeStreamEvent, toolCallIndexes map[int]int) *ClaudeOpenAIStreamResponse {
+	var choice ClaudeOpenAIStreamChoice
+	switch event.Type {
+	case "message_start":
+		empty := ""
+		choice.Delta = ClaudeOpenAIResponseMessage{Role: "assistant", Content: &empty}
+	case "content_block_start":
+		if event.ContentBlock == nil || event.ContentBlock.Type != "tool_use" {
+			return nil
+		}
+		index := len(toolCallIndexes)
+		toolCallIndexes[event.Index] = index
+		choice.Delta.ToolCalls = []OpenAIToolCall{{
+			Index:    &index,
+			Id:       event.ContentBlock.Id,
+			Type:     "function",
+			Function: OpenAIFunctionCall{Name: event.ContentBlock.Name},
+		}}
+	case "content_block_delta":
+		switch event.Delta.Type {
+		case "text_delta":
+			text := event.Delta.Text
+			choice.Delta.Content = &text
+		case "input_json_delta":
+			index, ok := toolCallIndexes[event.Index]
+			if !ok {
+				return nil
+			}
+			choice.Delta.ToolCalls = []OpenAIToolCall{{
+				Index:    &index,
+				Function: OpenAIFunctionCall{Arguments: event.Delta.PartialJson},
+			}}
+		default:
+			return nil
+		}
+	case "message_delta":
+		if event.Delta.StopReason == "" {
+			return nil
+		}
+		finishReason := stopReasonClaude2OpenAI(event.Delta.StopReason)
+		choice.FinishReason = &finishReason
+	default:
+		return nil
+	}
+	return &ClaudeOpenAIStreamResponse{
+		Object:  "chat.completion.chunk",
+		Choices: []ClaudeOpenAIStreamChoice{choice},
+	}
+}
+
+func claudeStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, string, *Usage) {
+	if resp.StatusCode != http.StatusOK {
+		return getClaudeResponseError(resp), "", nil
+	}
 	responseText := ""
 	responseId := fmt.Sprintf("chatcmpl-%s", common.GetUUID())
 	createdTime := common.GetTimestamp()
+	responseModel := ""
+	var claudeUsage ClaudeUsage
+	var streamError *ClaudeError
+	toolCallIndexes := make(map[int]int)
 	scanner := bufio.NewScanner(resp.Body)
-	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
-		if atEOF && len(data) == 0 {
-			return 0, nil, nil
-		}
-		if i := strings.Index(string(data), "\r\n\r\n"); i >= 0 {
-			return i + 4, data[0:i], nil
-		}
-		if atEOF {
-			return len(data), data, nil
-		}
-		return 0, nil, nil
-	})
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
 	go func() {
+		defer close(stopChan)
 		for scanner.Scan() {
 			data := scanner.Text()
-			if !strings.HasPrefix(data, "event: completion") {
+			if !strings.HasPrefix(data, "data:") {
 				continue
 			}
-			data = strings.TrimPrefix(data, "event: completion\r\ndata: ")
-			dataChan <- data
+			select {
+			case dataChan <- strings.TrimSpace(strings.TrimPrefix(data, "data:")):
+			case <-keeper.Done():
+				return
+			}
 		}
-		stopChan <- true
 	}()
-	setEventStreamHeaders(c)
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
-			// some implementations may add \r at the end of data
-			data = strings.TrimSuffix(data, "\r")
-			var claudeResponse ClaudeResponse
-			err := json.Unmarshal([]byte(data), &claudeResponse)
+			keeper.Touch()
+			var event ClaudeStreamEvent
+			err := json.Unmarshal([]byte(data), &event)
 			if err != nil {
 				common.SysError("error unmarshalling stream response: " + err.Error())
 				return true
 			}
-			responseText += claudeResponse.Completion
-			response := streamResponseClaude2OpenAI(&claudeResponse)
+			switch event.Type {
+			case "message_start":
+				if event.Message != nil {
+					responseModel = event.Message.Model
+					claudeUsage = event.Message.Usage
+				}
+			case "message_delta":
+				if event.Usage != nil {
+					claudeUsage.OutputTokens = event.Usage.OutputTokens
+				}
+			case "content_block_delta":
+				responseText += event.Delta.Text + event.Delta.PartialJson
+			case "error":
+				streamError = event.Error
+			}
+			response := streamEventClaude2OpenAI(&event, toolCallIndexes)
+			if response == nil {
+				return true
+			}
 			response.Id = responseId
 			response.Created = createdTime
+			response.Model = responseModel
 			jsonStr, err := json.Marshal(response)
 			if err != nil {
 				common.SysError("error marshalling stream response: " + err.Error())
@@ -164,57 +530,53 @@ func claudeStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithS
 			}
 			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
 			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
 		case <-stopChan:
+			if streamError != nil {
+				jsonStr, _ := json.Marshal(gin.H{"error": claudeErrorWrapper(*streamError, http.StatusInternalServerError).OpenAIError})
+				c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+			}
 			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
 			return false
 		}
 	})
-	err := resp.Body.Close()
+	err := keeper.Finish(resp.Body, stopChan)
 	if err != nil {
-		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), ""
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
-	return nil, responseText
+	usage := usageClaude2OpenAI(claudeUsage)
+	return nil, responseText, &usage
 }
 
-func claudeHandler(c *gin.Context, resp *http.Response, promptTokens int, model string) (*OpenAIErrorWithStatusCode, *Usage) {
+func claudeHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, *Usage, string) {
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, ""
 	}
 	err = resp.Body.Close()
 	if err != nil {
-		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
 	}
 	var claudeResponse ClaudeResponse
 	err = json.Unmarshal(responseBody, &claudeResponse)
 	if err != nil {
-		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, ""
 	}
 	if claudeResponse.Error.Type != "" {
-		return &OpenAIErrorWithStatusCode{
-			OpenAIError: OpenAIError{
-				Message: claudeResponse.Error.Message,
-				Type:    claudeResponse.Error.Type,
-				Param:   "",
-				Code:    claudeResponse.Error.Type,
-			},
-			StatusCode: resp.StatusCode,
-		}, nil
+		return claudeErrorWrapper(claudeResponse.Error, resp.StatusCode), nil, ""
 	}
 	fullTextResponse := responseClaude2OpenAI(&claudeResponse)
-	completionTokens := countTokenText(claudeResponse.Completion, model)
-	usage := Usage{
-		PromptTokens:     promptTokens,
-		CompletionTokens: completionTokens,
-		TotalTokens:      promptTokens + completionTokens,
-	}
-	fullTextResponse.Usage = usage
 	jsonResponse, err := json.Marshal(fullTextResponse)
 	if err != nil {
-		return errorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil
+		return errorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil, ""
 	}
 	c.Writer.Header().Set("Content-Type", "application/json")
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err = c.Writer.Write(jsonResponse)
-	return nil, &usage
+	completionText := ""
+	if content := fullTextResponse.Choices[0].Message.Content; content != nil {
+		completionText = *content
+	}
+	return nil, &fullTextResponse.Usage, completionText
 }
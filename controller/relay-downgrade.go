@@ -0,0 +1,128 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isModelOfSameKind keeps chat requests from being downgraded to an embedding model and so on
+func isModelOfSameKind(relayMode int, modelName string) bool {
+	isEmbedding := strings.Contains(modelName, "embedding")
+	switch relayMode {
+	case RelayModeEmbeddings:
+		return isEmbedding
+	case RelayModeModerations:
+		return strings.Contains(modelName, "moderation")
+	}
+	for _, keyword := range []string{"embedding", "moderation", "dall-e", "whisper", "tts"} {
+		if strings.Contains(modelName, keyword) {
+			return false
+		}
+	}
+	return true
+}
+
+func getAvailableQuota(tokenId int, userId int) (int, error) {
+	token, err := model.GetTokenById(tokenId)
+	if err != nil {
+		return 0, err
+	}
+	userQuota, err := model.CacheGetAvailableQuota(userId, token.OrganizationId)
+	if err != nil {
+		return 0, err
+	}
+	if !token.UnlimitedQuota && token.RemainQuota < userQuota {
+		return token.RemainQuota, nil
+	}
+	return userQuota, nil
+}
+
+// findDowngradeModel returns the cheapest model of the group whose pre-consumed quota fits the available quota
+func findDowngradeModel(group string, modelName string, relayMode int, preConsumedTokens int, availableQuota int) string {
+	models, err := model.GetGroupModels(group)
+	if err != nil {
+		common.SysError("failed to get group models: " + err.Error())
+		return ""
+	}
+	groupRatio := common.GetGroupRatio(group)
+	currentRatio := common.GetModelRatio(modelName)
+	bestModel := ""
+	bestRatio := 0.0
+	for _, candidate := range models {
+		if candidate == modelName || !isModelOfSameKind(relayMode, candidate) {
+			continue
+		}
+		ratio := common.GetModelRatio(candidate)
+		if ratio >= currentRatio || int(float64(preConsumedTokens)*ratio*groupRatio) > availableQuota {
+			continue
+		}
+		if bestModel == "" || ratio < bestRatio {
+			bestModel = candidate
+			bestRatio = ratio
+		}
+	}
+	return bestModel
+}
+
+func replaceRequestModel(c *gin.Context, modelName string) error {
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	var request map[string]interface{}
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return err
+	}
+	request["model"] = modelName
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return nil
+}
+
+// handleInsufficientQuota either relays the request again with a cheaper model if the token opts in,
+// or tells the client which model would fit in the remaining quota
+func handleInsufficientQuota(c *gin.Context, relayMode int, modelName string, preConsumedTokens int, quotaErr error) *OpenAIErrorWithStatusCode {
+	group := c.GetString("group")
+	errWithStatusCode := quotaExhaustedErrorWrapper(quotaErr, "pre_consume_token_quota_failed", http.StatusForbidden)
+	_, channelSpecified := c.Get("channelId")
+	autoDowngrade := c.GetBool("auto_downgrade") && c.GetString("downgraded_from") == "" && !channelSpecified
+	if !autoDowngrade && !common.ModelDowngradeSuggestionEnabled {
+		return errWithStatusCode
+	}
+	availableQuota, err := getAvailableQuota(c.GetInt("token_id"), c.GetInt("id"))
+	if err != nil {
+		return errWithStatusCode
+	}
+	suggestedModel := findDowngradeModel(group, modelName, relayMode, preConsumedTokens, availableQuota)
+	if suggestedModel == "" {
+		return errWithStatusCode
+	}
+	if autoDowngrade {
+		channel, err := middleware.SelectChannel(c, group, suggestedModel)
+		if err == nil && replaceRequestModel(c, suggestedModel) == nil {
+			middleware.SetupContextForSelectedChannel(c, channel)
+			c.Set("downgraded_from", modelName)
+			c.Writer.Header().Set("X-Downgraded-From", modelName)
+			return relayTextHelper(c, relayMode)
+		}
+	}
+	if common.ModelDowngradeSuggestionEnabled {
+		errWithStatusCode.Message = fmt.Sprintf("%s，剩余额度可使用模型 %s", errWithStatusCode.Message, suggestedModel)
+		errWithStatusCode.SuggestedModel = suggestedModel
+	}
+	return errWithStatusCode
+}
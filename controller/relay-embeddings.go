@@ -0,0 +1,250 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultEmbeddingInputLimit = 2048   // the most inputs of an embeddings request to OpenAI
+	maxEmbeddingChunkTokens    = 300000 // the most tokens of all the inputs of an embeddings request to OpenAI
+	maxEmbeddingChunkRequests  = 8      // upper limit of the parallel upstream requests of a split embeddings request
+)
+
+// embeddingInputLimits are the most inputs of an embeddings request for the upstreams which take fewer than OpenAI
+var embeddingInputLimits = map[int]int{
+	APITypeBaidu: 16,
+}
+
+// channelUsageShare is the usage of the part of a request served by one channel, a split request is served by several
+type channelUsageShare struct {
+	channelId int
+	keyHash   string
+	usage     Usage
+}
+
+// getShareQuotas splits the quota of a request between the channels in proportion to their tokens
+func getShareQuotas(quota int, shares []channelUsageShare) []int {
+	quotas := make([]int, len(shares))
+	totalTokens := 0
+	for _, share := range shares {
+		totalTokens += share.usage.PromptTokens + share.usage.CompletionTokens
+	}
+	if totalTokens == 0 {
+		quotas[0] = quota
+		return quotas
+	}
+	remaining := quota
+	for i, share := range shares {
+		quotas[i] = quota * (share.usage.PromptTokens + share.usage.CompletionTokens) / totalTokens
+		remaining -= quotas[i]
+	}
+	quotas[0] += remaining
+	return quotas
+}
+
+// EmbeddingChunkResponse keeps the embeddings as they are, they are lists of floats or base64 strings
+type EmbeddingChunkResponse struct {
+	Object string `json:"object"`
+	Data   []struct {
+		Object    string          `json:"object"`
+		Index     int             `json:"index"`
+		Embedding json.RawMessage `json:"embedding"`
+	} `json:"data"`
+	Model string `json:"model"`
+	Usage Usage  `json:"usage"`
+}
+
+func getEmbeddingInputLimit(channelType int) int {
+	limit, ok := embeddingInputLimits[getAPIType(channelType)]
+	if !ok {
+		limit = defaultEmbeddingInputLimit
+	}
+	if common.EmbeddingChunkSize > 0 && common.EmbeddingChunkSize < limit {
+		limit = common.EmbeddingChunkSize
+	}
+	return limit
+}
+
+// getEmbeddingChunks splits the inputs of an embeddings request which the upstream can't take at once, the chunks are
+// nil when it can
+func getEmbeddingChunks(request GeneralOpenAIRequest, channelType int, promptTokens int) [][]any {
+	inputs, ok := request.Input.([]any)
+	limit := getEmbeddingInputLimit(channelType)
+	if !ok || (len(inputs) <= limit && promptTokens <= maxEmbeddingChunkTokens) {
+		return nil
+	}
+	var chunks [][]any
+	var chunk []any
+	chunkTokens := 0
+	for _, input := range inputs {
+		inputTokens := countTokenEmbeddingInput(input, request.Model)
+		if len(chunk) > 0 && (len(chunk) >= limit || chunkTokens+inputTokens > maxEmbeddingChunkTokens) {
+			chunks = append(chunks, chunk)
+			chunk, chunkTokens = nil, 0
+		}
+		chunk = append(chunk, input)
+		chunkTokens += inputTokens
+	}
+	chunks = append(chunks, chunk)
+	if len(chunks) == 1 {
+		return nil
+	}
+	return chunks
+}
+
+// selectEmbeddingChannels picks the channels the chunks are sent to in turn, the channel of the request first, then as
+// many others of the same priority as the chunks need and which take chunks of the size, nil is the channel of the request
+func selectEmbeddingChannels(c *gin.Context, n int, chunkSize int) []*model.Channel {
+	channels := []*model.Channel{nil}
+	// the channel is chosen by the client, the experiment, the downgrade or the upstream object
+	if _, ok := c.Get("channelId"); ok || c.GetInt("experiment_id") != 0 || c.GetString("downgraded_from") != "" || c.GetString("upstream_object_id") != "" {
+		return channels
+	}
+	channel, err := model.GetChannelById(c.GetInt("channel_id"), true)
+	if err != nil {
+		return channels
+	}
+	usedChannelIds := []int{channel.Id}
+	for len(channels) < n {
+		peer, err := middleware.SelectFailoverChannel(c, usedChannelIds)
+		if err != nil {
+			break
+		}
+		// the lower priorities only take the requests which the higher ones failed
+		if peer.GetPriority() < channel.GetPriority() {
+			model.ReleaseChannelCircuitProbe(peer.Id)
+			break
+		}
+		usedChannelIds = append(usedChannelIds, peer.Id)
+		if getEmbeddingInputLimit(peer.Type) < chunkSize {
+			model.ReleaseChannelCircuitProbe(peer.Id)
+			continue
+		}
+		channels = append(channels, peer)
+	}
+	return channels
+}
+
+// relayEmbeddingChunks sends the chunks of the inputs in parallel across the channels of the model, and merges their
+// embeddings into one response, the chunks are billed at once by the request as the choices, a failed request is not
+// billed but the usage of the channels is recorded
+func relayEmbeddingChunks(c *gin.Context, relayMode int, chunks [][]any) (Usage, []channelUsageShare, *OpenAIErrorWithStatusCode) {
+	var usage Usage
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return usage, nil, errorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return usage, nil, errorWrapper(err, "unmarshal_request_body_failed", http.StatusBadRequest)
+	}
+	channels := selectEmbeddingChannels(c, len(chunks), len(chunks[0]))
+	common.SysLog(fmt.Sprintf("embeddings request of %d inputs split into %d chunks across %d channels", getInputCount(chunks), len(chunks), len(channels)))
+
+	responses := make([]*EmbeddingChunkResponse, len(chunks))
+	shares := make([]*channelUsageShare, len(chunks))
+	errs := make([]*OpenAIErrorWithStatusCode, len(chunks))
+	limiter := make(chan struct{}, maxEmbeddingChunkRequests)
+	var wg sync.WaitGroup
+	for i, chunk := range chunks {
+		request["input"] = chunk
+		chunkRequestBody, err := json.Marshal(request)
+		if err != nil {
+			return usage, nil, errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+		chunkContext, recorder := newChoiceContext(c, chunkRequestBody)
+		// the usage of an earlier attempt of the request is not the one of the chunk
+		chunkContext.Set("relay_usage", nil)
+		if channel := channels[i%len(channels)]; channel != nil {
+			middleware.SetupContextForSelectedChannel(chunkContext, channel)
+		}
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			limiter <- struct{}{}
+			defer func() { <-limiter }()
+			responses[i], shares[i], errs[i] = doEmbeddingChunkRequest(chunkContext, recorder, relayMode, len(chunks[i]))
+		}(i)
+	}
+	wg.Wait()
+
+	var channelShares []channelUsageShare
+	for _, share := range shares {
+		if share != nil {
+			channelShares = append(channelShares, *share)
+		}
+	}
+	for _, err := range errs {
+		if err != nil {
+			return usage, channelShares, err
+		}
+	}
+	merged := EmbeddingChunkResponse{
+		Object: "list",
+		Model:  responses[0].Model,
+	}
+	offset := 0
+	for i, response := range responses {
+		for _, item := range response.Data {
+			item.Index += offset
+			merged.Data = append(merged.Data, item)
+		}
+		offset += len(chunks[i])
+		// the usage billed for the chunk, which is counted when the upstream does not tell it
+		if shares[i] != nil {
+			usage.PromptTokens += shares[i].usage.PromptTokens
+			usage.TotalTokens += shares[i].usage.PromptTokens
+		}
+	}
+	merged.Usage = usage
+	c.JSON(http.StatusOK, merged)
+	return usage, channelShares, nil
+}
+
+func getInputCount(chunks [][]any) int {
+	count := 0
+	for _, chunk := range chunks {
+		count += len(chunk)
+	}
+	return count
+}
+
+func doEmbeddingChunkRequest(chunkContext *gin.Context, recorder *httptest.ResponseRecorder, relayMode int, inputCount int) (*EmbeddingChunkResponse, *channelUsageShare, *OpenAIErrorWithStatusCode) {
+	// the chunks fail over on their own, the other chunks are kept
+	err := relayWithFailover(chunkContext, relayMode, relayTextHelper)
+	var share *channelUsageShare
+	if usage, ok := chunkContext.Value("relay_usage").(Usage); ok {
+		share = &channelUsageShare{
+			channelId: chunkContext.GetInt("channel_id"),
+			keyHash:   chunkContext.GetString("channel_key_hash"),
+			usage:     usage,
+		}
+	}
+	if err != nil {
+		return nil, share, err
+	}
+	var response EmbeddingChunkResponse
+	jsonErr := json.Unmarshal(recorder.Body.Bytes(), &response)
+	if jsonErr != nil {
+		return nil, share, errorWrapper(jsonErr, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	if recorder.Code != http.StatusOK {
+		return nil, share, errorWrapper(fmt.Errorf("bad status code: %d", recorder.Code), "bad_status_code", recorder.Code)
+	}
+	if len(response.Data) != inputCount {
+		return nil, share, errorWrapper(errors.New("upstream returned fewer embeddings than the inputs"), "insufficient_embeddings", http.StatusInternalServerError)
+	}
+	return &response, share, nil
+}
@@ -0,0 +1,119 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type QuotaEstimate struct {
+	Model            string  `json:"model"`
+	PromptTokens     int     `json:"prompt_tokens"`
+	CompletionTokens int     `json:"completion_tokens"` // the max_tokens of the request, or the tokens pre-consumed by the relay when it is not set
+	Choices          int     `json:"choices"`
+	ModelRatio       float64 `json:"model_ratio"`
+	CompletionRatio  float64 `json:"completion_ratio"`
+	GroupRatio       float64 `json:"group_ratio"`
+	PromptQuota      int     `json:"prompt_quota"`
+	CompletionQuota  int     `json:"completion_quota"`
+	Quota            int     `json:"quota"`
+	Cost             float64 `json:"cost"` // unit is USD
+	AvailableQuota   int     `json:"available_quota"`
+	Sufficient       bool    `json:"sufficient"`
+}
+
+// estimateTextQuota counts the prompt the same way as the relay, the completion is charged in full
+// and the cached prompt tokens are not discounted, so the actual quota is at most the estimated one
+func estimateTextQuota(c *gin.Context, relayMode int) (*QuotaEstimate, *OpenAIErrorWithStatusCode) {
+	var textRequest GeneralOpenAIRequest
+	err := common.UnmarshalBodyReusable(c, &textRequest)
+	if err != nil {
+		return nil, errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if textRequest.Model == "" {
+		return nil, errorWrapper(errors.New("model is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	if relayMode == RelayModeChatCompletions && len(textRequest.Messages) == 0 {
+		return nil, errorWrapper(errors.New("field messages is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	if relayMode == RelayModeCompletions && (textRequest.Prompt == nil || textRequest.Prompt == "") {
+		return nil, errorWrapper(errors.New("field prompt is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	choiceCount, bestOf, err := getChoiceCount(textRequest)
+	if err != nil {
+		return nil, errorWrapper(err, "invalid_n", http.StatusBadRequest)
+	}
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping != "" && modelMapping != "{}" {
+		modelMap := make(map[string]string)
+		err := json.Unmarshal([]byte(modelMapping), &modelMap)
+		if err != nil {
+			return nil, errorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+		}
+		if modelMap[textRequest.Model] != "" {
+			textRequest.Model = modelMap[textRequest.Model]
+		}
+	}
+	promptTokens := 0
+	if relayMode == RelayModeChatCompletions {
+		promptTokens = countTokenMessages(textRequest.Messages, textRequest.Model)
+	} else {
+		promptTokens = countTokenInput(textRequest.Prompt, textRequest.Model)
+	}
+	choices := getChoicePlan(getAPIType(c.GetInt("channel")), relayMode, choiceCount, bestOf, textRequest.Stream)
+	promptCount := 1
+	if choices != nil {
+		promptCount = choices.requests
+	}
+	completionTokens := common.PreConsumedQuota
+	if textRequest.MaxTokens != 0 {
+		completionTokens = textRequest.MaxTokens
+	}
+	group := c.GetString("group")
+	estimate := &QuotaEstimate{
+		Model:            textRequest.Model,
+		PromptTokens:     promptTokens * promptCount,
+		CompletionTokens: completionTokens * bestOf,
+		Choices:          bestOf,
+		ModelRatio:       common.GetModelRatio(textRequest.Model),
+		CompletionRatio:  getCompletionRatio(textRequest.Model),
+		GroupRatio:       common.GetGroupRatio(group),
+	}
+	ratio := estimate.ModelRatio * estimate.GroupRatio
+	estimate.PromptQuota = int(float64(estimate.PromptTokens) * ratio)
+	estimate.CompletionQuota = int(float64(estimate.CompletionTokens) * estimate.CompletionRatio * ratio)
+	estimate.Quota = int(float64(estimate.PromptTokens+int(float64(estimate.CompletionTokens)*estimate.CompletionRatio)) * ratio)
+	if ratio != 0 && estimate.Quota <= 0 {
+		estimate.Quota = 1
+	}
+	if estimate.Quota != 0 {
+		estimate.Quota, _ = applyModelCharges(estimate.Model, estimate.Quota, estimate.GroupRatio)
+	}
+	estimate.Cost = float64(estimate.Quota) / common.QuotaPerUnit
+	estimate.AvailableQuota, err = getAvailableQuota(c.GetInt("token_id"), c.GetInt("id"))
+	if err != nil {
+		return nil, errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	estimate.Sufficient = estimate.AvailableQuota >= estimate.Quota
+	return estimate, nil
+}
+
+// EstimateQuota returns the quota a completion request would be charged without relaying it
+func EstimateQuota(c *gin.Context) {
+	relayMode := RelayModeChatCompletions
+	if !strings.HasPrefix(c.Request.URL.Path, "/v1/chat/completions") {
+		relayMode = RelayModeCompletions
+	}
+	estimate, err := estimateTextQuota(c, relayMode)
+	if err != nil {
+		c.JSON(err.StatusCode, gin.H{
+			"error": err.OpenAIError,
+		})
+		return
+	}
+	c.JSON(http.StatusOK, estimate)
+}
@@ -0,0 +1,163 @@
+package controller
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// shouldFailover tells whether another channel may succeed where this one failed,
+// the requests refused by One API itself fail the same way on any channel
+func shouldFailover(c *gin.Context, err *OpenAIErrorWithStatusCode) bool {
+	if err.quotaExhausted || c.Writer.Written() {
+		return false
+	}
+	// the channel is chosen by the client, the experiment, the downgrade or the upstream object
+	if _, ok := c.Get("channelId"); ok || c.GetInt("experiment_id") != 0 || c.GetString("downgraded_from") != "" || c.GetString("upstream_object_id") != "" {
+		return false
+	}
+	if c.GetString("request_model") == "" {
+		return false
+	}
+	switch {
+	case err.StatusCode == http.StatusTooManyRequests || err.StatusCode >= http.StatusInternalServerError:
+		return true
+	case err.StatusCode == http.StatusUnauthorized || err.StatusCode == http.StatusForbidden:
+		return err.Type != "one_api_error"
+	}
+	return false
+}
+
+// shouldRotateKey tells whether another key of the same channel may succeed where this one was rate limited or refused
+func shouldRotateKey(c *gin.Context, err *OpenAIErrorWithStatusCode) bool {
+	// the upstream objects are only known to the key which created them
+	if err.quotaExhausted || c.Writer.Written() || err.Type == "one_api_error" || c.GetString("channel_key_hash") == "" || c.GetString("upstream_object_id") != "" {
+		return false
+	}
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode == http.StatusUnauthorized || isKeyUnusable(&err.OpenAIError)
+}
+
+// shouldRetryChannel tells whether the request may succeed with the same channel when tried again, such as after a
+// timeout or an overloaded upstream
+func shouldRetryChannel(c *gin.Context, err *OpenAIErrorWithStatusCode) bool {
+	if err.quotaExhausted || c.Writer.Written() {
+		return false
+	}
+	return err.StatusCode == http.StatusTooManyRequests || err.StatusCode >= http.StatusInternalServerError
+}
+
+// pickOtherChannelKey chooses another key of the channel of the request than the failed ones, ok is false when none is left
+func pickOtherChannelKey(c *gin.Context, failedKeyHashes []string) (key string, keyHash string, ok bool) {
+	channel, err := model.GetChannelById(c.GetInt("channel_id"), true)
+	if err != nil {
+		return "", "", false
+	}
+	return model.PickOtherChannelKey(channel, failedKeyHashes)
+}
+
+// recordRelayResult measures the channel for the latency routing and its circuit breaker, the requests refused before
+// reaching the upstream and the errors caused by the request itself are not counted
+func recordRelayResult(c *gin.Context, latency int64, err *OpenAIErrorWithStatusCode) {
+	channelId := c.GetInt("channel_id")
+	success := err == nil
+	if err != nil && (err.quotaExhausted || (err.StatusCode != http.StatusTooManyRequests && err.StatusCode < http.StatusInternalServerError)) {
+		model.ReleaseChannelCircuitProbe(channelId)
+		return
+	}
+	model.RecordChannelCircuitResult(channelId, success)
+	if modelName := c.GetString("request_model"); modelName != "" {
+		model.RecordChannelLatency(channelId, modelName, latency, success)
+	}
+}
+
+// getRetryTimes is how many other channels are tried after the first one fails, the client may lower it with ?retry=
+func getRetryTimes(c *gin.Context) int {
+	retryTimes := resolveIntOption(c, "RetryTimes", common.RetryTimes)
+	if retry, err := strconv.Atoi(c.Query("retry")); err == nil && retry >= 0 && retry < retryTimes {
+		retryTimes = retry
+	}
+	return retryTimes
+}
+
+// relayWithFailover relays the request again with up to RetryTimes other channels of the model until one succeeds,
+// the channels of the same priority are tried before falling through to the lower ones
+func relayWithFailover(c *gin.Context, relayMode int, relayHelper func(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode) (finalErr *OpenAIErrorWithStatusCode) {
+	requestBody, readErr := io.ReadAll(c.Request.Body)
+	if readErr != nil {
+		return errorWrapper(readErr, "read_request_body_failed", http.StatusBadRequest)
+	}
+	relayStartTime := time.Now()
+	defer func() {
+		recordFeatureFlagMetrics(c, time.Since(relayStartTime).Milliseconds(), finalErr)
+	}()
+	retryTimes := 0
+	if isFeatureEnabled(c, "relay_failover") {
+		retryTimes = getRetryTimes(c)
+	}
+	var failedChannelIds []int
+	var failedKeyHashes []string
+	channelRetries := 0
+	for {
+		c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+		c.Request.ContentLength = int64(len(requestBody))
+		startTime := time.Now()
+		err := relayHelper(c, relayMode)
+		recordRelayResult(c, time.Since(startTime).Milliseconds(), err)
+		if err == nil {
+			mirrorToShadowChannel(c, relayMode, requestBody)
+			return nil
+		}
+		// the other keys of the channel are tried before the other channels
+		if shouldRotateKey(c, err) {
+			failedKeyHash := c.GetString("channel_key_hash")
+			failedKeyHashes = append(failedKeyHashes, failedKeyHash)
+			if key, keyHash, ok := pickOtherChannelKey(c, failedKeyHashes); ok {
+				reportRelayError(c, err)
+				common.SysLog(fmt.Sprintf("key %s of channel #%d failed, rotating to key %s", failedKeyHash[:8], c.GetInt("channel_id"), keyHash[:8]))
+				middleware.SetupContextForChannelKey(c, key, keyHash)
+				continue
+			}
+		}
+		// the retries of the channel come before the other channels
+		if channelRetries < getChannelTimeoutPolicy(c).RetryTimes && shouldRetryChannel(c, err) {
+			channelRetries++
+			reportRelayError(c, err)
+			common.SysLog(fmt.Sprintf("channel #%d failed, retrying it (%d)", c.GetInt("channel_id"), channelRetries))
+			continue
+		}
+		if len(failedChannelIds) >= retryTimes || !shouldFailover(c, err) {
+			return err
+		}
+		failedChannelId := c.GetInt("channel_id")
+		failedChannelIds = append(failedChannelIds, failedChannelId)
+		channel, selectErr := middleware.SelectFailoverChannel(c, failedChannelIds)
+		if selectErr != nil {
+			// all the channels of the model failed, the next model of its fallback chain is tried
+			fallbackChannel, fallbackModel, fallbackErr := middleware.SelectFallbackChannel(c)
+			if fallbackErr != nil {
+				return err
+			}
+			failedModel := c.GetString("request_model")
+			c.Request.Body = io.NopCloser(bytes.NewReader(requestBody))
+			if middleware.SetFallbackModel(c, fallbackModel) != nil {
+				return err
+			}
+			requestBody, _ = io.ReadAll(c.Request.Body)
+			common.SysLog(fmt.Sprintf("all channels of model %s failed, falling back to model %s", failedModel, fallbackModel))
+			channel = fallbackChannel
+		}
+		reportRelayError(c, err)
+		common.SysLog(fmt.Sprintf("channel #%d failed, failing over to channel #%d", failedChannelId, channel.Id))
+		middleware.SetupContextForSelectedChannel(c, channel)
+		failedKeyHashes = nil
+		channelRetries = 0
+	}
+}
@@ -0,0 +1,211 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the fine-tuning API of Azure is in the GA API versions since 2024-10-21
+const defaultAzureFineTuningAPIVersion = "2024-10-21"
+
+// FineTuningRequest is the part of a request creating a fine-tuning job used for its routing, the request is relayed
+// as it is, see https://platform.openai.com/docs/api-reference/fine-tuning
+type FineTuningRequest struct {
+	Model string `json:"model"`
+}
+
+// FineTuningJob is the part of a fine-tuning job used for its ownership and its billing
+type FineTuningJob struct {
+	Id            string `json:"id"`
+	Object        string `json:"object"`
+	Model         string `json:"model"`
+	Status        string `json:"status"`
+	TrainedTokens *int   `json:"trained_tokens"`
+}
+
+type FineTuningJobList struct {
+	Object  string            `json:"object"`
+	Data    []json.RawMessage `json:"data"`
+	HasMore bool              `json:"has_more"`
+}
+
+func relayFineTuningHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel")
+	if getAPIType(channelType) != APITypeOpenAI {
+		// the upstreams of their own formats have no fine-tuning API, another channel of the model may have
+		return errorWrapper(fmt.Errorf("channel type %d does not support the fine-tuning API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
+	createsJob := c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/fine_tuning/jobs"
+	if createsJob {
+		var fineTuningRequest FineTuningRequest
+		err := common.UnmarshalBodyReusable(c, &fineTuningRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		// the training and the validation files may be the files of the gateway
+		errWithStatusCode := resolveUpstreamFiles(c)
+		if errWithStatusCode != nil {
+			return errWithStatusCode
+		}
+		if c.GetBool("consume_quota") {
+			// the jobs are billed once they succeed, their trained tokens are unknown before
+			userQuota, err := model.CacheGetAvailableQuota(c.GetInt("id"), c.GetInt("organization_id"))
+			if err != nil {
+				return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+			}
+			if userQuota <= 0 {
+				return quotaExhaustedErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+			}
+		}
+		errWithStatusCode = mapRequestModel(c, fineTuningRequest.Model)
+		if errWithStatusCode != nil {
+			return errWithStatusCode
+		}
+	}
+
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	fullRequestURL := getUpstreamRequestURL(c, c.GetString("request_model"), defaultAzureFineTuningAPIVersion)
+	req, err := newUpstreamRequest(c, fullRequestURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return getOpenAIResponseError(resp)
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError)
+	}
+	jobs, responseBody, err := getFineTuningJobs(c, responseBody)
+	if err != nil {
+		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError)
+	}
+	copyResponseHeaders(c, resp)
+	c.Writer.Header().Del("Content-Length")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return errorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+	}
+	recordFineTuningJobs(c, jobs, createsJob)
+	return nil
+}
+
+// getFineTuningJobs parses the job or the list of the jobs of the response, the list keeps only the jobs of the token,
+// the keys of the channels are shared by the tokens, the events and the checkpoints of a job have no job
+func getFineTuningJobs(c *gin.Context, responseBody []byte) ([]FineTuningJob, []byte, error) {
+	if c.Param("fine_tuning_job_id") != "" || c.Request.Method == http.MethodPost {
+		var job FineTuningJob
+		err := json.Unmarshal(responseBody, &job)
+		if err != nil || job.Object != "fine_tuning.job" {
+			return nil, responseBody, err
+		}
+		return []FineTuningJob{job}, responseBody, nil
+	}
+	var list FineTuningJobList
+	err := json.Unmarshal(responseBody, &list)
+	if err != nil {
+		return nil, nil, err
+	}
+	ids, err := model.GetTokenUpstreamObjectIds(c.GetInt("token_id"), model.UpstreamObjectTypeFineTuningJob, c.GetInt("channel_id"))
+	if err != nil {
+		return nil, nil, err
+	}
+	owned := make(map[string]bool)
+	for _, id := range ids {
+		owned[id] = true
+	}
+	var jobs []FineTuningJob
+	data := make([]json.RawMessage, 0, len(list.Data))
+	for _, item := range list.Data {
+		var job FineTuningJob
+		err := json.Unmarshal(item, &job)
+		if err != nil {
+			return nil, nil, err
+		}
+		if !owned[job.Id] {
+			continue
+		}
+		jobs = append(jobs, job)
+		data = append(data, item)
+	}
+	list.Data = data
+	responseBody, err = json.Marshal(list)
+	return jobs, responseBody, err
+}
+
+// recordFineTuningJobs records the created job for the token, and bills the jobs which succeeded, each of them once,
+// whichever request sees them succeeded first
+func recordFineTuningJobs(c *gin.Context, jobs []FineTuningJob, createsJob bool) {
+	for _, job := range jobs {
+		if createsJob {
+			model.RecordUpstreamObject(&model.UpstreamObject{
+				Id:        job.Id,
+				Type:      model.UpstreamObjectTypeFineTuningJob,
+				UserId:    c.GetInt("id"),
+				TokenId:   c.GetInt("token_id"),
+				ChannelId: c.GetInt("channel_id"),
+				KeyHash:   c.GetString("channel_key_hash"),
+				Model:     c.GetString("request_model"),
+			})
+		}
+		if job.Status != "succeeded" || job.TrainedTokens == nil || *job.TrainedTokens <= 0 {
+			continue
+		}
+		// the model of the job is the one requested, the upstream reports the mapped one
+		object, err := model.GetUserUpstreamObject(job.Id, c.GetInt("id"))
+		if err == nil && model.MarkUpstreamObjectBilled(job.Id) {
+			billFineTuningJob(c, job.Id, object.Model, *job.TrainedTokens)
+		}
+	}
+}
+
+func getFineTuningQuota(modelName string, trainedTokens int, groupRatio float64) int {
+	return int(math.Ceil(common.GetFineTuningPrice(modelName) * float64(trainedTokens) / 1000000 * common.QuotaPerUnit * groupRatio))
+}
+
+func billFineTuningJob(c *gin.Context, jobId string, modelName string, trainedTokens int) {
+	settlement := getQuotaSettlement(c)
+	go func() {
+		model.RecordChannelTokens(settlement.channelId, modelName, trainedTokens)
+		groupRatio := common.GetGroupRatio(settlement.group)
+		// the budgets of the channel count the list price, without the group ratio and the discounts
+		spend := getFineTuningQuota(modelName, trainedTokens, 1)
+		model.RecordChannelSpend(settlement.channelId, spend)
+		quota := 0
+		if settlement.consumeQuota {
+			quota = getFineTuningQuota(modelName, trainedTokens, groupRatio)
+			chargeLog := ""
+			if quota != 0 {
+				quota, chargeLog = applyModelCharges(modelName, quota, groupRatio)
+				var couponLog string
+				quota, couponLog = applyUserCoupon(settlement.userId, modelName, quota)
+				chargeLog += couponLog
+			}
+			logContent := fmt.Sprintf("微调任务 %s 训练 %d tokens，单价 $%.3f / 1M tokens，分组倍率 %.2f", jobId, trainedTokens, common.GetFineTuningPrice(modelName), groupRatio)
+			logContent += chargeLog
+			settlement.settle(modelName, trainedTokens, 0, quota, logContent)
+		}
+		model.RecordChannelUsage(settlement.channelId, modelName, trainedTokens, 0, quota, float64(spend)/common.QuotaPerUnit, true)
+	}()
+}
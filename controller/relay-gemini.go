@@ -0,0 +1,493 @@
+package controller
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"one-api/common"
+	"strings"
+)
+
+// the OpenAI chat requests are translated to the Gemini generateContent API, the types are shared with the Gemini
+// ingress, see https://ai.google.dev/api/generate-content
+
+type GeminiError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Status  string `json:"status"`
+}
+
+type GeminiErrorResponse struct {
+	Error GeminiError `json:"error"`
+}
+
+func finishReasonGemini2OpenAI(reason string) string {
+	switch reason {
+	case "STOP":
+		return "stop"
+	case "MAX_TOKENS":
+		return "length"
+	case "SAFETY", "RECITATION", "BLOCKLIST", "PROHIBITED_CONTENT", "SPII", "IMAGE_SAFETY":
+		return "content_filter"
+	case "":
+		return ""
+	default:
+		return strings.ToLower(reason)
+	}
+}
+
+func usageGemini2OpenAI(usage *GeminiUsageMetadata) *Usage {
+	if usage == nil {
+		return nil
+	}
+	// the thoughts are billed as output
+	completionTokens := usage.CandidatesTokenCount + usage.ThoughtsTokenCount
+	openaiUsage := Usage{
+		PromptTokens:     usage.PromptTokenCount,
+		CompletionTokens: completionTokens,
+		TotalTokens:      usage.PromptTokenCount + completionTokens,
+	}
+	if usage.CachedContentTokenCount > 0 {
+		openaiUsage.PromptTokensDetails = &PromptTokensDetails{CachedTokens: usage.CachedContentTokenCount}
+	}
+	return &openaiUsage
+}
+
+// getGeminiImagePart inlines an image, a data URL as is and any other URL after downloading it
+func getGeminiImagePart(url string, proxy string) (map[string]any, error) {
+	mimeType, data, err := getImageData(url, proxy)
+	if err != nil {
+		return nil, err
+	}
+	mimeType, err = checkImage(mimeType, data, geminiImageLimit)
+	if err != nil {
+		return nil, err
+	}
+	return map[string]any{"inlineData": gin.H{"mimeType": mimeType, "data": data}}, nil
+}
+
+// getGeminiParts translates the content of an OpenAI message, a string or a list of text and image parts
+func getGeminiParts(content any, proxy string) ([]map[string]any, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if content == "" {
+			return nil, nil
+		}
+		return []map[string]any{{"text": content}}, nil
+	case []any:
+		var parts []map[string]any
+		for _, item := range content {
+			part, ok := item.(map[string]any)
+			if !ok {
+				return nil, errors.New("invalid content part")
+			}
+			partType, _ := part["type"].(string)
+			switch partType {
+			case "text":
+				text, _ := part["text"].(string)
+				parts = append(parts, map[string]any{"text": text})
+			case "image_url":
+				imageURL, _ := part["image_url"].(map[string]any)
+				url, _ := imageURL["url"].(string)
+				imagePart, err := getGeminiImagePart(url, proxy)
+				if err != nil {
+					return nil, err
+				}
+				parts = append(parts, imagePart)
+			default:
+				return nil, fmt.Errorf("content part of type %s is not supported", partType)
+			}
+		}
+		return parts, nil
+	}
+	return nil, errors.New("content must be a string or a list of content parts")
+}
+
+// getGeminiTools translates the tools to function declarations, whose parameters are the JSON schemas as they are
+func getGeminiTools(tools []OpenAITool) []any {
+	if len(tools) == 0 {
+		return nil
+	}
+	declarations := make([]map[string]any, 0, len(tools))
+	for _, tool := range tools {
+		declaration := map[string]any{"name": tool.Function.Name}
+		if tool.Function.Description != "" {
+			declaration["description"] = tool.Function.Description
+		}
+		if tool.Function.Parameters != nil {
+			declaration["parametersJsonSchema"] = tool.Function.Parameters
+		}
+		declarations = append(declarations, declaration)
+	}
+	return []any{map[string]any{"functionDeclarations": declarations}}
+}
+
+func getGeminiToolConfig(toolChoice any) *GeminiToolConfig {
+	switch toolChoice := toolChoice.(type) {
+	case string:
+		switch toolChoice {
+		case "auto":
+			return &GeminiToolConfig{FunctionCallingConfig: &GeminiFunctionCallingConfig{Mode: "AUTO"}}
+		case "required":
+			return &GeminiToolConfig{FunctionCallingConfig: &GeminiFunctionCallingConfig{Mode: "ANY"}}
+		case "none":
+			return &GeminiToolConfig{FunctionCallingConfig: &GeminiFunctionCallingConfig{Mode: "NONE"}}
+		}
+	case map[string]any:
+		function, _ := toolChoice["function"].(map[string]any)
+		if name, _ := function["name"].(string); name != "" {
+			return &GeminiToolConfig{FunctionCallingConfig: &GeminiFunctionCallingConfig{Mode: "ANY", AllowedFunctionNames: []string{name}}}
+		}
+	}
+	return nil
+}
+
+// getGeminiFunctionResponsePart is the part of a tool message, the response must be an object so the results which are
+// not one are wrapped
+func getGeminiFunctionResponsePart(name string, content any) map[string]any {
+	result := Message{Content: content}.StringContent()
+	var response map[string]any
+	if json.Unmarshal([]byte(result), &response) != nil || response == nil {
+		response = map[string]any{"result": result}
+	}
+	return map[string]any{"functionResponse": gin.H{"name": name, "response": response}}
+}
+
+// requestOpenAI2Gemini moves the system messages to the system instruction, the tool calls to function calls and the
+// tool messages to function responses, which are matched by the names of the functions, and merges the consecutive
+// messages of the same role, the safety settings of the request are passed as they are
+func requestOpenAI2Gemini(request ChatCompletionRequest, proxy string) (*GeminiGenerateContentRequest, error) {
+	geminiRequest := GeminiGenerateContentRequest{
+		Tools:          getGeminiTools(request.Tools),
+		ToolConfig:     getGeminiToolConfig(request.ToolChoice),
+		SafetySettings: request.SafetySettings,
+		GenerationConfig: &GeminiGenerationConfig{
+			Temperature:     request.Temperature,
+			TopP:            request.TopP,
+			MaxOutputTokens: request.MaxTokens,
+		},
+	}
+	switch stop := request.Stop.(type) {
+	case string:
+		geminiRequest.GenerationConfig.StopSequences = []string{stop}
+	case []any:
+		for _, item := range stop {
+			if sequence, ok := item.(string); ok {
+				geminiRequest.GenerationConfig.StopSequences = append(geminiRequest.GenerationConfig.StopSequences, sequence)
+			}
+		}
+	}
+	if format := request.ResponseFormat; format != nil && (format.Type == "json_object" || format.Type == "json_schema") {
+		geminiRequest.GenerationConfig.ResponseMimeType = "application/json"
+		if format.JsonSchema != nil {
+			geminiRequest.GenerationConfig.ResponseJsonSchema = format.JsonSchema.Schema
+		}
+	}
+	// the names of the functions of the tool calls by their ids
+	toolCallNames := make(map[string]string)
+	for _, message := range request.Messages {
+		var parts []map[string]any
+		var err error
+		if message.Role == "tool" {
+			name, ok := toolCallNames[message.ToolCallId]
+			if !ok {
+				return nil, fmt.Errorf("tool message answers no tool call %s", message.ToolCallId)
+			}
+			parts = []map[string]any{getGeminiFunctionResponsePart(name, message.Content)}
+		} else {
+			parts, err = getGeminiParts(message.Content, proxy)
+			if err != nil {
+				return nil, err
+			}
+		}
+		for _, toolCall := range message.ToolCalls {
+			toolCallNames[toolCall.Id] = toolCall.Function.Name
+			args := map[string]any{}
+			if toolCall.Function.Arguments != "" {
+				err = json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
+				if err != nil {
+					return nil, fmt.Errorf("invalid arguments of tool call %s", toolCall.Id)
+				}
+			}
+			parts = append(parts, map[string]any{"functionCall": gin.H{"name": toolCall.Function.Name, "args": args}})
+		}
+		if len(parts) == 0 {
+			continue
+		}
+		role := "user"
+		switch message.Role {
+		case "system", "developer":
+			if geminiRequest.SystemInstruction == nil {
+				geminiRequest.SystemInstruction = &GeminiContent{}
+			}
+			geminiRequest.SystemInstruction.Parts = append(geminiRequest.SystemInstruction.Parts, parts...)
+			continue
+		case "user", "tool":
+		case "assistant":
+			role = "model"
+		default:
+			return nil, fmt.Errorf("invalid message role %s", message.Role)
+		}
+		if last := len(geminiRequest.Contents) - 1; last >= 0 && geminiRequest.Contents[last].Role == role {
+			geminiRequest.Contents[last].Parts = append(geminiRequest.Contents[last].Parts, parts...)
+			continue
+		}
+		geminiRequest.Contents = append(geminiRequest.Contents, GeminiContent{Role: role, Parts: parts})
+	}
+	if len(geminiRequest.Contents) == 0 {
+		return nil, errors.New("messages must have a user or assistant message")
+	}
+	return &geminiRequest, nil
+}
+
+// getGeminiCandidateText joins the text parts of the candidate, leaving out the thoughts
+func getGeminiCandidateText(candidate GeminiCandidate) string {
+	text := ""
+	for _, part := range candidate.Content.Parts {
+		if !part.Thought {
+			text += part.Text
+		}
+	}
+	return text
+}
+
+// getGeminiToolCalls translates the function calls of the candidate, they get ids of their own when Gemini gives none
+func getGeminiToolCalls(candidate GeminiCandidate) []OpenAIToolCall {
+	var toolCalls []OpenAIToolCall
+	for _, part := range candidate.Content.Parts {
+		if part.FunctionCall == nil {
+			continue
+		}
+		id := part.FunctionCall.Id
+		if id == "" {
+			id = "call_" + strings.ReplaceAll(common.GetUUID(), "-", "")
+		}
+		args := part.FunctionCall.Args
+		if args == nil {
+			args = map[string]any{}
+		}
+		arguments, _ := json.Marshal(args)
+		toolCalls = append(toolCalls, OpenAIToolCall{
+			Id:       id,
+			Type:     "function",
+			Function: OpenAIFunctionCall{Name: part.FunctionCall.Name, Arguments: string(arguments)},
+		})
+	}
+	return toolCalls
+}
+
+// the candidates which called functions stop as the others do
+func getGeminiFinishReason(candidate GeminiCandidate, calledFunctions bool) string {
+	finishReason := finishReasonGemini2OpenAI(candidate.FinishReason)
+	if finishReason == "stop" && calledFunctions {
+		return "tool_calls"
+	}
+	return finishReason
+}
+
+func geminiErrorWrapper(geminiError GeminiError, statusCode int) *OpenAIErrorWithStatusCode {
+	return &OpenAIErrorWithStatusCode{
+		OpenAIError: OpenAIError{
+			Message: geminiError.Message,
+			Type:    geminiError.Status,
+			Param:   "",
+			Code:    geminiError.Status,
+		},
+		StatusCode: statusCode,
+	}
+}
+
+func getGeminiResponseError(resp *http.Response) *OpenAIErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+	// the stream errors come in a list
+	responseBody = []byte(strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(string(responseBody)), "["), "]"))
+	var errorResponse GeminiErrorResponse
+	err = json.Unmarshal(responseBody, &errorResponse)
+	if err != nil || errorResponse.Error.Message == "" {
+		return errorWrapper(fmt.Errorf("status code %d", resp.StatusCode), "bad_response_status_code", resp.StatusCode)
+	}
+	return geminiErrorWrapper(errorResponse.Error, resp.StatusCode)
+}
+
+func getGeminiBlockedError(response *GeminiGenerateContentResponse) *OpenAIErrorWithStatusCode {
+	if response.PromptFeedback == nil || response.PromptFeedback.BlockReason == "" {
+		return nil
+	}
+	return geminiErrorWrapper(GeminiError{
+		Message: "the prompt was blocked: " + response.PromptFeedback.BlockReason,
+		Status:  "prompt_blocked",
+	}, http.StatusBadRequest)
+}
+
+func responseGemini2OpenAI(response *GeminiGenerateContentResponse) *OpenAITextResponse {
+	fullTextResponse := OpenAITextResponse{
+		Id:      fmt.Sprintf("chatcmpl-%s", common.GetUUID()),
+		Object:  "chat.completion",
+		Created: common.GetTimestamp(),
+		Choices: make([]OpenAITextResponseChoice, 0, len(response.Candidates)),
+	}
+	for _, candidate := range response.Candidates {
+		message := Message{
+			Role:      "assistant",
+			Content:   getGeminiCandidateText(candidate),
+			ToolCalls: getGeminiToolCalls(candidate),
+		}
+		if message.Content == "" && len(message.ToolCalls) > 0 {
+			message.Content = nil
+		}
+		fullTextResponse.Choices = append(fullTextResponse.Choices, OpenAITextResponseChoice{
+			Index:        candidate.Index,
+			Message:      message,
+			FinishReason: getGeminiFinishReason(candidate, len(message.ToolCalls) > 0),
+		})
+	}
+	return &fullTextResponse
+}
+
+func geminiStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, string, *Usage) {
+	if resp.StatusCode != http.StatusOK {
+		return getGeminiResponseError(resp), "", nil
+	}
+	responseText := ""
+	responseId := fmt.Sprintf("chatcmpl-%s", common.GetUUID())
+	createdTime := common.GetTimestamp()
+	var usage *Usage
+	// the function calls come whole, they are numbered in the order they come for each candidate
+	toolCallCounts := make(map[int]int)
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
+	dataChan := make(chan string)
+	stopChan := make(chan bool)
+	go func() {
+		defer close(stopChan)
+		for scanner.Scan() {
+			data := scanner.Text()
+			if !strings.HasPrefix(data, "data:") {
+				continue
+			}
+			select {
+			case dataChan <- strings.TrimSpace(strings.TrimPrefix(data, "data:")):
+			case <-keeper.Done():
+				return
+			}
+		}
+	}()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case data := <-dataChan:
+			keeper.Touch()
+			var geminiResponse GeminiGenerateContentResponse
+			err := json.Unmarshal([]byte(data), &geminiResponse)
+			if err != nil {
+				common.SysError("error unmarshalling stream response: " + err.Error())
+				return true
+			}
+			if geminiResponse.UsageMetadata != nil {
+				usage = usageGemini2OpenAI(geminiResponse.UsageMetadata)
+			}
+			response := ChatCompletionsStreamResponse{
+				Id:      responseId,
+				Object:  "chat.completion.chunk",
+				Created: createdTime,
+				Model:   geminiResponse.ModelVersion,
+			}
+			if blockedErr := getGeminiBlockedError(&geminiResponse); blockedErr != nil {
+				finishReason := "content_filter"
+				response.Choices = []ChatCompletionsStreamResponseChoice{{FinishReason: &finishReason}}
+			}
+			for _, candidate := range geminiResponse.Candidates {
+				var choice ChatCompletionsStreamResponseChoice
+				choice.Index = candidate.Index
+				choice.Delta.Content = getGeminiCandidateText(candidate)
+				for _, toolCall := range getGeminiToolCalls(candidate) {
+					index := toolCallCounts[candidate.Index]
+					toolCallCounts[candidate.Index]++
+					toolCall.Index = &index
+					choice.Delta.ToolCalls = append(choice.Delta.ToolCalls, toolCall)
+					responseText += toolCall.Function.Arguments
+				}
+				if finishReason := getGeminiFinishReason(candidate, toolCallCounts[candidate.Index] > 0); finishReason != "" {
+					choice.FinishReason = &finishReason
+				}
+				responseText += choice.Delta.Content
+				response.Choices = append(response.Choices, choice)
+			}
+			if len(response.Choices) == 0 {
+				return true
+			}
+			jsonStr, err := json.Marshal(response)
+			if err != nil {
+				common.SysError("error marshalling stream response: " + err.Error())
+				return true
+			}
+			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonStr)})
+			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
+		case <-stopChan:
+			c.Render(-1, common.CustomEvent{Data: "data: [DONE]"})
+			return false
+		}
+	})
+	err := keeper.Finish(resp.Body, stopChan)
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
+	}
+	return nil, responseText, usage
+}
+
+func geminiHandler(c *gin.Context, resp *http.Response, promptTokens int, model string) (*OpenAIErrorWithStatusCode, *Usage, string) {
+	if resp.StatusCode != http.StatusOK {
+		return getGeminiResponseError(resp), nil, ""
+	}
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	var geminiResponse GeminiGenerateContentResponse
+	err = json.Unmarshal(responseBody, &geminiResponse)
+	if err != nil {
+		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	if blockedErr := getGeminiBlockedError(&geminiResponse); blockedErr != nil {
+		return blockedErr, nil, ""
+	}
+	fullTextResponse := responseGemini2OpenAI(&geminiResponse)
+	completionText := ""
+	for _, choice := range fullTextResponse.Choices {
+		completionText += choice.Message.StringContent()
+		for _, toolCall := range choice.Message.ToolCalls {
+			completionText += toolCall.Function.Arguments
+		}
+	}
+	usage := usageGemini2OpenAI(geminiResponse.UsageMetadata)
+	if usage == nil {
+		completionTokens := countTokenText(completionText, model)
+		usage = &Usage{PromptTokens: promptTokens, CompletionTokens: completionTokens, TotalTokens: promptTokens + completionTokens}
+	}
+	fullTextResponse.Usage = *usage
+	jsonResponse, err := json.Marshal(fullTextResponse)
+	if err != nil {
+		return errorWrapper(err, "marshal_response_body_failed", http.StatusInternalServerError), nil, ""
+	}
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(jsonResponse)
+	return nil, usage, completionText
+}
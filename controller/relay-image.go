@@ -6,44 +6,120 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"mime/multipart"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"strconv"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
+// getMultipartImageRequest reads the fields of an edit or a variation, the images and the mask are relayed as they are
+func getMultipartImageRequest(form *multipart.Form) (ImageRequest, error) {
+	value := func(key string) string {
+		if len(form.Value[key]) > 0 {
+			return form.Value[key][0]
+		}
+		return ""
+	}
+	imageRequest := ImageRequest{
+		Model:          value("model"),
+		Prompt:         value("prompt"),
+		Size:           value("size"),
+		Quality:        value("quality"),
+		ResponseFormat: value("response_format"),
+	}
+	if n := value("n"); n != "" {
+		var err error
+		imageRequest.N, err = strconv.Atoi(n)
+		if err != nil {
+			return imageRequest, errors.New("n must be an integer")
+		}
+	}
+	// gpt-image-1 takes several images as image[]
+	if len(form.File["image"]) == 0 && len(form.File["image[]"]) == 0 {
+		return imageRequest, errors.New("image is required")
+	}
+	return imageRequest, nil
+}
+
 func relayImageHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 	imageModel := "dall-e"
+	task := "images/generations"
+	switch relayMode {
+	case RelayModeImagesEdits:
+		imageModel = "dall-e-2"
+		task = "images/edits"
+	case RelayModeImagesVariations:
+		imageModel = "dall-e-2"
+		task = "images/variations"
+	}
 
-	tokenId := c.GetInt("token_id")
 	channelType := c.GetInt("channel")
 	userId := c.GetInt("id")
 	consumeQuota := c.GetBool("consume_quota")
 	group := c.GetString("group")
+	if getAPIType(channelType) != APITypeOpenAI {
+		// the upstreams of their own formats have no image API, another channel of the model may have
+		return errorWrapper(fmt.Errorf("channel type %d does not support the image API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
 
 	var imageRequest ImageRequest
-	if consumeQuota {
+	var form *multipart.Form
+	if relayMode == RelayModeImagesGenerations {
 		err := common.UnmarshalBodyReusable(c, &imageRequest)
 		if err != nil {
 			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
 		}
+	} else {
+		var err error
+		form, err = common.ParseMultipartFormReusable(c)
+		if err != nil {
+			return errorWrapper(err, "parse_multipart_form_failed", http.StatusBadRequest)
+		}
+		imageRequest, err = getMultipartImageRequest(form)
+		if err != nil {
+			return errorWrapper(err, "invalid_field_value", http.StatusBadRequest)
+		}
 	}
 
-	// Prompt validation
-	if imageRequest.Prompt == "" {
+	// Prompt validation, the variations take none
+	if imageRequest.Prompt == "" && relayMode != RelayModeImagesVariations {
 		return errorWrapper(errors.New("prompt is required"), "required_field_missing", http.StatusBadRequest)
 	}
 
-	// Not "256x256", "512x512", or "1024x1024"
-	if imageRequest.Size != "" && imageRequest.Size != "256x256" && imageRequest.Size != "512x512" && imageRequest.Size != "1024x1024" {
+	if imageRequest.Model != "" {
+		imageModel = imageRequest.Model
+	}
+	if imageRequest.Size == "" {
+		imageRequest.Size = "1024x1024"
+	}
+	if imageRequest.N == 0 {
+		imageRequest.N = 1
+	}
+
+	// the sizes and the qualities of the priced models are checked against their price table, the others keep the sizes
+	// of DALL·E 2
+	if common.IsImageModelPriced(imageModel) {
+		if _, ok := common.GetImagePrice(imageModel, imageRequest.Size, imageRequest.Quality); !ok {
+			return errorWrapper(fmt.Errorf("size %s is not supported by %s", imageRequest.Size, imageModel), "invalid_field_value", http.StatusBadRequest)
+		}
+		if !common.IsImageQualitySupported(imageModel, imageRequest.Quality) {
+			return errorWrapper(fmt.Errorf("quality %s is not supported by %s", imageRequest.Quality, imageModel), "invalid_field_value", http.StatusBadRequest)
+		}
+	} else if imageRequest.Size != "256x256" && imageRequest.Size != "512x512" && imageRequest.Size != "1024x1024" {
 		return errorWrapper(errors.New("size must be one of 256x256, 512x512, or 1024x1024"), "invalid_field_value", http.StatusBadRequest)
 	}
 
 	// N should between 1 and 10
-	if imageRequest.N != 0 && (imageRequest.N < 1 || imageRequest.N > 10) {
+	if imageRequest.N < 1 || imageRequest.N > 10 {
 		return errorWrapper(errors.New("n must be between 1 and 10"), "invalid_field_value", http.StatusBadRequest)
 	}
+	if imageRequest.ResponseFormat != "" && imageRequest.ResponseFormat != "url" && imageRequest.ResponseFormat != "b64_json" {
+		return errorWrapper(errors.New("response_format must be one of url or b64_json"), "invalid_field_value", http.StatusBadRequest)
+	}
 
 	// map model name
 	modelMapping := c.GetString("model_mapping")
@@ -68,48 +144,83 @@ func relayImageHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode
 	}
 
 	fullRequestURL := fmt.Sprintf("%s%s", baseURL, requestURL)
+	if channelType == common.ChannelTypeAzure {
+		fullRequestURL = getAzureRequestURL(c, imageModel, task)
+	}
 
-	var requestBody io.Reader
+	var requestBody io.Reader = c.Request.Body
+	contentType := c.Request.Header.Get("Content-Type")
 	if isModelMapped {
-		jsonStr, err := json.Marshal(imageRequest)
+		// the other fields of the request are kept as they are, the form is encoded again rather than changing the
+		// request since the failover relays it again
+		var err error
+		if form != nil {
+			requestBody, contentType, err = buildMultipartForm(form, imageModel)
+		} else {
+			err = replaceRequestModel(c, imageModel)
+			requestBody = c.Request.Body
+		}
 		if err != nil {
 			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
 		}
-		requestBody = bytes.NewBuffer(jsonStr)
-	} else {
-		requestBody = c.Request.Body
 	}
 
-	modelRatio := common.GetModelRatio(imageModel)
 	groupRatio := common.GetGroupRatio(group)
-	ratio := modelRatio * groupRatio
-	userQuota, err := model.CacheGetUserQuota(userId)
+	userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
 
-	sizeRatio := 1.0
-	// Size
-	if imageRequest.Size == "256x256" {
-		sizeRatio = 1
-	} else if imageRequest.Size == "512x512" {
-		sizeRatio = 1.125
-	} else if imageRequest.Size == "1024x1024" {
-		sizeRatio = 1.25
+	// the price of the mapped model applies, as it is the one generating the images
+	var quota int
+	var spend int // the list price for the budgets of the channel, without the group ratio and the discounts
+	var logContent string
+	if imagePrice, ok := common.GetImagePrice(imageModel, imageRequest.Size, imageRequest.Quality); ok {
+		quota = int(imagePrice*common.QuotaPerUnit*groupRatio) * imageRequest.N
+		spend = int(imagePrice*common.QuotaPerUnit) * imageRequest.N
+		logContent = fmt.Sprintf("图片单价 $%.3f，尺寸 %s", imagePrice, imageRequest.Size)
+		if imageRequest.Quality != "" {
+			logContent += fmt.Sprintf("，质量 %s", imageRequest.Quality)
+		}
+		logContent += fmt.Sprintf("，数量 %d，分组倍率 %.2f", imageRequest.N, groupRatio)
+	} else {
+		modelRatio := common.GetModelRatio(imageModel)
+		sizeRatio := 1.0
+		// Size
+		if imageRequest.Size == "256x256" {
+			sizeRatio = 1
+		} else if imageRequest.Size == "512x512" {
+			sizeRatio = 1.125
+		} else if imageRequest.Size == "1024x1024" {
+			sizeRatio = 1.25
+		}
+		quota = int(modelRatio*groupRatio*sizeRatio*1000) * imageRequest.N
+		spend = int(modelRatio*sizeRatio*1000) * imageRequest.N
+		logContent = fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f，尺寸 %s，数量 %d", modelRatio, groupRatio, imageRequest.Size, imageRequest.N)
+	}
+	if quota != 0 {
+		var chargeLog string
+		quota, chargeLog = applyModelCharges(imageModel, quota, groupRatio)
+		logContent += chargeLog
 	}
-	quota := int(ratio*sizeRatio*1000) * imageRequest.N
 
 	if consumeQuota && userQuota-quota < 0 {
-		return errorWrapper(err, "insufficient_user_quota", http.StatusForbidden)
+		return quotaExhaustedErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
 	}
 
 	req, err := http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
 	if err != nil {
 		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
 	}
-	req.Header.Set("Authorization", c.Request.Header.Get("Authorization"))
+	if channelType == common.ChannelTypeAzure {
+		req.Header.Set("api-key", strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	} else {
+		setBearerAuth(req, strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	}
 
-	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	req.Header.Set("Content-Type", contentType)
 	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+	setChannelHeaders(req, c.GetString("channel_headers"))
+	setPolicyHeaders(c, req)
 
-	resp, err := httpClient.Do(req)
+	resp, err := getRelayHTTPClient(c).Do(req)
 	if err != nil {
 		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 	}
@@ -122,27 +233,29 @@ func relayImageHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode
 	if err != nil {
 		return errorWrapper(err, "close_request_body_failed", http.StatusInternalServerError)
 	}
+	// the failed requests are returned as errors and not charged, so that they fail over to the other channels
+	if resp.StatusCode != http.StatusOK {
+		return getOpenAIResponseError(resp)
+	}
 	var textResponse ImageResponse
 
 	defer func() {
+		model.RecordChannelSpend(c.GetInt("channel_id"), spend)
 		if consumeQuota {
-			err := model.PostConsumeTokenQuota(tokenId, quota)
-			if err != nil {
-				common.SysError("error consuming token remain quota: " + err.Error())
-			}
-			err = model.CacheUpdateUserQuota(userId)
-			if err != nil {
-				common.SysError("error update user quota cache: " + err.Error())
-			}
 			if quota != 0 {
-				tokenName := c.GetString("token_name")
-				logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
-				model.RecordConsumeLog(userId, 0, 0, imageModel, tokenName, quota, logContent)
-				model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
-				channelId := c.GetInt("channel_id")
-				model.UpdateChannelUsedQuota(channelId, quota)
+				var couponLog string
+				quota, couponLog = applyUserCoupon(userId, imageModel, quota)
+				logContent += couponLog
 			}
+			getQuotaSettlement(c).settle(imageModel, 0, 0, quota, logContent)
+		}
+		billedQuota := 0
+		if consumeQuota {
+			billedQuota = quota
 		}
+		// the images are at their list price upstream, the ones priced with a model ratio are not estimated
+		_, priced := common.GetImagePrice(imageModel, imageRequest.Size, imageRequest.Quality)
+		model.RecordChannelUsage(c.GetInt("channel_id"), imageModel, 0, 0, billedQuota, float64(spend)/common.QuotaPerUnit, priced)
 	}()
 
 	if consumeQuota {
@@ -163,9 +276,7 @@ func relayImageHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode
 		resp.Body = io.NopCloser(bytes.NewBuffer(responseBody))
 	}
 
-	for k, v := range resp.Header {
-		c.Writer.Header().Set(k, v[0])
-	}
+	copyResponseHeaders(c, resp)
 	c.Writer.WriteHeader(resp.StatusCode)
 
 	_, err = io.Copy(c.Writer, resp.Body)
@@ -0,0 +1,570 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the Anthropic Messages format accepted from the clients, it is translated to the OpenAI format
+// so that it goes through the same routing and billing, see https://docs.anthropic.com/en/api/messages
+
+// AnthropicImageSource is the image of an image block, by its data or by its URL
+type AnthropicImageSource struct {
+	Type      string `json:"type"` // base64 or url
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+	Url       string `json:"url"`
+}
+
+// AnthropicRequestBlock is a content block of a request, the tool results hold content blocks of their own
+type AnthropicRequestBlock struct {
+	Type      string                `json:"type"`
+	Text      string                `json:"text"`
+	Source    *AnthropicImageSource `json:"source"`
+	Id        string                `json:"id"`
+	Name      string                `json:"name"`
+	Input     any                   `json:"input"`
+	ToolUseId string                `json:"tool_use_id"`
+	Content   any                   `json:"content"`
+	IsError   bool                  `json:"is_error"`
+}
+
+type AnthropicContentBlock struct {
+	Type  string `json:"type"`
+	Text  string `json:"text,omitempty"`
+	Id    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Input any    `json:"input,omitempty"`
+}
+
+type AnthropicMessage struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"` // a string or a list of content blocks
+}
+
+type AnthropicTool struct {
+	Type        string `json:"type,omitempty"` // empty or custom, the other types are the server tools of Anthropic
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	InputSchema any    `json:"input_schema"`
+}
+
+type AnthropicToolChoice struct {
+	Type                   string `json:"type"` // auto, any, tool or none
+	Name                   string `json:"name,omitempty"`
+	DisableParallelToolUse bool   `json:"disable_parallel_tool_use,omitempty"`
+}
+
+type AnthropicMessageRequest struct {
+	Model         string               `json:"model"`
+	Messages      []AnthropicMessage   `json:"messages"`
+	System        any                  `json:"system,omitempty"`
+	MaxTokens     int                  `json:"max_tokens"`
+	Temperature   *float64             `json:"temperature,omitempty"`
+	TopP          *float64             `json:"top_p,omitempty"`
+	StopSequences []string             `json:"stop_sequences,omitempty"`
+	Stream        bool                 `json:"stream,omitempty"`
+	Tools         []AnthropicTool      `json:"tools,omitempty"`
+	ToolChoice    *AnthropicToolChoice `json:"tool_choice,omitempty"`
+	Metadata      *struct {
+		UserId string `json:"user_id,omitempty"`
+	} `json:"metadata,omitempty"`
+}
+
+type AnthropicUsage struct {
+	InputTokens          int `json:"input_tokens"`
+	OutputTokens         int `json:"output_tokens"`
+	CacheReadInputTokens int `json:"cache_read_input_tokens,omitempty"`
+}
+
+type AnthropicMessageResponse struct {
+	Id           string                  `json:"id"`
+	Type         string                  `json:"type"`
+	Role         string                  `json:"role"`
+	Model        string                  `json:"model"`
+	Content      []AnthropicContentBlock `json:"content"`
+	StopReason   string                  `json:"stop_reason"`
+	StopSequence *string                 `json:"stop_sequence"`
+	Usage        AnthropicUsage          `json:"usage"`
+}
+
+func getAnthropicBlocks(content any) ([]AnthropicRequestBlock, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []AnthropicRequestBlock{{Type: "text", Text: content}}, nil
+	case []any:
+		jsonData, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+		var blocks []AnthropicRequestBlock
+		err = json.Unmarshal(jsonData, &blocks)
+		if err != nil {
+			return nil, errors.New("invalid content block")
+		}
+		return blocks, nil
+	}
+	return nil, errors.New("content must be a string or a list of content blocks")
+}
+
+// getAnthropicText joins the text blocks of the system prompt
+func getAnthropicText(content any) (string, error) {
+	blocks, err := getAnthropicBlocks(content)
+	if err != nil {
+		return "", err
+	}
+	var texts []string
+	for _, block := range blocks {
+		if block.Type != "text" {
+			return "", fmt.Errorf("content block of type %s is not supported", block.Type)
+		}
+		texts = append(texts, block.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+func getAnthropicImagePart(source *AnthropicImageSource) (map[string]any, error) {
+	if source == nil {
+		return nil, errors.New("image block without source")
+	}
+	url := source.Url
+	switch source.Type {
+	case "base64":
+		url = fmt.Sprintf("data:%s;base64,%s", source.MediaType, source.Data)
+	case "url":
+	default:
+		return nil, fmt.Errorf("image source of type %s is not supported", source.Type)
+	}
+	return map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}}, nil
+}
+
+// getAnthropicToolResult is the text of a tool result, its images are returned apart since the tool messages of
+// OpenAI hold text only
+func getAnthropicToolResult(block AnthropicRequestBlock) (string, []any, error) {
+	blocks, err := getAnthropicBlocks(block.Content)
+	if err != nil {
+		return "", nil, err
+	}
+	var texts []string
+	var images []any
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			texts = append(texts, block.Text)
+		case "image":
+			image, err := getAnthropicImagePart(block.Source)
+			if err != nil {
+				return "", nil, err
+			}
+			images = append(images, image)
+		default:
+			return "", nil, fmt.Errorf("tool result block of type %s is not supported", block.Type)
+		}
+	}
+	text := strings.Join(texts, "\n")
+	if block.IsError && text == "" {
+		text = "error"
+	}
+	return text, images, nil
+}
+
+// messageAnthropic2OpenAI translates the tool_use blocks to the tool calls of an assistant message, and the
+// tool_result blocks to the tool messages, which go before the rest of the user message since they must follow the
+// tool calls, the thinking blocks the clients send back along with the history are dropped
+func messageAnthropic2OpenAI(message AnthropicMessage) ([]ChatMessage, error) {
+	if message.Role != "user" && message.Role != "assistant" {
+		return nil, fmt.Errorf("invalid message role %s", message.Role)
+	}
+	blocks, err := getAnthropicBlocks(message.Content)
+	if err != nil {
+		return nil, err
+	}
+	var messages []ChatMessage
+	var parts []any
+	var toolCalls []OpenAIToolCall
+	for _, block := range blocks {
+		switch block.Type {
+		case "text":
+			parts = append(parts, map[string]any{"type": "text", "text": block.Text})
+		case "image":
+			image, err := getAnthropicImagePart(block.Source)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, image)
+		case "tool_use":
+			if message.Role != "assistant" {
+				return nil, errors.New("tool_use blocks must be in assistant messages")
+			}
+			arguments, err := json.Marshal(block.Input)
+			if err != nil {
+				return nil, err
+			}
+			if block.Input == nil {
+				arguments = []byte("{}")
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				Id:       block.Id,
+				Type:     "function",
+				Function: OpenAIFunctionCall{Name: block.Name, Arguments: string(arguments)},
+			})
+		case "tool_result":
+			if message.Role != "user" {
+				return nil, errors.New("tool_result blocks must be in user messages")
+			}
+			text, images, err := getAnthropicToolResult(block)
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, ChatMessage{Role: "tool", Content: text, ToolCallId: block.ToolUseId})
+			parts = append(parts, images...)
+		case "thinking", "redacted_thinking":
+		default:
+			return nil, fmt.Errorf("content block of type %s is not supported", block.Type)
+		}
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+	return append(messages, ChatMessage{Role: message.Role, Content: getIngressContent(parts), ToolCalls: toolCalls}), nil
+}
+
+func toolsAnthropic2OpenAI(tools []AnthropicTool) ([]OpenAITool, error) {
+	openaiTools := make([]OpenAITool, 0, len(tools))
+	for _, tool := range tools {
+		if tool.Type != "" && tool.Type != "custom" {
+			return nil, fmt.Errorf("tool of type %s is not supported", tool.Type)
+		}
+		openaiTool := OpenAITool{Type: "function"}
+		openaiTool.Function.Name = tool.Name
+		openaiTool.Function.Description = tool.Description
+		openaiTool.Function.Parameters = tool.InputSchema
+		openaiTools = append(openaiTools, openaiTool)
+	}
+	return openaiTools, nil
+}
+
+func toolChoiceAnthropic2OpenAI(toolChoice *AnthropicToolChoice) any {
+	switch toolChoice.Type {
+	case "any":
+		return "required"
+	case "tool":
+		return map[string]any{"type": "function", "function": map[string]any{"name": toolChoice.Name}}
+	case "none":
+		return "none"
+	}
+	return "auto"
+}
+
+func requestAnthropic2OpenAI(request AnthropicMessageRequest) (map[string]any, error) {
+	messages := make([]ChatMessage, 0, len(request.Messages)+1)
+	system, err := getAnthropicText(request.System)
+	if err != nil {
+		return nil, err
+	}
+	if system != "" {
+		messages = append(messages, ChatMessage{Role: "system", Content: system})
+	}
+	for _, message := range request.Messages {
+		openaiMessages, err := messageAnthropic2OpenAI(message)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, openaiMessages...)
+	}
+	openaiRequest := map[string]any{
+		"model":    request.Model,
+		"messages": messages,
+	}
+	if request.MaxTokens > 0 {
+		openaiRequest["max_tokens"] = request.MaxTokens
+	}
+	if request.Stream {
+		openaiRequest["stream"] = true
+	}
+	if request.Temperature != nil {
+		openaiRequest["temperature"] = *request.Temperature
+	}
+	if request.TopP != nil {
+		openaiRequest["top_p"] = *request.TopP
+	}
+	if len(request.StopSequences) > 0 {
+		openaiRequest["stop"] = request.StopSequences
+	}
+	if request.Metadata != nil && request.Metadata.UserId != "" {
+		openaiRequest["user"] = request.Metadata.UserId
+	}
+	if len(request.Tools) > 0 {
+		tools, err := toolsAnthropic2OpenAI(request.Tools)
+		if err != nil {
+			return nil, err
+		}
+		openaiRequest["tools"] = tools
+		if request.ToolChoice != nil {
+			openaiRequest["tool_choice"] = toolChoiceAnthropic2OpenAI(request.ToolChoice)
+			if request.ToolChoice.DisableParallelToolUse {
+				openaiRequest["parallel_tool_calls"] = false
+			}
+		}
+	}
+	return openaiRequest, nil
+}
+
+func stopReasonOpenAI2Anthropic(reason string) string {
+	switch reason {
+	case "length":
+		return "max_tokens"
+	case "tool_calls", "function_call":
+		return "tool_use"
+	case "content_filter":
+		return "refusal"
+	}
+	return "end_turn"
+}
+
+func usageOpenAI2Anthropic(usage Usage) AnthropicUsage {
+	anthropicUsage := AnthropicUsage{
+		InputTokens:  usage.PromptTokens,
+		OutputTokens: usage.CompletionTokens,
+	}
+	// the input tokens of Anthropic don't include the cached ones
+	if usage.PromptTokensDetails != nil && usage.PromptTokensDetails.CachedTokens > 0 {
+		anthropicUsage.CacheReadInputTokens = usage.PromptTokensDetails.CachedTokens
+		anthropicUsage.InputTokens -= usage.PromptTokensDetails.CachedTokens
+	}
+	return anthropicUsage
+}
+
+func getAnthropicErrorType(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "invalid_request_error"
+	case http.StatusUnauthorized:
+		return "authentication_error"
+	case http.StatusForbidden:
+		return "permission_error"
+	case http.StatusNotFound:
+		return "not_found_error"
+	case http.StatusRequestEntityTooLarge:
+		return "request_too_large"
+	case http.StatusTooManyRequests:
+		return "rate_limit_error"
+	case http.StatusServiceUnavailable, 529:
+		return "overloaded_error"
+	}
+	return "api_error"
+}
+
+// getAnthropicToolUse is the tool_use block of a tool call, the upstreams which give no ids get ones of their own
+func getAnthropicToolUse(toolCall OpenAIToolCall) AnthropicContentBlock {
+	id := toolCall.Id
+	if id == "" {
+		id = "toolu_" + strings.ReplaceAll(common.GetUUID(), "-", "")
+	}
+	input := map[string]any{}
+	if toolCall.Function.Arguments != "" {
+		_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &input)
+	}
+	return AnthropicContentBlock{Type: "tool_use", Id: id, Name: toolCall.Function.Name, Input: input}
+}
+
+type anthropicTranslator struct {
+	id          string
+	model       string
+	countTokens bool // the request is to /v1/messages/count_tokens
+	started     bool
+	blocks      int    // the number of the content blocks started in the stream
+	blockType   string // the type of the open content block, empty when none is open
+	toolBlocks  map[int]int
+	stopReason  string
+}
+
+func (t *anthropicTranslator) request(c *gin.Context) *OpenAIErrorWithStatusCode {
+	if c.Request.Header.Get("Authorization") == "" {
+		// the clients of the Anthropic Messages API send the key in x-api-key
+		c.Request.Header.Set("Authorization", "Bearer "+c.Request.Header.Get("x-api-key"))
+	}
+	t.countTokens = strings.HasSuffix(c.Request.URL.Path, "/count_tokens")
+	var request AnthropicMessageRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if request.MaxTokens <= 0 && !t.countTokens {
+		return errorWrapper(errors.New("max_tokens is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	if t.countTokens {
+		request.Stream = false
+	}
+	openaiRequest, err := requestAnthropic2OpenAI(request)
+	if err != nil {
+		return errorWrapper(err, "invalid_request", http.StatusBadRequest)
+	}
+	t.model = request.Model
+	return setIngressRequest(c, openaiRequest)
+}
+
+func (t *anthropicTranslator) writeEvent(w io.Writer, event string, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		common.SysError("error marshalling anthropic event: " + err.Error())
+		return
+	}
+	_, _ = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, jsonData)
+}
+
+func (t *anthropicTranslator) streamHeader(header http.Header) {}
+
+// startBlock stops the open content block and starts the next one, the blocks of a stream go one after another
+func (t *anthropicTranslator) startBlock(w io.Writer, blockType string, block gin.H) {
+	t.stopBlock(w)
+	t.writeEvent(w, "content_block_start", gin.H{
+		"type":          "content_block_start",
+		"index":         t.blocks,
+		"content_block": block,
+	})
+	t.blocks++
+	t.blockType = blockType
+}
+
+func (t *anthropicTranslator) stopBlock(w io.Writer) {
+	if t.blockType == "" {
+		return
+	}
+	t.writeEvent(w, "content_block_stop", gin.H{"type": "content_block_stop", "index": t.blocks - 1})
+	t.blockType = ""
+}
+
+func (t *anthropicTranslator) streamChunk(w io.Writer, chunk *ChatCompletionsStreamResponse) {
+	if !t.started {
+		t.started = true
+		t.toolBlocks = make(map[int]int)
+		t.writeEvent(w, "message_start", gin.H{
+			"type": "message_start",
+			"message": gin.H{
+				"id":            t.id,
+				"type":          "message",
+				"role":          "assistant",
+				"model":         t.model,
+				"content":       []AnthropicContentBlock{},
+				"stop_reason":   nil,
+				"stop_sequence": nil,
+				"usage":         AnthropicUsage{},
+			},
+		})
+	}
+	for _, choice := range chunk.Choices {
+		if choice.Index != 0 {
+			continue
+		}
+		if choice.Delta.Content != "" {
+			if t.blockType != "text" {
+				t.startBlock(w, "text", gin.H{"type": "text", "text": ""})
+			}
+			t.writeEvent(w, "content_block_delta", gin.H{
+				"type":  "content_block_delta",
+				"index": t.blocks - 1,
+				"delta": gin.H{"type": "text_delta", "text": choice.Delta.Content},
+			})
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			toolIndex := 0
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			blockIndex, ok := t.toolBlocks[toolIndex]
+			if !ok {
+				toolUse := getAnthropicToolUse(OpenAIToolCall{Id: toolCall.Id, Function: OpenAIFunctionCall{Name: toolCall.Function.Name}})
+				t.startBlock(w, "tool_use", gin.H{"type": "tool_use", "id": toolUse.Id, "name": toolUse.Name, "input": gin.H{}})
+				blockIndex = t.blocks - 1
+				t.toolBlocks[toolIndex] = blockIndex
+			}
+			if toolCall.Function.Arguments != "" {
+				t.writeEvent(w, "content_block_delta", gin.H{
+					"type":  "content_block_delta",
+					"index": blockIndex,
+					"delta": gin.H{"type": "input_json_delta", "partial_json": toolCall.Function.Arguments},
+				})
+			}
+		}
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			t.stopReason = *choice.FinishReason
+		}
+	}
+}
+
+func (t *anthropicTranslator) streamPing(w io.Writer) {
+	t.writeEvent(w, "ping", gin.H{"type": "ping"})
+}
+
+func (t *anthropicTranslator) streamEnd(w io.Writer, usage *Usage) {
+	if !t.started {
+		return
+	}
+	anthropicUsage := AnthropicUsage{}
+	if usage != nil {
+		anthropicUsage = usageOpenAI2Anthropic(*usage)
+	}
+	t.stopBlock(w)
+	stopReason := stopReasonOpenAI2Anthropic(t.stopReason)
+	if len(t.toolBlocks) > 0 && stopReason == "end_turn" {
+		stopReason = "tool_use"
+	}
+	t.writeEvent(w, "message_delta", gin.H{
+		"type":  "message_delta",
+		"delta": gin.H{"stop_reason": stopReason, "stop_sequence": nil},
+		"usage": anthropicUsage,
+	})
+	t.writeEvent(w, "message_stop", gin.H{"type": "message_stop"})
+}
+
+func (t *anthropicTranslator) response(textResponse *OpenAITextResponse, usage *Usage) any {
+	if t.countTokens {
+		return gin.H{"input_tokens": usage.PromptTokens}
+	}
+	response := AnthropicMessageResponse{
+		Id:      t.id,
+		Type:    "message",
+		Role:    "assistant",
+		Model:   t.model,
+		Content: []AnthropicContentBlock{},
+		Usage:   usageOpenAI2Anthropic(*usage),
+	}
+	if len(textResponse.Choices) > 0 {
+		choice := textResponse.Choices[0]
+		if text := choice.Message.StringContent(); text != "" {
+			response.Content = append(response.Content, AnthropicContentBlock{Type: "text", Text: text})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			response.Content = append(response.Content, getAnthropicToolUse(toolCall))
+		}
+		response.StopReason = stopReasonOpenAI2Anthropic(choice.FinishReason)
+		if len(choice.Message.ToolCalls) > 0 && response.StopReason == "end_turn" {
+			response.StopReason = "tool_use"
+		}
+	}
+	return response
+}
+
+func (t *anthropicTranslator) error(statusCode int, message string) any {
+	return gin.H{
+		"type":  "error",
+		"error": gin.H{"type": getAnthropicErrorType(statusCode), "message": message},
+	}
+}
+
+// AnthropicCompatible serves the Anthropic Messages API, it goes before the other middlewares so that their errors are translated as well
+func AnthropicCompatible() func(c *gin.Context) {
+	return ingressCompatible(func() ingressTranslator {
+		return &anthropicTranslator{id: "msg_" + strings.ReplaceAll(common.GetUUID(), "-", "")}
+	})
+}
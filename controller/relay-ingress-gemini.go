@@ -0,0 +1,603 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the Google Gemini generateContent format accepted from the clients, it is translated to the OpenAI format
+// so that it goes through the same routing and billing, see https://ai.google.dev/api/generate-content
+
+type GeminiFunctionCall struct {
+	Id   string `json:"id,omitempty"`
+	Name string `json:"name"`
+	Args any    `json:"args,omitempty"`
+}
+
+type GeminiPart struct {
+	Text         string              `json:"text,omitempty"`
+	Thought      bool                `json:"thought,omitempty"`
+	FunctionCall *GeminiFunctionCall `json:"functionCall,omitempty"`
+}
+
+type GeminiContent struct {
+	Role  string           `json:"role,omitempty"`
+	Parts []map[string]any `json:"parts"`
+}
+
+type GeminiGenerationConfig struct {
+	Temperature        *float64 `json:"temperature,omitempty"`
+	TopP               *float64 `json:"topP,omitempty"`
+	MaxOutputTokens    int      `json:"maxOutputTokens,omitempty"`
+	StopSequences      []string `json:"stopSequences,omitempty"`
+	CandidateCount     int      `json:"candidateCount,omitempty"`
+	ResponseMimeType   string   `json:"responseMimeType,omitempty"`
+	ResponseJsonSchema any      `json:"responseJsonSchema,omitempty"`
+}
+
+type GeminiFunctionDeclaration struct {
+	Name                 string `json:"name"`
+	Description          string `json:"description"`
+	Parameters           any    `json:"parameters"`
+	ParametersJsonSchema any    `json:"parametersJsonSchema"`
+}
+
+type GeminiFunctionCallingConfig struct {
+	Mode                 string   `json:"mode"` // AUTO, ANY or NONE
+	AllowedFunctionNames []string `json:"allowedFunctionNames,omitempty"`
+}
+
+type GeminiToolConfig struct {
+	FunctionCallingConfig *GeminiFunctionCallingConfig `json:"functionCallingConfig,omitempty"`
+}
+
+type GeminiGenerateContentRequest struct {
+	Contents          []GeminiContent         `json:"contents"`
+	SystemInstruction *GeminiContent          `json:"systemInstruction,omitempty"`
+	GenerationConfig  *GeminiGenerationConfig `json:"generationConfig,omitempty"`
+	Tools             []any                   `json:"tools,omitempty"`
+	ToolConfig        *GeminiToolConfig       `json:"toolConfig,omitempty"`
+	SafetySettings    []any                   `json:"safetySettings,omitempty"`
+}
+
+type GeminiResponseContent struct {
+	Role  string       `json:"role"`
+	Parts []GeminiPart `json:"parts"`
+}
+
+type GeminiCandidate struct {
+	Content      GeminiResponseContent `json:"content"`
+	FinishReason string                `json:"finishReason,omitempty"`
+	Index        int                   `json:"index"`
+}
+
+type GeminiUsageMetadata struct {
+	PromptTokenCount        int `json:"promptTokenCount"`
+	CandidatesTokenCount    int `json:"candidatesTokenCount"`
+	TotalTokenCount         int `json:"totalTokenCount"`
+	CachedContentTokenCount int `json:"cachedContentTokenCount,omitempty"`
+	ThoughtsTokenCount      int `json:"thoughtsTokenCount,omitempty"`
+}
+
+type GeminiGenerateContentResponse struct {
+	Candidates     []GeminiCandidate    `json:"candidates"`
+	UsageMetadata  *GeminiUsageMetadata `json:"usageMetadata,omitempty"`
+	ModelVersion   string               `json:"modelVersion"`
+	PromptFeedback *struct {
+		BlockReason string `json:"blockReason"`
+	} `json:"promptFeedback,omitempty"`
+}
+
+// getGeminiText joins the text parts of the system instruction
+func getGeminiText(content GeminiContent) (string, error) {
+	texts := make([]string, 0, len(content.Parts))
+	for _, part := range content.Parts {
+		text, ok := part["text"].(string)
+		if !ok {
+			return "", errors.New("the system instruction must be text")
+		}
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// getGeminiMedia is the image part of an inline image or of a file, the other media can't be expressed in the OpenAI format
+func getGeminiMedia(data map[string]any, inline bool) (map[string]any, error) {
+	mimeType, _ := data["mimeType"].(string)
+	if !strings.HasPrefix(mimeType, "image/") {
+		return nil, fmt.Errorf("media of type %s is not supported", mimeType)
+	}
+	url, _ := data["fileUri"].(string)
+	if inline {
+		base64Data, _ := data["data"].(string)
+		url = fmt.Sprintf("data:%s;base64,%s", mimeType, base64Data)
+	}
+	return map[string]any{"type": "image_url", "image_url": map[string]any{"url": url}}, nil
+}
+
+// contentGemini2OpenAI translates the function calls of the model to tool calls and the function responses to tool
+// messages, the function calls which have no ids get ones of their own, and the responses are matched to the calls
+// by the names in order, calls holds the ids of the calls which are yet to be answered
+func contentGemini2OpenAI(content GeminiContent, calls map[string][]string) ([]ChatMessage, error) {
+	role := "user"
+	switch content.Role {
+	case "", "user", "function":
+	case "model":
+		role = "assistant"
+	default:
+		return nil, fmt.Errorf("invalid content role %s", content.Role)
+	}
+	var messages []ChatMessage
+	var parts []any
+	var toolCalls []OpenAIToolCall
+	for _, part := range content.Parts {
+		// the thoughts of the model which the clients send back along with the history
+		if thought, _ := part["thought"].(bool); thought {
+			continue
+		}
+		if text, ok := part["text"].(string); ok {
+			parts = append(parts, map[string]any{"type": "text", "text": text})
+		} else if data, ok := part["inlineData"].(map[string]any); ok {
+			image, err := getGeminiMedia(data, true)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, image)
+		} else if data, ok := part["fileData"].(map[string]any); ok {
+			image, err := getGeminiMedia(data, false)
+			if err != nil {
+				return nil, err
+			}
+			parts = append(parts, image)
+		} else if call, ok := part["functionCall"].(map[string]any); ok {
+			if role != "assistant" {
+				return nil, errors.New("function calls must be in the contents of the model")
+			}
+			name, _ := call["name"].(string)
+			id, _ := call["id"].(string)
+			if id == "" {
+				id = "call_" + strings.ReplaceAll(common.GetUUID(), "-", "")
+			}
+			calls[name] = append(calls[name], id)
+			args := call["args"]
+			if args == nil {
+				args = map[string]any{}
+			}
+			arguments, err := json.Marshal(args)
+			if err != nil {
+				return nil, err
+			}
+			toolCalls = append(toolCalls, OpenAIToolCall{
+				Id:       id,
+				Type:     "function",
+				Function: OpenAIFunctionCall{Name: name, Arguments: string(arguments)},
+			})
+		} else if response, ok := part["functionResponse"].(map[string]any); ok {
+			if role != "user" {
+				return nil, errors.New("function responses must be in the contents of the user")
+			}
+			name, _ := response["name"].(string)
+			id, _ := response["id"].(string)
+			if id == "" && len(calls[name]) > 0 {
+				id = calls[name][0]
+				calls[name] = calls[name][1:]
+			}
+			result, err := json.Marshal(response["response"])
+			if err != nil {
+				return nil, err
+			}
+			messages = append(messages, ChatMessage{Role: "tool", Content: string(result), ToolCallId: id})
+		} else {
+			for key := range part {
+				if key != "thought" && key != "thoughtSignature" {
+					return nil, fmt.Errorf("part of type %s is not supported", key)
+				}
+			}
+		}
+	}
+	if len(parts) == 0 && len(toolCalls) == 0 {
+		return messages, nil
+	}
+	return append(messages, ChatMessage{Role: role, Content: getIngressContent(parts), ToolCalls: toolCalls}), nil
+}
+
+// normalizeGeminiSchema lowercases the types of the OpenAPI schemas of Gemini, such as OBJECT, for the JSON schemas
+func normalizeGeminiSchema(schema any) any {
+	switch schema := schema.(type) {
+	case map[string]any:
+		normalized := make(map[string]any, len(schema))
+		for key, value := range schema {
+			if typeName, ok := value.(string); ok && key == "type" {
+				normalized[key] = strings.ToLower(typeName)
+				continue
+			}
+			normalized[key] = normalizeGeminiSchema(value)
+		}
+		return normalized
+	case []any:
+		normalized := make([]any, 0, len(schema))
+		for _, value := range schema {
+			normalized = append(normalized, normalizeGeminiSchema(value))
+		}
+		return normalized
+	}
+	return schema
+}
+
+// toolsGemini2OpenAI translates the function declarations, the other tools such as googleSearch are of Google only
+func toolsGemini2OpenAI(tools []any) ([]OpenAITool, error) {
+	var openaiTools []OpenAITool
+	for _, item := range tools {
+		tool, ok := item.(map[string]any)
+		if !ok {
+			return nil, errors.New("invalid tool")
+		}
+		for key := range tool {
+			if key != "functionDeclarations" {
+				return nil, fmt.Errorf("tool %s is not supported", key)
+			}
+		}
+		jsonData, err := json.Marshal(tool["functionDeclarations"])
+		if err != nil {
+			return nil, err
+		}
+		var declarations []GeminiFunctionDeclaration
+		err = json.Unmarshal(jsonData, &declarations)
+		if err != nil {
+			return nil, errors.New("invalid function declarations")
+		}
+		for _, declaration := range declarations {
+			openaiTool := OpenAITool{Type: "function"}
+			openaiTool.Function.Name = declaration.Name
+			openaiTool.Function.Description = declaration.Description
+			openaiTool.Function.Parameters = declaration.ParametersJsonSchema
+			if declaration.Parameters != nil {
+				openaiTool.Function.Parameters = normalizeGeminiSchema(declaration.Parameters)
+			}
+			openaiTools = append(openaiTools, openaiTool)
+		}
+	}
+	return openaiTools, nil
+}
+
+func toolConfigGemini2OpenAI(toolConfig *GeminiToolConfig) any {
+	if toolConfig == nil || toolConfig.FunctionCallingConfig == nil {
+		return nil
+	}
+	config := toolConfig.FunctionCallingConfig
+	switch config.Mode {
+	case "ANY":
+		if len(config.AllowedFunctionNames) == 1 {
+			return map[string]any{"type": "function", "function": map[string]any{"name": config.AllowedFunctionNames[0]}}
+		}
+		return "required"
+	case "NONE":
+		return "none"
+	case "AUTO":
+		return "auto"
+	}
+	return nil
+}
+
+func requestGemini2OpenAI(request GeminiGenerateContentRequest, modelName string, stream bool) (map[string]any, error) {
+	if len(request.Contents) == 0 {
+		return nil, errors.New("contents is required")
+	}
+	messages := make([]ChatMessage, 0, len(request.Contents)+1)
+	if request.SystemInstruction != nil {
+		system, err := getGeminiText(*request.SystemInstruction)
+		if err != nil {
+			return nil, err
+		}
+		if system != "" {
+			messages = append(messages, ChatMessage{Role: "system", Content: system})
+		}
+	}
+	calls := make(map[string][]string)
+	for _, content := range request.Contents {
+		contentMessages, err := contentGemini2OpenAI(content, calls)
+		if err != nil {
+			return nil, err
+		}
+		messages = append(messages, contentMessages...)
+	}
+	openaiRequest := map[string]any{
+		"model":    modelName,
+		"messages": messages,
+	}
+	if stream {
+		openaiRequest["stream"] = true
+	}
+	if config := request.GenerationConfig; config != nil {
+		if config.Temperature != nil {
+			openaiRequest["temperature"] = *config.Temperature
+		}
+		if config.TopP != nil {
+			openaiRequest["top_p"] = *config.TopP
+		}
+		if config.MaxOutputTokens > 0 {
+			openaiRequest["max_tokens"] = config.MaxOutputTokens
+		}
+		if len(config.StopSequences) > 0 {
+			openaiRequest["stop"] = config.StopSequences
+		}
+		if config.CandidateCount > 1 {
+			openaiRequest["n"] = config.CandidateCount
+		}
+		if config.ResponseMimeType == "application/json" {
+			openaiRequest["response_format"] = gin.H{"type": "json_object"}
+			if config.ResponseJsonSchema != nil {
+				openaiRequest["response_format"] = gin.H{"type": "json_schema", "json_schema": gin.H{"name": "response", "schema": config.ResponseJsonSchema}}
+			}
+		}
+	}
+	if len(request.Tools) > 0 {
+		tools, err := toolsGemini2OpenAI(request.Tools)
+		if err != nil {
+			return nil, err
+		}
+		if len(tools) > 0 {
+			openaiRequest["tools"] = tools
+			if toolChoice := toolConfigGemini2OpenAI(request.ToolConfig); toolChoice != nil {
+				openaiRequest["tool_choice"] = toolChoice
+			}
+		}
+	}
+	return openaiRequest, nil
+}
+
+func finishReasonOpenAI2Gemini(reason string) string {
+	switch reason {
+	case "length":
+		return "MAX_TOKENS"
+	case "content_filter":
+		return "SAFETY"
+	}
+	return "STOP"
+}
+
+func usageOpenAI2Gemini(usage Usage) *GeminiUsageMetadata {
+	metadata := &GeminiUsageMetadata{
+		PromptTokenCount:     usage.PromptTokens,
+		CandidatesTokenCount: usage.CompletionTokens,
+		TotalTokenCount:      usage.PromptTokens + usage.CompletionTokens,
+	}
+	if usage.PromptTokensDetails != nil {
+		metadata.CachedContentTokenCount = usage.PromptTokensDetails.CachedTokens
+	}
+	return metadata
+}
+
+func getGeminiErrorStatus(statusCode int) string {
+	switch statusCode {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED"
+	case http.StatusNotFound:
+		return "NOT_FOUND"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED"
+	case http.StatusServiceUnavailable:
+		return "UNAVAILABLE"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED"
+	}
+	return "INTERNAL"
+}
+
+// geminiTranslator streams server-sent events if the client asks for alt=sse, otherwise a JSON array like the Gemini API
+type geminiTranslator struct {
+	model         string
+	sse           bool
+	countTokens   bool // the request is to {model}:countTokens
+	started       bool
+	finishReasons map[int]string
+	toolCalls     map[int][]OpenAIToolCall // the tool calls of the candidates, sent whole in the last chunk
+}
+
+// getGeminiFunctionCallPart is the part of a tool call, the arguments which are not a JSON object are dropped
+func getGeminiFunctionCallPart(toolCall OpenAIToolCall) GeminiPart {
+	args := map[string]any{}
+	if toolCall.Function.Arguments != "" {
+		_ = json.Unmarshal([]byte(toolCall.Function.Arguments), &args)
+	}
+	return GeminiPart{FunctionCall: &GeminiFunctionCall{Id: toolCall.Id, Name: toolCall.Function.Name, Args: args}}
+}
+
+func (t *geminiTranslator) request(c *gin.Context) *OpenAIErrorWithStatusCode {
+	// the model and the method are in the same segment, such as gemini-pro:generateContent
+	modelName, method, found := strings.Cut(c.Param("action"), ":")
+	if !found || modelName == "" {
+		return errorWrapper(errors.New("invalid path, expected /v1beta/models/{model}:generateContent"), "invalid_request", http.StatusNotFound)
+	}
+	stream := false
+	switch method {
+	case "generateContent":
+	case "streamGenerateContent":
+		stream = true
+		t.sse = c.Query("alt") == "sse"
+	case "countTokens":
+		t.countTokens = true
+	default:
+		return errorWrapper(fmt.Errorf("method %s is not supported", method), "api_not_implemented", http.StatusNotFound)
+	}
+	// the clients of the Gemini API send the key in x-goog-api-key or in the query
+	if c.Request.Header.Get("Authorization") == "" {
+		key := c.Request.Header.Get("x-goog-api-key")
+		if key == "" {
+			key = c.Query("key")
+		}
+		c.Request.Header.Set("Authorization", "Bearer "+key)
+	}
+	// the requests to countTokens may wrap a whole generateContent request
+	var request struct {
+		GeminiGenerateContentRequest
+		GenerateContentRequest *GeminiGenerateContentRequest `json:"generateContentRequest"`
+	}
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if request.GenerateContentRequest != nil {
+		request.GeminiGenerateContentRequest = *request.GenerateContentRequest
+	}
+	openaiRequest, err := requestGemini2OpenAI(request.GeminiGenerateContentRequest, modelName, stream)
+	if err != nil {
+		return errorWrapper(err, "invalid_request", http.StatusBadRequest)
+	}
+	t.model = modelName
+	return setIngressRequest(c, openaiRequest)
+}
+
+func (t *geminiTranslator) writeChunk(w io.Writer, response GeminiGenerateContentResponse) {
+	jsonData, err := json.Marshal(response)
+	if err != nil {
+		common.SysError("error marshalling gemini chunk: " + err.Error())
+		return
+	}
+	if t.sse {
+		_, _ = fmt.Fprintf(w, "data: %s\r\n\r\n", jsonData)
+		return
+	}
+	separator := ",\r\n"
+	if !t.started {
+		separator = "["
+	}
+	t.started = true
+	_, _ = fmt.Fprintf(w, "%s%s", separator, jsonData)
+}
+
+func (t *geminiTranslator) streamHeader(header http.Header) {
+	if !t.sse {
+		header.Set("Content-Type", "application/json")
+	}
+}
+
+// streamChunk holds the finish reasons back, so that they are sent with the usage in the last chunk
+func (t *geminiTranslator) streamChunk(w io.Writer, chunk *ChatCompletionsStreamResponse) {
+	response := GeminiGenerateContentResponse{ModelVersion: t.model}
+	for _, choice := range chunk.Choices {
+		if choice.FinishReason != nil && *choice.FinishReason != "" {
+			if t.finishReasons == nil {
+				t.finishReasons = make(map[int]string)
+			}
+			t.finishReasons[choice.Index] = finishReasonOpenAI2Gemini(*choice.FinishReason)
+		}
+		for _, toolCall := range choice.Delta.ToolCalls {
+			if t.toolCalls == nil {
+				t.toolCalls = make(map[int][]OpenAIToolCall)
+			}
+			calls := t.toolCalls[choice.Index]
+			toolIndex := len(calls)
+			if toolCall.Index != nil {
+				toolIndex = *toolCall.Index
+			}
+			if toolIndex >= len(calls) {
+				t.toolCalls[choice.Index] = append(calls, toolCall)
+				continue
+			}
+			calls[toolIndex].Function.Arguments += toolCall.Function.Arguments
+		}
+		if choice.Delta.Content == "" {
+			continue
+		}
+		response.Candidates = append(response.Candidates, GeminiCandidate{
+			Content: GeminiResponseContent{Role: "model", Parts: []GeminiPart{{Text: choice.Delta.Content}}},
+			Index:   choice.Index,
+		})
+	}
+	if len(response.Candidates) > 0 {
+		t.writeChunk(w, response)
+	}
+}
+
+func (t *geminiTranslator) streamPing(w io.Writer) {
+	if t.sse {
+		_, _ = io.WriteString(w, ": ping\r\n\r\n")
+	}
+}
+
+func (t *geminiTranslator) streamEnd(w io.Writer, usage *Usage) {
+	response := GeminiGenerateContentResponse{ModelVersion: t.model}
+	if usage != nil {
+		response.UsageMetadata = usageOpenAI2Gemini(*usage)
+	}
+	// the upstreams which don't tell the finish reason stopped normally
+	for index := 0; index == 0 || index < len(t.finishReasons); index++ {
+		finishReason := t.finishReasons[index]
+		if finishReason == "" {
+			finishReason = "STOP"
+		}
+		parts := make([]GeminiPart, 0, len(t.toolCalls[index]))
+		for _, toolCall := range t.toolCalls[index] {
+			parts = append(parts, getGeminiFunctionCallPart(toolCall))
+		}
+		response.Candidates = append(response.Candidates, GeminiCandidate{
+			Content:      GeminiResponseContent{Role: "model", Parts: parts},
+			FinishReason: finishReason,
+			Index:        index,
+		})
+	}
+	t.writeChunk(w, response)
+	if !t.sse {
+		_, _ = io.WriteString(w, "]")
+	}
+}
+
+func (t *geminiTranslator) response(textResponse *OpenAITextResponse, usage *Usage) any {
+	if t.countTokens {
+		return gin.H{"totalTokens": usage.PromptTokens}
+	}
+	response := GeminiGenerateContentResponse{
+		Candidates:    make([]GeminiCandidate, 0, len(textResponse.Choices)),
+		UsageMetadata: usageOpenAI2Gemini(*usage),
+		ModelVersion:  t.model,
+	}
+	for _, choice := range textResponse.Choices {
+		parts := make([]GeminiPart, 0, len(choice.Message.ToolCalls)+1)
+		if text := choice.Message.StringContent(); text != "" {
+			parts = append(parts, GeminiPart{Text: text})
+		}
+		for _, toolCall := range choice.Message.ToolCalls {
+			parts = append(parts, getGeminiFunctionCallPart(toolCall))
+		}
+		response.Candidates = append(response.Candidates, GeminiCandidate{
+			Content:      GeminiResponseContent{Role: "model", Parts: parts},
+			FinishReason: finishReasonOpenAI2Gemini(choice.FinishReason),
+			Index:        choice.Index,
+		})
+	}
+	return response
+}
+
+func (t *geminiTranslator) error(statusCode int, message string) any {
+	return gin.H{
+		"error": gin.H{"code": statusCode, "message": message, "status": getGeminiErrorStatus(statusCode)},
+	}
+}
+
+// GeminiCompatible serves the Gemini generateContent API, it goes before the other middlewares so that their errors are translated as well
+func GeminiCompatible() func(c *gin.Context) {
+	return ingressCompatible(func() ingressTranslator {
+		return &geminiTranslator{}
+	})
+}
+
+// RelayGeminiIngress relays the generateContent requests and counts the tokens of the countTokens ones
+func RelayGeminiIngress(c *gin.Context) {
+	if strings.HasSuffix(c.Param("action"), ":countTokens") {
+		CountIngressTokens(c)
+		return
+	}
+	RelayIngress(c)
+}
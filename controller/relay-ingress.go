@@ -0,0 +1,265 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ingressTranslator serves another API format on top of the OpenAI one, the request is translated before
+// the authentication and the routing, and the OpenAI responses of the middlewares and the relay are translated back
+type ingressTranslator interface {
+	// request replaces the body and the path of the request with the OpenAI chat completions
+	request(c *gin.Context) *OpenAIErrorWithStatusCode
+	// streamHeader adjusts the headers of a stream before they are sent
+	streamHeader(header http.Header)
+	streamChunk(w io.Writer, chunk *ChatCompletionsStreamResponse)
+	// streamPing handles the heartbeats of the relay
+	streamPing(w io.Writer)
+	streamEnd(w io.Writer, usage *Usage)
+	response(response *OpenAITextResponse, usage *Usage) any
+	error(statusCode int, message string) any
+}
+
+// ingressResponseWriter translates the events of a stream as they come while the other responses are buffered until finish
+type ingressResponseWriter struct {
+	gin.ResponseWriter
+	translator ingressTranslator
+	status     int
+	decided    bool
+	stream     bool
+	body       bytes.Buffer
+	finished   bool
+	usage      *Usage
+}
+
+func newIngressResponseWriter(writer gin.ResponseWriter, translator ingressTranslator) *ingressResponseWriter {
+	return &ingressResponseWriter{
+		ResponseWriter: writer,
+		translator:     translator,
+		status:         http.StatusOK,
+	}
+}
+
+func (w *ingressResponseWriter) WriteHeader(code int) {
+	// c.Render(-1, ...) keeps the status as it is
+	if code > 0 && !w.decided {
+		w.status = code
+	}
+}
+
+func (w *ingressResponseWriter) WriteHeaderNow() {
+	w.decide()
+}
+
+func (w *ingressResponseWriter) Status() int {
+	return w.status
+}
+
+func (w *ingressResponseWriter) Written() bool {
+	return w.decided
+}
+
+func (w *ingressResponseWriter) Flush() {
+	if w.stream {
+		w.ResponseWriter.Flush()
+	}
+}
+
+// decide tells the stream apart on the first write, the headers of a stream are sent right away
+func (w *ingressResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	w.stream = w.status == http.StatusOK && strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream")
+	w.Header().Del("Content-Length")
+	if w.stream {
+		w.translator.streamHeader(w.Header())
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.WriteHeaderNow()
+	}
+}
+
+func (w *ingressResponseWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *ingressResponseWriter) Write(data []byte) (int, error) {
+	w.decide()
+	w.body.Write(data)
+	if w.stream {
+		w.translateStream()
+	}
+	return len(data), nil
+}
+
+// translateStream handles the complete lines in the buffer, the rest waits for the next write
+func (w *ingressResponseWriter) translateStream() {
+	for {
+		data := w.body.Bytes()
+		end := bytes.IndexByte(data, '\n')
+		if end < 0 {
+			return
+		}
+		line := strings.TrimSuffix(string(data[:end]), "\r")
+		w.body.Next(end + 1)
+		switch {
+		case strings.HasPrefix(line, ":"):
+			w.translator.streamPing(w.ResponseWriter)
+		case strings.HasPrefix(line, "data: ") && line != "data: [DONE]":
+			var chunk ChatCompletionsStreamResponse
+			if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk) != nil {
+				continue
+			}
+			if chunk.Usage != nil {
+				w.usage = chunk.Usage
+			}
+			w.translator.streamChunk(w.ResponseWriter, &chunk)
+		}
+	}
+}
+
+func (w *ingressResponseWriter) writeJSON(status int, data any) {
+	jsonData, err := json.Marshal(data)
+	if err != nil {
+		common.SysError("error marshalling translated response: " + err.Error())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(status)
+	_, _ = w.ResponseWriter.Write(jsonData)
+}
+
+// finish ends the stream, or translates the buffered response, usage is the one billed by the relay if any
+func (w *ingressResponseWriter) finish(usage *Usage) {
+	if w.finished {
+		return
+	}
+	w.finished = true
+	if usage == nil {
+		usage = w.usage
+	}
+	if w.stream {
+		w.translator.streamEnd(w.ResponseWriter, usage)
+		w.ResponseWriter.Flush()
+		return
+	}
+	if !w.decided {
+		return
+	}
+	if w.status != http.StatusOK {
+		var errorResponse struct {
+			Error OpenAIError `json:"error"`
+		}
+		_ = json.Unmarshal(w.body.Bytes(), &errorResponse)
+		message := errorResponse.Error.Message
+		if message == "" {
+			message = http.StatusText(w.status)
+		}
+		w.writeJSON(w.status, w.translator.error(w.status, message))
+		return
+	}
+	var textResponse OpenAITextResponse
+	err := json.Unmarshal(w.body.Bytes(), &textResponse)
+	if err != nil {
+		w.writeJSON(http.StatusInternalServerError, w.translator.error(http.StatusInternalServerError, "failed to translate the upstream response"))
+		return
+	}
+	if usage == nil {
+		usage = &textResponse.Usage
+	}
+	w.writeJSON(http.StatusOK, w.translator.response(&textResponse, usage))
+}
+
+func ingressCompatible(newTranslator func() ingressTranslator) func(c *gin.Context) {
+	return func(c *gin.Context) {
+		translator := newTranslator()
+		writer := newIngressResponseWriter(c.Writer, translator)
+		c.Writer = writer
+		if err := translator.request(c); err != nil {
+			writeRelayError(c, err)
+			c.Abort()
+		} else {
+			c.Next()
+		}
+		var usage *Usage
+		if value, ok := c.Get("relay_usage"); ok {
+			relayUsage := value.(Usage)
+			if relayUsage.TotalTokens > 0 || relayUsage.CompletionTokens > 0 {
+				usage = &relayUsage
+			}
+		}
+		writer.finish(usage)
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// setIngressRequest replaces the request with the translated chat completions
+func setIngressRequest(c *gin.Context, openaiRequest map[string]any) *OpenAIErrorWithStatusCode {
+	jsonData, err := json.Marshal(openaiRequest)
+	if err != nil {
+		return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Request.URL.Path = "/v1/chat/completions"
+	c.Request.URL.RawPath = ""
+	// the query may hold the key of the client
+	c.Request.URL.RawQuery = ""
+	return nil
+}
+
+// getIngressContent is the content of a translated message, plain text if the parts are all text since it suits the
+// upstreams which don't take the lists of content parts
+func getIngressContent(parts []any) any {
+	if len(parts) == 0 {
+		return nil
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		part, _ := part.(map[string]any)
+		if part["type"] != "text" {
+			return parts
+		}
+		text, _ := part["text"].(string)
+		texts = append(texts, text)
+	}
+	return strings.Join(texts, "\n")
+}
+
+// CountIngressTokens counts the input tokens of a translated request, the tools included, the count goes to the
+// translator as the usage of an empty response
+func CountIngressTokens(c *gin.Context) {
+	var request struct {
+		Model    string    `json:"model"`
+		Messages []Message `json:"messages"`
+		Tools    any       `json:"tools"`
+	}
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		writeRelayError(c, errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest))
+		return
+	}
+	promptTokens := countTokenMessages(request.Messages, request.Model)
+	if request.Tools != nil {
+		tools, _ := json.Marshal(request.Tools)
+		promptTokens += countTokenText(string(tools), request.Model)
+	}
+	c.JSON(http.StatusOK, OpenAITextResponse{Usage: Usage{PromptTokens: promptTokens, TotalTokens: promptTokens}})
+}
+
+// RelayIngress relays the chat completions translated from another API format, with whatever channel the routing selects
+func RelayIngress(c *gin.Context) {
+	err := relayWithFailover(c, RelayModeChatCompletions, relayTextHelper)
+	if err != nil {
+		writeRelayError(c, err)
+		reportRelayError(c, err)
+	}
+}
@@ -88,11 +88,11 @@ func requestOpenAI2Minimax(request GeneralOpenAIRequest) *MinimaxChatRequest {
 	prompt := ""
 	for _, message := range request.Messages {
 		if message.Role == "system" {
-			prompt += message.Content
+			prompt += message.StringContent()
 		} else {
 			messages = append(messages, MinimaxChatMessage{
 				SenderType: openAIMsgRoleToMinimaxMsgRole(message.Role),
-				Text:       message.Content,
+				Text:       message.StringContent(),
 			})
 		}
 	}
@@ -137,14 +137,9 @@ func streamResponseMinimaxChat2OpenAI(response *MinimaxChatStreamResponse) *Chat
 	}
 	for i := range response.Choices {
 		choice := response.Choices[i]
-		ans.Choices = append(ans.Choices, ChatCompletionsStreamResponseChoice{
-			Delta: struct {
-				Content string `json:"content"`
-			}{
-				Content: choice.Delta,
-			},
-			FinishReason: &choice.FinishReason,
-		})
+		streamChoice := ChatCompletionsStreamResponseChoice{FinishReason: &choice.FinishReason}
+		streamChoice.Delta.Content = choice.Delta
+		ans.Choices = append(ans.Choices, streamChoice)
 	}
 	return &ans
 }
@@ -235,8 +230,16 @@ func minimaxStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWith
 		}
 		return 0, nil, nil
 	})
-	dataChan := make(chan string, 100)
+	c.Writer.Header().Set("Content-Type", "text/event-stream")
+	c.Writer.Header().Set("Cache-Control", "no-cache")
+	c.Writer.Header().Set("Connection", "keep-alive")
+	c.Writer.Header().Set("Transfer-Encoding", "chunked")
+	c.Writer.Header().Set("X-Accel-Buffering", "no")
+	keeper := newStreamKeeper(c)
+	dataChan := make(chan string)
+	stopChan := make(chan bool)
 	go func() {
+		defer close(stopChan)
 		for scanner.Scan() {
 			data := scanner.Text()
 			if len(data) < 6 { // ignore blank line or wrong format
@@ -246,18 +249,17 @@ func minimaxStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWith
 				continue
 			}
 			data = data[6:]
-			dataChan <- data
+			select {
+			case dataChan <- data:
+			case <-keeper.Done():
+				return
+			}
 		}
-		close(dataChan)
 	}()
-
-	c.Writer.Header().Set("Content-Type", "text/event-stream")
-	c.Writer.Header().Set("Cache-Control", "no-cache")
-	c.Writer.Header().Set("Connection", "keep-alive")
-	c.Writer.Header().Set("Transfer-Encoding", "chunked")
-	c.Writer.Header().Set("X-Accel-Buffering", "no")
 	c.Stream(func(w io.Writer) bool {
-		if data, ok := <-dataChan; ok {
+		select {
+		case data := <-dataChan:
+			keeper.Touch()
 			var minimaxChatStreamRsp MinimaxChatStreamResponse
 			err := json.Unmarshal([]byte(data), &minimaxChatStreamRsp)
 			if err != nil {
@@ -273,10 +275,13 @@ func minimaxStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWith
 			}
 			c.Render(-1, common.CustomEvent{Data: "data: " + string(jsonResponse)})
 			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
+		case <-stopChan:
+			return false
 		}
-		return false
 	})
-	err := resp.Body.Close()
+	err := keeper.Finish(resp.Body, stopChan)
 	if err != nil {
 		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
 	}
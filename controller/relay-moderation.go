@@ -0,0 +1,151 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"sort"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+type ModerationResult struct {
+	Flagged    bool            `json:"flagged"`
+	Categories map[string]bool `json:"categories"`
+}
+
+type ModerationResponse struct {
+	Results []ModerationResult `json:"results"`
+	Error   OpenAIError        `json:"error"`
+}
+
+// moderateContent asks the moderation model whether the content is flagged, and returns the flagged categories, only
+// OpenAI compatible channels are supported, the channel is selected like the ones of the request, within its data
+// residency
+func moderateContent(c *gin.Context, group string, moderationModel string, content string) ([]string, error) {
+	channel, err := middleware.SelectChannel(c, group, moderationModel)
+	if err != nil {
+		return nil, fmt.Errorf("审核模型 %s 无可用渠道", moderationModel)
+	}
+	if getAPIType(channel.Type) != APITypeOpenAI || channel.Type == common.ChannelTypeAzure {
+		return nil, fmt.Errorf("审核模型 %s 的渠道不是 OpenAI 兼容的渠道", moderationModel)
+	}
+	jsonData, err := json.Marshal(map[string]any{
+		"model": moderationModel,
+		"input": content,
+	})
+	if err != nil {
+		return nil, err
+	}
+	requestURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		requestURL = channel.BaseURL
+	}
+	req, err := http.NewRequest("POST", requestURL+"/v1/moderations", bytes.NewBuffer(jsonData))
+	if err != nil {
+		return nil, err
+	}
+	key, _ := model.PickChannelKey(channel)
+	req.Header.Set("Authorization", "Bearer "+key)
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := getImpatientHTTPClient(channel.Proxy).Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var response ModerationResponse
+	err = json.NewDecoder(resp.Body).Decode(&response)
+	if err != nil {
+		return nil, err
+	}
+	if response.Error.Message != "" {
+		return nil, errors.New(response.Error.Message)
+	}
+	var categories []string
+	for _, result := range response.Results {
+		if !result.Flagged {
+			continue
+		}
+		for category, flagged := range result.Categories {
+			if flagged {
+				categories = append(categories, category)
+			}
+		}
+		if len(categories) == 0 {
+			categories = append(categories, "flagged")
+		}
+	}
+	sort.Strings(categories)
+	return categories, nil
+}
+
+// checkContent returns why the content is refused by the sensitive words or by the moderation model, empty if it is
+// not, a failed call of the moderation model lets the content through
+func checkContent(c *gin.Context, group string, content string) string {
+	if word := common.MatchSensitiveWord(content); word != "" {
+		return "命中敏感词 " + word
+	}
+	if common.ContentModerationModel == "" {
+		return ""
+	}
+	categories, err := moderateContent(c, group, common.ContentModerationModel, content)
+	if err != nil {
+		common.SysError("failed to moderate content: " + err.Error())
+		return ""
+	}
+	if len(categories) > 0 {
+		return "审核模型判定违规：" + strings.Join(categories, ", ")
+	}
+	return ""
+}
+
+// ModerateContent checks the requests against the sensitive words and the moderation model before they are relayed,
+// by the moderation level of the user group, it comes after Distribute so that the group is known
+func ModerateContent() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group := c.GetString("group")
+		level := common.GetGroupModerationLevel(group)
+		if level == common.ModerationLevelOff || strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") ||
+			!strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			c.Next()
+			return
+		}
+		var body map[string]interface{}
+		err := common.UnmarshalBodyReusable(c, &body)
+		if err != nil || body == nil {
+			c.Next()
+			return
+		}
+		content := middleware.CollectRequestContent(body)
+		if content == "" {
+			c.Next()
+			return
+		}
+		reason := checkContent(c, group, content)
+		if reason == "" {
+			c.Next()
+			return
+		}
+		modelName, _ := body["model"].(string)
+		if level == common.ModerationLevelFlag {
+			model.RecordModerationLog(c.GetInt("id"), modelName, c.GetString("token_name"), "请求"+reason+"，已放行")
+			c.Next()
+			return
+		}
+		model.RecordModerationLog(c.GetInt("id"), modelName, c.GetString("token_name"), "请求"+reason+"，已拦截")
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "请求内容未通过审核",
+				"type":    "one_api_error",
+				"code":    "content_moderation_blocked",
+			},
+		})
+		c.Abort()
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"bufio"
 	"bytes"
 	"encoding/json"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"io"
 	"net/http"
@@ -11,8 +12,9 @@ import (
 	"strings"
 )
 
-func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*OpenAIErrorWithStatusCode, string) {
+func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*OpenAIErrorWithStatusCode, string, *Usage) {
 	responseText := ""
+	var usage *Usage
 	scanner := bufio.NewScanner(resp.Body)
 	scanner.Split(func(data []byte, atEOF bool) (advance int, token []byte, err error) {
 		if atEOF && len(data) == 0 {
@@ -26,9 +28,12 @@ func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*O
 		}
 		return 0, nil, nil
 	})
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
 	dataChan := make(chan string)
 	stopChan := make(chan bool)
 	go func() {
+		defer close(stopChan)
 		for scanner.Scan() {
 			data := scanner.Text()
 			if len(data) < 6 { // ignore blank line or wrong format
@@ -37,7 +42,11 @@ func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*O
 			if data[:6] != "data: " && data[:6] != "[DONE]" {
 				continue
 			}
-			dataChan <- data
+			select {
+			case dataChan <- data:
+			case <-keeper.Done():
+				return
+			}
 			data = data[6:]
 			if !strings.HasPrefix(data, "[DONE]") {
 				switch relayMode {
@@ -51,6 +60,9 @@ func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*O
 					for _, choice := range streamResponse.Choices {
 						responseText += choice.Delta.Content
 					}
+					if streamResponse.Usage != nil {
+						usage = streamResponse.Usage
+					}
 				case RelayModeCompletions:
 					var streamResponse CompletionsStreamResponse
 					err := json.Unmarshal([]byte(data), &streamResponse)
@@ -61,15 +73,17 @@ func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*O
 					for _, choice := range streamResponse.Choices {
 						responseText += choice.Text
 					}
+					if streamResponse.Usage != nil {
+						usage = streamResponse.Usage
+					}
 				}
 			}
 		}
-		stopChan <- true
 	}()
-	setEventStreamHeaders(c)
 	c.Stream(func(w io.Writer) bool {
 		select {
 		case data := <-dataChan:
+			keeper.Touch()
 			if strings.HasPrefix(data, "data: [DONE]") {
 				data = data[:12]
 			}
@@ -77,18 +91,40 @@ func openaiStreamHandler(c *gin.Context, resp *http.Response, relayMode int) (*O
 			data = strings.TrimSuffix(data, "\r")
 			c.Render(-1, common.CustomEvent{Data: data})
 			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
 		case <-stopChan:
 			return false
 		}
 	})
-	err := resp.Body.Close()
+	err := keeper.Finish(resp.Body, stopChan)
 	if err != nil {
-		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), ""
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
 	}
-	return nil, responseText
+	return nil, responseText, usage
 }
 
-func openaiHandler(c *gin.Context, resp *http.Response, consumeQuota bool, promptTokens int, model string) (*OpenAIErrorWithStatusCode, *Usage) {
+// getOpenAIResponseError is the error of a failed response of the APIs which are relayed as they are, such as audio
+func getOpenAIResponseError(resp *http.Response) *OpenAIErrorWithStatusCode {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError)
+	}
+	_ = resp.Body.Close()
+	var errorResponse struct {
+		Error OpenAIError `json:"error"`
+	}
+	err = json.Unmarshal(responseBody, &errorResponse)
+	if err != nil || errorResponse.Error.Message == "" {
+		return errorWrapper(fmt.Errorf("status code %d", resp.StatusCode), "bad_response_status_code", resp.StatusCode)
+	}
+	return &OpenAIErrorWithStatusCode{
+		OpenAIError: errorResponse.Error,
+		StatusCode:  resp.StatusCode,
+	}
+}
+
+func openaiHandler(c *gin.Context, resp *http.Response, consumeQuota bool, promptTokens int, model string) (*OpenAIErrorWithStatusCode, *TextResponse) {
 	var textResponse TextResponse
 	if consumeQuota {
 		responseBody, err := io.ReadAll(resp.Body)
@@ -116,9 +152,7 @@ func openaiHandler(c *gin.Context, resp *http.Response, consumeQuota bool, promp
 	// And then we will have to send an error response, but in this case, the header has already been set.
 	// So the httpClient will be confused by the response.
 	// For example, Postman will report error, and we cannot check the response at all.
-	for k, v := range resp.Header {
-		c.Writer.Header().Set(k, v[0])
-	}
+	copyResponseHeaders(c, resp)
 	c.Writer.WriteHeader(resp.StatusCode)
 	_, err := io.Copy(c.Writer, resp.Body)
 	if err != nil {
@@ -132,7 +166,7 @@ func openaiHandler(c *gin.Context, resp *http.Response, consumeQuota bool, promp
 	if textResponse.Usage.TotalTokens == 0 {
 		completionTokens := 0
 		for _, choice := range textResponse.Choices {
-			completionTokens += countTokenText(choice.Message.Content, model)
+			completionTokens += countTokenText(choice.Message.StringContent(), model)
 		}
 		textResponse.Usage = Usage{
 			PromptTokens:     promptTokens,
@@ -140,5 +174,5 @@ func openaiHandler(c *gin.Context, resp *http.Response, consumeQuota bool, promp
 			TotalTokens:      promptTokens + completionTokens,
 		}
 	}
-	return nil, &textResponse.Usage
+	return nil, &textResponse
 }
@@ -0,0 +1,183 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+var errRaceLost = errors.New("the response lost the race")
+
+// channelRace is shared by the two requests of a race, the first of them to write a response wins
+type channelRace struct {
+	lock    sync.Mutex
+	winner  int // index of the winning request, -1 until one of them responds
+	writer  gin.ResponseWriter
+	cancels []context.CancelFunc
+}
+
+// claim returns whether the request owns the response, the first request to claim copies its headers to the
+// client and cancels the other one
+func (race *channelRace) claim(index int, header http.Header, status int) bool {
+	race.lock.Lock()
+	defer race.lock.Unlock()
+	if race.winner == -1 {
+		race.winner = index
+		for key, values := range header {
+			race.writer.Header()[key] = values
+		}
+		race.writer.WriteHeader(status)
+		for i, cancel := range race.cancels {
+			if i != index {
+				cancel()
+			}
+		}
+	}
+	return race.winner == index
+}
+
+// raceWriter keeps the response of a request of the race to itself until the request has something to send
+type raceWriter struct {
+	gin.ResponseWriter
+	race   *channelRace
+	index  int
+	status int
+	closed chan bool
+}
+
+func newRaceWriter(writer gin.ResponseWriter, race *channelRace, index int, ctx context.Context) *raceWriter {
+	w := &raceWriter{ResponseWriter: writer, race: race, index: index, status: http.StatusOK, closed: make(chan bool, 1)}
+	go func() {
+		<-ctx.Done()
+		w.closed <- true
+	}()
+	return w
+}
+
+func (w *raceWriter) won() bool {
+	w.race.lock.Lock()
+	defer w.race.lock.Unlock()
+	return w.race.winner == w.index
+}
+
+func (w *raceWriter) WriteHeader(code int) {
+	w.status = code
+}
+
+func (w *raceWriter) WriteHeaderNow() {
+}
+
+func (w *raceWriter) Status() int {
+	return w.status
+}
+
+func (w *raceWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+// Write claims the race with the first data, the heartbeats and the paddings of a stream are dropped until then
+func (w *raceWriter) Write(data []byte) (int, error) {
+	if w.won() {
+		return w.race.writer.Write(data)
+	}
+	if strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") && !bytes.Contains(data, []byte("data:")) {
+		return len(data), nil
+	}
+	if !w.race.claim(w.index, w.Header(), w.status) {
+		return 0, errRaceLost
+	}
+	return w.race.writer.Write(data)
+}
+
+func (w *raceWriter) Flush() {
+	if w.won() {
+		w.race.writer.Flush()
+	}
+}
+
+// CloseNotify is called by the stream handlers, the recorder under the writer does not implement it
+func (w *raceWriter) CloseNotify() <-chan bool {
+	return w.closed
+}
+
+// isRaceEnabled tells whether the request is sent to two channels at once, by its token or by its model, the
+// requests for a specified channel and the ones of an experiment keep to their channel
+func isRaceEnabled(c *gin.Context) bool {
+	if _, ok := c.Get("channelId"); ok || c.GetInt("experiment_id") != 0 {
+		return false
+	}
+	if c.GetBool("race_enabled") {
+		return true
+	}
+	requestModel := c.GetString("request_model")
+	for _, raceModel := range common.RaceModels {
+		if raceModel != "" && raceModel == requestModel {
+			return true
+		}
+	}
+	return false
+}
+
+// relayRace sends the request to the selected channel and to the race channel at once, the response of the first
+// to respond is relayed and the other request is cancelled, only the usage of the winner is returned
+func relayRace(c *gin.Context, relayMode int, raceChannel *model.Channel) (Usage, []channelUsageShare, *OpenAIErrorWithStatusCode) {
+	var usage Usage
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return usage, nil, errorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	race := &channelRace{winner: -1, writer: c.Writer}
+	raceContexts := make([]*gin.Context, 2)
+	for i := range raceContexts {
+		ctx, cancel := context.WithCancel(c.Request.Context())
+		race.cancels = append(race.cancels, cancel)
+		raceContext, _ := newChoiceContext(c, requestBody)
+		raceContext.Request = raceContext.Request.WithContext(ctx)
+		raceContext.Writer = newRaceWriter(raceContext.Writer, race, i, ctx)
+		raceContexts[i] = raceContext
+	}
+	defer func() {
+		for _, cancel := range race.cancels {
+			cancel()
+		}
+	}()
+	middleware.SetupContextForSelectedChannel(raceContexts[1], raceChannel)
+	errs := make([]*OpenAIErrorWithStatusCode, len(raceContexts))
+	var wg sync.WaitGroup
+	for i, raceContext := range raceContexts {
+		wg.Add(1)
+		go func(i int, raceContext *gin.Context) {
+			defer wg.Done()
+			errs[i] = relayTextHelper(raceContext, relayMode)
+		}(i, raceContext)
+	}
+	wg.Wait()
+	race.lock.Lock()
+	winner := race.winner
+	race.lock.Unlock()
+	if winner == -1 {
+		// neither responded, the error of the selected channel is the one the client sees
+		if errs[0] != nil {
+			return usage, nil, errs[0]
+		}
+		return usage, nil, errs[1]
+	}
+	if value, ok := raceContexts[winner].Get("relay_usage"); ok {
+		usage = value.(Usage)
+	}
+	shares := []channelUsageShare{{
+		channelId: raceContexts[winner].GetInt("channel_id"),
+		keyHash:   raceContexts[winner].GetString("channel_key_hash"),
+		usage:     usage,
+	}}
+	return usage, shares, errs[winner]
+}
@@ -0,0 +1,324 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// the realtime API of Azure is only in the preview API versions
+const defaultAzureRealtimeAPIVersion = "2024-10-01-preview"
+
+const realtimeCloseTimeout = time.Second
+
+var realtimeUpgrader = websocket.Upgrader{
+	// the clients authenticate with the tokens rather than the cookies, so any origin is allowed
+	CheckOrigin:  func(r *http.Request) bool { return true },
+	Subprotocols: []string{"realtime"},
+}
+
+// RealtimeUsage is the usage of a response of a realtime session, the cached tokens are included in the text and the
+// audio tokens, see https://platform.openai.com/docs/api-reference/realtime-server-events/response/done
+type RealtimeUsage struct {
+	TotalTokens       int `json:"total_tokens"`
+	InputTokens       int `json:"input_tokens"`
+	OutputTokens      int `json:"output_tokens"`
+	InputTokenDetails struct {
+		CachedTokens        int `json:"cached_tokens"`
+		TextTokens          int `json:"text_tokens"`
+		AudioTokens         int `json:"audio_tokens"`
+		CachedTokensDetails struct {
+			TextTokens  int `json:"text_tokens"`
+			AudioTokens int `json:"audio_tokens"`
+		} `json:"cached_tokens_details"`
+	} `json:"input_token_details"`
+	OutputTokenDetails struct {
+		TextTokens  int `json:"text_tokens"`
+		AudioTokens int `json:"audio_tokens"`
+	} `json:"output_token_details"`
+}
+
+type RealtimeEvent struct {
+	Type     string `json:"type"`
+	Response *struct {
+		Usage *RealtimeUsage `json:"usage"`
+	} `json:"response,omitempty"`
+}
+
+// realtimeSession is what the bridge of a session needs, the context of the handshake is not used once it returns
+type realtimeSession struct {
+	quotaSettlement
+	requestModel string
+	modelName    string
+}
+
+// RealtimeCompatible takes the key from the subprotocols of the browsers, which can't set the headers of a WebSocket
+func RealtimeCompatible() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		if c.Request.Header.Get("Authorization") == "" {
+			for _, protocol := range websocket.Subprotocols(c.Request) {
+				if key := strings.TrimPrefix(protocol, "openai-insecure-api-key."); key != protocol {
+					c.Request.Header.Set("Authorization", "Bearer "+key)
+				}
+			}
+		}
+		c.Next()
+	}
+}
+
+// getRealtimeBeta is the beta header of the upstream, the browsers send it as a subprotocol
+func getRealtimeBeta(c *gin.Context) string {
+	if beta := c.Request.Header.Get("OpenAI-Beta"); beta != "" {
+		return beta
+	}
+	for _, protocol := range websocket.Subprotocols(c.Request) {
+		if beta := strings.TrimPrefix(protocol, "openai-beta."); beta != protocol {
+			return strings.Replace(beta, "-", "=", 1)
+		}
+	}
+	return ""
+}
+
+// relayRealtimeHelper connects to the upstream before upgrading the client, so that the failed handshakes fail over to
+// the other channels, the session is then bridged in the background
+func relayRealtimeHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel")
+	userId := c.GetInt("id")
+	consumeQuota := c.GetBool("consume_quota")
+	if getAPIType(channelType) != APITypeOpenAI {
+		return errorWrapper(fmt.Errorf("channel type %d does not support the realtime API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
+	if !websocket.IsWebSocketUpgrade(c.Request) {
+		return errorWrapper(errors.New("the realtime API requires a WebSocket connection"), "invalid_request", http.StatusBadRequest)
+	}
+	realtimeModel := c.GetString("request_model")
+	if realtimeModel == "" {
+		return errorWrapper(errors.New("model is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping != "" {
+		modelMap := make(map[string]string)
+		err := json.Unmarshal([]byte(modelMapping), &modelMap)
+		if err != nil {
+			return errorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+		}
+		if modelMap[realtimeModel] != "" {
+			realtimeModel = modelMap[realtimeModel]
+		}
+	}
+	// the session is billed by its responses, so it only needs some quota to start
+	if consumeQuota {
+		userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
+		if err != nil {
+			return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+		}
+		if userQuota <= 0 {
+			return quotaExhaustedErrorWrapper(errors.New("user quota is not enough"), "insufficient_user_quota", http.StatusForbidden)
+		}
+	}
+
+	baseURL := common.ChannelBaseURLs[channelType]
+	if c.GetString("base_url") != "" {
+		baseURL = c.GetString("base_url")
+	}
+	fullRequestURL := fmt.Sprintf("%s/v1/realtime?model=%s", baseURL, url.QueryEscape(realtimeModel))
+	if channelType == common.ChannelTypeAzure {
+		deployment, apiVersion := resolveAzureDeployment(c.GetString("azure_deployments"), c.GetString("api_version"), realtimeModel)
+		if apiVersion == defaultAzureAPIVersion {
+			apiVersion = defaultAzureRealtimeAPIVersion
+		}
+		if requestAPIVersion := c.Query("api-version"); requestAPIVersion != "" {
+			apiVersion = requestAPIVersion
+		}
+		fullRequestURL = fmt.Sprintf("%s/openai/realtime?api-version=%s&deployment=%s", baseURL, url.QueryEscape(apiVersion), url.QueryEscape(deployment))
+	}
+	fullRequestURL = "ws" + strings.TrimPrefix(fullRequestURL, "http")
+
+	req, err := http.NewRequest("GET", fullRequestURL, nil)
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	if channelType == common.ChannelTypeAzure {
+		req.Header.Set("api-key", strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	} else {
+		setBearerAuth(req, strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+	}
+	if beta := getRealtimeBeta(c); beta != "" {
+		req.Header.Set("OpenAI-Beta", beta)
+	}
+	setChannelHeaders(req, c.GetString("channel_headers"))
+	setPolicyHeaders(c, req)
+
+	upstream, resp, err := getWebsocketDialer(c.GetString("proxy")).Dial(fullRequestURL, req.Header)
+	if err != nil {
+		if resp != nil {
+			return getOpenAIResponseError(resp)
+		}
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	client, err := realtimeUpgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has answered the client already
+		_ = upstream.Close()
+		common.SysError("error upgrading the realtime connection: " + err.Error())
+		return nil
+	}
+	session := &realtimeSession{
+		quotaSettlement: getQuotaSettlement(c),
+		requestModel:    c.GetString("request_model"),
+		modelName:       realtimeModel,
+	}
+	// the consume logs are sampled for each response of the session
+	session.logConsumeEnabled = resolveBoolOption(c, "LogConsumeEnabled", common.LogConsumeEnabled)
+	go session.bridge(client, upstream)
+	return nil
+}
+
+// bridge relays the events both ways until either side closes, the responses are billed as they are done and the
+// session is closed once the quota runs out
+func (s *realtimeSession) bridge(client *websocket.Conn, upstream *websocket.Conn) {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for {
+			messageType, message, err := client.ReadMessage()
+			if err != nil {
+				forwardClose(upstream, err)
+				_ = upstream.Close()
+				return
+			}
+			if err := upstream.WriteMessage(messageType, message); err != nil {
+				_ = client.Close()
+				return
+			}
+		}
+	}()
+	for {
+		messageType, message, err := upstream.ReadMessage()
+		if err != nil {
+			forwardClose(client, err)
+			break
+		}
+		if err := client.WriteMessage(messageType, message); err != nil {
+			break
+		}
+		// the audio deltas are the most of the events, they are not parsed
+		if messageType != websocket.TextMessage || !bytes.Contains(message, []byte(`"response.done"`)) {
+			continue
+		}
+		var event RealtimeEvent
+		if json.Unmarshal(message, &event) != nil || event.Type != "response.done" || event.Response == nil || event.Response.Usage == nil {
+			continue
+		}
+		if !s.bill(event.Response.Usage) {
+			errorEvent := gin.H{
+				"type": "error",
+				"error": gin.H{
+					"type":    "insufficient_quota",
+					"code":    "insufficient_user_quota",
+					"message": "额度已用尽，实时会话已关闭",
+				},
+			}
+			jsonData, _ := json.Marshal(errorEvent)
+			_ = client.WriteMessage(websocket.TextMessage, jsonData)
+			_ = client.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "insufficient quota"), time.Now().Add(realtimeCloseTimeout))
+			_ = upstream.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(realtimeCloseTimeout))
+			break
+		}
+	}
+	_ = upstream.Close()
+	_ = client.Close()
+	<-done
+}
+
+// forwardClose passes the close code of a side to the other one, the abnormal closures have no close frame to pass
+func forwardClose(conn *websocket.Conn, err error) {
+	var closeErr *websocket.CloseError
+	if errors.As(err, &closeErr) && closeErr.Code != websocket.CloseAbnormalClosure && closeErr.Code != websocket.CloseTLSHandshake {
+		_ = conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(closeErr.Code, closeErr.Text), time.Now().Add(realtimeCloseTimeout))
+	}
+}
+
+// getRealtimeQuota weights the tokens of a response by the audio, the completion and the cache ratios, without the
+// model ratio, the cached audio tokens cost as much as the cached text ones
+func getRealtimeQuota(usage *RealtimeUsage, modelName string) float64 {
+	input := usage.InputTokenDetails
+	textTokens, audioTokens := input.TextTokens, input.AudioTokens
+	if textTokens+audioTokens == 0 {
+		// the compatible upstreams may report no details
+		textTokens = usage.InputTokens
+	}
+	cachedTextTokens, cachedAudioTokens := input.CachedTokensDetails.TextTokens, input.CachedTokensDetails.AudioTokens
+	if cachedTextTokens+cachedAudioTokens == 0 {
+		cachedTextTokens = input.CachedTokens
+	}
+	promptQuota := float64(textTokens-cachedTextTokens) + float64(audioTokens-cachedAudioTokens)*common.GetAudioRatio(modelName) +
+		float64(cachedTextTokens+cachedAudioTokens)*common.GetCacheRatio(modelName)
+	output := usage.OutputTokenDetails
+	outputTextTokens, outputAudioTokens := output.TextTokens, output.AudioTokens
+	if outputTextTokens+outputAudioTokens == 0 {
+		outputTextTokens = usage.OutputTokens
+	}
+	completionQuota := (float64(outputTextTokens) + float64(outputAudioTokens)*common.GetAudioCompletionRatio(modelName)) * getCompletionRatio(modelName)
+	return promptQuota + completionQuota
+}
+
+// bill charges a response of the session, false if the user or the token has no quota left
+func (s *realtimeSession) bill(usage *RealtimeUsage) bool {
+	promptTokens, completionTokens := usage.InputTokens, usage.OutputTokens
+	if promptTokens+completionTokens == 0 {
+		return true
+	}
+	model.RecordChannelTokens(s.channelId, s.requestModel, promptTokens+completionTokens)
+	modelRatio := common.GetModelRatio(s.modelName)
+	groupRatio := common.GetGroupRatio(s.group)
+	// the budgets of the channel count the list price, without the group ratio and the discounts
+	weightedTokens := getRealtimeQuota(usage, s.modelName)
+	model.RecordChannelSpend(s.channelId, int(weightedTokens*modelRatio))
+	quota := 0
+	if s.consumeQuota {
+		ratio := modelRatio * groupRatio
+		quota = int(weightedTokens * ratio)
+		if ratio != 0 && quota <= 0 {
+			quota = 1
+		}
+		chargeLog := ""
+		if quota != 0 {
+			quota, chargeLog = applyModelCharges(s.modelName, quota, groupRatio)
+			var couponLog string
+			quota, couponLog = applyUserCoupon(s.userId, s.modelName, quota)
+			chargeLog += couponLog
+		}
+		logContent := fmt.Sprintf("实时会话，音频输入 %d tokens，音频输出 %d tokens，模型倍率 %.2f，分组倍率 %.2f",
+			usage.InputTokenDetails.AudioTokens, usage.OutputTokenDetails.AudioTokens, modelRatio, groupRatio)
+		logContent += chargeLog
+		settlement := s.quotaSettlement
+		settlement.logConsumeEnabled = s.logConsumeEnabled && (common.LogSampleRate >= 100 || rand.Intn(100) < common.LogSampleRate)
+		settlement.settle(s.modelName, promptTokens, completionTokens, quota, logContent)
+	}
+	cost, priced := common.GetUpstreamCost(s.modelName, promptTokens, completionTokens)
+	model.RecordChannelUsage(s.channelId, s.modelName, promptTokens, completionTokens, quota, cost, priced)
+	if !s.consumeQuota {
+		return true
+	}
+	token, err := model.GetTokenById(s.tokenId)
+	if err != nil {
+		return true
+	}
+	userQuota, err := model.CacheGetAvailableQuota(s.userId, token.OrganizationId)
+	if err == nil && userQuota <= 0 {
+		return false
+	}
+	return token.UnlimitedQuota || token.RemainQuota > 0
+}
@@ -0,0 +1,70 @@
+package controller
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"one-api/common"
+	"sync"
+)
+
+// streamUsageDrift compares the completion tokens reported by upstreams with our own count
+type streamUsageDrift struct {
+	Samples        int
+	ReportedTokens int64
+	CountedTokens  int64
+}
+
+var streamUsageDriftLock sync.Mutex
+var streamUsageDrifts = make(map[string]*streamUsageDrift)
+
+func recordStreamUsageDrift(modelName string, reported int, counted int) {
+	streamUsageDriftLock.Lock()
+	drift, ok := streamUsageDrifts[modelName]
+	if !ok {
+		drift = &streamUsageDrift{}
+		streamUsageDrifts[modelName] = drift
+	}
+	drift.Samples++
+	drift.ReportedTokens += int64(reported)
+	drift.CountedTokens += int64(counted)
+	streamUsageDriftLock.Unlock()
+	if reported > 0 && math.Abs(float64(counted-reported))/float64(reported) > 0.2 {
+		common.SysLog(fmt.Sprintf("stream usage of %s differs from the local count: reported %d, counted %d", modelName, reported, counted))
+	}
+}
+
+func getStreamUsageDrifts() map[string]streamUsageDrift {
+	streamUsageDriftLock.Lock()
+	defer streamUsageDriftLock.Unlock()
+	drifts := make(map[string]streamUsageDrift, len(streamUsageDrifts))
+	for modelName, drift := range streamUsageDrifts {
+		drifts[modelName] = *drift
+	}
+	return drifts
+}
+
+// reconcileStreamUsage decides the usage to bill for a stream: upstreams which never report usage are
+// billed by tokenizing the accumulated completion with the encoding of the model, and a sample of the
+// upstreams which do report it are counted as well so that the two can be compared.
+func reconcileStreamUsage(modelName string, promptTokens int, responseText string, usage *Usage) Usage {
+	if usage == nil || usage.CompletionTokens == 0 {
+		completionTokens := countTokenText(responseText, modelName)
+		return Usage{
+			PromptTokens:     promptTokens,
+			CompletionTokens: completionTokens,
+			TotalTokens:      promptTokens + completionTokens,
+		}
+	}
+	reconciled := *usage
+	if reconciled.PromptTokens == 0 {
+		reconciled.PromptTokens = promptTokens
+	}
+	if reconciled.TotalTokens == 0 {
+		reconciled.TotalTokens = reconciled.PromptTokens + reconciled.CompletionTokens
+	}
+	if responseText != "" && rand.Intn(100) < common.StreamUsageVerificationRate {
+		recordStreamUsageDrift(modelName, reconciled.CompletionTokens, countTokenText(responseText, modelName))
+	}
+	return reconciled
+}
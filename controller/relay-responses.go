@@ -0,0 +1,477 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the Responses API of Azure is only in the preview API versions
+const defaultAzureResponsesAPIVersion = "2025-03-01-preview"
+
+// the events of the Responses and the Assistants APIs carry the whole objects when they are created and done
+const maxEventSize = 16 * 1024 * 1024
+
+// ResponsesRequest is the part of a request of the Responses API used for its validation and its billing, the request
+// is relayed as it is, see https://platform.openai.com/docs/api-reference/responses/create
+type ResponsesRequest struct {
+	Model           string `json:"model"`
+	Input           any    `json:"input"`
+	Instructions    string `json:"instructions"`
+	Tools           any    `json:"tools"`
+	Stream          bool   `json:"stream"`
+	Background      bool   `json:"background"`
+	MaxOutputTokens int    `json:"max_output_tokens"`
+}
+
+type ResponsesUsage struct {
+	InputTokens        int `json:"input_tokens"`
+	OutputTokens       int `json:"output_tokens"`
+	TotalTokens        int `json:"total_tokens"`
+	InputTokensDetails *struct {
+		CachedTokens int `json:"cached_tokens"`
+	} `json:"input_tokens_details,omitempty"`
+}
+
+type ResponsesOutputItem struct {
+	Type    string `json:"type"`
+	Content []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	} `json:"content,omitempty"`
+	Arguments string `json:"arguments,omitempty"`
+}
+
+// ResponsesResponse is the part of a response, or of the response in a streaming event, used for the billing
+type ResponsesResponse struct {
+	Id     string                `json:"id"`
+	Store  *bool                 `json:"store,omitempty"`
+	Output []ResponsesOutputItem `json:"output"`
+	Usage  *ResponsesUsage       `json:"usage"`
+}
+
+type ResponsesStreamEvent struct {
+	Type     string             `json:"type"`
+	Delta    any                `json:"delta,omitempty"`
+	Response *ResponsesResponse `json:"response,omitempty"`
+}
+
+func (usage *ResponsesUsage) toUsage() *Usage {
+	if usage == nil || usage.InputTokens+usage.OutputTokens == 0 {
+		return nil
+	}
+	converted := &Usage{
+		PromptTokens:     usage.InputTokens,
+		CompletionTokens: usage.OutputTokens,
+		TotalTokens:      usage.InputTokens + usage.OutputTokens,
+	}
+	if usage.InputTokensDetails != nil && usage.InputTokensDetails.CachedTokens > 0 {
+		converted.PromptTokensDetails = &PromptTokensDetails{CachedTokens: usage.InputTokensDetails.CachedTokens}
+	}
+	return converted
+}
+
+// getOutputText is the text of the output items, for the upstreams which report no usage
+func (response *ResponsesResponse) getOutputText() string {
+	text := ""
+	for _, item := range response.Output {
+		for _, content := range item.Content {
+			text += content.Text
+		}
+		text += item.Arguments
+	}
+	return text
+}
+
+// getResponsesInputText is the text of the input items for the estimate of the prompt tokens, such as the messages,
+// the function calls and their outputs
+func getResponsesInputText(input any) string {
+	switch input := input.(type) {
+	case string:
+		return input
+	case []any:
+		text := ""
+		for _, item := range input {
+			text += getResponsesInputText(item)
+		}
+		return text
+	case map[string]any:
+		text := ""
+		for _, key := range []string{"text", "content", "arguments", "output"} {
+			text += getResponsesInputText(input[key])
+		}
+		return text
+	}
+	return ""
+}
+
+// getUpstreamRequestURL is the URL of the request on the channel, with its query such as the pagination of the lists,
+// the model picks the API version of the Azure deployment, its default is replaced by the preview one of the API
+func getUpstreamRequestURL(c *gin.Context, modelName string, azurePreviewAPIVersion string) string {
+	baseURL := common.ChannelBaseURLs[c.GetInt("channel")]
+	if c.GetString("base_url") != "" {
+		baseURL = c.GetString("base_url")
+	}
+	if c.GetInt("channel") != common.ChannelTypeAzure {
+		return fmt.Sprintf("%s%s", baseURL, c.Request.URL.String())
+	}
+	query := c.Request.URL.Query()
+	if query.Get("api-version") == "" {
+		_, apiVersion := resolveAzureDeployment(c.GetString("azure_deployments"), c.GetString("api_version"), modelName)
+		if apiVersion == defaultAzureAPIVersion {
+			apiVersion = azurePreviewAPIVersion
+		}
+		query.Set("api-version", apiVersion)
+	}
+	return fmt.Sprintf("%s/openai%s?%s", baseURL, strings.TrimPrefix(c.Request.URL.Path, "/v1"), query.Encode())
+}
+
+func newUpstreamRequest(c *gin.Context, fullRequestURL string, requestBody io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.Request.Context(), c.Request.Method, fullRequestURL, requestBody)
+	if err != nil {
+		return nil, err
+	}
+	apiKey := strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer ")
+	if c.GetInt("channel") == common.ChannelTypeAzure {
+		req.Header.Set("api-key", apiKey)
+	} else {
+		setBearerAuth(req, apiKey)
+	}
+	req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
+	req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+	if beta := c.Request.Header.Get("OpenAI-Beta"); beta != "" {
+		req.Header.Set("OpenAI-Beta", beta)
+	}
+	setChannelHeaders(req, c.GetString("channel_headers"))
+	setPolicyHeaders(c, req)
+	return req, nil
+}
+
+func relayResponsesHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	channelType := c.GetInt("channel")
+	if getAPIType(channelType) != APITypeOpenAI {
+		// the upstreams of their own formats have no Responses API, another channel of the model may have
+		return errorWrapper(fmt.Errorf("channel type %d does not support the Responses API", channelType), "api_not_implemented", http.StatusNotImplemented)
+	}
+	if c.Request.Method != http.MethodPost || c.Request.URL.Path != "/v1/responses" {
+		return relayResponsesObject(c)
+	}
+	tokenId := c.GetInt("token_id")
+	userId := c.GetInt("id")
+	consumeQuota := c.GetBool("consume_quota")
+	group := c.GetString("group")
+
+	var responsesRequest ResponsesRequest
+	err := common.UnmarshalBodyReusable(c, &responsesRequest)
+	if err != nil {
+		return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+	}
+	if responsesRequest.Model == "" {
+		return errorWrapper(errors.New("model is required"), "required_field_missing", http.StatusBadRequest)
+	}
+	// a background response is done after the request, there would be no usage to bill
+	if responsesRequest.Background {
+		return errorWrapper(errors.New("background responses are not supported"), "invalid_field_value", http.StatusBadRequest)
+	}
+
+	// map model name
+	modelMapping := c.GetString("model_mapping")
+	if modelMapping != "" {
+		modelMap := make(map[string]string)
+		err := json.Unmarshal([]byte(modelMapping), &modelMap)
+		if err != nil {
+			return errorWrapper(err, "unmarshal_model_mapping_failed", http.StatusInternalServerError)
+		}
+		if modelMap[responsesRequest.Model] != "" {
+			responsesRequest.Model = modelMap[responsesRequest.Model]
+			err = replaceRequestModel(c, responsesRequest.Model)
+			if err != nil {
+				return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+			}
+		}
+	}
+	fullRequestURL := getUpstreamRequestURL(c, responsesRequest.Model, defaultAzureResponsesAPIVersion)
+	if channelType == common.ChannelTypeAzure {
+		// the model of a request to Azure is the deployment
+		deployment, _ := resolveAzureDeployment(c.GetString("azure_deployments"), c.GetString("api_version"), responsesRequest.Model)
+		err = replaceRequestModel(c, deployment)
+		if err != nil {
+			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+	}
+
+	promptTokens := countTokenText(responsesRequest.Instructions+getResponsesInputText(responsesRequest.Input), responsesRequest.Model)
+	if responsesRequest.Tools != nil {
+		tools, _ := json.Marshal(responsesRequest.Tools)
+		promptTokens += countTokenText(string(tools), responsesRequest.Model)
+	}
+	preConsumedTokens := common.PreConsumedQuota
+	if responsesRequest.MaxOutputTokens != 0 {
+		preConsumedTokens = promptTokens + responsesRequest.MaxOutputTokens
+	}
+	modelRatio := common.GetModelRatio(responsesRequest.Model)
+	groupRatio := common.GetGroupRatio(group)
+	ratio := modelRatio * groupRatio
+	preConsumedQuota := int(float64(preConsumedTokens) * ratio)
+	userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
+	if err != nil {
+		return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
+	}
+	if userQuota > 10*preConsumedQuota {
+		// in this case, we do not pre-consume quota
+		// because the user has enough quota
+		preConsumedQuota = 0
+	}
+	var reservation *model.Reservation
+	if consumeQuota && preConsumedQuota > 0 {
+		reservation, err = model.ReserveQuota(tokenId, preConsumedQuota)
+		if model.IsQuotaInsufficientError(err) {
+			return quotaExhaustedErrorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+		}
+		if err != nil {
+			return errorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
+		}
+	}
+
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	req, err := newUpstreamRequest(c, fullRequestURL, bytes.NewReader(requestBody))
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		_ = model.SettleQuota(reservation, tokenId, 0)
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	// the failed requests are returned as errors, so that they fail over to the other channels
+	if resp.StatusCode != http.StatusOK {
+		_ = model.SettleQuota(reservation, tokenId, 0)
+		return getOpenAIResponseError(resp)
+	}
+
+	var response *ResponsesResponse
+	var usage Usage
+	channelId := c.GetInt("channel_id")
+	channelKeyHash := c.GetString("channel_key_hash")
+	requestModel := c.GetString("request_model")
+	defer func() {
+		c.Set("relay_usage", usage)
+		settlement := getQuotaSettlement(c)
+		settlement.reservation = reservation
+		go func() {
+			// the stored responses are continued and retrieved with the channel and the key which created them
+			if response != nil && response.Id != "" && (response.Store == nil || *response.Store) {
+				model.RecordUpstreamObject(&model.UpstreamObject{
+					Id:        response.Id,
+					Type:      model.UpstreamObjectTypeResponse,
+					UserId:    userId,
+					ChannelId: channelId,
+					KeyHash:   channelKeyHash,
+					Model:     requestModel,
+				})
+			}
+			model.RecordChannelTokens(channelId, requestModel, usage.PromptTokens+usage.CompletionTokens)
+			completionRatio := getCompletionRatio(responsesRequest.Model)
+			// the budgets of the channel count the list price, without the group ratio and the discounts
+			spend := (getPromptQuota(usage, responsesRequest.Model) + float64(usage.CompletionTokens)*completionRatio) * modelRatio
+			model.RecordChannelSpend(channelId, int(spend))
+			quota := 0
+			if consumeQuota {
+				quota = int((getPromptQuota(usage, responsesRequest.Model) + float64(usage.CompletionTokens)*completionRatio) * ratio)
+				if ratio != 0 && quota <= 0 {
+					quota = 1
+				}
+				if usage.PromptTokens+usage.CompletionTokens == 0 {
+					// in this case, must be some error happened
+					quota = 0
+				}
+				chargeLog := ""
+				if quota != 0 {
+					quota, chargeLog = applyModelCharges(responsesRequest.Model, quota, groupRatio)
+					var couponLog string
+					quota, couponLog = applyUserCoupon(userId, responsesRequest.Model, quota)
+					chargeLog += couponLog
+				}
+				logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
+				logContent += getPromptCacheLog(usage, responsesRequest.Model)
+				logContent += chargeLog
+				settlement.settle(responsesRequest.Model, usage.PromptTokens, usage.CompletionTokens, quota, logContent)
+			}
+			if usage.PromptTokens+usage.CompletionTokens > 0 {
+				cost, priced := common.GetUpstreamCost(responsesRequest.Model, usage.PromptTokens, usage.CompletionTokens)
+				model.RecordChannelUsage(channelId, responsesRequest.Model, usage.PromptTokens, usage.CompletionTokens, quota, cost, priced)
+			}
+		}()
+	}()
+
+	if responsesRequest.Stream {
+		var responseText string
+		var errWithStatusCode *OpenAIErrorWithStatusCode
+		errWithStatusCode, responseText, response = responsesStreamHandler(c, resp)
+		var reportedUsage *Usage
+		if response != nil {
+			reportedUsage = response.Usage.toUsage()
+		}
+		// the aborted streams have no usage and no text, they are not billed
+		if reportedUsage != nil || responseText != "" {
+			usage = reconcileStreamUsage(responsesRequest.Model, promptTokens, responseText, reportedUsage)
+		}
+		return errWithStatusCode
+	}
+	var errWithStatusCode *OpenAIErrorWithStatusCode
+	errWithStatusCode, response = responsesHandler(c, resp)
+	if response != nil {
+		if reportedUsage := response.Usage.toUsage(); reportedUsage != nil {
+			usage = *reportedUsage
+		} else {
+			completionTokens := countTokenText(response.getOutputText(), responsesRequest.Model)
+			usage = Usage{PromptTokens: promptTokens, CompletionTokens: completionTokens, TotalTokens: promptTokens + completionTokens}
+		}
+	}
+	return errWithStatusCode
+}
+
+func responsesHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, *ResponsesResponse) {
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return errorWrapper(err, "read_response_body_failed", http.StatusInternalServerError), nil
+	}
+	err = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), nil
+	}
+	var response ResponsesResponse
+	err = json.Unmarshal(responseBody, &response)
+	if err != nil {
+		return errorWrapper(err, "unmarshal_response_body_failed", http.StatusInternalServerError), nil
+	}
+	copyResponseHeaders(c, resp)
+	c.Writer.WriteHeader(resp.StatusCode)
+	_, err = c.Writer.Write(responseBody)
+	if err != nil {
+		return errorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError), &response
+	}
+	return nil, &response
+}
+
+// responsesStreamHandler relays the events of a response as they are, the response is the one of the last event
+// which has it, its usage is reported when it is done, and the text deltas are kept for the upstreams which report none
+func responsesStreamHandler(c *gin.Context, resp *http.Response) (*OpenAIErrorWithStatusCode, string, *ResponsesResponse) {
+	responseText := ""
+	var response *ResponsesResponse
+	err := relayEventStream(c, resp, func(data string) {
+		var streamEvent ResponsesStreamEvent
+		err := json.Unmarshal([]byte(data), &streamEvent)
+		if err != nil {
+			common.SysError("error unmarshalling stream response: " + err.Error())
+			return
+		}
+		if delta, ok := streamEvent.Delta.(string); ok && streamEvent.Type == "response.output_text.delta" {
+			responseText += delta
+		}
+		if streamEvent.Response != nil {
+			response = streamEvent.Response
+		}
+	})
+	if err != nil {
+		return errorWrapper(err, "close_response_body_failed", http.StatusInternalServerError), "", nil
+	}
+	return nil, responseText, response
+}
+
+// relayEventStream relays the named events of an upstream, such as the ones of the Responses and the Assistants APIs,
+// an event is sent as a whole so that the heartbeats never split it, and its data is handed to onData
+func relayEventStream(c *gin.Context, resp *http.Response, onData func(data string)) error {
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), maxEventSize)
+	setEventStreamHeaders(c)
+	keeper := newStreamKeeper(c)
+	eventChan := make(chan string)
+	stopChan := make(chan bool)
+	go func() {
+		defer close(stopChan)
+		event := ""
+		for scanner.Scan() {
+			line := strings.TrimSuffix(scanner.Text(), "\r")
+			if line == "" {
+				if event != "" {
+					select {
+					case eventChan <- event:
+					case <-keeper.Done():
+						return
+					}
+					event = ""
+				}
+				continue
+			}
+			event += line + "\n"
+			if strings.HasPrefix(line, "data: ") {
+				onData(line[6:])
+			}
+		}
+		if event != "" {
+			select {
+			case eventChan <- event:
+			case <-keeper.Done():
+				return
+			}
+		}
+	}()
+	c.Stream(func(w io.Writer) bool {
+		select {
+		case event := <-eventChan:
+			keeper.Touch()
+			_, _ = io.WriteString(w, event+"\n")
+			return true
+		case <-keeper.Tick():
+			return keeper.OnTick(w)
+		case <-stopChan:
+			return false
+		}
+	})
+	return keeper.Finish(resp.Body, stopChan)
+}
+
+// relayResponsesObject relays the requests about a stored response, such as retrieving, cancelling or deleting it and
+// listing its input items, which are not billed
+func relayResponsesObject(c *gin.Context) *OpenAIErrorWithStatusCode {
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return errorWrapper(err, "read_request_body_failed", http.StatusBadRequest)
+	}
+	req, err := newUpstreamRequest(c, getUpstreamRequestURL(c, c.GetString("request_model"), defaultAzureResponsesAPIVersion), bytes.NewReader(requestBody))
+	if err != nil {
+		return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
+	}
+	resp, err := getRelayHTTPClient(c).Do(req)
+	if err != nil {
+		return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return getOpenAIResponseError(resp)
+	}
+	if c.Request.Method == http.MethodDelete {
+		model.DeleteUpstreamObject(c.Param("response_id"))
+	}
+	copyResponseHeaders(c, resp)
+	c.Writer.WriteHeader(resp.StatusCode)
+	// the retrieval of a response may be streamed
+	err = copyFlushing(c.Writer, resp.Body)
+	_ = resp.Body.Close()
+	if err != nil {
+		return errorWrapper(err, "copy_response_body_failed", http.StatusInternalServerError)
+	}
+	return nil
+}
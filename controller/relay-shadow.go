@@ -0,0 +1,130 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"one-api/common"
+	"one-api/middleware"
+	"one-api/model"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const shadowRequestTimeout = 5 * time.Minute
+
+// the shadow requests beyond it are dropped rather than piling up when the shadow channel is slow
+var shadowRequestSlots = make(chan struct{}, 64)
+
+var shadowRequestPaths = map[int]string{
+	RelayModeChatCompletions: "/v1/chat/completions",
+	RelayModeCompletions:     "/v1/completions",
+	RelayModeEmbeddings:      "/v1/embeddings",
+	RelayModeModerations:     "/v1/moderations",
+}
+
+// mirrorToShadowChannel sends a copy of a successful request to the shadow channel of the channel which served it
+// with the shadow rate of the channel, the copy is not billed to the user and its response is dropped, nor sent to a
+// shadow channel outside the data residency of the request
+func mirrorToShadowChannel(c *gin.Context, relayMode int, requestBody []byte) {
+	shadowChannelId := c.GetInt("shadow_channel_id")
+	if shadowChannelId == 0 || c.GetInt("shadow_rate") <= rand.Intn(100) {
+		return
+	}
+	path, ok := shadowRequestPaths[relayMode]
+	if !ok {
+		return
+	}
+	shadowChannel, err := model.GetChannelById(shadowChannelId, true)
+	if err != nil || shadowChannel.Status != common.ChannelStatusEnabled || !middleware.IsChannelCompliant(c, shadowChannel) {
+		return
+	}
+	select {
+	case shadowRequestSlots <- struct{}{}:
+	default:
+		return
+	}
+	modelName := c.GetString("request_model")
+	go func() {
+		defer func() { <-shadowRequestSlots }()
+		err := sendShadowRequest(shadowChannel, modelName, path, requestBody)
+		if err != nil {
+			common.SysError(fmt.Sprintf("shadow request to channel #%d failed: %s", shadowChannelId, err.Error()))
+		}
+	}()
+}
+
+// sendShadowRequest measures the shadow channel like a channel serving the request, for its latency and error rate
+// and its spend and cost, the streams are requested without streaming to get the usage
+func sendShadowRequest(channel *model.Channel, modelName string, path string, requestBody []byte) error {
+	var request map[string]any
+	err := json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return err
+	}
+	upstreamModel := modelName
+	if channel.ModelMapping != "" {
+		modelMapping := make(map[string]string)
+		err = json.Unmarshal([]byte(channel.ModelMapping), &modelMapping)
+		if err != nil {
+			return err
+		}
+		if modelMapping[modelName] != "" {
+			upstreamModel = modelMapping[modelName]
+		}
+	}
+	request["model"] = upstreamModel
+	if _, ok := request["stream"]; ok {
+		request["stream"] = false
+		delete(request, "stream_options")
+	}
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	baseURL := common.ChannelBaseURLs[channel.Type]
+	if channel.BaseURL != "" {
+		baseURL = channel.BaseURL
+	}
+	req, err := http.NewRequest("POST", baseURL+path, bytes.NewBuffer(jsonData))
+	if err != nil {
+		return err
+	}
+	key, _ := model.PickChannelKey(channel)
+	setBearerAuth(req, key)
+	req.Header.Set("Content-Type", "application/json")
+	setChannelHeaders(req, channel.Headers)
+	client := *getHTTPClient(channel.Proxy)
+	client.Timeout = shadowRequestTimeout
+	startTime := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		model.RecordChannelLatency(channel.Id, modelName, time.Since(startTime).Milliseconds(), false)
+		return err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	latency := time.Since(startTime).Milliseconds()
+	if err != nil || resp.StatusCode != http.StatusOK {
+		model.RecordChannelLatency(channel.Id, modelName, latency, false)
+		if err != nil {
+			return err
+		}
+		return fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	model.RecordChannelLatency(channel.Id, modelName, latency, true)
+	var response TextResponse
+	err = json.Unmarshal(body, &response)
+	if err != nil || response.Usage.PromptTokens+response.Usage.CompletionTokens == 0 {
+		return err
+	}
+	spend := (getPromptQuota(response.Usage, upstreamModel) + float64(response.Usage.CompletionTokens)*getCompletionRatio(upstreamModel)) * common.GetModelRatio(upstreamModel)
+	model.RecordChannelSpend(channel.Id, int(spend))
+	cost, priced := common.GetUpstreamCost(upstreamModel, response.Usage.PromptTokens, response.Usage.CompletionTokens)
+	model.RecordChannelUsage(channel.Id, upstreamModel, response.Usage.PromptTokens, response.Usage.CompletionTokens, 0, cost, priced)
+	return nil
+}
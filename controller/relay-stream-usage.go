@@ -0,0 +1,138 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// streamUsageChunk is the part of a chunk of a chat or completions stream which tells the usage chunk apart
+type streamUsageChunk struct {
+	Id      string `json:"id"`
+	Object  string `json:"object"`
+	Created int64  `json:"created"`
+	Model   string `json:"model"`
+	Choices []any  `json:"choices"`
+	Usage   *Usage `json:"usage,omitempty"`
+}
+
+// streamUsageWriter makes the usage of a stream the last chunk before [DONE] when the client asks for it with
+// stream_options.include_usage, and drops the usage chunk the upstream sends only because the gateway asked for it,
+// the events are handled as a whole so that the heartbeats pass as they are
+type streamUsageWriter struct {
+	gin.ResponseWriter
+	includeUsage bool
+	body         bytes.Buffer
+	lastChunk    streamUsageChunk
+	sentUsage    bool // the upstream sent its own usage chunk
+	done         bool // [DONE] is held back until the usage is known
+}
+
+func newStreamUsageWriter(writer gin.ResponseWriter, includeUsage bool) *streamUsageWriter {
+	return &streamUsageWriter{ResponseWriter: writer, includeUsage: includeUsage}
+}
+
+func (w *streamUsageWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *streamUsageWriter) Write(data []byte) (int, error) {
+	if !strings.HasPrefix(w.Header().Get("Content-Type"), "text/event-stream") {
+		// the errors of the upstream are not streamed
+		return w.ResponseWriter.Write(data)
+	}
+	w.body.Write(data)
+	for {
+		buffered := w.body.Bytes()
+		end := bytes.Index(buffered, []byte("\n\n"))
+		if end < 0 {
+			return len(data), nil
+		}
+		event := string(buffered[:end+2])
+		w.body.Next(end + 2)
+		w.writeEvent(event)
+	}
+}
+
+func (w *streamUsageWriter) writeEvent(event string) {
+	line := strings.TrimSpace(event)
+	if line == "data: [DONE]" {
+		w.done = true
+		return
+	}
+	if strings.HasPrefix(line, "data: ") {
+		var chunk streamUsageChunk
+		if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &chunk) == nil && chunk.Object != "" {
+			if chunk.Usage != nil && len(chunk.Choices) == 0 {
+				if !w.includeUsage {
+					return
+				}
+				w.sentUsage = true
+			}
+			w.lastChunk = chunk
+		}
+	}
+	_, _ = io.WriteString(w.ResponseWriter, event)
+}
+
+// finish sends the usage billed by the relay when the upstream sent none, and then the [DONE] held back, nothing is
+// sent when the stream did not end, such as after the client is gone
+func (w *streamUsageWriter) finish(usage Usage) {
+	if !w.done {
+		return
+	}
+	if w.includeUsage && !w.sentUsage && w.lastChunk.Object != "" {
+		chunk := w.lastChunk
+		chunk.Choices = []any{}
+		chunk.Usage = &usage
+		jsonStr, err := json.Marshal(chunk)
+		if err == nil {
+			_, _ = io.WriteString(w.ResponseWriter, "data: "+string(jsonStr)+"\n\n")
+		}
+	}
+	_, _ = io.WriteString(w.ResponseWriter, "data: [DONE]\n\n")
+	w.ResponseWriter.Flush()
+}
+
+// setRequestStreamUsage asks the upstream for the usage of the stream, so that it is billed by the counts of the
+// upstream rather than by counting the completion locally
+func setRequestStreamUsage(c *gin.Context) error {
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return err
+	}
+	streamOptions, _ := request["stream_options"].(map[string]any)
+	if streamOptions == nil {
+		streamOptions = map[string]any{}
+	}
+	streamOptions["include_usage"] = true
+	request["stream_options"] = streamOptions
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return nil
+}
+
+// shouldRequestStreamUsage tells whether the upstream is asked for the usage of the stream, the older Azure API
+// versions refuse stream_options so Azure gets it only when the client sent it
+func shouldRequestStreamUsage(c *gin.Context, apiType int, relayMode int, includeUsage bool) bool {
+	if apiType != APITypeOpenAI || includeUsage || !common.StreamUsageRequestEnabled {
+		return false
+	}
+	if relayMode != RelayModeChatCompletions && relayMode != RelayModeCompletions {
+		return false
+	}
+	return c.GetInt("channel") != common.ChannelTypeAzure
+}
@@ -0,0 +1,121 @@
+package controller
+
+import (
+	"fmt"
+	"io"
+	"one-api/common"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var sdkUserAgentKeywords = []string{"openai", "anthropic", "langchain", "python-requests", "python-httpx", "aiohttp", "axios", "node-fetch", "undici", "go-http-client", "okhttp"}
+
+// detectStreamClient guesses the type of client, browsers send Sec-Fetch-* headers and official SDKs send X-Stainless-*
+func detectStreamClient(c *gin.Context) string {
+	if c.Request.Header.Get("X-Stainless-Lang") != "" {
+		return common.StreamClientSDK
+	}
+	userAgent := strings.ToLower(c.Request.Header.Get("User-Agent"))
+	if strings.HasPrefix(userAgent, "curl/") || strings.HasPrefix(userAgent, "wget/") || strings.HasPrefix(userAgent, "httpie/") {
+		return common.StreamClientCurl
+	}
+	for _, keyword := range sdkUserAgentKeywords {
+		if strings.Contains(userAgent, keyword) {
+			return common.StreamClientSDK
+		}
+	}
+	if c.Request.Header.Get("Sec-Fetch-Mode") != "" || strings.HasPrefix(userAgent, "mozilla/") {
+		return common.StreamClientBrowser
+	}
+	return common.StreamClientOther
+}
+
+// streamKeeper sends heartbeats and enforces the idle timeout of a stream,
+// the stream handlers must call Touch whenever they get data from the upstream
+type streamKeeper struct {
+	setting   common.StreamSetting
+	ticker    *time.Ticker
+	lastData  time.Time
+	lastWrite time.Time
+	done      chan struct{}
+	stopped   bool
+}
+
+func newStreamKeeper(c *gin.Context) *streamKeeper {
+	clientType := detectStreamClient(c)
+	keeper := &streamKeeper{
+		setting:   common.GetStreamSetting(c.GetString("group"), clientType),
+		lastData:  time.Now(),
+		lastWrite: time.Now(),
+		done:      make(chan struct{}),
+	}
+	keeper.setting.Heartbeat = resolveIntOption(c, "StreamHeartbeat", keeper.setting.Heartbeat)
+	keeper.setting.IdleTimeout = resolveIntOption(c, "StreamIdleTimeout", keeper.setting.IdleTimeout)
+	if keeper.setting.Heartbeat > 0 || keeper.setting.IdleTimeout > 0 {
+		keeper.ticker = time.NewTicker(time.Second)
+	}
+	if keeper.setting.Retry > 0 {
+		_, _ = fmt.Fprintf(c.Writer, "retry: %d\n\n", keeper.setting.Retry)
+	}
+	if keeper.setting.Padding > 0 {
+		_, _ = fmt.Fprintf(c.Writer, ":%s\n\n", strings.Repeat(" ", keeper.setting.Padding))
+	}
+	if keeper.setting.Retry > 0 || keeper.setting.Padding > 0 {
+		c.Writer.Flush()
+	}
+	return keeper
+}
+
+// Tick returns nil when there is nothing to keep, receiving from it then blocks forever
+func (keeper *streamKeeper) Tick() <-chan time.Time {
+	if keeper.ticker == nil {
+		return nil
+	}
+	return keeper.ticker.C
+}
+
+func (keeper *streamKeeper) Touch() {
+	keeper.lastData = time.Now()
+	keeper.lastWrite = keeper.lastData
+}
+
+// OnTick returns false when the stream should be closed
+func (keeper *streamKeeper) OnTick(w io.Writer) bool {
+	if keeper.setting.IdleTimeout > 0 && time.Since(keeper.lastData) >= time.Duration(keeper.setting.IdleTimeout)*time.Second {
+		common.SysError(fmt.Sprintf("stream closed after being idle for %d seconds", keeper.setting.IdleTimeout))
+		return false
+	}
+	if keeper.setting.Heartbeat > 0 && time.Since(keeper.lastWrite) >= time.Duration(keeper.setting.Heartbeat)*time.Second {
+		_, _ = io.WriteString(w, ": keepalive\n\n")
+		keeper.lastWrite = time.Now()
+	}
+	return true
+}
+
+// Done is closed when the stream ends, the readers of the upstream stop sending to the handler then
+func (keeper *streamKeeper) Done() <-chan struct{} {
+	return keeper.done
+}
+
+func (keeper *streamKeeper) Stop() {
+	if keeper.stopped {
+		return
+	}
+	keeper.stopped = true
+	close(keeper.done)
+	if keeper.ticker != nil {
+		keeper.ticker.Stop()
+	}
+}
+
+// Finish ends the stream and waits for the reader of the upstream to exit, which closes readerDone, the body is
+// closed first so that the reader stops even when the client is gone before the upstream finished, what the reader
+// got until then is billed
+func (keeper *streamKeeper) Finish(body io.Closer, readerDone <-chan bool) error {
+	keeper.Stop()
+	err := body.Close()
+	<-readerDone
+	return err
+}
@@ -0,0 +1,317 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// isStructuredOutput tells whether the request asks for a JSON output
+func isStructuredOutput(format *ResponseFormat) bool {
+	return format != nil && (format.Type == "json_object" || format.Type == "json_schema")
+}
+
+// getResponseFormatSchema returns the schema of the output, nil when any JSON object is accepted
+func getResponseFormatSchema(format *ResponseFormat) map[string]any {
+	if format == nil || format.Type != "json_schema" || format.JsonSchema == nil {
+		return nil
+	}
+	schema, _ := format.JsonSchema.Schema.(map[string]any)
+	return schema
+}
+
+// getResponseFormatInstruction is the system prompt which asks for the response format from the upstreams without one
+func getResponseFormatInstruction(format *ResponseFormat) string {
+	if !isStructuredOutput(format) {
+		return ""
+	}
+	instruction := "Respond with a JSON object only, without any other text or code fences."
+	if schema := getResponseFormatSchema(format); schema != nil {
+		schemaJson, err := json.Marshal(schema)
+		if err == nil {
+			instruction += " The JSON object must conform to this JSON schema: " + string(schemaJson)
+		}
+	}
+	return instruction
+}
+
+// repairJSONOutput strips what the models tend to put around the JSON, such as the code fences and the explanations
+func repairJSONOutput(text string) string {
+	text = strings.TrimSpace(text)
+	if strings.HasPrefix(text, "```") {
+		text = strings.TrimPrefix(text, "```")
+		text = strings.TrimPrefix(text, "json")
+		text = strings.TrimSuffix(strings.TrimSpace(text), "```")
+		text = strings.TrimSpace(text)
+	}
+	if start, end := strings.Index(text, "{"), strings.LastIndex(text, "}"); start >= 0 && end > start {
+		text = text[start : end+1]
+	}
+	return text
+}
+
+// validateJSONOutput parses the output and checks it against the schema, the repaired output is returned when the
+// output as is fails but the repaired one passes
+func validateJSONOutput(text string, schema map[string]any) (string, error) {
+	var value any
+	err := json.Unmarshal([]byte(text), &value)
+	if err == nil {
+		err = validateJSONSchema(value, schema, schema, "$")
+	} else {
+		err = fmt.Errorf("the output is not valid JSON: %s", err.Error())
+	}
+	if err == nil {
+		return text, nil
+	}
+	repaired := repairJSONOutput(text)
+	if repaired == text {
+		return "", err
+	}
+	if json.Unmarshal([]byte(repaired), &value) != nil || validateJSONSchema(value, schema, schema, "$") != nil {
+		return "", err
+	}
+	return repaired, nil
+}
+
+// resolveJSONSchemaRef looks up the local references such as #/$defs/name
+func resolveJSONSchemaRef(root map[string]any, ref string) (map[string]any, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("reference %s is not supported", ref)
+	}
+	var node any = root
+	for _, name := range strings.Split(strings.TrimPrefix(strings.TrimPrefix(ref, "#"), "/"), "/") {
+		if name == "" {
+			continue
+		}
+		object, _ := node.(map[string]any)
+		node = object[name]
+	}
+	schema, ok := node.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("reference %s is not found", ref)
+	}
+	return schema, nil
+}
+
+func getJSONType(value any) string {
+	switch value := value.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "boolean"
+	case float64:
+		if value == math.Trunc(value) {
+			return "integer"
+		}
+		return "number"
+	case string:
+		return "string"
+	case []any:
+		return "array"
+	case map[string]any:
+		return "object"
+	}
+	return ""
+}
+
+func matchJSONType(value any, schemaType any) bool {
+	valueType := getJSONType(value)
+	var types []any
+	switch schemaType := schemaType.(type) {
+	case string:
+		types = []any{schemaType}
+	case []any:
+		types = schemaType
+	default:
+		return true
+	}
+	for _, t := range types {
+		if t == valueType || (t == "number" && valueType == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// validateJSONSchema checks the value against the keywords of the JSON schema which the structured outputs use,
+// the other keywords are not checked, a nil schema accepts any JSON object
+func validateJSONSchema(value any, schema map[string]any, root map[string]any, path string) error {
+	if schema == nil {
+		if _, ok := value.(map[string]any); !ok {
+			return errors.New("the output is not a JSON object")
+		}
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		refSchema, err := resolveJSONSchemaRef(root, ref)
+		if err != nil {
+			return err
+		}
+		return validateJSONSchema(value, refSchema, root, path)
+	}
+	if schemaType, ok := schema["type"]; ok && !matchJSONType(value, schemaType) {
+		return fmt.Errorf("%s must be of type %v", path, schemaType)
+	}
+	if enum, ok := schema["enum"].([]any); ok {
+		found := false
+		for _, item := range enum {
+			if fmt.Sprint(item) == fmt.Sprint(value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("%s must be one of %v", path, enum)
+		}
+	}
+	if constValue, ok := schema["const"]; ok && fmt.Sprint(constValue) != fmt.Sprint(value) {
+		return fmt.Errorf("%s must be %v", path, constValue)
+	}
+	if anyOf, ok := schema["anyOf"].([]any); ok {
+		var err error
+		for _, item := range anyOf {
+			itemSchema, _ := item.(map[string]any)
+			if err = validateJSONSchema(value, itemSchema, root, path); err == nil {
+				break
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("%s matches none of the schemas of anyOf", path)
+		}
+	}
+	switch value := value.(type) {
+	case map[string]any:
+		properties, _ := schema["properties"].(map[string]any)
+		if required, ok := schema["required"].([]any); ok {
+			for _, name := range required {
+				if _, ok := value[fmt.Sprint(name)]; !ok {
+					return fmt.Errorf("%s.%v is required", path, name)
+				}
+			}
+		}
+		for name, item := range value {
+			propertySchema, ok := properties[name].(map[string]any)
+			if !ok {
+				switch additional := schema["additionalProperties"].(type) {
+				case bool:
+					if !additional {
+						return fmt.Errorf("%s.%s is not allowed", path, name)
+					}
+				case map[string]any:
+					propertySchema = additional
+				}
+			}
+			if propertySchema == nil {
+				continue
+			}
+			err := validateJSONSchema(item, propertySchema, root, path+"."+name)
+			if err != nil {
+				return err
+			}
+		}
+	case []any:
+		if minItems, ok := schema["minItems"].(float64); ok && float64(len(value)) < minItems {
+			return fmt.Errorf("%s must have at least %v items", path, minItems)
+		}
+		if maxItems, ok := schema["maxItems"].(float64); ok && float64(len(value)) > maxItems {
+			return fmt.Errorf("%s must have at most %v items", path, maxItems)
+		}
+		if itemSchema, ok := schema["items"].(map[string]any); ok {
+			for i, item := range value {
+				err := validateJSONSchema(item, itemSchema, root, fmt.Sprintf("%s[%d]", path, i))
+				if err != nil {
+					return err
+				}
+			}
+		}
+	case string:
+		if minLength, ok := schema["minLength"].(float64); ok && float64(len([]rune(value))) < minLength {
+			return fmt.Errorf("%s must be at least %v characters long", path, minLength)
+		}
+		if maxLength, ok := schema["maxLength"].(float64); ok && float64(len([]rune(value))) > maxLength {
+			return fmt.Errorf("%s must be at most %v characters long", path, maxLength)
+		}
+	case float64:
+		if minimum, ok := schema["minimum"].(float64); ok && value < minimum {
+			return fmt.Errorf("%s must be at least %v", path, minimum)
+		}
+		if maximum, ok := schema["maximum"].(float64); ok && value > maximum {
+			return fmt.Errorf("%s must be at most %v", path, maximum)
+		}
+	}
+	return nil
+}
+
+// relayStructuredOutput validates the JSON outputs of a chat request and asks the upstream again when they fail, with
+// the invalid output and the error appended to the messages, the returned usage covers all the attempts, the last
+// response is returned as is when none of the attempts passes
+func relayStructuredOutput(c *gin.Context, relayMode int, format *ResponseFormat) (Usage, string, *OpenAIErrorWithStatusCode) {
+	var usage Usage
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return usage, "", errorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return usage, "", errorWrapper(err, "unmarshal_request_body_failed", http.StatusBadRequest)
+	}
+	schema := getResponseFormatSchema(format)
+	messages, _ := request["messages"].([]any)
+	var response *OpenAITextResponse
+	for attempt := 0; attempt <= common.StructuredOutputRetryTimes; attempt++ {
+		request["messages"] = messages
+		attemptRequestBody, err := json.Marshal(request)
+		if err != nil {
+			return usage, "", errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+		attemptContext, recorder := newChoiceContext(c, attemptRequestBody)
+		var attemptUsage *Usage
+		var relayErr *OpenAIErrorWithStatusCode
+		response, attemptUsage, relayErr = doChoiceRequest(attemptContext, recorder, relayMode)
+		if attemptUsage != nil {
+			usage.PromptTokens += attemptUsage.PromptTokens
+			usage.CompletionTokens += attemptUsage.CompletionTokens
+			usage.TotalTokens += attemptUsage.TotalTokens
+		}
+		if relayErr != nil {
+			return usage, "", relayErr
+		}
+		var validationErr error
+		for i, choice := range response.Choices {
+			// the tool calls and the truncated outputs are not checked
+			if choice.FinishReason != "stop" {
+				continue
+			}
+			text := choice.Message.StringContent()
+			repaired, err := validateJSONOutput(text, schema)
+			if err != nil {
+				validationErr = err
+				messages = append(messages,
+					map[string]any{"role": "assistant", "content": text},
+					map[string]any{"role": "user", "content": fmt.Sprintf("The response is invalid: %s. Respond again with the corrected JSON only.", err.Error())},
+				)
+				break
+			}
+			response.Choices[i].Message.Content = repaired
+		}
+		if validationErr == nil {
+			break
+		}
+		common.SysLog(fmt.Sprintf("structured output attempt %d failed validation: %s", attempt+1, validationErr.Error()))
+	}
+	response.Usage = usage
+	var completionText strings.Builder
+	for _, choice := range response.Choices {
+		completionText.WriteString(choice.Message.StringContent())
+	}
+	c.JSON(http.StatusOK, response)
+	return usage, completionText.String(), nil
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"one-api/model"
+
+	"github.com/gin-gonic/gin"
+)
+
+// injectSystemPrompts puts the system prompts the admins set on the token and on the channel at the start of the
+// messages of a chat request, the one of the token first, the system and developer messages of the request are dropped
+// when either of them is in the override mode, it tells whether the request is changed
+func injectSystemPrompts(c *gin.Context) (bool, error) {
+	systemPrompts := [][2]string{
+		{c.GetString("token_system_prompt"), c.GetString("token_system_prompt_mode")},
+		{c.GetString("channel_system_prompt"), c.GetString("channel_system_prompt_mode")},
+	}
+	var messages []any
+	override := false
+	for _, systemPrompt := range systemPrompts {
+		if systemPrompt[0] == "" {
+			continue
+		}
+		messages = append(messages, map[string]any{"role": "system", "content": systemPrompt[0]})
+		override = override || systemPrompt[1] == model.SystemPromptModeOverride
+	}
+	if len(messages) == 0 {
+		return false, nil
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return false, err
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return false, err
+	}
+	requestMessages, _ := request["messages"].([]any)
+	for _, message := range requestMessages {
+		if object, ok := message.(map[string]any); ok && override && (object["role"] == "system" || object["role"] == "developer") {
+			continue
+		}
+		messages = append(messages, message)
+	}
+	request["messages"] = messages
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return false, err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return true, nil
+}
@@ -9,6 +9,7 @@ import (
 	"io"
 	"net/http"
 	"one-api/common"
+	"one-api/middleware"
 	"one-api/model"
 	"strings"
 	"time"
@@ -23,6 +24,8 @@ const (
 	APITypeAli
 	APITypeXunfei
 	APITypeMiniMax
+	APITypeGemini
+	APITypeBedrock
 )
 
 var httpClient *http.Client
@@ -35,7 +38,66 @@ func init() {
 	}
 }
 
+func getAPIType(channelType int) int {
+	switch channelType {
+	case common.ChannelTypeAnthropic:
+		return APITypeClaude
+	case common.ChannelTypeBaidu:
+		return APITypeBaidu
+	case common.ChannelTypePaLM:
+		return APITypePaLM
+	case common.ChannelTypeZhipu:
+		return APITypeZhipu
+	case common.ChannelTypeAli:
+		return APITypeAli
+	case common.ChannelTypeXunfei:
+		return APITypeXunfei
+	case common.ChannelTypeMiniMax:
+		return APITypeMiniMax
+	case common.ChannelTypeGemini:
+		return APITypeGemini
+	case common.ChannelTypeBedrock:
+		return APITypeBedrock
+	}
+	return APITypeOpenAI
+}
+
+// getCompletionRatio weights the completion tokens against the prompt tokens
+func getCompletionRatio(modelName string) float64 {
+	if strings.HasPrefix(modelName, "gpt-3.5") {
+		return 1.333333
+	}
+	// the realtime models, the output text costs 4 times the input text
+	if strings.Contains(modelName, "realtime") {
+		return 4
+	}
+	if strings.HasPrefix(modelName, "gpt-4") {
+		return 2
+	}
+	// the Messages API models, the output costs 5 times the input
+	if strings.HasPrefix(modelName, "claude-") && !strings.HasPrefix(modelName, "claude-2") && !strings.HasPrefix(modelName, "claude-instant") {
+		return 5
+	}
+	if strings.HasPrefix(modelName, "gemini-2.5-flash") {
+		return 8.333333
+	}
+	if strings.HasPrefix(modelName, "gemini-2.5-pro") {
+		return 8
+	}
+	if strings.HasPrefix(modelName, "gemini-") {
+		return 4
+	}
+	if strings.HasPrefix(modelName, "llama3-8b") {
+		return 2
+	}
+	if strings.HasPrefix(modelName, "llama3-70b") {
+		return 1.320755
+	}
+	return 1
+}
+
 func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
+	startTime := time.Now()
 	channelType := c.GetInt("channel")
 	tokenId := c.GetInt("token_id")
 	userId := c.GetInt("id")
@@ -75,7 +137,7 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			return errorWrapper(err, "error_field_input", http.StatusBadRequest)
 		}
 	case RelayModeModerations:
-		if textRequest.Input == "" {
+		if textRequest.Input == nil || textRequest.Input == "" {
 			return errorWrapper(errors.New("field input is required"), "required_field_missing", http.StatusBadRequest)
 		}
 	case RelayModeEdits:
@@ -83,6 +145,30 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			return errorWrapper(errors.New("field instruction is required"), "required_field_missing", http.StatusBadRequest)
 		}
 	}
+	choiceCount, bestOf := 1, 1
+	if relayMode == RelayModeChatCompletions || relayMode == RelayModeCompletions {
+		var err error
+		choiceCount, bestOf, err = getChoiceCount(textRequest)
+		if err != nil {
+			return errorWrapper(err, "invalid_n", http.StatusBadRequest)
+		}
+	}
+	isChoiceRequest := c.GetBool("choice_request")
+	if relayMode == RelayModeChatCompletions && !isChoiceRequest {
+		// the sub-requests of the choices have them already
+		injected, err := injectSystemPrompts(c)
+		if err != nil {
+			return errorWrapper(err, "inject_system_prompt_failed", http.StatusInternalServerError)
+		}
+		if injected {
+			var injectedRequest GeneralOpenAIRequest
+			err = common.UnmarshalBodyReusable(c, &injectedRequest)
+			if err != nil {
+				return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+			}
+			textRequest.Messages = injectedRequest.Messages
+		}
+	}
 	// map model name
 	modelMapping := c.GetString("model_mapping")
 	isModelMapped := false
@@ -97,23 +183,7 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			isModelMapped = true
 		}
 	}
-	apiType := APITypeOpenAI
-	switch channelType {
-	case common.ChannelTypeAnthropic:
-		apiType = APITypeClaude
-	case common.ChannelTypeBaidu:
-		apiType = APITypeBaidu
-	case common.ChannelTypePaLM:
-		apiType = APITypePaLM
-	case common.ChannelTypeZhipu:
-		apiType = APITypeZhipu
-	case common.ChannelTypeAli:
-		apiType = APITypeAli
-	case common.ChannelTypeXunfei:
-		apiType = APITypeXunfei
-	case common.ChannelTypeMiniMax:
-		apiType = APITypeMiniMax
-	}
+	apiType := getAPIType(channelType)
 	baseURL := common.ChannelBaseURLs[channelType]
 	requestURL := c.Request.URL.String()
 	if c.GetString("base_url") != "" {
@@ -124,28 +194,31 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 	case APITypeOpenAI:
 		if channelType == common.ChannelTypeAzure {
 			// https://learn.microsoft.com/en-us/azure/cognitive-services/openai/chatgpt-quickstart?pivots=rest-api&tabs=command-line#rest-api
-			query := c.Request.URL.Query()
-			apiVersion := query.Get("api-version")
-			if apiVersion == "" {
-				apiVersion = c.GetString("api_version")
-			}
-			requestURL := strings.Split(requestURL, "?")[0]
-			requestURL = fmt.Sprintf("%s?api-version=%s", requestURL, apiVersion)
-			baseURL = c.GetString("base_url")
-			task := strings.TrimPrefix(requestURL, "/v1/")
-			model_ := textRequest.Model
-			model_ = strings.Replace(model_, ".", "", -1)
-			// https://github.com/songquanpeng/one-api/issues/67
-			model_ = strings.TrimSuffix(model_, "-0301")
-			model_ = strings.TrimSuffix(model_, "-0314")
-			model_ = strings.TrimSuffix(model_, "-0613")
-			fullRequestURL = fmt.Sprintf("%s/openai/deployments/%s/%s", baseURL, model_, task)
+			task := strings.TrimPrefix(c.Request.URL.Path, "/v1/")
+			if relayMode == RelayModeEmbeddings {
+				// also for /v1/engines/:model/embeddings
+				task = "embeddings"
+			}
+			fullRequestURL = getAzureRequestURL(c, textRequest.Model, task)
 		}
 	case APITypeClaude:
-		fullRequestURL = "https://api.anthropic.com/v1/complete"
+		fullRequestURL = "https://api.anthropic.com/v1/messages"
 		if baseURL != "" {
-			fullRequestURL = fmt.Sprintf("%s/v1/complete", baseURL)
+			fullRequestURL = fmt.Sprintf("%s/v1/messages", baseURL)
+		}
+	case APITypeGemini:
+		// https://ai.google.dev/api/generate-content
+		method := "generateContent"
+		if textRequest.Stream {
+			method = "streamGenerateContent?alt=sse"
+		}
+		fullRequestURL = fmt.Sprintf("%s/v1beta/models/%s:%s", baseURL, textRequest.Model, method)
+	case APITypeBedrock:
+		credentials, err := parseBedrockKey(strings.TrimPrefix(c.Request.Header.Get("Authorization"), "Bearer "))
+		if err != nil {
+			return errorWrapper(err, "invalid_bedrock_config", http.StatusInternalServerError)
 		}
+		fullRequestURL = getBedrockRequestURL(baseURL, credentials.region, textRequest.Model, textRequest.Stream)
 	case APITypeBaidu:
 		switch textRequest.Model {
 		case "ERNIE-Bot":
@@ -160,7 +233,7 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 		apiKey := c.Request.Header.Get("Authorization")
 		apiKey = strings.TrimPrefix(apiKey, "Bearer ")
 		var err error
-		if apiKey, err = getBaiduAccessToken(apiKey); err != nil {
+		if apiKey, err = getBaiduAccessToken(apiKey, c.GetString("proxy")); err != nil {
 			return errorWrapper(err, "invalid_baidu_config", http.StatusInternalServerError)
 		}
 		fullRequestURL += "?access_token=" + apiKey
@@ -187,6 +260,15 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			fullRequestURL = fmt.Sprintf("https://api.minimax.chat/v1/embeddings?GroupId=%s", groupId)
 		}
 	}
+	var truncationUsage Usage
+	truncationLog := ""
+	if relayMode == RelayModeChatCompletions && !isChoiceRequest && c.GetString("context_truncation") != "" {
+		var err *OpenAIErrorWithStatusCode
+		truncationLog, truncationUsage, err = truncateContext(c, &textRequest)
+		if err != nil {
+			return err
+		}
+	}
 	var promptTokens int
 	var completionTokens int
 	switch relayMode {
@@ -199,15 +281,26 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 	case RelayModeEmbeddings:
 		promptTokens = countTokenEmbeddingInput(textRequest.Input, textRequest.Model)
 	}
-	preConsumedTokens := common.PreConsumedQuota
+	choices := getChoicePlan(apiType, relayMode, choiceCount, bestOf, textRequest.Stream)
+	promptCount := 1
+	if choices != nil {
+		// every emulated request is charged for the prompt
+		promptCount = choices.requests
+	}
+	preConsumedTokens := common.PreConsumedQuota * bestOf
 	if textRequest.MaxTokens != 0 {
-		preConsumedTokens = promptTokens + textRequest.MaxTokens
+		preConsumedTokens = promptTokens*promptCount + textRequest.MaxTokens*bestOf
 	}
 	modelRatio := common.GetModelRatio(textRequest.Model)
 	groupRatio := common.GetGroupRatio(group)
 	ratio := modelRatio * groupRatio
+	if relayMode == RelayModeModerations && common.FreeModerationEnabled {
+		ratio = 0
+	}
+	batchRatio := getBatchRatio(c)
+	ratio *= batchRatio
 	preConsumedQuota := int(float64(preConsumedTokens) * ratio)
-	userQuota, err := model.CacheGetUserQuota(userId)
+	userQuota, err := model.CacheGetAvailableQuota(userId, c.GetInt("organization_id"))
 	if err != nil {
 		return errorWrapper(err, "get_user_quota_failed", http.StatusInternalServerError)
 	}
@@ -216,30 +309,301 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 		// because the user has enough quota
 		preConsumedQuota = 0
 	}
-	if consumeQuota && preConsumedQuota > 0 {
-		err := model.PreConsumeTokenQuota(tokenId, preConsumedQuota)
+	var reservation *model.Reservation
+	// the parent request has reserved the quota for all its choices
+	if consumeQuota && preConsumedQuota > 0 && !isChoiceRequest {
+		reservation, err = model.ReserveQuota(tokenId, preConsumedQuota)
+		if model.IsQuotaInsufficientError(err) {
+			return handleInsufficientQuota(c, relayMode, textRequest.Model, preConsumedTokens, err)
+		}
 		if err != nil {
 			return errorWrapper(err, "pre_consume_token_quota_failed", http.StatusForbidden)
 		}
 	}
-	var requestBody io.Reader
+	var req *http.Request
+	var resp *http.Response
+	isStream := textRequest.Stream
+	var textResponse TextResponse
+	var completionText string
+	tokenName := c.GetString("token_name")
+	channelId := c.GetInt("channel_id")
+	channelKeyHash := c.GetString("channel_key_hash")
+	requestModel := c.GetString("request_model")
+	experimentId := c.GetInt("experiment_id")
+	// the channels which served the parts of a split request, the request is served by its channel alone otherwise
+	var channelShares []channelUsageShare
+	// the log of the cache which served the request, empty if it was relayed
+	responseCacheLog := ""
+
+	defer func() {
+		if truncationUsage.PromptTokens+truncationUsage.CompletionTokens > 0 && textResponse.Usage.PromptTokens+textResponse.Usage.CompletionTokens > 0 {
+			// the summary of the truncated messages is billed with the request
+			textResponse.Usage.PromptTokens += truncationUsage.PromptTokens
+			textResponse.Usage.CompletionTokens += truncationUsage.CompletionTokens
+			textResponse.Usage.TotalTokens += truncationUsage.TotalTokens
+		}
+		// the usage is kept for the requests relayed on behalf of another one, such as the choices and the translated ingress formats
+		c.Set("relay_usage", textResponse.Usage)
+		if isChoiceRequest {
+			// the parent request bills all the choices at once
+			return
+		}
+		// c.Writer.Flush()
+		latency := time.Since(startTime).Milliseconds()
+		// the stream is billed for what the upstream generated until the client was gone
+		clientGone := isStream && c.Request.Context().Err() != nil
+		// the context is reused by gin once the handler returns, what the billing needs of it is read before
+		downgradedFrom := c.GetString("downgraded_from")
+		modelAlias := c.GetString("model_alias")
+		fallbackFrom := c.GetString("fallback_from")
+		settlement := getQuotaSettlement(c)
+		experimentArm := c.GetString("experiment_arm")
+		// the judge may run out of the regions, so the requests with a data residency are not judged
+		experimentJudged := c.GetString("experiment_judge_model") != "" && !middleware.HasDataResidency(c)
+		go func() {
+			shares := channelShares
+			if shares == nil {
+				shares = []channelUsageShare{{channelId: channelId, keyHash: channelKeyHash, usage: textResponse.Usage}}
+			}
+			for _, share := range shares {
+				// the limits are set for the model the channel was selected for
+				model.RecordChannelTokens(share.channelId, requestModel, share.usage.PromptTokens+share.usage.CompletionTokens)
+				// the budgets of the channel count the list price, without the group ratio and the discounts
+				spend := (getPromptQuota(share.usage, textRequest.Model) + float64(share.usage.CompletionTokens)*getCompletionRatio(textRequest.Model)) * modelRatio
+				model.RecordChannelSpend(share.channelId, int(spend))
+			}
+			billedQuota := 0
+			if consumeQuota {
+				quota := 0
+				completionRatio := getCompletionRatio(textRequest.Model)
+
+				promptTokens = textResponse.Usage.PromptTokens
+				completionTokens = textResponse.Usage.CompletionTokens
+
+				quota = int(getPromptQuota(textResponse.Usage, textRequest.Model)) + int(float64(completionTokens)*completionRatio)
+				quota = int(float64(quota) * ratio)
+				if ratio != 0 && quota <= 0 {
+					quota = 1
+				}
+				totalTokens := promptTokens + completionTokens
+				upstreamFailed := resp != nil && resp.StatusCode >= http.StatusInternalServerError
+				streamAborted := isStream && completionTokens == 0 && textResponse.Usage.TotalTokens == 0
+				refunded := false
+				if totalTokens == 0 || upstreamFailed || streamAborted {
+					// in this case, must be some error happened
+					// we cannot just return, because we have to return the pre-consumed quota
+					quota = 0
+					refunded = reservation != nil
+				}
+				chargeLog := ""
+				if quota != 0 {
+					quota, chargeLog = applyModelCharges(textRequest.Model, quota, groupRatio)
+					var couponLog string
+					quota, couponLog = applyUserCoupon(userId, textRequest.Model, quota)
+					chargeLog += couponLog
+				}
+				billedQuota = quota
+				logContent := ""
+				if quota != 0 {
+					logContent = fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
+					if batchRatio != 1 {
+						logContent += fmt.Sprintf("，批处理倍率 %.2f", batchRatio)
+					}
+					if downgradedFrom != "" {
+						logContent += fmt.Sprintf("，额度不足由 %s 自动降级", downgradedFrom)
+					}
+					if modelAlias != "" {
+						logContent += fmt.Sprintf("，请求的模型为别名 %s", modelAlias)
+					}
+					if fallbackFrom != "" {
+						logContent += fmt.Sprintf("，%s 无可用渠道，回退到 %s", fallbackFrom, requestModel)
+					}
+					logContent += getPromptCacheLog(textResponse.Usage, textRequest.Model)
+					logContent += truncationLog
+					if responseCacheLog != "" {
+						logContent += fmt.Sprintf("，%s，缓存倍率 %.2f", responseCacheLog, common.ResponseCacheRatio)
+					}
+					if clientGone {
+						logContent += "，客户端中途断开"
+					}
+					if bestOf > 1 {
+						logContent += fmt.Sprintf("，生成 %d 个结果", bestOf)
+					}
+					logContent += chargeLog
+				}
+				settlement.reservation = reservation
+				settlement.shares = shares
+				settlement.settle(textRequest.Model, promptTokens, completionTokens, quota, logContent)
+				if refunded {
+					refundLog := fmt.Sprintf("上游请求失败，已退还预扣额度 %s", common.LogQuota(preConsumedQuota))
+					model.RecordRefundLog(userId, textRequest.Model, tokenName, preConsumedQuota, refundLog)
+				}
+				if experimentId != 0 {
+					record := &model.ExperimentRecord{
+						ExperimentId: experimentId,
+						Arm:          experimentArm,
+						ChannelId:    channelId,
+						Latency:      latency,
+						Quota:        quota,
+						Success:      !(totalTokens == 0 || upstreamFailed || streamAborted),
+					}
+					if experimentJudged && len(textRequest.Messages) > 0 {
+						record.Prompt = textRequest.Messages[len(textRequest.Messages)-1].StringContent()
+						record.Completion = completionText
+					}
+					model.RecordExperimentResult(record)
+				}
+			}
+			for i, shareQuota := range getShareQuotas(billedQuota, shares) {
+				usage := shares[i].usage
+				if usage.PromptTokens+usage.CompletionTokens > 0 {
+					cost, priced := common.GetUpstreamCost(textRequest.Model, usage.PromptTokens, usage.CompletionTokens)
+					model.RecordChannelUsage(shares[i].channelId, textRequest.Model, usage.PromptTokens, usage.CompletionTokens, shareQuota, cost, priced)
+				}
+			}
+		}()
+	}()
+	includeUsage := textRequest.StreamOptions != nil && textRequest.StreamOptions.IncludeUsage
+	requestUsage := isStream && shouldRequestStreamUsage(c, apiType, relayMode, includeUsage)
+	if isStream && !isChoiceRequest && (includeUsage || requestUsage) {
+		usageWriter := newStreamUsageWriter(c.Writer, includeUsage)
+		c.Writer = usageWriter
+		defer func() {
+			usageWriter.finish(textResponse.Usage)
+			c.Writer = usageWriter.ResponseWriter
+		}()
+	}
+	responseCacheKey, cacheErr := getResponseCacheKey(c, relayMode)
+	if cacheErr != nil {
+		return errorWrapper(cacheErr, "get_response_cache_key_failed", http.StatusInternalServerError)
+	}
+	var cached *cachedResponse
+	var semanticQuery *semanticCacheQuery
+	if responseCacheKey != "" {
+		cached = getCachedResponse(responseCacheKey)
+		responseCacheLog = "命中响应缓存"
+	}
+	if cached == nil {
+		semanticQuery = getSemanticCacheQuery(c, relayMode)
+		if semanticQuery != nil {
+			cached = semanticQuery.find()
+			responseCacheLog = "命中语义缓存"
+		}
+	}
+	if cached != nil {
+		writeCachedResponse(c, cached)
+		textResponse.Usage = cached.Usage
+		// no channel served the request
+		channelShares = []channelUsageShare{}
+		ratio *= common.ResponseCacheRatio
+		return nil
+	}
+	responseCacheLog = ""
+	if responseCacheKey != "" || semanticQuery != nil {
+		cacheWriter := &responseCacheWriter{ResponseWriter: c.Writer}
+		c.Writer = cacheWriter
+		defer func() {
+			c.Writer = cacheWriter.ResponseWriter
+			if cacheWriter.Status() != http.StatusOK || cacheWriter.body.Len() == 0 || textResponse.Usage.TotalTokens == 0 {
+				return
+			}
+			if responseCacheKey != "" {
+				setCachedResponse(responseCacheKey, cacheWriter.body.Bytes(), textResponse.Usage)
+			}
+			if semanticQuery != nil {
+				semanticQuery.store(cacheWriter.body.Bytes(), textResponse.Usage)
+			}
+		}()
+	}
+	if choices != nil {
+		usage, responseText, err := relayChoices(c, relayMode, choices, choiceCount, isStream, textRequest.Model)
+		textResponse.Usage = usage
+		completionText = responseText
+		return err
+	}
+	if (relayMode == RelayModeChatCompletions || relayMode == RelayModeCompletions) && !isChoiceRequest && isRaceEnabled(c) {
+		if raceChannel, err := middleware.SelectFailoverChannel(c, []int{channelId}); err == nil && raceChannel.Id != channelId {
+			usage, shares, err := relayRace(c, relayMode, raceChannel)
+			textResponse.Usage = usage
+			channelShares = shares
+			return err
+		}
+	}
+	if relayMode == RelayModeChatCompletions && !isStream && !isChoiceRequest && isStructuredOutput(textRequest.ResponseFormat) &&
+		resolveBoolOption(c, "StructuredOutputValidationEnabled", common.StructuredOutputValidationEnabled) {
+		usage, responseText, err := relayStructuredOutput(c, relayMode, textRequest.ResponseFormat)
+		textResponse.Usage = usage
+		completionText = responseText
+		return err
+	}
+	if relayMode == RelayModeEmbeddings && !isChoiceRequest {
+		if chunks := getEmbeddingChunks(textRequest, channelType, promptTokens); chunks != nil {
+			usage, shares, err := relayEmbeddingChunks(c, relayMode, chunks)
+			textResponse.Usage = usage
+			channelShares = shares
+			return err
+		}
+	}
 	if isModelMapped {
-		jsonStr, err := json.Marshal(textRequest)
+		// only the model is replaced, the fields which the gateway does not know such as the tools are kept
+		err := replaceRequestModel(c, textRequest.Model)
 		if err != nil {
 			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
 		}
-		requestBody = bytes.NewBuffer(jsonStr)
-	} else {
-		requestBody = c.Request.Body
 	}
+	if requestUsage {
+		err := setRequestStreamUsage(c)
+		if err != nil {
+			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+	}
+	var requestBody io.Reader = c.Request.Body
 	switch apiType {
 	case APITypeClaude:
-		claudeRequest := requestOpenAI2Claude(textRequest)
+		var openaiRequest ChatCompletionRequest
+		err := common.UnmarshalBodyReusable(c, &openaiRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		claudeRequest, err := requestOpenAI2Claude(openaiRequest, textRequest.Model)
+		if err != nil {
+			return errorWrapper(err, "convert_request_failed", http.StatusBadRequest)
+		}
 		jsonStr, err := json.Marshal(claudeRequest)
 		if err != nil {
 			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
 		}
 		requestBody = bytes.NewBuffer(jsonStr)
+	case APITypeGemini:
+		var openaiRequest ChatCompletionRequest
+		err := common.UnmarshalBodyReusable(c, &openaiRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		geminiRequest, err := requestOpenAI2Gemini(openaiRequest, c.GetString("proxy"))
+		if err != nil {
+			return errorWrapper(err, "convert_request_failed", http.StatusBadRequest)
+		}
+		jsonStr, err := json.Marshal(geminiRequest)
+		if err != nil {
+			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonStr)
+	case APITypeBedrock:
+		var openaiRequest ChatCompletionRequest
+		err := common.UnmarshalBodyReusable(c, &openaiRequest)
+		if err != nil {
+			return errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		}
+		bedrockRequest, err := requestOpenAI2Bedrock(openaiRequest, c.GetString("proxy"))
+		if err != nil {
+			return errorWrapper(err, "convert_request_failed", http.StatusBadRequest)
+		}
+		jsonStr, err := json.Marshal(bedrockRequest)
+		if err != nil {
+			return errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+		}
+		requestBody = bytes.NewBuffer(jsonStr)
 	case APITypeBaidu:
 		var jsonData []byte
 		var err error
@@ -292,12 +656,9 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 		requestBody = bytes.NewBuffer(jsonData)
 	}
 
-	var req *http.Request
-	var resp *http.Response
-	isStream := textRequest.Stream
-
 	if apiType != APITypeXunfei { // cause xunfei use websocket
-		req, err = http.NewRequest(c.Request.Method, fullRequestURL, requestBody)
+		// the upstream request is cancelled when the client is gone
+		req, err = http.NewRequestWithContext(c.Request.Context(), c.Request.Method, fullRequestURL, requestBody)
 		if err != nil {
 			return errorWrapper(err, "new_request_failed", http.StatusInternalServerError)
 		}
@@ -308,7 +669,7 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			if channelType == common.ChannelTypeAzure {
 				req.Header.Set("api-key", apiKey)
 			} else {
-				req.Header.Set("Authorization", c.Request.Header.Get("Authorization"))
+				setBearerAuth(req, apiKey)
 			}
 		case APITypeClaude:
 			req.Header.Set("x-api-key", apiKey)
@@ -317,6 +678,8 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 				anthropicVersion = "2023-06-01"
 			}
 			req.Header.Set("anthropic-version", anthropicVersion)
+		case APITypeGemini:
+			req.Header.Set("x-goog-api-key", apiKey)
 		case APITypeZhipu:
 			token := getZhipuToken(apiKey)
 			req.Header.Set("Authorization", token)
@@ -330,8 +693,18 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 		}
 		req.Header.Set("Content-Type", c.Request.Header.Get("Content-Type"))
 		req.Header.Set("Accept", c.Request.Header.Get("Accept"))
+		setChannelHeaders(req, c.GetString("channel_headers"))
+		setPolicyHeaders(c, req)
+		if apiType == APITypeBedrock {
+			// signed last, the key was validated with the URL
+			credentials, _ := parseBedrockKey(apiKey)
+			err = signBedrockRequest(req, credentials)
+			if err != nil {
+				return errorWrapper(err, "sign_request_failed", http.StatusInternalServerError)
+			}
+		}
 		//req.Header.Set("Connection", c.Request.Header.Get("Connection"))
-		resp, err = httpClient.Do(req)
+		resp, err = getRelayHTTPClient(c).Do(req)
 		if err != nil {
 			return errorWrapper(err, "do_request_failed", http.StatusInternalServerError)
 		}
@@ -346,93 +719,87 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 		isStream = isStream || strings.HasPrefix(resp.Header.Get("Content-Type"), "text/event-stream")
 	}
 
-	var textResponse TextResponse
-	tokenName := c.GetString("token_name")
-	channelId := c.GetInt("channel_id")
-
-	defer func() {
-		// c.Writer.Flush()
-		go func() {
-			if consumeQuota {
-				quota := 0
-				completionRatio := 1.0
-				if strings.HasPrefix(textRequest.Model, "gpt-3.5") {
-					completionRatio = 1.333333
-				}
-				if strings.HasPrefix(textRequest.Model, "gpt-4") {
-					completionRatio = 2
-				}
-
-				promptTokens = textResponse.Usage.PromptTokens
-				completionTokens = textResponse.Usage.CompletionTokens
-
-				quota = promptTokens + int(float64(completionTokens)*completionRatio)
-				quota = int(float64(quota) * ratio)
-				if ratio != 0 && quota <= 0 {
-					quota = 1
-				}
-				totalTokens := promptTokens + completionTokens
-				if totalTokens == 0 {
-					// in this case, must be some error happened
-					// we cannot just return, because we may have to return the pre-consumed quota
-					quota = 0
-				}
-				quotaDelta := quota - preConsumedQuota
-				err := model.PostConsumeTokenQuota(tokenId, quotaDelta)
-				if err != nil {
-					common.SysError("error consuming token remain quota: " + err.Error())
-				}
-				err = model.CacheUpdateUserQuota(userId)
-				if err != nil {
-					common.SysError("error update user quota cache: " + err.Error())
-				}
-				if quota != 0 {
-					logContent := fmt.Sprintf("模型倍率 %.2f，分组倍率 %.2f", modelRatio, groupRatio)
-					model.RecordConsumeLog(userId, promptTokens, completionTokens, textRequest.Model, tokenName, quota, logContent)
-					model.UpdateUserUsedQuotaAndRequestCount(userId, quota)
-
-					model.UpdateChannelUsedQuota(channelId, quota)
-				}
-			}
-		}()
-	}()
 	switch apiType {
 	case APITypeOpenAI:
 		if isStream {
-			err, responseText := openaiStreamHandler(c, resp, relayMode)
+			err, responseText, usage := openaiStreamHandler(c, resp, relayMode)
+			if err != nil {
+				return err
+			}
+			textResponse.Usage = reconcileStreamUsage(textRequest.Model, promptTokens, responseText, usage)
+			completionText = responseText
+			return nil
+		} else {
+			err, response := openaiHandler(c, resp, consumeQuota, promptTokens, textRequest.Model)
+			if err != nil {
+				return err
+			}
+			if response != nil {
+				textResponse = *response
+				for _, choice := range response.Choices {
+					completionText += choice.Message.StringContent()
+				}
+			}
+			return nil
+		}
+	case APITypeClaude:
+		if isStream {
+			err, responseText, usage := claudeStreamHandler(c, resp)
 			if err != nil {
 				return err
 			}
-			textResponse.Usage.PromptTokens = promptTokens
-			textResponse.Usage.CompletionTokens = countTokenText(responseText, textRequest.Model)
+			textResponse.Usage = reconcileStreamUsage(textRequest.Model, promptTokens, responseText, usage)
+			completionText = responseText
 			return nil
 		} else {
-			err, usage := openaiHandler(c, resp, consumeQuota, promptTokens, textRequest.Model)
+			err, usage, responseText := claudeHandler(c, resp)
 			if err != nil {
 				return err
 			}
 			if usage != nil {
 				textResponse.Usage = *usage
 			}
+			completionText = responseText
 			return nil
 		}
-	case APITypeClaude:
+	case APITypeGemini:
+		if isStream {
+			err, responseText, usage := geminiStreamHandler(c, resp)
+			if err != nil {
+				return err
+			}
+			textResponse.Usage = reconcileStreamUsage(textRequest.Model, promptTokens, responseText, usage)
+			completionText = responseText
+			return nil
+		} else {
+			err, usage, responseText := geminiHandler(c, resp, promptTokens, textRequest.Model)
+			if err != nil {
+				return err
+			}
+			if usage != nil {
+				textResponse.Usage = *usage
+			}
+			completionText = responseText
+			return nil
+		}
+	case APITypeBedrock:
 		if isStream {
-			err, responseText := claudeStreamHandler(c, resp)
+			err, responseText, usage := bedrockStreamHandler(c, resp, textRequest.Model)
 			if err != nil {
 				return err
 			}
-			textResponse.Usage.PromptTokens = promptTokens
-			textResponse.Usage.CompletionTokens = countTokenText(responseText, textRequest.Model)
+			textResponse.Usage = reconcileStreamUsage(textRequest.Model, promptTokens, responseText, usage)
+			completionText = responseText
 			return nil
 		} else {
-			err, usage := claudeHandler(c, resp, promptTokens, textRequest.Model)
+			err, usage, responseText := bedrockHandler(c, resp, textRequest.Model)
 			if err != nil {
 				return err
 			}
 			if usage != nil {
 				textResponse.Usage = *usage
 			}
+			completionText = responseText
 			return nil
 		}
 	case APITypeBaidu:
@@ -468,8 +835,8 @@ func relayTextHelper(c *gin.Context, relayMode int) *OpenAIErrorWithStatusCode {
 			if err != nil {
 				return err
 			}
-			textResponse.Usage.PromptTokens = promptTokens
-			textResponse.Usage.CompletionTokens = countTokenText(responseText, textRequest.Model)
+			textResponse.Usage = reconcileStreamUsage(textRequest.Model, promptTokens, responseText, nil)
+			completionText = responseText
 			return nil
 		} else {
 			err, usage := palmHandler(c, resp, promptTokens, textRequest.Model)
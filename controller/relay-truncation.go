@@ -0,0 +1,134 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// contextSummaryMaxTokens is the room kept in the context window for the summary of the dropped messages
+const contextSummaryMaxTokens = 1024
+
+const contextSummaryPrompt = "Summarize the conversation below concisely, keeping the facts, decisions and open questions needed to continue it."
+
+// summarizeMessages asks the model of the request for a summary of the messages, the usage is returned even when it fails
+func summarizeMessages(c *gin.Context, modelName string, messages []Message, window int) (string, *Usage, *OpenAIErrorWithStatusCode) {
+	lines := make([]string, 0, len(messages))
+	for _, message := range messages {
+		lines = append(lines, fmt.Sprintf("%s: %s", message.Role, message.StringContent()))
+	}
+	// the oldest lines give way when the transcript itself is beyond the context window
+	transcript := strings.Join(lines, "\n\n")
+	for len(lines) > 1 && countTokenText(transcript, modelName)+countTokenText(contextSummaryPrompt, modelName) > window-contextSummaryMaxTokens {
+		lines = lines[1:]
+		transcript = strings.Join(lines, "\n\n")
+	}
+	requestBody, err := json.Marshal(map[string]any{
+		"model": modelName,
+		"messages": []any{
+			map[string]any{"role": "system", "content": contextSummaryPrompt},
+			map[string]any{"role": "user", "content": transcript},
+		},
+		"max_tokens": contextSummaryMaxTokens,
+	})
+	if err != nil {
+		return "", nil, errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+	}
+	summaryContext, recorder := newChoiceContext(c, requestBody)
+	response, usage, relayErr := doChoiceRequest(summaryContext, recorder, RelayModeChatCompletions)
+	if relayErr != nil {
+		return "", usage, relayErr
+	}
+	if len(response.Choices) == 0 {
+		return "", usage, errorWrapper(errors.New("the summary is empty"), "empty_summary", http.StatusInternalServerError)
+	}
+	return response.Choices[0].Message.StringContent(), usage, nil
+}
+
+// truncateContext drops the oldest messages of a chat request beyond the context window of the upstream model, by the
+// truncation policy of the token, the leading system messages and the last message are always kept, with the
+// summarize policy the dropped messages are replaced by a summary the model writes, it returns the log of what was
+// done, empty if the request fits already, and the usage of the summary
+func truncateContext(c *gin.Context, textRequest *GeneralOpenAIRequest) (string, Usage, *OpenAIErrorWithStatusCode) {
+	var summaryUsage Usage
+	policy := c.GetString("context_truncation")
+	window := common.GetModelContextWindow(textRequest.Model)
+	if window == 0 {
+		return "", summaryUsage, nil
+	}
+	budget := window - textRequest.MaxTokens
+	messages := textRequest.Messages
+	messageTokens := make([]int, len(messages))
+	promptTokens := 3
+	for i, message := range messages {
+		messageTokens[i] = countTokenMessages([]Message{message}, textRequest.Model) - 3
+		promptTokens += messageTokens[i]
+	}
+	if promptTokens <= budget {
+		return "", summaryUsage, nil
+	}
+	if policy == model.ContextTruncationSummarize {
+		budget -= contextSummaryMaxTokens
+	}
+	leading := 0
+	for leading < len(messages)-1 && (messages[leading].Role == "system" || messages[leading].Role == "developer") {
+		leading++
+	}
+	// a tool message is not kept without the assistant message which called the tool
+	end := leading
+	for end < len(messages)-1 && (promptTokens > budget || messages[end].Role == "tool") {
+		promptTokens -= messageTokens[end]
+		end++
+	}
+	if promptTokens > budget {
+		return "", summaryUsage, errorWrapper(fmt.Errorf("the messages exceed the context window of %d tokens of %s even after truncation", window, textRequest.Model), "context_length_exceeded", http.StatusBadRequest)
+	}
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return "", summaryUsage, errorWrapper(err, "read_request_body_failed", http.StatusInternalServerError)
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		return "", summaryUsage, errorWrapper(err, "unmarshal_request_body_failed", http.StatusBadRequest)
+	}
+	requestMessages, _ := request["messages"].([]any)
+	if len(requestMessages) != len(messages) {
+		return "", summaryUsage, errorWrapper(errors.New("the messages cannot be truncated"), "truncate_messages_failed", http.StatusInternalServerError)
+	}
+	keptMessages := append([]any{}, requestMessages[:leading]...)
+	keptTextMessages := append([]Message{}, messages[:leading]...)
+	truncationLog := fmt.Sprintf("，超出上下文窗口，丢弃最早的 %d 条消息", end-leading)
+	if policy == model.ContextTruncationSummarize {
+		modelName, _ := request["model"].(string)
+		summary, usage, relayErr := summarizeMessages(c, modelName, messages[leading:end], window)
+		if usage != nil {
+			summaryUsage = *usage
+		}
+		if relayErr != nil {
+			common.SysError("failed to summarize the truncated messages: " + relayErr.Message)
+		} else {
+			summaryMessage := Message{Role: "system", Content: "Summary of the earlier part of the conversation: " + summary}
+			keptMessages = append(keptMessages, map[string]any{"role": summaryMessage.Role, "content": summaryMessage.Content})
+			keptTextMessages = append(keptTextMessages, summaryMessage)
+			truncationLog = fmt.Sprintf("，超出上下文窗口，最早的 %d 条消息替换为摘要", end-leading)
+		}
+	}
+	request["messages"] = append(keptMessages, requestMessages[end:]...)
+	textRequest.Messages = append(keptTextMessages, messages[end:]...)
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		return "", summaryUsage, errorWrapper(err, "marshal_text_request_failed", http.StatusInternalServerError)
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return truncationLog, summaryUsage, nil
+}
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/pkoukk/tiktoken-go"
+	"math"
+	"math/rand"
+	"net/http"
 	"one-api/common"
+	"one-api/model"
 	"reflect"
 )
 
@@ -54,8 +58,13 @@ func countTokenMessages(messages []Message, model string) int {
 	tokenNum := 0
 	for _, message := range messages {
 		tokenNum += tokensPerMessage
-		tokenNum += getTokenNum(tokenEncoder, message.Content)
+		tokenNum += getTokenNum(tokenEncoder, message.StringContent())
+		tokenNum += countTokenImages(message.Content, model)
 		tokenNum += getTokenNum(tokenEncoder, message.Role)
+		for _, toolCall := range message.ToolCalls {
+			tokenNum += getTokenNum(tokenEncoder, toolCall.Function.Name)
+			tokenNum += getTokenNum(tokenEncoder, toolCall.Function.Arguments)
+		}
 		if message.Name != nil {
 			tokenNum += tokensPerName
 			tokenNum += getTokenNum(tokenEncoder, *message.Name)
@@ -91,6 +100,20 @@ func countTokenInput(input any, model string) int {
 			text += s
 		}
 		return countTokenText(text, model)
+	case []any:
+		// the JSON arrays of the moderations, of texts or of text and image parts
+		text := ""
+		for _, item := range input.([]any) {
+			switch item := item.(type) {
+			case string:
+				text += item
+			case map[string]any:
+				if itemText, ok := item["text"].(string); ok {
+					text += itemText
+				}
+			}
+		}
+		return countTokenText(text, model)
 	}
 	return 0
 }
@@ -112,10 +135,22 @@ func errorWrapper(err error, code string, statusCode int) *OpenAIErrorWithStatus
 	}
 }
 
+// quotaExhaustedErrorWrapper is for the requests refused because the token or the user runs out of quota
+func quotaExhaustedErrorWrapper(err error, code string, statusCode int) *OpenAIErrorWithStatusCode {
+	errWithStatusCode := errorWrapper(err, code, statusCode)
+	errWithStatusCode.quotaExhausted = true
+	return errWithStatusCode
+}
+
 func shouldDisableChannel(err *OpenAIError) bool {
 	if !common.AutomaticDisableChannelEnabled {
 		return false
 	}
+	return isKeyUnusable(err)
+}
+
+// isKeyUnusable tells whether the key of the channel ran out or was revoked, another key of the channel may still work
+func isKeyUnusable(err *OpenAIError) bool {
 	if err == nil {
 		return false
 	}
@@ -132,3 +167,148 @@ func setEventStreamHeaders(c *gin.Context) {
 	c.Writer.Header().Set("Transfer-Encoding", "chunked")
 	c.Writer.Header().Set("X-Accel-Buffering", "no")
 }
+
+// setPolicyHeaders adds the upstream headers set by the policies of the group and channel
+func setPolicyHeaders(c *gin.Context, req *http.Request) {
+	headers, ok := c.Get("policy_headers")
+	if !ok {
+		return
+	}
+	for key, value := range headers.(map[string]string) {
+		req.Header.Set(key, value)
+	}
+}
+
+// getPromptQuota weights the prompt tokens by the cache discount of the model
+func getPromptQuota(usage Usage, modelName string) float64 {
+	if usage.PromptTokensDetails == nil {
+		return float64(usage.PromptTokens)
+	}
+	cachedTokens := usage.PromptTokensDetails.CachedTokens
+	cacheCreationTokens := usage.PromptTokensDetails.CacheCreationTokens
+	uncachedTokens := usage.PromptTokens - cachedTokens - cacheCreationTokens
+	if uncachedTokens < 0 {
+		uncachedTokens = 0
+	}
+	return float64(uncachedTokens) + float64(cachedTokens)*common.GetCacheRatio(modelName) +
+		float64(cacheCreationTokens)*common.GetCacheCreationRatio(modelName)
+}
+
+// getPromptCacheLog describes the cached prompt tokens for the consume log
+func getPromptCacheLog(usage Usage, modelName string) string {
+	if usage.PromptTokensDetails == nil {
+		return ""
+	}
+	logContent := ""
+	if usage.PromptTokensDetails.CachedTokens > 0 {
+		logContent += fmt.Sprintf("，缓存命中 %d tokens（缓存倍率 %.2f）", usage.PromptTokensDetails.CachedTokens, common.GetCacheRatio(modelName))
+	}
+	if usage.PromptTokensDetails.CacheCreationTokens > 0 {
+		logContent += fmt.Sprintf("，缓存写入 %d tokens（写入倍率 %.2f）", usage.PromptTokensDetails.CacheCreationTokens, common.GetCacheCreationRatio(modelName))
+	}
+	return logContent
+}
+
+// resolveIntOption returns the option overridden for the group, the user or the token of the request
+func resolveIntOption(c *gin.Context, key string, defaultValue int) int {
+	return model.ResolveIntOption(key, c.GetString("group"), c.GetInt("id"), c.GetInt("token_id"), defaultValue)
+}
+
+func resolveBoolOption(c *gin.Context, key string, defaultValue bool) bool {
+	return model.ResolveBoolOption(key, c.GetString("group"), c.GetInt("id"), c.GetInt("token_id"), defaultValue)
+}
+
+// shouldRecordConsumeLog samples the consume logs by LogSampleRate, the daily usages are always recorded
+func shouldRecordConsumeLog(c *gin.Context) bool {
+	if !resolveBoolOption(c, "LogConsumeEnabled", common.LogConsumeEnabled) {
+		return false
+	}
+	return common.LogSampleRate >= 100 || rand.Intn(100) < common.LogSampleRate
+}
+
+// quotaSettlement is what settling the quota of a request needs, it is read of the context before the billing
+// goroutines since gin reuses the context once the handler returns
+type quotaSettlement struct {
+	userId            int
+	tokenId           int
+	tokenName         string
+	organizationId    int
+	group             string
+	channelId         int
+	channelKeyHash    string
+	consumeQuota      bool
+	logConsumeEnabled bool
+	reservation       *model.Reservation
+	// the channels the request was split between, the quota goes to the channel of the request when there are none
+	shares []channelUsageShare
+}
+
+func getQuotaSettlement(c *gin.Context) quotaSettlement {
+	return quotaSettlement{
+		userId:            c.GetInt("id"),
+		tokenId:           c.GetInt("token_id"),
+		tokenName:         c.GetString("token_name"),
+		organizationId:    c.GetInt("organization_id"),
+		group:             c.GetString("group"),
+		channelId:         c.GetInt("channel_id"),
+		channelKeyHash:    c.GetString("channel_key_hash"),
+		consumeQuota:      c.GetBool("consume_quota"),
+		logConsumeEnabled: shouldRecordConsumeLog(c),
+	}
+}
+
+// settle charges the token the quota of a request, settling the reservation of the request if there is one, and
+// records the quota in the consume log, the usages of the tenant and the user and the used quotas of the channels
+func (s quotaSettlement) settle(modelName string, promptTokens int, completionTokens int, quota int, logContent string) {
+	err := model.SettleQuota(s.reservation, s.tokenId, quota)
+	if err != nil {
+		common.SysError("error consuming token remain quota: " + err.Error())
+	}
+	err = model.CacheUpdateUserQuota(s.userId)
+	if err != nil {
+		common.SysError("error update user quota cache: " + err.Error())
+	}
+	if quota == 0 {
+		return
+	}
+	if s.logConsumeEnabled {
+		model.RecordConsumeLog(s.userId, promptTokens, completionTokens, modelName, s.tokenName, quota, logContent)
+	}
+	model.RecordTenantUsage(s.group, modelName, promptTokens, completionTokens, quota)
+	model.RecordUserUsage(s.userId, s.tokenName, modelName, promptTokens, completionTokens, quota)
+	model.UpdateUserUsedQuotaAndRequestCount(s.userId, quota, s.organizationId)
+	shares := s.shares
+	if shares == nil {
+		shares = []channelUsageShare{{channelId: s.channelId, keyHash: s.channelKeyHash}}
+	}
+	for i, shareQuota := range getShareQuotas(quota, shares) {
+		model.UpdateChannelUsedQuota(shares[i].channelId, shareQuota)
+		model.RecordChannelKeyUsage(shares[i].channelId, shares[i].keyHash, shareQuota)
+	}
+}
+
+// applyModelCharges raises the quota to the minimum charge of the model and adds its surcharge,
+// both are priced in USD and weighted by the group ratio, the description is appended to the consume log
+func applyModelCharges(modelName string, quota int, groupRatio float64) (int, string) {
+	logContent := ""
+	minQuota := int(math.Ceil(common.GetModelMinCharge(modelName) * common.QuotaPerUnit * groupRatio))
+	if quota < minQuota {
+		quota = minQuota
+		logContent += fmt.Sprintf("，不足最低收费按 %s 计算", common.LogQuota(minQuota))
+	}
+	surcharge := int(math.Ceil(common.GetModelSurcharge(modelName) * common.QuotaPerUnit * groupRatio))
+	if surcharge > 0 {
+		quota += surcharge
+		logContent += fmt.Sprintf("，附加费 %s", common.LogQuota(surcharge))
+	}
+	return quota, logContent
+}
+
+// applyUserCoupon takes the discount of the coupons of the user off the quota, the description is appended to the consume log
+func applyUserCoupon(userId int, modelName string, quota int) (int, string) {
+	discount, couponName := model.UseUserCoupon(userId, modelName, quota)
+	if discount == 0 {
+		return quota, ""
+	}
+	return quota - discount, fmt.Sprintf("，优惠券 %s 减免 %s", couponName, common.LogQuota(discount))
+}
@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"io"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// the upstreams which take the images inline get the ones given by URL downloaded first
+const maxImageSize = 20 * 1024 * 1024
+
+const imageDownloadTimeout = 30 * time.Second
+
+// the images given by URL are not downloaded to count their tokens, nor the images which can't be decoded, they are
+// counted as of this size
+const defaultImageSize = 1024
+
+// imageLimit is what the upstream takes of an inline image
+type imageLimit struct {
+	maxSize   int // in bytes, of the decoded image
+	mimeTypes []string
+}
+
+var (
+	claudeImageLimit  = imageLimit{maxSize: 5 * 1024 * 1024, mimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"}}
+	bedrockImageLimit = imageLimit{maxSize: 3750 * 1024, mimeTypes: []string{"image/jpeg", "image/png", "image/gif", "image/webp"}}
+	geminiImageLimit  = imageLimit{maxSize: maxImageSize, mimeTypes: []string{"image/jpeg", "image/png", "image/webp", "image/heic", "image/heif"}}
+)
+
+// checkImage tells the client which of its images the upstream would refuse, before sending them
+func checkImage(mimeType string, data string, limit imageLimit) (string, error) {
+	mimeType = strings.ToLower(mimeType)
+	if mimeType == "image/jpg" {
+		mimeType = "image/jpeg"
+	}
+	supported := false
+	for _, t := range limit.mimeTypes {
+		if t == mimeType {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return "", fmt.Errorf("image of type %s is not supported", mimeType)
+	}
+	if size := base64.StdEncoding.DecodedLen(len(data)); size > limit.maxSize {
+		return "", fmt.Errorf("image of %d bytes is larger than the limit of %d bytes", size, limit.maxSize)
+	}
+	return mimeType, nil
+}
+
+// getImageData returns the MIME type and the base64 data of the image of an image_url part, a data URL as is and
+// any other URL after downloading it
+func getImageData(url string, proxy string) (string, string, error) {
+	if strings.HasPrefix(url, "data:") {
+		i := strings.Index(url, ";base64,")
+		if i < 0 {
+			return "", "", errors.New("invalid image data URL")
+		}
+		return url[len("data:"):i], url[i+len(";base64,"):], nil
+	}
+	client := *getHTTPClient(proxy)
+	client.Timeout = imageDownloadTimeout
+	resp, err := client.Get(url)
+	if err != nil {
+		return "", "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("failed to download image: status code %d", resp.StatusCode)
+	}
+	image, err := io.ReadAll(io.LimitReader(resp.Body, maxImageSize+1))
+	if err != nil {
+		return "", "", err
+	}
+	if len(image) > maxImageSize {
+		return "", "", errors.New("image is too large")
+	}
+	mimeType := resp.Header.Get("Content-Type")
+	if !strings.HasPrefix(mimeType, "image/") {
+		mimeType = http.DetectContentType(image)
+	}
+	if !strings.HasPrefix(mimeType, "image/") {
+		return "", "", errors.New("the URL is not an image")
+	}
+	return mimeType, base64.StdEncoding.EncodeToString(image), nil
+}
+
+// getImageSize decodes the size of the image of a data URL, the images of the other URLs are of the default size
+func getImageSize(url string) (int, int) {
+	if strings.HasPrefix(url, "data:") {
+		if i := strings.Index(url, ";base64,"); i >= 0 {
+			config, _, err := image.DecodeConfig(base64.NewDecoder(base64.StdEncoding, strings.NewReader(url[i+len(";base64,"):])))
+			if err == nil && config.Width > 0 && config.Height > 0 {
+				return config.Width, config.Height
+			}
+		}
+	}
+	return defaultImageSize, defaultImageSize
+}
+
+// scaleImageSize scales the size down to fit in the box, keeping the aspect ratio
+func scaleImageSize(width int, height int, maxWidth int, maxHeight int) (int, int) {
+	scale := math.Min(float64(maxWidth)/float64(width), float64(maxHeight)/float64(height))
+	if scale >= 1 {
+		return width, height
+	}
+	return int(float64(width) * scale), int(float64(height) * scale)
+}
+
+// countTokenImage counts the tokens of an image as the upstreams of the model bill them, see
+// https://platform.openai.com/docs/guides/images-vision#calculating-costs,
+// https://docs.anthropic.com/en/docs/build-with-claude/vision#calculate-image-costs and
+// https://ai.google.dev/gemini-api/docs/tokens#multimodal-tokens
+func countTokenImage(url string, detail string, model string) int {
+	width, height := getImageSize(url)
+	switch {
+	case strings.Contains(model, "claude"):
+		// the long edge is scaled down to 1568 pixels
+		width, height = scaleImageSize(width, height, 1568, 1568)
+		return int(math.Ceil(float64(width*height) / 750))
+	case strings.HasPrefix(model, "gemini"):
+		// the small images are one tile, the others are cropped into tiles of 768 pixels
+		if width <= 384 && height <= 384 {
+			return 258
+		}
+		return int(math.Ceil(float64(width)/768)*math.Ceil(float64(height)/768)) * 258
+	}
+	baseTokens, tileTokens := 85, 170
+	if strings.HasPrefix(model, "gpt-4o-mini") {
+		baseTokens, tileTokens = 2833, 5667
+	}
+	if detail == "low" {
+		return baseTokens
+	}
+	// fitted in 2048 pixels, then the short side is scaled down to 768 pixels, and cut into tiles of 512 pixels
+	width, height = scaleImageSize(width, height, 2048, 2048)
+	if shortSide := math.Min(float64(width), float64(height)); shortSide > 768 {
+		width, height = int(float64(width)*768/shortSide), int(float64(height)*768/shortSide)
+	}
+	tiles := int(math.Ceil(float64(width)/512) * math.Ceil(float64(height)/512))
+	return baseTokens + tiles*tileTokens
+}
+
+// countTokenImages counts the tokens of the image parts of the content of a message
+func countTokenImages(content any, model string) int {
+	parts, ok := content.([]any)
+	if !ok {
+		return 0
+	}
+	tokens := 0
+	for _, item := range parts {
+		part, _ := item.(map[string]any)
+		if partType, _ := part["type"].(string); partType != "image_url" {
+			continue
+		}
+		imageURL, _ := part["image_url"].(map[string]any)
+		url, _ := imageURL["url"].(string)
+		detail, _ := imageURL["detail"].(string)
+		tokens += countTokenImage(url, detail, model)
+	}
+	return tokens
+}
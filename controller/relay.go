@@ -4,16 +4,41 @@ import (
 	"fmt"
 	"net/http"
 	"one-api/common"
-	"strconv"
+	"one-api/middleware"
+	"one-api/model"
 	"strings"
 
 	"github.com/gin-gonic/gin"
 )
 
 type Message struct {
-	Role    string  `json:"role"`
-	Content string  `json:"content"`
-	Name    *string `json:"name,omitempty"`
+	Role      string           `json:"role"`
+	Content   any              `json:"content"`
+	Name      *string          `json:"name,omitempty"`
+	ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
+}
+
+// StringContent returns the content of the message as text, the text parts joined when it is a list of content parts
+// such as text and images
+func (message Message) StringContent() string {
+	switch content := message.Content.(type) {
+	case string:
+		return content
+	case []any:
+		text := ""
+		for _, item := range content {
+			part, ok := item.(map[string]any)
+			if !ok {
+				continue
+			}
+			if partType, _ := part["type"].(string); partType == "text" {
+				partText, _ := part["text"].(string)
+				text += partText
+			}
+		}
+		return text
+	}
+	return ""
 }
 
 const (
@@ -24,22 +49,95 @@ const (
 	RelayModeModerations
 	RelayModeImagesGenerations
 	RelayModeEdits
+	RelayModeAudioSpeech
+	RelayModeAudioTranscription
+	RelayModeAudioTranslation
+	RelayModeImagesEdits
+	RelayModeImagesVariations
+	RelayModeRealtime
+	RelayModeResponses
+	RelayModeAssistants
+	RelayModeFineTuning
 )
 
 // https://platform.openai.com/docs/api-reference/chat
 
 type GeneralOpenAIRequest struct {
-	Model       string    `json:"model,omitempty"`
-	Messages    []Message `json:"messages,omitempty"`
-	Prompt      any       `json:"prompt,omitempty"`
-	Stream      bool      `json:"stream,omitempty"`
-	MaxTokens   int       `json:"max_tokens,omitempty"`
-	Temperature float64   `json:"temperature,omitempty"`
-	TopP        float64   `json:"top_p,omitempty"`
-	N           int       `json:"n,omitempty"`
-	Input       any       `json:"input,omitempty" validate:"omitempty,ValidateEmbeddingInput"`
-	Instruction string    `json:"instruction,omitempty"`
-	Size        string    `json:"size,omitempty"`
+	Model          string          `json:"model,omitempty"`
+	Messages       []Message       `json:"messages,omitempty"`
+	Prompt         any             `json:"prompt,omitempty"`
+	Stream         bool            `json:"stream,omitempty"`
+	MaxTokens      int             `json:"max_tokens,omitempty"`
+	Temperature    float64         `json:"temperature,omitempty"`
+	TopP           float64         `json:"top_p,omitempty"`
+	N              int             `json:"n,omitempty"`
+	BestOf         int             `json:"best_of,omitempty"`
+	Input          any             `json:"input,omitempty" validate:"omitempty,ValidateEmbeddingInput"`
+	Instruction    string          `json:"instruction,omitempty"`
+	Size           string          `json:"size,omitempty"`
+	ResponseFormat *ResponseFormat `json:"response_format,omitempty"`
+	StreamOptions  *StreamOptions  `json:"stream_options,omitempty"`
+}
+
+type StreamOptions struct {
+	IncludeUsage bool `json:"include_usage"` // a last chunk of the stream, without choices, carries the usage
+}
+
+type OpenAIFunctionCall struct {
+	Name      string `json:"name,omitempty"`
+	Arguments string `json:"arguments"`
+}
+
+type OpenAIToolCall struct {
+	Index    *int               `json:"index,omitempty"` // only in the stream chunks
+	Id       string             `json:"id,omitempty"`
+	Type     string             `json:"type,omitempty"`
+	Function OpenAIFunctionCall `json:"function"`
+}
+
+type OpenAITool struct {
+	Type     string `json:"type"`
+	Function struct {
+		Name        string `json:"name"`
+		Description string `json:"description,omitempty"`
+		Parameters  any    `json:"parameters,omitempty"`
+	} `json:"function"`
+}
+
+type ResponseFormatJsonSchema struct {
+	Name        string `json:"name"`
+	Description string `json:"description,omitempty"`
+	Schema      any    `json:"schema,omitempty"`
+	Strict      bool   `json:"strict,omitempty"`
+}
+
+// ResponseFormat asks for a JSON output, of any object or matching the schema
+type ResponseFormat struct {
+	Type       string                    `json:"type"` // text, json_object or json_schema
+	JsonSchema *ResponseFormatJsonSchema `json:"json_schema,omitempty"`
+}
+
+// ChatMessage is a message of a chat request for the adapters of the upstreams with their own formats, the content is
+// a string or a list of parts, such as text and image_url
+type ChatMessage struct {
+	Role       string           `json:"role"`
+	Content    any              `json:"content"`
+	ToolCalls  []OpenAIToolCall `json:"tool_calls,omitempty"`
+	ToolCallId string           `json:"tool_call_id,omitempty"`
+}
+
+type ChatCompletionRequest struct {
+	Messages       []ChatMessage   `json:"messages"`
+	MaxTokens      int             `json:"max_tokens"`
+	Temperature    *float64        `json:"temperature"`
+	TopP           *float64        `json:"top_p"`
+	Stop           any             `json:"stop"`
+	Stream         bool            `json:"stream"`
+	Tools          []OpenAITool    `json:"tools"`
+	ToolChoice     any             `json:"tool_choice"`
+	User           string          `json:"user"`
+	ResponseFormat *ResponseFormat `json:"response_format"`
+	SafetySettings []any           `json:"safety_settings"` // passed to Gemini as is
 }
 
 type ChatRequest struct {
@@ -57,27 +155,38 @@ type TextRequest struct {
 }
 
 type ImageRequest struct {
-	Prompt string `json:"prompt"`
-	N      int    `json:"n"`
-	Size   string `json:"size"`
+	Model          string `json:"model"`
+	Prompt         string `json:"prompt"`
+	N              int    `json:"n"`
+	Size           string `json:"size"`
+	Quality        string `json:"quality"`
+	ResponseFormat string `json:"response_format"`
+}
+
+type PromptTokensDetails struct {
+	CachedTokens        int `json:"cached_tokens"`
+	CacheCreationTokens int `json:"cache_creation_tokens,omitempty"` // written into the cache, Anthropic charges extra for them
 }
 
 type Usage struct {
-	PromptTokens     int `json:"prompt_tokens"`
-	CompletionTokens int `json:"completion_tokens"`
-	TotalTokens      int `json:"total_tokens"`
+	PromptTokens        int                  `json:"prompt_tokens"`
+	CompletionTokens    int                  `json:"completion_tokens"`
+	TotalTokens         int                  `json:"total_tokens"`
+	PromptTokensDetails *PromptTokensDetails `json:"prompt_tokens_details,omitempty"` // the cached tokens are included in the prompt tokens
 }
 
 type OpenAIError struct {
-	Message string `json:"message"`
-	Type    string `json:"type"`
-	Param   string `json:"param"`
-	Code    any    `json:"code"`
+	Message        string `json:"message"`
+	Type           string `json:"type"`
+	Param          string `json:"param"`
+	Code           any    `json:"code"`
+	SuggestedModel string `json:"suggested_model,omitempty"` // the cheaper model which fits in the remaining quota
 }
 
 type OpenAIErrorWithStatusCode struct {
 	OpenAIError
-	StatusCode int `json:"status_code"`
+	StatusCode     int  `json:"status_code"`
+	quotaExhausted bool // the operators may configure the response of each group
 }
 
 type TextResponse struct {
@@ -121,8 +230,10 @@ type ImageResponse struct {
 }
 
 type ChatCompletionsStreamResponseChoice struct {
+	Index int `json:"index"`
 	Delta struct {
-		Content string `json:"content"`
+		Content   string           `json:"content"`
+		ToolCalls []OpenAIToolCall `json:"tool_calls,omitempty"`
 	} `json:"delta"`
 	FinishReason *string `json:"finish_reason"`
 }
@@ -133,6 +244,7 @@ type ChatCompletionsStreamResponse struct {
 	Created int64                                 `json:"created"`
 	Model   string                                `json:"model"`
 	Choices []ChatCompletionsStreamResponseChoice `json:"choices"`
+	Usage   *Usage                                `json:"usage,omitempty"`
 }
 
 type CompletionsStreamResponse struct {
@@ -140,6 +252,7 @@ type CompletionsStreamResponse struct {
 		Text         string `json:"text"`
 		FinishReason string `json:"finish_reason"`
 	} `json:"choices"`
+	Usage *Usage `json:"usage,omitempty"`
 }
 
 func Relay(c *gin.Context) {
@@ -156,41 +269,86 @@ func Relay(c *gin.Context) {
 		relayMode = RelayModeModerations
 	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/generations") {
 		relayMode = RelayModeImagesGenerations
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") {
+		relayMode = RelayModeImagesEdits
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+		relayMode = RelayModeImagesVariations
 	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/edits") {
 		relayMode = RelayModeEdits
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/speech") {
+		relayMode = RelayModeAudioSpeech
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") {
+		relayMode = RelayModeAudioTranscription
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/translations") {
+		relayMode = RelayModeAudioTranslation
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/realtime") {
+		relayMode = RelayModeRealtime
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/responses") {
+		relayMode = RelayModeResponses
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/assistants") || strings.HasPrefix(c.Request.URL.Path, "/v1/threads") {
+		relayMode = RelayModeAssistants
+	} else if strings.HasPrefix(c.Request.URL.Path, "/v1/fine_tuning") {
+		relayMode = RelayModeFineTuning
 	}
-	var err *OpenAIErrorWithStatusCode
+	relayHelper := relayTextHelper
 	switch relayMode {
-	case RelayModeImagesGenerations:
-		err = relayImageHelper(c, relayMode)
-	default:
-		err = relayTextHelper(c, relayMode)
+	case RelayModeImagesGenerations, RelayModeImagesEdits, RelayModeImagesVariations:
+		relayHelper = relayImageHelper
+	case RelayModeAudioSpeech, RelayModeAudioTranscription, RelayModeAudioTranslation:
+		relayHelper = relayAudioHelper
+	case RelayModeRealtime:
+		relayHelper = relayRealtimeHelper
+	case RelayModeResponses:
+		relayHelper = relayResponsesHelper
+	case RelayModeAssistants:
+		relayHelper = relayAssistantsHelper
+	case RelayModeFineTuning:
+		relayHelper = relayFineTuningHelper
 	}
+	err := relayWithFailover(c, relayMode, relayHelper)
 	if err != nil {
-		retryTimesStr := c.Query("retry")
-		retryTimes, _ := strconv.Atoi(retryTimesStr)
-		if retryTimesStr == "" {
-			retryTimes = common.RetryTimes
-		}
-		if retryTimes > 0 {
-			c.Redirect(http.StatusTemporaryRedirect, fmt.Sprintf("%s?retry=%d", c.Request.URL.Path, retryTimes-1))
-		} else {
-			if err.StatusCode == http.StatusTooManyRequests {
-				err.OpenAIError.Message = "当前分组上游负载已饱和，请稍后再试"
-			}
-			c.JSON(err.StatusCode, gin.H{
-				"error": err.OpenAIError,
-			})
+		writeRelayError(c, err)
+		reportRelayError(c, err)
+	}
+}
+
+func writeRelayError(c *gin.Context, err *OpenAIErrorWithStatusCode) {
+	if err.quotaExhausted && middleware.WriteQuotaExhaustedResponse(c, c.GetString("group"), err.StatusCode, err.Message) {
+		return
+	}
+	if err.StatusCode == http.StatusTooManyRequests {
+		err.OpenAIError.Message = "当前分组上游负载已饱和，请稍后再试"
+	} else if !resolveBoolOption(c, "ErrorPassthroughEnabled", common.ErrorPassthroughEnabled) &&
+		(err.Type != "one_api_error" || err.StatusCode >= http.StatusInternalServerError) {
+		// the upstream details are kept in the system log only
+		err.OpenAIError.Message = "上游服务出现错误，请稍后再试"
+		err.OpenAIError.Param = ""
+	}
+	c.JSON(err.StatusCode, gin.H{
+		"error": err.OpenAIError,
+	})
+}
+
+// reportRelayError logs the error and disables the channel if the error tells it is unusable
+func reportRelayError(c *gin.Context, err *OpenAIErrorWithStatusCode) {
+	channelId := c.GetInt("channel_id")
+	common.SysError(fmt.Sprintf("relay error (channel #%d): %s", channelId, err.Message))
+	// https://platform.openai.com/docs/guides/error-codes/api-errors
+	disable := shouldDisableChannel(&err.OpenAIError)
+	if keyHash := c.GetString("channel_key_hash"); keyHash != "" && !err.quotaExhausted && (err.Type != "one_api_error" || err.StatusCode >= http.StatusInternalServerError) {
+		// only the failed key is disabled while the channel has other ones
+		dead := isKeyUnusable(&err.OpenAIError) || err.StatusCode == http.StatusUnauthorized
+		if model.RecordChannelKeyFailure(channelId, keyHash, err.Message, dead) > 0 {
+			disable = false
 		}
-		channelId := c.GetInt("channel_id")
-		common.SysError(fmt.Sprintf("relay error (channel #%d): %s", channelId, err.Message))
-		// https://platform.openai.com/docs/guides/error-codes/api-errors
-		if shouldDisableChannel(&err.OpenAIError) {
-			channelId := c.GetInt("channel_id")
-			channelName := c.GetString("channel_name")
-			disableChannel(channelId, channelName, err.Message)
+		if err.StatusCode == http.StatusTooManyRequests && !dead {
+			model.CoolDownChannelKey(channelId, keyHash)
 		}
 	}
+	if disable {
+		channelName := c.GetString("channel_name")
+		disableChannel(channelId, channelName, err.Message)
+	}
 }
 
 func RelayNotImplemented(c *gin.Context) {
@@ -0,0 +1,188 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func GetSelfSpendingAlerts(c *gin.Context) {
+	alerts, err := model.GetUserSpendingAlerts(c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    alerts,
+	})
+}
+
+func validateSpendingAlert(alert *model.SpendingAlert, userId int) string {
+	if len(alert.Name) == 0 || len(alert.Name) > 20 {
+		return "提醒规则名称长度必须在1-20之间"
+	}
+	if alert.Type != model.SpendingAlertTypeDaily && alert.Type != model.SpendingAlertTypeTotal {
+		return "无效的提醒类型"
+	}
+	if alert.Threshold <= 0 {
+		return "提醒额度必须大于 0"
+	}
+	if alert.TokenId != 0 {
+		if _, err := model.GetTokenByIds(alert.TokenId, userId); err != nil {
+			return "令牌不存在"
+		}
+	}
+	switch alert.Notifier {
+	case model.SpendingAlertNotifierEmail:
+		if email, _ := model.GetUserEmail(userId); email == "" {
+			return "请先绑定邮箱"
+		}
+	case model.SpendingAlertNotifierWebhook:
+		if !strings.HasPrefix(alert.WebhookURL, "http://") && !strings.HasPrefix(alert.WebhookURL, "https://") {
+			return "Webhook 地址必须以 http:// 或 https:// 开头"
+		}
+		if len(alert.WebhookURL) > 512 {
+			return "Webhook 地址过长"
+		}
+	default:
+		return "无效的通知方式"
+	}
+	return ""
+}
+
+func AddSpendingAlert(c *gin.Context) {
+	userId := c.GetInt("id")
+	alert := model.SpendingAlert{}
+	err := c.ShouldBindJSON(&alert)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if message := validateSpendingAlert(&alert, userId); message != "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": message,
+		})
+		return
+	}
+	count, err := model.CountUserSpendingAlerts(userId)
+	if err == nil && count >= model.MaxSpendingAlertsPerUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "提醒规则数量已达上限",
+		})
+		return
+	}
+	cleanAlert := model.SpendingAlert{
+		UserId:      userId,
+		Name:        alert.Name,
+		Type:        alert.Type,
+		TokenId:     alert.TokenId,
+		Threshold:   alert.Threshold,
+		Notifier:    alert.Notifier,
+		WebhookURL:  alert.WebhookURL,
+		Status:      common.SpendingAlertStatusEnabled,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = cleanAlert.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanAlert,
+	})
+}
+
+func UpdateSpendingAlert(c *gin.Context) {
+	userId := c.GetInt("id")
+	statusOnly := c.Query("status_only")
+	alert := model.SpendingAlert{}
+	err := c.ShouldBindJSON(&alert)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	cleanAlert, err := model.GetSpendingAlertByIds(alert.Id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if statusOnly != "" {
+		if alert.Status != common.SpendingAlertStatusEnabled && alert.Status != common.SpendingAlertStatusDisabled {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": "无效的状态",
+			})
+			return
+		}
+		cleanAlert.Status = alert.Status
+	} else {
+		// If you add more fields, please also update alert.Update()
+		cleanAlert.Name = alert.Name
+		cleanAlert.Type = alert.Type
+		cleanAlert.TokenId = alert.TokenId
+		cleanAlert.Threshold = alert.Threshold
+		cleanAlert.Notifier = alert.Notifier
+		cleanAlert.WebhookURL = alert.WebhookURL
+		if message := validateSpendingAlert(cleanAlert, userId); message != "" {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": message,
+			})
+			return
+		}
+	}
+	err = cleanAlert.Update()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    cleanAlert,
+	})
+}
+
+func DeleteSpendingAlert(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	err := model.DeleteSpendingAlertByIds(id, c.GetInt("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
@@ -0,0 +1,168 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type StatementLine struct {
+	*model.StatementItem
+	Amount float64 `json:"amount"`
+}
+
+type Statement struct {
+	Month        string           `json:"month"`
+	UserId       int              `json:"user_id"`
+	Username     string           `json:"username"`
+	Currency     string           `json:"currency"`
+	ExchangeRate float64          `json:"exchange_rate"`
+	Lines        []*StatementLine `json:"lines"`
+	TotalQuota   int64            `json:"total_quota"`
+	TotalAmount  float64          `json:"total_amount"`
+	BillingMode  int              `json:"billing_mode"`
+	Balance      int              `json:"balance"` // current quota, negative means the amount owed by a postpaid user
+}
+
+func quotaToStatementAmount(quota int64) float64 {
+	return common.QuotaToCurrencyAmount(float64(quota))
+}
+
+func buildStatement(userId int, month string) (*Statement, error) {
+	start, err := time.ParseInLocation("2006-01", month, time.Local)
+	if err != nil {
+		return nil, fmt.Errorf("无效的月份，格式应为 2006-01")
+	}
+	items, err := model.GetUserStatementItems(userId, start.Unix(), start.AddDate(0, 1, 0).Unix())
+	if err != nil {
+		return nil, err
+	}
+	user, err := model.GetUserById(userId, false)
+	if err != nil {
+		return nil, err
+	}
+	statement := &Statement{
+		Month:        month,
+		UserId:       userId,
+		Username:     user.Username,
+		BillingMode:  user.BillingMode,
+		Balance:      user.Quota,
+		Currency:     common.StatementCurrency,
+		ExchangeRate: common.StatementExchangeRate,
+		Lines:        make([]*StatementLine, 0, len(items)),
+	}
+	for _, item := range items {
+		line := &StatementLine{StatementItem: item, Amount: quotaToStatementAmount(item.Quota)}
+		statement.Lines = append(statement.Lines, line)
+		statement.TotalQuota += item.Quota
+	}
+	statement.TotalAmount = quotaToStatementAmount(statement.TotalQuota)
+	return statement, nil
+}
+
+func (statement *Statement) CSV() ([]byte, error) {
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"month", "username", "model", "token", "requests", "prompt_tokens", "completion_tokens", "quota", "amount", "currency"})
+	for _, line := range statement.Lines {
+		_ = writer.Write([]string{
+			statement.Month,
+			statement.Username,
+			line.ModelName,
+			line.TokenName,
+			strconv.Itoa(line.Requests),
+			strconv.FormatInt(line.PromptTokens, 10),
+			strconv.FormatInt(line.CompletionTokens, 10),
+			strconv.FormatInt(line.Quota, 10),
+			strconv.FormatFloat(line.Amount, 'f', 6, 64),
+			statement.Currency,
+		})
+	}
+	_ = writer.Write([]string{statement.Month, statement.Username, "total", "", "", "", "",
+		strconv.FormatInt(statement.TotalQuota, 10), strconv.FormatFloat(statement.TotalAmount, 'f', 6, 64), statement.Currency})
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func (statement *Statement) PDF() []byte {
+	pdf := common.SimplePDF{}
+	pdf.AddLine(fmt.Sprintf("%s - Monthly Statement", common.SystemName))
+	pdf.AddLine("")
+	pdf.AddLine(fmt.Sprintf("Month:    %s", statement.Month))
+	pdf.AddLine(fmt.Sprintf("User:     %s (#%d)", statement.Username, statement.UserId))
+	pdf.AddLine(fmt.Sprintf("Currency: %s (1 USD = %g %s)", statement.Currency, statement.ExchangeRate, statement.Currency))
+	pdf.AddLine("")
+	rowFormat := "%-24.24s %-16.16s %8s %12s %12s %12s"
+	pdf.AddLine(fmt.Sprintf(rowFormat, "Model", "Token", "Requests", "Prompt", "Completion", "Amount"))
+	for _, line := range statement.Lines {
+		pdf.AddLine(fmt.Sprintf(rowFormat, line.ModelName, line.TokenName, strconv.Itoa(line.Requests),
+			strconv.FormatInt(line.PromptTokens, 10), strconv.FormatInt(line.CompletionTokens, 10), strconv.FormatFloat(line.Amount, 'f', 6, 64)))
+	}
+	pdf.AddLine("")
+	pdf.AddLine(fmt.Sprintf("Total: %.6f %s", statement.TotalAmount, statement.Currency))
+	if statement.BillingMode == common.UserBillingModePostpaid && statement.Balance < 0 {
+		pdf.AddLine(fmt.Sprintf("Amount due: %.6f %s", quotaToStatementAmount(int64(-statement.Balance)), statement.Currency))
+	}
+	return pdf.Bytes()
+}
+
+func respondStatement(c *gin.Context, userId int) {
+	month := c.Query("month")
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	statement, err := buildStatement(userId, month)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	filename := fmt.Sprintf("statement-%s-%d", month, userId)
+	switch c.Query("format") {
+	case "csv":
+		data, err := statement.CSV()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.csv", filename))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+	case "pdf":
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%s.pdf", filename))
+		c.Data(http.StatusOK, "application/pdf", statement.PDF())
+	default:
+		c.JSON(http.StatusOK, gin.H{
+			"success": true,
+			"message": "",
+			"data":    statement,
+		})
+	}
+}
+
+func GetSelfStatement(c *gin.Context) {
+	respondStatement(c, c.GetInt("id"))
+}
+
+func GetUserStatement(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	respondStatement(c, id)
+}
@@ -116,15 +116,54 @@ func AddToken(c *gin.Context) {
 		})
 		return
 	}
+	if token.ChannelGroup != "" && model.GetChannelGroupByName(token.ChannelGroup) == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "渠道组不存在",
+		})
+		return
+	}
+	if !model.IsValidContextTruncation(token.ContextTruncation) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的上下文截断策略",
+		})
+		return
+	}
+	if !model.IsValidPriority(token.Priority) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的优先级",
+		})
+		return
+	}
+	if token.OrganizationId != 0 {
+		if _, err := model.GetOrganizationMember(token.OrganizationId, c.GetInt("id")); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	cleanToken := model.Token{
-		UserId:         c.GetInt("id"),
-		Name:           token.Name,
-		Key:            common.GenerateKey(),
-		CreatedTime:    common.GetTimestamp(),
-		AccessedTime:   common.GetTimestamp(),
-		ExpiredTime:    token.ExpiredTime,
-		RemainQuota:    token.RemainQuota,
-		UnlimitedQuota: token.UnlimitedQuota,
+		UserId:               c.GetInt("id"),
+		Name:                 token.Name,
+		Key:                  common.GenerateKey(),
+		CreatedTime:          common.GetTimestamp(),
+		AccessedTime:         common.GetTimestamp(),
+		ExpiredTime:          token.ExpiredTime,
+		RemainQuota:          token.RemainQuota,
+		UnlimitedQuota:       token.UnlimitedQuota,
+		AutoDowngrade:        token.AutoDowngrade,
+		DataResidency:        token.DataResidency,
+		ChannelGroup:         token.ChannelGroup,
+		ContextTruncation:    token.ContextTruncation,
+		RaceEnabled:          token.RaceEnabled,
+		Priority:             token.Priority,
+		ResponseCacheEnabled: token.ResponseCacheEnabled,
+		SemanticCacheEnabled: token.SemanticCacheEnabled,
+		OrganizationId:       token.OrganizationId,
 	}
 	err = cleanToken.Insert()
 	if err != nil {
@@ -178,6 +217,36 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if token.ChannelGroup != "" && model.GetChannelGroupByName(token.ChannelGroup) == nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "渠道组不存在",
+		})
+		return
+	}
+	if !model.IsValidContextTruncation(token.ContextTruncation) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的上下文截断策略",
+		})
+		return
+	}
+	if !model.IsValidPriority(token.Priority) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的优先级",
+		})
+		return
+	}
+	if token.OrganizationId != 0 && statusOnly == "" {
+		if _, err := model.GetOrganizationMember(token.OrganizationId, userId); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+	}
 	cleanToken, err := model.GetTokenByIds(token.Id, userId)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -202,6 +271,7 @@ func UpdateToken(c *gin.Context) {
 			return
 		}
 	}
+	previousStatus := cleanToken.Status
 	if statusOnly != "" {
 		cleanToken.Status = token.Status
 	} else {
@@ -210,6 +280,15 @@ func UpdateToken(c *gin.Context) {
 		cleanToken.ExpiredTime = token.ExpiredTime
 		cleanToken.RemainQuota = token.RemainQuota
 		cleanToken.UnlimitedQuota = token.UnlimitedQuota
+		cleanToken.AutoDowngrade = token.AutoDowngrade
+		cleanToken.DataResidency = token.DataResidency
+		cleanToken.ChannelGroup = token.ChannelGroup
+		cleanToken.ContextTruncation = token.ContextTruncation
+		cleanToken.RaceEnabled = token.RaceEnabled
+		cleanToken.Priority = token.Priority
+		cleanToken.ResponseCacheEnabled = token.ResponseCacheEnabled
+		cleanToken.SemanticCacheEnabled = token.SemanticCacheEnabled
+		cleanToken.OrganizationId = token.OrganizationId
 	}
 	err = cleanToken.Update()
 	if err != nil {
@@ -219,6 +298,14 @@ func UpdateToken(c *gin.Context) {
 		})
 		return
 	}
+	if previousStatus != cleanToken.Status {
+		switch cleanToken.Status {
+		case common.TokenStatusEnabled:
+			model.FireTokenWebhook(model.WebhookEventTokenEnabled, cleanToken)
+		case common.TokenStatusDisabled:
+			model.FireTokenWebhook(model.WebhookEventTokenDisabled, cleanToken)
+		}
+	}
 	c.JSON(http.StatusOK, gin.H{
 		"success": true,
 		"message": "",
@@ -226,3 +313,98 @@ func UpdateToken(c *gin.Context) {
 	})
 	return
 }
+
+func RotateToken(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	userId := c.GetInt("id")
+	token, err := model.GetTokenByIds(id, userId)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	err = token.Rotate()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+	return
+}
+
+// UpdateTokenSystemPrompt lets the admins set the system prompt of any token, such as the usage policy of a company
+func UpdateTokenSystemPrompt(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var request struct {
+		SystemPrompt     string `json:"system_prompt"`
+		SystemPromptMode string `json:"system_prompt_mode"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if !model.IsValidSystemPromptMode(request.SystemPromptMode) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的系统提示模式",
+		})
+		return
+	}
+	token, err := model.UpdateTokenSystemPrompt(id, request.SystemPrompt, request.SystemPromptMode)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+	return
+}
+
+// UpdateTokenFineTuning lets the token manage the fine-tuning jobs, only the admins may grant it
+func UpdateTokenFineTuning(c *gin.Context) {
+	id, _ := strconv.Atoi(c.Param("id"))
+	var request struct {
+		FineTuningEnabled bool `json:"fine_tuning_enabled"`
+	}
+	err := c.ShouldBindJSON(&request)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	token, err := model.UpdateTokenFineTuningEnabled(id, request.FineTuningEnabled)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    token,
+	})
+	return
+}
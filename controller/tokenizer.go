@@ -0,0 +1,292 @@
+package controller
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/pkoukk/tiktoken-go"
+	"github.com/pkoukk/tiktoken-go-loader/assets"
+)
+
+// the SHA-256 of the encoding files published by OpenAI, the downloaded and the cached files are checked against them
+var tokenizerFileSHA256 = map[string]string{
+	"cl100k_base.tiktoken": "223921b76ee99bde995b7ff738513eef100fb51d18c93597a113bcffe865b2a7",
+	"p50k_base.tiktoken":   "94b5ca7dff4d00767bc256fdd1b27e5b17361d7b8a5f968547f9f23eb70d2069",
+	"r50k_base.tiktoken":   "306cd27f03c1a714eca7108e03d66b7dc042abe8c258b44c199a7ed9838dd930",
+}
+
+const (
+	TokenizerSourceCache    = "cache"
+	TokenizerSourceDownload = "download"
+	TokenizerSourceEmbedded = "embedded"
+)
+
+type TokenizerFileStatus struct {
+	File       string `json:"file"`
+	SHA256     string `json:"sha256"`
+	Source     string `json:"source"`
+	Error      string `json:"error,omitempty"`
+	LoadedTime int64  `json:"loaded_time"`
+}
+
+var tokenizerFileStatus = map[string]*TokenizerFileStatus{}
+var tokenizerFileStatusLock sync.RWMutex
+
+// tokenizerBpeLoader is called by tiktoken the first time an encoding is used, the file comes from the cache,
+// the download or the copy embedded in the binary, in that order, the download is skipped in the offline mode
+type tokenizerBpeLoader struct{}
+
+func (l *tokenizerBpeLoader) LoadTiktokenBpe(tiktokenBpeFile string) (map[string]int, error) {
+	fileName := path.Base(tiktokenBpeFile)
+	status := &TokenizerFileStatus{File: fileName, SHA256: tokenizerFileSHA256[fileName]}
+	var contents []byte
+	var err error
+	if !common.TokenizerOfflineEnabled {
+		contents, status.Source, err = readTokenizerFileCached(tiktokenBpeFile)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to load tokenizer file %s: %s, using the embedded one", fileName, err.Error()))
+			status.Error = err.Error()
+		}
+	}
+	if contents == nil {
+		contents, err = assets.Assets.ReadFile(fileName)
+		if err != nil {
+			return nil, err
+		}
+		status.Source = TokenizerSourceEmbedded
+	}
+	ranks, err := parseTokenizerFile(contents)
+	if err != nil {
+		return nil, err
+	}
+	status.LoadedTime = common.GetTimestamp()
+	tokenizerFileStatusLock.Lock()
+	tokenizerFileStatus[fileName] = status
+	tokenizerFileStatusLock.Unlock()
+	return ranks, nil
+}
+
+func verifyTokenizerFile(fileName string, contents []byte) error {
+	expected, ok := tokenizerFileSHA256[fileName]
+	if !ok {
+		return nil
+	}
+	sum := sha256.Sum256(contents)
+	if actual := hex.EncodeToString(sum[:]); actual != expected {
+		return fmt.Errorf("sha256 mismatch, expected %s, got %s", expected, actual)
+	}
+	return nil
+}
+
+// getTokenizerCacheDir follows tiktoken, so that the files cached before are still used
+func getTokenizerCacheDir() string {
+	if dir := os.Getenv("TIKTOKEN_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	if dir := os.Getenv("DATA_GYM_CACHE_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "data-gym-cache")
+}
+
+func readTokenizerFileCached(url string) ([]byte, string, error) {
+	fileName := path.Base(url)
+	cachePath := filepath.Join(getTokenizerCacheDir(), fmt.Sprintf("%x", sha1.Sum([]byte(url))))
+	if contents, err := os.ReadFile(cachePath); err == nil {
+		if err = verifyTokenizerFile(fileName, contents); err == nil {
+			return contents, TokenizerSourceCache, nil
+		}
+		common.SysError(fmt.Sprintf("cached tokenizer file %s is corrupted: %s, downloading it again", fileName, err.Error()))
+		_ = os.Remove(cachePath)
+	}
+	client := http.Client{Timeout: time.Minute}
+	resp, err := client.Get(url)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, "", fmt.Errorf("status code %d", resp.StatusCode)
+	}
+	contents, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	if err = verifyTokenizerFile(fileName, contents); err != nil {
+		return nil, "", err
+	}
+	// a failure to cache is not fatal, the file is downloaded again the next time
+	err = os.MkdirAll(filepath.Dir(cachePath), 0755)
+	if err == nil {
+		tmpPath := cachePath + "." + common.GetUUID() + ".tmp"
+		err = os.WriteFile(tmpPath, contents, 0644)
+		if err == nil {
+			err = os.Rename(tmpPath, cachePath)
+		}
+	}
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to cache tokenizer file %s: %s", fileName, err.Error()))
+	}
+	return contents, TokenizerSourceDownload, nil
+}
+
+func parseTokenizerFile(contents []byte) (map[string]int, error) {
+	ranks := make(map[string]int)
+	for _, line := range strings.Split(string(contents), "\n") {
+		if line == "" {
+			continue
+		}
+		token, rank, found := strings.Cut(line, " ")
+		if !found {
+			return nil, errors.New("invalid tokenizer file")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			return nil, err
+		}
+		ranks[string(decoded)], err = strconv.Atoi(rank)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ranks, nil
+}
+
+func InitTokenEncoders() {
+	tiktoken.SetBpeLoader(&tokenizerBpeLoader{})
+	if common.TokenizerOfflineEnabled {
+		common.SysLog("tokenizer offline mode enabled, using the embedded encodings")
+	}
+}
+
+// getModelEncoding tells which encoding getTokenEncoder uses for the model, like tiktoken.EncodingForModel does
+func getModelEncoding(model string) (string, bool) {
+	if encoding, ok := tiktoken.MODEL_TO_ENCODING[model]; ok {
+		return encoding, false
+	}
+	for prefix, encoding := range tiktoken.MODEL_PREFIX_TO_ENCODING {
+		if strings.HasPrefix(model, prefix) {
+			return encoding, false
+		}
+	}
+	return tiktoken.MODEL_TO_ENCODING["gpt-3.5-turbo"], true
+}
+
+type TokenizerModel struct {
+	Model    string `json:"model"`
+	Encoding string `json:"encoding"`
+	Fallback bool   `json:"fallback"`
+}
+
+// GetTokenizer shows the encoding of each model and where the encoding files are loaded from,
+// the files are loaded the first time an encoding is used so the unused ones are not listed
+func GetTokenizer(c *gin.Context) {
+	names := make([]string, 0, len(common.ModelRatio))
+	if model := c.Query("model"); model != "" {
+		names = append(names, model)
+	} else {
+		for name := range common.ModelRatio {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+	models := make([]TokenizerModel, 0, len(names))
+	for _, name := range names {
+		encoding, fallback := getModelEncoding(name)
+		models = append(models, TokenizerModel{Model: name, Encoding: encoding, Fallback: fallback})
+	}
+	tokenizerFileStatusLock.RLock()
+	files := make([]TokenizerFileStatus, 0, len(tokenizerFileSHA256))
+	for fileName, sha := range tokenizerFileSHA256 {
+		status, ok := tokenizerFileStatus[fileName]
+		if !ok {
+			status = &TokenizerFileStatus{File: fileName, SHA256: sha}
+		}
+		files = append(files, *status)
+	}
+	tokenizerFileStatusLock.RUnlock()
+	sort.Slice(files, func(i, j int) bool {
+		return files[i].File < files[j].File
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"offline":     common.TokenizerOfflineEnabled,
+			"approximate": common.ApproximateTokenEnabled,
+			"cache_dir":   getTokenizerCacheDir(),
+			"files":       files,
+			"models":      models,
+		},
+	})
+}
+
+type TokenizeRequest struct {
+	Model    string    `json:"model"`
+	Messages []Message `json:"messages"`
+	Prompt   any       `json:"prompt"`
+	Tools    any       `json:"tools"`
+}
+
+type TokenizeResponse struct {
+	Model        string `json:"model"`
+	PromptTokens int    `json:"prompt_tokens"`
+	Encoding     string `json:"encoding"`
+	Approximate  bool   `json:"approximate"` // counted by the length of the text, see ApproximateTokenEnabled
+}
+
+// Tokenize counts the prompt tokens of the messages or of the prompt the same way as the relay does,
+// so that the clients can size their prompts without a tokenizer of their own
+func Tokenize(c *gin.Context) {
+	var request TokenizeRequest
+	err := common.UnmarshalBodyReusable(c, &request)
+	if err != nil {
+		openaiErr := errorWrapper(err, "bind_request_body_failed", http.StatusBadRequest)
+		c.JSON(openaiErr.StatusCode, gin.H{"error": openaiErr.OpenAIError})
+		return
+	}
+	if request.Model == "" {
+		openaiErr := errorWrapper(errors.New("model is required"), "required_field_missing", http.StatusBadRequest)
+		c.JSON(openaiErr.StatusCode, gin.H{"error": openaiErr.OpenAIError})
+		return
+	}
+	if len(request.Messages) == 0 && (request.Prompt == nil || request.Prompt == "") {
+		openaiErr := errorWrapper(errors.New("field messages or prompt is required"), "required_field_missing", http.StatusBadRequest)
+		c.JSON(openaiErr.StatusCode, gin.H{"error": openaiErr.OpenAIError})
+		return
+	}
+	promptTokens := 0
+	if len(request.Messages) > 0 {
+		promptTokens = countTokenMessages(request.Messages, request.Model)
+	} else {
+		promptTokens = countTokenInput(request.Prompt, request.Model)
+	}
+	if request.Tools != nil {
+		tools, _ := json.Marshal(request.Tools)
+		promptTokens += countTokenText(string(tools), request.Model)
+	}
+	encoding, _ := getModelEncoding(request.Model)
+	c.JSON(http.StatusOK, TokenizeResponse{
+		Model:        request.Model,
+		PromptTokens: promptTokens,
+		Encoding:     encoding,
+		Approximate:  common.ApproximateTokenEnabled,
+	})
+}
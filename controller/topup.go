@@ -0,0 +1,175 @@
+package controller
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+type payRequest struct {
+	Amount        int    `json:"amount"`
+	PaymentMethod string `json:"payment_method"`
+}
+
+var epayPaymentMethods = map[string]bool{
+	"alipay": true,
+	"wxpay":  true,
+	"qqpay":  true,
+}
+
+func getTopUpMoney(amount int) float64 {
+	return float64(amount) * common.TopUpPrice
+}
+
+func RequestPay(c *gin.Context) {
+	req := payRequest{}
+	err := c.ShouldBindJSON(&req)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if common.EpayAddress == "" || common.EpayId == "" || common.EpaySecret == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启在线充值",
+		})
+		return
+	}
+	if req.Amount < common.MinTopUp {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": fmt.Sprintf("充值数量不能小于 %d", common.MinTopUp),
+		})
+		return
+	}
+	if !epayPaymentMethods[req.PaymentMethod] {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "不支持的支付方式",
+		})
+		return
+	}
+	id := c.GetInt("id")
+	order := model.TopUpOrder{
+		UserId:        id,
+		TradeNo:       fmt.Sprintf("%d%s", time.Now().UnixMilli(), common.GetRandomString(6)),
+		PaymentMethod: req.PaymentMethod,
+		Amount:        req.Amount,
+		Quota:         int(float64(req.Amount) * common.QuotaPerUnit),
+		Money:         getTopUpMoney(req.Amount),
+		Status:        common.TopUpOrderStatusPending,
+		CreatedTime:   common.GetTimestamp(),
+	}
+	err = order.Insert()
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	payURL := common.EpayPayURL(map[string]string{
+		"pid":          common.EpayId,
+		"type":         order.PaymentMethod,
+		"out_trade_no": order.TradeNo,
+		"notify_url":   fmt.Sprintf("%s/api/user/epay/notify", common.ServerAddress),
+		"return_url":   fmt.Sprintf("%s/topup", common.ServerAddress),
+		"name":         fmt.Sprintf("%s 充值 %d", common.SystemName, order.Amount),
+		"money":        strconv.FormatFloat(order.Money, 'f', 2, 64),
+	})
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"trade_no": order.TradeNo,
+			"money":    order.Money,
+			"url":      payURL,
+		},
+	})
+	return
+}
+
+// EpayNotify is called by the payment gateway, the response body must be "success" or it keeps retrying
+func EpayNotify(c *gin.Context) {
+	params := make(map[string]string)
+	for k, v := range c.Request.URL.Query() {
+		params[k] = v[0]
+	}
+	if c.Request.Method == http.MethodPost {
+		_ = c.Request.ParseForm()
+		for k, v := range c.Request.PostForm {
+			params[k] = v[0]
+		}
+	}
+	if !common.EpayVerify(params, common.EpaySecret) || params["pid"] != common.EpayId {
+		common.SysError("epay notify with invalid signature, trade no: " + params["out_trade_no"])
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	if params["trade_status"] != "TRADE_SUCCESS" {
+		c.String(http.StatusOK, "success")
+		return
+	}
+	money, err := strconv.ParseFloat(params["money"], 64)
+	if err != nil {
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	err = model.CompleteTopUpOrder(params["out_trade_no"], params["trade_no"], money)
+	if err != nil {
+		common.SysError(fmt.Sprintf("failed to complete top up order %s: %s", params["out_trade_no"], err.Error()))
+		c.String(http.StatusOK, "fail")
+		return
+	}
+	c.String(http.StatusOK, "success")
+}
+
+func GetSelfTopUpOrders(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	orders, err := model.GetUserTopUpOrders(c.GetInt("id"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orders,
+	})
+	return
+}
+
+func GetAllTopUpOrders(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	orders, err := model.GetAllTopUpOrders(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    orders,
+	})
+	return
+}
@@ -0,0 +1,283 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/gin-contrib/sessions"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+)
+
+// a pending login expires if the code is not entered in time
+const totpPendingLoginTTL = 5 * 60
+
+type TOTPRequest struct {
+	Code string `json:"code"`
+}
+
+func LoginTOTP(c *gin.Context) {
+	session := sessions.Default(c)
+	pendingId, _ := session.Get("totp_pending_id").(int)
+	pendingAt, _ := session.Get("totp_pending_at").(int64)
+	if pendingId == 0 || common.GetTimestamp()-pendingAt > totpPendingLoginTTL {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "登录已过期，请重新登录",
+			"success": false,
+		})
+		return
+	}
+	var req TOTPRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "无效的参数",
+			"success": false,
+		})
+		return
+	}
+	user, err := model.GetUserById(pendingId, true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	ok, err := user.VerifyTOTP(req.Code)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"message": err.Error(),
+			"success": false,
+		})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "两步验证码错误或已使用",
+			"success": false,
+		})
+		return
+	}
+	if user.Status != common.UserStatusEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"message": "用户已被封禁",
+			"success": false,
+		})
+		return
+	}
+	completeLogin(user, c)
+}
+
+// SetupTOTP starts an enrollment, the secret only takes effect once EnableTOTP confirms a code generated from it
+func SetupTOTP(c *gin.Context) {
+	user, err := model.GetUserById(c.GetInt("id"), true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "已开启两步验证",
+		})
+		return
+	}
+	secret := common.GenerateTOTPSecret()
+	err = model.SetUserTOTPSecret(user.Id, secret)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"secret": secret,
+			"url":    common.GetTOTPURL(user.Username, secret),
+		},
+	})
+}
+
+// EnableTOTP confirms the enrollment with a code, the recovery codes are only shown in its response
+func EnableTOTP(c *gin.Context) {
+	var req TOTPRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	user, err := model.GetUserById(c.GetInt("id"), true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "已开启两步验证",
+		})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "请先获取两步验证密钥",
+		})
+		return
+	}
+	counter := common.ValidateTOTP(user.TOTPSecret, req.Code)
+	if counter == 0 {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "两步验证码错误",
+		})
+		return
+	}
+	recoveryCodes := common.GenerateRecoveryCodes()
+	err = model.EnableUserTOTP(user.Id, recoveryCodes, counter)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	session := sessions.Default(c)
+	if session.Get("id") != nil {
+		session.Set("totp_enabled", true)
+		_ = session.Save()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+// verifySelfTOTP checks the code of the logged-in user before the two-factor authentication is changed, it writes
+// the response if the code is refused
+func verifySelfTOTP(c *gin.Context) *model.User {
+	var req TOTPRequest
+	err := json.NewDecoder(c.Request.Body).Decode(&req)
+	if err != nil || req.Code == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return nil
+	}
+	user, err := model.GetUserById(c.GetInt("id"), true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return nil
+	}
+	if !user.TOTPEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "未开启两步验证",
+		})
+		return nil
+	}
+	ok, err := user.VerifyTOTP(req.Code)
+	if err != nil || !ok {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "两步验证码错误或已使用",
+		})
+		return nil
+	}
+	return user
+}
+
+func DisableTOTP(c *gin.Context) {
+	user := verifySelfTOTP(c)
+	if user == nil {
+		return
+	}
+	if common.TOTPRequiredForAdminEnabled && user.Role >= common.RoleAdminUser {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员账户必须开启两步验证",
+		})
+		return
+	}
+	err := model.DisableUserTOTP(user.Id)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	session := sessions.Default(c)
+	if session.Get("id") != nil {
+		session.Set("totp_enabled", false)
+		_ = session.Save()
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+	})
+}
+
+// RegenerateRecoveryCodes replaces all the recovery codes, the unused ones stop working
+func RegenerateRecoveryCodes(c *gin.Context) {
+	user := verifySelfTOTP(c)
+	if user == nil {
+		return
+	}
+	recoveryCodes := common.GenerateRecoveryCodes()
+	err := model.UpdateUserRecoveryCodes(user.Id, recoveryCodes)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"recovery_codes": recoveryCodes,
+		},
+	})
+}
+
+func GetTOTPStatus(c *gin.Context) {
+	user, err := model.GetUserById(c.GetInt("id"), true)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data": gin.H{
+			"enabled":             user.TOTPEnabled,
+			"recovery_codes_left": user.CountRecoveryCodes(),
+			"required":            common.TOTPRequiredForAdminEnabled && user.Role >= common.RoleAdminUser,
+		},
+	})
+}
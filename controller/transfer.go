@@ -0,0 +1,90 @@
+package controller
+
+import (
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+)
+
+type transferQuotaRequest struct {
+	Username string `json:"username"`
+	Quota    int    `json:"quota"`
+}
+
+func TransferQuota(c *gin.Context) {
+	if !common.QuotaTransferEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员未开启额度转账",
+		})
+		return
+	}
+	request := transferQuotaRequest{}
+	err := c.ShouldBindJSON(&request)
+	if err != nil || request.Username == "" {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的参数",
+		})
+		return
+	}
+	transfer, err := model.TransferQuota(c.GetInt("id"), request.Username, request.Quota)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    transfer,
+	})
+	return
+}
+
+func GetSelfQuotaTransfers(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	transfers, err := model.GetUserQuotaTransfers(c.GetInt("id"), p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    transfers,
+	})
+	return
+}
+
+func GetAllQuotaTransfers(c *gin.Context) {
+	p, _ := strconv.Atoi(c.Query("p"))
+	if p < 0 {
+		p = 0
+	}
+	transfers, err := model.GetAllQuotaTransfers(p*common.ItemsPerPage, common.ItemsPerPage)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    transfers,
+	})
+	return
+}
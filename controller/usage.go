@@ -0,0 +1,220 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// escapePrometheusLabel escapes a label value as required by the Prometheus text format
+func escapePrometheusLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
+
+func GetMetrics(c *gin.Context) {
+	counters := model.GetTenantUsageCounters()
+	sort.Slice(counters, func(i, j int) bool {
+		if counters[i].Tenant != counters[j].Tenant {
+			return counters[i].Tenant < counters[j].Tenant
+		}
+		return counters[i].ModelName < counters[j].ModelName
+	})
+	var buf bytes.Buffer
+	metrics := []struct {
+		name  string
+		help  string
+		value func(counter *model.TenantUsageCounter) int64
+	}{
+		{"one_api_tenant_requests_total", "Number of billed requests per tenant and model.", func(counter *model.TenantUsageCounter) int64 { return int64(counter.Requests) }},
+		{"one_api_tenant_prompt_tokens_total", "Prompt tokens per tenant and model.", func(counter *model.TenantUsageCounter) int64 { return counter.PromptTokens }},
+		{"one_api_tenant_completion_tokens_total", "Completion tokens per tenant and model.", func(counter *model.TenantUsageCounter) int64 { return counter.CompletionTokens }},
+		{"one_api_tenant_quota_total", "Quota consumed per tenant and model.", func(counter *model.TenantUsageCounter) int64 { return counter.Quota }},
+	}
+	for _, metric := range metrics {
+		buf.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s counter\n", metric.name, metric.help, metric.name))
+		for i := range counters {
+			buf.WriteString(fmt.Sprintf("%s{tenant=\"%s\",model=\"%s\"} %d\n", metric.name,
+				escapePrometheusLabel(counters[i].Tenant), escapePrometheusLabel(counters[i].ModelName), metric.value(&counters[i])))
+		}
+	}
+	drifts := getStreamUsageDrifts()
+	driftModels := make([]string, 0, len(drifts))
+	for modelName := range drifts {
+		driftModels = append(driftModels, modelName)
+	}
+	sort.Strings(driftModels)
+	buf.WriteString("# HELP one_api_stream_usage_reported_tokens_total Completion tokens reported by upstreams in the verified streams.\n# TYPE one_api_stream_usage_reported_tokens_total counter\n")
+	for _, modelName := range driftModels {
+		buf.WriteString(fmt.Sprintf("one_api_stream_usage_reported_tokens_total{model=\"%s\"} %d\n", escapePrometheusLabel(modelName), drifts[modelName].ReportedTokens))
+	}
+	buf.WriteString("# HELP one_api_stream_usage_counted_tokens_total Completion tokens counted locally in the verified streams.\n# TYPE one_api_stream_usage_counted_tokens_total counter\n")
+	for _, modelName := range driftModels {
+		buf.WriteString(fmt.Sprintf("one_api_stream_usage_counted_tokens_total{model=\"%s\"} %d\n", escapePrometheusLabel(modelName), drifts[modelName].CountedTokens))
+	}
+	circuits := model.GetChannelCircuits()
+	buf.WriteString("# HELP one_api_channel_circuit_state State of the circuit breaker per channel, 0 closed, 1 open, 2 half-open.\n# TYPE one_api_channel_circuit_state gauge\n")
+	for _, circuit := range circuits {
+		state := 0
+		switch circuit.State {
+		case model.CircuitStateOpen:
+			state = 1
+		case model.CircuitStateHalfOpen:
+			state = 2
+		}
+		buf.WriteString(fmt.Sprintf("one_api_channel_circuit_state{channel=\"%d\"} %d\n", circuit.ChannelId, state))
+	}
+	buf.WriteString("# HELP one_api_channel_circuit_opened_total Number of times the circuit breaker of a channel opened.\n# TYPE one_api_channel_circuit_opened_total counter\n")
+	for _, circuit := range circuits {
+		buf.WriteString(fmt.Sprintf("one_api_channel_circuit_opened_total{channel=\"%d\"} %d\n", circuit.ChannelId, circuit.OpenedCount))
+	}
+	queues := model.GetChannelQueueStats()
+	queueMetrics := []struct {
+		name       string
+		help       string
+		metricType string
+		value      func(stat *model.ChannelQueueStat) string
+	}{
+		{"one_api_channel_queue_waiting", "Requests waiting for a channel of the model.", "gauge", func(stat *model.ChannelQueueStat) string { return strconv.Itoa(stat.Waiting) }},
+		{"one_api_channel_queue_enqueued_total", "Requests queued because the channels of the model were at their rate limits.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatInt(stat.Enqueued, 10) }},
+		{"one_api_channel_queue_released_total", "Queued requests which got a channel.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatInt(stat.Released, 10) }},
+		{"one_api_channel_queue_timeouts_total", "Queued requests which gave up waiting for a channel.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatInt(stat.TimedOut, 10) }},
+		{"one_api_channel_queue_rejected_total", "Requests refused because the queue was full.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatInt(stat.Rejected, 10) }},
+		{"one_api_channel_queue_shed_total", "Queued requests dropped for the requests of a higher priority.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatInt(stat.Shed, 10) }},
+		{"one_api_channel_queue_wait_seconds_total", "Seconds the released requests waited in the queue.", "counter", func(stat *model.ChannelQueueStat) string { return strconv.FormatFloat(stat.WaitTime, 'f', 3, 64) }},
+	}
+	for _, metric := range queueMetrics {
+		buf.WriteString(fmt.Sprintf("# HELP %s %s\n# TYPE %s %s\n", metric.name, metric.help, metric.name, metric.metricType))
+		for i := range queues {
+			buf.WriteString(fmt.Sprintf("%s{model=\"%s\"} %s\n", metric.name, escapePrometheusLabel(queues[i].Model), metric.value(&queues[i])))
+		}
+	}
+	c.Data(http.StatusOK, "text/plain; version=0.0.4; charset=utf-8", buf.Bytes())
+}
+
+func buildTenantUsageCSV(month string) ([]byte, error) {
+	usages, err := model.GetMonthlyTenantUsages(month)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	writer := csv.NewWriter(&buf)
+	_ = writer.Write([]string{"month", "tenant", "model", "requests", "prompt_tokens", "completion_tokens", "quota", "amount_usd", "amount", "currency"})
+	for _, usage := range usages {
+		_ = writer.Write([]string{
+			month,
+			usage.Tenant,
+			usage.ModelName,
+			strconv.Itoa(usage.Requests),
+			strconv.FormatInt(usage.PromptTokens, 10),
+			strconv.FormatInt(usage.CompletionTokens, 10),
+			strconv.FormatInt(usage.Quota, 10),
+			strconv.FormatFloat(float64(usage.Quota)/common.QuotaPerUnit, 'f', 6, 64),
+			strconv.FormatFloat(common.QuotaToDisplayAmount(float64(usage.Quota)), 'f', 6, 64),
+			common.GetQuotaDisplayType(),
+		})
+	}
+	writer.Flush()
+	return buf.Bytes(), writer.Error()
+}
+
+func exportTenantUsage(month string) (string, error) {
+	data, err := buildTenantUsageCSV(month)
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("tenant-usage-%s.csv", month)
+	var locations []string
+	if dir := os.Getenv("USAGE_EXPORT_DIR"); dir != "" {
+		path := filepath.Join(dir, name)
+		err = os.WriteFile(path, data, 0644)
+		if err != nil {
+			return "", err
+		}
+		locations = append(locations, path)
+	}
+	if common.S3Enabled() {
+		key := "usage/" + name
+		err = common.S3PutObject(key, data, "text/csv")
+		if err != nil {
+			return "", err
+		}
+		locations = append(locations, fmt.Sprintf("s3://%s/%s", common.S3Bucket, key))
+	}
+	return strings.Join(locations, ","), nil
+}
+
+// AutomaticallyExportTenantUsage exports the usage of the previous calendar month once it has ended
+func AutomaticallyExportTenantUsage(frequency int) {
+	for {
+		now := time.Now()
+		month := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, now.Location()).AddDate(0, -1, 0).Format("2006-01")
+		if !model.IsUsageExported(month) {
+			// make sure the usage of the last day has reached the database
+			model.FlushTenantUsages()
+			location, err := exportTenantUsage(month)
+			if err != nil {
+				common.SysError("failed to export tenant usage: " + err.Error())
+			} else {
+				err = model.RecordUsageExport(month, location)
+				if err != nil {
+					common.SysError("failed to record usage export: " + err.Error())
+				}
+				common.SysLog(fmt.Sprintf("tenant usage of %s exported to %s", month, location))
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Minute)
+	}
+}
+
+func GetTenantUsage(c *gin.Context) {
+	month := c.Query("month")
+	if month == "" {
+		month = time.Now().Format("2006-01")
+	}
+	if _, err := time.Parse("2006-01", month); err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的月份，格式应为 2006-01",
+		})
+		return
+	}
+	model.FlushTenantUsages()
+	if c.Query("format") == "csv" {
+		data, err := buildTenantUsageCSV(month)
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=tenant-usage-%s.csv", month))
+		c.Data(http.StatusOK, "text/csv; charset=utf-8", data)
+		return
+	}
+	usages, err := model.GetMonthlyTenantUsages(month)
+	if err != nil {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": err.Error(),
+		})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{
+		"success": true,
+		"message": "",
+		"data":    usages,
+	})
+	return
+}
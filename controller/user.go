@@ -59,12 +59,42 @@ func Login(c *gin.Context) {
 }
 
 // setup session & cookies and then return user info
+// setupLogin logs the user in once the first factor is checked, the users with the two-factor authentication enabled
+// get a pending login which LoginTOTP completes
 func setupLogin(user *model.User, c *gin.Context) {
+	if user.TOTPEnabled {
+		session := sessions.Default(c)
+		session.Clear()
+		session.Set("totp_pending_id", user.Id)
+		session.Set("totp_pending_at", common.GetTimestamp())
+		err := session.Save()
+		if err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"message": "无法保存会话信息，请重试",
+				"success": false,
+			})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{
+			"message": "请输入两步验证码",
+			"success": false,
+			"data": gin.H{
+				"totp_required": true,
+			},
+		})
+		return
+	}
+	completeLogin(user, c)
+}
+
+func completeLogin(user *model.User, c *gin.Context) {
 	session := sessions.Default(c)
+	session.Clear()
 	session.Set("id", user.Id)
 	session.Set("username", user.Username)
 	session.Set("role", user.Role)
 	session.Set("status", user.Status)
+	session.Set("totp_enabled", user.TOTPEnabled)
 	err := session.Save()
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{
@@ -373,10 +403,18 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if !model.IsValidPriority(updatedUser.Priority) {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "无效的优先级",
+		})
+		return
+	}
 	if updatedUser.Password == "$I_LOVE_U" {
 		updatedUser.Password = "" // rollback to what it should be
 	}
 	updatePassword := updatedUser.Password != ""
+	updatedUser.TOTPEnabled = false // only the two-factor authentication endpoints turn it on
 	if err := updatedUser.Update(updatePassword); err != nil {
 		c.JSON(http.StatusOK, gin.H{
 			"success": false,
@@ -384,6 +422,22 @@ func UpdateUser(c *gin.Context) {
 		})
 		return
 	}
+	if updatedUser.BillingMode != 0 {
+		if err := model.UpdateUserBillingMode(updatedUser.Id, updatedUser.BillingMode, updatedUser.CreditLimit); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		if originUser.BillingMode != updatedUser.BillingMode || originUser.CreditLimit != updatedUser.CreditLimit {
+			billingMode := "预付费"
+			if updatedUser.BillingMode == common.UserBillingModePostpaid {
+				billingMode = "后付费"
+			}
+			model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户计费模式设置为%s，信用额度 %s", billingMode, common.LogQuota(updatedUser.CreditLimit)))
+		}
+	}
 	if originUser.Quota != updatedUser.Quota {
 		model.RecordLog(originUser.Id, model.LogTypeManage, fmt.Sprintf("管理员将用户额度从 %s修改为 %s", common.LogQuota(originUser.Quota), common.LogQuota(updatedUser.Quota)))
 	}
@@ -648,6 +702,19 @@ func ManageUser(c *gin.Context) {
 			return
 		}
 		user.Role = common.RoleCommonUser
+	case "reset_totp":
+		// for the users who lost both their phone and their recovery codes
+		if err := model.DisableUserTOTP(user.Id); err != nil {
+			c.JSON(http.StatusOK, gin.H{
+				"success": false,
+				"message": err.Error(),
+			})
+			return
+		}
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPRecoveryCodes = ""
+		user.TOTPLastCounter = 0
 	}
 
 	if err := user.Update(false); err != nil {
@@ -0,0 +1,78 @@
+package controller
+
+import (
+	"fmt"
+	"one-api/common"
+	"one-api/model"
+	"sync"
+	"time"
+)
+
+var warmupDone = false
+var warmupLock sync.RWMutex
+
+func IsWarmedUp() bool {
+	warmupLock.RLock()
+	defer warmupLock.RUnlock()
+	return warmupDone
+}
+
+func finishWarmup() {
+	warmupLock.Lock()
+	warmupDone = true
+	warmupLock.Unlock()
+}
+
+// probeChannels sends a test request to every enabled channel concurrently, it establishes the upstream
+// connections and refreshes the response times, but never disables a channel since a deploy is a bad moment to judge
+func probeChannels() {
+	channels, err := model.GetAllChannels(0, 0, true)
+	if err != nil {
+		common.SysError("failed to fetch channels to probe: " + err.Error())
+		return
+	}
+	var wg sync.WaitGroup
+	semaphore := make(chan bool, 8)
+	for _, channel := range channels {
+		if channel.Status != common.ChannelStatusEnabled {
+			continue
+		}
+		wg.Add(1)
+		semaphore <- true
+		go func(channel *model.Channel) {
+			defer func() {
+				<-semaphore
+				wg.Done()
+			}()
+			tik := time.Now()
+			err, _ := testChannel(channel, &model.ChannelProbe{})
+			milliseconds := time.Since(tik).Milliseconds()
+			if err != nil {
+				common.SysError(fmt.Sprintf("warmup probe of channel #%d failed: %s", channel.Id, err.Error()))
+				return
+			}
+			channel.UpdateResponseTime(milliseconds)
+		}(channel)
+	}
+	wg.Wait()
+}
+
+// WarmUp runs once on startup, the readiness probe fails until it's done or WarmupTimeout has passed
+func WarmUp() {
+	done := make(chan bool, 1)
+	go func() {
+		startTime := time.Now()
+		warmed := model.WarmUpTokenCache(common.WarmupTokenCount)
+		if common.WarmupProbeChannels {
+			probeChannels()
+		}
+		common.SysLog(fmt.Sprintf("warmup finished in %s, %d tokens cached", time.Since(startTime).Round(time.Millisecond), warmed))
+		done <- true
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Duration(common.WarmupTimeout) * time.Second):
+		common.SysError(fmt.Sprintf("warmup isn't finished after %d seconds, marking the instance ready anyway", common.WarmupTimeout))
+	}
+	finishWarmup()
+}
@@ -12,6 +12,7 @@ import (
 	"one-api/router"
 	"os"
 	"strconv"
+	"strings"
 )
 
 //go:embed web/build
@@ -52,6 +53,19 @@ func main() {
 	if common.RedisEnabled {
 		model.InitChannelCache()
 	}
+	model.InitExperimentCache()
+	model.InitFeatureFlagCache()
+	model.InitPolicyCache()
+	model.InitChannelGroupCache()
+	model.InitModelAliasCache()
+	model.InitOptionOverrideCache()
+	controller.InitTokenEncoders()
+	if os.Getenv("RELAY_HOOK_PLUGINS") != "" {
+		err = middleware.LoadRelayHookPlugins(strings.Split(os.Getenv("RELAY_HOOK_PLUGINS"), ","))
+		if err != nil {
+			common.FatalLog("failed to load relay hook plugins: " + err.Error())
+		}
+	}
 	if os.Getenv("SYNC_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("SYNC_FREQUENCY"))
 		if err != nil {
@@ -59,10 +73,42 @@ func main() {
 		}
 		common.SyncFrequency = frequency
 		go model.SyncOptions(frequency)
+		go model.SyncExperimentCache(frequency)
+		go model.SyncFeatureFlagCache(frequency)
+		go model.SyncPolicyCache(frequency)
+		go model.SyncChannelGroupCache(frequency)
+		go model.SyncModelAliasCache(frequency)
+		go model.SyncOptionOverrideCache(frequency)
 		if common.RedisEnabled {
 			go model.SyncChannelCache(frequency)
 		}
 	}
+	if model.KubernetesConfigEnabled() {
+		err = model.LoadKubernetesConfig()
+		if err != nil {
+			common.FatalLog("failed to load kubernetes config: " + err.Error())
+		}
+		go model.SyncKubernetesConfig(common.KubernetesConfigSyncFrequency)
+	}
+	go model.SyncTenantUsages(60)
+	go model.SyncUserUsages(60)
+	go model.SyncChannelKeyUsages(60)
+	go model.SyncChannelSpends(60)
+	go model.SyncChannelUsages(60)
+	if common.IsMasterNode {
+		go model.SweepExpiredReservations(60)
+		go model.RetryWebhookDeliveries(30)
+		go model.AutomaticallyGrantDailyQuota(60)
+		go model.AutomaticallyExpireCredits(60)
+		go model.AutomaticallyEvaluateSpendingAlerts(60)
+		go controller.AutomaticallyCheckChannelBudgets(60)
+		go controller.AutomaticallyCheckChannelMaintenance(60)
+		go controller.AutomaticallyBillAssistantsRuns(60)
+		if os.Getenv("USAGE_EXPORT_DIR") != "" || common.S3Enabled() {
+			go controller.AutomaticallyExportTenantUsage(60)
+		}
+	}
+	go controller.WarmUp()
 	if os.Getenv("CHANNEL_UPDATE_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_UPDATE_FREQUENCY"))
 		if err != nil {
@@ -70,6 +116,13 @@ func main() {
 		}
 		go controller.AutomaticallyUpdateChannels(frequency)
 	}
+	if os.Getenv("MODEL_SYNC_FREQUENCY") != "" {
+		frequency, err := strconv.Atoi(os.Getenv("MODEL_SYNC_FREQUENCY"))
+		if err != nil {
+			common.FatalLog("failed to parse MODEL_SYNC_FREQUENCY: " + err.Error())
+		}
+		go controller.AutomaticallySyncModels(frequency)
+	}
 	if os.Getenv("CHANNEL_TEST_FREQUENCY") != "" {
 		frequency, err := strconv.Atoi(os.Getenv("CHANNEL_TEST_FREQUENCY"))
 		if err != nil {
@@ -89,6 +142,10 @@ func main() {
 	server.Use(sessions.Sessions("session", store))
 
 	router.SetRouter(server, buildFS, indexPage)
+	if common.IsMasterNode {
+		// the requests of the batches are served by the server like the other requests
+		go controller.AutomaticallyProcessBatches(server, 10)
+	}
 	var port = os.Getenv("PORT")
 	if port == "" {
 		port = strconv.Itoa(*common.Port)
@@ -1,11 +1,14 @@
 package middleware
 
 import (
+	"crypto/subtle"
+	"errors"
 	"github.com/gin-contrib/sessions"
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
+	"os"
 	"strings"
 )
 
@@ -15,6 +18,7 @@ func authHelper(c *gin.Context, minRole int) {
 	role := session.Get("role")
 	id := session.Get("id")
 	status := session.Get("status")
+	totpEnabled, _ := session.Get("totp_enabled").(bool)
 	if username == nil {
 		// Check access token
 		accessToken := c.Request.Header.Get("Authorization")
@@ -33,6 +37,7 @@ func authHelper(c *gin.Context, minRole int) {
 			role = user.Role
 			id = user.Id
 			status = user.Status
+			totpEnabled = user.TOTPEnabled
 		} else {
 			c.JSON(http.StatusOK, gin.H{
 				"success": false,
@@ -58,6 +63,16 @@ func authHelper(c *gin.Context, minRole int) {
 		c.Abort()
 		return
 	}
+	// the admin routes control the channel keys, so the admins may be required to enable the two-factor
+	// authentication, the self routes stay open for the enrollment
+	if minRole >= common.RoleAdminUser && common.TOTPRequiredForAdminEnabled && !totpEnabled {
+		c.JSON(http.StatusOK, gin.H{
+			"success": false,
+			"message": "管理员账户需要先开启两步验证",
+		})
+		c.Abort()
+		return
+	}
 	c.Set("username", username)
 	c.Set("role", role)
 	c.Set("id", id)
@@ -82,6 +97,18 @@ func RootAuth() func(c *gin.Context) {
 	}
 }
 
+// MetricsAuth admits the scrapers with the METRICS_TOKEN, else the root users like RootAuth
+func MetricsAuth() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		metricsToken := os.Getenv("METRICS_TOKEN")
+		if metricsToken != "" && subtle.ConstantTimeCompare([]byte(c.Request.Header.Get("Authorization")), []byte("Bearer "+metricsToken)) == 1 {
+			c.Next()
+			return
+		}
+		authHelper(c, common.RoleRootUser)
+	}
+}
+
 func TokenAuth() func(c *gin.Context) {
 	return func(c *gin.Context) {
 		key := c.Request.Header.Get("Authorization")
@@ -90,6 +117,14 @@ func TokenAuth() func(c *gin.Context) {
 		parts := strings.Split(key, "-")
 		key = parts[0]
 		token, err := model.ValidateUserToken(key)
+		var exhaustedErr *model.TokenExhaustedError
+		if errors.As(err, &exhaustedErr) {
+			group, groupErr := model.CacheGetUserGroup(exhaustedErr.UserId)
+			if groupErr == nil && WriteQuotaExhaustedResponse(c, group, http.StatusUnauthorized, err.Error()) {
+				c.Abort()
+				return
+			}
+		}
 		if err != nil {
 			c.JSON(http.StatusUnauthorized, gin.H{
 				"error": gin.H{
@@ -113,12 +148,41 @@ func TokenAuth() func(c *gin.Context) {
 		c.Set("id", token.UserId)
 		c.Set("token_id", token.Id)
 		c.Set("token_name", token.Name)
+		c.Set("auto_downgrade", token.AutoDowngrade)
+		c.Set("data_residency", token.DataResidency)
+		c.Set("token_channel_group", token.ChannelGroup)
+		c.Set("fine_tuning_enabled", token.FineTuningEnabled)
+		c.Set("token_system_prompt", token.SystemPrompt)
+		c.Set("token_system_prompt_mode", token.SystemPromptMode)
+		c.Set("context_truncation", token.ContextTruncation)
+		c.Set("race_enabled", token.RaceEnabled)
+		c.Set("response_cache_enabled", token.ResponseCacheEnabled)
+		c.Set("semantic_cache_enabled", token.SemanticCacheEnabled)
+		c.Set("organization_id", token.OrganizationId)
+		priority, err := model.CacheGetUserPriority(token.UserId)
+		if err != nil || priority == 0 {
+			priority = common.PriorityNormal
+		}
+		if token.Priority != 0 && token.Priority < priority {
+			priority = token.Priority
+		}
+		c.Set("priority", priority)
 		requestURL := c.Request.URL.String()
 		consumeQuota := true
 		if strings.HasPrefix(requestURL, "/v1/models") {
 			consumeQuota = false
 		}
 		c.Set("consume_quota", consumeQuota)
+		if strings.HasPrefix(requestURL, "/v1/fine_tuning") && !token.FineTuningEnabled {
+			c.JSON(http.StatusForbidden, gin.H{
+				"error": gin.H{
+					"message": "该令牌未开启微调权限",
+					"type":    "one_api_error",
+				},
+			})
+			c.Abort()
+			return
+		}
 		if len(parts) > 1 {
 			if model.IsAdmin(token.UserId) {
 				c.Set("channelId", parts[1])
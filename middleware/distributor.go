@@ -1,7 +1,9 @@
 package middleware
 
 import (
+	"errors"
 	"fmt"
+	"mime/multipart"
 	"net/http"
 	"one-api/common"
 	"one-api/model"
@@ -13,6 +15,7 @@ import (
 
 type ModelRequest struct {
 	Model string `json:"model"`
+	User  string `json:"user"`
 }
 
 func Distribute() func(c *gin.Context) {
@@ -20,6 +23,14 @@ func Distribute() func(c *gin.Context) {
 		userId := c.GetInt("id")
 		userGroup, _ := model.CacheGetUserGroup(userId)
 		c.Set("group", userGroup)
+		if objectId, modelName := getUpstreamObjectId(c); objectId != "" {
+			distributeToUpstreamObject(c, objectId, modelName)
+			return
+		}
+		if objectType := getLatestTokenObjectType(c); objectType != "" {
+			distributeToLatestTokenObject(c, objectType)
+			return
+		}
 		var channel *model.Channel
 		channelId, ok := c.Get("channelId")
 		if ok {
@@ -55,10 +66,42 @@ func Distribute() func(c *gin.Context) {
 				c.Abort()
 				return
 			}
+			if !IsChannelCompliant(c, channel) {
+				c.JSON(http.StatusForbidden, gin.H{
+					"error": gin.H{
+						"message": "该渠道不满足数据驻留要求",
+						"type":    "one_api_error",
+					},
+				})
+				c.Abort()
+				return
+			}
+			if !model.AcquireChannelRateLimit(channel, "") {
+				c.JSON(http.StatusTooManyRequests, gin.H{
+					"error": gin.H{
+						"message": "该渠道已达到速率限制，请稍后重试",
+						"type":    "one_api_error",
+					},
+				})
+				c.Abort()
+				return
+			}
 		} else {
 			// Select a channel for the user
 			var modelRequest ModelRequest
-			err := common.UnmarshalBodyReusable(c, &modelRequest)
+			var err error
+			if strings.HasPrefix(c.Request.URL.Path, "/v1/realtime") {
+				// the handshake of the WebSocket has no body
+				modelRequest.Model = c.Query("model")
+			} else if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "multipart/form-data") {
+				var form *multipart.Form
+				form, err = common.ParseMultipartFormReusable(c)
+				if err == nil && len(form.Value["model"]) > 0 {
+					modelRequest.Model = form.Value["model"][0]
+				}
+			} else {
+				err = common.UnmarshalBodyReusable(c, &modelRequest)
+			}
 			if err != nil {
 				c.JSON(http.StatusBadRequest, gin.H{
 					"error": gin.H{
@@ -84,10 +127,54 @@ func Distribute() func(c *gin.Context) {
 					modelRequest.Model = "dall-e"
 				}
 			}
-			channel, err = model.CacheGetRandomSatisfiedChannel(userGroup, modelRequest.Model)
+			if strings.HasPrefix(c.Request.URL.Path, "/v1/images/edits") || strings.HasPrefix(c.Request.URL.Path, "/v1/images/variations") {
+				if modelRequest.Model == "" {
+					modelRequest.Model = "dall-e-2"
+				}
+			}
+			if strings.HasPrefix(c.Request.URL.Path, "/v1/audio/transcriptions") || strings.HasPrefix(c.Request.URL.Path, "/v1/audio/translations") {
+				if modelRequest.Model == "" {
+					modelRequest.Model = "whisper-1"
+				}
+			}
+			modelRequest.Model = applyModelAlias(c, modelRequest.Model)
+			c.Set("request_model", modelRequest.Model)
+			c.Set("sticky_key", getStickyKey(c, modelRequest.User))
+			channel = selectExperimentChannel(c, userGroup, modelRequest.Model)
+			if channel == nil {
+				channel, err = SelectChannel(c, userGroup, modelRequest.Model)
+			}
 			if err != nil {
+				if fallbackChannel, fallbackModel, fallbackErr := SelectFallbackChannel(c); fallbackErr == nil && SetFallbackModel(c, fallbackModel) == nil {
+					channel, err = fallbackChannel, nil
+				}
+			}
+			if err != nil {
+				if errors.Is(err, model.ErrChannelsThrottled) {
+					c.JSON(http.StatusTooManyRequests, gin.H{
+						"error": gin.H{
+							"message": fmt.Sprintf("当前分组 %s 下对于模型 %s 的渠道均已达到速率限制，请稍后重试", userGroup, modelRequest.Model),
+							"type":    "one_api_error",
+						},
+					})
+					c.Abort()
+					return
+				}
+				if errors.Is(err, model.ErrChannelQueueFull) {
+					c.JSON(http.StatusTooManyRequests, gin.H{
+						"error": gin.H{
+							"message": "等待可用渠道的请求过多，请稍后重试",
+							"type":    "one_api_error",
+						},
+					})
+					c.Abort()
+					return
+				}
 				message := fmt.Sprintf("当前分组 %s 下对于模型 %s 无可用渠道", userGroup, modelRequest.Model)
-				if channel != nil {
+				if HasDataResidency(c) {
+					// never fall back to a channel out of the regions
+					message = fmt.Sprintf("当前分组 %s 下对于模型 %s 无满足数据驻留要求的可用渠道", userGroup, modelRequest.Model)
+				} else if channel != nil {
 					common.SysError(fmt.Sprintf("渠道不存在：%d", channel.Id))
 					message = "数据库一致性已被破坏，请联系管理员"
 				}
@@ -101,18 +188,132 @@ func Distribute() func(c *gin.Context) {
 				return
 			}
 		}
-		c.Set("channel", channel.Type)
-		c.Set("channel_id", channel.Id)
-		c.Set("channel_name", channel.Name)
-		c.Set("model_mapping", channel.ModelMapping)
-		c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", channel.Key))
-		c.Set("base_url", channel.BaseURL)
-		if channel.Type == common.ChannelTypeAzure {
-			c.Set("api_version", channel.Other)
-		}
-		if channel.Type == common.ChannelTypeMiniMax {
-			c.Set("group_id", channel.Other)
-		}
+		SetupContextForSelectedChannel(c, channel)
 		c.Next()
 	}
 }
+
+// getDataResidency returns the region constraints of the token and of the group, both must be satisfied
+func getDataResidency(c *gin.Context) []string {
+	return []string{c.GetString("data_residency"), common.GetGroupDataResidency(c.GetString("group"))}
+}
+
+func HasDataResidency(c *gin.Context) bool {
+	for _, constraint := range getDataResidency(c) {
+		if constraint != "" {
+			return true
+		}
+	}
+	return false
+}
+
+func IsChannelCompliant(c *gin.Context, channel *model.Channel) bool {
+	return common.IsRegionAllowed(channel.Region, getDataResidency(c)...)
+}
+
+// getStickyKey identifies the conversation of the request with the X-Conversation-Id header or else the user field of
+// the request, scoped to the user of one-api, empty if sticky routing is disabled or the client supplied none
+func getStickyKey(c *gin.Context, user string) string {
+	if !common.StickyRoutingEnabled {
+		return ""
+	}
+	conversationId := c.Request.Header.Get("X-Conversation-Id")
+	if conversationId == "" {
+		conversationId = user
+	}
+	if conversationId == "" {
+		return ""
+	}
+	return fmt.Sprintf("%d:%s", c.GetInt("id"), conversationId)
+}
+
+// getChannelGroup is the channel group of the moderations, else the one of the token, else the one of the model, else
+// the one of the user group, empty if none
+func getChannelGroup(c *gin.Context, modelName string) string {
+	if common.ModerationChannelGroup != "" && strings.HasPrefix(c.Request.URL.Path, "/v1/moderations") {
+		return common.ModerationChannelGroup
+	}
+	if channelGroup := c.GetString("token_channel_group"); channelGroup != "" {
+		return channelGroup
+	}
+	if channelGroup := common.ModelChannelGroups[modelName]; channelGroup != "" {
+		return channelGroup
+	}
+	return common.GroupChannelGroups[c.GetString("group")]
+}
+
+// SelectChannel picks a channel of the group for the model, within the regions of the data residency of the request if any
+func SelectChannel(c *gin.Context, group string, modelName string) (*model.Channel, error) {
+	return model.CacheGetFailoverChannel(getChannelSelection(c, group, modelName, nil))
+}
+
+// selectExperimentChannel picks the channel of the arm of the running experiment of the model the request falls in,
+// like any other channel of the group for the model, nil if there is no experiment or the channel is not available
+func selectExperimentChannel(c *gin.Context, group string, modelName string) *model.Channel {
+	experiment := model.GetRunningExperiment(modelName)
+	if experiment == nil {
+		return nil
+	}
+	arm, channelId := experiment.PickArm()
+	selection := getChannelSelection(c, group, modelName, nil)
+	selection.ChannelId = channelId
+	channel, err := model.CacheGetFailoverChannel(selection)
+	if err != nil {
+		return nil
+	}
+	c.Set("experiment_id", experiment.Id)
+	c.Set("experiment_arm", arm)
+	c.Set("experiment_judge_model", experiment.JudgeModel)
+	return channel
+}
+
+// SelectFailoverChannel picks another channel for the model of the request after the failed ones, the lower priorities
+// are only reached when all the channels of the higher ones failed
+func SelectFailoverChannel(c *gin.Context, failedChannelIds []int) (*model.Channel, error) {
+	return model.CacheGetFailoverChannel(getChannelSelection(c, c.GetString("group"), c.GetString("request_model"), failedChannelIds))
+}
+
+func getChannelSelection(c *gin.Context, group string, modelName string, failedChannelIds []int) *model.ChannelSelection {
+	return &model.ChannelSelection{
+		Group:            group,
+		Model:            modelName,
+		FailedChannelIds: failedChannelIds,
+		StickyKey:        c.GetString("sticky_key"),
+		ChannelGroup:     getChannelGroup(c, modelName),
+		Priority:         c.GetInt("priority"),
+		Constraints:      getDataResidency(c),
+		Context:          c.Request.Context(),
+	}
+}
+
+// SetupContextForSelectedChannel is also used by the relay when it switches to another channel
+func SetupContextForSelectedChannel(c *gin.Context, channel *model.Channel) {
+	c.Set("channel", channel.Type)
+	c.Set("channel_id", channel.Id)
+	c.Set("channel_name", channel.Name)
+	c.Set("model_mapping", channel.ModelMapping)
+	key, keyHash := model.PickChannelKey(channel)
+	SetupContextForChannelKey(c, key, keyHash)
+	c.Set("base_url", channel.BaseURL)
+	c.Set("proxy", channel.Proxy)
+	c.Set("shadow_channel_id", channel.GetShadowChannelId())
+	c.Set("shadow_rate", channel.GetShadowRate())
+	c.Set("channel_headers", channel.Headers)
+	c.Set("strip_headers", channel.StripHeaders)
+	c.Set("timeout_policy", channel.TimeoutPolicy)
+	c.Set("channel_system_prompt", channel.SystemPrompt)
+	c.Set("channel_system_prompt_mode", channel.SystemPromptMode)
+	if channel.Type == common.ChannelTypeAzure {
+		c.Set("api_version", channel.Other)
+		c.Set("azure_deployments", channel.AzureDeployments)
+	}
+	if channel.Type == common.ChannelTypeMiniMax {
+		c.Set("group_id", channel.Other)
+	}
+}
+
+// SetupContextForChannelKey is also used by the relay when it switches to another key of the same channel
+func SetupContextForChannelKey(c *gin.Context, key string, keyHash string) {
+	c.Set("channel_key_hash", keyHash)
+	c.Request.Header.Set("Authorization", fmt.Sprintf("Bearer %s", key))
+}
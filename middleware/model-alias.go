@@ -0,0 +1,53 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// setRequestBodyModel replaces the model of the JSON request body, the other fields are kept as they are
+func setRequestBodyModel(c *gin.Context, modelName string) error {
+	requestBody, err := io.ReadAll(c.Request.Body)
+	if err != nil {
+		return err
+	}
+	var request map[string]any
+	err = json.Unmarshal(requestBody, &request)
+	if err != nil {
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		return err
+	}
+	request["model"] = modelName
+	jsonData, err := json.Marshal(request)
+	if err != nil {
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		return err
+	}
+	c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+	c.Request.ContentLength = int64(len(jsonData))
+	return nil
+}
+
+// applyModelAlias replaces the model of the request by the one the alias stands for, it returns the model to route
+// the request for, the alias is told in the X-Model-Alias-From header and the deprecated ones also in a Deprecation
+// header, only the JSON requests are changed
+func applyModelAlias(c *gin.Context, modelName string) string {
+	alias := model.GetModelAlias(modelName)
+	if alias == nil || !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return modelName
+	}
+	if setRequestBodyModel(c, alias.Model) != nil {
+		return modelName
+	}
+	c.Set("model_alias", modelName)
+	c.Writer.Header().Set("X-Model-Alias-From", modelName)
+	if alias.Deprecated {
+		c.Writer.Header().Set("Deprecation", "true")
+	}
+	return alias.Model
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"errors"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SelectFallbackChannel picks a channel for the next model of the fallback chain of the model the client asked for,
+// after the one the request has fallen back to already if any, only the JSON requests fall back
+func SelectFallbackChannel(c *gin.Context) (*model.Channel, string, error) {
+	if !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+		return nil, "", errors.New("the request cannot fall back to another model")
+	}
+	currentModel := c.GetString("request_model")
+	primaryModel := c.GetString("fallback_from")
+	if primaryModel == "" {
+		primaryModel = currentModel
+	}
+	fallbacks := common.ModelFallbacks[primaryModel]
+	if currentModel != primaryModel {
+		for i, fallback := range fallbacks {
+			if fallback == currentModel {
+				fallbacks = fallbacks[i+1:]
+				break
+			}
+		}
+	}
+	for _, fallback := range fallbacks {
+		channel, err := SelectChannel(c, c.GetString("group"), fallback)
+		if err == nil {
+			return channel, fallback, nil
+		}
+	}
+	return nil, "", errors.New("no fallback model has an available channel")
+}
+
+// SetFallbackModel makes the request use the fallback model, the model the client asked for is told in the
+// X-Model-Fallback-From header
+func SetFallbackModel(c *gin.Context, modelName string) error {
+	err := setRequestBodyModel(c, modelName)
+	if err != nil {
+		return err
+	}
+	if c.GetString("fallback_from") == "" {
+		c.Set("fallback_from", c.GetString("request_model"))
+		c.Writer.Header().Set("X-Model-Fallback-From", c.GetString("request_model"))
+	}
+	c.Set("request_model", modelName)
+	return nil
+}
@@ -0,0 +1,139 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/model"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// CollectRequestContent joins the texts of the request so that the policies and the moderation can inspect what the user sends
+func CollectRequestContent(body map[string]interface{}) string {
+	var texts []string
+	var collect func(value interface{})
+	collect = func(value interface{}) {
+		switch v := value.(type) {
+		case string:
+			texts = append(texts, v)
+		case []interface{}:
+			for _, item := range v {
+				collect(item)
+			}
+		case map[string]interface{}:
+			if text, ok := v["text"]; ok {
+				collect(text)
+			}
+			if content, ok := v["content"]; ok {
+				collect(content)
+			}
+		}
+	}
+	for _, key := range []string{"messages", "prompt", "input", "instruction"} {
+		collect(body[key])
+	}
+	return strings.Join(texts, "\n")
+}
+
+func abortWithPolicyError(c *gin.Context, message string) {
+	if message == "" {
+		message = "请求被管理员设置的策略拒绝"
+	}
+	c.JSON(http.StatusForbidden, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "one_api_error",
+			"code":    "policy_violation",
+		},
+	})
+	c.Abort()
+}
+
+// ApplyPolicies runs the policies of the user group and the selected channel, so it must come after Distribute
+func ApplyPolicies() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group := c.GetString("group")
+		channelId := c.GetInt("channel_id")
+		rules := model.GetPolicyRules(group, channelId)
+		if len(rules) == 0 {
+			c.Next()
+			return
+		}
+		var body map[string]interface{}
+		var rawBody []byte
+		if strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			var err error
+			rawBody, err = io.ReadAll(c.Request.Body)
+			_ = c.Request.Body.Close()
+			if err == nil {
+				c.Request.Body = io.NopCloser(bytes.NewBuffer(rawBody))
+				_ = json.Unmarshal(rawBody, &body)
+			}
+		}
+		if body == nil {
+			body = map[string]interface{}{}
+		}
+		headers := make(map[string]string, len(c.Request.Header))
+		for key := range c.Request.Header {
+			if key == "Authorization" {
+				// it has been replaced by the channel key already
+				continue
+			}
+			headers[strings.ToLower(key)] = c.Request.Header.Get(key)
+		}
+		modelName, _ := body["model"].(string)
+		request := &model.PolicyRequest{
+			Fields: map[string]string{
+				"model":      modelName,
+				"group":      group,
+				"channel_id": strconv.Itoa(channelId),
+				"user_id":    strconv.Itoa(c.GetInt("id")),
+				"token_name": c.GetString("token_name"),
+				"path":       c.Request.URL.Path,
+			},
+			Headers: headers,
+			Body:    body,
+			Content: CollectRequestContent(body),
+		}
+		policyHeaders := make(map[string]string)
+		bodyChanged := false
+		for _, rule := range rules {
+			if !rule.Matches(request) {
+				continue
+			}
+			switch rule.Action {
+			case model.PolicyActionDeny:
+				abortWithPolicyError(c, rule.Message)
+				return
+			case model.PolicyActionSetHeader:
+				value := fmt.Sprintf("%v", rule.Value)
+				policyHeaders[rule.Key] = value
+				request.Headers[strings.ToLower(rule.Key)] = value
+			case model.PolicyActionSetBody:
+				body[rule.Key] = rule.Value
+				bodyChanged = true
+			case model.PolicyActionDeleteBody:
+				delete(body, rule.Key)
+				bodyChanged = true
+			}
+		}
+		if bodyChanged && rawBody != nil {
+			jsonData, err := json.Marshal(body)
+			if err != nil {
+				abortWithPolicyError(c, "策略修改后的请求体无法序列化")
+				return
+			}
+			c.Request.Body = io.NopCloser(bytes.NewBuffer(jsonData))
+			c.Request.ContentLength = int64(len(jsonData))
+		}
+		if len(policyHeaders) > 0 {
+			c.Set("policy_headers", policyHeaders)
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,57 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"one-api/common"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+func escapeJSONString(s string) string {
+	jsonBytes, _ := json.Marshal(s)
+	return string(jsonBytes[1 : len(jsonBytes)-1])
+}
+
+// WriteQuotaExhaustedResponse writes the response configured for the group when a token or a user runs out of quota,
+// it returns false if there is none so that the caller writes its own error
+func WriteQuotaExhaustedResponse(c *gin.Context, group string, statusCode int, message string) bool {
+	response := common.GetGroupQuotaExhaustedResponse(group)
+	if response == nil {
+		return false
+	}
+	if customMessage := response.GetMessage(c.Request.Header.Get("Accept-Language")); customMessage != "" {
+		message = customMessage
+	}
+	if response.StatusCode != 0 {
+		statusCode = response.StatusCode
+	}
+	if response.UpgradeURL != "" {
+		c.Header("X-Upgrade-URL", response.UpgradeURL)
+		if statusCode >= http.StatusMultipleChoices && statusCode < http.StatusBadRequest {
+			c.Header("Location", response.UpgradeURL)
+		}
+	}
+	if len(response.Body) == 0 {
+		openaiError := gin.H{
+			"message": message,
+			"type":    "one_api_error",
+			"code":    "insufficient_quota",
+		}
+		if response.UpgradeURL != "" {
+			openaiError["upgrade_url"] = response.UpgradeURL
+		}
+		c.JSON(statusCode, gin.H{
+			"error": openaiError,
+		})
+		return true
+	}
+	body := strings.NewReplacer(
+		"{message}", escapeJSONString(message),
+		"{upgrade_url}", escapeJSONString(response.UpgradeURL),
+		"{group}", escapeJSONString(group),
+	).Replace(string(response.Body))
+	c.Data(statusCode, "application/json", []byte(body))
+	return true
+}
@@ -6,6 +6,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"net/http"
 	"one-api/common"
+	"one-api/model"
 	"time"
 )
 
@@ -14,9 +15,13 @@ var timeFormat = "2006-01-02T15:04:05.000Z"
 var inMemoryRateLimiter common.InMemoryRateLimiter
 
 func redisRateLimiter(c *gin.Context, maxRequestNum int, duration int64, mark string) {
+	redisRateLimiterByKey(c, maxRequestNum, duration, mark+c.ClientIP())
+}
+
+func redisRateLimiterByKey(c *gin.Context, maxRequestNum int, duration int64, mark string) {
 	ctx := context.Background()
 	rdb := common.RDB
-	key := "rateLimit:" + mark + c.ClientIP()
+	key := "rateLimit:" + mark
 	listLength, err := rdb.LLen(ctx, key).Result()
 	if err != nil {
 		fmt.Println(err.Error())
@@ -101,3 +106,32 @@ func DownloadRateLimit() func(c *gin.Context) {
 func UploadRateLimit() func(c *gin.Context) {
 	return rateLimitFactory(common.UploadRateLimitNum, common.UploadRateLimitDuration, "UP")
 }
+
+// RelayRateLimit limits the relay requests per minute of each token, the limit can be overridden for a group, a user or a token
+func RelayRateLimit() func(c *gin.Context) {
+	inMemoryRateLimiter.Init(common.RateLimitKeyExpirationDuration)
+	return func(c *gin.Context) {
+		tokenId := c.GetInt("token_id")
+		maxRequestNum := model.ResolveIntOption("RelayRateLimitNum", c.GetString("group"), c.GetInt("id"), tokenId, common.RelayRateLimitNum)
+		if maxRequestNum <= 0 {
+			c.Next()
+			return
+		}
+		mark := fmt.Sprintf("RL%d", tokenId)
+		if common.RedisEnabled {
+			redisRateLimiterByKey(c, maxRequestNum, 60, mark)
+		} else if !inMemoryRateLimiter.Request(mark, maxRequestNum, 60) {
+			c.Status(http.StatusTooManyRequests)
+			c.Abort()
+		}
+		if c.IsAborted() && c.Writer.Status() == http.StatusTooManyRequests {
+			c.JSON(http.StatusTooManyRequests, gin.H{
+				"error": gin.H{
+					"message": fmt.Sprintf("令牌请求过于频繁，每分钟最多 %d 次", maxRequestNum),
+					"type":    "one_api_error",
+					"code":    "rate_limit_exceeded",
+				},
+			})
+		}
+	}
+}
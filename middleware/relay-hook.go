@@ -0,0 +1,323 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"plugin"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RelayHook inspects or modifies the relayed requests and responses, the bodies are the decoded JSON in the format
+// of the OpenAI API, the config is the one of the hook in the RelayHooks option
+type RelayHook interface {
+	// TransformRequest runs before the channel is selected, an error rejects the request with its message
+	TransformRequest(c *gin.Context, config map[string]any, body map[string]any) error
+	// TransformResponse runs on the successful JSON responses and on every chunk of the streams, an error is logged
+	// and the response is sent as the hooks left it
+	TransformResponse(c *gin.Context, config map[string]any, body map[string]any) error
+}
+
+var relayHooks = map[string]RelayHook{
+	"redact_pii": &redactPIIHook{},
+}
+var relayHooksLock sync.RWMutex
+
+// RegisterRelayHook makes a hook available to the RelayHooks option under the name
+func RegisterRelayHook(name string, hook RelayHook) {
+	relayHooksLock.Lock()
+	defer relayHooksLock.Unlock()
+	relayHooks[name] = hook
+}
+
+// LoadRelayHookPlugins opens the Go plugins at the paths, each of them must export
+// func NewRelayHook() (string, middleware.RelayHook), the hook is registered with the returned name
+func LoadRelayHookPlugins(paths []string) error {
+	for _, path := range paths {
+		path = strings.TrimSpace(path)
+		if path == "" {
+			continue
+		}
+		p, err := plugin.Open(path)
+		if err != nil {
+			return err
+		}
+		symbol, err := p.Lookup("NewRelayHook")
+		if err != nil {
+			return err
+		}
+		newRelayHook, ok := symbol.(func() (string, RelayHook))
+		if !ok {
+			return fmt.Errorf("NewRelayHook of plugin %s has the wrong type", path)
+		}
+		name, hook := newRelayHook()
+		RegisterRelayHook(name, hook)
+		common.SysLog(fmt.Sprintf("relay hook %s loaded from %s", name, path))
+	}
+	return nil
+}
+
+type relayHookEntry struct {
+	name   string
+	hook   RelayHook
+	config map[string]any
+}
+
+func getRelayHooks(group string) []relayHookEntry {
+	relayHooksLock.RLock()
+	defer relayHooksLock.RUnlock()
+	var entries []relayHookEntry
+	for _, setting := range common.GetRelayHookSettings(group) {
+		hook, ok := relayHooks[setting.Name]
+		if !ok {
+			common.SysError("relay hook not found: " + setting.Name)
+			continue
+		}
+		entries = append(entries, relayHookEntry{name: setting.Name, hook: hook, config: setting.Config})
+	}
+	return entries
+}
+
+func abortWithRelayHookError(c *gin.Context, message string) {
+	c.JSON(http.StatusBadRequest, gin.H{
+		"error": gin.H{
+			"message": message,
+			"type":    "one_api_error",
+			"code":    "relay_hook_rejected",
+		},
+	})
+	c.Abort()
+}
+
+// relayHookWriter passes the successful JSON responses and the events of the streams through the response hooks,
+// the JSON responses are held until the handler returns, the other responses are written as they are
+type relayHookWriter struct {
+	gin.ResponseWriter
+	c     *gin.Context
+	hooks []relayHookEntry
+	body  bytes.Buffer
+	held  bool
+}
+
+func (w *relayHookWriter) transform(body map[string]any) {
+	for _, entry := range w.hooks {
+		err := entry.hook.TransformResponse(w.c, entry.config, body)
+		if err != nil {
+			common.SysError(fmt.Sprintf("relay hook %s failed on the response: %s", entry.name, err.Error()))
+		}
+	}
+}
+
+func (w *relayHookWriter) WriteString(s string) (int, error) {
+	return w.Write([]byte(s))
+}
+
+func (w *relayHookWriter) Write(data []byte) (int, error) {
+	contentType := w.Header().Get("Content-Type")
+	if strings.HasPrefix(contentType, "text/event-stream") {
+		w.body.Write(data)
+		for {
+			buffered := w.body.Bytes()
+			end := bytes.Index(buffered, []byte("\n\n"))
+			if end < 0 {
+				return len(data), nil
+			}
+			event := string(buffered[:end+2])
+			w.body.Next(end + 2)
+			_, _ = io.WriteString(w.ResponseWriter, w.transformEvent(event))
+		}
+	}
+	if strings.HasPrefix(contentType, "application/json") && w.Status() < http.StatusMultipleChoices {
+		w.held = true
+		return w.body.Write(data)
+	}
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *relayHookWriter) transformEvent(event string) string {
+	line := strings.TrimSpace(event)
+	if !strings.HasPrefix(line, "data: ") || line == "data: [DONE]" {
+		return event
+	}
+	var body map[string]any
+	if json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &body) != nil {
+		return event
+	}
+	w.transform(body)
+	jsonData, err := json.Marshal(body)
+	if err != nil {
+		return event
+	}
+	return "data: " + string(jsonData) + "\n\n"
+}
+
+func (w *relayHookWriter) Written() bool {
+	return w.held || w.ResponseWriter.Written()
+}
+
+// finish writes the JSON response held back
+func (w *relayHookWriter) finish() {
+	if !w.held {
+		return
+	}
+	data := w.body.Bytes()
+	var body map[string]any
+	if json.Unmarshal(data, &body) == nil {
+		w.transform(body)
+		jsonData, err := json.Marshal(body)
+		if err == nil {
+			data = jsonData
+		}
+	}
+	w.Header().Del("Content-Length")
+	_, _ = w.ResponseWriter.Write(data)
+}
+
+// RelayHooks runs the hooks of the RelayHooks option, it comes before Distribute so that the hooks may change the
+// model, and after the compatible middlewares so that the hooks see the format of the OpenAI API
+func RelayHooks() func(c *gin.Context) {
+	return func(c *gin.Context) {
+		group, _ := model.CacheGetUserGroup(c.GetInt("id"))
+		hooks := getRelayHooks(group)
+		if len(hooks) == 0 || !strings.HasPrefix(c.Request.Header.Get("Content-Type"), "application/json") {
+			c.Next()
+			return
+		}
+		requestBody, err := io.ReadAll(c.Request.Body)
+		_ = c.Request.Body.Close()
+		if err != nil {
+			abortWithRelayHookError(c, "读取请求体失败")
+			return
+		}
+		var body map[string]any
+		if json.Unmarshal(requestBody, &body) == nil {
+			for _, entry := range hooks {
+				err = entry.hook.TransformRequest(c, entry.config, body)
+				if err != nil {
+					abortWithRelayHookError(c, err.Error())
+					return
+				}
+			}
+			jsonData, err := json.Marshal(body)
+			if err == nil {
+				requestBody = jsonData
+			}
+		}
+		c.Request.Body = io.NopCloser(bytes.NewBuffer(requestBody))
+		c.Request.ContentLength = int64(len(requestBody))
+		writer := &relayHookWriter{ResponseWriter: c.Writer, c: c, hooks: hooks}
+		c.Writer = writer
+		c.Next()
+		writer.finish()
+		c.Writer = writer.ResponseWriter
+	}
+}
+
+// transformTexts replaces the texts of the messages and the prompts of a request, or of the choices of a response
+func transformTexts(body map[string]any, replace func(string) string) {
+	var walk func(value any) any
+	walk = func(value any) any {
+		switch v := value.(type) {
+		case string:
+			return replace(v)
+		case []any:
+			for i, item := range v {
+				v[i] = walk(item)
+			}
+		case map[string]any:
+			for _, key := range []string{"content", "text", "message", "delta"} {
+				if item, ok := v[key]; ok {
+					v[key] = walk(item)
+				}
+			}
+		}
+		return value
+	}
+	for _, key := range []string{"messages", "prompt", "input", "instruction", "choices"} {
+		if value, ok := body[key]; ok {
+			body[key] = walk(value)
+		}
+	}
+}
+
+var defaultPIIPatterns = map[string]string{
+	"email":       `[A-Za-z0-9._%+\-]+@[A-Za-z0-9.\-]+\.[A-Za-z]{2,}`,
+	"phone":       `(?:\+?86[ \-]?)?1[3-9]\d{9}`,
+	"id_card":     `\b\d{17}[\dXx]\b`,
+	"credit_card": `\b(?:\d[ \-]?){13,19}\b`,
+}
+
+// redactPIIHook replaces the personal information in the requests, and in the responses when config.responses is
+// true, config.patterns maps names to the regular expressions used in place of the default ones, and
+// config.replacement is what the matches are replaced by
+type redactPIIHook struct {
+	lock     sync.Mutex
+	compiled map[string]*regexp.Regexp
+}
+
+func (h *redactPIIHook) getPatterns(config map[string]any) ([]*regexp.Regexp, error) {
+	patterns := defaultPIIPatterns
+	if custom, ok := config["patterns"].(map[string]any); ok {
+		patterns = make(map[string]string, len(custom))
+		for name, pattern := range custom {
+			patterns[name] = fmt.Sprint(pattern)
+		}
+	}
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	if h.compiled == nil {
+		h.compiled = make(map[string]*regexp.Regexp)
+	}
+	var regexps []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, ok := h.compiled[pattern]
+		if !ok {
+			var err error
+			re, err = regexp.Compile(pattern)
+			if err != nil {
+				return nil, errors.New("PII 规则的正则表达式无效：" + pattern)
+			}
+			h.compiled[pattern] = re
+		}
+		regexps = append(regexps, re)
+	}
+	return regexps, nil
+}
+
+func (h *redactPIIHook) redact(config map[string]any, body map[string]any) error {
+	regexps, err := h.getPatterns(config)
+	if err != nil {
+		return err
+	}
+	replacement := "[REDACTED]"
+	if value, ok := config["replacement"].(string); ok {
+		replacement = value
+	}
+	transformTexts(body, func(text string) string {
+		for _, re := range regexps {
+			text = re.ReplaceAllString(text, replacement)
+		}
+		return text
+	})
+	return nil
+}
+
+func (h *redactPIIHook) TransformRequest(c *gin.Context, config map[string]any, body map[string]any) error {
+	return h.redact(config, body)
+}
+
+func (h *redactPIIHook) TransformResponse(c *gin.Context, config map[string]any, body map[string]any) error {
+	if responses, _ := config["responses"].(bool); !responses {
+		return nil
+	}
+	return h.redact(config, body)
+}
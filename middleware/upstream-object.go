@@ -0,0 +1,121 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"one-api/model"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// getUpstreamObjectId is the object stored by an upstream which the request is about, such as the response it
+// continues or the thread it runs, and the model of the request if any, the id is empty for the requests about no object
+func getUpstreamObjectId(c *gin.Context) (string, string) {
+	for _, param := range []string{"response_id", "thread_id", "assistant_id", "fine_tuning_job_id"} {
+		if id := c.Param(param); id != "" {
+			return id, ""
+		}
+	}
+	if c.Request.Method != http.MethodPost {
+		return "", ""
+	}
+	if strings.HasPrefix(c.Request.URL.Path, "/v1/responses") {
+		var request struct {
+			Model              string `json:"model"`
+			PreviousResponseId string `json:"previous_response_id"`
+		}
+		// the malformed bodies are refused by the relay
+		_ = common.UnmarshalBodyReusable(c, &request)
+		return request.PreviousResponseId, request.Model
+	}
+	if c.Request.URL.Path == "/v1/threads/runs" {
+		var request struct {
+			Model       string `json:"model"`
+			AssistantId string `json:"assistant_id"`
+		}
+		_ = common.UnmarshalBodyReusable(c, &request)
+		return request.AssistantId, request.Model
+	}
+	return "", ""
+}
+
+// getLatestTokenObjectType is the type of the latest object of the token whose channel and key the request is sent to,
+// the threads are created there to be run by the assistants, and the assistants and the fine-tuning jobs are listed
+// there, the type is empty for the other requests
+func getLatestTokenObjectType(c *gin.Context) string {
+	switch {
+	case c.Request.Method == http.MethodPost && c.Request.URL.Path == "/v1/threads",
+		c.Request.Method == http.MethodGet && c.Request.URL.Path == "/v1/assistants":
+		return model.UpstreamObjectTypeAssistant
+	case c.Request.Method == http.MethodGet && c.Request.URL.Path == "/v1/fine_tuning/jobs":
+		return model.UpstreamObjectTypeFineTuningJob
+	}
+	return ""
+}
+
+func distributeToLatestTokenObject(c *gin.Context, objectType string) {
+	object, err := model.GetLatestTokenUpstreamObject(c.GetInt("token_id"), objectType)
+	if err != nil {
+		if c.Request.Method == http.MethodGet {
+			// the token has nothing to list
+			c.JSON(http.StatusOK, gin.H{
+				"object":   "list",
+				"data":     []any{},
+				"first_id": nil,
+				"last_id":  nil,
+				"has_more": false,
+			})
+			c.Abort()
+			return
+		}
+		c.JSON(http.StatusBadRequest, gin.H{
+			"error": gin.H{
+				"message": "请先使用该令牌创建助手",
+				"type":    "one_api_error",
+			},
+		})
+		c.Abort()
+		return
+	}
+	distributeToUpstreamObject(c, object.Id, "")
+}
+
+// distributeToUpstreamObject sends the request to the channel and the key which created the object, no other channel
+// knows it
+func distributeToUpstreamObject(c *gin.Context, objectId string, modelName string) {
+	object, err := model.GetUserUpstreamObject(objectId, c.GetInt("id"))
+	// the objects of the Assistants API and the fine-tuning jobs belong to the token which created them
+	if err != nil || (object.TokenId != 0 && object.TokenId != c.GetInt("token_id")) {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("对象 %s 不存在", objectId),
+				"type":    "one_api_error",
+			},
+		})
+		c.Abort()
+		return
+	}
+	channel, err := model.GetChannelById(object.ChannelId, true)
+	if err != nil || channel.Status != common.ChannelStatusEnabled {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"error": gin.H{
+				"message": fmt.Sprintf("创建对象 %s 的渠道已不可用", objectId),
+				"type":    "one_api_error",
+			},
+		})
+		c.Abort()
+		return
+	}
+	if modelName == "" {
+		modelName = object.Model
+	}
+	c.Set("request_model", modelName)
+	c.Set("upstream_object_id", objectId)
+	SetupContextForSelectedChannel(c, channel)
+	if key, ok := model.GetChannelKeyByHash(channel, object.KeyHash); ok && object.KeyHash != "" {
+		SetupContextForChannelKey(c, key, object.KeyHash)
+	}
+	c.Next()
+}
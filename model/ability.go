@@ -1,6 +1,10 @@
 package model
 
 import (
+	"context"
+	"errors"
+	"gorm.io/gorm"
+	"math/rand"
 	"one-api/common"
 	"strings"
 )
@@ -13,20 +17,186 @@ type Ability struct {
 }
 
 func GetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
-	ability := Ability{}
-	var err error = nil
-	if common.UsingSQLite {
-		err = DB.Where("`group` = ? and model = ? and enabled = 1", group, model).Order("RANDOM()").Limit(1).First(&ability).Error
-	} else {
-		err = DB.Where("`group` = ? and model = ? and enabled = 1", group, model).Order("RAND()").Limit(1).First(&ability).Error
-	}
+	return GetRandomSatisfiedChannelInRegion(group, model)
+}
+
+func GetRandomSatisfiedChannelInRegion(group string, model string, constraints ...string) (*Channel, error) {
+	return GetFailoverChannel(&ChannelSelection{Group: group, Model: model, Constraints: constraints})
+}
+
+// ChannelSelection is what a channel is picked for, among the channels of the group for the model
+type ChannelSelection struct {
+	Group            string
+	Model            string
+	FailedChannelIds []int           // the channels which failed the request already
+	StickyKey        string          // the requests of the same sticky key go to the same channel when possible
+	ChannelGroup     string          // only the channels of the channel group are picked if any
+	Priority         int             // orders the request among the ones waiting for the congested channels
+	Constraints      []string        // the regions the channel must satisfy, see common.IsRegionAllowed
+	Context          context.Context // of the request, which stops waiting for a channel once it is done, nil if none
+	ChannelId        int             // only this channel is picked if any, the request does not wait for it
+}
+
+// GetFailoverChannel picks a channel for the selection, the lower priorities of the channels are only used when all
+// the channels of the higher ones failed
+func GetFailoverChannel(selection *ChannelSelection) (*Channel, error) {
+	var channelIds []int
+	err := DB.Model(&Ability{}).Where("`group` = ? and model = ? and enabled = 1", selection.Group, selection.Model).Pluck("channel_id", &channelIds).Error
 	if err != nil {
 		return nil, err
 	}
-	channel := Channel{}
-	channel.Id = ability.ChannelId
-	err = DB.First(&channel, "id = ?", ability.ChannelId).Error
-	return &channel, err
+	var channels []*Channel
+	if len(channelIds) > 0 {
+		err = DB.Where("id in ?", channelIds).Find(&channels).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return pickChannel(channels, selection)
+}
+
+// selectChannel picks among the channels of the highest priority which haven't failed, belong to the channel group if
+// any, satisfy the region constraints, are within their rate limits and whose circuit breaker is not open, with a
+// probability proportional to their weight or the fastest one with the latency routing of the model or of the channel
+// group, or the one the sticky key hashes to, throttled tells whether a channel was skipped only because of its rate limits
+func selectChannel(channels []*Channel, model string, failedChannelIds []int, stickyKey string, channelGroup *ChannelGroup, constraints []string) (selected *Channel, throttled bool) {
+	var candidates []*Channel
+	for _, channel := range channels {
+		if !common.IsRegionAllowed(channel.Region, constraints...) || containsChannelId(failedChannelIds, channel.Id) {
+			continue
+		}
+		if channelGroup != nil && !channelGroup.contains(channel.Id) {
+			continue
+		}
+		if isChannelCircuitOpen(channel.Id) {
+			continue
+		}
+		// the lower priorities take over the requests beyond the limits
+		if isChannelThrottled(channel, model) {
+			throttled = true
+			continue
+		}
+		if len(candidates) > 0 && channel.GetPriority() < candidates[0].GetPriority() {
+			continue
+		}
+		if len(candidates) > 0 && channel.GetPriority() > candidates[0].GetPriority() {
+			candidates = candidates[:0]
+		}
+		candidates = append(candidates, channel)
+	}
+	if len(candidates) == 0 {
+		return nil, throttled
+	}
+	if stickyKey != "" {
+		return getStickyChannel(candidates, stickyKey), false
+	}
+	routingMode := common.GetModelRoutingMode(model)
+	if channelGroup != nil && channelGroup.Strategy != "" {
+		routingMode = channelGroup.Strategy
+	}
+	if routingMode == common.RoutingModeLatency {
+		return getFastestChannel(candidates, model), false
+	}
+	return getWeightedRandomChannel(candidates), false
+}
+
+// pickChannel admits the request through the circuit breaker and the rate limits of the selected channel, while all
+// the channels of the model satisfying the constraints are at their rate limits the request is queued behind the
+// earlier ones of the same scope of the same or a higher priority
+func pickChannel(channels []*Channel, selection *ChannelSelection) (*Channel, error) {
+	model := selection.Model
+	var channelGroup *ChannelGroup
+	if selection.ChannelGroup != "" {
+		// a channel group which does not exist has no channel rather than all of them
+		channelGroup = GetChannelGroupByName(selection.ChannelGroup)
+		if channelGroup == nil {
+			return nil, gorm.ErrRecordNotFound
+		}
+	}
+	if selection.ChannelId != 0 {
+		var selected []*Channel
+		for _, channel := range channels {
+			if channel.Id == selection.ChannelId {
+				selected = append(selected, channel)
+			}
+		}
+		channels = selected
+	}
+	priority := selection.Priority
+	if priority == 0 {
+		priority = common.PriorityNormal
+	}
+	pick := func() (*Channel, error) {
+		skippedChannelIds := selection.FailedChannelIds
+		raced := false
+		for {
+			channel, throttled := selectChannel(channels, model, skippedChannelIds, selection.StickyKey, channelGroup, selection.Constraints)
+			if channel != nil {
+				skippedChannelIds = append(skippedChannelIds[:len(skippedChannelIds):len(skippedChannelIds)], channel.Id)
+				// a concurrent request may have become the probe of the breaker
+				if !acquireChannelCircuit(channel.Id) {
+					continue
+				}
+				if AcquireChannelRateLimit(channel, model) {
+					return channel, nil
+				}
+				// the channel reached its limit with a concurrent request
+				ReleaseChannelCircuitProbe(channel.Id)
+				raced = true
+				continue
+			}
+			if !throttled && !raced {
+				return nil, gorm.ErrRecordNotFound
+			}
+			return nil, ErrChannelsThrottled
+		}
+	}
+	// the queued requests of the same or a higher priority go first
+	scope := getChannelQueueScope(selection)
+	if !hasChannelWaiters(scope, priority) {
+		channel, err := pick()
+		if !errors.Is(err, ErrChannelsThrottled) || selection.ChannelId != 0 {
+			return channel, err
+		}
+	} else if selection.ChannelId != 0 {
+		return nil, ErrChannelsThrottled
+	}
+	ctx := selection.Context
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return waitChannel(ctx, scope, model, priority, pick)
+}
+
+func containsChannelId(channelIds []int, id int) bool {
+	for _, channelId := range channelIds {
+		if channelId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// getWeightedRandomChannel picks a channel with a probability proportional to its weight, the channels without a weight count as 1
+func getWeightedRandomChannel(channels []*Channel) *Channel {
+	totalWeight := 0
+	for _, channel := range channels {
+		totalWeight += channel.GetWeight()
+	}
+	n := rand.Intn(totalWeight)
+	for _, channel := range channels {
+		n -= channel.GetWeight()
+		if n < 0 {
+			return channel
+		}
+	}
+	return channels[len(channels)-1]
+}
+
+// GetGroupModels returns the models which can be used by the group
+func GetGroupModels(group string) (models []string, err error) {
+	err = DB.Model(&Ability{}).Where("`group` = ? and enabled = 1", group).Distinct("model").Pluck("model", &models).Error
+	return models, err
 }
 
 func (channel *Channel) AddAbilities() error {
@@ -0,0 +1,137 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch is a job of the Batch API, its requests are either sent to the batch API of an upstream, or run by the
+// gateway one after another on the channels
+type Batch struct {
+	Id               string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	UserId           int    `json:"-" gorm:"index"`
+	TokenId          int    `json:"-"`
+	Endpoint         string `json:"endpoint"`
+	InputFileId      string `json:"input_file_id"`
+	CompletionWindow string `json:"completion_window"`
+	Status           string `json:"status" gorm:"type:varchar(16);index"`
+	OutputFileId     string `json:"output_file_id"`
+	ErrorFileId      string `json:"error_file_id"`
+	Errors           string `json:"-" gorm:"type:text"` // the errors of the validation in JSON
+	Metadata         string `json:"-" gorm:"type:text"` // in JSON
+	Total            int    `json:"-"`
+	Completed        int    `json:"-"`
+	Failed           int    `json:"-"`
+	ChannelId        int    `json:"-"`                   // 0 for the batches run by the gateway
+	KeyHash          string `json:"-" gorm:"default:''"` // of the key which created the upstream batch
+	UpstreamBatchId  string `json:"-" gorm:"default:''"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint"`
+	InProgressAt     int64  `json:"in_progress_at" gorm:"bigint"`
+	ExpiresAt        int64  `json:"expires_at" gorm:"bigint"`
+	CompletedAt      int64  `json:"completed_at" gorm:"bigint"`
+	FailedAt         int64  `json:"failed_at" gorm:"bigint"`
+	CancelledAt      int64  `json:"cancelled_at" gorm:"bigint"`
+}
+
+// BatchOutput is the output of a request of a batch run by the gateway, it is kept until the output files are written
+// so that a batch is resumed where it stopped
+type BatchOutput struct {
+	Id      int    `json:"id"`
+	BatchId string `json:"batch_id" gorm:"type:varchar(64);index"`
+	Line    int    `json:"line"`
+	Output  string `json:"output" gorm:"type:text"`
+	IsError bool   `json:"is_error"`
+}
+
+func (batch *Batch) Insert() error {
+	batch.CreatedAt = common.GetTimestamp()
+	return DB.Create(batch).Error
+}
+
+func (batch *Batch) Update() error {
+	return DB.Save(batch).Error
+}
+
+// IsDone tells if the batch will not change anymore
+func (batch *Batch) IsDone() bool {
+	switch batch.Status {
+	case BatchStatusFailed, BatchStatusCompleted, BatchStatusExpired, BatchStatusCancelled:
+		return true
+	}
+	return false
+}
+
+func GetUserBatches(userId int, after string, limit int) (batches []*Batch, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if after != "" {
+		var afterBatch Batch
+		if DB.Where("id = ? and user_id = ?", after, userId).First(&afterBatch).Error == nil {
+			tx = tx.Where("created_at < ? or (created_at = ? and id < ?)", afterBatch.CreatedAt, afterBatch.CreatedAt, afterBatch.Id)
+		}
+	}
+	err = tx.Order("created_at desc, id desc").Limit(limit).Find(&batches).Error
+	return batches, err
+}
+
+func GetUserBatch(id string, userId int) (*Batch, error) {
+	batch := &Batch{}
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(batch).Error
+	return batch, err
+}
+
+func GetBatchById(id string) (*Batch, error) {
+	batch := &Batch{}
+	err := DB.Where("id = ?", id).First(batch).Error
+	return batch, err
+}
+
+// GetUnfinishedBatches are the batches to run or to poll, the oldest first
+func GetUnfinishedBatches() (batches []*Batch, err error) {
+	err = DB.Where("status in ?", []string{BatchStatusValidating, BatchStatusInProgress, BatchStatusFinalizing, BatchStatusCancelling}).
+		Order("created_at").Find(&batches).Error
+	return batches, err
+}
+
+func RecordBatchOutput(output *BatchOutput) error {
+	return DB.Create(output).Error
+}
+
+func GetBatchOutputs(batchId string) (outputs []*BatchOutput, err error) {
+	err = DB.Where("batch_id = ?", batchId).Order("line").Find(&outputs).Error
+	return outputs, err
+}
+
+func DeleteBatchOutputs(batchId string) {
+	err := DB.Where("batch_id = ?", batchId).Delete(&BatchOutput{}).Error
+	if err != nil {
+		common.SysError("failed to delete batch outputs: " + err.Error())
+	}
+}
+
+// SetBatchStatus changes the batch only if it is in one of the statuses, so that the worker and the cancellations do
+// not overwrite each other
+func SetBatchStatus(id string, fromStatuses []string, fields map[string]any) bool {
+	result := DB.Model(&Batch{}).Where("id = ? and status in ?", id, fromStatuses).Updates(fields)
+	if result.Error != nil {
+		common.SysError("failed to update batch status: " + result.Error.Error())
+		return false
+	}
+	return result.RowsAffected == 1
+}
+
+func UpdateBatchCounts(id string, completed int, failed int) {
+	err := DB.Model(&Batch{}).Where("id = ?", id).Updates(map[string]any{"completed": completed, "failed": failed}).Error
+	if err != nil {
+		common.SysError("failed to update batch counts: " + err.Error())
+	}
+}
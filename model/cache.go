@@ -2,9 +2,7 @@ package model
 
 import (
 	"encoding/json"
-	"errors"
 	"fmt"
-	"math/rand"
 	"one-api/common"
 	"strconv"
 	"strings"
@@ -63,6 +61,59 @@ func CacheGetUserGroup(id int) (group string, err error) {
 	return group, err
 }
 
+func CacheGetUserCreditLimit(id int) (creditLimit int, err error) {
+	if !common.RedisEnabled {
+		return GetUserCreditLimit(id)
+	}
+	creditLimitString, err := common.RedisGet(fmt.Sprintf("user_credit_limit:%d", id))
+	if err != nil {
+		creditLimit, err = GetUserCreditLimit(id)
+		if err != nil {
+			return 0, err
+		}
+		err = common.RedisSet(fmt.Sprintf("user_credit_limit:%d", id), fmt.Sprintf("%d", creditLimit), time.Duration(UserId2QuotaCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("Redis set user credit limit error: " + err.Error())
+		}
+		return creditLimit, err
+	}
+	creditLimit, err = strconv.Atoi(creditLimitString)
+	return creditLimit, err
+}
+
+func CacheGetUserPriority(id int) (priority int, err error) {
+	if !common.RedisEnabled {
+		return GetUserPriority(id)
+	}
+	priorityString, err := common.RedisGet(fmt.Sprintf("user_priority:%d", id))
+	if err != nil {
+		priority, err = GetUserPriority(id)
+		if err != nil {
+			return 0, err
+		}
+		err = common.RedisSet(fmt.Sprintf("user_priority:%d", id), fmt.Sprintf("%d", priority), time.Duration(UserId2GroupCacheSeconds)*time.Second)
+		if err != nil {
+			common.SysError("Redis set user priority error: " + err.Error())
+		}
+		return priority, err
+	}
+	priority, err = strconv.Atoi(priorityString)
+	return priority, err
+}
+
+// CacheGetUserAvailableQuota is the quota the user can still spend, including the credit of postpaid users
+func CacheGetUserAvailableQuota(id int) (quota int, err error) {
+	quota, err = CacheGetUserQuota(id)
+	if err != nil {
+		return 0, err
+	}
+	creditLimit, err := CacheGetUserCreditLimit(id)
+	if err != nil {
+		return 0, err
+	}
+	return quota + creditLimit, nil
+}
+
 func CacheGetUserQuota(id int) (quota int, err error) {
 	if !common.RedisEnabled {
 		return GetUserQuota(id)
@@ -96,8 +147,13 @@ func CacheUpdateUserQuota(id int) error {
 }
 
 func CacheIsUserEnabled(userId int) bool {
+	if enabled, ok := cacheIsUserEnabledLocally(userId); ok {
+		return enabled
+	}
 	if !common.RedisEnabled {
-		return IsUserEnabled(userId)
+		enabled := IsUserEnabled(userId)
+		setUserEnabledLocally(userId, enabled)
+		return enabled
 	}
 	enabled, err := common.RedisGet(fmt.Sprintf("user_enabled:%d", userId))
 	if err != nil {
@@ -111,6 +167,7 @@ func CacheIsUserEnabled(userId int) bool {
 			common.SysError("Redis set user enabled error: " + err.Error())
 		}
 	}
+	setUserEnabledLocally(userId, enabled == "1")
 	return enabled == "1"
 }
 
@@ -161,15 +218,22 @@ func SyncChannelCache(frequency int) {
 }
 
 func CacheGetRandomSatisfiedChannel(group string, model string) (*Channel, error) {
+	return CacheGetFailoverChannel(&ChannelSelection{Group: group, Model: model})
+}
+
+// CacheGetRandomSatisfiedChannelInRegion only picks the channels whose region satisfies all the constraints
+func CacheGetRandomSatisfiedChannelInRegion(group string, model string, constraints ...string) (*Channel, error) {
+	return CacheGetFailoverChannel(&ChannelSelection{Group: group, Model: model, Constraints: constraints})
+}
+
+// CacheGetFailoverChannel picks a channel for the selection, see GetFailoverChannel
+func CacheGetFailoverChannel(selection *ChannelSelection) (*Channel, error) {
 	if !common.RedisEnabled {
-		return GetRandomSatisfiedChannel(group, model)
+		return GetFailoverChannel(selection)
 	}
+	// the lock is not held while waiting for the rate limits
 	channelSyncLock.RLock()
-	defer channelSyncLock.RUnlock()
-	channels := group2model2channels[group][model]
-	if len(channels) == 0 {
-		return nil, errors.New("channel not found")
-	}
-	idx := rand.Intn(len(channels))
-	return channels[idx], nil
+	channels := group2model2channels[selection.Group][selection.Model]
+	channelSyncLock.RUnlock()
+	return pickChannel(channels, selection)
 }
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChannelSpend is the quota used by a channel in a day, for the budgets of the channel
+type ChannelSpend struct {
+	Id        int    `json:"id"`
+	ChannelId int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_spend"`
+	Day       string `json:"day" gorm:"type:varchar(10);uniqueIndex:idx_channel_spend;index"` // 2006-01-02, local time
+	Quota     int64  `json:"quota" gorm:"bigint;default:0"`
+}
+
+type channelSpendKey struct {
+	channelId int
+	day       string
+}
+
+var channelSpendLock sync.Mutex
+var pendingChannelSpends = make(map[channelSpendKey]int64)
+
+func (channel *Channel) GetDailyBudget() int {
+	if channel.DailyBudget == nil {
+		return 0
+	}
+	return *channel.DailyBudget
+}
+
+func (channel *Channel) GetMonthlyBudget() int {
+	if channel.MonthlyBudget == nil {
+		return 0
+	}
+	return *channel.MonthlyBudget
+}
+
+// RecordChannelSpend only accumulates in memory, SyncChannelSpends writes it to the database periodically
+func RecordChannelSpend(channelId int, quota int) {
+	if quota <= 0 {
+		return
+	}
+	day := time.Now().Format("2006-01-02")
+	channelSpendLock.Lock()
+	defer channelSpendLock.Unlock()
+	pendingChannelSpends[channelSpendKey{channelId, day}] += int64(quota)
+}
+
+func flushChannelSpend(key channelSpendKey, quota int64) error {
+	result := DB.Model(&ChannelSpend{}).Where("channel_id = ? and day = ?", key.channelId, key.day).Update("quota", gorm.Expr("quota + ?", quota))
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(&ChannelSpend{ChannelId: key.channelId, Day: key.day, Quota: quota}).Error
+	if err != nil {
+		// another node may have created the row in the meantime
+		return DB.Model(&ChannelSpend{}).Where("channel_id = ? and day = ?", key.channelId, key.day).Update("quota", gorm.Expr("quota + ?", quota)).Error
+	}
+	return nil
+}
+
+func FlushChannelSpends() {
+	channelSpendLock.Lock()
+	pending := pendingChannelSpends
+	pendingChannelSpends = make(map[channelSpendKey]int64)
+	channelSpendLock.Unlock()
+	for key, quota := range pending {
+		err := flushChannelSpend(key, quota)
+		if err != nil {
+			common.SysError("failed to flush channel spend: " + err.Error())
+		}
+	}
+}
+
+func SyncChannelSpends(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushChannelSpends()
+	}
+}
+
+// GetChannelSpend is the quota used by the channel today and in the current calendar month
+func GetChannelSpend(channelId int) (daily int64, monthly int64, err error) {
+	now := time.Now()
+	err = DB.Model(&ChannelSpend{}).Select("COALESCE(sum(quota), 0)").Where("channel_id = ? and day = ?", channelId, now.Format("2006-01-02")).Scan(&daily).Error
+	if err != nil {
+		return 0, 0, err
+	}
+	err = DB.Model(&ChannelSpend{}).Select("COALESCE(sum(quota), 0)").Where("channel_id = ? and day LIKE ?", channelId, now.Format("2006-01")+"-%").Scan(&monthly).Error
+	return daily, monthly, err
+}
+
+func GetChannelsWithBudget() (channels []*Channel, err error) {
+	err = DB.Omit("key").Where("daily_budget > 0 or monthly_budget > 0 or status = ?", common.ChannelStatusOverBudget).Find(&channels).Error
+	return channels, err
+}
+
+func deleteChannelSpends(channelId int) error {
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelSpend{}).Error
+}
@@ -0,0 +1,163 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"sort"
+	"sync"
+)
+
+const (
+	CircuitStateClosed   = "closed"    // the requests pass
+	CircuitStateOpen     = "open"      // the channel is skipped until CircuitBreakerOpenTime elapses
+	CircuitStateHalfOpen = "half_open" // one request at a time probes whether the channel recovered
+)
+
+// a probe which never reported back, such as a client gone away, does not keep the breaker half-open forever
+const channelCircuitProbeTimeout = 60 // in seconds
+
+// ChannelCircuit is the breaker of a channel, kept in memory by each node
+type ChannelCircuit struct {
+	ChannelId           int    `json:"channel_id"`
+	State               string `json:"state"`
+	ConsecutiveFailures int    `json:"consecutive_failures"`
+	OpenedTime          int64  `json:"opened_time"`  // when it last opened
+	OpenedCount         int    `json:"opened_count"` // how many times it opened since the node started
+	probeTime           int64  // when the probe in flight of the half-open breaker started, 0 if none
+}
+
+var channelCircuitLock sync.Mutex
+var channelCircuits = make(map[int]*ChannelCircuit)
+
+func getChannelCircuit(channelId int) *ChannelCircuit {
+	circuit, ok := channelCircuits[channelId]
+	if !ok {
+		circuit = &ChannelCircuit{ChannelId: channelId, State: CircuitStateClosed}
+		channelCircuits[channelId] = circuit
+	}
+	return circuit
+}
+
+// refresh turns an open breaker half-open once the open time elapsed, the caller must hold channelCircuitLock
+func (circuit *ChannelCircuit) refresh(now int64) {
+	if circuit.State == CircuitStateOpen && now-circuit.OpenedTime >= int64(common.CircuitBreakerOpenTime) {
+		circuit.State = CircuitStateHalfOpen
+		circuit.probeTime = 0
+	}
+	if circuit.State == CircuitStateHalfOpen && circuit.probeTime != 0 && now-circuit.probeTime >= channelCircuitProbeTimeout {
+		circuit.probeTime = 0
+	}
+}
+
+func (circuit *ChannelCircuit) isAllowed() bool {
+	switch circuit.State {
+	case CircuitStateOpen:
+		return false
+	case CircuitStateHalfOpen:
+		return circuit.probeTime == 0
+	}
+	return true
+}
+
+// isChannelCircuitOpen tells whether the breaker of the channel refuses a request now
+func isChannelCircuitOpen(channelId int) bool {
+	if common.CircuitBreakerFailureThreshold <= 0 {
+		return false
+	}
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	circuit, ok := channelCircuits[channelId]
+	if !ok {
+		return false
+	}
+	circuit.refresh(common.GetTimestamp())
+	return !circuit.isAllowed()
+}
+
+// acquireChannelCircuit lets a request through the breaker of the channel, the request becomes the probe of a
+// half-open breaker
+func acquireChannelCircuit(channelId int) bool {
+	if common.CircuitBreakerFailureThreshold <= 0 {
+		return true
+	}
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	circuit, ok := channelCircuits[channelId]
+	if !ok {
+		return true
+	}
+	now := common.GetTimestamp()
+	circuit.refresh(now)
+	if !circuit.isAllowed() {
+		return false
+	}
+	if circuit.State == CircuitStateHalfOpen {
+		circuit.probeTime = now
+	}
+	return true
+}
+
+// ReleaseChannelCircuitProbe lets another request probe the channel when the request ended without telling whether
+// the channel works, such as when the request itself was invalid
+func ReleaseChannelCircuitProbe(channelId int) {
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	if circuit, ok := channelCircuits[channelId]; ok && circuit.State == CircuitStateHalfOpen {
+		circuit.probeTime = 0
+	}
+}
+
+// RecordChannelCircuitResult opens the breaker after CircuitBreakerFailureThreshold failures in a row or a failed
+// probe, and closes it after a successful probe
+func RecordChannelCircuitResult(channelId int, success bool) {
+	if common.CircuitBreakerFailureThreshold <= 0 {
+		return
+	}
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	if _, ok := channelCircuits[channelId]; !ok && success {
+		return
+	}
+	circuit := getChannelCircuit(channelId)
+	now := common.GetTimestamp()
+	circuit.refresh(now)
+	if success {
+		if circuit.State != CircuitStateClosed {
+			common.SysLog(fmt.Sprintf("circuit breaker of channel #%d closed", channelId))
+		}
+		circuit.State = CircuitStateClosed
+		circuit.ConsecutiveFailures = 0
+		circuit.probeTime = 0
+		return
+	}
+	circuit.ConsecutiveFailures++
+	// the requests sent before the breaker opened may still fail while it is open
+	if circuit.State == CircuitStateHalfOpen || (circuit.State == CircuitStateClosed && circuit.ConsecutiveFailures >= common.CircuitBreakerFailureThreshold) {
+		circuit.State = CircuitStateOpen
+		circuit.OpenedTime = now
+		circuit.OpenedCount++
+		circuit.probeTime = 0
+		common.SysLog(fmt.Sprintf("circuit breaker of channel #%d opened", channelId))
+	}
+}
+
+// GetChannelCircuits are the breakers of this node which saw a failure, the others are closed
+func GetChannelCircuits() []ChannelCircuit {
+	channelCircuitLock.Lock()
+	now := common.GetTimestamp()
+	circuits := make([]ChannelCircuit, 0, len(channelCircuits))
+	for _, circuit := range channelCircuits {
+		circuit.refresh(now)
+		circuits = append(circuits, *circuit)
+	}
+	channelCircuitLock.Unlock()
+	sort.Slice(circuits, func(i, j int) bool { return circuits[i].ChannelId < circuits[j].ChannelId })
+	return circuits
+}
+
+// ResetChannelCircuit closes the breaker of the channel, such as when an admin enables the channel again
+func ResetChannelCircuit(channelId int) {
+	channelCircuitLock.Lock()
+	defer channelCircuitLock.Unlock()
+	delete(channelCircuits, channelId)
+}
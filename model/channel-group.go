@@ -0,0 +1,110 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ChannelGroup is a named set of channels which the tokens, the models and the user groups route to instead of all
+// the channels of the model, with its own load balancing strategy
+type ChannelGroup struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(32);uniqueIndex"`
+	Strategy    string `json:"strategy" gorm:"type:varchar(32);default:''"` // weighted or latency, empty follows ModelRoutingMode
+	ChannelIds  string `json:"channel_ids" gorm:"type:text"`                // comma separated, such as 1,2,5
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+
+	channelIds map[int]bool
+}
+
+func ParseChannelGroupChannelIds(channelIds string) (map[int]bool, error) {
+	ids := make(map[int]bool)
+	for _, part := range strings.Split(channelIds, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		id, err := strconv.Atoi(part)
+		if err != nil || id <= 0 {
+			return nil, fmt.Errorf("无效的渠道 ID：%s", part)
+		}
+		ids[id] = true
+	}
+	return ids, nil
+}
+
+func (group *ChannelGroup) contains(channelId int) bool {
+	return group.channelIds[channelId]
+}
+
+var channelGroups map[string]*ChannelGroup
+var channelGroupSyncLock sync.RWMutex
+
+func InitChannelGroupCache() {
+	var groups []*ChannelGroup
+	DB.Find(&groups)
+	newChannelGroups := make(map[string]*ChannelGroup, len(groups))
+	for _, group := range groups {
+		channelIds, err := ParseChannelGroupChannelIds(group.ChannelIds)
+		if err != nil {
+			common.SysError(fmt.Sprintf("invalid channels of channel group #%d: %s", group.Id, err.Error()))
+			continue
+		}
+		group.channelIds = channelIds
+		newChannelGroups[group.Name] = group
+	}
+	channelGroupSyncLock.Lock()
+	channelGroups = newChannelGroups
+	channelGroupSyncLock.Unlock()
+}
+
+func SyncChannelGroupCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitChannelGroupCache()
+	}
+}
+
+// GetChannelGroupByName returns the cached channel group, nil if there is none of the name
+func GetChannelGroupByName(name string) *ChannelGroup {
+	channelGroupSyncLock.RLock()
+	defer channelGroupSyncLock.RUnlock()
+	return channelGroups[name]
+}
+
+func GetAllChannelGroups(startIdx int, num int) (groups []*ChannelGroup, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&groups).Error
+	return groups, err
+}
+
+func GetChannelGroupById(id int) (*ChannelGroup, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	group := ChannelGroup{Id: id}
+	err := DB.First(&group, "id = ?", id).Error
+	return &group, err
+}
+
+func (group *ChannelGroup) Insert() error {
+	err := DB.Create(group).Error
+	InitChannelGroupCache()
+	return err
+}
+
+func (group *ChannelGroup) Update() error {
+	err := DB.Model(group).Select("name", "strategy", "channel_ids").Updates(group).Error
+	InitChannelGroupCache()
+	return err
+}
+
+func (group *ChannelGroup) Delete() error {
+	err := DB.Delete(group).Error
+	InitChannelGroupCache()
+	return err
+}
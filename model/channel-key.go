@@ -0,0 +1,428 @@
+package model
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"one-api/common"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChannelKeyStrategyRoundRobin          = "round_robin"           // the keys take turns
+	ChannelKeyStrategyExhaust             = "exhaust"               // a key is used until it runs out, then the next one, such as to use up the expiring credits first
+	ChannelKeyStrategyLeastRecentlyFailed = "least_recently_failed" // the key which failed the longest time ago, or never
+)
+
+func IsValidChannelKeyStrategy(strategy string) bool {
+	switch strategy {
+	case "", ChannelKeyStrategyRoundRobin, ChannelKeyStrategyExhaust, ChannelKeyStrategyLeastRecentlyFailed:
+		return true
+	}
+	return false
+}
+
+// ChannelKey is the usage of one of the keys of a channel with a key strategy, the key itself is not stored again
+type ChannelKey struct {
+	Id             int    `json:"id"`
+	ChannelId      int    `json:"channel_id" gorm:"uniqueIndex:idx_channel_key"`
+	KeyHash        string `json:"-" gorm:"type:varchar(64);uniqueIndex:idx_channel_key"`
+	KeyHint        string `json:"key_hint" gorm:"type:varchar(32)"` // the head and the tail of the key
+	Status         int    `json:"status" gorm:"default:1"`
+	RequestCount   int    `json:"request_count" gorm:"default:0"`
+	FailedCount    int    `json:"failed_count" gorm:"default:0"`
+	UsedQuota      int64  `json:"used_quota" gorm:"bigint;default:0"`
+	LastUsedTime   int64  `json:"last_used_time" gorm:"bigint"`
+	LastFailedTime int64  `json:"last_failed_time" gorm:"bigint"`
+	LastError      string `json:"last_error"`
+}
+
+type channelKeyState struct {
+	keys       []string
+	usages     []*ChannelKey // in the order of the keys
+	next       int           // where the turns start
+	loadedTime int64
+}
+
+type channelKeyUsageKey struct {
+	channelId int
+	keyHash   string
+}
+
+var channelKeyLock sync.Mutex
+var channelKeyStates = make(map[int]*channelKeyState)
+var pendingChannelKeyUsages = make(map[channelKeyUsageKey]*ChannelKey)
+var channelKeyCooldowns = make(map[channelKeyUsageKey]int64) // until when the keys are skipped
+
+// GetKeys are the keys of the channel, one per line when it has a key strategy
+func (channel *Channel) GetKeys() []string {
+	if channel.KeyStrategy == "" {
+		return []string{channel.Key}
+	}
+	var keys []string
+	for _, key := range strings.Split(channel.Key, "\n") {
+		key = strings.TrimSpace(key)
+		if key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+func hashChannelKey(key string) string {
+	hash := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(hash[:])
+}
+
+func getChannelKeyHint(key string) string {
+	if len(key) <= 8 {
+		return "****"
+	}
+	return key[:3] + "..." + key[len(key)-4:]
+}
+
+func isSameKeys(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// loadChannelKeyState creates the missing usages and removes the ones of the keys no longer in the channel
+func loadChannelKeyState(channelId int, keys []string) (*channelKeyState, error) {
+	var rows []*ChannelKey
+	err := DB.Where("channel_id = ?", channelId).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	rowsByHash := make(map[string]*ChannelKey, len(rows))
+	for _, row := range rows {
+		rowsByHash[row.KeyHash] = row
+	}
+	state := &channelKeyState{keys: keys, loadedTime: common.GetTimestamp()}
+	hashes := make([]string, 0, len(keys))
+	kept := 0
+	for _, key := range keys {
+		hash := hashChannelKey(key)
+		hashes = append(hashes, hash)
+		if _, ok := rowsByHash[hash]; ok {
+			kept++
+		}
+	}
+	for i, key := range keys {
+		hash := hashes[i]
+		row, ok := rowsByHash[hash]
+		if !ok {
+			row = &ChannelKey{ChannelId: channelId, KeyHash: hash, KeyHint: getChannelKeyHint(key), Status: common.ChannelStatusEnabled}
+			if err = DB.Create(row).Error; err != nil {
+				// another node may have created the row in the meantime
+				if err = DB.First(row, "channel_id = ? and key_hash = ?", channelId, hash).Error; err != nil {
+					return nil, err
+				}
+			}
+			rowsByHash[hash] = row
+		}
+		state.usages = append(state.usages, row)
+	}
+	if kept < len(rows) {
+		err = DB.Where("channel_id = ? and key_hash not in ?", channelId, hashes).Delete(&ChannelKey{}).Error
+		if err != nil {
+			common.SysError("failed to delete removed channel keys: " + err.Error())
+		}
+	}
+	return state, nil
+}
+
+// lockChannelKeyState returns the state of the channel with channelKeyLock held, also on error, the state is reloaded
+// when the keys change or after SyncFrequency so that the keys disabled by the other nodes are noticed, the lock is
+// shared by all the channels so it is released while the database is read
+func lockChannelKeyState(channel *Channel) (*channelKeyState, error) {
+	keys := channel.GetKeys()
+	channelKeyLock.Lock()
+	state, ok := channelKeyStates[channel.Id]
+	if ok && isSameKeys(state.keys, keys) && common.GetTimestamp()-state.loadedTime < int64(common.SyncFrequency) {
+		return state, nil
+	}
+	channelKeyLock.Unlock()
+	newState, err := loadChannelKeyState(channel.Id, keys)
+	channelKeyLock.Lock()
+	if err != nil {
+		return nil, err
+	}
+	// a concurrent request may have reloaded the state in the meantime, its turns are kept
+	if state, ok := channelKeyStates[channel.Id]; ok {
+		if isSameKeys(state.keys, keys) && state.loadedTime >= newState.loadedTime {
+			return state, nil
+		}
+		newState.next = state.next
+	}
+	channelKeyStates[channel.Id] = newState
+	return newState, nil
+}
+
+// pick skips the excluded keys and, unless allowed, the ones cooling down after the upstream rate limited them,
+// it returns -1 if no key is left
+func (state *channelKeyState) pick(strategy string, excludedHashes []string, allowCoolingDown bool) int {
+	var picked = -1
+	now := common.GetTimestamp()
+	for i := range state.usages {
+		index := i
+		if strategy != ChannelKeyStrategyExhaust {
+			index = (state.next + i) % len(state.usages)
+		}
+		usage := state.usages[index]
+		if usage.Status != common.ChannelStatusEnabled || containsKeyHash(excludedHashes, usage.KeyHash) {
+			continue
+		}
+		if !allowCoolingDown && channelKeyCooldowns[channelKeyUsageKey{usage.ChannelId, usage.KeyHash}] > now {
+			continue
+		}
+		if strategy != ChannelKeyStrategyLeastRecentlyFailed {
+			picked = index
+			break
+		}
+		// starting after the last picked one, the keys which never failed take turns
+		if picked == -1 || usage.LastFailedTime < state.usages[picked].LastFailedTime {
+			picked = index
+		}
+	}
+	if picked != -1 {
+		state.next = picked + 1
+	}
+	return picked
+}
+
+func containsKeyHash(keyHashes []string, keyHash string) bool {
+	for _, hash := range keyHashes {
+		if hash == keyHash {
+			return true
+		}
+	}
+	return false
+}
+
+// PickChannelKey chooses the key of the channel for a request with its key strategy, the hash identifies the key when
+// its usage or its failure is recorded and is empty for the channels without a key strategy
+func PickChannelKey(channel *Channel) (key string, keyHash string) {
+	if channel.KeyStrategy == "" {
+		return channel.Key, ""
+	}
+	state, err := lockChannelKeyState(channel)
+	defer channelKeyLock.Unlock()
+	if err != nil {
+		common.SysError("failed to load channel keys: " + err.Error())
+		keys := channel.GetKeys()
+		if len(keys) == 0 {
+			return "", ""
+		}
+		return keys[0], ""
+	}
+	if len(state.keys) == 0 {
+		return "", ""
+	}
+	index := state.pick(channel.KeyStrategy, nil, false)
+	if index == -1 {
+		// all the keys are cooling down, the one whose turn it is is still better than none
+		index = state.pick(channel.KeyStrategy, nil, true)
+	}
+	if index == -1 {
+		// all the keys failed, the channel is about to be disabled
+		index = 0
+		state.next = 1
+	}
+	return state.keys[index], state.usages[index].KeyHash
+}
+
+// PickOtherChannelKey chooses another key of the channel than the ones which failed the request already, ok is false
+// when no other key is enabled and out of its cooldown
+func PickOtherChannelKey(channel *Channel, failedKeyHashes []string) (key string, keyHash string, ok bool) {
+	if channel.KeyStrategy == "" {
+		return "", "", false
+	}
+	state, err := lockChannelKeyState(channel)
+	defer channelKeyLock.Unlock()
+	if err != nil {
+		common.SysError("failed to load channel keys: " + err.Error())
+		return "", "", false
+	}
+	index := state.pick(channel.KeyStrategy, failedKeyHashes, false)
+	if index == -1 {
+		return "", "", false
+	}
+	return state.keys[index], state.usages[index].KeyHash, true
+}
+
+// GetChannelKeyByHash is the key of the channel with the hash, ok is false when the channel no longer has it
+func GetChannelKeyByHash(channel *Channel, keyHash string) (key string, ok bool) {
+	for _, key := range channel.GetKeys() {
+		if hashChannelKey(key) == keyHash {
+			return key, true
+		}
+	}
+	return "", false
+}
+
+// CoolDownChannelKey skips the key for ChannelKeyCooldownTime after the upstream rate limited it,
+// the cooldown is kept by each node
+func CoolDownChannelKey(channelId int, keyHash string) {
+	if keyHash == "" || common.ChannelKeyCooldownTime <= 0 {
+		return
+	}
+	channelKeyLock.Lock()
+	defer channelKeyLock.Unlock()
+	now := common.GetTimestamp()
+	for key, until := range channelKeyCooldowns {
+		if until <= now {
+			delete(channelKeyCooldowns, key)
+		}
+	}
+	channelKeyCooldowns[channelKeyUsageKey{channelId, keyHash}] = now + int64(common.ChannelKeyCooldownTime)
+}
+
+func findChannelKeyUsage(channelId int, keyHash string) *ChannelKey {
+	state, ok := channelKeyStates[channelId]
+	if !ok {
+		return nil
+	}
+	for _, usage := range state.usages {
+		if usage.KeyHash == keyHash {
+			return usage
+		}
+	}
+	return nil
+}
+
+// RecordChannelKeyUsage only accumulates in memory, SyncChannelKeyUsages writes it to the database periodically
+func RecordChannelKeyUsage(channelId int, keyHash string, quota int) {
+	if keyHash == "" {
+		return
+	}
+	channelKeyLock.Lock()
+	defer channelKeyLock.Unlock()
+	now := common.GetTimestamp()
+	if usage := findChannelKeyUsage(channelId, keyHash); usage != nil {
+		usage.RequestCount++
+		usage.UsedQuota += int64(quota)
+	}
+	key := channelKeyUsageKey{channelId, keyHash}
+	pending, ok := pendingChannelKeyUsages[key]
+	if !ok {
+		pending = &ChannelKey{ChannelId: channelId, KeyHash: keyHash}
+		pendingChannelKeyUsages[key] = pending
+	}
+	pending.RequestCount++
+	pending.UsedQuota += int64(quota)
+	pending.LastUsedTime = now
+}
+
+// RecordChannelKeyFailure is written at once, it disables the key if asked and returns how many keys of the channel
+// are still enabled
+func RecordChannelKeyFailure(channelId int, keyHash string, reason string, disable bool) int {
+	now := common.GetTimestamp()
+	updates := map[string]interface{}{
+		"failed_count":     gorm.Expr("failed_count + ?", 1),
+		"last_failed_time": now,
+		"last_error":       reason,
+	}
+	if disable {
+		updates["status"] = common.ChannelStatusDisabled
+	}
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ? and key_hash = ?", channelId, keyHash).Updates(updates).Error
+	if err != nil {
+		common.SysError("failed to update channel key: " + err.Error())
+	}
+	channelKeyLock.Lock()
+	if usage := findChannelKeyUsage(channelId, keyHash); usage != nil {
+		usage.FailedCount++
+		usage.LastFailedTime = now
+		usage.LastError = reason
+		if disable {
+			usage.Status = common.ChannelStatusDisabled
+		}
+	}
+	channelKeyLock.Unlock()
+	var enabledCount int64
+	err = DB.Model(&ChannelKey{}).Where("channel_id = ? and status = ?", channelId, common.ChannelStatusEnabled).Count(&enabledCount).Error
+	if err != nil {
+		common.SysError("failed to count enabled channel keys: " + err.Error())
+		return 1
+	}
+	return int(enabledCount)
+}
+
+// EnableChannelKeys gives the keys disabled one by one another chance when the whole channel is enabled again
+func EnableChannelKeys(channelId int) {
+	err := DB.Model(&ChannelKey{}).Where("channel_id = ?", channelId).Update("status", common.ChannelStatusEnabled).Error
+	if err != nil {
+		common.SysError("failed to enable channel keys: " + err.Error())
+	}
+	channelKeyLock.Lock()
+	delete(channelKeyStates, channelId)
+	channelKeyLock.Unlock()
+}
+
+func deleteChannelKeys(channelId int) error {
+	channelKeyLock.Lock()
+	delete(channelKeyStates, channelId)
+	channelKeyLock.Unlock()
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelKey{}).Error
+}
+
+// GetChannelKeyUsages are the usages of the keys in their order in the channel, nil for the channels without a key strategy
+func GetChannelKeyUsages(channel *Channel) ([]*ChannelKey, error) {
+	if channel.KeyStrategy == "" {
+		return nil, nil
+	}
+	FlushChannelKeyUsages()
+	var rows []*ChannelKey
+	err := DB.Where("channel_id = ?", channel.Id).Find(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+	rowsByHash := make(map[string]*ChannelKey, len(rows))
+	for _, row := range rows {
+		rowsByHash[row.KeyHash] = row
+	}
+	usages := make([]*ChannelKey, 0, len(rows))
+	for _, key := range channel.GetKeys() {
+		if row, ok := rowsByHash[hashChannelKey(key)]; ok {
+			usages = append(usages, row)
+		} else {
+			// not used yet
+			usages = append(usages, &ChannelKey{ChannelId: channel.Id, KeyHint: getChannelKeyHint(key), Status: common.ChannelStatusEnabled})
+		}
+	}
+	return usages, nil
+}
+
+func FlushChannelKeyUsages() {
+	channelKeyLock.Lock()
+	pending := pendingChannelKeyUsages
+	pendingChannelKeyUsages = make(map[channelKeyUsageKey]*ChannelKey)
+	channelKeyLock.Unlock()
+	for _, usage := range pending {
+		err := DB.Model(&ChannelKey{}).Where("channel_id = ? and key_hash = ?", usage.ChannelId, usage.KeyHash).Updates(map[string]interface{}{
+			"request_count":  gorm.Expr("request_count + ?", usage.RequestCount),
+			"used_quota":     gorm.Expr("used_quota + ?", usage.UsedQuota),
+			"last_used_time": usage.LastUsedTime,
+		}).Error
+		if err != nil {
+			common.SysError("failed to flush channel key usage: " + err.Error())
+		}
+	}
+}
+
+func SyncChannelKeyUsages(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushChannelKeyUsages()
+	}
+}
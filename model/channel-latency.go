@@ -0,0 +1,136 @@
+package model
+
+import (
+	"math/rand"
+	"one-api/common"
+	"sort"
+	"sync"
+)
+
+const channelLatencyWindow = 100    // the latest requests of a channel for a model which are measured
+const channelLatencyMinSamples = 10 // the channels measured less are only reached by exploration
+
+type channelLatencySample struct {
+	latency int64 // in milliseconds
+	success bool
+}
+
+type channelLatencyKey struct {
+	channelId int
+	model     string
+}
+
+// channelLatencyRing is kept in memory by each node, the latencies of the failed requests are not counted
+type channelLatencyRing struct {
+	samples []channelLatencySample
+	next    int
+}
+
+type ChannelLatencyStats struct {
+	ChannelId int     `json:"channel_id"`
+	Model     string  `json:"model"`
+	Samples   int     `json:"samples"`
+	P50       int64   `json:"p50"` // in milliseconds
+	P95       int64   `json:"p95"`
+	ErrorRate float64 `json:"error_rate"`
+}
+
+var channelLatencyLock sync.RWMutex
+var channelLatencies = make(map[channelLatencyKey]*channelLatencyRing)
+
+func RecordChannelLatency(channelId int, model string, latency int64, success bool) {
+	key := channelLatencyKey{channelId, model}
+	channelLatencyLock.Lock()
+	defer channelLatencyLock.Unlock()
+	ring, ok := channelLatencies[key]
+	if !ok {
+		ring = &channelLatencyRing{}
+		channelLatencies[key] = ring
+	}
+	sample := channelLatencySample{latency: latency, success: success}
+	if len(ring.samples) < channelLatencyWindow {
+		ring.samples = append(ring.samples, sample)
+		return
+	}
+	ring.samples[ring.next] = sample
+	ring.next = (ring.next + 1) % channelLatencyWindow
+}
+
+func percentile(sorted []int64, p int) int64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+	return sorted[(len(sorted)-1)*p/100]
+}
+
+func (ring *channelLatencyRing) getStats(key channelLatencyKey) *ChannelLatencyStats {
+	stats := &ChannelLatencyStats{ChannelId: key.channelId, Model: key.model, Samples: len(ring.samples)}
+	latencies := make([]int64, 0, len(ring.samples))
+	failures := 0
+	for _, sample := range ring.samples {
+		if sample.success {
+			latencies = append(latencies, sample.latency)
+		} else {
+			failures++
+		}
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	stats.P50 = percentile(latencies, 50)
+	stats.P95 = percentile(latencies, 95)
+	if stats.Samples > 0 {
+		stats.ErrorRate = float64(failures) / float64(stats.Samples)
+	}
+	return stats
+}
+
+func getChannelLatencyStats(channelId int, model string) *ChannelLatencyStats {
+	key := channelLatencyKey{channelId, model}
+	channelLatencyLock.RLock()
+	defer channelLatencyLock.RUnlock()
+	ring, ok := channelLatencies[key]
+	if !ok {
+		return &ChannelLatencyStats{ChannelId: channelId, Model: model}
+	}
+	return ring.getStats(key)
+}
+
+// GetAllChannelLatencyStats are the statistics of this node, by channel and then by model
+func GetAllChannelLatencyStats() []*ChannelLatencyStats {
+	channelLatencyLock.RLock()
+	allStats := make([]*ChannelLatencyStats, 0, len(channelLatencies))
+	for key, ring := range channelLatencies {
+		allStats = append(allStats, ring.getStats(key))
+	}
+	channelLatencyLock.RUnlock()
+	sort.Slice(allStats, func(i, j int) bool {
+		if allStats[i].ChannelId != allStats[j].ChannelId {
+			return allStats[i].ChannelId < allStats[j].ChannelId
+		}
+		return allStats[i].Model < allStats[j].Model
+	})
+	return allStats
+}
+
+// getFastestChannel picks the channel with the lowest median latency among the ones measured enough and failing less
+// than LatencyRoutingMaxErrorRate, a part of the requests is still routed by weight so that the others keep being measured
+func getFastestChannel(channels []*Channel, model string) *Channel {
+	if rand.Intn(100) < common.LatencyRoutingExploreRate {
+		return getWeightedRandomChannel(channels)
+	}
+	var fastest *Channel
+	var fastestStats *ChannelLatencyStats
+	for _, channel := range channels {
+		stats := getChannelLatencyStats(channel.Id, model)
+		if stats.Samples < channelLatencyMinSamples || stats.ErrorRate > common.LatencyRoutingMaxErrorRate {
+			continue
+		}
+		if fastest == nil || stats.P50 < fastestStats.P50 {
+			fastest = channel
+			fastestStats = stats
+		}
+	}
+	if fastest == nil {
+		return getWeightedRandomChannel(channels)
+	}
+	return fastest
+}
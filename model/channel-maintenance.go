@@ -0,0 +1,96 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"time"
+)
+
+const maintenanceTimeLayout = "2006-01-02 15:04"
+
+// the longest recurring window, a longer maintenance is better expressed with a time range
+const maxMaintenanceDuration = 7 * 24 * 60 // in minutes
+
+// MaintenanceWindow is either recurring, starting when the cron expression fires and lasting Duration minutes, or a
+// time range from Start to End, both in the local time of the server
+type MaintenanceWindow struct {
+	Cron     string `json:"cron,omitempty"`     // such as 0 2 * * 6 for every Saturday at 2:00
+	Duration int    `json:"duration,omitempty"` // in minutes
+	Start    string `json:"start,omitempty"`    // 2006-01-02 15:04
+	End      string `json:"end,omitempty"`
+}
+
+func parseMaintenanceWindows(windowsJSON string) ([]MaintenanceWindow, error) {
+	var windows []MaintenanceWindow
+	if windowsJSON == "" {
+		return windows, nil
+	}
+	err := json.Unmarshal([]byte(windowsJSON), &windows)
+	return windows, err
+}
+
+// isActive tells whether the time is within the window, a recurring window is checked minute by minute backwards
+// over its duration
+func (window *MaintenanceWindow) isActive(now time.Time) (bool, error) {
+	if window.Cron != "" {
+		if window.Duration <= 0 || window.Duration > maxMaintenanceDuration {
+			return false, errors.New("invalid duration")
+		}
+		schedule, err := common.ParseCronSchedule(window.Cron)
+		if err != nil {
+			return false, err
+		}
+		minute := now.Truncate(time.Minute)
+		for i := 0; i < window.Duration; i++ {
+			if schedule.Matches(minute.Add(-time.Duration(i) * time.Minute)) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	start, err := time.ParseInLocation(maintenanceTimeLayout, window.Start, time.Local)
+	if err != nil {
+		return false, err
+	}
+	end, err := time.ParseInLocation(maintenanceTimeLayout, window.End, time.Local)
+	if err != nil {
+		return false, err
+	}
+	if !end.After(start) {
+		return false, errors.New("the end is not after the start")
+	}
+	return !now.Before(start) && now.Before(end), nil
+}
+
+func IsValidMaintenanceWindows(windowsJSON string) bool {
+	windows, err := parseMaintenanceWindows(windowsJSON)
+	if err != nil {
+		return false
+	}
+	for _, window := range windows {
+		if _, err := window.isActive(time.Now()); err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// IsInMaintenance tells whether the time is within one of the maintenance windows of the channel
+func (channel *Channel) IsInMaintenance(now time.Time) bool {
+	windows, err := parseMaintenanceWindows(channel.MaintenanceWindows)
+	if err != nil {
+		return false
+	}
+	for _, window := range windows {
+		if active, err := window.isActive(now); err == nil && active {
+			return true
+		}
+	}
+	return false
+}
+
+func GetChannelsWithMaintenanceWindows() (channels []*Channel, err error) {
+	err = DB.Omit("key").Where("maintenance_windows <> '' or status = ?", common.ChannelStatusMaintenance).Find(&channels).Error
+	return channels, err
+}
@@ -0,0 +1,65 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+const MaxChannelProbesPerChannel = 100
+
+// ChannelProbe is the result of a health check of a channel
+type ChannelProbe struct {
+	Id           int    `json:"id"`
+	ChannelId    int    `json:"channel_id" gorm:"index"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+	Success      bool   `json:"success"`
+	ResponseTime int    `json:"response_time"` // in milliseconds
+	Message      string `json:"message"`
+	Status       int    `json:"status"`      // the status of the channel after the check
+	StatusCode   int    `json:"status_code"` // of the upstream response
+	Model        string `json:"model"`       // the model the upstream answered with
+	Stream       bool   `json:"stream"`      // whether the test payload was streamed
+}
+
+// RecordChannelProbe keeps the latest MaxChannelProbesPerChannel checks of the channel
+func RecordChannelProbe(probe *ChannelProbe) {
+	probe.CreatedTime = common.GetTimestamp()
+	err := DB.Create(probe).Error
+	if err != nil {
+		common.SysError("failed to record channel probe: " + err.Error())
+		return
+	}
+	var oldest ChannelProbe
+	err = DB.Where("channel_id = ?", probe.ChannelId).Order("id desc").Offset(MaxChannelProbesPerChannel - 1).Limit(1).Find(&oldest).Error
+	if err != nil || oldest.Id == 0 {
+		return
+	}
+	err = DB.Where("channel_id = ? and id < ?", probe.ChannelId, oldest.Id).Delete(&ChannelProbe{}).Error
+	if err != nil {
+		common.SysError("failed to delete old channel probes: " + err.Error())
+	}
+}
+
+func GetChannelProbes(channelId int, num int) (probes []*ChannelProbe, err error) {
+	err = DB.Where("channel_id = ?", channelId).Order("id desc").Limit(num).Find(&probes).Error
+	return probes, err
+}
+
+// CountConsecutiveChannelProbes is how many of the latest checks of the channel in a row have the result
+func CountConsecutiveChannelProbes(channelId int, success bool, limit int) (int, error) {
+	probes, err := GetChannelProbes(channelId, limit)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, probe := range probes {
+		if probe.Success != success {
+			break
+		}
+		count++
+	}
+	return count, nil
+}
+
+func deleteChannelProbes(channelId int) error {
+	return DB.Where("channel_id = ?", channelId).Delete(&ChannelProbe{}).Error
+}
@@ -0,0 +1,216 @@
+package model
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrChannelQueueFull is returned when the queue of the requests waiting for a channel is at ChannelQueueDepth, or
+// when a queued request is shed for one of a higher priority
+var ErrChannelQueueFull = errors.New("the queue of the requests waiting for a channel is full")
+
+// channelWaiter is a request waiting for a channel while all the channels of its selection are at their rate limits
+type channelWaiter struct {
+	scope      string // the requests which select among the same channels share a queue, see getChannelQueueScope
+	model      string
+	priority   int
+	enqueuedAt time.Time
+	shed       bool          // the waiter gave its place to a request of a higher priority
+	ready      chan struct{} // signaled when the waiter gets to the head of its queue or is shed
+}
+
+// ChannelQueueStat is the queue of a model on this node
+type ChannelQueueStat struct {
+	Model    string  `json:"model"`
+	Waiting  int     `json:"waiting"`
+	Enqueued int64   `json:"enqueued"`
+	Released int64   `json:"released"`  // the requests which got a channel after waiting
+	TimedOut int64   `json:"timed_out"` // the requests which gave up after ChannelRateLimitWaitTime
+	Rejected int64   `json:"rejected"`  // the requests refused because the queues were full
+	Shed     int64   `json:"shed"`      // the queued requests dropped for the ones of a higher priority
+	WaitTime float64 `json:"wait_time"` // seconds waited by the released requests
+}
+
+// the queues are kept by each node, the requests of a scope are released by their priority and then in the order
+// they arrived, the stats are kept by model
+var channelQueueLock sync.Mutex
+var channelQueues = make(map[string][]*channelWaiter)
+var channelQueueWaiting = 0
+var channelQueueStats = make(map[string]*ChannelQueueStat)
+
+func getChannelQueueStat(model string) *ChannelQueueStat {
+	stat, ok := channelQueueStats[model]
+	if !ok {
+		stat = &ChannelQueueStat{Model: model}
+		channelQueueStats[model] = stat
+	}
+	return stat
+}
+
+// getChannelQueueScope is the queue of the selection, the requests of a model for other groups, channel groups or
+// regions select among other channels and do not wait behind each other
+func getChannelQueueScope(selection *ChannelSelection) string {
+	return fmt.Sprintf("%s\n%s\n%s\n%s", selection.Group, selection.Model, selection.ChannelGroup, strings.Join(selection.Constraints, ","))
+}
+
+// hasChannelWaiters tells whether requests of the scope of the same or a higher priority are queued
+func hasChannelWaiters(scope string, priority int) bool {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	queue := channelQueues[scope]
+	return len(queue) > 0 && queue[0].priority >= priority
+}
+
+func (waiter *channelWaiter) signal() {
+	select {
+	case waiter.ready <- struct{}{}:
+	default:
+	}
+}
+
+// removeChannelWaiter takes the waiter out of its queue and lets the next one try at once, the caller must hold
+// channelQueueLock
+func removeChannelWaiter(waiter *channelWaiter) {
+	queue := channelQueues[waiter.scope]
+	for i, queued := range queue {
+		if queued == waiter {
+			queue = append(queue[:i:i], queue[i+1:]...)
+			break
+		}
+	}
+	if len(queue) == 0 {
+		delete(channelQueues, waiter.scope)
+	} else {
+		channelQueues[waiter.scope] = queue
+		queue[0].signal()
+	}
+	channelQueueWaiting--
+	getChannelQueueStat(waiter.model).Waiting--
+}
+
+// shedChannelWaiter drops the latest of the queued requests of the lowest priority, if it is below the priority,
+// the caller must hold channelQueueLock
+func shedChannelWaiter(priority int) bool {
+	var shed *channelWaiter
+	for _, queue := range channelQueues {
+		last := queue[len(queue)-1]
+		if last.priority < priority && (shed == nil || last.priority < shed.priority ||
+			(last.priority == shed.priority && last.enqueuedAt.After(shed.enqueuedAt))) {
+			shed = last
+		}
+	}
+	if shed == nil {
+		return false
+	}
+	removeChannelWaiter(shed)
+	shed.shed = true
+	shed.signal()
+	getChannelQueueStat(shed.model).Shed++
+	return true
+}
+
+// enqueueChannelWaiter queues the request after the ones of the same or a higher priority, when the queues are full a
+// request of a lower priority is shed to make room, or else the request is refused
+func enqueueChannelWaiter(scope string, model string, priority int) (*channelWaiter, error) {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	stat := getChannelQueueStat(model)
+	if channelQueueWaiting >= common.ChannelQueueDepth && !shedChannelWaiter(priority) {
+		stat.Rejected++
+		return nil, ErrChannelQueueFull
+	}
+	waiter := &channelWaiter{scope: scope, model: model, priority: priority, enqueuedAt: time.Now(), ready: make(chan struct{}, 1)}
+	queue := channelQueues[scope]
+	i := len(queue)
+	for i > 0 && queue[i-1].priority < priority {
+		i--
+	}
+	channelQueues[scope] = append(queue[:i:i], append([]*channelWaiter{waiter}, queue[i:]...)...)
+	channelQueueWaiting++
+	stat.Waiting++
+	stat.Enqueued++
+	return waiter, nil
+}
+
+// state tells whether the waiter is at the head of its queue and whether it was shed
+func (waiter *channelWaiter) state() (head bool, shed bool) {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	queue := channelQueues[waiter.scope]
+	return len(queue) > 0 && queue[0] == waiter, waiter.shed
+}
+
+// dequeue removes the waiter from its queue with the result of its wait
+func (waiter *channelWaiter) dequeue(err error) {
+	channelQueueLock.Lock()
+	defer channelQueueLock.Unlock()
+	// a waiter shed while it was picking a channel is out of the queue already
+	if !waiter.shed {
+		removeChannelWaiter(waiter)
+	}
+	stat := getChannelQueueStat(waiter.model)
+	if err == nil {
+		stat.Released++
+		stat.WaitTime += time.Since(waiter.enqueuedAt).Seconds()
+	} else if errors.Is(err, ErrChannelsThrottled) {
+		stat.TimedOut++
+	}
+}
+
+// waitChannel queues the request until it gets to the head of the queue of its scope and gets a channel from pick,
+// it gives up after ChannelRateLimitWaitTime, when it is shed or when the request is done
+func waitChannel(ctx context.Context, scope string, model string, priority int, pick func() (*Channel, error)) (*Channel, error) {
+	if common.ChannelRateLimitWaitTime <= 0 {
+		return nil, ErrChannelsThrottled
+	}
+	waiter, err := enqueueChannelWaiter(scope, model, priority)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(time.Duration(common.ChannelRateLimitWaitTime) * time.Second)
+	for {
+		head, shed := waiter.state()
+		if shed {
+			return nil, ErrChannelQueueFull
+		}
+		if head {
+			channel, err := pick()
+			if !errors.Is(err, ErrChannelsThrottled) {
+				waiter.dequeue(err)
+				return channel, err
+			}
+		}
+		if time.Now().After(deadline) {
+			waiter.dequeue(ErrChannelsThrottled)
+			return nil, ErrChannelsThrottled
+		}
+		select {
+		case <-waiter.ready:
+		case <-time.After(channelRateLimitPollInterval):
+		case <-ctx.Done():
+			// the client is gone, the requests behind it should not wait for it
+			waiter.dequeue(ctx.Err())
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// GetChannelQueueStats returns the queues of the models sorted by model
+func GetChannelQueueStats() []ChannelQueueStat {
+	channelQueueLock.Lock()
+	stats := make([]ChannelQueueStat, 0, len(channelQueueStats))
+	for _, stat := range channelQueueStats {
+		stats = append(stats, *stat)
+	}
+	channelQueueLock.Unlock()
+	sort.Slice(stats, func(i, j int) bool {
+		return stats[i].Model < stats[j].Model
+	})
+	return stats
+}
@@ -0,0 +1,188 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+	"sync"
+	"time"
+)
+
+const channelRateLimitWindow = 60 * 1000 // in milliseconds
+const channelRateLimitPollInterval = 100 * time.Millisecond
+
+// ErrChannelsThrottled is returned when the channels of the model are all at their rate limits
+var ErrChannelsThrottled = errors.New("all channels are rate limited")
+
+type ChannelRateLimit struct {
+	RPM int `json:"rpm"`
+	TPM int `json:"tpm"`
+}
+
+type channelTokenUsage struct {
+	time   int64 // in milliseconds
+	tokens int
+}
+
+// channelRateLimitUsage is the usage of the last minute, keyed by an empty model for the usage of the whole channel
+type channelRateLimitUsage struct {
+	requests []int64 // in milliseconds
+	tokens   []channelTokenUsage
+}
+
+// the rate limits are counted by each node
+var channelRateLimitLock sync.Mutex
+var channelRateLimitUsages = make(map[channelLatencyKey]*channelRateLimitUsage)
+
+func IsValidModelRateLimits(jsonStr string) bool {
+	if jsonStr == "" {
+		return true
+	}
+	limits := make(map[string]*ChannelRateLimit)
+	if json.Unmarshal([]byte(jsonStr), &limits) != nil {
+		return false
+	}
+	for _, limit := range limits {
+		if limit == nil || limit.RPM < 0 || limit.TPM < 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (channel *Channel) GetRPM() int {
+	if channel.RPM == nil {
+		return 0
+	}
+	return *channel.RPM
+}
+
+func (channel *Channel) GetTPM() int {
+	if channel.TPM == nil {
+		return 0
+	}
+	return *channel.TPM
+}
+
+// getRateLimits are the limits of the whole channel and the ones of the model
+func (channel *Channel) getRateLimits(model string) map[string]*ChannelRateLimit {
+	limits := make(map[string]*ChannelRateLimit)
+	if channel.GetRPM() > 0 || channel.GetTPM() > 0 {
+		limits[""] = &ChannelRateLimit{RPM: channel.GetRPM(), TPM: channel.GetTPM()}
+	}
+	if model == "" || channel.ModelRateLimits == "" {
+		return limits
+	}
+	modelRateLimits := make(map[string]*ChannelRateLimit)
+	err := json.Unmarshal([]byte(channel.ModelRateLimits), &modelRateLimits)
+	if err != nil {
+		common.SysError("failed to unmarshal model rate limits: " + err.Error())
+		return limits
+	}
+	if limit, ok := modelRateLimits[model]; ok && limit != nil && (limit.RPM > 0 || limit.TPM > 0) {
+		limits[model] = limit
+	}
+	return limits
+}
+
+// prune drops the usage older than a minute, the caller must hold channelRateLimitLock
+func (usage *channelRateLimitUsage) prune(now int64) {
+	i := 0
+	for i < len(usage.requests) && now-usage.requests[i] >= channelRateLimitWindow {
+		i++
+	}
+	usage.requests = usage.requests[i:]
+	i = 0
+	for i < len(usage.tokens) && now-usage.tokens[i].time >= channelRateLimitWindow {
+		i++
+	}
+	usage.tokens = usage.tokens[i:]
+}
+
+func (usage *channelRateLimitUsage) isWithin(limit *ChannelRateLimit) bool {
+	if limit.RPM > 0 && len(usage.requests) >= limit.RPM {
+		return false
+	}
+	if limit.TPM > 0 {
+		tokens := 0
+		for _, item := range usage.tokens {
+			tokens += item.tokens
+		}
+		if tokens >= limit.TPM {
+			return false
+		}
+	}
+	return true
+}
+
+func getChannelRateLimitUsage(key channelLatencyKey, now int64) *channelRateLimitUsage {
+	usage, ok := channelRateLimitUsages[key]
+	if !ok {
+		usage = &channelRateLimitUsage{}
+		channelRateLimitUsages[key] = usage
+	}
+	usage.prune(now)
+	return usage
+}
+
+// isChannelThrottled tells whether the channel would go beyond one of its limits with another request of the model
+func isChannelThrottled(channel *Channel, model string) bool {
+	limits := channel.getRateLimits(model)
+	if len(limits) == 0 {
+		return false
+	}
+	now := time.Now().UnixMilli()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	for name, limit := range limits {
+		if !getChannelRateLimitUsage(channelLatencyKey{channel.Id, name}, now).isWithin(limit) {
+			return true
+		}
+	}
+	return false
+}
+
+// AcquireChannelRateLimit counts a request of the model on the channel if it is within all the limits of the channel
+func AcquireChannelRateLimit(channel *Channel, model string) bool {
+	limits := channel.getRateLimits(model)
+	if len(limits) == 0 {
+		return true
+	}
+	now := time.Now().UnixMilli()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	usages := make([]*channelRateLimitUsage, 0, len(limits))
+	for name, limit := range limits {
+		usage := getChannelRateLimitUsage(channelLatencyKey{channel.Id, name}, now)
+		if !usage.isWithin(limit) {
+			return false
+		}
+		usages = append(usages, usage)
+	}
+	for _, usage := range usages {
+		usage.requests = append(usage.requests, now)
+	}
+	return true
+}
+
+// RecordChannelTokens counts the tokens used by a request of the model for the TPM limits of the channel
+func RecordChannelTokens(channelId int, model string, tokens int) {
+	if tokens <= 0 {
+		return
+	}
+	now := time.Now().UnixMilli()
+	channelRateLimitLock.Lock()
+	defer channelRateLimitLock.Unlock()
+	names := []string{""}
+	if model != "" {
+		names = append(names, model)
+	}
+	for _, name := range names {
+		key := channelLatencyKey{channelId, name}
+		// only the channels with a limit are counted
+		if usage, ok := channelRateLimitUsages[key]; ok {
+			usage.prune(now)
+			usage.tokens = append(usage.tokens, channelTokenUsage{time: now, tokens: tokens})
+		}
+	}
+}
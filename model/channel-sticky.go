@@ -0,0 +1,27 @@
+package model
+
+import (
+	"hash/fnv"
+	"math"
+	"strconv"
+)
+
+// getStickyChannel picks the channel the sticky key hashes to with a weighted rendezvous hash, so that the requests of
+// a conversation keep going to the same channel, the shares of the channels follow their weights and only the
+// conversations of a channel which goes away move to the others
+func getStickyChannel(channels []*Channel, stickyKey string) *Channel {
+	var selected *Channel
+	bestScore := math.Inf(-1)
+	for _, channel := range channels {
+		hash := fnv.New64a()
+		_, _ = hash.Write([]byte(stickyKey + ":" + strconv.Itoa(channel.Id)))
+		// a uniform value in (0, 1)
+		value := (float64(hash.Sum64()>>11) + 0.5) / (1 << 53)
+		score := -float64(channel.GetWeight()) / math.Log(value)
+		if selected == nil || score > bestScore {
+			selected = channel
+			bestScore = score
+		}
+	}
+	return selected
+}
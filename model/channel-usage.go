@@ -0,0 +1,106 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// ChannelUsage is the daily usage of a channel on a model, what the users were billed and what it is estimated to
+// cost upstream, kept for the margin report
+type ChannelUsage struct {
+	Id               int     `json:"id"`
+	ChannelId        int     `json:"channel_id" gorm:"uniqueIndex:idx_channel_usage"`
+	ModelName        string  `json:"model_name" gorm:"type:varchar(64);uniqueIndex:idx_channel_usage"`
+	Day              string  `json:"day" gorm:"type:varchar(10);uniqueIndex:idx_channel_usage;index"` // 2006-01-02, local time
+	Requests         int     `json:"requests" gorm:"default:0"`
+	PromptTokens     int64   `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64   `json:"completion_tokens" gorm:"bigint;default:0"`
+	Quota            int64   `json:"quota" gorm:"bigint;default:0"` // billed to the users
+	Cost             float64 `json:"cost" gorm:"default:0"`         // in USD, at the upstream prices
+	UnpricedRequests int     `json:"unpriced_requests" gorm:"default:0"`
+}
+
+type channelUsageKey struct {
+	channelId int
+	model     string
+	day       string
+}
+
+var channelUsageLock sync.Mutex
+var pendingChannelUsages = make(map[channelUsageKey]*ChannelUsage)
+
+// RecordChannelUsage only accumulates in memory, SyncChannelUsages writes it to the database periodically,
+// the cost of the unpriced requests is not estimated
+func RecordChannelUsage(channelId int, modelName string, promptTokens int, completionTokens int, quota int, cost float64, priced bool) {
+	key := channelUsageKey{channelId, modelName, time.Now().Format("2006-01-02")}
+	channelUsageLock.Lock()
+	defer channelUsageLock.Unlock()
+	usage, ok := pendingChannelUsages[key]
+	if !ok {
+		usage = &ChannelUsage{ChannelId: key.channelId, ModelName: key.model, Day: key.day}
+		pendingChannelUsages[key] = usage
+	}
+	usage.Requests++
+	usage.PromptTokens += int64(promptTokens)
+	usage.CompletionTokens += int64(completionTokens)
+	usage.Quota += int64(quota)
+	usage.Cost += cost
+	if !priced {
+		usage.UnpricedRequests++
+	}
+}
+
+func flushChannelUsage(usage *ChannelUsage) error {
+	updates := map[string]interface{}{
+		"requests":          gorm.Expr("requests + ?", usage.Requests),
+		"prompt_tokens":     gorm.Expr("prompt_tokens + ?", usage.PromptTokens),
+		"completion_tokens": gorm.Expr("completion_tokens + ?", usage.CompletionTokens),
+		"quota":             gorm.Expr("quota + ?", usage.Quota),
+		"cost":              gorm.Expr("cost + ?", usage.Cost),
+		"unpriced_requests": gorm.Expr("unpriced_requests + ?", usage.UnpricedRequests),
+	}
+	query := DB.Model(&ChannelUsage{}).Where("channel_id = ? and model_name = ? and day = ?", usage.ChannelId, usage.ModelName, usage.Day)
+	result := query.Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(usage).Error
+	if err != nil {
+		// another node may have created the row in the meantime
+		return DB.Model(&ChannelUsage{}).Where("channel_id = ? and model_name = ? and day = ?", usage.ChannelId, usage.ModelName, usage.Day).Updates(updates).Error
+	}
+	return nil
+}
+
+func FlushChannelUsages() {
+	channelUsageLock.Lock()
+	pending := pendingChannelUsages
+	pendingChannelUsages = make(map[channelUsageKey]*ChannelUsage)
+	channelUsageLock.Unlock()
+	for _, usage := range pending {
+		err := flushChannelUsage(usage)
+		if err != nil {
+			common.SysError("failed to flush channel usage: " + err.Error())
+		}
+	}
+}
+
+func SyncChannelUsages(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushChannelUsages()
+	}
+}
+
+// GetChannelUsages sums up the daily usages from the start day to the end day included, by channel and model
+func GetChannelUsages(startDay string, endDay string) (usages []*ChannelUsage, err error) {
+	err = DB.Model(&ChannelUsage{}).Select(
+		"channel_id, model_name, sum(requests) as requests, sum(prompt_tokens) as prompt_tokens, "+
+			"sum(completion_tokens) as completion_tokens, sum(quota) as quota, sum(cost) as cost, "+
+			"sum(unpriced_requests) as unpriced_requests",
+	).Where("day >= ? and day <= ?", startDay, endDay).Group("channel_id, model_name").Order("channel_id, model_name").Scan(&usages).Error
+	return usages, err
+}
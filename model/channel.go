@@ -6,23 +6,55 @@ import (
 )
 
 type Channel struct {
-	Id                 int     `json:"id"`
-	Type               int     `json:"type" gorm:"default:0"`
-	Key                string  `json:"key" gorm:"not null;index"`
-	Status             int     `json:"status" gorm:"default:1"`
-	Name               string  `json:"name" gorm:"index"`
-	Weight             int     `json:"weight"`
-	CreatedTime        int64   `json:"created_time" gorm:"bigint"`
-	TestTime           int64   `json:"test_time" gorm:"bigint"`
-	ResponseTime       int     `json:"response_time"` // in milliseconds
-	BaseURL            string  `json:"base_url" gorm:"column:base_url"`
-	Other              string  `json:"other"`
-	Balance            float64 `json:"balance"` // in USD
-	BalanceUpdatedTime int64   `json:"balance_updated_time" gorm:"bigint"`
-	Models             string  `json:"models"`
-	Group              string  `json:"group" gorm:"type:varchar(32);default:'default'"`
-	UsedQuota          int64   `json:"used_quota" gorm:"bigint;default:0"`
-	ModelMapping       string  `json:"model_mapping" gorm:"type:varchar(1024);default:''"`
+	Id                 int           `json:"id"`
+	Type               int           `json:"type" gorm:"default:0"`
+	Key                string        `json:"key" gorm:"not null;index"`
+	Status             int           `json:"status" gorm:"default:1"`
+	Name               string        `json:"name" gorm:"index"`
+	Weight             int           `json:"weight"`                           // the share of the traffic among the channels which can serve a request, 0 counts as 1
+	Priority           *int64        `json:"priority" gorm:"bigint;default:0"` // the channels of a lower priority only serve when the higher ones fail or are disabled
+	CreatedTime        int64         `json:"created_time" gorm:"bigint"`
+	TestTime           int64         `json:"test_time" gorm:"bigint"`
+	ResponseTime       int           `json:"response_time"` // in milliseconds
+	BaseURL            string        `json:"base_url" gorm:"column:base_url"`
+	Other              string        `json:"other"`
+	Balance            float64       `json:"balance"` // in USD
+	BalanceUpdatedTime int64         `json:"balance_updated_time" gorm:"bigint"`
+	Models             string        `json:"models"`
+	Group              string        `json:"group" gorm:"type:varchar(32);default:'default'"`
+	UsedQuota          int64         `json:"used_quota" gorm:"bigint;default:0"`
+	ModelMapping       string        `json:"model_mapping" gorm:"type:varchar(1024);default:''"`
+	Region             string        `json:"region" gorm:"type:varchar(32);default:''"`       // where the upstream processes the data, such as eu
+	KeyStrategy        string        `json:"key_strategy" gorm:"type:varchar(32);default:''"` // how the keys take turns, empty means a single key
+	RPM                *int          `json:"rpm" gorm:"column:rpm;default:0"`                 // requests per minute sent to the upstream, 0 means unlimited
+	TPM                *int          `json:"tpm" gorm:"column:tpm;default:0"`                 // tokens per minute used on the upstream, 0 means unlimited
+	ModelRateLimits    string        `json:"model_rate_limits" gorm:"type:text"`              // the limits of each model in JSON, such as {"gpt-4": {"rpm": 100, "tpm": 40000}}
+	DailyBudget        *int          `json:"daily_budget" gorm:"default:0"`                   // the quota the channel may use in a day at the list price, 0 means unlimited
+	MonthlyBudget      *int          `json:"monthly_budget" gorm:"default:0"`                 // the same for a calendar month
+	Proxy              string        `json:"proxy" gorm:"type:varchar(255);default:''"`       // all the upstream calls of the channel go through it, such as socks5://127.0.0.1:1080
+	MaintenanceWindows string        `json:"maintenance_windows" gorm:"type:text"`            // the windows in JSON during which the channel is paused, such as [{"cron":"0 2 * * 6","duration":120}]
+	ShadowChannelId    *int          `json:"shadow_channel_id" gorm:"default:0"`              // the channel a copy of the requests is mirrored to, 0 means none
+	ShadowRate         *int          `json:"shadow_rate" gorm:"default:0"`                    // the percentage of the requests mirrored
+	AutoSyncModels     *bool         `json:"auto_sync_models" gorm:"default:false"`           // the models follow the list of the upstream on every model sync
+	Headers            string        `json:"headers" gorm:"type:text"`                        // the extra headers sent upstream in JSON, such as {"OpenAI-Organization": "org-xxx"}
+	StripHeaders       string        `json:"strip_headers" gorm:"type:text"`                  // the upstream response headers not passed to the clients, comma separated
+	TestPayload        string        `json:"test_payload" gorm:"type:text"`                   // the chat completions request in JSON the tests send, empty means a single token of gpt-3.5-turbo
+	TimeoutPolicy      string        `json:"timeout_policy" gorm:"type:text"`                 // the timeouts and retries in JSON, such as {"connect_timeout": 5, "timeout": 300, "models": {"o1": {"timeout": 900}}}
+	AzureDeployments   string        `json:"azure_deployments" gorm:"type:text"`              // the deployments of the models on Azure in JSON, such as {"gpt-4o": {"deployment": "prod-4o", "api_version": "2024-06-01"}}
+	SystemPrompt       string        `json:"system_prompt" gorm:"type:text"`                  // injected into the chat requests it serves
+	SystemPromptMode   string        `json:"system_prompt_mode" gorm:"type:varchar(16);default:''"`
+	KeyUsages          []*ChannelKey `json:"key_usages,omitempty" gorm:"-"`
+	DailySpend         int64         `json:"daily_spend,omitempty" gorm:"-"` // the quota used today at the list price, up to a minute behind
+	MonthlySpend       int64         `json:"monthly_spend,omitempty" gorm:"-"`
+}
+
+const (
+	SystemPromptModePrepend  = "prepend"  // before the system prompts of the request, the default
+	SystemPromptModeOverride = "override" // in place of the system prompts of the request, so that the clients cannot change it
+)
+
+func IsValidSystemPromptMode(mode string) bool {
+	return mode == "" || mode == SystemPromptModePrepend || mode == SystemPromptModeOverride
 }
 
 func GetAllChannels(startIdx int, num int, selectAll bool) ([]*Channel, error) {
@@ -90,6 +122,14 @@ func (channel *Channel) Insert() error {
 
 func (channel *Channel) Update() error {
 	var err error
+	if channel.Status == common.ChannelStatusEnabled {
+		var oldStatus int
+		DB.Model(&Channel{}).Where("id = ?", channel.Id).Select("status").Scan(&oldStatus)
+		if oldStatus != common.ChannelStatusEnabled {
+			EnableChannelKeys(channel.Id)
+			ResetChannelCircuit(channel.Id)
+		}
+	}
 	err = DB.Model(channel).Updates(channel).Error
 	if err != nil {
 		return err
@@ -99,6 +139,47 @@ func (channel *Channel) Update() error {
 	return err
 }
 
+func (channel *Channel) UpdateModels(models string) error {
+	err := DB.Model(channel).Update("models", models).Error
+	if err != nil {
+		return err
+	}
+	channel.Models = models
+	return channel.UpdateAbilities()
+}
+
+func (channel *Channel) IsAutoSyncModels() bool {
+	return channel.AutoSyncModels != nil && *channel.AutoSyncModels
+}
+
+func (channel *Channel) GetShadowChannelId() int {
+	if channel.ShadowChannelId == nil {
+		return 0
+	}
+	return *channel.ShadowChannelId
+}
+
+func (channel *Channel) GetShadowRate() int {
+	if channel.ShadowRate == nil {
+		return 0
+	}
+	return *channel.ShadowRate
+}
+
+func (channel *Channel) GetWeight() int {
+	if channel.Weight <= 0 {
+		return 1
+	}
+	return channel.Weight
+}
+
+func (channel *Channel) GetPriority() int64 {
+	if channel.Priority == nil {
+		return 0
+	}
+	return *channel.Priority
+}
+
 func (channel *Channel) UpdateResponseTime(responseTime int64) {
 	err := DB.Model(channel).Select("response_time", "test_time").Updates(Channel{
 		TestTime:     common.GetTimestamp(),
@@ -126,6 +207,18 @@ func (channel *Channel) Delete() error {
 		return err
 	}
 	err = channel.DeleteAbilities()
+	if err != nil {
+		return err
+	}
+	err = deleteChannelKeys(channel.Id)
+	if err != nil {
+		return err
+	}
+	err = deleteChannelProbes(channel.Id)
+	if err != nil {
+		return err
+	}
+	err = deleteChannelSpends(channel.Id)
 	return err
 }
 
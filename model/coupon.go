@@ -0,0 +1,347 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Coupon is a percentage discount on the quota of the requests, unlike the redemption codes it doesn't add any quota,
+// it is attached to a user by claiming its code or by an admin, and each user gets their own usage caps
+type Coupon struct {
+	Id           int    `json:"id"`
+	UserId       int    `json:"user_id"`
+	Code         string `json:"code" gorm:"type:char(32);uniqueIndex"`
+	Name         string `json:"name" gorm:"index"`
+	Status       int    `json:"status" gorm:"default:1"`
+	Discount     int    `json:"discount"`                           // the percentage taken off the quota, 1 to 100
+	Models       string `json:"models"`                             // the comma separated models it applies to, empty means all
+	StartTime    int64  `json:"start_time" gorm:"bigint;default:0"` // it can't be claimed or used before
+	EndTime      int64  `json:"end_time" gorm:"bigint;default:-1"`  // -1 means never expired
+	ValidDays    int    `json:"valid_days" gorm:"default:0"`        // the window after it is attached to a user, 0 means until the end time
+	MaxClaims    int    `json:"max_claims" gorm:"default:1"`        // how many users can claim the code
+	ClaimedCount int    `json:"claimed_count" gorm:"default:0"`
+	MaxUses      int    `json:"max_uses" gorm:"default:0"`     // how many requests of each user it discounts, 0 means no limit
+	MaxDiscount  int    `json:"max_discount" gorm:"default:0"` // how much quota each user can save, 0 means no limit
+	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
+}
+
+// UserCoupon is a coupon attached to a user, the terms are copied so that editing the coupon doesn't change the attached ones
+type UserCoupon struct {
+	Id              int    `json:"id"`
+	CouponId        int    `json:"coupon_id" gorm:"uniqueIndex:idx_user_coupon"`
+	UserId          int    `json:"user_id" gorm:"uniqueIndex:idx_user_coupon;index"`
+	Name            string `json:"name"`
+	Status          int    `json:"status" gorm:"default:1"`
+	Discount        int    `json:"discount"`
+	Models          string `json:"models"`
+	StartTime       int64  `json:"start_time" gorm:"bigint"`
+	EndTime         int64  `json:"end_time" gorm:"bigint"` // -1 means never expired
+	MaxUses         int    `json:"max_uses"`
+	UsedCount       int    `json:"used_count" gorm:"default:0"`
+	MaxDiscount     int    `json:"max_discount"`
+	DiscountedQuota int    `json:"discounted_quota" gorm:"default:0"`
+	CreatedTime     int64  `json:"created_time" gorm:"bigint"`
+}
+
+// CouponLedger records each discount given by a coupon
+type CouponLedger struct {
+	Id           int    `json:"id"`
+	UserCouponId int    `json:"user_coupon_id" gorm:"index"`
+	UserId       int    `json:"user_id" gorm:"index"`
+	ModelName    string `json:"model_name"`
+	Quota        int    `json:"quota"`    // the quota before the discount
+	Discount     int    `json:"discount"` // the quota taken off
+	CreatedTime  int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+var userCouponCache = common.NewLRUCache(common.TokenLocalCacheSize, time.Minute)
+
+func getUserCouponCacheKey(userId int) string {
+	return "coupon:" + strconv.Itoa(userId)
+}
+
+func GetAllCoupons(startIdx int, num int) (coupons []*Coupon, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&coupons).Error
+	return coupons, err
+}
+
+func SearchCoupons(keyword string) (coupons []*Coupon, err error) {
+	err = DB.Where("id = ? or name LIKE ? or code = ?", keyword, keyword+"%", keyword).Find(&coupons).Error
+	return coupons, err
+}
+
+func GetCouponById(id int) (*Coupon, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	coupon := Coupon{Id: id}
+	err := DB.First(&coupon, "id = ?", id).Error
+	return &coupon, err
+}
+
+func (coupon *Coupon) Insert() error {
+	return DB.Create(coupon).Error
+}
+
+// Update disables the attached coupons as well when the coupon is disabled
+func (coupon *Coupon) Update() error {
+	err := DB.Model(coupon).Select("name", "status", "discount", "models", "start_time", "end_time", "valid_days", "max_claims", "max_uses", "max_discount").Updates(coupon).Error
+	if err != nil {
+		return err
+	}
+	if coupon.Status == common.CouponStatusDisabled {
+		err = DB.Model(&UserCoupon{}).Where("coupon_id = ? and status = ?", coupon.Id, common.CouponStatusEnabled).Update("status", common.CouponStatusDisabled).Error
+	}
+	return err
+}
+
+func DeleteCouponById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	coupon := Coupon{Id: id}
+	err := DB.Where(coupon).First(&coupon).Error
+	if err != nil {
+		return err
+	}
+	return DB.Delete(&coupon).Error
+}
+
+// attachCoupon gives the coupon to the user, the claims of the code are only limited when the user claims it
+func attachCoupon(selector string, value any, userId int, byUser bool) (*UserCoupon, error) {
+	if userId == 0 {
+		return nil, errors.New("无效的 user id")
+	}
+	coupon := &Coupon{}
+	userCoupon := &UserCoupon{}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where(selector, value).First(coupon).Error
+		if err != nil {
+			return errors.New("无效的优惠券")
+		}
+		now := common.GetTimestamp()
+		if coupon.Status != common.CouponStatusEnabled {
+			return errors.New("该优惠券已被禁用")
+		}
+		if coupon.StartTime > now {
+			return errors.New("该优惠券尚未开始")
+		}
+		if coupon.EndTime != -1 && coupon.EndTime < now {
+			return errors.New("该优惠券已过期")
+		}
+		if byUser && coupon.ClaimedCount >= coupon.MaxClaims {
+			return errors.New("该优惠券已被领完")
+		}
+		var attached int64
+		tx.Model(&UserCoupon{}).Where("coupon_id = ? and user_id = ?", coupon.Id, userId).Count(&attached)
+		if attached > 0 {
+			return errors.New("已领取过该优惠券")
+		}
+		endTime := coupon.EndTime
+		if coupon.ValidDays > 0 {
+			validEndTime := now + int64(coupon.ValidDays)*24*60*60
+			if endTime == -1 || validEndTime < endTime {
+				endTime = validEndTime
+			}
+		}
+		*userCoupon = UserCoupon{
+			CouponId:    coupon.Id,
+			UserId:      userId,
+			Name:        coupon.Name,
+			Status:      common.CouponStatusEnabled,
+			Discount:    coupon.Discount,
+			Models:      coupon.Models,
+			StartTime:   now,
+			EndTime:     endTime,
+			MaxUses:     coupon.MaxUses,
+			MaxDiscount: coupon.MaxDiscount,
+			CreatedTime: now,
+		}
+		// the condition keeps the concurrent claims within the limit
+		claim := tx.Model(&Coupon{}).Where("id = ?", coupon.Id)
+		if byUser {
+			claim = claim.Where("claimed_count < max_claims")
+		}
+		result := claim.Update("claimed_count", gorm.Expr("claimed_count + 1"))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该优惠券已被领完")
+		}
+		return tx.Create(userCoupon).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	userCouponCache.Delete(getUserCouponCacheKey(userId))
+	RecordLog(userId, LogTypeSystem, fmt.Sprintf("获得优惠券 %s，请求额度减免 %d%%", coupon.Name, coupon.Discount))
+	return userCoupon, nil
+}
+
+func ClaimCoupon(code string, userId int) (*UserCoupon, error) {
+	if code == "" {
+		return nil, errors.New("未提供优惠券码")
+	}
+	return attachCoupon("code = ?", code, userId, true)
+}
+
+func AssignCoupon(couponId int, userId int) (*UserCoupon, error) {
+	return attachCoupon("id = ?", couponId, userId, false)
+}
+
+func GetUserCoupons(userId int) (userCoupons []*UserCoupon, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&userCoupons).Error
+	return userCoupons, err
+}
+
+func GetCouponLedgers(userId int, userCouponId int, startIdx int, num int) (ledgers []*CouponLedger, err error) {
+	tx := DB.Order("id desc")
+	if userId != 0 {
+		tx = tx.Where("user_id = ?", userId)
+	}
+	if userCouponId != 0 {
+		tx = tx.Where("user_coupon_id = ?", userCouponId)
+	}
+	err = tx.Limit(num).Offset(startIdx).Find(&ledgers).Error
+	return ledgers, err
+}
+
+func (userCoupon *UserCoupon) isUsable(modelName string, now int64) bool {
+	if userCoupon.Status != common.CouponStatusEnabled || userCoupon.StartTime > now ||
+		(userCoupon.EndTime != -1 && userCoupon.EndTime < now) {
+		return false
+	}
+	if userCoupon.Models == "" {
+		return true
+	}
+	for _, name := range strings.Split(userCoupon.Models, ",") {
+		if strings.TrimSpace(name) == modelName {
+			return true
+		}
+	}
+	return false
+}
+
+// getUsableUserCoupons is cached, so that the users without coupons don't query the database for every request,
+// the terms are checked again when the coupon is used
+func getUsableUserCoupons(userId int) []UserCoupon {
+	key := getUserCouponCacheKey(userId)
+	if value, ok := userCouponCache.Get(key); ok {
+		return value.([]UserCoupon)
+	}
+	var userCoupons []UserCoupon
+	err := DB.Where("user_id = ? and status = ?", userId, common.CouponStatusEnabled).Find(&userCoupons).Error
+	if err != nil {
+		common.SysError("failed to get user coupons: " + err.Error())
+		return nil
+	}
+	// the biggest discount is used first
+	sort.Slice(userCoupons, func(i, j int) bool {
+		return userCoupons[i].Discount > userCoupons[j].Discount
+	})
+	userCouponCache.Set(key, userCoupons)
+	return userCoupons
+}
+
+// useUserCoupon applies the coupon once, the update only succeeds if the coupon is unchanged since it was read, so
+// that the concurrent requests stay within the uses and the discount of the coupon, it retries a few times if not
+func useUserCoupon(userCouponId int, modelName string, quota int) (int, error) {
+	for attempt := 0; attempt < 3; attempt++ {
+		discount, applied, err := tryUseUserCoupon(userCouponId, modelName, quota)
+		if err != nil || applied {
+			return discount, err
+		}
+	}
+	return 0, nil
+}
+
+func tryUseUserCoupon(userCouponId int, modelName string, quota int) (discount int, applied bool, err error) {
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		userCoupon := &UserCoupon{}
+		err := tx.First(userCoupon, "id = ?", userCouponId).Error
+		if err != nil {
+			return err
+		}
+		if !userCoupon.isUsable(modelName, common.GetTimestamp()) ||
+			(userCoupon.MaxUses > 0 && userCoupon.UsedCount >= userCoupon.MaxUses) {
+			applied = true
+			return nil
+		}
+		discount = quota * userCoupon.Discount / 100
+		if userCoupon.MaxDiscount > 0 && userCoupon.DiscountedQuota+discount > userCoupon.MaxDiscount {
+			discount = userCoupon.MaxDiscount - userCoupon.DiscountedQuota
+		}
+		if discount <= 0 {
+			discount = 0
+			applied = true
+			return nil
+		}
+		status := userCoupon.Status
+		if (userCoupon.MaxUses > 0 && userCoupon.UsedCount+1 >= userCoupon.MaxUses) ||
+			(userCoupon.MaxDiscount > 0 && userCoupon.DiscountedQuota+discount >= userCoupon.MaxDiscount) {
+			status = common.CouponStatusExhausted
+		}
+		result := tx.Model(&UserCoupon{}).
+			Where("id = ? and status = ? and used_count = ? and discounted_quota = ?",
+				userCoupon.Id, common.CouponStatusEnabled, userCoupon.UsedCount, userCoupon.DiscountedQuota).
+			Updates(map[string]interface{}{
+				"used_count":       gorm.Expr("used_count + 1"),
+				"discounted_quota": gorm.Expr("discounted_quota + ?", discount),
+				"status":           status,
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			// used by another request meanwhile
+			discount = 0
+			return nil
+		}
+		applied = true
+		return tx.Create(&CouponLedger{
+			UserCouponId: userCoupon.Id,
+			UserId:       userCoupon.UserId,
+			ModelName:    modelName,
+			Quota:        quota,
+			Discount:     discount,
+			CreatedTime:  common.GetTimestamp(),
+		}).Error
+	})
+	if err != nil {
+		return 0, false, err
+	}
+	return discount, applied, nil
+}
+
+// UseUserCoupon takes the discount of the best usable coupon of the user off the quota of a request,
+// it returns the quota taken off and the name of the coupon
+func UseUserCoupon(userId int, modelName string, quota int) (int, string) {
+	if quota <= 0 {
+		return 0, ""
+	}
+	now := common.GetTimestamp()
+	for _, userCoupon := range getUsableUserCoupons(userId) {
+		if !userCoupon.isUsable(modelName, now) {
+			continue
+		}
+		discount, err := useUserCoupon(userCoupon.Id, modelName, quota)
+		if err != nil {
+			common.SysError("failed to use coupon: " + err.Error())
+			continue
+		}
+		if discount > 0 {
+			return discount, userCoupon.Name
+		}
+		// the caps are only counted in the database, the cached copy is refreshed once the coupon is used up
+		userCouponCache.Delete(getUserCouponCacheKey(userId))
+	}
+	return 0, ""
+}
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CreditSourceAdmin      = "admin"
+	CreditSourceRedemption = "redemption"
+	CreditSourceRegister   = "register"
+	CreditSourceInvitation = "invitation"
+)
+
+// CreditBucket is a part of the user quota that expires, the quota itself is still counted in users.quota,
+// the bucket only tracks how much of it is left to be taken away on expiry
+type CreditBucket struct {
+	Id          int    `json:"id"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	Quota       int    `json:"quota"`
+	Remain      int    `json:"remain"`
+	Source      string `json:"source" gorm:"type:varchar(32)"`
+	Status      int    `json:"status" gorm:"default:1"`
+	ExpiredTime int64  `json:"expired_time" gorm:"bigint;index"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func addCreditBucket(tx *gorm.DB, userId int, quota int, days int, source string) error {
+	if quota <= 0 || days <= 0 {
+		return nil
+	}
+	now := common.GetTimestamp()
+	return tx.Create(&CreditBucket{
+		UserId:      userId,
+		Quota:       quota,
+		Remain:      quota,
+		Source:      source,
+		Status:      common.CreditBucketStatusActive,
+		ExpiredTime: now + int64(days)*24*60*60,
+		CreatedTime: now,
+	}).Error
+}
+
+// GrantExpiringCredit adds quota to the user which expires after the given days
+func GrantExpiringCredit(userId int, quota int, days int, source string) error {
+	if quota <= 0 {
+		return errors.New("额度必须大于 0")
+	}
+	if days <= 0 {
+		return errors.New("有效天数必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("用户不存在")
+		}
+		return addCreditBucket(tx, userId, quota, days, source)
+	})
+	if err != nil {
+		return err
+	}
+	_ = CacheUpdateUserQuota(userId)
+	RecordLog(userId, LogTypeTopup, fmt.Sprintf("获得限时额度 %s，%d 天后过期", common.LogQuota(quota), days))
+	return nil
+}
+
+func getActiveCreditQuota(tx *gorm.DB, userId int) (quota int64) {
+	tx.Model(&CreditBucket{}).Where("user_id = ? and status = ? and expired_time > ?", userId, common.CreditBucketStatusActive, common.GetTimestamp()).
+		Select("COALESCE(sum(remain), 0)").Scan(&quota)
+	return quota
+}
+
+// consumeCreditBuckets draws the consumed quota from the buckets expiring first, so that the expiring credits are used before the paid ones
+func consumeCreditBuckets(userId int, quota int) {
+	if quota <= 0 {
+		return
+	}
+	var buckets []*CreditBucket
+	err := DB.Where("user_id = ? and status = ? and remain > 0 and expired_time > ?", userId, common.CreditBucketStatusActive, common.GetTimestamp()).
+		Order("expired_time").Find(&buckets).Error
+	if err != nil {
+		common.SysError("failed to get credit buckets: " + err.Error())
+		return
+	}
+	for _, bucket := range buckets {
+		if quota <= 0 {
+			break
+		}
+		taken := bucket.Remain
+		if taken > quota {
+			taken = quota
+		}
+		// the guard keeps concurrent requests from taking the same credits twice, the rest goes to the next bucket
+		result := DB.Model(&CreditBucket{}).Where("id = ? and remain >= ?", bucket.Id, taken).Update("remain", gorm.Expr("remain - ?", taken))
+		if result.Error != nil {
+			common.SysError("failed to consume credit bucket: " + result.Error.Error())
+			continue
+		}
+		if result.RowsAffected > 0 {
+			quota -= taken
+		}
+	}
+}
+
+func GetUserCreditBuckets(userId int, startIdx int, num int) (buckets []*CreditBucket, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Limit(num).Offset(startIdx).Find(&buckets).Error
+	return buckets, err
+}
+
+// expireCreditBucket takes the unused credits away from the user, returns the quota taken
+func expireCreditBucket(bucketId int) (*CreditBucket, int, error) {
+	bucket := &CreditBucket{}
+	expired := 0
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", bucketId).First(bucket).Error
+		if err != nil {
+			return err
+		}
+		if bucket.Status != common.CreditBucketStatusActive {
+			return nil
+		}
+		user := &User{}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Select("id", "quota").Where("id = ?", bucket.UserId).First(user).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		expired = bucket.Remain
+		// never take away more than the user has, the credits may have been consumed without being tracked
+		if expired > user.Quota {
+			expired = user.Quota
+		}
+		if expired < 0 {
+			expired = 0
+		}
+		// the condition on the remainder keeps a concurrent consumption from being taken away twice, the bucket is
+		// expired by the next sweep then
+		result := tx.Model(&CreditBucket{}).Where("id = ? and status = ? and remain = ?", bucket.Id, common.CreditBucketStatusActive, bucket.Remain).
+			Update("status", common.CreditBucketStatusExpired)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			expired = 0
+			return nil
+		}
+		if expired == 0 {
+			return nil
+		}
+		return tx.Model(&User{}).Where("id = ?", bucket.UserId).Update("quota", gorm.Expr("quota - ?", expired)).Error
+	})
+	return bucket, expired, err
+}
+
+func ExpireCreditBuckets() (int, error) {
+	var bucketIds []int
+	err := DB.Model(&CreditBucket{}).Where("status = ? and expired_time <= ?", common.CreditBucketStatusActive, common.GetTimestamp()).
+		Pluck("id", &bucketIds).Error
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, bucketId := range bucketIds {
+		bucket, expired, err := expireCreditBucket(bucketId)
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to expire credit bucket #%d: %s", bucketId, err.Error()))
+			continue
+		}
+		count++
+		if expired > 0 {
+			_ = CacheUpdateUserQuota(bucket.UserId)
+			RecordLog(bucket.UserId, LogTypeSystem, fmt.Sprintf("限时额度已过期，扣除未使用的 %s", common.LogQuota(expired)))
+		}
+	}
+	return count, nil
+}
+
+// AutomaticallyExpireCredits takes the expired credits away, only on the master node
+func AutomaticallyExpireCredits(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		count, err := ExpireCreditBuckets()
+		if err != nil {
+			common.SysError("failed to expire credits: " + err.Error())
+			continue
+		}
+		if count > 0 {
+			common.SysLog(fmt.Sprintf("%d credit buckets expired", count))
+		}
+	}
+}
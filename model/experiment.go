@@ -0,0 +1,170 @@
+package model
+
+import (
+	"errors"
+	"math/rand"
+	"one-api/common"
+	"sync"
+	"time"
+)
+
+// Experiment splits the traffic of one model between a control channel and a treatment channel
+type Experiment struct {
+	Id                 int    `json:"id"`
+	Name               string `json:"name" gorm:"index"`
+	Model              string `json:"model" gorm:"index"`
+	ControlChannelId   int    `json:"control_channel_id"`
+	TreatmentChannelId int    `json:"treatment_channel_id"`
+	Percentage         int    `json:"percentage"` // share of requests sent to the treatment arm, 0 - 100
+	JudgeModel         string `json:"judge_model" gorm:"default:''"`
+	Status             int    `json:"status" gorm:"default:1"`
+	CreatedTime        int64  `json:"created_time" gorm:"bigint"`
+}
+
+type ExperimentRecord struct {
+	Id           int     `json:"id"`
+	ExperimentId int     `json:"experiment_id" gorm:"index"`
+	Arm          string  `json:"arm" gorm:"type:varchar(16);index"`
+	ChannelId    int     `json:"channel_id"`
+	Latency      int64   `json:"latency"` // in milliseconds
+	Quota        int     `json:"quota" gorm:"default:0"`
+	Success      bool    `json:"success"`
+	Prompt       string  `json:"prompt" gorm:"type:text"`     // only kept when a judge model is configured
+	Completion   string  `json:"completion" gorm:"type:text"` // same as above
+	Score        float64 `json:"score" gorm:"default:-1"`     // -1 means not judged yet
+	CreatedAt    int64   `json:"created_at" gorm:"bigint;index"`
+}
+
+type ExperimentArmStat struct {
+	Arm        string  `json:"arm"`
+	Requests   int     `json:"requests"`
+	Errors     int     `json:"errors"`
+	ErrorRate  float64 `json:"error_rate"`
+	AvgLatency float64 `json:"avg_latency"`
+	P95Latency int64   `json:"p95_latency"`
+	TotalQuota int     `json:"total_quota"`
+	AvgQuota   float64 `json:"avg_quota"`
+	Judged     int     `json:"judged"`
+	AvgScore   float64 `json:"avg_score"`
+}
+
+const (
+	ExperimentArmControl   = "control"
+	ExperimentArmTreatment = "treatment"
+)
+
+var model2experiment map[string]*Experiment
+var experimentSyncLock sync.RWMutex
+
+func InitExperimentCache() {
+	var experiments []*Experiment
+	DB.Where("status = ?", common.ExperimentStatusRunning).Find(&experiments)
+	newModel2experiment := make(map[string]*Experiment)
+	for _, experiment := range experiments {
+		newModel2experiment[experiment.Model] = experiment
+	}
+	experimentSyncLock.Lock()
+	model2experiment = newModel2experiment
+	experimentSyncLock.Unlock()
+}
+
+func SyncExperimentCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitExperimentCache()
+	}
+}
+
+func GetRunningExperiment(model string) *Experiment {
+	experimentSyncLock.RLock()
+	defer experimentSyncLock.RUnlock()
+	return model2experiment[model]
+}
+
+// PickArm decides which arm this request belongs to, and returns the channel id of that arm
+func (experiment *Experiment) PickArm() (string, int) {
+	if rand.Intn(100) < experiment.Percentage {
+		return ExperimentArmTreatment, experiment.TreatmentChannelId
+	}
+	return ExperimentArmControl, experiment.ControlChannelId
+}
+
+func GetAllExperiments(startIdx int, num int) ([]*Experiment, error) {
+	var experiments []*Experiment
+	err := DB.Order("id desc").Limit(num).Offset(startIdx).Find(&experiments).Error
+	return experiments, err
+}
+
+func GetExperimentById(id int) (*Experiment, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	experiment := Experiment{Id: id}
+	err := DB.First(&experiment, "id = ?", id).Error
+	return &experiment, err
+}
+
+func (experiment *Experiment) Insert() error {
+	err := DB.Create(experiment).Error
+	InitExperimentCache()
+	return err
+}
+
+func (experiment *Experiment) Update() error {
+	err := DB.Model(experiment).Select("name", "model", "control_channel_id", "treatment_channel_id", "percentage", "judge_model", "status").Updates(experiment).Error
+	InitExperimentCache()
+	return err
+}
+
+func (experiment *Experiment) Delete() error {
+	err := DB.Delete(experiment).Error
+	if err != nil {
+		return err
+	}
+	err = DB.Where("experiment_id = ?", experiment.Id).Delete(&ExperimentRecord{}).Error
+	InitExperimentCache()
+	return err
+}
+
+func RecordExperimentResult(record *ExperimentRecord) {
+	record.CreatedAt = common.GetTimestamp()
+	record.Score = -1
+	err := DB.Create(record).Error
+	if err != nil {
+		common.SysError("failed to record experiment result: " + err.Error())
+	}
+}
+
+func GetUnjudgedExperimentRecords(experimentId int, num int) (records []*ExperimentRecord, err error) {
+	err = DB.Where("experiment_id = ? and score < 0 and success = ? and completion <> ''", experimentId, true).Order("id desc").Limit(num).Find(&records).Error
+	return records, err
+}
+
+func UpdateExperimentRecordScore(id int, score float64) error {
+	return DB.Model(&ExperimentRecord{}).Where("id = ?", id).Update("score", score).Error
+}
+
+func GetExperimentReport(experimentId int) ([]*ExperimentArmStat, error) {
+	var stats []*ExperimentArmStat
+	err := DB.Model(&ExperimentRecord{}).Select(
+		"arm, count(*) as requests, sum(case when success then 0 else 1 end) as errors, avg(latency) as avg_latency, "+
+			"sum(quota) as total_quota, count(case when score >= 0 then 1 end) as judged, coalesce(avg(case when score >= 0 then score end), 0) as avg_score",
+	).Where("experiment_id = ?", experimentId).Group("arm").Scan(&stats).Error
+	if err != nil {
+		return nil, err
+	}
+	for _, stat := range stats {
+		if stat.Requests == 0 {
+			continue
+		}
+		stat.ErrorRate = float64(stat.Errors) / float64(stat.Requests)
+		stat.AvgQuota = float64(stat.TotalQuota) / float64(stat.Requests)
+		var latencies []int64
+		err = DB.Model(&ExperimentRecord{}).Where("experiment_id = ? and arm = ?", experimentId, stat.Arm).
+			Order("latency").Offset(stat.Requests*95/100).Limit(1).Pluck("latency", &latencies).Error
+		if err == nil && len(latencies) > 0 {
+			stat.P95Latency = latencies[0]
+		}
+	}
+	return stats, nil
+}
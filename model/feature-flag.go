@@ -0,0 +1,211 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"hash/fnv"
+	"one-api/common"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// FeatureFlag rolls a relay behavior out to a share of the users, a user keeps the same side of the rollout
+// while the percentage only grows
+type FeatureFlag struct {
+	Id               int                           `json:"id"`
+	Key              string                        `json:"key" gorm:"type:varchar(64);uniqueIndex"`
+	Description      string                        `json:"description"`
+	Status           int                           `json:"status" gorm:"default:1"`
+	Percentage       int                           `json:"percentage"`                           // share of the users with the feature, 0 - 100
+	GroupPercentages string                        `json:"group_percentages" gorm:"type:text"`   // JSON, the percentage of each group instead of the one above
+	UserIds          string                        `json:"user_ids" gorm:"type:text;default:''"` // comma separated, the users who always have the feature
+	CreatedTime      int64                         `json:"created_time" gorm:"bigint"`
+	UpdatedTime      int64                         `json:"updated_time" gorm:"bigint"`
+	Metrics          map[string]*FeatureFlagMetric `json:"metrics,omitempty" gorm:"-"`
+
+	groupPercentages map[string]int
+	userIds          map[int]bool
+}
+
+// FeatureFlagMetric compares the requests with the feature ("on") to the ones without ("off"), counted by each node
+type FeatureFlagMetric struct {
+	Requests     int     `json:"requests"`
+	Errors       int     `json:"errors"`
+	ErrorRate    float64 `json:"error_rate"`
+	AvgLatency   float64 `json:"avg_latency"` // in milliseconds
+	totalLatency int64
+}
+
+// KnownFeatureFlags are the relay behaviors which can be gated, with their behavior when the flag is not defined
+var KnownFeatureFlags = map[string]bool{
+	"relay_failover": true, // retry the failed requests on the other channels
+}
+
+var key2featureFlag map[string]*FeatureFlag
+var featureFlagSyncLock sync.RWMutex
+var featureFlagMetrics = make(map[string]map[string]*FeatureFlagMetric)
+var featureFlagMetricsLock sync.Mutex
+
+func (flag *FeatureFlag) parse() error {
+	flag.groupPercentages = make(map[string]int)
+	if flag.GroupPercentages != "" {
+		if err := json.Unmarshal([]byte(flag.GroupPercentages), &flag.groupPercentages); err != nil {
+			return errors.New("分组比例不是合法的 JSON 字符串")
+		}
+	}
+	for _, percentage := range flag.groupPercentages {
+		if percentage < 0 || percentage > 100 {
+			return errors.New("分组比例必须在 0-100 之间")
+		}
+	}
+	flag.userIds = make(map[int]bool)
+	for _, id := range strings.Split(flag.UserIds, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" {
+			continue
+		}
+		userId, err := strconv.Atoi(id)
+		if err != nil {
+			return errors.New("用户 id 必须为数字")
+		}
+		flag.userIds[userId] = true
+	}
+	return nil
+}
+
+func (flag *FeatureFlag) Validate() error {
+	if _, ok := KnownFeatureFlags[flag.Key]; !ok {
+		return errors.New("未知的功能开关")
+	}
+	if flag.Percentage < 0 || flag.Percentage > 100 {
+		return errors.New("开启比例必须在 0-100 之间")
+	}
+	return flag.parse()
+}
+
+func InitFeatureFlagCache() {
+	var flags []*FeatureFlag
+	DB.Find(&flags)
+	newKey2featureFlag := make(map[string]*FeatureFlag)
+	for _, flag := range flags {
+		if err := flag.parse(); err != nil {
+			common.SysError("failed to parse feature flag " + flag.Key + ": " + err.Error())
+			continue
+		}
+		newKey2featureFlag[flag.Key] = flag
+	}
+	featureFlagSyncLock.Lock()
+	key2featureFlag = newKey2featureFlag
+	featureFlagSyncLock.Unlock()
+}
+
+func SyncFeatureFlagCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitFeatureFlagCache()
+	}
+}
+
+// getRolloutBucket places the user in 0 - 99 for the flag, the flags are independent of each other
+func getRolloutBucket(key string, userId int) int {
+	hash := fnv.New32a()
+	_, _ = hash.Write([]byte(key + ":" + strconv.Itoa(userId)))
+	return int(hash.Sum32() % 100)
+}
+
+// IsFeatureEnabled tells whether the user of the group has the feature, defaultValue is used for the flags not defined
+func IsFeatureEnabled(key string, group string, userId int, defaultValue bool) bool {
+	featureFlagSyncLock.RLock()
+	flag, ok := key2featureFlag[key]
+	featureFlagSyncLock.RUnlock()
+	if !ok {
+		return defaultValue
+	}
+	if flag.Status != common.FeatureFlagStatusEnabled {
+		return false
+	}
+	if flag.userIds[userId] {
+		return true
+	}
+	percentage := flag.Percentage
+	if groupPercentage, ok := flag.groupPercentages[group]; ok {
+		percentage = groupPercentage
+	}
+	return getRolloutBucket(key, userId) < percentage
+}
+
+// RecordFeatureFlagMetric counts a request in the side of the rollout it was on
+func RecordFeatureFlagMetric(key string, enabled bool, latency int64, success bool) {
+	side := "off"
+	if enabled {
+		side = "on"
+	}
+	featureFlagMetricsLock.Lock()
+	defer featureFlagMetricsLock.Unlock()
+	metrics, ok := featureFlagMetrics[key]
+	if !ok {
+		metrics = make(map[string]*FeatureFlagMetric)
+		featureFlagMetrics[key] = metrics
+	}
+	metric, ok := metrics[side]
+	if !ok {
+		metric = &FeatureFlagMetric{}
+		metrics[side] = metric
+	}
+	metric.Requests++
+	if !success {
+		metric.Errors++
+	}
+	metric.totalLatency += latency
+}
+
+func getFeatureFlagMetrics(key string) map[string]*FeatureFlagMetric {
+	featureFlagMetricsLock.Lock()
+	defer featureFlagMetricsLock.Unlock()
+	metrics := make(map[string]*FeatureFlagMetric)
+	for side, metric := range featureFlagMetrics[key] {
+		result := *metric
+		result.ErrorRate = float64(result.Errors) / float64(result.Requests)
+		result.AvgLatency = float64(result.totalLatency) / float64(result.Requests)
+		metrics[side] = &result
+	}
+	return metrics
+}
+
+func GetAllFeatureFlags() ([]*FeatureFlag, error) {
+	var flags []*FeatureFlag
+	err := DB.Order("id desc").Find(&flags).Error
+	for _, flag := range flags {
+		flag.Metrics = getFeatureFlagMetrics(flag.Key)
+	}
+	return flags, err
+}
+
+func GetFeatureFlagById(id int) (*FeatureFlag, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	flag := FeatureFlag{Id: id}
+	err := DB.First(&flag, "id = ?", id).Error
+	return &flag, err
+}
+
+func (flag *FeatureFlag) Insert() error {
+	err := DB.Create(flag).Error
+	InitFeatureFlagCache()
+	return err
+}
+
+func (flag *FeatureFlag) Update() error {
+	err := DB.Model(flag).Select("description", "status", "percentage", "group_percentages", "user_ids", "updated_time").Updates(flag).Error
+	InitFeatureFlagCache()
+	return err
+}
+
+func (flag *FeatureFlag) Delete() error {
+	err := DB.Delete(flag).Error
+	InitFeatureFlagCache()
+	return err
+}
@@ -0,0 +1,85 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+// File is a file uploaded to the gateway for the Files API, it is uploaded to an upstream the first time a request on
+// the upstream uses it
+type File struct {
+	Id        string `json:"id" gorm:"type:varchar(64);primaryKey"`
+	Object    string `json:"object" gorm:"-"`
+	UserId    int    `json:"-" gorm:"index"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at" gorm:"bigint"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose" gorm:"type:varchar(32)"`
+	Storage   string `json:"-" gorm:"type:varchar(16)"` // local or s3
+}
+
+// UpstreamFile is the copy of a file uploaded with a key of a channel
+type UpstreamFile struct {
+	Id             int    `json:"id"`
+	FileId         string `json:"file_id" gorm:"type:varchar(64);uniqueIndex:idx_upstream_file"`
+	ChannelId      int    `json:"channel_id" gorm:"uniqueIndex:idx_upstream_file"`
+	KeyHash        string `json:"-" gorm:"type:varchar(64);default:'';uniqueIndex:idx_upstream_file"` // empty for the channels without a key strategy
+	UpstreamFileId string `json:"upstream_file_id"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+}
+
+func (file *File) GetStorageKey() string {
+	return "files/" + file.Id
+}
+
+func (file *File) Insert() error {
+	file.CreatedAt = common.GetTimestamp()
+	return DB.Create(file).Error
+}
+
+func GetUserFiles(userId int, purpose string) (files []*File, err error) {
+	tx := DB.Where("user_id = ?", userId)
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+	err = tx.Order("created_at desc").Find(&files).Error
+	return files, err
+}
+
+func GetUserFile(id string, userId int) (*File, error) {
+	file := &File{}
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(file).Error
+	return file, err
+}
+
+// GetUserFileBytes is the storage used by the files of the user
+func GetUserFileBytes(userId int) (bytes int64, err error) {
+	err = DB.Model(&File{}).Where("user_id = ?", userId).Select("COALESCE(SUM(bytes), 0)").Scan(&bytes).Error
+	return bytes, err
+}
+
+// Delete deletes the file and returns its copies on the upstreams
+func (file *File) Delete() (upstreamFiles []*UpstreamFile, err error) {
+	err = DB.Where("file_id = ?", file.Id).Find(&upstreamFiles).Error
+	if err != nil {
+		return nil, err
+	}
+	err = DB.Where("file_id = ?", file.Id).Delete(&UpstreamFile{}).Error
+	if err != nil {
+		return nil, err
+	}
+	return upstreamFiles, DB.Delete(file).Error
+}
+
+func GetUpstreamFileId(fileId string, channelId int, keyHash string) (string, bool) {
+	upstreamFile := &UpstreamFile{}
+	err := DB.Where("file_id = ? and channel_id = ? and key_hash = ?", fileId, channelId, keyHash).First(upstreamFile).Error
+	return upstreamFile.UpstreamFileId, err == nil
+}
+
+func RecordUpstreamFile(upstreamFile *UpstreamFile) {
+	upstreamFile.CreatedTime = common.GetTimestamp()
+	err := DB.Create(upstreamFile).Error
+	if err != nil {
+		common.SysError("failed to record upstream file: " + err.Error())
+	}
+}
@@ -0,0 +1,118 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// DailyGrant records the days on which the free quota has been granted, the unique day keeps it from being granted twice
+type DailyGrant struct {
+	Id          int    `json:"id"`
+	Day         string `json:"day" gorm:"type:varchar(10);uniqueIndex"`
+	Quota       int    `json:"quota"`
+	Users       int64  `json:"users"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func grantDailyQuota(day string) (int64, error) {
+	grant := &DailyGrant{
+		Day:         day,
+		Quota:       common.DailyGrantQuota,
+		CreatedTime: common.GetTimestamp(),
+	}
+	// claim the day first, another master may be doing the same
+	err := DB.Create(grant).Error
+	if err != nil {
+		var count int64
+		if DB.Model(&DailyGrant{}).Where("day = ?", day).Count(&count).Error == nil && count > 0 {
+			// another master claimed the day
+			return 0, nil
+		}
+		return 0, err
+	}
+	// the postpaid users owe what their quota is below zero, the grant must not pay it off
+	tx := DB.Model(&User{}).Select("id", "quota").Where("status = ? and billing_mode <> ?", common.UserStatusEnabled, common.UserBillingModePostpaid)
+	if common.DailyGrantGroup != "" {
+		tx = tx.Where("`group` = ?", common.DailyGrantGroup)
+	}
+	if common.DailyGrantActiveDays > 0 {
+		since := time.Now().AddDate(0, 0, -common.DailyGrantActiveDays).Unix()
+		tx = tx.Where("id in (?)", DB.Model(&Token{}).Select("user_id").Where("accessed_time >= ?", since))
+	}
+	if !common.DailyGrantAccumulationEnabled {
+		tx = tx.Where("quota >= 0 and quota < ?", common.DailyGrantQuota)
+	}
+	var users []*User
+	result := tx.FindInBatches(&users, 500, func(batch *gorm.DB, _ int) error {
+		for _, user := range users {
+			quota, err := grantUserDailyQuota(user)
+			if err != nil {
+				return err
+			}
+			if quota == 0 {
+				continue
+			}
+			grant.Users++
+			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("每日赠送 %s", common.LogQuota(quota)))
+			err = CacheUpdateUserQuota(user.Id)
+			if err != nil {
+				common.SysError("error update user quota cache: " + err.Error())
+			}
+		}
+		return nil
+	})
+	if result.Error != nil {
+		if grant.Users == 0 {
+			// release the day so that the next round can retry
+			DB.Delete(grant)
+			return 0, result.Error
+		}
+		// the users granted already keep their quota, the others wait for tomorrow
+		common.SysError("failed to grant daily quota to all users: " + result.Error.Error())
+	}
+	DB.Model(grant).Update("users", grant.Users)
+	return grant.Users, nil
+}
+
+// grantUserDailyQuota returns the quota granted to the user, the top up is checked again in case the quota changed
+func grantUserDailyQuota(user *User) (int, error) {
+	if common.DailyGrantAccumulationEnabled {
+		err := DB.Model(&User{}).Where("id = ?", user.Id).Update("quota", gorm.Expr("quota + ?", common.DailyGrantQuota)).Error
+		if err != nil {
+			return 0, err
+		}
+		return common.DailyGrantQuota, nil
+	}
+	// top the quota up to the grant, the unused free quota of yesterday is not carried over
+	result := DB.Model(&User{}).Where("id = ? and quota = ?", user.Id, user.Quota).Update("quota", common.DailyGrantQuota)
+	if result.Error != nil {
+		return 0, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return 0, nil
+	}
+	return common.DailyGrantQuota - user.Quota, nil
+}
+
+// AutomaticallyGrantDailyQuota grants the free quota once per day, only on the master node
+func AutomaticallyGrantDailyQuota(frequency int) {
+	for {
+		if common.DailyGrantQuota > 0 {
+			day := time.Now().Format("2006-01-02")
+			var count int64
+			DB.Model(&DailyGrant{}).Where("day = ?", day).Count(&count)
+			if count == 0 {
+				users, err := grantDailyQuota(day)
+				if err != nil {
+					common.SysError("failed to grant daily quota: " + err.Error())
+				} else if users > 0 {
+					common.SysLog(fmt.Sprintf("daily quota %d granted to %d users", common.DailyGrantQuota, users))
+				}
+			}
+		}
+		time.Sleep(time.Duration(frequency) * time.Second)
+	}
+}
@@ -0,0 +1,215 @@
+package model
+
+import (
+	"encoding/json"
+	"fmt"
+	"one-api/common"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Kubernetes projects every key of a mounted ConfigMap / Secret as a file, and swaps the
+// files atomically (through the ..data symlink) when the object is edited.
+// A file named after an option key sets that option, channels.json declares channels.
+const kubernetesChannelsFile = "channels.json"
+
+var kubernetesConfigLoaded = false
+var kubernetesConfigLock sync.Mutex
+var kubernetesFileContents = make(map[string]string)
+
+func KubernetesConfigEnabled() bool {
+	return common.KubernetesConfigDirs != ""
+}
+
+// IsKubernetesConfigLoaded reports whether the mounted config has been applied at least once.
+func IsKubernetesConfigLoaded() bool {
+	kubernetesConfigLock.Lock()
+	defer kubernetesConfigLock.Unlock()
+	return kubernetesConfigLoaded
+}
+
+func readKubernetesConfigFiles() (map[string]string, error) {
+	files := make(map[string]string)
+	for _, dir := range strings.Split(common.KubernetesConfigDirs, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			name := entry.Name()
+			if strings.HasPrefix(name, ".") {
+				continue // ..data and the timestamped directories
+			}
+			path := filepath.Join(dir, name)
+			info, err := os.Stat(path) // follow the symlink
+			if err != nil || info.IsDir() {
+				continue
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			files[name] = strings.TrimRight(string(content), "\r\n")
+		}
+	}
+	return files, nil
+}
+
+func applyKubernetesOption(key string, value string) error {
+	common.OptionMapRWMutex.RLock()
+	_, ok := common.OptionMap[key]
+	common.OptionMapRWMutex.RUnlock()
+	if !ok {
+		return fmt.Errorf("unknown option %s", key)
+	}
+	if common.IsMasterNode {
+		return UpdateOption(key, value)
+	}
+	// slave nodes only apply it in memory, the master node persists it
+	return updateOptionMap(key, value)
+}
+
+// applyKubernetesChannels upserts the declared channels, matching them by name.
+// Channels missing from the file are left untouched.
+func applyKubernetesChannels(content string) error {
+	var channels []Channel
+	err := json.Unmarshal([]byte(content), &channels)
+	if err != nil {
+		return err
+	}
+	for _, channel := range channels {
+		if channel.Name == "" {
+			return fmt.Errorf("channel name is required")
+		}
+		var existing Channel
+		err = DB.Where("name = ?", channel.Name).Limit(1).Find(&existing).Error
+		if err != nil {
+			return err
+		}
+		if existing.Id == 0 {
+			channel.Id = 0
+			channel.CreatedTime = common.GetTimestamp()
+			err = channel.Insert()
+		} else {
+			channel.Id = existing.Id
+			err = channel.Update()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to apply channel %s: %s", channel.Name, err.Error())
+		}
+	}
+	if common.RedisEnabled {
+		InitChannelCache()
+	}
+	return nil
+}
+
+// LoadKubernetesConfig applies the files which have changed since the last call.
+func LoadKubernetesConfig() error {
+	files, err := readKubernetesConfigFiles()
+	if err != nil {
+		return err
+	}
+	kubernetesConfigLock.Lock()
+	defer kubernetesConfigLock.Unlock()
+	for name, content := range files {
+		if previous, ok := kubernetesFileContents[name]; ok && previous == content {
+			continue
+		}
+		err = nil
+		if name == kubernetesChannelsFile {
+			if common.IsMasterNode {
+				err = applyKubernetesChannels(content)
+			}
+		} else {
+			err = applyKubernetesOption(name, content)
+		}
+		// remember it even if it failed, so that a broken file is reported once rather than every poll
+		kubernetesFileContents[name] = content
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to apply kubernetes config %s: %s", name, err.Error()))
+			continue
+		}
+		common.SysLog("applied kubernetes config " + name)
+	}
+	kubernetesConfigLoaded = true
+	return nil
+}
+
+// the events of a swap come in a burst, the config is loaded once they stop
+const kubernetesConfigDebounce = 500 * time.Millisecond
+
+func watchKubernetesConfigDirs() (*fsnotify.Watcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	for _, dir := range strings.Split(common.KubernetesConfigDirs, ",") {
+		dir = strings.TrimSpace(dir)
+		if dir == "" {
+			continue
+		}
+		// the directory is watched rather than the files, Kubernetes replaces the ..data symlink in it
+		err = watcher.Add(dir)
+		if err != nil {
+			_ = watcher.Close()
+			return nil, err
+		}
+	}
+	return watcher, nil
+}
+
+func loadKubernetesConfigLogged() {
+	err := LoadKubernetesConfig()
+	if err != nil {
+		common.SysError("failed to load kubernetes config: " + err.Error())
+	}
+}
+
+// SyncKubernetesConfig reloads the mounted config as soon as Kubernetes swaps its files, the directories are read
+// every frequency seconds as well in case an event is missed or the file system can't be watched
+func SyncKubernetesConfig(frequency int) {
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	watcher, err := watchKubernetesConfigDirs()
+	if err != nil {
+		common.SysError("failed to watch kubernetes config, only polling it: " + err.Error())
+	} else {
+		defer watcher.Close()
+		events = watcher.Events
+		errors = watcher.Errors
+	}
+	ticker := time.NewTicker(time.Duration(frequency) * time.Second)
+	defer ticker.Stop()
+	var debounce <-chan time.Time
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			debounce = time.After(kubernetesConfigDebounce)
+		case err, ok := <-errors:
+			if !ok {
+				errors = nil
+				continue
+			}
+			common.SysError("failed to watch kubernetes config: " + err.Error())
+		case <-debounce:
+			debounce = nil
+			loadKubernetesConfigLogged()
+		case <-ticker.C:
+			loadKubernetesConfigLogged()
+		}
+	}
+}
@@ -3,13 +3,14 @@ package model
 import (
 	"gorm.io/gorm"
 	"one-api/common"
+	"sort"
 )
 
 type Log struct {
 	Id               int    `json:"id"`
-	UserId           int    `json:"user_id"`
-	CreatedAt        int64  `json:"created_at" gorm:"bigint;index"`
-	Type             int    `json:"type" gorm:"index"`
+	UserId           int    `json:"user_id" gorm:"index:idx_logs_user_type_created,priority:1"`
+	CreatedAt        int64  `json:"created_at" gorm:"bigint;index;index:idx_logs_user_type_created,priority:3;index:idx_logs_type_created,priority:2"`
+	Type             int    `json:"type" gorm:"index;index:idx_logs_user_type_created,priority:2;index:idx_logs_type_created,priority:1"`
 	Content          string `json:"content"`
 	Username         string `json:"username" gorm:"index;default:''"`
 	TokenName        string `json:"token_name" gorm:"index;default:''"`
@@ -25,6 +26,9 @@ const (
 	LogTypeConsume
 	LogTypeManage
 	LogTypeSystem
+	LogTypeRefund
+	LogTypeTransfer
+	LogTypeModeration
 )
 
 func RecordLog(userId int, logType int, content string) {
@@ -44,10 +48,8 @@ func RecordLog(userId int, logType int, content string) {
 	}
 }
 
+// RecordConsumeLog doesn't check LogConsumeEnabled, the callers resolve it with the overrides of the request
 func RecordConsumeLog(userId int, promptTokens int, completionTokens int, modelName string, tokenName string, quota int, content string) {
-	if !common.LogConsumeEnabled {
-		return
-	}
 	log := &Log{
 		UserId:           userId,
 		Username:         GetUsernameById(userId),
@@ -66,6 +68,41 @@ func RecordConsumeLog(userId int, promptTokens int, completionTokens int, modelN
 	}
 }
 
+// RecordModerationLog records a request which the content moderation blocked or flagged
+func RecordModerationLog(userId int, modelName string, tokenName string, content string) {
+	log := &Log{
+		UserId:    userId,
+		Username:  GetUsernameById(userId),
+		CreatedAt: common.GetTimestamp(),
+		Type:      LogTypeModeration,
+		Content:   content,
+		TokenName: tokenName,
+		ModelName: modelName,
+	}
+	err := DB.Create(log).Error
+	if err != nil {
+		common.SysError("failed to record log: " + err.Error())
+	}
+}
+
+// RecordRefundLog records a request whose pre-consumed quota has been given back, quota is the refunded amount
+func RecordRefundLog(userId int, modelName string, tokenName string, quota int, content string) {
+	log := &Log{
+		UserId:    userId,
+		Username:  GetUsernameById(userId),
+		CreatedAt: common.GetTimestamp(),
+		Type:      LogTypeRefund,
+		Content:   content,
+		TokenName: tokenName,
+		ModelName: modelName,
+		Quota:     quota,
+	}
+	err := DB.Create(log).Error
+	if err != nil {
+		common.SysError("failed to record log: " + err.Error())
+	}
+}
+
 func GetAllLogs(logType int, startTimestamp int64, endTimestamp int64, modelName string, username string, tokenName string, startIdx int, num int) (logs []*Log, err error) {
 	var tx *gorm.DB
 	if logType == LogTypeUnknown {
@@ -166,3 +203,115 @@ func SumUsedToken(logType int, startTimestamp int64, endTimestamp int64, modelNa
 	tx.Where("type = ?", LogTypeConsume).Scan(&token)
 	return token
 }
+
+type StatementItem struct {
+	ModelName        string `json:"model_name"`
+	TokenName        string `json:"token_name"`
+	Requests         int    `json:"requests"`
+	PromptTokens     int64  `json:"prompt_tokens"`
+	CompletionTokens int64  `json:"completion_tokens"`
+	Quota            int64  `json:"quota"`
+}
+
+// GetUserStatementItems sums up the consume logs of a user within [startTimestamp, endTimestamp) by model and token,
+// the daily usages are summed up instead when the logs are sampled, except before the daily usages were kept
+func GetUserStatementItems(userId int, startTimestamp int64, endTimestamp int64) (items []*StatementItem, err error) {
+	if common.LogSampleRate >= 100 {
+		return getLogStatementItems(userId, startTimestamp, endTimestamp)
+	}
+	usageStart, err := getUserUsageStart()
+	if err != nil {
+		return nil, err
+	}
+	if usageStart == 0 || endTimestamp <= usageStart {
+		return getLogStatementItems(userId, startTimestamp, endTimestamp)
+	}
+	if startTimestamp >= usageStart {
+		return getUserUsageStatementItems(userId, startTimestamp, endTimestamp)
+	}
+	logItems, err := getLogStatementItems(userId, startTimestamp, usageStart)
+	if err != nil {
+		return nil, err
+	}
+	usageItems, err := getUserUsageStatementItems(userId, usageStart, endTimestamp)
+	if err != nil {
+		return nil, err
+	}
+	return mergeStatementItems(logItems, usageItems), nil
+}
+
+func getLogStatementItems(userId int, startTimestamp int64, endTimestamp int64) (items []*StatementItem, err error) {
+	err = DB.Table("logs").Select(
+		"model_name, token_name, count(*) as requests, sum(prompt_tokens) as prompt_tokens, "+
+			"sum(completion_tokens) as completion_tokens, sum(quota) as quota",
+	).Where("user_id = ? and type = ? and created_at >= ? and created_at < ?", userId, LogTypeConsume, startTimestamp, endTimestamp).
+		Group("model_name, token_name").Order("model_name, token_name").Scan(&items).Error
+	return items, err
+}
+
+// mergeStatementItems adds up the items of the same model and token, sorted like the queries
+func mergeStatementItems(a []*StatementItem, b []*StatementItem) []*StatementItem {
+	merged := make(map[[2]string]*StatementItem)
+	var items []*StatementItem
+	for _, item := range append(a, b...) {
+		key := [2]string{item.ModelName, item.TokenName}
+		if existing, ok := merged[key]; ok {
+			existing.Requests += item.Requests
+			existing.PromptTokens += item.PromptTokens
+			existing.CompletionTokens += item.CompletionTokens
+			existing.Quota += item.Quota
+			continue
+		}
+		merged[key] = item
+		items = append(items, item)
+	}
+	sort.Slice(items, func(i, j int) bool {
+		if items[i].ModelName != items[j].ModelName {
+			return items[i].ModelName < items[j].ModelName
+		}
+		return items[i].TokenName < items[j].TokenName
+	})
+	return items
+}
+
+// SumUserConsumedQuota sums up the consume logs of a user within [startTimestamp, endTimestamp),
+// the time of the first of them is returned as well, 0 if there is none.
+// The daily usages are summed up instead when the logs are sampled, except before the daily usages were kept
+func SumUserConsumedQuota(userId int, startTimestamp int64, endTimestamp int64) (quota int64, firstTime int64, err error) {
+	if common.LogSampleRate >= 100 {
+		return sumLogConsumedQuota(userId, startTimestamp, endTimestamp)
+	}
+	usageStart, err := getUserUsageStart()
+	if err != nil {
+		return 0, 0, err
+	}
+	if usageStart == 0 || endTimestamp <= usageStart {
+		return sumLogConsumedQuota(userId, startTimestamp, endTimestamp)
+	}
+	if startTimestamp >= usageStart {
+		return sumUserUsageQuota(userId, startTimestamp, endTimestamp)
+	}
+	logQuota, firstTime, err := sumLogConsumedQuota(userId, startTimestamp, usageStart)
+	if err != nil {
+		return 0, 0, err
+	}
+	usageQuota, usageFirstTime, err := sumUserUsageQuota(userId, usageStart, endTimestamp)
+	if err != nil {
+		return 0, 0, err
+	}
+	if firstTime == 0 {
+		firstTime = usageFirstTime
+	}
+	return logQuota + usageQuota, firstTime, nil
+}
+
+func sumLogConsumedQuota(userId int, startTimestamp int64, endTimestamp int64) (quota int64, firstTime int64, err error) {
+	var result struct {
+		Quota     int64
+		FirstTime int64
+	}
+	err = DB.Table("logs").Select("COALESCE(sum(quota), 0) as quota, COALESCE(min(created_at), 0) as first_time").
+		Where("user_id = ? and type = ? and created_at >= ? and created_at < ?", userId, LogTypeConsume, startTimestamp, endTimestamp).
+		Scan(&result).Error
+	return result.Quota, result.FirstTime, err
+}
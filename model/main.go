@@ -77,6 +77,13 @@ func InitDB() (err error) {
 		sqlDB.SetMaxIdleConns(common.GetOrDefault("SQL_MAX_IDLE_CONNS", 100))
 		sqlDB.SetMaxOpenConns(common.GetOrDefault("SQL_MAX_OPEN_CONNS", 1000))
 		sqlDB.SetConnMaxLifetime(time.Second * time.Duration(common.GetOrDefault("SQL_MAX_LIFETIME", 60)))
+		if common.SQLAuditEnabled {
+			err = registerSQLAudit(DB, sqlDB)
+			if err != nil {
+				return err
+			}
+			go ReportSlowQueries(common.SQLAuditReportFrequency)
+		}
 
 		if !common.IsMasterNode {
 			return nil
@@ -101,14 +108,142 @@ func InitDB() (err error) {
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&RedemptionRecord{})
+		if err != nil {
+			return err
+		}
 		err = db.AutoMigrate(&Ability{})
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&ChannelKey{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelProbe{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelSpend{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelUsage{})
+		if err != nil {
+			return err
+		}
 		err = db.AutoMigrate(&Log{})
 		if err != nil {
 			return err
 		}
+		err = db.AutoMigrate(&Reservation{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&TopUpOrder{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&TenantUsage{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UsageExport{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&WebhookDelivery{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&DailyGrant{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&QuotaTransfer{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&CreditBucket{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&OptionOverride{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserUsage{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Coupon{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UserCoupon{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&CouponLedger{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&SpendingAlert{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Policy{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ChannelGroup{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ModelAlias{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Experiment{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&ExperimentRecord{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&FeatureFlag{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UpstreamObject{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&File{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&UpstreamFile{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Batch{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&BatchOutput{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&Organization{})
+		if err != nil {
+			return err
+		}
+		err = db.AutoMigrate(&OrganizationMember{})
+		if err != nil {
+			return err
+		}
 		common.SysLog("database migrated")
 		err = createRootAccountIfNeed()
 		return err
@@ -118,6 +253,14 @@ func InitDB() (err error) {
 	return err
 }
 
+func PingDB() error {
+	sqlDB, err := DB.DB()
+	if err != nil {
+		return err
+	}
+	return sqlDB.Ping()
+}
+
 func CloseDB() error {
 	sqlDB, err := DB.DB()
 	if err != nil {
@@ -0,0 +1,77 @@
+package model
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// ModelAlias makes the requests for a model name, such as a deprecated one, use another model before they are routed
+type ModelAlias struct {
+	Id          int    `json:"id"`
+	Alias       string `json:"alias" gorm:"type:varchar(64);uniqueIndex"`
+	Model       string `json:"model" gorm:"type:varchar(64)"`
+	Deprecated  bool   `json:"deprecated" gorm:"default:false"` // the responses tell the clients the alias is deprecated
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+var modelAliases map[string]*ModelAlias
+var modelAliasSyncLock sync.RWMutex
+
+func InitModelAliasCache() {
+	var aliases []*ModelAlias
+	DB.Find(&aliases)
+	newModelAliases := make(map[string]*ModelAlias, len(aliases))
+	for _, alias := range aliases {
+		newModelAliases[alias.Alias] = alias
+	}
+	modelAliasSyncLock.Lock()
+	modelAliases = newModelAliases
+	modelAliasSyncLock.Unlock()
+}
+
+func SyncModelAliasCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitModelAliasCache()
+	}
+}
+
+// GetModelAlias returns the cached alias, nil if the model name is not an alias
+func GetModelAlias(name string) *ModelAlias {
+	modelAliasSyncLock.RLock()
+	defer modelAliasSyncLock.RUnlock()
+	return modelAliases[name]
+}
+
+func GetAllModelAliases(startIdx int, num int) (aliases []*ModelAlias, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&aliases).Error
+	return aliases, err
+}
+
+func GetModelAliasById(id int) (*ModelAlias, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	alias := ModelAlias{Id: id}
+	err := DB.First(&alias, "id = ?", id).Error
+	return &alias, err
+}
+
+func (alias *ModelAlias) Insert() error {
+	err := DB.Create(alias).Error
+	InitModelAliasCache()
+	return err
+}
+
+func (alias *ModelAlias) Update() error {
+	err := DB.Model(alias).Select("alias", "model", "deprecated").Updates(alias).Error
+	InitModelAliasCache()
+	return err
+}
+
+func (alias *ModelAlias) Delete() error {
+	err := DB.Delete(alias).Error
+	InitModelAliasCache()
+	return err
+}
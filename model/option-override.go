@@ -0,0 +1,183 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm/clause"
+)
+
+// OptionOverride overrides an option for a group, a user or a token,
+// the token level wins over the user level, which wins over the group level and then the global option
+type OptionOverride struct {
+	Id          int    `json:"id"`
+	Scope       string `json:"scope" gorm:"type:varchar(16);uniqueIndex:idx_option_override"`  // group, user or token
+	Target      string `json:"target" gorm:"type:varchar(64);uniqueIndex:idx_option_override"` // the group name, the user id or the token id
+	Key         string `json:"key" gorm:"type:varchar(64);uniqueIndex:idx_option_override"`
+	Value       string `json:"value" gorm:"type:text"`
+	UpdatedTime int64  `json:"updated_time" gorm:"bigint"`
+}
+
+const (
+	OptionScopeGroup = "group"
+	OptionScopeUser  = "user"
+	OptionScopeToken = "token"
+)
+
+// OverridableOptions are the options which can be overridden, with the type of their values
+var OverridableOptions = map[string]string{
+	"RelayRateLimitNum":                 "int",
+	"LogConsumeEnabled":                 "bool",
+	"ErrorPassthroughEnabled":           "bool",
+	"StreamHeartbeat":                   "int", // overrides the heartbeat of StreamSettings
+	"StreamIdleTimeout":                 "int", // overrides the idle timeout of StreamSettings
+	"FileStorageQuota":                  "int",
+	"StructuredOutputValidationEnabled": "bool",
+}
+
+var optionOverrides = make(map[string]string)
+var optionOverrideSyncLock sync.RWMutex
+
+func getOptionOverrideKey(scope string, target string, key string) string {
+	return scope + ":" + target + ":" + key
+}
+
+func InitOptionOverrideCache() {
+	var overrides []*OptionOverride
+	DB.Find(&overrides)
+	newOptionOverrides := make(map[string]string, len(overrides))
+	for _, override := range overrides {
+		newOptionOverrides[getOptionOverrideKey(override.Scope, override.Target, override.Key)] = override.Value
+	}
+	optionOverrideSyncLock.Lock()
+	optionOverrides = newOptionOverrides
+	optionOverrideSyncLock.Unlock()
+}
+
+func SyncOptionOverrideCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitOptionOverrideCache()
+	}
+}
+
+// ResolveOption returns the value of the option for a request, looking it up from the token to the user,
+// the group and finally the global options, false is returned if it is set nowhere
+func ResolveOption(key string, group string, userId int, tokenId int) (string, bool) {
+	optionOverrideSyncLock.RLock()
+	layers := []string{
+		getOptionOverrideKey(OptionScopeToken, strconv.Itoa(tokenId), key),
+		getOptionOverrideKey(OptionScopeUser, strconv.Itoa(userId), key),
+		getOptionOverrideKey(OptionScopeGroup, group, key),
+	}
+	for _, layer := range layers {
+		if value, ok := optionOverrides[layer]; ok {
+			optionOverrideSyncLock.RUnlock()
+			return value, true
+		}
+	}
+	optionOverrideSyncLock.RUnlock()
+	common.OptionMapRWMutex.RLock()
+	defer common.OptionMapRWMutex.RUnlock()
+	value, ok := common.OptionMap[key]
+	return value, ok
+}
+
+func ResolveIntOption(key string, group string, userId int, tokenId int, defaultValue int) int {
+	value, ok := ResolveOption(key, group, userId, tokenId)
+	if !ok {
+		return defaultValue
+	}
+	intValue, err := strconv.Atoi(value)
+	if err != nil {
+		return defaultValue
+	}
+	return intValue
+}
+
+func ResolveBoolOption(key string, group string, userId int, tokenId int, defaultValue bool) bool {
+	value, ok := ResolveOption(key, group, userId, tokenId)
+	if !ok {
+		return defaultValue
+	}
+	boolValue, err := strconv.ParseBool(value)
+	if err != nil {
+		return defaultValue
+	}
+	return boolValue
+}
+
+func (override *OptionOverride) validate() error {
+	if override.Scope != OptionScopeGroup && override.Scope != OptionScopeUser && override.Scope != OptionScopeToken {
+		return errors.New("作用范围必须为 group、user 或 token")
+	}
+	if override.Target == "" {
+		return errors.New("作用对象不能为空")
+	}
+	if override.Scope != OptionScopeGroup {
+		if _, err := strconv.Atoi(override.Target); err != nil {
+			return errors.New("用户与令牌的作用对象必须为 id")
+		}
+	}
+	valueType, ok := OverridableOptions[override.Key]
+	if !ok {
+		return fmt.Errorf("选项 %s 不支持覆盖", override.Key)
+	}
+	var err error
+	switch valueType {
+	case "int":
+		_, err = strconv.Atoi(override.Value)
+	case "bool":
+		_, err = strconv.ParseBool(override.Value)
+	}
+	if err != nil {
+		return fmt.Errorf("选项 %s 的值必须为 %s 类型", override.Key, valueType)
+	}
+	return nil
+}
+
+// SaveOptionOverride creates the override or updates its value if it exists already
+func SaveOptionOverride(override *OptionOverride) error {
+	err := override.validate()
+	if err != nil {
+		return err
+	}
+	override.UpdatedTime = common.GetTimestamp()
+	err = DB.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "scope"}, {Name: "target"}, {Name: "key"}},
+		DoUpdates: clause.AssignmentColumns([]string{"value", "updated_time"}),
+	}).Create(override).Error
+	if err != nil {
+		return err
+	}
+	InitOptionOverrideCache()
+	return nil
+}
+
+func GetOptionOverrides(scope string, target string) (overrides []*OptionOverride, err error) {
+	tx := DB.Order("scope, target, id")
+	if scope != "" {
+		tx = tx.Where("scope = ?", scope)
+	}
+	if target != "" {
+		tx = tx.Where("target = ?", target)
+	}
+	err = tx.Find(&overrides).Error
+	return overrides, err
+}
+
+func DeleteOptionOverrideById(id int) error {
+	if id == 0 {
+		return errors.New("id 为空！")
+	}
+	err := DB.Delete(&OptionOverride{Id: id}).Error
+	if err != nil {
+		return err
+	}
+	InitOptionOverrideCache()
+	return nil
+}
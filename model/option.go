@@ -34,11 +34,17 @@ func InitOptionMap() {
 	common.OptionMap["TurnstileCheckEnabled"] = strconv.FormatBool(common.TurnstileCheckEnabled)
 	common.OptionMap["RegisterEnabled"] = strconv.FormatBool(common.RegisterEnabled)
 	common.OptionMap["AutomaticDisableChannelEnabled"] = strconv.FormatBool(common.AutomaticDisableChannelEnabled)
+	common.OptionMap["AutomaticEnableChannelEnabled"] = strconv.FormatBool(common.AutomaticEnableChannelEnabled)
 	common.OptionMap["ApproximateTokenEnabled"] = strconv.FormatBool(common.ApproximateTokenEnabled)
 	common.OptionMap["LogConsumeEnabled"] = strconv.FormatBool(common.LogConsumeEnabled)
 	common.OptionMap["DisplayInCurrencyEnabled"] = strconv.FormatBool(common.DisplayInCurrencyEnabled)
 	common.OptionMap["DisplayTokenStatEnabled"] = strconv.FormatBool(common.DisplayTokenStatEnabled)
+	common.OptionMap["QuotaTransferEnabled"] = strconv.FormatBool(common.QuotaTransferEnabled)
+	common.OptionMap["DailyGrantAccumulationEnabled"] = strconv.FormatBool(common.DailyGrantAccumulationEnabled)
+	common.OptionMap["ModelDowngradeSuggestionEnabled"] = strconv.FormatBool(common.ModelDowngradeSuggestionEnabled)
 	common.OptionMap["ChannelDisableThreshold"] = strconv.FormatFloat(common.ChannelDisableThreshold, 'f', -1, 64)
+	common.OptionMap["ChannelProbeFailureThreshold"] = strconv.Itoa(common.ChannelProbeFailureThreshold)
+	common.OptionMap["ChannelProbeRecoveryThreshold"] = strconv.Itoa(common.ChannelProbeRecoveryThreshold)
 	common.OptionMap["EmailDomainRestrictionEnabled"] = strconv.FormatBool(common.EmailDomainRestrictionEnabled)
 	common.OptionMap["EmailDomainWhitelist"] = strings.Join(common.EmailDomainWhitelist, ",")
 	common.OptionMap["SMTPServer"] = ""
@@ -54,6 +60,18 @@ func InitOptionMap() {
 	common.OptionMap["Logo"] = common.Logo
 	common.OptionMap["ServerAddress"] = ""
 	common.OptionMap["GitHubClientId"] = ""
+	common.OptionMap["LDAPAuthEnabled"] = strconv.FormatBool(common.LDAPAuthEnabled)
+	common.OptionMap["LDAPServerURL"] = common.LDAPServerURL
+	common.OptionMap["LDAPBindDN"] = common.LDAPBindDN
+	common.OptionMap["LDAPBindPassword"] = ""
+	common.OptionMap["LDAPBaseDN"] = common.LDAPBaseDN
+	common.OptionMap["LDAPUsernameAttribute"] = common.LDAPUsernameAttribute
+	common.OptionMap["LDAPGroupAttribute"] = common.LDAPGroupAttribute
+	common.OptionMap["LDAPAutoProvisionEnabled"] = strconv.FormatBool(common.LDAPAutoProvisionEnabled)
+	common.OptionMap["LDAPStartTLSEnabled"] = strconv.FormatBool(common.LDAPStartTLSEnabled)
+	common.OptionMap["LDAPInsecureTLSEnabled"] = strconv.FormatBool(common.LDAPInsecureTLSEnabled)
+	common.OptionMap["TOTPRequiredForAdminEnabled"] = strconv.FormatBool(common.TOTPRequiredForAdminEnabled)
+	common.OptionMap["LDAPGroupRoles"] = common.LDAPGroupRoles2JSONString()
 	common.OptionMap["GitHubClientSecret"] = ""
 	common.OptionMap["WeChatServerAddress"] = ""
 	common.OptionMap["WeChatServerToken"] = ""
@@ -67,10 +85,80 @@ func InitOptionMap() {
 	common.OptionMap["PreConsumedQuota"] = strconv.Itoa(common.PreConsumedQuota)
 	common.OptionMap["ModelRatio"] = common.ModelRatio2JSONString()
 	common.OptionMap["GroupRatio"] = common.GroupRatio2JSONString()
+	common.OptionMap["CacheRatio"] = common.CacheRatio2JSONString()
+	common.OptionMap["AudioRatio"] = common.AudioRatio2JSONString()
+	common.OptionMap["AudioCompletionRatio"] = common.AudioCompletionRatio2JSONString()
+	common.OptionMap["ImagePrice"] = common.ImagePrice2JSONString()
+	common.OptionMap["TranscriptionPrice"] = common.TranscriptionPrice2JSONString()
+	common.OptionMap["SpeechPrice"] = common.SpeechPrice2JSONString()
+	common.OptionMap["FineTuningPrice"] = common.FineTuningPrice2JSONString()
+	common.OptionMap["SpeechVoices"] = common.SpeechVoices2JSONString()
+	common.OptionMap["UpstreamPrices"] = common.UpstreamPrices2JSONString()
+	common.OptionMap["ModelMinCharge"] = common.ModelMinCharge2JSONString()
+	common.OptionMap["ModelSurcharge"] = common.ModelSurcharge2JSONString()
+	common.OptionMap["GroupDataResidency"] = common.GroupDataResidency2JSONString()
+	common.OptionMap["GroupQuotaExhaustedResponse"] = common.GroupQuotaExhaustedResponse2JSONString()
+	common.OptionMap["CacheCreationRatio"] = common.CacheCreationRatio2JSONString()
+	common.OptionMap["StreamSettings"] = common.StreamSettings2JSONString()
+	common.OptionMap["ModelContextWindow"] = common.ModelContextWindow2JSONString()
+	common.OptionMap["RelayHooks"] = common.RelayHooks2JSONString()
+	common.OptionMap["ModelSyncTemplates"] = common.ModelSyncTemplates2JSONString()
+	common.OptionMap["SyncedModels"] = common.SyncedModels2JSONString()
+	common.OptionMap["ModelRoutingMode"] = common.ModelRoutingMode2JSONString()
+	common.OptionMap["ModelFallbacks"] = common.ModelFallbacks2JSONString()
+	common.OptionMap["ModelChannelGroups"] = common.ModelChannelGroups2JSONString()
+	common.OptionMap["GroupChannelGroups"] = common.GroupChannelGroups2JSONString()
+	common.OptionMap["ModerationChannelGroup"] = common.ModerationChannelGroup
+	common.OptionMap["SensitiveWords"] = strings.Join(common.SensitiveWords, "\n")
+	common.OptionMap["ContentModerationModel"] = common.ContentModerationModel
+	common.OptionMap["GroupModerationLevel"] = common.GroupModerationLevel2JSONString()
+	common.OptionMap["LatencyRoutingMaxErrorRate"] = strconv.FormatFloat(common.LatencyRoutingMaxErrorRate, 'f', -1, 64)
+	common.OptionMap["LatencyRoutingExploreRate"] = strconv.Itoa(common.LatencyRoutingExploreRate)
 	common.OptionMap["TopUpLink"] = common.TopUpLink
 	common.OptionMap["ChatLink"] = common.ChatLink
+	common.OptionMap["EpayAddress"] = ""
+	common.OptionMap["EpayId"] = ""
+	common.OptionMap["EpaySecret"] = ""
+	common.OptionMap["TopUpPrice"] = strconv.FormatFloat(common.TopUpPrice, 'f', -1, 64)
+	common.OptionMap["MinTopUp"] = strconv.Itoa(common.MinTopUp)
+	common.OptionMap["StatementCurrency"] = common.StatementCurrency
+	common.OptionMap["StatementExchangeRate"] = strconv.FormatFloat(common.StatementExchangeRate, 'f', -1, 64)
+	common.OptionMap["WebhookURL"] = ""
+	common.OptionMap["WebhookSecret"] = ""
+	common.OptionMap["QuotaTransferMin"] = strconv.Itoa(common.QuotaTransferMin)
+	common.OptionMap["QuotaTransferMax"] = strconv.Itoa(common.QuotaTransferMax)
+	common.OptionMap["QuotaTransferDailyLimit"] = strconv.Itoa(common.QuotaTransferDailyLimit)
+	common.OptionMap["QuotaTransferFeeRate"] = strconv.FormatFloat(common.QuotaTransferFeeRate, 'f', -1, 64)
+	common.OptionMap["DailyGrantQuota"] = strconv.Itoa(common.DailyGrantQuota)
+	common.OptionMap["DailyGrantGroup"] = common.DailyGrantGroup
+	common.OptionMap["DailyGrantActiveDays"] = strconv.Itoa(common.DailyGrantActiveDays)
+	common.OptionMap["PromoCreditExpireDays"] = strconv.Itoa(common.PromoCreditExpireDays)
+	common.OptionMap["RelayRateLimitNum"] = strconv.Itoa(common.RelayRateLimitNum)
+	common.OptionMap["ErrorPassthroughEnabled"] = strconv.FormatBool(common.ErrorPassthroughEnabled)
+	common.OptionMap["StickyRoutingEnabled"] = strconv.FormatBool(common.StickyRoutingEnabled)
+	common.OptionMap["FreeModerationEnabled"] = strconv.FormatBool(common.FreeModerationEnabled)
 	common.OptionMap["QuotaPerUnit"] = strconv.FormatFloat(common.QuotaPerUnit, 'f', -1, 64)
 	common.OptionMap["RetryTimes"] = strconv.Itoa(common.RetryTimes)
+	common.OptionMap["FileSizeLimit"] = strconv.Itoa(common.FileSizeLimit)
+	common.OptionMap["FileStorageQuota"] = strconv.Itoa(common.FileStorageQuota)
+	common.OptionMap["BatchRatio"] = strconv.FormatFloat(common.BatchRatio, 'f', -1, 64)
+	common.OptionMap["BatchUpstreamEnabled"] = strconv.FormatBool(common.BatchUpstreamEnabled)
+	common.OptionMap["EmbeddingChunkSize"] = strconv.Itoa(common.EmbeddingChunkSize)
+	common.OptionMap["CircuitBreakerFailureThreshold"] = strconv.Itoa(common.CircuitBreakerFailureThreshold)
+	common.OptionMap["CircuitBreakerOpenTime"] = strconv.Itoa(common.CircuitBreakerOpenTime)
+	common.OptionMap["ChannelKeyCooldownTime"] = strconv.Itoa(common.ChannelKeyCooldownTime)
+	common.OptionMap["ChannelRateLimitWaitTime"] = strconv.Itoa(common.ChannelRateLimitWaitTime)
+	common.OptionMap["ChannelQueueDepth"] = strconv.Itoa(common.ChannelQueueDepth)
+	common.OptionMap["StructuredOutputValidationEnabled"] = strconv.FormatBool(common.StructuredOutputValidationEnabled)
+	common.OptionMap["StructuredOutputRetryTimes"] = strconv.Itoa(common.StructuredOutputRetryTimes)
+	common.OptionMap["StreamUsageRequestEnabled"] = strconv.FormatBool(common.StreamUsageRequestEnabled)
+	common.OptionMap["StreamUsageVerificationRate"] = strconv.Itoa(common.StreamUsageVerificationRate)
+	common.OptionMap["LogSampleRate"] = strconv.Itoa(common.LogSampleRate)
+	common.OptionMap["ResponseCacheTTL"] = strconv.Itoa(common.ResponseCacheTTL)
+	common.OptionMap["ResponseCacheRatio"] = strconv.FormatFloat(common.ResponseCacheRatio, 'f', -1, 64)
+	common.OptionMap["SemanticCacheModel"] = common.SemanticCacheModel
+	common.OptionMap["SemanticCacheThreshold"] = strconv.FormatFloat(common.SemanticCacheThreshold, 'f', -1, 64)
+	common.OptionMap["RaceModels"] = strings.Join(common.RaceModels, ",")
 	common.OptionMapRWMutex.Unlock()
 	loadOptionsFromDatabase()
 }
@@ -137,6 +225,16 @@ func updateOptionMap(key string, value string) (err error) {
 			common.EmailVerificationEnabled = boolValue
 		case "GitHubOAuthEnabled":
 			common.GitHubOAuthEnabled = boolValue
+		case "LDAPAuthEnabled":
+			common.LDAPAuthEnabled = boolValue
+		case "LDAPAutoProvisionEnabled":
+			common.LDAPAutoProvisionEnabled = boolValue
+		case "LDAPStartTLSEnabled":
+			common.LDAPStartTLSEnabled = boolValue
+		case "LDAPInsecureTLSEnabled":
+			common.LDAPInsecureTLSEnabled = boolValue
+		case "TOTPRequiredForAdminEnabled":
+			common.TOTPRequiredForAdminEnabled = boolValue
 		case "WeChatAuthEnabled":
 			common.WeChatAuthEnabled = boolValue
 		case "TurnstileCheckEnabled":
@@ -147,6 +245,8 @@ func updateOptionMap(key string, value string) (err error) {
 			common.EmailDomainRestrictionEnabled = boolValue
 		case "AutomaticDisableChannelEnabled":
 			common.AutomaticDisableChannelEnabled = boolValue
+		case "AutomaticEnableChannelEnabled":
+			common.AutomaticEnableChannelEnabled = boolValue
 		case "ApproximateTokenEnabled":
 			common.ApproximateTokenEnabled = boolValue
 		case "LogConsumeEnabled":
@@ -155,11 +255,31 @@ func updateOptionMap(key string, value string) (err error) {
 			common.DisplayInCurrencyEnabled = boolValue
 		case "DisplayTokenStatEnabled":
 			common.DisplayTokenStatEnabled = boolValue
+		case "QuotaTransferEnabled":
+			common.QuotaTransferEnabled = boolValue
+		case "DailyGrantAccumulationEnabled":
+			common.DailyGrantAccumulationEnabled = boolValue
+		case "ErrorPassthroughEnabled":
+			common.ErrorPassthroughEnabled = boolValue
+		case "StickyRoutingEnabled":
+			common.StickyRoutingEnabled = boolValue
+		case "FreeModerationEnabled":
+			common.FreeModerationEnabled = boolValue
+		case "BatchUpstreamEnabled":
+			common.BatchUpstreamEnabled = boolValue
+		case "ModelDowngradeSuggestionEnabled":
+			common.ModelDowngradeSuggestionEnabled = boolValue
+		case "StreamUsageRequestEnabled":
+			common.StreamUsageRequestEnabled = boolValue
+		case "StructuredOutputValidationEnabled":
+			common.StructuredOutputValidationEnabled = boolValue
 		}
 	}
 	switch key {
 	case "EmailDomainWhitelist":
 		common.EmailDomainWhitelist = strings.Split(value, ",")
+	case "RaceModels":
+		common.RaceModels = strings.Split(value, ",")
 	case "SMTPServer":
 		common.SMTPServer = value
 	case "SMTPPort":
@@ -177,6 +297,20 @@ func updateOptionMap(key string, value string) (err error) {
 		common.GitHubClientId = value
 	case "GitHubClientSecret":
 		common.GitHubClientSecret = value
+	case "LDAPServerURL":
+		common.LDAPServerURL = value
+	case "LDAPBindDN":
+		common.LDAPBindDN = value
+	case "LDAPBindPassword":
+		common.LDAPBindPassword = value
+	case "LDAPBaseDN":
+		common.LDAPBaseDN = value
+	case "LDAPUsernameAttribute":
+		common.LDAPUsernameAttribute = value
+	case "LDAPGroupAttribute":
+		common.LDAPGroupAttribute = value
+	case "LDAPGroupRoles":
+		err = common.UpdateLDAPGroupRolesByJSONString(value)
 	case "Footer":
 		common.Footer = value
 	case "SystemName":
@@ -205,16 +339,146 @@ func updateOptionMap(key string, value string) (err error) {
 		common.PreConsumedQuota, _ = strconv.Atoi(value)
 	case "RetryTimes":
 		common.RetryTimes, _ = strconv.Atoi(value)
+	case "FileSizeLimit":
+		common.FileSizeLimit, _ = strconv.Atoi(value)
+	case "FileStorageQuota":
+		common.FileStorageQuota, _ = strconv.Atoi(value)
+	case "CircuitBreakerFailureThreshold":
+		common.CircuitBreakerFailureThreshold, _ = strconv.Atoi(value)
+	case "CircuitBreakerOpenTime":
+		common.CircuitBreakerOpenTime, _ = strconv.Atoi(value)
+	case "ChannelKeyCooldownTime":
+		common.ChannelKeyCooldownTime, _ = strconv.Atoi(value)
+	case "ChannelRateLimitWaitTime":
+		common.ChannelRateLimitWaitTime, _ = strconv.Atoi(value)
+	case "ChannelQueueDepth":
+		common.ChannelQueueDepth, _ = strconv.Atoi(value)
+	case "StructuredOutputRetryTimes":
+		common.StructuredOutputRetryTimes, _ = strconv.Atoi(value)
+	case "StreamUsageVerificationRate":
+		common.StreamUsageVerificationRate, _ = strconv.Atoi(value)
+	case "LogSampleRate":
+		common.LogSampleRate, _ = strconv.Atoi(value)
 	case "ModelRatio":
 		err = common.UpdateModelRatioByJSONString(value)
 	case "GroupRatio":
 		err = common.UpdateGroupRatioByJSONString(value)
+	case "CacheRatio":
+		err = common.UpdateCacheRatioByJSONString(value)
+	case "CacheCreationRatio":
+		err = common.UpdateCacheCreationRatioByJSONString(value)
+	case "AudioRatio":
+		err = common.UpdateAudioRatioByJSONString(value)
+	case "AudioCompletionRatio":
+		err = common.UpdateAudioCompletionRatioByJSONString(value)
+	case "ImagePrice":
+		err = common.UpdateImagePriceByJSONString(value)
+	case "TranscriptionPrice":
+		err = common.UpdateTranscriptionPriceByJSONString(value)
+	case "SpeechPrice":
+		err = common.UpdateSpeechPriceByJSONString(value)
+	case "FineTuningPrice":
+		err = common.UpdateFineTuningPriceByJSONString(value)
+	case "SpeechVoices":
+		err = common.UpdateSpeechVoicesByJSONString(value)
+	case "UpstreamPrices":
+		err = common.UpdateUpstreamPricesByJSONString(value)
+	case "ModelMinCharge":
+		err = common.UpdateModelMinChargeByJSONString(value)
+	case "ModelSurcharge":
+		err = common.UpdateModelSurchargeByJSONString(value)
+	case "GroupDataResidency":
+		err = common.UpdateGroupDataResidencyByJSONString(value)
+	case "GroupQuotaExhaustedResponse":
+		err = common.UpdateGroupQuotaExhaustedResponseByJSONString(value)
+	case "StreamSettings":
+		err = common.UpdateStreamSettingsByJSONString(value)
+	case "ModelContextWindow":
+		err = common.UpdateModelContextWindowByJSONString(value)
+	case "RelayHooks":
+		err = common.UpdateRelayHooksByJSONString(value)
+	case "ModelSyncTemplates":
+		err = common.UpdateModelSyncTemplatesByJSONString(value)
+	case "SyncedModels":
+		err = common.UpdateSyncedModelsByJSONString(value)
+	case "ModelRoutingMode":
+		err = common.UpdateModelRoutingModeByJSONString(value)
+	case "ModelFallbacks":
+		err = common.UpdateModelFallbacksByJSONString(value)
+	case "ModelChannelGroups":
+		err = common.UpdateModelChannelGroupsByJSONString(value)
+	case "GroupChannelGroups":
+		err = common.UpdateGroupChannelGroupsByJSONString(value)
+	case "ModerationChannelGroup":
+		common.ModerationChannelGroup = value
+	case "SensitiveWords":
+		common.UpdateSensitiveWordsByString(value)
+	case "ContentModerationModel":
+		common.ContentModerationModel = value
+	case "GroupModerationLevel":
+		err = common.UpdateGroupModerationLevelByJSONString(value)
+	case "LatencyRoutingMaxErrorRate":
+		common.LatencyRoutingMaxErrorRate, _ = strconv.ParseFloat(value, 64)
+	case "LatencyRoutingExploreRate":
+		common.LatencyRoutingExploreRate, _ = strconv.Atoi(value)
 	case "TopUpLink":
 		common.TopUpLink = value
 	case "ChatLink":
 		common.ChatLink = value
+	case "EpayAddress":
+		common.EpayAddress = value
+	case "EpayId":
+		common.EpayId = value
+	case "EpaySecret":
+		common.EpaySecret = value
+	case "TopUpPrice":
+		common.TopUpPrice, _ = strconv.ParseFloat(value, 64)
+	case "MinTopUp":
+		common.MinTopUp, _ = strconv.Atoi(value)
+	case "StatementCurrency":
+		common.StatementCurrency = value
+	case "StatementExchangeRate":
+		common.StatementExchangeRate, _ = strconv.ParseFloat(value, 64)
+	case "WebhookURL":
+		common.WebhookURL = value
+	case "WebhookSecret":
+		common.WebhookSecret = value
+	case "QuotaTransferMin":
+		common.QuotaTransferMin, _ = strconv.Atoi(value)
+	case "QuotaTransferMax":
+		common.QuotaTransferMax, _ = strconv.Atoi(value)
+	case "QuotaTransferDailyLimit":
+		common.QuotaTransferDailyLimit, _ = strconv.Atoi(value)
+	case "QuotaTransferFeeRate":
+		common.QuotaTransferFeeRate, _ = strconv.ParseFloat(value, 64)
+	case "DailyGrantQuota":
+		common.DailyGrantQuota, _ = strconv.Atoi(value)
+	case "DailyGrantGroup":
+		common.DailyGrantGroup = value
+	case "DailyGrantActiveDays":
+		common.DailyGrantActiveDays, _ = strconv.Atoi(value)
+	case "PromoCreditExpireDays":
+		common.PromoCreditExpireDays, _ = strconv.Atoi(value)
+	case "RelayRateLimitNum":
+		common.RelayRateLimitNum, _ = strconv.Atoi(value)
 	case "ChannelDisableThreshold":
 		common.ChannelDisableThreshold, _ = strconv.ParseFloat(value, 64)
+	case "BatchRatio":
+		common.BatchRatio, _ = strconv.ParseFloat(value, 64)
+	case "ResponseCacheTTL":
+		common.ResponseCacheTTL, _ = strconv.Atoi(value)
+	case "ResponseCacheRatio":
+		common.ResponseCacheRatio, _ = strconv.ParseFloat(value, 64)
+	case "SemanticCacheModel":
+		common.SemanticCacheModel = value
+	case "SemanticCacheThreshold":
+		common.SemanticCacheThreshold, _ = strconv.ParseFloat(value, 64)
+	case "EmbeddingChunkSize":
+		common.EmbeddingChunkSize, _ = strconv.Atoi(value)
+	case "ChannelProbeFailureThreshold":
+		common.ChannelProbeFailureThreshold, _ = strconv.Atoi(value)
+	case "ChannelProbeRecoveryThreshold":
+		common.ChannelProbeRecoveryThreshold, _ = strconv.Atoi(value)
 	case "QuotaPerUnit":
 		common.QuotaPerUnit, _ = strconv.ParseFloat(value, 64)
 	}
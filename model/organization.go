@@ -0,0 +1,288 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"one-api/common"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	OrganizationRoleMember = 1
+	OrganizationRoleAdmin  = 10 // manages the members and their budgets and sees the usage of all of them
+)
+
+var (
+	ErrOrganizationQuotaInsufficient = errors.New("组织额度不足")
+	ErrOrganizationBudgetExceeded    = errors.New("超出组织分配给你的预算")
+)
+
+// Organization is a team of users sharing a quota pool, the tokens of its members which are bound to it draw from
+// the pool instead of the quota of their users
+type Organization struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"type:varchar(64);uniqueIndex"`
+	Quota       int    `json:"quota" gorm:"default:0"`
+	UsedQuota   int    `json:"used_quota" gorm:"default:0"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+// OrganizationMember is the membership of a user, the budget caps what the tokens of the user may draw from the pool
+type OrganizationMember struct {
+	Id             int   `json:"id"`
+	OrganizationId int   `json:"organization_id" gorm:"uniqueIndex:idx_organization_member"`
+	UserId         int   `json:"user_id" gorm:"uniqueIndex:idx_organization_member;index"`
+	Role           int   `json:"role" gorm:"default:1"`
+	Budget         int   `json:"budget" gorm:"default:0"` // 0 means the member may use the whole pool
+	UsedQuota      int   `json:"used_quota" gorm:"default:0"`
+	CreatedTime    int64 `json:"created_time" gorm:"bigint"`
+}
+
+// OrganizationMemberInfo is a membership with the names of the user, for the lists
+type OrganizationMemberInfo struct {
+	OrganizationMember
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+}
+
+// UserOrganization is an organization a user belongs to, with the membership of the user
+type UserOrganization struct {
+	Organization
+	Role       int `json:"role"`
+	Budget     int `json:"budget"`
+	UsedBudget int `json:"used_budget"`
+}
+
+func IsQuotaInsufficientError(err error) bool {
+	return errors.Is(err, ErrTokenQuotaInsufficient) || errors.Is(err, ErrUserQuotaInsufficient) ||
+		errors.Is(err, ErrOrganizationQuotaInsufficient) || errors.Is(err, ErrOrganizationBudgetExceeded)
+}
+
+func GetAllOrganizations(startIdx int, num int) (organizations []*Organization, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&organizations).Error
+	return organizations, err
+}
+
+func GetOrganizationById(id int) (*Organization, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	organization := Organization{}
+	err := DB.First(&organization, "id = ?", id).Error
+	return &organization, err
+}
+
+func (organization *Organization) Insert() error {
+	return DB.Create(organization).Error
+}
+
+// Update sets the quota of the pool, which may be zero
+func (organization *Organization) Update() error {
+	return DB.Model(organization).Select("name", "quota").Updates(organization).Error
+}
+
+// DeleteOrganizationById removes the members too, the tokens bound to the organization stop working rather than
+// falling back to the quota of their users
+func DeleteOrganizationById(id int) error {
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Delete(&Organization{}, "id = ?", id)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("组织不存在")
+		}
+		return tx.Where("organization_id = ?", id).Delete(&OrganizationMember{}).Error
+	})
+}
+
+func GetUserOrganizations(userId int) (organizations []*UserOrganization, err error) {
+	err = DB.Table("organizations").
+		Select("organizations.*, organization_members.role, organization_members.budget, organization_members.used_quota as used_budget").
+		Joins("join organization_members on organization_members.organization_id = organizations.id").
+		Where("organization_members.user_id = ?", userId).Order("organizations.id desc").Scan(&organizations).Error
+	return organizations, err
+}
+
+func GetOrganizationMember(organizationId int, userId int) (*OrganizationMember, error) {
+	member := OrganizationMember{}
+	err := DB.First(&member, "organization_id = ? and user_id = ?", organizationId, userId).Error
+	if err != nil {
+		return nil, errors.New("不是该组织的成员")
+	}
+	return &member, nil
+}
+
+func GetOrganizationMembers(organizationId int) (members []*OrganizationMemberInfo, err error) {
+	err = DB.Table("organization_members").
+		Select("organization_members.*, users.username, users.display_name").
+		Joins("left join users on users.id = organization_members.user_id").
+		Where("organization_members.organization_id = ?", organizationId).Order("organization_members.id").Scan(&members).Error
+	return members, err
+}
+
+func IsValidOrganizationRole(role int) bool {
+	return role == OrganizationRoleMember || role == OrganizationRoleAdmin
+}
+
+func AddOrganizationMember(organizationId int, username string, role int, budget int) (*OrganizationMember, error) {
+	user := User{}
+	err := DB.Select("id").First(&user, "username = ?", username).Error
+	if err != nil {
+		return nil, errors.New("用户不存在")
+	}
+	if _, err = GetOrganizationMember(organizationId, user.Id); err == nil {
+		return nil, errors.New("该用户已是组织成员")
+	}
+	member := &OrganizationMember{
+		OrganizationId: organizationId,
+		UserId:         user.Id,
+		Role:           role,
+		Budget:         budget,
+		CreatedTime:    common.GetTimestamp(),
+	}
+	return member, DB.Create(member).Error
+}
+
+// UpdateOrganizationMember sets the role and the budget, the budget may be set to zero
+func UpdateOrganizationMember(organizationId int, userId int, role int, budget int) error {
+	result := DB.Model(&OrganizationMember{}).Where("organization_id = ? and user_id = ?", organizationId, userId).
+		Updates(map[string]interface{}{
+			"role":   role,
+			"budget": budget,
+		})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errors.New("不是该组织的成员")
+	}
+	return result.Error
+}
+
+func DeleteOrganizationMember(organizationId int, userId int) error {
+	result := DB.Where("organization_id = ? and user_id = ?", organizationId, userId).Delete(&OrganizationMember{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errors.New("不是该组织的成员")
+	}
+	return result.Error
+}
+
+func CountOrganizationAdmins(organizationId int) (count int64, err error) {
+	err = DB.Model(&OrganizationMember{}).Where("organization_id = ? and role = ?", organizationId, OrganizationRoleAdmin).Count(&count).Error
+	return count, err
+}
+
+// GetOrganizationAvailableQuota is what the tokens of the member may still draw from the pool
+func GetOrganizationAvailableQuota(organizationId int, userId int) (int, error) {
+	organization, err := GetOrganizationById(organizationId)
+	if err != nil {
+		return 0, errors.New("组织不存在")
+	}
+	member, err := GetOrganizationMember(organizationId, userId)
+	if err != nil {
+		return 0, err
+	}
+	quota := organization.Quota
+	if member.Budget > 0 && member.Budget-member.UsedQuota < quota {
+		quota = member.Budget - member.UsedQuota
+	}
+	return quota, nil
+}
+
+// CacheGetAvailableQuota is the quota a request may spend, from the pool if the token is bound to an organization,
+// from the user otherwise
+func CacheGetAvailableQuota(userId int, organizationId int) (int, error) {
+	if organizationId != 0 {
+		return GetOrganizationAvailableQuota(organizationId, userId)
+	}
+	return CacheGetUserAvailableQuota(userId)
+}
+
+// preConsumeOrganizationQuota draws the quota from the pool within the budget of the member, the conditions of the
+// updates keep the concurrent requests from overdrawing them
+func preConsumeOrganizationQuota(organizationId int, userId int, quota int) error {
+	if quota == 0 {
+		// MySQL reports no affected rows for the updates which change nothing
+		_, err := GetOrganizationMember(organizationId, userId)
+		return err
+	}
+	return DB.Transaction(func(tx *gorm.DB) error {
+		result := tx.Model(&OrganizationMember{}).
+			Where("organization_id = ? and user_id = ? and (budget = 0 or used_quota + ? <= budget)", organizationId, userId, quota).
+			Update("used_quota", gorm.Expr("used_quota + ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			if tx.Where("organization_id = ? and user_id = ?", organizationId, userId).Find(&OrganizationMember{}).RowsAffected == 0 {
+				return errors.New("不是该组织的成员")
+			}
+			return ErrOrganizationBudgetExceeded
+		}
+		result = tx.Model(&Organization{}).Where("id = ? and quota >= ?", organizationId, quota).Updates(
+			map[string]interface{}{
+				"quota":      gorm.Expr("quota - ?", quota),
+				"used_quota": gorm.Expr("used_quota + ?", quota),
+			},
+		)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return ErrOrganizationQuotaInsufficient
+		}
+		return nil
+	})
+}
+
+// postConsumeOrganizationQuota charges the difference with the pre-consumed quota, negative gives it back, the
+// member may have left meanwhile
+func postConsumeOrganizationQuota(organizationId int, userId int, quota int) error {
+	err := DB.Model(&Organization{}).Where("id = ?", organizationId).Updates(
+		map[string]interface{}{
+			"quota":      gorm.Expr("quota - ?", quota),
+			"used_quota": gorm.Expr("used_quota + ?", quota),
+		},
+	).Error
+	if err != nil {
+		return err
+	}
+	return DB.Model(&OrganizationMember{}).Where("organization_id = ? and user_id = ?", organizationId, userId).
+		Update("used_quota", gorm.Expr("used_quota + ?", quota)).Error
+}
+
+// FundOrganization moves quota of a member into the pool, the credit limit and the expiring credits can't be moved
+func FundOrganization(organizationId int, userId int, quota int) error {
+	if quota <= 0 {
+		return errors.New("额度必须大于 0")
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		user := &User{}
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", userId).First(user).Error
+		if err != nil {
+			return err
+		}
+		if int64(user.Quota)-getActiveCreditQuota(tx, user.Id) < int64(quota) {
+			return errors.New("额度不足")
+		}
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", user.Id, quota).Update("quota", gorm.Expr("quota - ?", quota))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("额度不足")
+		}
+		result = tx.Model(&Organization{}).Where("id = ?", organizationId).Update("quota", gorm.Expr("quota + ?", quota))
+		if result.Error == nil && result.RowsAffected == 0 {
+			return errors.New("组织不存在")
+		}
+		return result.Error
+	})
+	if err != nil {
+		return err
+	}
+	_ = CacheUpdateUserQuota(userId)
+	organization, _ := GetOrganizationById(organizationId)
+	RecordLog(userId, LogTypeTransfer, fmt.Sprintf("向组织 %s 注入额度 %s", organization.Name, common.LogQuota(quota)))
+	return nil
+}
@@ -0,0 +1,298 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"one-api/common"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Policy is a list of rules attached to a group or a channel. Rules are declarative JSON,
+// nothing is executed, so admins can define custom checks without being able to harm the server:
+//
+//	[
+//	  {"when": [{"field": "content", "op": "regex", "value": "(?i)password"}], "action": "deny", "message": "..."},
+//	  {"when": [{"field": "model", "op": "prefix", "value": "gpt-4"}], "action": "set_body", "key": "max_tokens", "value": 1024},
+//	  {"action": "set_header", "key": "X-Tenant", "value": "vip"}
+//	]
+type Policy struct {
+	Id          int    `json:"id"`
+	Name        string `json:"name" gorm:"index"`
+	Scope       string `json:"scope" gorm:"type:varchar(16);index"`  // group or channel
+	Target      string `json:"target" gorm:"type:varchar(64);index"` // the group name, or the channel id
+	Priority    int    `json:"priority" gorm:"default:0"`            // policies with lower priority run first
+	Rules       string `json:"rules" gorm:"type:text"`
+	Status      int    `json:"status" gorm:"default:1"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+
+	rules []*PolicyRule
+}
+
+const (
+	PolicyScopeGroup   = "group"
+	PolicyScopeChannel = "channel"
+)
+
+const (
+	PolicyActionDeny       = "deny"
+	PolicyActionSetHeader  = "set_header"
+	PolicyActionSetBody    = "set_body"
+	PolicyActionDeleteBody = "delete_body"
+)
+
+type PolicyCondition struct {
+	Field string      `json:"field"` // model, group, channel_id, user_id, token_name, path, content, header.<name>, body.<key>
+	Op    string      `json:"op"`    // eq, ne, prefix, suffix, contains, regex, gt, lt, in, exists, not_exists
+	Value interface{} `json:"value"`
+
+	regex *regexp.Regexp
+}
+
+type PolicyRule struct {
+	When    []*PolicyCondition `json:"when"`
+	Action  string             `json:"action"`
+	Key     string             `json:"key"`
+	Value   interface{}        `json:"value"`
+	Message string             `json:"message"`
+}
+
+// PolicyRequest is what the rules can see of a request
+type PolicyRequest struct {
+	Fields  map[string]string
+	Headers map[string]string
+	Body    map[string]interface{}
+	Content string
+}
+
+func (request *PolicyRequest) lookup(field string) (string, bool) {
+	switch {
+	case field == "content":
+		return request.Content, true
+	case strings.HasPrefix(field, "header."):
+		value, ok := request.Headers[strings.ToLower(strings.TrimPrefix(field, "header."))]
+		return value, ok
+	case strings.HasPrefix(field, "body."):
+		var current interface{} = request.Body
+		for _, key := range strings.Split(strings.TrimPrefix(field, "body."), ".") {
+			object, ok := current.(map[string]interface{})
+			if !ok {
+				return "", false
+			}
+			current, ok = object[key]
+			if !ok {
+				return "", false
+			}
+		}
+		if s, ok := current.(string); ok {
+			return s, true
+		}
+		data, _ := json.Marshal(current)
+		return string(data), true
+	default:
+		value, ok := request.Fields[field]
+		return value, ok
+	}
+}
+
+func (condition *PolicyCondition) compile() error {
+	if condition.Field == "" {
+		return errors.New("条件缺少 field")
+	}
+	switch condition.Op {
+	case "eq", "ne", "prefix", "suffix", "contains", "gt", "lt", "in", "exists", "not_exists":
+	case "regex":
+		pattern, ok := condition.Value.(string)
+		if !ok {
+			return errors.New("regex 条件的 value 必须是字符串")
+		}
+		regex, err := regexp.Compile(pattern)
+		if err != nil {
+			return err
+		}
+		condition.regex = regex
+	default:
+		return fmt.Errorf("不支持的条件运算符 %s", condition.Op)
+	}
+	return nil
+}
+
+func policyValueString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	data, _ := json.Marshal(value)
+	return string(data)
+}
+
+func (condition *PolicyCondition) match(request *PolicyRequest) bool {
+	actual, ok := request.lookup(condition.Field)
+	switch condition.Op {
+	case "exists":
+		return ok
+	case "not_exists":
+		return !ok
+	}
+	if !ok {
+		return condition.Op == "ne"
+	}
+	expected := policyValueString(condition.Value)
+	switch condition.Op {
+	case "eq":
+		return actual == expected
+	case "ne":
+		return actual != expected
+	case "prefix":
+		return strings.HasPrefix(actual, expected)
+	case "suffix":
+		return strings.HasSuffix(actual, expected)
+	case "contains":
+		return strings.Contains(actual, expected)
+	case "regex":
+		return condition.regex.MatchString(actual)
+	case "gt", "lt":
+		actualNumber, err1 := strconv.ParseFloat(actual, 64)
+		expectedNumber, err2 := strconv.ParseFloat(expected, 64)
+		if err1 != nil || err2 != nil {
+			return false
+		}
+		if condition.Op == "gt" {
+			return actualNumber > expectedNumber
+		}
+		return actualNumber < expectedNumber
+	case "in":
+		values, ok := condition.Value.([]interface{})
+		if !ok {
+			return false
+		}
+		for _, value := range values {
+			if policyValueString(value) == actual {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Matches reports whether all the conditions of the rule hold, a rule without conditions always matches
+func (rule *PolicyRule) Matches(request *PolicyRequest) bool {
+	for _, condition := range rule.When {
+		if !condition.match(request) {
+			return false
+		}
+	}
+	return true
+}
+
+func ParsePolicyRules(rules string) ([]*PolicyRule, error) {
+	var parsed []*PolicyRule
+	err := json.Unmarshal([]byte(rules), &parsed)
+	if err != nil {
+		return nil, fmt.Errorf("规则不是合法的 JSON：%s", err.Error())
+	}
+	for i, rule := range parsed {
+		switch rule.Action {
+		case PolicyActionDeny:
+		case PolicyActionSetHeader, PolicyActionSetBody, PolicyActionDeleteBody:
+			if rule.Key == "" {
+				return nil, fmt.Errorf("第 %d 条规则缺少 key", i+1)
+			}
+		default:
+			return nil, fmt.Errorf("第 %d 条规则的动作 %s 不受支持", i+1, rule.Action)
+		}
+		for _, condition := range rule.When {
+			if err := condition.compile(); err != nil {
+				return nil, fmt.Errorf("第 %d 条规则：%s", i+1, err.Error())
+			}
+		}
+	}
+	return parsed, nil
+}
+
+var scope2target2policies map[string]map[string][]*Policy
+var policySyncLock sync.RWMutex
+
+func InitPolicyCache() {
+	var policies []*Policy
+	DB.Where("status = ?", common.PolicyStatusEnabled).Order("priority, id").Find(&policies)
+	newScope2target2policies := map[string]map[string][]*Policy{
+		PolicyScopeGroup:   {},
+		PolicyScopeChannel: {},
+	}
+	for _, policy := range policies {
+		rules, err := ParsePolicyRules(policy.Rules)
+		if err != nil {
+			common.SysError(fmt.Sprintf("invalid rules of policy #%d: %s", policy.Id, err.Error()))
+			continue
+		}
+		policy.rules = rules
+		targets, ok := newScope2target2policies[policy.Scope]
+		if !ok {
+			continue
+		}
+		targets[policy.Target] = append(targets[policy.Target], policy)
+	}
+	policySyncLock.Lock()
+	scope2target2policies = newScope2target2policies
+	policySyncLock.Unlock()
+}
+
+func SyncPolicyCache(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		InitPolicyCache()
+	}
+}
+
+// GetPolicyRules returns the rules which apply to a request, in the order they should run
+func GetPolicyRules(group string, channelId int) []*PolicyRule {
+	policySyncLock.RLock()
+	policies := append([]*Policy{}, scope2target2policies[PolicyScopeGroup][group]...)
+	policies = append(policies, scope2target2policies[PolicyScopeChannel][strconv.Itoa(channelId)]...)
+	policySyncLock.RUnlock()
+	sort.SliceStable(policies, func(i, j int) bool {
+		return policies[i].Priority < policies[j].Priority
+	})
+	var rules []*PolicyRule
+	for _, policy := range policies {
+		rules = append(rules, policy.rules...)
+	}
+	return rules
+}
+
+func GetAllPolicies(startIdx int, num int) (policies []*Policy, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&policies).Error
+	return policies, err
+}
+
+func GetPolicyById(id int) (*Policy, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	policy := Policy{Id: id}
+	err := DB.First(&policy, "id = ?", id).Error
+	return &policy, err
+}
+
+func (policy *Policy) Insert() error {
+	err := DB.Create(policy).Error
+	InitPolicyCache()
+	return err
+}
+
+func (policy *Policy) Update() error {
+	err := DB.Model(policy).Select("name", "scope", "target", "priority", "rules", "status").Updates(policy).Error
+	InitPolicyCache()
+	return err
+}
+
+func (policy *Policy) Delete() error {
+	err := DB.Delete(policy).Error
+	InitPolicyCache()
+	return err
+}
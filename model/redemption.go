@@ -8,15 +8,29 @@ import (
 )
 
 type Redemption struct {
-	Id           int    `json:"id"`
-	UserId       int    `json:"user_id"`
-	Key          string `json:"key" gorm:"type:char(32);uniqueIndex"`
-	Status       int    `json:"status" gorm:"default:1"`
-	Name         string `json:"name" gorm:"index"`
-	Quota        int    `json:"quota" gorm:"default:100"`
-	CreatedTime  int64  `json:"created_time" gorm:"bigint"`
-	RedeemedTime int64  `json:"redeemed_time" gorm:"bigint"`
-	Count        int    `json:"count" gorm:"-:all"` // only for api request
+	Id             int    `json:"id"`
+	UserId         int    `json:"user_id"`
+	Key            string `json:"key" gorm:"type:char(32);uniqueIndex"`
+	Status         int    `json:"status" gorm:"default:1"`
+	Name           string `json:"name" gorm:"index"`
+	Quota          int    `json:"quota" gorm:"default:100"`
+	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
+	RedeemedTime   int64  `json:"redeemed_time" gorm:"bigint"`
+	Batch          string `json:"batch" gorm:"type:varchar(32);index"`
+	ExpiredTime    int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	MaxRedemptions int    `json:"max_redemptions" gorm:"default:1"`      // how many different users can redeem it
+	RedeemedCount  int    `json:"redeemed_count" gorm:"default:0"`
+	CreditDays     int    `json:"credit_days" gorm:"default:0"` // the redeemed quota expires after N days, 0 means never
+	Count          int    `json:"count" gorm:"-:all"`           // only for api request
+	Prefix         string `json:"prefix" gorm:"-:all"`          // only for api request
+}
+
+// RedemptionRecord remembers who has redeemed a code, so that a code with several redemptions can't be used twice by the same user
+type RedemptionRecord struct {
+	Id           int   `json:"id"`
+	RedemptionId int   `json:"redemption_id" gorm:"uniqueIndex:idx_redemption_user"`
+	UserId       int   `json:"user_id" gorm:"uniqueIndex:idx_redemption_user;index"`
+	CreatedTime  int64 `json:"created_time" gorm:"bigint"`
 }
 
 func GetAllRedemptions(startIdx int, num int) ([]*Redemption, error) {
@@ -51,26 +65,60 @@ func Redeem(key string, userId int) (quota int, err error) {
 	redemption := &Redemption{}
 
 	err = DB.Transaction(func(tx *gorm.DB) error {
-		err := tx.Set("gorm:query_option", "FOR UPDATE").Where("`key` = ?", key).First(redemption).Error
+		err := tx.Where("`key` = ?", key).First(redemption).Error
 		if err != nil {
 			return errors.New("无效的兑换码")
 		}
+		if redemption.Status == common.RedemptionCodeStatusDisabled {
+			return errors.New("该兑换码已被禁用")
+		}
 		if redemption.Status != common.RedemptionCodeStatusEnabled {
 			return errors.New("该兑换码已被使用")
 		}
+		if redemption.ExpiredTime != -1 && redemption.ExpiredTime < common.GetTimestamp() {
+			return errors.New("该兑换码已过期")
+		}
+		var redeemed int64
+		tx.Model(&RedemptionRecord{}).Where("redemption_id = ? and user_id = ?", redemption.Id, userId).Count(&redeemed)
+		if redeemed > 0 {
+			return errors.New("您已使用过该兑换码")
+		}
+		// the condition keeps the concurrent redemptions within the limit of the code
+		result := tx.Model(&Redemption{}).
+			Where("id = ? and status = ? and redeemed_count < max_redemptions", redemption.Id, common.RedemptionCodeStatusEnabled).
+			Updates(map[string]interface{}{
+				"redeemed_count": gorm.Expr("redeemed_count + 1"),
+				"redeemed_time":  common.GetTimestamp(),
+			})
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return errors.New("该兑换码已被使用")
+		}
+		err = tx.Model(&Redemption{}).Where("id = ? and redeemed_count >= max_redemptions", redemption.Id).
+			Update("status", common.RedemptionCodeStatusUsed).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&RedemptionRecord{RedemptionId: redemption.Id, UserId: userId, CreatedTime: common.GetTimestamp()}).Error
+		if err != nil {
+			return err
+		}
 		err = tx.Model(&User{}).Where("id = ?", userId).Update("quota", gorm.Expr("quota + ?", redemption.Quota)).Error
 		if err != nil {
 			return err
 		}
-		redemption.RedeemedTime = common.GetTimestamp()
-		redemption.Status = common.RedemptionCodeStatusUsed
-		err = tx.Save(redemption).Error
-		return err
+		return addCreditBucket(tx, userId, redemption.Quota, redemption.CreditDays, CreditSourceRedemption)
 	})
 	if err != nil {
 		return 0, errors.New("兑换失败，" + err.Error())
 	}
-	RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
+	if redemption.CreditDays > 0 {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s，%d 天后过期", common.LogQuota(redemption.Quota), redemption.CreditDays))
+	} else {
+		RecordLog(userId, LogTypeTopup, fmt.Sprintf("通过兑换码充值 %s", common.LogQuota(redemption.Quota)))
+	}
 	return redemption.Quota, nil
 }
 
@@ -80,6 +128,32 @@ func (redemption *Redemption) Insert() error {
 	return err
 }
 
+// InsertRedemptions inserts a batch of codes in chunks, so that thousands of codes don't make a single huge statement
+func InsertRedemptions(redemptions []*Redemption) error {
+	return DB.CreateInBatches(redemptions, 50).Error
+}
+
+func GetRedemptionsByBatch(batch string) (redemptions []*Redemption, err error) {
+	err = DB.Where("batch = ?", batch).Order("id").Find(&redemptions).Error
+	return redemptions, err
+}
+
+// RevokeRedemptions disables the unused codes of a batch or with the given ids, and returns how many were revoked
+func RevokeRedemptions(batch string, ids []int) (int64, error) {
+	if batch == "" && len(ids) == 0 {
+		return 0, errors.New("请指定批次或兑换码 id")
+	}
+	tx := DB.Model(&Redemption{}).Where("status = ?", common.RedemptionCodeStatusEnabled)
+	if batch != "" {
+		tx = tx.Where("batch = ?", batch)
+	}
+	if len(ids) > 0 {
+		tx = tx.Where("id in ?", ids)
+	}
+	result := tx.Update("status", common.RedemptionCodeStatusDisabled)
+	return result.RowsAffected, result.Error
+}
+
 func (redemption *Redemption) SelectUpdate() error {
 	// This can update zero values
 	return DB.Model(redemption).Select("redeemed_time", "status").Updates(redemption).Error
@@ -88,7 +162,7 @@ func (redemption *Redemption) SelectUpdate() error {
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (redemption *Redemption) Update() error {
 	var err error
-	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time").Updates(redemption).Error
+	err = DB.Model(redemption).Select("name", "status", "quota", "redeemed_time", "expired_time", "max_redemptions", "credit_days").Updates(redemption).Error
 	return err
 }
 
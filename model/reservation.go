@@ -0,0 +1,137 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+	"time"
+)
+
+// Reservation records the quota held for an in-flight request.
+// It is created before the request is relayed and settled with the exact amount afterwards,
+// so that a request which never finishes (crash, killed stream) can still be released later.
+type Reservation struct {
+	Id           int   `json:"id"`
+	UserId       int   `json:"user_id" gorm:"index"`
+	TokenId      int   `json:"token_id" gorm:"index"`
+	Quota        int   `json:"quota" gorm:"default:0"`
+	SettledQuota int   `json:"settled_quota" gorm:"default:0"`
+	Status       int   `json:"status" gorm:"index;index:idx_reservations_status_created,priority:1;default:1"`
+	CreatedTime  int64 `json:"created_time" gorm:"bigint;index;index:idx_reservations_status_created,priority:2"`
+	SettledTime  int64 `json:"settled_time" gorm:"bigint"`
+}
+
+// ReserveQuota pre-consumes the estimated quota and records the reservation.
+func ReserveQuota(tokenId int, quota int) (*Reservation, error) {
+	token, err := GetTokenById(tokenId)
+	if err != nil {
+		return nil, err
+	}
+	err = PreConsumeTokenQuota(tokenId, quota)
+	if err != nil {
+		return nil, err
+	}
+	reservation := &Reservation{
+		UserId:      token.UserId,
+		TokenId:     tokenId,
+		Quota:       quota,
+		Status:      common.ReservationStatusReserved,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err = DB.Create(reservation).Error
+	if err != nil {
+		// we cannot track it, so give the quota back right now
+		_ = PostConsumeTokenQuota(tokenId, -quota)
+		return nil, err
+	}
+	return reservation, nil
+}
+
+// finish marks the reservation as done, it returns false if someone else has finished it already.
+func (reservation *Reservation) finish(status int, settledQuota int) (bool, error) {
+	result := DB.Model(&Reservation{}).Where("id = ? and status = ?", reservation.Id, common.ReservationStatusReserved).Updates(
+		map[string]interface{}{
+			"status":        status,
+			"settled_quota": settledQuota,
+			"settled_time":  common.GetTimestamp(),
+		},
+	)
+	if result.Error != nil {
+		return false, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return false, nil
+	}
+	reservation.Status = status
+	reservation.SettledQuota = settledQuota
+	return true, nil
+}
+
+// Settle charges the exact quota by applying the difference against the reserved amount.
+func (reservation *Reservation) Settle(quota int) error {
+	ok, err := reservation.finish(common.ReservationStatusSettled, quota)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		// the reservation has been released by the sweeper, so nothing is held for us anymore
+		common.SysError(fmt.Sprintf("reservation #%d has already been released, charging directly", reservation.Id))
+		return PostConsumeTokenQuota(reservation.TokenId, quota)
+	}
+	return PostConsumeTokenQuota(reservation.TokenId, quota-reservation.Quota)
+}
+
+// Release gives all the reserved quota back.
+func (reservation *Reservation) Release() error {
+	ok, err := reservation.finish(common.ReservationStatusReleased, 0)
+	if err != nil || !ok {
+		return err
+	}
+	return PostConsumeTokenQuota(reservation.TokenId, -reservation.Quota)
+}
+
+// SettleQuota settles the reservation if there is one, otherwise it charges the quota directly.
+func SettleQuota(reservation *Reservation, tokenId int, quota int) error {
+	if reservation == nil {
+		return PostConsumeTokenQuota(tokenId, quota)
+	}
+	return reservation.Settle(quota)
+}
+
+func ReleaseExpiredReservations(timeout int64) (int, error) {
+	var reservations []*Reservation
+	err := DB.Where("status = ? and created_time < ?", common.ReservationStatusReserved, common.GetTimestamp()-timeout).Find(&reservations).Error
+	if err != nil {
+		return 0, err
+	}
+	released := 0
+	for _, reservation := range reservations {
+		err = reservation.Release()
+		if err != nil {
+			common.SysError(fmt.Sprintf("failed to release reservation #%d: %s", reservation.Id, err.Error()))
+			continue
+		}
+		if reservation.Status != common.ReservationStatusReleased {
+			continue
+		}
+		err = CacheUpdateUserQuota(reservation.UserId)
+		if err != nil {
+			common.SysError("error update user quota cache: " + err.Error())
+		}
+		released++
+	}
+	return released, nil
+}
+
+func SweepExpiredReservations(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		released, err := ReleaseExpiredReservations(int64(common.ReservationTimeout))
+		if err != nil {
+			common.SysError("failed to release expired reservations: " + err.Error())
+			continue
+		}
+		if released > 0 {
+			common.SysLog(fmt.Sprintf("released %d expired reservations", released))
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package model
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"one-api/common"
+	"time"
+)
+
+const (
+	SpendingAlertTypeDaily = 1 // the quota used today exceeds the threshold
+	SpendingAlertTypeTotal = 2 // the used quota crosses the threshold
+)
+
+const (
+	SpendingAlertNotifierEmail   = "email"
+	SpendingAlertNotifierWebhook = "webhook"
+)
+
+const MaxSpendingAlertsPerUser = 20
+
+// SpendingAlert is a rule created by a user for their own spending, of all their tokens or of one of them
+type SpendingAlert struct {
+	Id                int    `json:"id"`
+	UserId            int    `json:"user_id" gorm:"index"`
+	Name              string `json:"name" gorm:"type:varchar(32)"`
+	Type              int    `json:"type"`
+	TokenId           int    `json:"token_id" gorm:"default:0"` // 0 means all the tokens of the user
+	Threshold         int    `json:"threshold"`
+	Notifier          string `json:"notifier" gorm:"type:varchar(16)"`
+	WebhookURL        string `json:"webhook_url" gorm:"type:varchar(512);column:webhook_url"`
+	Status            int    `json:"status" gorm:"default:1"`
+	CreatedTime       int64  `json:"created_time" gorm:"bigint"`
+	TriggeredPeriod   string `json:"triggered_period" gorm:"type:varchar(10);default:''"` // the day of a daily alert, "total" for the others, so that it fires once
+	LastTriggeredTime int64  `json:"last_triggered_time" gorm:"bigint"`
+	LastError         string `json:"last_error"`
+}
+
+func GetUserSpendingAlerts(userId int) (alerts []*SpendingAlert, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Find(&alerts).Error
+	return alerts, err
+}
+
+func GetSpendingAlertByIds(id int, userId int) (*SpendingAlert, error) {
+	if id == 0 || userId == 0 {
+		return nil, errors.New("id 或 userId 为空！")
+	}
+	alert := SpendingAlert{}
+	err := DB.First(&alert, "id = ? and user_id = ?", id, userId).Error
+	return &alert, err
+}
+
+func CountUserSpendingAlerts(userId int) (count int64, err error) {
+	err = DB.Model(&SpendingAlert{}).Where("user_id = ?", userId).Count(&count).Error
+	return count, err
+}
+
+func (alert *SpendingAlert) Insert() error {
+	return DB.Create(alert).Error
+}
+
+// Update also rearms the alert, so that it fires again when the new threshold is crossed
+func (alert *SpendingAlert) Update() error {
+	alert.TriggeredPeriod = ""
+	alert.LastError = ""
+	return DB.Model(alert).Select("name", "type", "token_id", "threshold", "notifier", "webhook_url", "status",
+		"triggered_period", "last_error").Updates(alert).Error
+}
+
+func DeleteSpendingAlertByIds(id int, userId int) error {
+	result := DB.Where("id = ? and user_id = ?", id, userId).Delete(&SpendingAlert{})
+	if result.Error == nil && result.RowsAffected == 0 {
+		return errors.New("提醒规则不存在")
+	}
+	return result.Error
+}
+
+func (alert *SpendingAlert) getPeriod() string {
+	if alert.Type == SpendingAlertTypeDaily {
+		return time.Now().Format("2006-01-02")
+	}
+	return "total"
+}
+
+// getSpending is the quota used today or in total, of the token if the alert has one
+func (alert *SpendingAlert) getSpending(token *Token) (int64, error) {
+	var spending int64
+	var err error
+	if alert.Type == SpendingAlertTypeDaily {
+		query := DB.Model(&UserUsage{}).Select("COALESCE(sum(quota), 0)").Where("user_id = ? and day = ?", alert.UserId, alert.getPeriod())
+		if token != nil {
+			query = query.Where("token_name = ?", token.Name)
+		}
+		err = query.Scan(&spending).Error
+	} else if token != nil {
+		spending = int64(token.UsedQuota)
+	} else {
+		err = DB.Model(&User{}).Where("id = ?", alert.UserId).Select("used_quota").Scan(&spending).Error
+	}
+	return spending, err
+}
+
+func (alert *SpendingAlert) notify(token *Token, spending int64) error {
+	scope := "您的账户"
+	if token != nil {
+		scope = fmt.Sprintf("令牌 %s", token.Name)
+	}
+	period := "累计已使用"
+	if alert.Type == SpendingAlertTypeDaily {
+		period = "今日已使用"
+	}
+	content := fmt.Sprintf("%s%s %s，已超过提醒规则 %s 设置的 %s。", scope, period, common.LogQuota(int(spending)), alert.Name, common.LogQuota(alert.Threshold))
+	switch alert.Notifier {
+	case SpendingAlertNotifierWebhook:
+		payload, err := json.Marshal(map[string]interface{}{
+			"event":     "spending.alert",
+			"timestamp": common.GetTimestamp(),
+			"alert":     alert,
+			"spending":  spending,
+			"message":   content,
+		})
+		if err != nil {
+			return err
+		}
+		resp, err := webhookClient.Post(alert.WebhookURL, "application/json", bytes.NewReader(payload))
+		if err != nil {
+			return err
+		}
+		_ = resp.Body.Close()
+		if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+			return fmt.Errorf("status code %d", resp.StatusCode)
+		}
+		return nil
+	default:
+		email, err := GetUserEmail(alert.UserId)
+		if err != nil {
+			return err
+		}
+		if email == "" {
+			return errors.New("user has no email")
+		}
+		return common.SendEmail("额度消费提醒", email, content)
+	}
+}
+
+// evaluate fires the alert once in a period, the failed deliveries are not retried in the same period
+func (alert *SpendingAlert) evaluate() error {
+	period := alert.getPeriod()
+	if alert.TriggeredPeriod == period {
+		return nil
+	}
+	var token *Token
+	if alert.TokenId != 0 {
+		var err error
+		token, err = GetTokenByIds(alert.TokenId, alert.UserId)
+		if err != nil {
+			return err
+		}
+	}
+	spending, err := alert.getSpending(token)
+	if err != nil {
+		return err
+	}
+	if spending < int64(alert.Threshold) {
+		return nil
+	}
+	lastError := ""
+	if err = alert.notify(token, spending); err != nil {
+		lastError = err.Error()
+	}
+	return DB.Model(alert).Select("triggered_period", "last_triggered_time", "last_error").Updates(SpendingAlert{
+		TriggeredPeriod:   period,
+		LastTriggeredTime: common.GetTimestamp(),
+		LastError:         lastError,
+	}).Error
+}
+
+func AutomaticallyEvaluateSpendingAlerts(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushUserUsages()
+		var alerts []*SpendingAlert
+		err := DB.Where("status = ?", common.SpendingAlertStatusEnabled).Find(&alerts).Error
+		if err != nil {
+			common.SysError("failed to get spending alerts: " + err.Error())
+			continue
+		}
+		for _, alert := range alerts {
+			err = alert.evaluate()
+			if err != nil {
+				common.SysError(fmt.Sprintf("failed to evaluate spending alert #%d: %s", alert.Id, err.Error()))
+			}
+		}
+	}
+}
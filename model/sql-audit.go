@@ -0,0 +1,237 @@
+package model
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"one-api/common"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SlowQuery is the statistics of the slow queries sharing the same fingerprint
+type SlowQuery struct {
+	Fingerprint string `json:"fingerprint"`
+	Sample      string `json:"sample"`
+	Count       int    `json:"count"`
+	TotalTime   int64  `json:"total_time"` // unit is millisecond
+	MaxTime     int64  `json:"max_time"`
+	Plan        string `json:"plan"`
+	FullScan    string `json:"full_scan"` // the table scanned without an index, empty if none
+}
+
+var slowQueries = make(map[string]*SlowQuery)
+var slowQueriesLock sync.Mutex
+
+var sqlStringPattern = regexp.MustCompile(`'(?:[^']|'')*'`)
+var sqlDoubleQuotedStringPattern = regexp.MustCompile(`"(?:[^"]|"")*"`)
+var sqlNumberPattern = regexp.MustCompile(`\b\d+(?:\.\d+)?\b`)
+var sqlListPattern = regexp.MustCompile(`\(\s*\?(?:\s*,\s*\?)*\s*\)`)
+var sqlSpacePattern = regexp.MustCompile(`\s+`)
+var sqliteScanPattern = regexp.MustCompile(`^SCAN (?:TABLE )?(\S+)`)
+var postgresScanPattern = regexp.MustCompile(`Seq Scan on (\S+)`)
+
+// getSQLFingerprint replaces the literals so that the same query with different arguments is counted together,
+// the logged statements of MySQL and SQLite quote the strings with double quotes while PostgreSQL uses them for identifiers
+func getSQLFingerprint(statement string, dialect string) string {
+	fingerprint := sqlStringPattern.ReplaceAllString(statement, "?")
+	if dialect != "postgres" {
+		fingerprint = sqlDoubleQuotedStringPattern.ReplaceAllString(fingerprint, "?")
+	}
+	fingerprint = sqlNumberPattern.ReplaceAllString(fingerprint, "?")
+	fingerprint = sqlListPattern.ReplaceAllString(fingerprint, "(?)")
+	fingerprint = strings.TrimSpace(sqlSpacePattern.ReplaceAllString(fingerprint, " "))
+	if len(fingerprint) > 500 {
+		fingerprint = fingerprint[:500]
+	}
+	return fingerprint
+}
+
+// sqlAudit records the slow statements and explains them, it is hooked around the callbacks of gorm which build and
+// run the statements so that it sees the parameterized SQL apart from its arguments, which are never logged
+type sqlAudit struct {
+	sqlDB   *sql.DB
+	dialect string
+}
+
+const sqlAuditStartKey = "sql_audit:start"
+
+func registerSQLAudit(db *gorm.DB, sqlDB *sql.DB) error {
+	audit := &sqlAudit{sqlDB: sqlDB, dialect: db.Dialector.Name()}
+	// the slow statements gorm warns about are logged without their arguments as well
+	logLevel := logger.Warn
+	if common.DebugEnabled {
+		logLevel = logger.Info
+	}
+	db.Logger = logger.New(log.New(os.Stdout, "\r\n", log.LstdFlags), logger.Config{
+		SlowThreshold:        200 * time.Millisecond,
+		LogLevel:             logLevel,
+		Colorful:             true,
+		ParameterizedQueries: true,
+	})
+	callback := db.Callback()
+	errs := []error{
+		callback.Create().Before("gorm:create").Register("sql_audit:before_create", audit.before),
+		callback.Create().After("gorm:create").Register("sql_audit:after_create", audit.after),
+		callback.Query().Before("gorm:query").Register("sql_audit:before_query", audit.before),
+		callback.Query().After("gorm:query").Register("sql_audit:after_query", audit.after),
+		callback.Update().Before("gorm:update").Register("sql_audit:before_update", audit.before),
+		callback.Update().After("gorm:update").Register("sql_audit:after_update", audit.after),
+		callback.Delete().Before("gorm:delete").Register("sql_audit:before_delete", audit.before),
+		callback.Delete().After("gorm:delete").Register("sql_audit:after_delete", audit.after),
+		callback.Row().Before("gorm:row").Register("sql_audit:before_row", audit.before),
+		callback.Row().After("gorm:row").Register("sql_audit:after_row", audit.after),
+		callback.Raw().Before("gorm:raw").Register("sql_audit:before_raw", audit.before),
+		callback.Raw().After("gorm:raw").Register("sql_audit:after_raw", audit.after),
+	}
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (audit *sqlAudit) before(tx *gorm.DB) {
+	tx.InstanceSet(sqlAuditStartKey, time.Now())
+}
+
+func (audit *sqlAudit) after(tx *gorm.DB) {
+	value, ok := tx.InstanceGet(sqlAuditStartKey)
+	if !ok {
+		return
+	}
+	elapsed := time.Since(value.(time.Time)).Milliseconds()
+	if elapsed < int64(common.SQLSlowThreshold) {
+		return
+	}
+	statement := tx.Statement.SQL.String()
+	vars := append([]any{}, tx.Statement.Vars...)
+	common.SysLog(fmt.Sprintf("slow query (%dms, %d rows, %d arguments redacted): %s", elapsed, tx.RowsAffected, len(vars), statement))
+	fingerprint := getSQLFingerprint(statement, audit.dialect)
+	slowQueriesLock.Lock()
+	query, ok := slowQueries[fingerprint]
+	if !ok {
+		query = &SlowQuery{Fingerprint: fingerprint, Sample: statement}
+		slowQueries[fingerprint] = query
+	}
+	query.Count++
+	query.TotalTime += elapsed
+	if elapsed > query.MaxTime {
+		query.MaxTime = elapsed
+	}
+	slowQueriesLock.Unlock()
+	// each fingerprint is explained once per report
+	if !ok && strings.HasPrefix(strings.ToUpper(strings.TrimSpace(statement)), "SELECT") {
+		go audit.explain(fingerprint, statement, vars)
+	}
+}
+
+// explain runs on the raw connection, so that it doesn't go through the audit again, with the arguments bound like
+// the statement so that the plan is the one of the statement
+func (audit *sqlAudit) explain(fingerprint string, statement string, vars []any) {
+	prefix := "EXPLAIN "
+	if common.UsingSQLite {
+		prefix = "EXPLAIN QUERY PLAN "
+	}
+	rows, err := audit.sqlDB.Query(prefix+statement, vars...)
+	if err != nil {
+		common.SysError("failed to explain slow query: " + err.Error())
+		return
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return
+	}
+	var lines []string
+	fullScan := ""
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		pointers := make([]any, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if rows.Scan(pointers...) != nil {
+			continue
+		}
+		fields := make(map[string]string, len(columns))
+		parts := make([]string, 0, len(columns))
+		for i, column := range columns {
+			fields[strings.ToLower(column)] = values[i].String
+			parts = append(parts, values[i].String)
+		}
+		line := strings.Join(parts, " | ")
+		if common.UsingSQLite {
+			line = fields["detail"]
+		}
+		lines = append(lines, line)
+		if fullScan != "" {
+			continue
+		}
+		// SQLite prints SCAN for the tables read without an index, PostgreSQL prints Seq Scan and MySQL the ALL access type
+		if match := sqliteScanPattern.FindStringSubmatch(line); match != nil && !strings.Contains(line, "INDEX") {
+			fullScan = match[1]
+		} else if match := postgresScanPattern.FindStringSubmatch(line); match != nil {
+			fullScan = match[1]
+		} else if fields["type"] == "ALL" {
+			fullScan = fields["table"]
+		}
+	}
+	slowQueriesLock.Lock()
+	if query, ok := slowQueries[fingerprint]; ok {
+		query.Plan = strings.Join(lines, "\n")
+		query.FullScan = fullScan
+	}
+	slowQueriesLock.Unlock()
+}
+
+// GetSlowQueries returns the slow queries since the last report, the slowest in total first
+func GetSlowQueries() []SlowQuery {
+	slowQueriesLock.Lock()
+	queries := make([]SlowQuery, 0, len(slowQueries))
+	for _, query := range slowQueries {
+		queries = append(queries, *query)
+	}
+	slowQueriesLock.Unlock()
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].TotalTime > queries[j].TotalTime
+	})
+	return queries
+}
+
+func reportSlowQueries() {
+	queries := GetSlowQueries()
+	slowQueriesLock.Lock()
+	slowQueries = make(map[string]*SlowQuery)
+	slowQueriesLock.Unlock()
+	if len(queries) == 0 {
+		return
+	}
+	common.SysLog(fmt.Sprintf("sql audit: %d kinds of slow queries in the last %d seconds", len(queries), common.SQLAuditReportFrequency))
+	for i, query := range queries {
+		if i >= 10 {
+			break
+		}
+		common.SysLog(fmt.Sprintf("sql audit: %d times, %dms in total, %dms at most: %s", query.Count, query.TotalTime, query.MaxTime, query.Fingerprint))
+	}
+	for _, query := range queries {
+		if query.FullScan != "" {
+			common.SysLog(fmt.Sprintf("sql audit: missing index suspect, table %s is scanned by: %s", query.FullScan, query.Fingerprint))
+		}
+	}
+}
+
+func ReportSlowQueries(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		reportSlowQueries()
+	}
+}
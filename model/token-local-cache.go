@@ -0,0 +1,79 @@
+package model
+
+import (
+	"errors"
+	"one-api/common"
+	"strconv"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// tokenAccessedTimeUpdateInterval keeps the busy tokens from writing the accessed time on every request, unit is second
+const tokenAccessedTimeUpdateInterval = 10
+
+var tokenLocalCache = common.NewLRUCache(common.TokenLocalCacheSize, time.Duration(common.TokenLocalCacheTTL)*time.Millisecond)
+var invalidTokenLocalCache = common.NewLRUCache(common.TokenLocalCacheSize, time.Duration(common.InvalidTokenLocalCacheTTL)*time.Millisecond)
+var userEnabledLocalCache = common.NewLRUCache(common.TokenLocalCacheSize, time.Duration(common.TokenLocalCacheTTL)*time.Millisecond)
+
+var tokenAccessedTimes sync.Map
+
+// getTokenByKeyLocally returns a copy of the token, so that the callers can't change the cached one
+func getTokenByKeyLocally(key string) (*Token, error) {
+	if value, ok := tokenLocalCache.Get(key); ok {
+		token := *value.(*Token)
+		return &token, nil
+	}
+	token, err := CacheGetTokenByKey(key)
+	if err != nil {
+		return nil, err
+	}
+	cachedToken := *token
+	tokenLocalCache.Set(key, &cachedToken)
+	return token, nil
+}
+
+// rejectTokenLocally remembers why the key is invalid, the database errors are not remembered
+func rejectTokenLocally(key string, err error) error {
+	tokenLocalCache.Delete(key)
+	invalidTokenLocalCache.Set(key, err)
+	return err
+}
+
+func isTokenNotFound(err error) bool {
+	return errors.Is(err, gorm.ErrRecordNotFound)
+}
+
+func invalidateTokenLocally(key string) {
+	tokenLocalCache.Delete(key)
+	invalidTokenLocalCache.Delete(key)
+}
+
+func cacheIsUserEnabledLocally(userId int) (bool, bool) {
+	value, ok := userEnabledLocalCache.Get(strconv.Itoa(userId))
+	if !ok {
+		return false, false
+	}
+	return value.(bool), true
+}
+
+func setUserEnabledLocally(userId int, enabled bool) {
+	userEnabledLocalCache.Set(strconv.Itoa(userId), enabled)
+}
+
+// updateTokenAccessedTime writes the accessed time at most once per interval for each token
+func updateTokenAccessedTime(token *Token) {
+	now := common.GetTimestamp()
+	if value, ok := tokenAccessedTimes.Load(token.Id); ok && now-value.(int64) < tokenAccessedTimeUpdateInterval {
+		return
+	}
+	tokenAccessedTimes.Store(token.Id, now)
+	go func() {
+		token.AccessedTime = now
+		err := token.SelectUpdate()
+		if err != nil {
+			common.SysError("failed to update token" + err.Error())
+		}
+	}()
+}
@@ -0,0 +1,128 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"testing"
+
+	"gorm.io/driver/sqlite"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var testDBOnce sync.Once
+
+func setupTestDB(tb testing.TB) {
+	testDBOnce.Do(func() {
+		// the tokens are read from the database behind the local cache
+		common.RedisEnabled = false
+		db, err := gorm.Open(sqlite.Open("file:one-api-test?mode=memory&cache=shared"), &gorm.Config{
+			Logger: logger.Default.LogMode(logger.Silent),
+		})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		err = db.AutoMigrate(&Token{}, &User{})
+		if err != nil {
+			tb.Fatal(err)
+		}
+		DB = db
+	})
+}
+
+func insertTestToken(tb testing.TB) *Token {
+	setupTestDB(tb)
+	token := &Token{
+		UserId:         1,
+		Name:           "test",
+		Key:            common.GenerateKey(),
+		Status:         common.TokenStatusEnabled,
+		CreatedTime:    common.GetTimestamp(),
+		AccessedTime:   common.GetTimestamp(),
+		ExpiredTime:    -1,
+		UnlimitedQuota: true,
+	}
+	err := token.Insert()
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return token
+}
+
+func TestTokenUpdateInvalidatesLocalCache(t *testing.T) {
+	token := insertTestToken(t)
+	if _, err := ValidateUserToken(token.Key); err != nil {
+		t.Fatalf("the enabled token is rejected: %v", err)
+	}
+	token.Status = common.TokenStatusDisabled
+	err := token.Update()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateUserToken(token.Key); err == nil {
+		t.Fatal("the disabled token is still accepted from the local cache")
+	}
+}
+
+func TestTokenDeleteInvalidatesLocalCache(t *testing.T) {
+	token := insertTestToken(t)
+	if _, err := ValidateUserToken(token.Key); err != nil {
+		t.Fatalf("the enabled token is rejected: %v", err)
+	}
+	err := DeleteTokenById(token.Id, token.UserId)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateUserToken(token.Key); err == nil {
+		t.Fatal("the deleted token is still accepted from the local cache")
+	}
+}
+
+func TestTokenRotateInvalidatesLocalCache(t *testing.T) {
+	token := insertTestToken(t)
+	oldKey := token.Key
+	if _, err := ValidateUserToken(oldKey); err != nil {
+		t.Fatalf("the enabled token is rejected: %v", err)
+	}
+	err := token.Rotate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateUserToken(oldKey); err == nil {
+		t.Fatal("the old key is still accepted from the local cache")
+	}
+	if _, err := ValidateUserToken(token.Key); err != nil {
+		t.Fatalf("the new key is rejected: %v", err)
+	}
+}
+
+// BenchmarkValidateUserToken measures the validation of the tokens served by the local cache, which has to stay far
+// below 0.1 ms for the bursts of the edge functions
+func BenchmarkValidateUserToken(b *testing.B) {
+	b.Run("warm hit", func(b *testing.B) {
+		token := insertTestToken(b)
+		if _, err := ValidateUserToken(token.Key); err != nil {
+			b.Fatal(err)
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			// the cache expires every TokenLocalCacheTTL, the refills are part of the cost
+			if _, err := ValidateUserToken(token.Key); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("negative hit", func(b *testing.B) {
+		setupTestDB(b)
+		key := common.GenerateKey()
+		if _, err := ValidateUserToken(key); err == nil {
+			b.Fatal("the unknown key is accepted")
+		}
+		b.ResetTimer()
+		for i := 0; i < b.N; i++ {
+			if _, err := ValidateUserToken(key); err == nil {
+				b.Fatal("the unknown key is accepted")
+			}
+		}
+	})
+}
@@ -8,17 +8,52 @@ import (
 )
 
 type Token struct {
-	Id             int    `json:"id"`
-	UserId         int    `json:"user_id"`
-	Key            string `json:"key" gorm:"type:char(48);uniqueIndex"`
-	Status         int    `json:"status" gorm:"default:1"`
-	Name           string `json:"name" gorm:"index" `
-	CreatedTime    int64  `json:"created_time" gorm:"bigint"`
-	AccessedTime   int64  `json:"accessed_time" gorm:"bigint"`
-	ExpiredTime    int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
-	RemainQuota    int    `json:"remain_quota" gorm:"default:0"`
-	UnlimitedQuota bool   `json:"unlimited_quota" gorm:"default:false"`
-	UsedQuota      int    `json:"used_quota" gorm:"default:0"` // used quota
+	Id                   int    `json:"id"`
+	UserId               int    `json:"user_id" gorm:"index"`
+	Key                  string `json:"key" gorm:"type:char(48);uniqueIndex"`
+	Status               int    `json:"status" gorm:"default:1"`
+	Name                 string `json:"name" gorm:"index" `
+	CreatedTime          int64  `json:"created_time" gorm:"bigint"`
+	AccessedTime         int64  `json:"accessed_time" gorm:"bigint"`
+	ExpiredTime          int64  `json:"expired_time" gorm:"bigint;default:-1"` // -1 means never expired
+	RemainQuota          int    `json:"remain_quota" gorm:"default:0"`
+	UnlimitedQuota       bool   `json:"unlimited_quota" gorm:"default:false"`
+	UsedQuota            int    `json:"used_quota" gorm:"default:0"`                       // used quota
+	AutoDowngrade        bool   `json:"auto_downgrade" gorm:"default:false"`               // switch to a cheaper model when the quota isn't enough
+	DataResidency        string `json:"data_residency" gorm:"type:varchar(64);default:''"` // the comma separated regions of the channels it can use, empty means any
+	ChannelGroup         string `json:"channel_group" gorm:"type:varchar(32);default:''"`  // the channel group its requests are routed to, empty follows the model and the user group
+	FineTuningEnabled    bool   `json:"fine_tuning_enabled" gorm:"default:false"`          // it may manage the fine-tuning jobs, which cost much more than the requests
+	SystemPrompt         string `json:"system_prompt" gorm:"type:text"`                    // set by the admins only, injected into its chat requests
+	SystemPromptMode     string `json:"system_prompt_mode" gorm:"type:varchar(16);default:''"`
+	ContextTruncation    string `json:"context_truncation" gorm:"type:varchar(16);default:''"` // what is done to the chat requests beyond the context window, empty leaves them to the upstream
+	RaceEnabled          bool   `json:"race_enabled" gorm:"default:false"`                     // its requests are sent to two channels at once and the first to respond wins
+	ResponseCacheEnabled bool   `json:"response_cache_enabled" gorm:"default:false"`           // the responses of its requests with a temperature of 0 are cached
+	SemanticCacheEnabled bool   `json:"semantic_cache_enabled" gorm:"default:false"`           // its requests may be served the responses of similar prompts
+	Priority             int    `json:"priority" gorm:"type:int;default:0"`                    // 0 follows the user, a token never goes above the priority of its user
+	OrganizationId       int    `json:"organization_id" gorm:"default:0;index"`                // the organization whose pool it draws from, 0 draws from the quota of its user
+}
+
+const (
+	ContextTruncationTruncate  = "truncate"  // the oldest messages are dropped
+	ContextTruncationSummarize = "summarize" // the oldest messages are replaced by a summary the model writes
+)
+
+func IsValidContextTruncation(truncation string) bool {
+	return truncation == "" || truncation == ContextTruncationTruncate || truncation == ContextTruncationSummarize
+}
+
+var (
+	ErrTokenQuotaInsufficient = errors.New("令牌额度不足")
+	ErrUserQuotaInsufficient  = errors.New("用户额度不足")
+)
+
+// TokenExhaustedError keeps the user of the token, so that the response can follow the group of the user
+type TokenExhaustedError struct {
+	UserId int
+}
+
+func (e *TokenExhaustedError) Error() string {
+	return "该令牌额度已用尽"
 }
 
 func GetAllUserTokens(userId int, startIdx int, num int) ([]*Token, error) {
@@ -37,39 +72,48 @@ func ValidateUserToken(key string) (token *Token, err error) {
 	if key == "" {
 		return nil, errors.New("未提供令牌")
 	}
-	token, err = CacheGetTokenByKey(key)
+	if value, ok := invalidTokenLocalCache.Get(key); ok {
+		return nil, value.(error)
+	}
+	token, err = getTokenByKeyLocally(key)
 	if err == nil {
+		if token.Status == common.TokenStatusExhausted {
+			return nil, rejectTokenLocally(key, &TokenExhaustedError{UserId: token.UserId})
+		}
 		if token.Status != common.TokenStatusEnabled {
-			return nil, errors.New("该令牌状态不可用")
+			return nil, rejectTokenLocally(key, errors.New("该令牌状态不可用"))
 		}
 		if token.ExpiredTime != -1 && token.ExpiredTime < common.GetTimestamp() {
-			token.Status = common.TokenStatusExpired
-			err := token.SelectUpdate()
-			if err != nil {
-				common.SysError("failed to update token status" + err.Error())
-			}
-			return nil, errors.New("该令牌已过期")
+			token.disableWithStatus(common.TokenStatusExpired, WebhookEventTokenExpired)
+			return nil, rejectTokenLocally(key, errors.New("该令牌已过期"))
 		}
 		if !token.UnlimitedQuota && token.RemainQuota <= 0 {
-			token.Status = common.TokenStatusExhausted
-			err := token.SelectUpdate()
-			if err != nil {
-				common.SysError("failed to update token status" + err.Error())
-			}
-			return nil, errors.New("该令牌额度已用尽")
+			token.disableWithStatus(common.TokenStatusExhausted, WebhookEventTokenExhausted)
+			return nil, rejectTokenLocally(key, &TokenExhaustedError{UserId: token.UserId})
 		}
-		go func() {
-			token.AccessedTime = common.GetTimestamp()
-			err := token.SelectUpdate()
-			if err != nil {
-				common.SysError("failed to update token" + err.Error())
-			}
-		}()
+		updateTokenAccessedTime(token)
 		return token, nil
 	}
+	if isTokenNotFound(err) {
+		return nil, rejectTokenLocally(key, errors.New("无效的令牌"))
+	}
 	return nil, errors.New("无效的令牌")
 }
 
+// disableWithStatus only fires the webhook for the request which actually changed the status,
+// the cached token may still look enabled to the requests coming after it
+func (token *Token) disableWithStatus(status int, event string) {
+	result := DB.Model(&Token{}).Where("id = ? and status = ?", token.Id, common.TokenStatusEnabled).Update("status", status)
+	if result.Error != nil {
+		common.SysError("failed to update token status" + result.Error.Error())
+		return
+	}
+	token.Status = status
+	if result.RowsAffected > 0 {
+		FireTokenWebhook(event, token)
+	}
+}
+
 func GetTokenByIds(id int, userId int) (*Token, error) {
 	if id == 0 || userId == 0 {
 		return nil, errors.New("id 或 userId 为空！")
@@ -93,16 +137,72 @@ func GetTokenById(id int) (*Token, error) {
 func (token *Token) Insert() error {
 	var err error
 	err = DB.Create(token).Error
+	if err == nil {
+		FireTokenWebhook(WebhookEventTokenCreated, token)
+	}
 	return err
 }
 
+// Rotate replaces the key of the token, the old key stops working immediately
+func (token *Token) Rotate() error {
+	oldKey := token.Key
+	token.Key = common.GenerateKey()
+	err := DB.Model(token).Update("key", token.Key).Error
+	if err != nil {
+		return err
+	}
+	invalidateTokenLocally(oldKey)
+	if common.RedisEnabled {
+		err = common.RedisDel(fmt.Sprintf("token:%s", oldKey))
+		if err != nil {
+			common.SysError("failed to delete token cache: " + err.Error())
+		}
+	}
+	FireTokenWebhook(WebhookEventTokenRotated, token)
+	return nil
+}
+
 // Update Make sure your token's fields is completed, because this will update non-zero values
 func (token *Token) Update() error {
 	var err error
-	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota").Updates(token).Error
+	err = DB.Model(token).Select("name", "status", "expired_time", "remain_quota", "unlimited_quota", "auto_downgrade", "data_residency", "channel_group", "context_truncation", "race_enabled", "response_cache_enabled", "semantic_cache_enabled", "priority", "organization_id").Updates(token).Error
+	if err == nil {
+		invalidateTokenLocally(token.Key)
+	}
 	return err
 }
 
+// UpdateTokenSystemPrompt is kept apart from Update, since the users may not change the system prompt of their tokens
+func UpdateTokenSystemPrompt(id int, systemPrompt string, systemPromptMode string) (*Token, error) {
+	token := Token{Id: id}
+	err := DB.First(&token, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	token.SystemPrompt = systemPrompt
+	token.SystemPromptMode = systemPromptMode
+	err = DB.Model(&token).Select("system_prompt", "system_prompt_mode").Updates(token).Error
+	if err == nil {
+		invalidateTokenLocally(token.Key)
+	}
+	return &token, err
+}
+
+// UpdateTokenFineTuningEnabled is kept apart from Update as well, the fine-tuning jobs cost much more than the requests
+func UpdateTokenFineTuningEnabled(id int, enabled bool) (*Token, error) {
+	token := Token{Id: id}
+	err := DB.First(&token, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	token.FineTuningEnabled = enabled
+	err = DB.Model(&token).Select("fine_tuning_enabled").Updates(token).Error
+	if err == nil {
+		invalidateTokenLocally(token.Key)
+	}
+	return &token, err
+}
+
 func (token *Token) SelectUpdate() error {
 	// This can update zero values
 	return DB.Model(token).Select("accessed_time", "status").Updates(token).Error
@@ -111,6 +211,10 @@ func (token *Token) SelectUpdate() error {
 func (token *Token) Delete() error {
 	var err error
 	err = DB.Delete(token).Error
+	if err == nil {
+		invalidateTokenLocally(token.Key)
+		FireTokenWebhook(WebhookEventTokenDeleted, token)
+	}
 	return err
 }
 
@@ -162,14 +266,28 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 		return err
 	}
 	if !token.UnlimitedQuota && token.RemainQuota < quota {
-		return errors.New("令牌额度不足")
+		return ErrTokenQuotaInsufficient
+	}
+	if token.OrganizationId != 0 {
+		err = preConsumeOrganizationQuota(token.OrganizationId, token.UserId, quota)
+		if err != nil {
+			return err
+		}
+		if !token.UnlimitedQuota {
+			err = DecreaseTokenQuota(tokenId, quota)
+		}
+		return err
 	}
 	userQuota, err := GetUserQuota(token.UserId)
 	if err != nil {
 		return err
 	}
-	if userQuota < quota {
-		return errors.New("用户额度不足")
+	creditLimit, err := GetUserCreditLimit(token.UserId)
+	if err != nil {
+		return err
+	}
+	if userQuota+creditLimit < quota {
+		return ErrUserQuotaInsufficient
 	}
 	quotaTooLow := userQuota >= common.QuotaRemindThreshold && userQuota-quota < common.QuotaRemindThreshold
 	noMoreQuota := userQuota-quota <= 0
@@ -186,7 +304,7 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 			if email != "" {
 				topUpLink := fmt.Sprintf("%s/topup", common.ServerAddress)
 				err = common.SendEmail(prompt, email,
-					fmt.Sprintf("%s，当前剩余额度为 %d，为了不影响您的使用，请及时充值。<br/>充值链接：<a href='%s'>%s</a>", prompt, userQuota, topUpLink, topUpLink))
+					fmt.Sprintf("%s，当前剩余额度为 %s，为了不影响您的使用，请及时充值。<br/>充值链接：<a href='%s'>%s</a>", prompt, common.LogQuota(userQuota), topUpLink, topUpLink))
 				if err != nil {
 					common.SysError("failed to send email" + err.Error())
 				}
@@ -205,7 +323,12 @@ func PreConsumeTokenQuota(tokenId int, quota int) (err error) {
 
 func PostConsumeTokenQuota(tokenId int, quota int) (err error) {
 	token, err := GetTokenById(tokenId)
-	if quota > 0 {
+	if err != nil {
+		return err
+	}
+	if token.OrganizationId != 0 {
+		err = postConsumeOrganizationQuota(token.OrganizationId, token.UserId, quota)
+	} else if quota > 0 {
 		err = DecreaseUserQuota(token.UserId, quota)
 	} else {
 		err = IncreaseUserQuota(token.UserId, -quota)
@@ -0,0 +1,82 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"gorm.io/gorm"
+	"math"
+	"one-api/common"
+)
+
+// TopUpOrder is an online payment, the quota is credited once the payment gateway confirms it
+type TopUpOrder struct {
+	Id            int     `json:"id"`
+	UserId        int     `json:"user_id" gorm:"index"`
+	TradeNo       string  `json:"trade_no" gorm:"type:varchar(64);uniqueIndex"`
+	GatewayNo     string  `json:"gateway_no" gorm:"type:varchar(64)"` // trade number of the payment gateway
+	PaymentMethod string  `json:"payment_method" gorm:"type:varchar(16)"`
+	Amount        int     `json:"amount"` // in units of QuotaPerUnit
+	Quota         int     `json:"quota"`
+	Money         float64 `json:"money"` // in CNY
+	Status        int     `json:"status" gorm:"default:1"`
+	CreatedTime   int64   `json:"created_time" gorm:"bigint"`
+	CompletedTime int64   `json:"completed_time" gorm:"bigint"`
+}
+
+func GetUserTopUpOrders(userId int, startIdx int, num int) (orders []*TopUpOrder, err error) {
+	err = DB.Where("user_id = ?", userId).Order("id desc").Limit(num).Offset(startIdx).Find(&orders).Error
+	return orders, err
+}
+
+func GetAllTopUpOrders(startIdx int, num int) (orders []*TopUpOrder, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&orders).Error
+	return orders, err
+}
+
+func (order *TopUpOrder) Insert() error {
+	return DB.Create(order).Error
+}
+
+// CompleteTopUpOrder credits the quota of a paid order, it is safe to be called repeatedly
+// because payment gateways retry the notification until they get a success response.
+func CompleteTopUpOrder(tradeNo string, gatewayNo string, money float64) error {
+	if tradeNo == "" {
+		return errors.New("未提供订单号")
+	}
+	order := &TopUpOrder{}
+	err := DB.Where("trade_no = ?", tradeNo).First(order).Error
+	if err != nil {
+		return errors.New("订单不存在")
+	}
+	if order.Status == common.TopUpOrderStatusSuccess {
+		return nil
+	}
+	if math.Abs(order.Money-money) > 0.01 {
+		return fmt.Errorf("支付金额 %.2f 与订单金额 %.2f 不一致", money, order.Money)
+	}
+	completed := false
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		// only the notification which moves the order out of pending credits the quota
+		result := tx.Model(&TopUpOrder{}).Where("id = ? and status = ?", order.Id, common.TopUpOrderStatusPending).Updates(
+			map[string]interface{}{
+				"gateway_no":     gatewayNo,
+				"status":         common.TopUpOrderStatusSuccess,
+				"completed_time": common.GetTimestamp(),
+			},
+		)
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected != 1 {
+			return nil
+		}
+		completed = true
+		return tx.Model(&User{}).Where("id = ?", order.UserId).Update("quota", gorm.Expr("quota + ?", order.Quota)).Error
+	})
+	if err != nil || !completed {
+		return err
+	}
+	_ = CacheUpdateUserQuota(order.UserId)
+	RecordLog(order.UserId, LogTypeTopup, fmt.Sprintf("在线充值成功，充值额度 %s，支付金额 %.2f 元", common.LogQuota(order.Quota), order.Money))
+	return nil
+}
@@ -0,0 +1,103 @@
+package model
+
+import (
+	"encoding/json"
+	"errors"
+	"one-api/common"
+)
+
+// SetUserTOTPSecret keeps the secret of an enrollment until it is confirmed with a code
+func SetUserTOTPSecret(id int, secret string) error {
+	return DB.Model(&User{}).Where("id = ? and totp_enabled = ?", id, false).Update("totp_secret", secret).Error
+}
+
+func hashRecoveryCodes(codes []string) (string, error) {
+	hashes := make([]string, 0, len(codes))
+	for _, code := range codes {
+		hash, err := common.Password2Hash(code)
+		if err != nil {
+			return "", err
+		}
+		hashes = append(hashes, hash)
+	}
+	jsonBytes, err := json.Marshal(hashes)
+	return string(jsonBytes), err
+}
+
+// EnableUserTOTP turns on the two-factor authentication with the recovery codes, the counter is the period of the
+// code which confirmed the enrollment
+func EnableUserTOTP(id int, recoveryCodes []string, counter int64) error {
+	hashes, err := hashRecoveryCodes(recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_enabled":        true,
+		"totp_recovery_codes": hashes,
+		"totp_last_counter":   counter,
+	}).Error
+}
+
+func UpdateUserRecoveryCodes(id int, recoveryCodes []string) error {
+	hashes, err := hashRecoveryCodes(recoveryCodes)
+	if err != nil {
+		return err
+	}
+	return DB.Model(&User{}).Where("id = ?", id).Update("totp_recovery_codes", hashes).Error
+}
+
+func DisableUserTOTP(id int) error {
+	return DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"totp_enabled":        false,
+		"totp_secret":         "",
+		"totp_recovery_codes": "",
+		"totp_last_counter":   0,
+	}).Error
+}
+
+// VerifyTOTP accepts a code of the authenticator app or one of the recovery codes, both only once
+func (user *User) VerifyTOTP(code string) (bool, error) {
+	if user.TOTPSecret == "" {
+		return false, errors.New("未开启两步验证")
+	}
+	if counter := common.ValidateTOTP(user.TOTPSecret, code); counter != 0 {
+		// the condition on the counter makes the check and the update atomic against a replay
+		result := DB.Model(&User{}).Where("id = ? and totp_last_counter < ?", user.Id, counter).Update("totp_last_counter", counter)
+		if result.Error != nil {
+			return false, result.Error
+		}
+		return result.RowsAffected == 1, nil
+	}
+	if user.TOTPRecoveryCodes == "" {
+		return false, nil
+	}
+	var hashes []string
+	err := json.Unmarshal([]byte(user.TOTPRecoveryCodes), &hashes)
+	if err != nil {
+		return false, err
+	}
+	code = common.NormalizeRecoveryCode(code)
+	for i, hash := range hashes {
+		if !common.ValidatePasswordAndHash(code, hash) {
+			continue
+		}
+		remaining, err := json.Marshal(append(hashes[:i:i], hashes[i+1:]...))
+		if err != nil {
+			return false, err
+		}
+		result := DB.Model(&User{}).Where("id = ? and totp_recovery_codes = ?", user.Id, user.TOTPRecoveryCodes).
+			Update("totp_recovery_codes", string(remaining))
+		if result.Error != nil {
+			return false, result.Error
+		}
+		return result.RowsAffected == 1, nil
+	}
+	return false, nil
+}
+
+// CountUserRecoveryCodes returns how many recovery codes the user has not used
+func (user *User) CountRecoveryCodes() int {
+	var hashes []string
+	_ = json.Unmarshal([]byte(user.TOTPRecoveryCodes), &hashes)
+	return len(hashes)
+}
@@ -0,0 +1,117 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"one-api/common"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// QuotaTransfer is the ledger of the quota moved between users
+type QuotaTransfer struct {
+	Id           int    `json:"id"`
+	FromUserId   int    `json:"from_user_id" gorm:"index"`
+	FromUsername string `json:"from_username"`
+	ToUserId     int    `json:"to_user_id" gorm:"index"`
+	ToUsername   string `json:"to_username"`
+	Quota        int    `json:"quota"`
+	Fee          int    `json:"fee"`
+	CreatedTime  int64  `json:"created_time" gorm:"bigint;index"`
+}
+
+func GetQuotaTransferFee(quota int) int {
+	return int(math.Ceil(float64(quota) * common.QuotaTransferFeeRate))
+}
+
+func getTransferredQuotaSince(tx *gorm.DB, userId int, since int64) (quota int64) {
+	tx.Model(&QuotaTransfer{}).Where("from_user_id = ? and created_time >= ?", userId, since).
+		Select("COALESCE(sum(quota), 0)").Scan(&quota)
+	return quota
+}
+
+// TransferQuota moves quota from one user to another, the fee is charged to the sender and goes to nobody
+func TransferQuota(fromUserId int, toUsername string, quota int) (*QuotaTransfer, error) {
+	if quota <= 0 {
+		return nil, errors.New("转账额度必须大于 0")
+	}
+	if common.QuotaTransferMin > 0 && quota < common.QuotaTransferMin {
+		return nil, fmt.Errorf("单次转账额度不能低于 %s", common.LogQuota(common.QuotaTransferMin))
+	}
+	if common.QuotaTransferMax > 0 && quota > common.QuotaTransferMax {
+		return nil, fmt.Errorf("单次转账额度不能高于 %s", common.LogQuota(common.QuotaTransferMax))
+	}
+	fee := GetQuotaTransferFee(quota)
+	transfer := &QuotaTransfer{
+		FromUserId:  fromUserId,
+		ToUsername:  toUsername,
+		Quota:       quota,
+		Fee:         fee,
+		CreatedTime: common.GetTimestamp(),
+	}
+	err := DB.Transaction(func(tx *gorm.DB) error {
+		sender := &User{}
+		// the lock serializes the transfers of the sender, so that the daily limit holds
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", fromUserId).First(sender).Error
+		if err != nil {
+			return err
+		}
+		receiver := &User{}
+		err = tx.Where("username = ?", toUsername).First(receiver).Error
+		if err != nil {
+			return errors.New("收款用户不存在")
+		}
+		if receiver.Id == sender.Id {
+			return errors.New("不能向自己转账")
+		}
+		if receiver.Status != common.UserStatusEnabled {
+			return errors.New("收款用户已被封禁")
+		}
+		// the credit limit of postpaid users and the expiring credits can't be transferred
+		if int64(sender.Quota)-getActiveCreditQuota(tx, sender.Id) < int64(quota+fee) {
+			return fmt.Errorf("额度不足，本次转账需要 %s（含手续费 %s）", common.LogQuota(quota+fee), common.LogQuota(fee))
+		}
+		if common.QuotaTransferDailyLimit > 0 {
+			now := time.Now()
+			todayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location()).Unix()
+			if getTransferredQuotaSince(tx, sender.Id, todayStart)+int64(quota) > int64(common.QuotaTransferDailyLimit) {
+				return fmt.Errorf("超出每日转账上限 %s", common.LogQuota(common.QuotaTransferDailyLimit))
+			}
+		}
+		result := tx.Model(&User{}).Where("id = ? and quota >= ?", sender.Id, quota+fee).Update("quota", gorm.Expr("quota - ?", quota+fee))
+		if result.Error != nil {
+			return result.Error
+		}
+		if result.RowsAffected == 0 {
+			return fmt.Errorf("额度不足，本次转账需要 %s（含手续费 %s）", common.LogQuota(quota+fee), common.LogQuota(fee))
+		}
+		err = tx.Model(&User{}).Where("id = ?", receiver.Id).Update("quota", gorm.Expr("quota + ?", quota)).Error
+		if err != nil {
+			return err
+		}
+		transfer.FromUsername = sender.Username
+		transfer.ToUserId = receiver.Id
+		return tx.Create(transfer).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	_ = CacheUpdateUserQuota(transfer.FromUserId)
+	_ = CacheUpdateUserQuota(transfer.ToUserId)
+	RecordLog(transfer.FromUserId, LogTypeTransfer, fmt.Sprintf("向用户 %s 转账 %s，手续费 %s", transfer.ToUsername, common.LogQuota(quota), common.LogQuota(fee)))
+	RecordLog(transfer.ToUserId, LogTypeTransfer, fmt.Sprintf("收到用户 %s 转账 %s", transfer.FromUsername, common.LogQuota(quota)))
+	return transfer, nil
+}
+
+func GetUserQuotaTransfers(userId int, startIdx int, num int) (transfers []*QuotaTransfer, err error) {
+	err = DB.Where("from_user_id = ? or to_user_id = ?", userId, userId).Order("id desc").Limit(num).Offset(startIdx).Find(&transfers).Error
+	return transfers, err
+}
+
+func GetAllQuotaTransfers(startIdx int, num int) (transfers []*QuotaTransfer, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&transfers).Error
+	return transfers, err
+}
@@ -0,0 +1,79 @@
+package model
+
+import (
+	"one-api/common"
+)
+
+const (
+	UpstreamObjectTypeResponse      = "response"
+	UpstreamObjectTypeAssistant     = "assistant"
+	UpstreamObjectTypeThread        = "thread"
+	UpstreamObjectTypeRun           = "run"
+	UpstreamObjectTypeFineTuningJob = "fine_tuning_job"
+)
+
+// UpstreamObject is an object stored by an upstream, such as a response, only the channel and the key which created it
+// know it, so the later requests about it are sent there, and only the user who created it may reach it, or only the
+// token for the objects of the Assistants API and the fine-tuning jobs
+type UpstreamObject struct {
+	Id          string `json:"id" gorm:"type:varchar(128);primaryKey"`
+	Type        string `json:"type" gorm:"type:varchar(32)"`
+	UserId      int    `json:"user_id" gorm:"index"`
+	TokenId     int    `json:"token_id" gorm:"index;default:0"` // 0 for the objects reached by all the tokens of the user
+	ChannelId   int    `json:"channel_id"`
+	KeyHash     string `json:"-" gorm:"type:varchar(64);default:''"` // empty for the channels without a key strategy
+	Model       string `json:"model" gorm:"default:''"`
+	ThreadId    string `json:"thread_id" gorm:"type:varchar(128);default:''"` // for the runs, which are reached through their threads
+	Billed      bool   `json:"billed" gorm:"default:false"`                   // for the runs and the fine-tuning jobs, which are billed once they are done
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func RecordUpstreamObject(object *UpstreamObject) {
+	object.CreatedTime = common.GetTimestamp()
+	err := DB.Save(object).Error
+	if err != nil {
+		common.SysError("failed to record upstream object: " + err.Error())
+	}
+}
+
+func GetUserUpstreamObject(id string, userId int) (*UpstreamObject, error) {
+	object := &UpstreamObject{}
+	err := DB.Where("id = ? and user_id = ?", id, userId).First(object).Error
+	return object, err
+}
+
+func DeleteUpstreamObject(id string) {
+	err := DB.Delete(&UpstreamObject{}, "id = ?", id).Error
+	if err != nil {
+		common.SysError("failed to delete upstream object: " + err.Error())
+	}
+}
+
+// GetLatestTokenUpstreamObject is the latest object of the type created by the token
+func GetLatestTokenUpstreamObject(tokenId int, objectType string) (*UpstreamObject, error) {
+	object := &UpstreamObject{}
+	err := DB.Where("token_id = ? and type = ?", tokenId, objectType).Order("created_time desc").First(object).Error
+	return object, err
+}
+
+func GetTokenUpstreamObjectIds(tokenId int, objectType string, channelId int) (ids []string, err error) {
+	err = DB.Model(&UpstreamObject{}).Where("token_id = ? and type = ? and channel_id = ?", tokenId, objectType, channelId).Pluck("id", &ids).Error
+	return ids, err
+}
+
+// MarkUpstreamObjectBilled tells if the object is billed by this call, so that it is billed once by the concurrent calls
+func MarkUpstreamObjectBilled(id string) bool {
+	result := DB.Model(&UpstreamObject{}).Where("id = ? and billed = ?", id, false).Update("billed", true)
+	if result.Error != nil {
+		common.SysError("failed to mark upstream object billed: " + result.Error.Error())
+		return false
+	}
+	return result.RowsAffected == 1
+}
+
+// GetUnbilledUpstreamRuns are the runs not billed yet which were created before the time, the oldest first
+func GetUnbilledUpstreamRuns(before int64, limit int) (objects []*UpstreamObject, err error) {
+	err = DB.Where("type = ? and billed = ? and thread_id <> '' and created_time < ?", UpstreamObjectTypeRun, false, before).
+		Order("created_time").Limit(limit).Find(&objects).Error
+	return objects, err
+}
@@ -0,0 +1,152 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TenantUsage is the daily usage of a tenant (the user group) on a model, kept for chargeback
+type TenantUsage struct {
+	Id               int    `json:"id"`
+	Tenant           string `json:"tenant" gorm:"type:varchar(32);uniqueIndex:idx_tenant_usage"`
+	ModelName        string `json:"model_name" gorm:"type:varchar(64);uniqueIndex:idx_tenant_usage"`
+	Day              string `json:"day" gorm:"type:varchar(10);uniqueIndex:idx_tenant_usage;index"` // 2006-01-02, local time
+	Requests         int    `json:"requests" gorm:"default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"bigint;default:0"`
+	Quota            int64  `json:"quota" gorm:"bigint;default:0"`
+}
+
+type tenantUsageKey struct {
+	tenant string
+	model  string
+	day    string
+}
+
+type TenantUsageCounter struct {
+	Tenant           string
+	ModelName        string
+	Requests         int
+	PromptTokens     int64
+	CompletionTokens int64
+	Quota            int64
+}
+
+var tenantUsageLock sync.Mutex
+var pendingTenantUsages = make(map[tenantUsageKey]*TenantUsageCounter)
+
+// tenantUsageCounters are cumulative since the process started, they back the Prometheus counters
+var tenantUsageCounters = make(map[tenantUsageKey]*TenantUsageCounter)
+
+func addTenantUsage(counters map[tenantUsageKey]*TenantUsageCounter, key tenantUsageKey, promptTokens int, completionTokens int, quota int) {
+	counter, ok := counters[key]
+	if !ok {
+		counter = &TenantUsageCounter{Tenant: key.tenant, ModelName: key.model}
+		counters[key] = counter
+	}
+	counter.Requests++
+	counter.PromptTokens += int64(promptTokens)
+	counter.CompletionTokens += int64(completionTokens)
+	counter.Quota += int64(quota)
+}
+
+// RecordTenantUsage only accumulates in memory, SyncTenantUsages writes it to the database periodically
+func RecordTenantUsage(tenant string, modelName string, promptTokens int, completionTokens int, quota int) {
+	day := time.Now().Format("2006-01-02")
+	tenantUsageLock.Lock()
+	defer tenantUsageLock.Unlock()
+	addTenantUsage(pendingTenantUsages, tenantUsageKey{tenant, modelName, day}, promptTokens, completionTokens, quota)
+	addTenantUsage(tenantUsageCounters, tenantUsageKey{tenant, modelName, ""}, promptTokens, completionTokens, quota)
+}
+
+func GetTenantUsageCounters() []TenantUsageCounter {
+	tenantUsageLock.Lock()
+	defer tenantUsageLock.Unlock()
+	counters := make([]TenantUsageCounter, 0, len(tenantUsageCounters))
+	for _, counter := range tenantUsageCounters {
+		counters = append(counters, *counter)
+	}
+	return counters
+}
+
+func flushTenantUsage(key tenantUsageKey, counter *TenantUsageCounter) error {
+	updates := map[string]interface{}{
+		"requests":          gorm.Expr("requests + ?", counter.Requests),
+		"prompt_tokens":     gorm.Expr("prompt_tokens + ?", counter.PromptTokens),
+		"completion_tokens": gorm.Expr("completion_tokens + ?", counter.CompletionTokens),
+		"quota":             gorm.Expr("quota + ?", counter.Quota),
+	}
+	tx := DB.Model(&TenantUsage{}).Where("tenant = ? and model_name = ? and day = ?", key.tenant, key.model, key.day)
+	result := tx.Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(&TenantUsage{
+		Tenant:           key.tenant,
+		ModelName:        key.model,
+		Day:              key.day,
+		Requests:         counter.Requests,
+		PromptTokens:     counter.PromptTokens,
+		CompletionTokens: counter.CompletionTokens,
+		Quota:            counter.Quota,
+	}).Error
+	if err != nil {
+		// another node may have created the row in the meantime
+		return DB.Model(&TenantUsage{}).Where("tenant = ? and model_name = ? and day = ?", key.tenant, key.model, key.day).Updates(updates).Error
+	}
+	return nil
+}
+
+func FlushTenantUsages() {
+	tenantUsageLock.Lock()
+	pending := pendingTenantUsages
+	pendingTenantUsages = make(map[tenantUsageKey]*TenantUsageCounter)
+	tenantUsageLock.Unlock()
+	for key, counter := range pending {
+		err := flushTenantUsage(key, counter)
+		if err != nil {
+			common.SysError("failed to flush tenant usage: " + err.Error())
+		}
+	}
+}
+
+func SyncTenantUsages(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushTenantUsages()
+	}
+}
+
+// GetMonthlyTenantUsages sums up the daily usages of a calendar month, month is like 2006-01
+func GetMonthlyTenantUsages(month string) (usages []*TenantUsage, err error) {
+	err = DB.Model(&TenantUsage{}).Select(
+		"tenant, model_name, sum(requests) as requests, sum(prompt_tokens) as prompt_tokens, "+
+			"sum(completion_tokens) as completion_tokens, sum(quota) as quota",
+	).Where("day LIKE ?", month+"-%").Group("tenant, model_name").Order("tenant, model_name").Scan(&usages).Error
+	return usages, err
+}
+
+// UsageExport records the months which have been exported already
+type UsageExport struct {
+	Id          int    `json:"id"`
+	Month       string `json:"month" gorm:"type:varchar(7);uniqueIndex"`
+	Location    string `json:"location"`
+	CreatedTime int64  `json:"created_time" gorm:"bigint"`
+}
+
+func IsUsageExported(month string) bool {
+	var count int64
+	DB.Model(&UsageExport{}).Where("month = ?", month).Count(&count)
+	return count > 0
+}
+
+func RecordUsageExport(month string, location string) error {
+	return DB.Create(&UsageExport{
+		Month:       month,
+		Location:    location,
+		CreatedTime: common.GetTimestamp(),
+	}).Error
+}
@@ -0,0 +1,164 @@
+package model
+
+import (
+	"one-api/common"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// UserUsage is the daily usage of a user on a model with a token, it stays exact when the consume logs are sampled
+type UserUsage struct {
+	Id               int    `json:"id"`
+	UserId           int    `json:"user_id" gorm:"uniqueIndex:idx_user_usage"`
+	Day              string `json:"day" gorm:"type:varchar(10);uniqueIndex:idx_user_usage"` // 2006-01-02, local time
+	ModelName        string `json:"model_name" gorm:"type:varchar(64);uniqueIndex:idx_user_usage"`
+	TokenName        string `json:"token_name" gorm:"type:varchar(64);uniqueIndex:idx_user_usage"`
+	Requests         int    `json:"requests" gorm:"default:0"`
+	PromptTokens     int64  `json:"prompt_tokens" gorm:"bigint;default:0"`
+	CompletionTokens int64  `json:"completion_tokens" gorm:"bigint;default:0"`
+	Quota            int64  `json:"quota" gorm:"bigint;default:0"`
+}
+
+type userUsageKey struct {
+	userId    int
+	day       string
+	modelName string
+	tokenName string
+}
+
+var userUsageLock sync.Mutex
+var pendingUserUsages = make(map[userUsageKey]*UserUsage)
+var userUsageStart int64 // see getUserUsageStart, kept once known since it does not change
+
+// RecordUserUsage only accumulates in memory, SyncUserUsages writes it to the database periodically
+func RecordUserUsage(userId int, tokenName string, modelName string, promptTokens int, completionTokens int, quota int) {
+	key := userUsageKey{userId, time.Now().Format("2006-01-02"), modelName, tokenName}
+	userUsageLock.Lock()
+	defer userUsageLock.Unlock()
+	addPendingUserUsage(key, &UserUsage{Requests: 1, PromptTokens: int64(promptTokens), CompletionTokens: int64(completionTokens), Quota: int64(quota)})
+}
+
+// addPendingUserUsage must be called with userUsageLock held
+func addPendingUserUsage(key userUsageKey, delta *UserUsage) {
+	usage, ok := pendingUserUsages[key]
+	if !ok {
+		usage = &UserUsage{UserId: key.userId, Day: key.day, ModelName: key.modelName, TokenName: key.tokenName}
+		pendingUserUsages[key] = usage
+	}
+	usage.Requests += delta.Requests
+	usage.PromptTokens += delta.PromptTokens
+	usage.CompletionTokens += delta.CompletionTokens
+	usage.Quota += delta.Quota
+}
+
+func flushUserUsage(usage *UserUsage) error {
+	updates := map[string]interface{}{
+		"requests":          gorm.Expr("requests + ?", usage.Requests),
+		"prompt_tokens":     gorm.Expr("prompt_tokens + ?", usage.PromptTokens),
+		"completion_tokens": gorm.Expr("completion_tokens + ?", usage.CompletionTokens),
+		"quota":             gorm.Expr("quota + ?", usage.Quota),
+	}
+	where := DB.Model(&UserUsage{}).Where("user_id = ? and day = ? and model_name = ? and token_name = ?", usage.UserId, usage.Day, usage.ModelName, usage.TokenName)
+	result := where.Updates(updates)
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+	err := DB.Create(usage).Error
+	if err != nil {
+		// another node may have created the row in the meantime
+		return DB.Model(&UserUsage{}).Where("user_id = ? and day = ? and model_name = ? and token_name = ?", usage.UserId, usage.Day, usage.ModelName, usage.TokenName).
+			Updates(updates).Error
+	}
+	return nil
+}
+
+func FlushUserUsages() {
+	userUsageLock.Lock()
+	pending := pendingUserUsages
+	pendingUserUsages = make(map[userUsageKey]*UserUsage)
+	userUsageLock.Unlock()
+	for key, usage := range pending {
+		err := flushUserUsage(usage)
+		if err != nil {
+			// the usage is kept for the next flush rather than lost, the logs may be sampled
+			common.SysError("failed to flush user usage: " + err.Error())
+			userUsageLock.Lock()
+			addPendingUserUsage(key, usage)
+			userUsageLock.Unlock()
+		}
+	}
+}
+
+func SyncUserUsages(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		FlushUserUsages()
+	}
+}
+
+// getUserUsageStart is the start of the first full day of the daily usages, the consume logs of the days before are
+// not sampled and are the only record of them, 0 if there is no daily usage yet
+func getUserUsageStart() (int64, error) {
+	userUsageLock.Lock()
+	start := userUsageStart
+	userUsageLock.Unlock()
+	if start != 0 {
+		return start, nil
+	}
+	var firstDay string
+	err := DB.Model(&UserUsage{}).Select("COALESCE(min(day), '')").Scan(&firstDay).Error
+	if err != nil || firstDay == "" {
+		return 0, err
+	}
+	first, err := time.ParseInLocation("2006-01-02", firstDay, time.Local)
+	if err != nil {
+		return 0, err
+	}
+	start = first.AddDate(0, 0, 1).Unix()
+	userUsageLock.Lock()
+	userUsageStart = start
+	userUsageLock.Unlock()
+	return start, nil
+}
+
+func getUsageDays(startTimestamp int64, endTimestamp int64) (string, string) {
+	return time.Unix(startTimestamp, 0).Format("2006-01-02"), time.Unix(endTimestamp-1, 0).Format("2006-01-02")
+}
+
+// getUserUsageStatementItems is GetUserStatementItems on the daily usages, the timestamps are rounded to the days
+func getUserUsageStatementItems(userId int, startTimestamp int64, endTimestamp int64) (items []*StatementItem, err error) {
+	FlushUserUsages()
+	startDay, endDay := getUsageDays(startTimestamp, endTimestamp)
+	err = DB.Model(&UserUsage{}).Select(
+		"model_name, token_name, sum(requests) as requests, sum(prompt_tokens) as prompt_tokens, "+
+			"sum(completion_tokens) as completion_tokens, sum(quota) as quota",
+	).Where("user_id = ? and day >= ? and day <= ?", userId, startDay, endDay).
+		Group("model_name, token_name").Order("model_name, token_name").Scan(&items).Error
+	return items, err
+}
+
+// sumUserUsageQuota is SumUserConsumedQuota on the daily usages, the first time is the start of the first day
+func sumUserUsageQuota(userId int, startTimestamp int64, endTimestamp int64) (quota int64, firstTime int64, err error) {
+	FlushUserUsages()
+	startDay, endDay := getUsageDays(startTimestamp, endTimestamp)
+	var result struct {
+		Quota    int64
+		FirstDay string
+	}
+	err = DB.Model(&UserUsage{}).Select("COALESCE(sum(quota), 0) as quota, COALESCE(min(day), '') as first_day").
+		Where("user_id = ? and day >= ? and day <= ?", userId, startDay, endDay).Scan(&result).Error
+	if err != nil || result.FirstDay == "" {
+		return result.Quota, 0, err
+	}
+	first, err := time.ParseInLocation("2006-01-02", result.FirstDay, time.Local)
+	if err != nil {
+		return result.Quota, 0, err
+	}
+	firstTime = first.Unix()
+	if firstTime < startTimestamp {
+		firstTime = startTimestamp
+	}
+	return result.Quota, firstTime, nil
+}
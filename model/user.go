@@ -11,23 +11,31 @@ import (
 // User if you add sensitive fields, don't forget to clean them in setupLogin function.
 // Otherwise, the sensitive information will be saved on local storage in plain text!
 type User struct {
-	Id               int    `json:"id"`
-	Username         string `json:"username" gorm:"unique;index" validate:"max=12"`
-	Password         string `json:"password" gorm:"not null;" validate:"min=8,max=20"`
-	DisplayName      string `json:"display_name" gorm:"index" validate:"max=20"`
-	Role             int    `json:"role" gorm:"type:int;default:1"`   // admin, common
-	Status           int    `json:"status" gorm:"type:int;default:1"` // enabled, disabled
-	Email            string `json:"email" gorm:"index" validate:"max=50"`
-	GitHubId         string `json:"github_id" gorm:"column:github_id;index"`
-	WeChatId         string `json:"wechat_id" gorm:"column:wechat_id;index"`
-	VerificationCode string `json:"verification_code" gorm:"-:all"`                                    // this field is only for Email verification, don't save it to database!
-	AccessToken      string `json:"access_token" gorm:"type:char(32);column:access_token;uniqueIndex"` // this token is for system management
-	Quota            int    `json:"quota" gorm:"type:int;default:0"`
-	UsedQuota        int    `json:"used_quota" gorm:"type:int;default:0;column:used_quota"` // used quota
-	RequestCount     int    `json:"request_count" gorm:"type:int;default:0;"`               // request number
-	Group            string `json:"group" gorm:"type:varchar(32);default:'default'"`
-	AffCode          string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
-	InviterId        int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	Id                int    `json:"id"`
+	Username          string `json:"username" gorm:"unique;index" validate:"max=12"`
+	Password          string `json:"password" gorm:"not null;" validate:"min=8,max=20"`
+	DisplayName       string `json:"display_name" gorm:"index" validate:"max=20"`
+	Role              int    `json:"role" gorm:"type:int;default:1"`   // admin, common
+	Status            int    `json:"status" gorm:"type:int;default:1"` // enabled, disabled
+	Email             string `json:"email" gorm:"index" validate:"max=50"`
+	GitHubId          string `json:"github_id" gorm:"column:github_id;index"`
+	WeChatId          string `json:"wechat_id" gorm:"column:wechat_id;index"`
+	LDAPId            string `json:"ldap_id" gorm:"column:ldap_id;index"`                               // the username of the user in the LDAP directory
+	VerificationCode  string `json:"verification_code" gorm:"-:all"`                                    // this field is only for Email verification, don't save it to database!
+	AccessToken       string `json:"access_token" gorm:"type:char(32);column:access_token;uniqueIndex"` // this token is for system management
+	Quota             int    `json:"quota" gorm:"type:int;default:0"`
+	UsedQuota         int    `json:"used_quota" gorm:"type:int;default:0;column:used_quota"` // used quota
+	RequestCount      int    `json:"request_count" gorm:"type:int;default:0;"`               // request number
+	Group             string `json:"group" gorm:"type:varchar(32);default:'default'"`
+	AffCode           string `json:"aff_code" gorm:"type:varchar(32);column:aff_code;uniqueIndex"`
+	InviterId         int    `json:"inviter_id" gorm:"type:int;column:inviter_id;index"`
+	BillingMode       int    `json:"billing_mode" gorm:"type:int;default:1"`
+	CreditLimit       int    `json:"credit_limit" gorm:"type:int;default:0"`                // only works in postpaid mode
+	Priority          int    `json:"priority" gorm:"type:int;default:2"`                    // scheduling priority of the requests when the channels are congested
+	TOTPEnabled       bool   `json:"totp_enabled" gorm:"column:totp_enabled;default:false"` // only changed by the two-factor authentication endpoints
+	TOTPSecret        string `json:"-" gorm:"column:totp_secret"`
+	TOTPRecoveryCodes string `json:"-" gorm:"column:totp_recovery_codes;type:text"` // the bcrypt hashes of the unused recovery codes in JSON
+	TOTPLastCounter   int64  `json:"-" gorm:"column:totp_last_counter;default:0"`   // the period of the last accepted code, a code is only accepted once
 }
 
 func GetMaxUserId() int {
@@ -93,15 +101,18 @@ func (user *User) Insert(inviterId int) error {
 		return result.Error
 	}
 	if common.QuotaForNewUser > 0 {
+		_ = addCreditBucket(DB, user.Id, common.QuotaForNewUser, common.PromoCreditExpireDays, CreditSourceRegister)
 		RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("新用户注册赠送 %s", common.LogQuota(common.QuotaForNewUser)))
 	}
 	if inviterId != 0 {
 		if common.QuotaForInvitee > 0 {
 			_ = IncreaseUserQuota(user.Id, common.QuotaForInvitee)
+			_ = addCreditBucket(DB, user.Id, common.QuotaForInvitee, common.PromoCreditExpireDays, CreditSourceInvitation)
 			RecordLog(user.Id, LogTypeSystem, fmt.Sprintf("使用邀请码赠送 %s", common.LogQuota(common.QuotaForInvitee)))
 		}
 		if common.QuotaForInviter > 0 {
 			_ = IncreaseUserQuota(inviterId, common.QuotaForInviter)
+			_ = addCreditBucket(DB, inviterId, common.QuotaForInviter, common.PromoCreditExpireDays, CreditSourceInvitation)
 			RecordLog(inviterId, LogTypeSystem, fmt.Sprintf("邀请用户赠送 %s", common.LogQuota(common.QuotaForInviter)))
 		}
 	}
@@ -169,6 +180,14 @@ func (user *User) FillUserByGitHubId() error {
 	return nil
 }
 
+func (user *User) FillUserByLDAPId() error {
+	if user.LDAPId == "" {
+		return errors.New("LDAP id 为空！")
+	}
+	DB.Where(User{LDAPId: user.LDAPId}).First(user)
+	return nil
+}
+
 func (user *User) FillUserByWeChatId() error {
 	if user.WeChatId == "" {
 		return errors.New("WeChat id 为空！")
@@ -197,6 +216,10 @@ func IsGitHubIdAlreadyTaken(githubId string) bool {
 	return DB.Where("github_id = ?", githubId).Find(&User{}).RowsAffected == 1
 }
 
+func IsLDAPIdAlreadyTaken(ldapId string) bool {
+	return DB.Where("ldap_id = ?", ldapId).Find(&User{}).RowsAffected == 1
+}
+
 func IsUsernameAlreadyTaken(username string) bool {
 	return DB.Where("username = ?", username).Find(&User{}).RowsAffected == 1
 }
@@ -256,6 +279,34 @@ func GetUserQuota(id int) (quota int, err error) {
 	return quota, err
 }
 
+// GetUserCreditLimit returns how far the quota of the user may go below zero
+func GetUserCreditLimit(id int) (creditLimit int, err error) {
+	user := User{}
+	err = DB.Model(&User{}).Where("id = ?", id).Select("billing_mode", "credit_limit").First(&user).Error
+	if err != nil || user.BillingMode != common.UserBillingModePostpaid {
+		return 0, err
+	}
+	return user.CreditLimit, nil
+}
+
+// UpdateUserBillingMode can set the credit limit to zero, which Update() skips
+func UpdateUserBillingMode(id int, billingMode int, creditLimit int) error {
+	if billingMode != common.UserBillingModePrepaid && billingMode != common.UserBillingModePostpaid {
+		return errors.New("无效的计费模式")
+	}
+	if creditLimit < 0 {
+		return errors.New("信用额度不能为负数")
+	}
+	return DB.Model(&User{}).Where("id = ?", id).Updates(map[string]interface{}{
+		"billing_mode": billingMode,
+		"credit_limit": creditLimit,
+	}).Error
+}
+
+func UpdateUserRole(id int, role int) error {
+	return DB.Model(&User{}).Where("id = ?", id).Update("role", role).Error
+}
+
 func GetUserUsedQuota(id int) (quota int, err error) {
 	err = DB.Model(&User{}).Where("id = ?", id).Select("used_quota").Find(&quota).Error
 	return quota, err
@@ -266,11 +317,31 @@ func GetUserEmail(id int) (email string, err error) {
 	return email, err
 }
 
+// IsValidPriority accepts 0, which leaves the priority as it is for the users and follows the user for the tokens
+func IsValidPriority(priority int) bool {
+	return priority == 0 || (priority >= common.PriorityLow && priority <= common.PriorityHigh)
+}
+
+func GetUserPriority(id int) (priority int, err error) {
+	err = DB.Model(&User{}).Where("id = ?", id).Select("priority").Find(&priority).Error
+	return priority, err
+}
+
 func GetUserGroup(id int) (group string, err error) {
 	err = DB.Model(&User{}).Where("id = ?", id).Select("`group`").Find(&group).Error
 	return group, err
 }
 
+type GroupUserCount struct {
+	Group string `json:"group"`
+	Count int    `json:"count"`
+}
+
+func GetGroupUserCounts() (counts []*GroupUserCount, err error) {
+	err = DB.Model(&User{}).Select("`group`, count(*) as count").Group("group").Scan(&counts).Error
+	return counts, err
+}
+
 func IncreaseUserQuota(id int, quota int) (err error) {
 	if quota < 0 {
 		return errors.New("quota 不能为负数！")
@@ -292,7 +363,9 @@ func GetRootUserEmail() (email string) {
 	return email
 }
 
-func UpdateUserUsedQuotaAndRequestCount(id int, quota int) {
+// UpdateUserUsedQuotaAndRequestCount counts the usage of the user, the usage paid by an organization doesn't consume
+// the expiring credits of the user
+func UpdateUserUsedQuotaAndRequestCount(id int, quota int, organizationId int) {
 	err := DB.Model(&User{}).Where("id = ?", id).Updates(
 		map[string]interface{}{
 			"used_quota":    gorm.Expr("used_quota + ?", quota),
@@ -302,6 +375,9 @@ func UpdateUserUsedQuotaAndRequestCount(id int, quota int) {
 	if err != nil {
 		common.SysError("failed to update user used quota and request count: " + err.Error())
 	}
+	if organizationId == 0 {
+		consumeCreditBuckets(id, quota)
+	}
 }
 
 func GetUsernameById(id int) (username string) {
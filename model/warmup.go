@@ -0,0 +1,38 @@
+package model
+
+import (
+	"fmt"
+	"one-api/common"
+)
+
+// WarmUpTokenCache loads the most recently used tokens and their users into Redis,
+// so that the first requests after a deploy don't all hit the database
+func WarmUpTokenCache(count int) int {
+	if !common.RedisEnabled || count <= 0 {
+		return 0
+	}
+	var tokens []*Token
+	err := DB.Select("`key`", "user_id").Where("status = ?", common.TokenStatusEnabled).
+		Order("accessed_time desc").Limit(count).Find(&tokens).Error
+	if err != nil {
+		common.SysError("failed to fetch tokens to warm up: " + err.Error())
+		return 0
+	}
+	warmed := 0
+	userIds := make(map[int]bool)
+	for _, token := range tokens {
+		if _, err := CacheGetTokenByKey(token.Key); err != nil {
+			common.SysError(fmt.Sprintf("failed to warm up token of user #%d: %s", token.UserId, err.Error()))
+			continue
+		}
+		warmed++
+		if userIds[token.UserId] {
+			continue
+		}
+		userIds[token.UserId] = true
+		_, _ = CacheGetUserGroup(token.UserId)
+		_, _ = CacheGetUserAvailableQuota(token.UserId)
+		CacheIsUserEnabled(token.UserId)
+	}
+	return warmed
+}
@@ -0,0 +1,193 @@
+package model
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"one-api/common"
+	"strconv"
+	"time"
+)
+
+const (
+	WebhookEventTokenCreated   = "token.created"
+	WebhookEventTokenRotated   = "token.rotated"
+	WebhookEventTokenEnabled   = "token.enabled"
+	WebhookEventTokenDisabled  = "token.disabled"
+	WebhookEventTokenExpired   = "token.expired"
+	WebhookEventTokenExhausted = "token.exhausted"
+	WebhookEventTokenDeleted   = "token.deleted"
+)
+
+// webhookRetryDelays is the wait before each retry, the delivery is given up after the last one
+var webhookRetryDelays = []int64{60, 5 * 60, 30 * 60, 2 * 60 * 60, 6 * 60 * 60}
+
+type WebhookDelivery struct {
+	Id            int    `json:"id"`
+	Event         string `json:"event" gorm:"type:varchar(32);index"`
+	Payload       string `json:"payload" gorm:"type:text"`
+	Status        int    `json:"status" gorm:"index;default:1"`
+	Attempts      int    `json:"attempts" gorm:"default:0"`
+	ResponseCode  int    `json:"response_code"`
+	LastError     string `json:"last_error"`
+	CreatedTime   int64  `json:"created_time" gorm:"bigint;index"`
+	NextRetryTime int64  `json:"next_retry_time" gorm:"bigint;index"`
+}
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+type webhookTokenPayload struct {
+	Id             int    `json:"id"`
+	UserId         int    `json:"user_id"`
+	Name           string `json:"name"`
+	KeySuffix      string `json:"key_suffix"` // the full key is never sent
+	Status         int    `json:"status"`
+	ExpiredTime    int64  `json:"expired_time"`
+	RemainQuota    int    `json:"remain_quota"`
+	UnlimitedQuota bool   `json:"unlimited_quota"`
+}
+
+func buildTokenPayload(token *Token) *webhookTokenPayload {
+	keySuffix := token.Key
+	if len(keySuffix) > 4 {
+		keySuffix = keySuffix[len(keySuffix)-4:]
+	}
+	return &webhookTokenPayload{
+		Id:             token.Id,
+		UserId:         token.UserId,
+		Name:           token.Name,
+		KeySuffix:      keySuffix,
+		Status:         token.Status,
+		ExpiredTime:    token.ExpiredTime,
+		RemainQuota:    token.RemainQuota,
+		UnlimitedQuota: token.UnlimitedQuota,
+	}
+}
+
+// FireTokenWebhook records the event and tries to deliver it right away, failed deliveries are retried by RetryWebhookDeliveries
+func FireTokenWebhook(event string, token *Token) {
+	if common.WebhookURL == "" {
+		return
+	}
+	payload, err := json.Marshal(map[string]interface{}{
+		"event":     event,
+		"timestamp": common.GetTimestamp(),
+		"token":     buildTokenPayload(token),
+	})
+	if err != nil {
+		common.SysError("failed to marshal webhook payload: " + err.Error())
+		return
+	}
+	delivery := &WebhookDelivery{
+		Event:       event,
+		Payload:     string(payload),
+		Status:      common.WebhookDeliveryStatusPending,
+		CreatedTime: common.GetTimestamp(),
+		// keep the retry worker away until the first attempt is done
+		NextRetryTime: common.GetTimestamp() + webhookRetryDelays[0],
+	}
+	err = DB.Create(delivery).Error
+	if err != nil {
+		common.SysError("failed to record webhook delivery: " + err.Error())
+		return
+	}
+	go delivery.Deliver()
+}
+
+func signWebhookPayload(timestamp string, payload string) string {
+	h := hmac.New(sha256.New, []byte(common.WebhookSecret))
+	h.Write([]byte(timestamp + "." + payload))
+	return "sha256=" + hex.EncodeToString(h.Sum(nil))
+}
+
+func (delivery *WebhookDelivery) send() (int, error) {
+	req, err := http.NewRequest("POST", common.WebhookURL, bytes.NewBufferString(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(common.GetTimestamp(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Webhook-Event", delivery.Event)
+	req.Header.Set("X-Webhook-Delivery", strconv.Itoa(delivery.Id))
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if common.WebhookSecret != "" {
+		req.Header.Set("X-Webhook-Signature", signWebhookPayload(timestamp, delivery.Payload))
+	}
+	resp, err := webhookClient.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Deliver makes one attempt and schedules the next one if it fails
+func (delivery *WebhookDelivery) Deliver() {
+	responseCode, err := delivery.send()
+	delivery.Attempts++
+	delivery.ResponseCode = responseCode
+	if err == nil {
+		delivery.Status = common.WebhookDeliveryStatusSuccess
+		delivery.LastError = ""
+	} else {
+		delivery.LastError = err.Error()
+		if delivery.Attempts > len(webhookRetryDelays) {
+			delivery.Status = common.WebhookDeliveryStatusFailed
+		} else {
+			delivery.Status = common.WebhookDeliveryStatusPending
+			delivery.NextRetryTime = common.GetTimestamp() + webhookRetryDelays[delivery.Attempts-1]
+		}
+	}
+	err = DB.Model(delivery).Select("status", "attempts", "response_code", "last_error", "next_retry_time").Updates(delivery).Error
+	if err != nil {
+		common.SysError("failed to update webhook delivery: " + err.Error())
+	}
+}
+
+func RetryWebhookDeliveries(frequency int) {
+	for {
+		time.Sleep(time.Duration(frequency) * time.Second)
+		if common.WebhookURL == "" {
+			continue
+		}
+		var deliveries []*WebhookDelivery
+		err := DB.Where("status = ? and next_retry_time <= ?", common.WebhookDeliveryStatusPending, common.GetTimestamp()).
+			Order("id").Limit(100).Find(&deliveries).Error
+		if err != nil {
+			common.SysError("failed to fetch webhook deliveries: " + err.Error())
+			continue
+		}
+		for _, delivery := range deliveries {
+			delivery.Deliver()
+		}
+	}
+}
+
+func GetAllWebhookDeliveries(startIdx int, num int) (deliveries []*WebhookDelivery, err error) {
+	err = DB.Order("id desc").Limit(num).Offset(startIdx).Find(&deliveries).Error
+	return deliveries, err
+}
+
+// ResendWebhookDelivery delivers it again immediately, regardless of its status
+func ResendWebhookDelivery(id int) (*WebhookDelivery, error) {
+	if id == 0 {
+		return nil, errors.New("id 为空！")
+	}
+	delivery := &WebhookDelivery{}
+	err := DB.First(delivery, "id = ?", id).Error
+	if err != nil {
+		return nil, err
+	}
+	delivery.Deliver()
+	return delivery, nil
+}
@@ -14,6 +14,10 @@ func SetApiRouter(router *gin.Engine) {
 	apiRouter.Use(middleware.GlobalAPIRateLimit())
 	{
 		apiRouter.GET("/status", controller.GetStatus)
+		apiRouter.GET("/healthz", controller.GetHealth)
+		apiRouter.GET("/readyz", controller.GetReadiness)
+		apiRouter.GET("/slow_queries", middleware.RootAuth(), controller.GetSlowQueries)
+		apiRouter.GET("/tokenizer", middleware.AdminAuth(), controller.GetTokenizer)
 		apiRouter.GET("/notice", controller.GetNotice)
 		apiRouter.GET("/about", controller.GetAbout)
 		apiRouter.GET("/home_page_content", controller.GetHomePageContent)
@@ -29,17 +33,40 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			userRoute.POST("/register", middleware.CriticalRateLimit(), middleware.TurnstileCheck(), controller.Register)
 			userRoute.POST("/login", middleware.CriticalRateLimit(), controller.Login)
+			userRoute.POST("/login/ldap", middleware.CriticalRateLimit(), controller.LDAPLogin)
+			userRoute.POST("/login/totp", middleware.CriticalRateLimit(), controller.LoginTOTP)
 			userRoute.GET("/logout", controller.Logout)
+			userRoute.GET("/epay/notify", controller.EpayNotify)
+			userRoute.POST("/epay/notify", controller.EpayNotify)
 
 			selfRoute := userRoute.Group("/")
 			selfRoute.Use(middleware.UserAuth())
 			{
 				selfRoute.GET("/self", controller.GetSelf)
+				selfRoute.GET("/self/forecast", controller.GetSelfForecast)
 				selfRoute.PUT("/self", controller.UpdateSelf)
 				selfRoute.DELETE("/self", controller.DeleteSelf)
 				selfRoute.GET("/token", controller.GenerateAccessToken)
 				selfRoute.GET("/aff", controller.GetAffCode)
 				selfRoute.POST("/topup", controller.TopUp)
+				selfRoute.POST("/pay", controller.RequestPay)
+				selfRoute.GET("/topup/order/self", controller.GetSelfTopUpOrders)
+				selfRoute.GET("/statement", controller.GetSelfStatement)
+				selfRoute.POST("/transfer", controller.TransferQuota)
+				selfRoute.GET("/transfer/self", controller.GetSelfQuotaTransfers)
+				selfRoute.GET("/credit/self", controller.GetSelfCreditBuckets)
+				selfRoute.GET("/coupon/self", controller.GetSelfCoupons)
+				selfRoute.POST("/coupon", controller.ClaimCoupon)
+				selfRoute.GET("/coupon/ledger/self", controller.GetSelfCouponLedgers)
+				selfRoute.GET("/alert/self", controller.GetSelfSpendingAlerts)
+				selfRoute.POST("/alert", controller.AddSpendingAlert)
+				selfRoute.PUT("/alert", controller.UpdateSpendingAlert)
+				selfRoute.DELETE("/alert/:id", controller.DeleteSpendingAlert)
+				selfRoute.GET("/totp", controller.GetTOTPStatus)
+				selfRoute.POST("/totp/setup", controller.SetupTOTP)
+				selfRoute.POST("/totp/enable", middleware.CriticalRateLimit(), controller.EnableTOTP)
+				selfRoute.POST("/totp/disable", middleware.CriticalRateLimit(), controller.DisableTOTP)
+				selfRoute.POST("/totp/recovery_codes", middleware.CriticalRateLimit(), controller.RegenerateRecoveryCodes)
 			}
 
 			adminRoute := userRoute.Group("/")
@@ -47,7 +74,12 @@ func SetApiRouter(router *gin.Engine) {
 			{
 				adminRoute.GET("/", controller.GetAllUsers)
 				adminRoute.GET("/search", controller.SearchUsers)
+				adminRoute.GET("/topup/order", controller.GetAllTopUpOrders)
+				adminRoute.GET("/transfer", controller.GetAllQuotaTransfers)
 				adminRoute.GET("/:id", controller.GetUser)
+				adminRoute.GET("/:id/statement", controller.GetUserStatement)
+				adminRoute.GET("/:id/credit", controller.GetUserCreditBuckets)
+				adminRoute.POST("/credit", controller.GrantCredit)
 				adminRoute.POST("/", controller.CreateUser)
 				adminRoute.POST("/manage", controller.ManageUser)
 				adminRoute.PUT("/", controller.UpdateUser)
@@ -59,6 +91,9 @@ func SetApiRouter(router *gin.Engine) {
 		{
 			optionRoute.GET("/", controller.GetOptions)
 			optionRoute.PUT("/", controller.UpdateOption)
+			optionRoute.GET("/override", controller.GetOptionOverrides)
+			optionRoute.PUT("/override", controller.SaveOptionOverride)
+			optionRoute.DELETE("/override/:id", controller.DeleteOptionOverride)
 		}
 		channelRoute := apiRouter.Group("/channel")
 		channelRoute.Use(middleware.AdminAuth())
@@ -66,12 +101,20 @@ func SetApiRouter(router *gin.Engine) {
 			channelRoute.GET("/", controller.GetAllChannels)
 			channelRoute.GET("/search", controller.SearchChannels)
 			channelRoute.GET("/models", controller.ListModels)
+			channelRoute.GET("/latency", controller.GetChannelLatencies)
+			channelRoute.GET("/circuit", controller.GetChannelCircuits)
+			channelRoute.GET("/margin", controller.GetChannelMargins)
+			channelRoute.GET("/export", middleware.RootAuth(), controller.ExportChannels)
 			channelRoute.GET("/:id", controller.GetChannel)
 			channelRoute.GET("/test", controller.TestAllChannels)
 			channelRoute.GET("/test/:id", controller.TestChannel)
+			channelRoute.GET("/probe/:id", controller.GetChannelProbes)
 			channelRoute.GET("/update_balance", controller.UpdateAllChannelsBalance)
 			channelRoute.GET("/update_balance/:id", controller.UpdateChannelBalance)
+			channelRoute.GET("/sync_models", controller.SyncModels)
+			channelRoute.GET("/sync_models/:id", controller.SyncChannelModels)
 			channelRoute.POST("/", controller.AddChannel)
+			channelRoute.POST("/import", controller.ImportChannels)
 			channelRoute.PUT("/", controller.UpdateChannel)
 			channelRoute.DELETE("/:id", controller.DeleteChannel)
 		}
@@ -83,8 +126,25 @@ func SetApiRouter(router *gin.Engine) {
 			tokenRoute.GET("/:id", controller.GetToken)
 			tokenRoute.POST("/", controller.AddToken)
 			tokenRoute.PUT("/", controller.UpdateToken)
+			tokenRoute.POST("/:id/rotate", controller.RotateToken)
+			tokenRoute.PUT("/:id/system_prompt", middleware.AdminAuth(), controller.UpdateTokenSystemPrompt)
+			tokenRoute.PUT("/:id/fine_tuning", middleware.AdminAuth(), controller.UpdateTokenFineTuning)
 			tokenRoute.DELETE("/:id", controller.DeleteToken)
 		}
+		organizationRoute := apiRouter.Group("/organization")
+		organizationRoute.Use(middleware.UserAuth())
+		{
+			organizationRoute.GET("/", middleware.AdminAuth(), controller.GetAllOrganizations)
+			organizationRoute.GET("/self", controller.GetSelfOrganizations)
+			organizationRoute.GET("/:id/usage", controller.GetOrganizationUsage)
+			organizationRoute.POST("/", middleware.AdminAuth(), controller.AddOrganization)
+			organizationRoute.PUT("/", middleware.AdminAuth(), controller.UpdateOrganization)
+			organizationRoute.DELETE("/:id", middleware.AdminAuth(), controller.DeleteOrganization)
+			organizationRoute.POST("/:id/member", controller.AddOrganizationMember)
+			organizationRoute.PUT("/:id/member", controller.UpdateOrganizationMember)
+			organizationRoute.DELETE("/:id/member/:user_id", controller.DeleteOrganizationMember)
+			organizationRoute.POST("/:id/fund", controller.FundOrganization)
+		}
 		redemptionRoute := apiRouter.Group("/redemption")
 		redemptionRoute.Use(middleware.AdminAuth())
 		{
@@ -92,20 +152,90 @@ func SetApiRouter(router *gin.Engine) {
 			redemptionRoute.GET("/search", controller.SearchRedemptions)
 			redemptionRoute.GET("/:id", controller.GetRedemption)
 			redemptionRoute.POST("/", controller.AddRedemption)
+			redemptionRoute.POST("/revoke", controller.RevokeRedemptions)
+			redemptionRoute.GET("/batch/:batch/export", controller.ExportRedemptions)
 			redemptionRoute.PUT("/", controller.UpdateRedemption)
 			redemptionRoute.DELETE("/:id", controller.DeleteRedemption)
 		}
+		couponRoute := apiRouter.Group("/coupon")
+		couponRoute.Use(middleware.AdminAuth())
+		{
+			couponRoute.GET("/", controller.GetAllCoupons)
+			couponRoute.GET("/search", controller.SearchCoupons)
+			couponRoute.GET("/ledger", controller.GetCouponLedgers)
+			couponRoute.GET("/:id", controller.GetCoupon)
+			couponRoute.POST("/", controller.AddCoupon)
+			couponRoute.POST("/:id/assign", controller.AssignCoupon)
+			couponRoute.PUT("/", controller.UpdateCoupon)
+			couponRoute.DELETE("/:id", controller.DeleteCoupon)
+		}
 		logRoute := apiRouter.Group("/log")
 		logRoute.GET("/", middleware.AdminAuth(), controller.GetAllLogs)
 		logRoute.GET("/stat", middleware.AdminAuth(), controller.GetLogsStat)
+		logRoute.GET("/tenant_usage", middleware.AdminAuth(), controller.GetTenantUsage)
 		logRoute.GET("/self/stat", middleware.UserAuth(), controller.GetLogsSelfStat)
 		logRoute.GET("/search", middleware.AdminAuth(), controller.SearchAllLogs)
 		logRoute.GET("/self", middleware.UserAuth(), controller.GetUserLogs)
 		logRoute.GET("/self/search", middleware.UserAuth(), controller.SearchUserLogs)
+		webhookRoute := apiRouter.Group("/webhook")
+		webhookRoute.Use(middleware.AdminAuth())
+		{
+			webhookRoute.GET("/", controller.GetAllWebhookDeliveries)
+			webhookRoute.POST("/:id/resend", controller.ResendWebhookDelivery)
+		}
+		experimentRoute := apiRouter.Group("/experiment")
+		experimentRoute.Use(middleware.AdminAuth())
+		{
+			experimentRoute.GET("/", controller.GetAllExperiments)
+			experimentRoute.GET("/:id", controller.GetExperiment)
+			experimentRoute.GET("/:id/report", controller.GetExperimentReport)
+			experimentRoute.POST("/:id/judge", controller.JudgeExperiment)
+			experimentRoute.POST("/", controller.AddExperiment)
+			experimentRoute.PUT("/", controller.UpdateExperiment)
+			experimentRoute.DELETE("/:id", controller.DeleteExperiment)
+		}
+		featureFlagRoute := apiRouter.Group("/feature_flag")
+		featureFlagRoute.Use(middleware.AdminAuth())
+		{
+			featureFlagRoute.GET("/", controller.GetAllFeatureFlags)
+			featureFlagRoute.POST("/", controller.AddFeatureFlag)
+			featureFlagRoute.PUT("/", controller.UpdateFeatureFlag)
+			featureFlagRoute.DELETE("/:id", controller.DeleteFeatureFlag)
+		}
+		policyRoute := apiRouter.Group("/policy")
+		policyRoute.Use(middleware.AdminAuth())
+		{
+			policyRoute.GET("/", controller.GetAllPolicies)
+			policyRoute.GET("/:id", controller.GetPolicy)
+			policyRoute.POST("/", controller.AddPolicy)
+			policyRoute.PUT("/", controller.UpdatePolicy)
+			policyRoute.DELETE("/:id", controller.DeletePolicy)
+		}
+		channelGroupRoute := apiRouter.Group("/channel_group")
+		channelGroupRoute.Use(middleware.AdminAuth())
+		{
+			channelGroupRoute.GET("/", controller.GetAllChannelGroups)
+			channelGroupRoute.GET("/:id", controller.GetChannelGroup)
+			channelGroupRoute.POST("/", controller.AddChannelGroup)
+			channelGroupRoute.PUT("/", controller.UpdateChannelGroup)
+			channelGroupRoute.DELETE("/:id", controller.DeleteChannelGroup)
+		}
+		modelAliasRoute := apiRouter.Group("/model_alias")
+		modelAliasRoute.Use(middleware.AdminAuth())
+		{
+			modelAliasRoute.GET("/", controller.GetAllModelAliases)
+			modelAliasRoute.GET("/:id", controller.GetModelAlias)
+			modelAliasRoute.POST("/", controller.AddModelAlias)
+			modelAliasRoute.PUT("/", controller.UpdateModelAlias)
+			modelAliasRoute.DELETE("/:id", controller.DeleteModelAlias)
+		}
 		groupRoute := apiRouter.Group("/group")
 		groupRoute.Use(middleware.AdminAuth())
 		{
 			groupRoute.GET("/", controller.GetGroups)
+			groupRoute.GET("/ratio", controller.GetGroupRatios)
+			groupRoute.PUT("/ratio", controller.UpdateGroupRatio)
+			groupRoute.DELETE("/ratio/:group", controller.DeleteGroupRatio)
 		}
 	}
 }
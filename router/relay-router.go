@@ -15,30 +15,103 @@ func SetRelayRouter(router *gin.Engine) {
 		modelsRouter.GET("", controller.ListModels)
 		modelsRouter.GET("/:model", controller.RetrieveModel)
 	}
+	// the files are kept by the gateway, they are uploaded to the upstreams by the requests which use them
+	filesRouter := router.Group("/v1/files")
+	filesRouter.Use(middleware.TokenAuth(), middleware.RelayRateLimit())
+	{
+		filesRouter.POST("", controller.UploadFile)
+		filesRouter.GET("", controller.ListFiles)
+		filesRouter.GET("/:id", controller.RetrieveFile)
+		filesRouter.DELETE("/:id", controller.DeleteFile)
+		filesRouter.GET("/:id/content", controller.RetrieveFileContent)
+	}
+	batchesRouter := router.Group("/v1/batches")
+	batchesRouter.Use(middleware.TokenAuth(), middleware.RelayRateLimit())
+	{
+		batchesRouter.POST("", controller.CreateBatch)
+		batchesRouter.GET("", controller.ListBatches)
+		batchesRouter.GET("/:batch_id", controller.RetrieveBatch)
+		batchesRouter.POST("/:batch_id/cancel", controller.CancelBatch)
+	}
 	relayV1Router := router.Group("/v1")
-	relayV1Router.Use(middleware.TokenAuth(), middleware.Distribute())
+	relayV1Router.Use(middleware.TokenAuth(), middleware.RelayHooks(), middleware.Distribute(), middleware.RelayRateLimit(), middleware.ApplyPolicies(), controller.ModerateContent())
 	{
 		relayV1Router.POST("/completions", controller.Relay)
 		relayV1Router.POST("/chat/completions", controller.Relay)
 		relayV1Router.POST("/edits", controller.Relay)
 		relayV1Router.POST("/images/generations", controller.Relay)
-		relayV1Router.POST("/images/edits", controller.RelayNotImplemented)
-		relayV1Router.POST("/images/variations", controller.RelayNotImplemented)
+		relayV1Router.POST("/images/edits", controller.Relay)
+		relayV1Router.POST("/images/variations", controller.Relay)
 		relayV1Router.POST("/embeddings", controller.Relay)
 		relayV1Router.POST("/engines/:model/embeddings", controller.Relay)
-		relayV1Router.POST("/audio/transcriptions", controller.RelayNotImplemented)
-		relayV1Router.POST("/audio/translations", controller.RelayNotImplemented)
-		relayV1Router.GET("/files", controller.RelayNotImplemented)
-		relayV1Router.POST("/files", controller.RelayNotImplemented)
-		relayV1Router.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id", controller.RelayNotImplemented)
-		relayV1Router.GET("/files/:id/content", controller.RelayNotImplemented)
+		relayV1Router.POST("/audio/speech", controller.Relay)
+		relayV1Router.POST("/audio/transcriptions", controller.Relay)
+		relayV1Router.POST("/audio/translations", controller.Relay)
 		relayV1Router.POST("/fine-tunes", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine-tunes", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine-tunes/:id", controller.RelayNotImplemented)
 		relayV1Router.POST("/fine-tunes/:id/cancel", controller.RelayNotImplemented)
 		relayV1Router.GET("/fine-tunes/:id/events", controller.RelayNotImplemented)
+		relayV1Router.POST("/fine_tuning/jobs", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs/:fine_tuning_job_id", controller.Relay)
+		relayV1Router.POST("/fine_tuning/jobs/:fine_tuning_job_id/cancel", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs/:fine_tuning_job_id/events", controller.Relay)
+		relayV1Router.GET("/fine_tuning/jobs/:fine_tuning_job_id/checkpoints", controller.Relay)
 		relayV1Router.DELETE("/models/:model", controller.RelayNotImplemented)
 		relayV1Router.POST("/moderations", controller.Relay)
+		relayV1Router.POST("/responses", controller.Relay)
+		relayV1Router.GET("/responses/:response_id", controller.Relay)
+		relayV1Router.DELETE("/responses/:response_id", controller.Relay)
+		relayV1Router.POST("/responses/:response_id/cancel", controller.Relay)
+		relayV1Router.GET("/responses/:response_id/input_items", controller.Relay)
+		relayV1Router.POST("/assistants", controller.Relay)
+		relayV1Router.GET("/assistants", controller.Relay)
+		relayV1Router.GET("/assistants/:assistant_id", controller.Relay)
+		relayV1Router.POST("/assistants/:assistant_id", controller.Relay)
+		relayV1Router.DELETE("/assistants/:assistant_id", controller.Relay)
+		relayV1Router.POST("/threads", controller.Relay)
+		relayV1Router.POST("/threads/runs", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id", controller.Relay)
+		relayV1Router.DELETE("/threads/:thread_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/messages", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/messages", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/messages/:message_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/messages/:message_id", controller.Relay)
+		relayV1Router.DELETE("/threads/:thread_id/messages/:message_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/runs", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/runs", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/runs/:run_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/runs/:run_id", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/runs/:run_id/submit_tool_outputs", controller.Relay)
+		relayV1Router.POST("/threads/:thread_id/runs/:run_id/cancel", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/runs/:run_id/steps", controller.Relay)
+		relayV1Router.GET("/threads/:thread_id/runs/:run_id/steps/:step_id", controller.Relay)
+	}
+	// estimates the quota of a request without relaying it, so it is not rate limited
+	estimateRouter := router.Group("/v1")
+	estimateRouter.Use(middleware.TokenAuth(), middleware.Distribute())
+	{
+		estimateRouter.POST("/chat/completions/estimate", controller.EstimateQuota)
+		estimateRouter.POST("/completions/estimate", controller.EstimateQuota)
+	}
+	// counts the tokens of a prompt without relaying it, so it needs no channel
+	router.POST("/v1/tokenize", middleware.TokenAuth(), controller.Tokenize)
+	messagesRouter := router.Group("/v1/messages")
+	messagesRouter.Use(controller.AnthropicCompatible(), middleware.TokenAuth(), middleware.RelayHooks(), middleware.Distribute(), middleware.RelayRateLimit(), middleware.ApplyPolicies(), controller.ModerateContent())
+	{
+		messagesRouter.POST("", controller.RelayIngress)
+		messagesRouter.POST("/count_tokens", controller.CountIngressTokens)
+	}
+	realtimeRouter := router.Group("/v1/realtime")
+	realtimeRouter.Use(controller.RealtimeCompatible(), middleware.TokenAuth(), middleware.Distribute(), middleware.RelayRateLimit(), middleware.ApplyPolicies())
+	{
+		realtimeRouter.GET("", controller.Relay)
+	}
+	geminiRouter := router.Group("/v1beta/models")
+	geminiRouter.Use(controller.GeminiCompatible(), middleware.TokenAuth(), middleware.RelayHooks(), middleware.Distribute(), middleware.RelayRateLimit(), middleware.ApplyPolicies(), controller.ModerateContent())
+	{
+		geminiRouter.POST("/:action", controller.RelayGeminiIngress)
 	}
 }
@@ -50,6 +50,8 @@ function App() {
       localStorage.setItem('footer_html', data.footer_html);
       localStorage.setItem('quota_per_unit', data.quota_per_unit);
       localStorage.setItem('display_in_currency', data.display_in_currency);
+      localStorage.setItem('display_currency', data.display_currency);
+      localStorage.setItem('exchange_rate', data.exchange_rate);
       if (data.chat_link) {
         localStorage.setItem('chat_link', data.chat_link);
       } else {
@@ -139,6 +139,24 @@ const ChannelsTable = () => {
             已禁用
           </Label>
         );
+      case 3:
+        return (
+          <Label basic color='yellow'>
+            已自动禁用
+          </Label>
+        );
+      case 4:
+        return (
+          <Label basic color='orange'>
+            已超出预算
+          </Label>
+        );
+      case 5:
+        return (
+          <Label basic color='blue'>
+            维护中
+          </Label>
+        );
       default:
         return (
           <Label basic color='grey'>
@@ -208,6 +226,20 @@ const ChannelsTable = () => {
     }
   };
 
+  const syncChannelModels = async (id, name, idx) => {
+    const res = await API.get(`/api/channel/sync_models/${id}/`);
+    const { success, message, data } = res.data;
+    if (success) {
+      let newChannels = [...channels];
+      let realIdx = (activePage - 1) * ITEMS_PER_PAGE + idx;
+      newChannels[realIdx].models = data.models.join(',');
+      setChannels(newChannels);
+      showInfo(`通道 ${name} 模型同步成功，新增 ${data.added.length} 个，移除 ${data.removed.length} 个。`);
+    } else {
+      showError(message);
+    }
+  };
+
   const updateChannelBalance = async (id, name, idx) => {
     const res = await API.get(`/api/channel/update_balance/${id}/`);
     const { success, message, balance } = res.data;
@@ -226,9 +258,10 @@ const ChannelsTable = () => {
   const updateAllChannelsBalance = async () => {
     setUpdatingBalance(true);
     const res = await API.get(`/api/channel/update_balance`);
-    const { success, message } = res.data;
+    const { success, message, data } = res.data;
     if (success) {
-      showInfo('已更新完毕所有已启用通道余额！');
+      showInfo(`已更新 ${data.updated} 个通道的余额，${data.failed.length} 个失败，${data.skipped} 个不支持查询`);
+      await refresh();
     } else {
       showError(message);
     }
@@ -383,6 +416,14 @@ const ChannelsTable = () => {
                       >
                         测试
                       </Button>
+                      <Button
+                        size={'small'}
+                        onClick={() => {
+                          syncChannelModels(channel.id, channel.name, idx);
+                        }}
+                      >
+                        同步模型
+                      </Button>
                       {/*<Button*/}
                       {/*  size={'small'}*/}
                       {/*  positive*/}
@@ -15,10 +15,13 @@ const OperationSetting = () => {
     ChatLink: '',
     QuotaPerUnit: 0,
     AutomaticDisableChannelEnabled: '',
+    AutomaticEnableChannelEnabled: '',
     ChannelDisableThreshold: 0,
     LogConsumeEnabled: '',
     DisplayInCurrencyEnabled: '',
     DisplayTokenStatEnabled: '',
+    StatementCurrency: 'USD',
+    StatementExchangeRate: 0,
     ApproximateTokenEnabled: '',
     RetryTimes: 0,
   });
@@ -126,6 +129,12 @@ const OperationSetting = () => {
         if (originInputs['RetryTimes'] !== inputs.RetryTimes) {
           await updateOption('RetryTimes', inputs.RetryTimes);
         }
+        if (originInputs['StatementCurrency'] !== inputs.StatementCurrency) {
+          await updateOption('StatementCurrency', inputs.StatementCurrency);
+        }
+        if (originInputs['StatementExchangeRate'] !== inputs.StatementExchangeRate) {
+          await updateOption('StatementExchangeRate', inputs.StatementExchangeRate);
+        }
         break;
     }
   };
@@ -178,6 +187,26 @@ const OperationSetting = () => {
               placeholder='失败重试次数'
             />
           </Form.Group>
+          <Form.Group widths={4}>
+            <Form.Input
+              label='额度显示与账单货币'
+              name='StatementCurrency'
+              onChange={handleInputChange}
+              autoComplete='new-password'
+              value={inputs.StatementCurrency}
+              placeholder='例如 USD、CNY'
+            />
+            <Form.Input
+              label='汇率'
+              name='StatementExchangeRate'
+              onChange={handleInputChange}
+              autoComplete='new-password'
+              value={inputs.StatementExchangeRate}
+              type='number'
+              step='0.01'
+              placeholder='一美元能兑换的该货币'
+            />
+          </Form.Group>
           <Form.Group inline>
             <Form.Checkbox
               checked={inputs.LogConsumeEnabled === 'true'}
@@ -240,6 +269,12 @@ const OperationSetting = () => {
               name='AutomaticDisableChannelEnabled'
               onChange={handleInputChange}
             />
+            <Form.Checkbox
+              checked={inputs.AutomaticEnableChannelEnabled === 'true'}
+              label='测试恢复时自动启用通道'
+              name='AutomaticEnableChannelEnabled'
+              onChange={handleInputChange}
+            />
           </Form.Group>
           <Form.Button onClick={() => {
             submitConfig('monitor').then();
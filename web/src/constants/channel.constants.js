@@ -2,6 +2,8 @@ export const CHANNEL_OPTIONS = [
   { key: 1, text: 'OpenAI', value: 1, color: 'green' },
   { key: 14, text: 'Anthropic Claude', value: 14, color: 'black' },
   { key: 3, text: 'Azure OpenAI', value: 3, color: 'olive' },
+  { key: 21, text: 'AWS Bedrock', value: 21, color: 'yellow' },
+  { key: 20, text: 'Google Gemini', value: 20, color: 'orange' },
   { key: 11, text: 'Google PaLM2', value: 11, color: 'orange' },
   { key: 15, text: '百度文心千帆', value: 15, color: 'blue' },
   { key: 17, text: '阿里通义千问', value: 17, color: 'orange' },
@@ -9,6 +11,7 @@ export const CHANNEL_OPTIONS = [
   { key: 16, text: '智谱 ChatGLM', value: 16, color: 'violet' },
   { key: 19, text: 'MiniMax', value: 19, color: 'rose' },
   { key: 8, text: '自定义渠道', value: 8, color: 'pink' },
+  { key: 22, text: '本地模型（Ollama、vLLM、LM Studio）', value: 22, color: 'grey' },
   { key: 2, text: '代理：API2D', value: 2, color: 'blue' },
   { key: 5, text: '代理：OpenAI-SB', value: 5, color: 'brown' },
   { key: 7, text: '代理：OhMyGPT', value: 7, color: 'purple' },
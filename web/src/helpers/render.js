@@ -43,7 +43,19 @@ export function renderQuota(quota, digits = 2) {
   quotaPerUnit = parseFloat(quotaPerUnit);
   displayInCurrency = displayInCurrency === 'true';
   if (displayInCurrency) {
-    return '$' + (quota / quotaPerUnit).toFixed(digits);
+    let currency = localStorage.getItem('display_currency');
+    let exchangeRate = parseFloat(localStorage.getItem('exchange_rate'));
+    if (!currency || !exchangeRate) {
+      return '$' + (quota / quotaPerUnit).toFixed(digits);
+    }
+    let amount = (quota / quotaPerUnit * exchangeRate).toFixed(digits);
+    if (currency === 'USD') {
+      return '$' + amount;
+    }
+    if (currency === 'CNY') {
+      return '¥' + amount;
+    }
+    return amount + ' ' + currency;
   }
   return renderNumber(quota);
 }
@@ -10,6 +10,11 @@ const MODEL_MAPPING_EXAMPLE = {
   'gpt-4-32k-0314': 'gpt-4-32k'
 };
 
+const AZURE_DEPLOYMENTS_EXAMPLE = {
+  'gpt-4o': { deployment: 'prod-gpt-4o', api_version: '2024-06-01' },
+  'text-embedding-3-small': { deployment: 'embedding' }
+};
+
 const EditChannel = () => {
   const params = useParams();
   const navigate = useNavigate();
@@ -27,6 +32,7 @@ const EditChannel = () => {
     base_url: '',
     other: '',
     model_mapping: '',
+    azure_deployments: '',
     models: [],
     groups: ['default']
   };
@@ -44,11 +50,17 @@ const EditChannel = () => {
       let localModels = [];
       switch (value) {
         case 14:
-          localModels = ['claude-instant-1', 'claude-2'];
+          localModels = ['claude-instant-1', 'claude-2', 'claude-3-5-haiku-20241022', 'claude-3-5-sonnet-20241022', 'claude-3-7-sonnet-20250219', 'claude-sonnet-4-20250514', 'claude-opus-4-20250514'];
           break;
         case 11:
           localModels = ['PaLM-2'];
           break;
+        case 21:
+          localModels = ['claude-3-5-haiku-20241022', 'claude-3-5-sonnet-20241022', 'claude-3-7-sonnet-20250219', 'claude-sonnet-4-20250514', 'llama3-8b-instruct', 'llama3-70b-instruct', 'llama3.1-8b-instruct', 'llama3.1-70b-instruct', 'llama3.1-405b-instruct'];
+          break;
+        case 20:
+          localModels = ['gemini-1.5-flash', 'gemini-1.5-pro', 'gemini-2.0-flash', 'gemini-2.5-flash', 'gemini-2.5-pro'];
+          break;
         case 15:
           localModels = ['ERNIE-Bot', 'ERNIE-Bot-turbo', 'Embedding-V1'];
           break;
@@ -86,6 +98,9 @@ const EditChannel = () => {
       if (data.model_mapping !== '') {
         data.model_mapping = JSON.stringify(JSON.parse(data.model_mapping), null, 2);
       }
+      if (data.azure_deployments !== '') {
+        data.azure_deployments = JSON.stringify(JSON.parse(data.azure_deployments), null, 2);
+      }
       setInputs(data);
     } else {
       showError(message);
@@ -147,7 +162,7 @@ const EditChannel = () => {
   }, []);
 
   const submit = async () => {
-    if (!isEdit && (inputs.name === '' || inputs.key === '')) {
+    if (!isEdit && (inputs.name === '' || (inputs.key === '' && inputs.type !== 22))) {
       showInfo('请填写渠道名称和渠道密钥！');
       return;
     }
@@ -159,6 +174,10 @@ const EditChannel = () => {
       showInfo('模型映射必须是合法的 JSON 格式！');
       return;
     }
+    if (inputs.azure_deployments !== '' && !verifyJSON(inputs.azure_deployments)) {
+      showInfo('部署映射必须是合法的 JSON 格式！');
+      return;
+    }
     let localInputs = inputs;
     if (localInputs.base_url.endsWith('/')) {
       localInputs.base_url = localInputs.base_url.slice(0, localInputs.base_url.length - 1);
@@ -169,6 +188,9 @@ const EditChannel = () => {
     if (localInputs.model_mapping === '') {
       localInputs.model_mapping = '{}';
     }
+    if (localInputs.azure_deployments === '') {
+      localInputs.azure_deployments = '{}';
+    }
     let res;
     localInputs.models = localInputs.models.join(',');
     localInputs.group = localInputs.groups.join(',');
@@ -209,7 +231,7 @@ const EditChannel = () => {
             inputs.type === 3 && (
               <>
                 <Message>
-                  注意，<strong>模型部署名称必须和模型名称保持一致</strong>，因为 One API 会把请求体中的 model
+                  注意，未在部署映射中配置的模型，<strong>模型部署名称必须和模型名称保持一致</strong>，因为 One API 会把请求体中的 model
                   参数替换为你的部署名称（模型名称中的点会被剔除），<a target='_blank'
                                                                     href='https://github.com/songquanpeng/one-api/issues/133?notification_referrer_id=NT_kwDOAmJSYrM2NjIwMzI3NDgyOjM5OTk4MDUw#issuecomment-1571602271'>图片演示</a>。
                 </Message>
@@ -233,6 +255,17 @@ const EditChannel = () => {
                     autoComplete='new-password'
                   />
                 </Form.Field>
+                <Form.Field>
+                  <Form.TextArea
+                    label='部署映射'
+                    placeholder={`此项可选，为一个 JSON 字符串，键为模型名称，值为该模型的部署名称与 API 版本，未配置 API 版本时使用默认 API 版本，例如：\n${JSON.stringify(AZURE_DEPLOYMENTS_EXAMPLE, null, 2)}`}
+                    name='azure_deployments'
+                    onChange={handleInputChange}
+                    value={inputs.azure_deployments}
+                    style={{ minHeight: 150, fontFamily: 'JetBrains Mono, Consolas' }}
+                    autoComplete='new-password'
+                  />
+                </Form.Field>
               </>
             )
           }
@@ -250,6 +283,20 @@ const EditChannel = () => {
               </Form.Field>
             )
           }
+          {
+            inputs.type === 22 && (
+              <Form.Field>
+                <Form.Input
+                  label='Base URL'
+                  name='base_url'
+                  placeholder={'请输入本地服务的 Base URL（不含 /v1），例如：http://localhost:11434（Ollama）、http://localhost:8000（vLLM）或 http://localhost:1234（LM Studio）'}
+                  onChange={handleInputChange}
+                  value={inputs.base_url}
+                  autoComplete='new-password'
+                />
+              </Form.Field>
+            )
+          }
           {
             inputs.type === 19 && (
               <Form.Field>
@@ -371,8 +418,8 @@ const EditChannel = () => {
               <Form.Input
                 label='密钥'
                 name='key'
-                required
-                placeholder={inputs.type === 15 ? '按照如下格式输入：APIKey|SecretKey' : (inputs.type === 18 ? '按照如下格式输入：APPID|APISecret|APIKey' : '请输入渠道对应的鉴权密钥')}
+                required={inputs.type !== 22}
+                placeholder={inputs.type === 22 ? '此项可选，未启用鉴权的本地服务无需填写' : (inputs.type === 15 ? '按照如下格式输入：APIKey|SecretKey' : (inputs.type === 18 ? '按照如下格式输入：APPID|APISecret|APIKey' : (inputs.type === 21 ? '按照如下格式输入：AccessKeyId|SecretAccessKey|Region' : '请输入渠道对应的鉴权密钥')))}
                 onChange={handleInputChange}
                 value={inputs.key}
                 autoComplete='new-password'
@@ -390,7 +437,7 @@ const EditChannel = () => {
             )
           }
           {
-            inputs.type !== 3 && inputs.type !== 8 && (
+            inputs.type !== 3 && inputs.type !== 8 && inputs.type !== 22 && (
               <Form.Field>
                 <Form.Input
                   label='代理'